@@ -0,0 +1,169 @@
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"gorm.io/gorm"
+)
+
+// ErrNotFound is returned by a Store for unknown keys.
+var ErrNotFound = errors.New("API key not found")
+
+// Key is a stored API key. The key itself is stored only as a hash.
+type Key struct {
+	ID          int64      `gorm:"primaryKey;autoIncrement"`
+	Hash        string     `gorm:"size:64;uniqueIndex;not null"`
+	Hint        string     `gorm:"size:32"` // Prefix, environment and first characters, for display
+	Name        string     `gorm:"size:255"`
+	UserID      int64      `gorm:"index;not null"`
+	Environment string     `gorm:"size:32;not null"`
+	Abilities   string     `gorm:"type:text"` // Comma separated, as for tokens
+	ExpiresAt   *time.Time `gorm:"index"`
+	LastUsedAt  *time.Time
+	RevokedAt   *time.Time
+	CreatedAt   time.Time
+}
+
+// TableName keeps API keys apart from personal access tokens.
+func (Key) TableName() string {
+	return "api_keys"
+}
+
+// Can reports whether the key grants ability, with the same wildcard rules
+// as tokens.
+func (k *Key) Can(ability string) bool {
+	return (&entity.PersonalAccessToken{Abilities: k.Abilities}).Can(ability)
+}
+
+// Hash hashes a key for storage.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Store persists API keys.
+type Store interface {
+	SaveKey(ctx context.Context, k *Key) error
+	FindKey(ctx context.Context, hash string) (*Key, error)
+	RevokeKey(ctx context.Context, hash string, at time.Time) error
+	TouchKey(ctx context.Context, id int64, at time.Time) error
+}
+
+// MemoryStore keeps keys in memory (for testing).
+type MemoryStore struct {
+	mu     sync.RWMutex
+	keys   map[string]Key
+	nextID int64
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]Key)}
+}
+
+func (s *MemoryStore) SaveKey(_ context.Context, k *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k.ID == 0 {
+		s.nextID++
+		k.ID = s.nextID
+	}
+	s.keys[k.Hash] = *k
+	return nil
+}
+
+func (s *MemoryStore) FindKey(_ context.Context, hash string) (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	k, ok := s.keys[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &k, nil
+}
+
+func (s *MemoryStore) RevokeKey(_ context.Context, hash string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.keys[hash]
+	if !ok {
+		return ErrNotFound
+	}
+	k.RevokedAt = &at
+	s.keys[hash] = k
+	return nil
+}
+
+func (s *MemoryStore) TouchKey(_ context.Context, id int64, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, k := range s.keys {
+		if k.ID == id {
+			k.LastUsedAt = &at
+			s.keys[hash] = k
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// GormStore keeps keys in the api_keys table (db.AutoMigrate(&apikey.Key{})).
+type GormStore struct {
+	db *gorm.DB
+}
+
+var _ Store = (*GormStore)(nil)
+
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+func (s *GormStore) SaveKey(ctx context.Context, k *Key) error {
+	return s.db.WithContext(ctx).Save(k).Error
+}
+
+func (s *GormStore) FindKey(ctx context.Context, hash string) (*Key, error) {
+	var k Key
+	err := s.db.WithContext(ctx).First(&k, "hash = ?", hash).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *GormStore) RevokeKey(ctx context.Context, hash string, at time.Time) error {
+	res := s.db.WithContext(ctx).Model(&Key{}).
+		Where("hash = ? AND revoked_at IS NULL", hash).
+		Update("revoked_at", at)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		var n int64
+		if err := s.db.WithContext(ctx).Model(&Key{}).Where("hash = ?", hash).Count(&n).Error; err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+	}
+	return nil
+}
+
+func (s *GormStore) TouchKey(ctx context.Context, id int64, at time.Time) error {
+	return s.db.WithContext(ctx).Model(&Key{}).Where("id = ?", id).Update("last_used_at", at).Error
+}
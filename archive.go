@@ -0,0 +1,108 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/storage"
+	"gorm.io/gorm"
+)
+
+// Archive is cold storage for inactive tokens (see WithArchive). Implement
+// it to archive to object storage such as S3.
+type Archive = storage.Archive
+
+// ArchivedToken is a row of the table used by NewTableArchive
+type ArchivedToken = storage.ArchivedToken
+
+// JSONLArchive archives tokens to an append-only JSON Lines file
+type JSONLArchive = storage.JSONLArchive
+
+// NewTableArchive archives tokens to the personal_access_tokens_archive
+// table of db (db.AutoMigrate(&goauth.ArchivedToken{}))
+func NewTableArchive(db *gorm.DB) Archive {
+	return storage.NewTableArchive(db)
+}
+
+// OpenJSONLArchive opens or creates a JSON Lines archive file at path
+func OpenJSONLArchive(path string) (*JSONLArchive, error) {
+	return storage.OpenJSONLArchive(path)
+}
+
+// archiveOptions holds the archive configured by WithArchive
+type archiveOptions struct {
+	archive     Archive
+	inactiveFor time.Duration
+	interval    time.Duration
+	driver      *storage.ArchivingDriver
+}
+
+// WithArchive moves tokens unused for inactiveFor from storage to archive
+// every interval (0 = only when ArchiveInactive is called), keeping the hot
+// table small. An archived token is moved back transparently the first time
+// it is presented, so clients never notice. Archiving needs a driver that
+// can enumerate its tokens; tokens in named slots or refresh families stay
+// in storage, and archived tokens are not listed by ListTokens.
+func WithArchive(archive Archive, inactiveFor, interval time.Duration) Option {
+	return func(c *Client) error {
+		if archive == nil {
+			return fmt.Errorf("archive cannot be nil")
+		}
+		if inactiveFor <= 0 || interval < 0 {
+			return fmt.Errorf("archive needs a positive inactivity period and a non-negative interval")
+		}
+		c.archive = &archiveOptions{archive: archive, inactiveFor: inactiveFor, interval: interval}
+		return nil
+	}
+}
+
+func (c *Client) wrapArchive() {
+	if c.archive == nil {
+		return
+	}
+	c.archive.driver = storage.NewArchivingDriver(c.storage, c.archive.archive)
+	c.storage = c.archive.driver
+}
+
+// ArchiveInactive moves tokens unused for the period configured with
+// WithArchive to the archive and returns the number moved
+func (c *Client) ArchiveInactive(ctx context.Context) (int64, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if c.archive == nil {
+		return 0, fmt.Errorf("no archive configured (call WithArchive)")
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	n, err := c.archive.driver.ArchiveInactive(c.config.Now().Add(-c.archive.inactiveFor))
+	if err != nil {
+		return n, fmt.Errorf("failed to archive inactive tokens: %w", err)
+	}
+	return n, nil
+}
+
+func (c *Client) startArchiving() {
+	if c.archive == nil || c.archive.interval <= 0 {
+		return
+	}
+
+	c.config.Workers.Every("archive", c.archive.interval, func() error {
+		n, err := c.ArchiveInactive(context.Background())
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			c.config.Logger.Info("archived inactive tokens", "count", n)
+		}
+		return nil
+	})
+}
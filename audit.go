@@ -0,0 +1,161 @@
+package goauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/siem"
+)
+
+const (
+	auditBuffer = 1024        // Events queued for the sink before new ones are dropped
+	auditBatch  = 100         // Events handed to the sink at once
+	auditFlush  = time.Second // Longest an event waits for a batch to fill
+)
+
+// auditSeverity is the 0-10 severity of each event type
+var auditSeverity = map[string]int{
+	siem.TokenCreated:       2,
+	siem.TokenRotated:       2,
+	siem.TokenRevoked:       3,
+	siem.AbilityDenied:      4,
+	siem.UserTokensRevoked:  5,
+	siem.ValidationFailed:   5,
+	siem.RateLimited:        7,
+	siem.RefreshTokenReused: 9,
+	siem.SuspiciousUse:      8,
+	siem.AutomatedResponse:  6,
+	siem.TokenResumed:       3,
+	siem.TokensExpired:      1,
+	siem.LoginSucceeded:     2,
+	siem.LoginFailed:        5,
+	siem.MFAVerified:        2,
+	siem.MFAFailed:          6,
+}
+
+// WithAuditEvents sends security events to sink (see the siem package):
+// tokens created, rotated and revoked, failed and rate limited
+// validations, denied abilities and replayed refresh tokens. Events are
+// queued and written in batches by a background worker so they never slow
+// down validation; when the sink falls behind, new events are dropped and
+// logged. Close writes the events still queued.
+func WithAuditEvents(sink siem.Sink) Option {
+	return func(c *Client) error {
+		if sink == nil {
+			return fmt.Errorf("audit sink cannot be nil")
+		}
+		c.addAuditSink("audit-events", sink)
+		return nil
+	}
+}
+
+type auditLog struct {
+	sinks map[string]siem.Sink // By worker name
+	queue chan siem.Event
+}
+
+// addAuditSink hands every event to sink as well
+func (c *Client) addAuditSink(name string, sink siem.Sink) {
+	if c.events == nil {
+		c.events = &auditLog{sinks: make(map[string]siem.Sink), queue: make(chan siem.Event, auditBuffer)}
+	}
+	c.events.sinks[name] = sink
+}
+
+// audit queues e, filling in what the call site doesn't know
+func (c *Client) audit(ctx context.Context, e siem.Event) {
+	if c.events == nil {
+		return
+	}
+	e.Time = c.config.Now()
+	e.Version = Version()
+	e.Severity = auditSeverity[e.Type]
+	if e.Outcome == "" {
+		e.Outcome = siem.Success
+	}
+	if ip, _ := ctx.Value(clientIPKey{}).(string); ip != "" {
+		e.SourceIP = ip
+	}
+
+	select {
+	case c.events.queue <- e:
+	default:
+		c.config.Logger.Warn("audit event dropped", "type", e.Type)
+	}
+}
+
+// auditToken queues a successful event about the token raw
+func (c *Client) auditToken(ctx context.Context, typ string, userID int64, raw string) {
+	loc, _, _ := cutToken(raw)
+	c.audit(ctx, siem.Event{Type: typ, UserID: userID, Locator: loc})
+}
+
+// auditFailure queues the event for a failed validation of raw. Errors
+// that say nothing about the token, e.g. storage outages, aren't events.
+func (c *Client) auditFailure(ctx context.Context, raw string, err error) {
+	e := siem.Event{Outcome: siem.Failure, Reason: err.Error()}
+	switch {
+	case errors.Is(err, utils.ErrRateLimited):
+		e.Type = siem.RateLimited
+	case errors.Is(err, utils.ErrRefreshTokenReused):
+		e.Type = siem.RefreshTokenReused
+	case errors.Is(err, utils.ErrTokenInvalid):
+		e.Type = siem.ValidationFailed
+	default:
+		return
+	}
+	e.Locator, _, _ = cutToken(raw)
+	c.audit(ctx, e)
+}
+
+func (c *Client) startAudit() {
+	if c.events == nil {
+		return
+	}
+
+	workers := c.config.Workers
+	workers.Go("audit-events", func() error {
+		ticker := time.NewTicker(auditFlush)
+		defer ticker.Stop()
+
+		var batch []siem.Event
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			events := batch
+			batch = nil
+			for name, sink := range c.events.sinks {
+				workers.Run(name, func() error {
+					ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+					defer cancel()
+					return sink.Write(ctx, events)
+				})
+			}
+		}
+
+		for {
+			select {
+			case e := <-c.events.queue:
+				if batch = append(batch, e); len(batch) >= auditBatch {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			case <-workers.Done():
+				for {
+					select {
+					case e := <-c.events.queue:
+						batch = append(batch, e)
+					default:
+						flush()
+						return nil
+					}
+				}
+			}
+		}
+	})
+}
@@ -1,13 +1,78 @@
 package auth_test
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/beevik/etree"
 	"github.com/mohar9h/goauth"
+	"github.com/mohar9h/goauth/apikey"
+	"github.com/mohar9h/goauth/clientsdk"
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/goauthtest"
+	"github.com/mohar9h/goauth/importer"
+	"github.com/mohar9h/goauth/license"
+	"github.com/mohar9h/goauth/metering"
+	"github.com/mohar9h/goauth/mfa"
+	"github.com/mohar9h/goauth/middleware"
+	"github.com/mohar9h/goauth/oauth2"
+	"github.com/mohar9h/goauth/oidc"
+	"github.com/mohar9h/goauth/password"
+	"github.com/mohar9h/goauth/phc"
+	"github.com/mohar9h/goauth/policy"
+	"github.com/mohar9h/goauth/ratelimit"
+	"github.com/mohar9h/goauth/saml"
+	"github.com/mohar9h/goauth/siem"
+	_ "github.com/mohar9h/goauth/sqlitestore"
+	"github.com/mohar9h/goauth/tokenformat"
+	"github.com/mohar9h/goauth/verifier"
+	"github.com/mohar9h/goauth/webhook"
+	dsig "github.com/russellhaering/goxmldsig"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 func TestNewClient(t *testing.T) {
@@ -18,14 +83,7 @@ func TestNewClient(t *testing.T) {
 	}{
 		{
 			name:    "default client",
-			opts:    []goauth.Option{},
-			wantErr: false,
-		},
-		{
-			name: "client with signing key",
-			opts: []goauth.Option{
-				goauth.WithSigningKey("test-key-123"),
-			},
+			opts:    []goauth.Option{goauth.WithMemoryStorage()},
 			wantErr: false,
 		},
 		{
@@ -41,6 +99,7 @@ func TestNewClient(t *testing.T) {
 			opts: []goauth.Option{
 				goauth.WithSigningKey("test-key-123"),
 				goauth.WithTokenLength(64),
+				goauth.WithMemoryStorage(),
 			},
 			wantErr: false,
 		},
@@ -49,9 +108,17 @@ func TestNewClient(t *testing.T) {
 			opts: []goauth.Option{
 				goauth.WithSigningKey("test-key-123"),
 				goauth.WithTokenExpiration(1 * time.Hour),
+				goauth.WithMemoryStorage(),
 			},
 			wantErr: false,
 		},
+		{
+			name: "signing key without storage",
+			opts: []goauth.Option{
+				goauth.WithSigningKey("test-key-123"),
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid token length",
 			opts: []goauth.Option{
@@ -269,10 +336,12 @@ func TestGetTokenInfo(t *testing.T) {
 }
 
 func TestTokenExpiration(t *testing.T) {
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
 	client, err := goauth.NewClient(
 		goauth.WithSigningKey("test-key-123"),
 		goauth.WithMemoryStorage(),
-		goauth.WithTokenExpiration(1*time.Millisecond), // Very short expiration
+		goauth.WithClock(clock),
+		goauth.WithTokenExpiration(time.Hour),
 	)
 	require.NoError(t, err)
 
@@ -287,14 +356,182 @@ func TestTokenExpiration(t *testing.T) {
 	_, err = client.ValidateToken(context.Background(), token)
 	require.NoError(t, err)
 
-	// Wait for expiration
-	time.Sleep(10 * time.Millisecond)
+	// Still valid up to the expiry
+	clock.Advance(time.Hour)
+	_, err = client.ValidateToken(context.Background(), token)
+	require.NoError(t, err)
+
+	clock.Advance(time.Second)
 
 	// Token should be expired
 	_, err = client.ValidateToken(context.Background(), token)
 	assert.Error(t, err)
 }
 
+func TestPruneExpired(t *testing.T) {
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	client, err := goauth.NewClient(
+		goauth.WithSigningKey("test-key-123"),
+		goauth.WithMemoryStorage(),
+		goauth.WithClock(clock),
+		goauth.WithTokenExpiration(time.Hour),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.CreateToken(context.Background(), &goauth.TokenOptions{UserId: 123})
+		require.NoError(t, err)
+	}
+
+	n, err := client.PruneExpired(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, n, "nothing has expired yet")
+
+	clock.Advance(time.Hour + time.Second)
+
+	n, err = client.PruneExpired(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	_, total, err := client.ListTokens(context.Background(), 123, nil)
+	require.NoError(t, err)
+	assert.Zero(t, total)
+}
+
+func TestAPIVersionBinding(t *testing.T) {
+	client, err := goauth.NewClient(
+		goauth.WithSigningKey("test-key-123"),
+		goauth.WithMemoryStorage(),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	token, err := client.CreateToken(ctx, &goauth.TokenOptions{
+		UserId:      123,
+		APIVersions: &goauth.VersionRange{Min: "1", Max: "2.5"},
+		Features:    []string{"beta-search"},
+	})
+	require.NoError(t, err)
+
+	_, err = client.ValidateTokenForAPI(ctx, token, "2.1", "beta-search")
+	require.NoError(t, err)
+
+	_, err = client.ValidateTokenForAPI(ctx, token, "3")
+	assert.ErrorIs(t, err, goauth.ErrAPIVersionNotAllowed)
+
+	_, err = client.ValidateTokenForAPI(ctx, token, "2", "new-checkout")
+	assert.ErrorIs(t, err, goauth.ErrFeatureNotAllowed)
+
+	// Raising the minimum supported version fences the token off entirely
+	fenced, err := goauth.NewClient(
+		goauth.WithSigningKey("test-key-123"),
+		goauth.WithStorage(client.Storage()),
+		goauth.WithMinAPIVersion("3"),
+	)
+	require.NoError(t, err)
+	_, err = fenced.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, goauth.ErrAPIVersionNotAllowed)
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	client, err := goauth.NewClient(
+		goauth.WithSigningKey("test-key-123"),
+		goauth.WithMemoryStorage(),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	user, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Abilities: []string{"read:posts"}})
+	require.NoError(t, err)
+	ops, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 2, Abilities: []string{"ops:maintenance"}})
+	require.NoError(t, err)
+
+	client.SetMaintenanceMode(true, []string{"ops:maintenance"})
+
+	_, err = client.ValidateToken(ctx, user)
+	assert.ErrorIs(t, err, goauth.ErrMaintenanceMode)
+	_, err = client.ValidateToken(ctx, ops)
+	require.NoError(t, err)
+
+	// Revocation isn't gated, so tokens can be killed mid-incident
+	require.NoError(t, client.RevokeToken(ctx, user))
+
+	client.SetMaintenanceMode(false, nil)
+
+	_, err = client.ValidateToken(ctx, ops)
+	require.NoError(t, err)
+	_, err = client.ValidateToken(ctx, user)
+	assert.Error(t, err)
+}
+
+func TestMaintenanceModeMatcher(t *testing.T) {
+	ctx := context.Background()
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	client, err := goauth.NewClient(
+		goauth.WithMemoryStorage(),
+		goauth.WithClock(clock),
+		goauth.WithAbilityMatcher(&goauth.TreeMatcher{Implies: map[string][]string{"sre": {"ops:*"}}}),
+		goauth.WithSlidingExpiration(time.Hour),
+		goauth.WithSoftRevocation(),
+		goauth.WithLastUsedTracking(goauth.LastUsedSync),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	sre, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Abilities: []string{"sre"}})
+	require.NoError(t, err)
+	user, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 2, Abilities: []string{"read"}})
+	require.NoError(t, err)
+
+	// The allowlist is matched with the configured matcher
+	client.SetMaintenanceMode(true, []string{"ops:maintenance"})
+	_, err = client.ValidateToken(ctx, sre)
+	require.NoError(t, err)
+	_, err = client.ValidateToken(ctx, user)
+	assert.ErrorIs(t, err, goauth.ErrMaintenanceMode)
+
+	// Revoking neither records a use nor extends the sliding expiry
+	before, _, err := client.ListTokens(ctx, 2, nil)
+	require.NoError(t, err)
+	require.Len(t, before, 1)
+	clock.Advance(30 * time.Minute)
+	require.NoError(t, client.RevokeToken(ctx, user))
+
+	after, _, err := client.ListTokens(ctx, 2, &goauth.ListOptions{Status: goauth.StatusRevoked})
+	require.NoError(t, err)
+	require.Len(t, after, 1)
+	assert.NotNil(t, after[0].RevokedAt)
+	assert.Nil(t, after[0].LastUsedAt)
+	assert.Equal(t, before[0].ExpiresAt, after[0].ExpiresAt)
+}
+
+func TestExperimentRouting(t *testing.T) {
+	client, err := goauth.NewClient(
+		goauth.WithSigningKey("test-key-123"),
+		goauth.WithMemoryStorage(),
+		goauth.WithExperiment("long-tokens", 100, goauth.WithTokenLength(64)),
+	)
+	require.NoError(t, err)
+
+	token, err := client.CreateToken(context.Background(), &goauth.TokenOptions{UserId: 123})
+	require.NoError(t, err)
+
+	_, secret, _ := strings.Cut(token, "|")
+	assert.Greater(t, len(secret), 64*2) // hex-encoded bytes plus CRC32 suffix
+
+	info, err := client.ValidateToken(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, "long-tokens", goauth.ExperimentOf(info))
+
+	_, err = goauth.NewClient(
+		goauth.WithMemoryStorage(),
+		goauth.WithExperiment("a", 60),
+		goauth.WithExperiment("b", 50),
+	)
+	assert.Error(t, err)
+}
+
 func TestContextCancellation(t *testing.T) {
 	client, err := goauth.NewClient(
 		goauth.WithSigningKey("test-key-123"),
@@ -315,7 +552,5630 @@ func TestContextCancellation(t *testing.T) {
 	assert.Equal(t, context.Canceled, err)
 }
 
+func TestListTokens(t *testing.T) {
+	client, err := goauth.NewClient(
+		goauth.WithSigningKey("test-key-123"),
+		goauth.WithMemoryStorage(),
+	)
+	require.NoError(t, err)
+
+	var tokens []string
+	for i := 0; i < 3; i++ {
+		token, err := client.CreateToken(context.Background(), &goauth.TokenOptions{
+			UserId:    123,
+			Abilities: []string{"read:posts"},
+		})
+		require.NoError(t, err)
+		tokens = append(tokens, token)
+	}
+	_, err = client.CreateToken(context.Background(), &goauth.TokenOptions{UserId: 456})
+	require.NoError(t, err)
+
+	list, total, err := client.ListTokens(context.Background(), 123, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Len(t, list, 3)
+
+	page, total, err := client.ListTokens(context.Background(), 123, &goauth.ListOptions{Limit: 2, Offset: 2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Len(t, page, 1)
+	assert.Equal(t, list[2].ID, page[0].ID)
+
+	require.NoError(t, client.RevokeToken(context.Background(), tokens[0]))
+
+	active, total, err := client.ListTokens(context.Background(), 123, &goauth.ListOptions{Status: goauth.StatusActive})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Len(t, active, 2)
+
+	// Revocation deletes the row unless soft revocation keeps it
+	_, total, err = client.ListTokens(context.Background(), 123, &goauth.ListOptions{Status: goauth.StatusRevoked})
+	require.NoError(t, err)
+	assert.Zero(t, total)
+
+	_, _, err = client.ListTokens(context.Background(), 0, nil)
+	assert.Error(t, err)
+}
+
+func TestListTokensByStatus(t *testing.T) {
+	ctx := context.Background()
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithClock(clock), goauth.WithSoftRevocation())
+	require.NoError(t, err)
+	defer client.Close()
+
+	ids := make(map[string]int64)
+	for _, name := range []string{"active", "expired", "revoked"} {
+		opts := &goauth.TokenOptions{UserId: 1, Name: stringPtr(name)}
+		if name == "expired" {
+			opts.ExpiresIn = time.Hour
+		}
+		raw, err := client.CreateToken(ctx, opts)
+		require.NoError(t, err)
+		tok, err := client.GetTokenInfo(ctx, raw)
+		require.NoError(t, err)
+		ids[name] = tok.ID
+		if name == "revoked" {
+			require.NoError(t, client.RevokeToken(ctx, raw))
+		}
+	}
+	clock.Advance(2 * time.Hour)
+
+	for status, want := range map[goauth.TokenStatus][]int64{
+		goauth.StatusAll:     {ids["active"], ids["expired"], ids["revoked"]},
+		goauth.StatusActive:  {ids["active"]},
+		goauth.StatusExpired: {ids["expired"]},
+		goauth.StatusRevoked: {ids["revoked"]},
+	} {
+		list, total, err := client.ListTokens(ctx, 1, &goauth.ListOptions{Status: status})
+		require.NoError(t, err)
+		assert.EqualValues(t, len(want), total, status)
+		var got []int64
+		for _, tok := range list {
+			got = append(got, tok.ID)
+		}
+		assert.ElementsMatch(t, want, got, status)
+	}
+}
+
+func TestLocators(t *testing.T) {
+	tests := []struct {
+		name    string
+		locator goauth.Locator
+		length  int
+	}{
+		{name: "numeric id", locator: goauth.IDLocator(), length: 1},
+		{name: "hash prefix", locator: goauth.HashPrefixLocator(12), length: 12},
+		{name: "ulid", locator: goauth.ULIDLocator(), length: 26},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := goauth.NewClient(
+				goauth.WithSigningKey("test-key-123"),
+				goauth.WithMemoryStorage(),
+				goauth.WithLocator(tt.locator),
+			)
+			require.NoError(t, err)
+
+			token, err := client.CreateToken(context.Background(), &goauth.TokenOptions{UserId: 123})
+			require.NoError(t, err)
+
+			loc, secret, ok := strings.Cut(token, "|")
+			require.True(t, ok)
+			assert.Len(t, loc, tt.length)
+
+			_, err = client.ValidateToken(context.Background(), token)
+			require.NoError(t, err)
+
+			_, err = client.ValidateToken(context.Background(), "X"+loc+"|"+secret)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestTokenAbilities(t *testing.T) {
+	tests := []struct {
+		name      string
+		abilities string
+		check     string
+		want      bool
+	}{
+		{name: "exact match", abilities: "read:posts,write:comments", check: "write:comments", want: true},
+		{name: "missing ability", abilities: "read:posts", check: "write:posts", want: false},
+		{name: "global wildcard", abilities: "*", check: "delete:users", want: true},
+		{name: "prefix wildcard", abilities: "read:*", check: "read:comments", want: true},
+		{name: "prefix wildcard mismatch", abilities: "read:*", check: "write:comments", want: false},
+		{name: "empty abilities", abilities: "", check: "read:posts", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok := &goauth.PersonalAccessToken{Abilities: tt.abilities}
+			assert.Equal(t, tt.want, tok.Can(tt.check))
+			assert.Equal(t, !tt.want, tok.Cant(tt.check))
+		})
+	}
+}
+
+func TestAbilityList(t *testing.T) {
+	tok := &goauth.PersonalAccessToken{}
+	assert.Nil(t, tok.AbilityList())
+
+	tok.Abilities = `read:posts, ,write:posts when resource.tags in ["a", "b"],*`
+	list := tok.AbilityList()
+	assert.Equal(t, []string{"read:posts", `write:posts when resource.tags in ["a", "b"]`, "*"}, list)
+	list[0] = "changed"
+	assert.Equal(t, "read:posts", tok.AbilityList()[0], "the slice is a copy")
+
+	tok.SetAbilities([]string{"read:posts", "write:comments"})
+	assert.Equal(t, "read:posts,write:comments", tok.Abilities)
+	assert.True(t, tok.Can("write:comments"))
+
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+	raw, err := client.CreateToken(context.Background(), &goauth.TokenOptions{UserId: 1, Abilities: []string{"read:posts", "write:posts"}})
+	require.NoError(t, err)
+	valid, err := client.ValidateToken(context.Background(), raw)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"read:posts", "write:posts"}, valid.AbilityList())
+}
+
+func TestValidateTokenWithAbility(t *testing.T) {
+	client, err := goauth.NewClient(
+		goauth.WithSigningKey("test-key-123"),
+		goauth.WithMemoryStorage(),
+	)
+	require.NoError(t, err)
+
+	token, err := client.CreateToken(context.Background(), &goauth.TokenOptions{
+		UserId:    123,
+		Abilities: []string{"read:*"},
+	})
+	require.NoError(t, err)
+
+	_, err = client.ValidateTokenWithAbility(context.Background(), token, "read:posts")
+	require.NoError(t, err)
+
+	_, err = client.ValidateTokenWithAbility(context.Background(), token, "write:posts")
+	assert.ErrorIs(t, err, goauth.ErrAbilityDenied)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	client, err := goauth.NewClient(
+		goauth.WithSigningKey("test-key-123"),
+		goauth.WithMemoryStorage(),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	pair, err := client.CreateTokenPair(ctx, &goauth.TokenOptions{
+		UserId:    123,
+		Abilities: []string{"read:posts"},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, pair.RefreshExpiresAt)
+
+	_, err = client.ValidateToken(ctx, pair.AccessToken)
+	require.NoError(t, err)
+
+	// Refresh tokens can't be used as access tokens
+	_, err = client.ValidateToken(ctx, pair.RefreshToken)
+	assert.Error(t, err)
+
+	rotated, err := client.RefreshToken(ctx, pair.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEqual(t, pair.RefreshToken, rotated.RefreshToken)
+
+	info, err := client.ValidateToken(ctx, rotated.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "read:posts", info.Abilities)
+
+	// Replaying the rotated refresh token revokes the whole family
+	_, err = client.RefreshToken(ctx, pair.RefreshToken)
+	assert.ErrorIs(t, err, goauth.ErrRefreshTokenReused)
+
+	_, err = client.ValidateToken(ctx, rotated.AccessToken)
+	assert.Error(t, err)
+	_, err = client.RefreshToken(ctx, rotated.RefreshToken)
+	assert.ErrorIs(t, err, goauth.ErrRefreshTokenReused)
+}
+
+func TestSanctumCompatibility(t *testing.T) {
+	client, err := goauth.NewClient(
+		goauth.WithSigningKey("test-key-123"),
+		goauth.WithMemoryStorage(),
+		goauth.WithSanctumCompatibility(),
+	)
+	require.NoError(t, err)
+
+	secret := "sanctumPlainTextToken0123456789abcdefghij"
+	sum := sha256.Sum256([]byte(secret))
+	record := &goauth.PersonalAccessToken{
+		UserId:    42,
+		Token:     hex.EncodeToString(sum[:]),
+		Abilities: `["read:posts","write:posts"]`,
+		CreatedAt: time.Now(),
+	}
+	require.NoError(t, client.Storage().StoreToken(record))
+
+	// Bare Sanctum token without an ID segment
+	info, err := client.ValidateToken(context.Background(), secret)
+	require.NoError(t, err)
+	assert.Equal(t, "read:posts,write:posts", info.Abilities)
+
+	// Record was rewritten to the canonical format
+	stored, err := client.Storage().FindByID(record.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "read:posts,write:posts", stored.Abilities)
+
+	_, err = client.ValidateToken(context.Background(), fmt.Sprintf("%d|%s", record.ID, secret))
+	require.NoError(t, err)
+}
+
+func TestGuestTokens(t *testing.T) {
+	client, err := goauth.NewClient(
+		goauth.WithSigningKey("test-key-123"),
+		goauth.WithMemoryStorage(),
+		goauth.WithGuestAbilities("cart:read", "cart:write"),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	token, err := client.CreateGuestToken(ctx, nil)
+	require.NoError(t, err)
+
+	info, err := client.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), info.UserId)
+	assert.True(t, info.Can("cart:write"))
+	assert.False(t, info.Can("orders:read"))
+
+	promoted, err := client.PromoteGuestToken(ctx, token, 77)
+	require.NoError(t, err)
+	assert.Equal(t, int64(77), promoted.UserId)
+
+	info, err = client.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(77), info.UserId)
+
+	// Already promoted
+	_, err = client.PromoteGuestToken(ctx, token, 78)
+	assert.Error(t, err)
+}
+
 // Helper function to create string pointers
 func stringPtr(s string) *string {
 	return &s
 }
+
+func TestSQLiteConcurrency(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(goauth.SQLiteDSN(filepath.Join(t.TempDir(), "tokens.db"), goauth.SQLiteOptions{})), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	client, err := goauth.NewClient(goauth.WithSQLiteStorage(db, goauth.SQLiteOptions{}))
+	require.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, "sqlite", client.DriverStats()[0].Driver)
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, 16*20)
+
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				token, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: int64(w + 1)})
+				if err != nil {
+					errs <- err
+					return
+				}
+				if _, err := client.ValidateToken(ctx, token); err != nil {
+					errs <- err
+					return
+				}
+				if i%2 == 0 {
+					if err := client.RevokeToken(ctx, token); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent operation failed: %v", err)
+	}
+
+	tokens, total, err := client.ListTokens(ctx, 1, &goauth.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), total)
+	assert.Len(t, tokens, 10)
+}
+
+func TestReplaceNamedToken(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "tokens.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	drivers := map[string]goauth.Option{
+		"memory": goauth.WithMemoryStorage(),
+		"gorm":   goauth.WithGormStorage(db),
+	}
+
+	for name, opt := range drivers {
+		t.Run(name, func(t *testing.T) {
+			client, err := goauth.NewClient(opt)
+			require.NoError(t, err)
+			defer client.Close()
+
+			ctx := context.Background()
+			ci := "ci"
+			opts := &goauth.TokenOptions{UserId: 7, Name: &ci, Replace: true}
+
+			first, err := client.CreateToken(ctx, opts)
+			require.NoError(t, err)
+			second, err := client.CreateToken(ctx, opts)
+			require.NoError(t, err)
+
+			_, err = client.ValidateToken(ctx, first)
+			assert.Error(t, err)
+			tok, err := client.ValidateToken(ctx, second)
+			require.NoError(t, err)
+			assert.Equal(t, strings.SplitN(first, "|", 2)[0], strings.SplitN(second, "|", 2)[0], "replacement keeps the token ID")
+
+			_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 7, Name: &ci})
+			require.NoError(t, err, "tokens created without Replace don't conflict")
+
+			_, total, err := client.ListTokens(ctx, 7, &goauth.ListOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), total)
+			assert.Equal(t, int64(7), tok.UserId)
+		})
+	}
+}
+
+func TestIndexVerification(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "tokens.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	_, err = goauth.NewClient(goauth.WithGormStorage(db), goauth.WithIndexVerification(true))
+	require.NoError(t, err)
+
+	require.NoError(t, db.Migrator().DropIndex(&goauth.PersonalAccessToken{}, "idx_pat_token"))
+
+	_, err = goauth.NewClient(goauth.WithGormStorage(db), goauth.WithIndexVerification(true))
+	assert.ErrorIs(t, err, goauth.ErrMissingIndex)
+
+	_, err = goauth.NewClient(goauth.WithGormStorage(db), goauth.WithIndexVerification(false))
+	assert.NoError(t, err, "non-strict verification only warns")
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithIndexVerification(true))
+	assert.NoError(t, err)
+}
+
+func TestTokenSet(t *testing.T) {
+	ctx := context.Background()
+	primary, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer primary.Close()
+
+	kept, err := primary.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Abilities: []string{"read"}})
+	require.NoError(t, err)
+	revoked, err := primary.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	require.NoError(t, primary.RevokeToken(ctx, revoked))
+
+	path := filepath.Join(t.TempDir(), "tokens.gats")
+	n, err := primary.ExportTokenSetFile(ctx, path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	edge, err := goauth.NewClient(goauth.WithTokenSetFile(path, 0))
+	require.NoError(t, err)
+	defer edge.Close()
+
+	tok, err := edge.ValidateToken(ctx, kept)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), tok.UserId)
+	assert.True(t, tok.Can("read"))
+
+	_, err = edge.ValidateToken(ctx, revoked)
+	assert.Error(t, err)
+
+	_, err = edge.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	assert.ErrorIs(t, err, goauth.ErrReadOnlyStorage)
+
+	set, err := goauth.OpenTokenSet(path)
+	require.NoError(t, err)
+	defer set.Close()
+
+	sum := sha256.Sum256([]byte(strings.SplitN(kept, "|", 2)[1]))
+	hash := hex.EncodeToString(sum[:])
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, ok := set.Lookup(hash); !ok {
+			t.Fatal("token missing from set")
+		}
+	})
+	assert.Zero(t, allocs)
+
+	// Materializing a record from the edge driver costs only the record
+	driver := edge.Storage()
+	allocs = testing.AllocsPerRun(100, func() {
+		if _, err := driver.FindByHash(hash); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.LessOrEqual(t, allocs, 1.0)
+	found, err := driver.FindByHash(hash)
+	require.NoError(t, err)
+	assert.Equal(t, "read", found.Abilities)
+
+	// Peppered hashes can't be indexed, so the export fails rather than
+	// leaving those tokens out
+	peppered, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithHashPepper([]byte("0123456789abcdef-pepper")))
+	require.NoError(t, err)
+	defer peppered.Close()
+	_, err = peppered.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	_, err = peppered.ExportTokenSet(ctx, io.Discard)
+	assert.ErrorIs(t, err, goauth.ErrUnindexedHash)
+}
+
+func TestRateLimiting(t *testing.T) {
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	client, err := goauth.NewClient(
+		goauth.WithMemoryStorage(),
+		goauth.WithClock(clock),
+		goauth.WithRateLimiter(ratelimit.NewMemory(3, time.Hour)),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx := goauth.ContextWithClientIP(context.Background(), "203.0.113.7")
+	token, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+
+	id := strings.SplitN(token, "|", 2)[0]
+	for i := 0; i < 3; i++ {
+		_, err := client.ValidateToken(context.Background(), id+"|wrong-secret")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, goauth.ErrRateLimited)
+	}
+
+	_, err = client.ValidateToken(context.Background(), token)
+	assert.ErrorIs(t, err, goauth.ErrRateLimited, "token ID is locked after repeated failures")
+
+	// One attempt is restored per refill interval of the client's clock
+	clock.Advance(59 * time.Minute)
+	_, err = client.ValidateToken(context.Background(), token)
+	assert.ErrorIs(t, err, goauth.ErrRateLimited)
+	clock.Advance(time.Minute)
+	_, err = client.ValidateToken(context.Background(), token)
+	require.NoError(t, err)
+
+	other, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 2})
+	require.NoError(t, err)
+	_, err = client.ValidateToken(ctx, other)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, _ = client.ValidateToken(ctx, fmt.Sprintf("%d|guess", 1000+i))
+	}
+	_, err = client.ValidateToken(ctx, other)
+	assert.ErrorIs(t, err, goauth.ErrRateLimited, "client IP is locked after repeated failures")
+}
+
+func TestRateLimitingWithoutClientIP(t *testing.T) {
+	ctx := context.Background()
+	var logs bytes.Buffer
+	client, err := goauth.NewClient(
+		goauth.WithMemoryStorage(),
+		goauth.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		goauth.WithRateLimiter(ratelimit.NewMemory(3, time.Hour)),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	token, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+
+	// Rotating the caller-chosen token ID doesn't escape the limit: attempts
+	// without a client IP share one budget
+	for i := 0; i < 3; i++ {
+		_, err := client.ValidateToken(ctx, fmt.Sprintf("%d|guess", 1000+i))
+		assert.NotErrorIs(t, err, goauth.ErrRateLimited)
+	}
+	_, err = client.ValidateToken(ctx, fmt.Sprintf("%d|guess", 2000))
+	assert.ErrorIs(t, err, goauth.ErrRateLimited)
+	_, err = client.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, goauth.ErrRateLimited)
+	assert.Equal(t, 1, strings.Count(logs.String(), "without a client IP"), "warned once")
+
+	// Callers passing their IP keep their own budget
+	_, err = client.ValidateToken(goauth.ContextWithClientIP(ctx, "203.0.113.7"), token)
+	require.NoError(t, err)
+}
+
+func TestImportExternalToken(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+
+	licence := "K7QF-X2M9-PL4W-RZ8T-B3NV-H6JD"
+	token, err := client.ImportExternalToken(ctx, licence, &goauth.ImportOptions{
+		TokenOptions: goauth.TokenOptions{UserId: 5, Abilities: []string{"license:use"}},
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(token, "|"+licence))
+
+	tok, err := client.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), tok.UserId)
+
+	_, err = client.ImportExternalToken(ctx, licence, &goauth.ImportOptions{
+		TokenOptions: goauth.TokenOptions{UserId: 5},
+	})
+	assert.ErrorIs(t, err, goauth.ErrDuplicateToken)
+
+	for _, weak := range []string{"short", "aaaaaaaaaaaaaaaaaaaaaaaa", "1212121212121212"} {
+		_, err = client.ImportExternalToken(ctx, weak, &goauth.ImportOptions{
+			TokenOptions: goauth.TokenOptions{UserId: 5},
+		})
+		assert.ErrorIs(t, err, goauth.ErrWeakToken, weak)
+	}
+
+	secret := "device-7f3a9c1e5b2d4f6a8c0e"
+	sum := sha256.Sum256([]byte(secret))
+	prefix, err := client.ImportExternalToken(ctx, hex.EncodeToString(sum[:]), &goauth.ImportOptions{
+		TokenOptions: goauth.TokenOptions{UserId: 6},
+		Hashed:       true,
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(prefix, "|"))
+
+	tok, err = client.ValidateToken(ctx, prefix+secret)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), tok.UserId)
+}
+
+func TestClientBinding(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+
+	token, err := client.CreateToken(ctx, &goauth.TokenOptions{
+		UserId:             1,
+		BoundIP:            "10.0.0.0/24",
+		BoundUserAgentHash: goauth.HashUserAgent("device-agent/1.0"),
+	})
+	require.NoError(t, err)
+
+	request := func(addr, ua string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = addr
+		r.Header.Set("User-Agent", ua)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return r
+	}
+
+	_, err = client.ValidateTokenWithRequest(ctx, "", request("10.0.0.8:5123", "device-agent/1.0"))
+	assert.NoError(t, err)
+
+	_, err = client.ValidateTokenWithRequest(ctx, "", request("192.0.2.1:5123", "device-agent/1.0"))
+	assert.ErrorIs(t, err, goauth.ErrClientMismatch)
+
+	_, err = client.ValidateTokenWithRequest(ctx, "", request("10.0.0.8:5123", "curl/8.0"))
+	assert.ErrorIs(t, err, goauth.ErrClientMismatch)
+
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, BoundIP: "not-an-ip"})
+	assert.Error(t, err)
+
+	lenient, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithClientBinding(goauth.ClientBindingLogOnly))
+	require.NoError(t, err)
+	defer lenient.Close()
+
+	token, err = lenient.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, BoundIP: "10.0.0.1"})
+	require.NoError(t, err)
+	_, err = lenient.ValidateTokenWithRequest(ctx, "", request("192.0.2.1:5123", "curl/8.0"))
+	assert.NoError(t, err)
+}
+
+func TestCertificateBinding(t *testing.T) {
+	ctx := context.Background()
+	clientCert := func(cn string) *x509.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		return cert
+	}
+	owner, other := clientCert("payments"), clientCert("payments")
+
+	// Lenient IP binding doesn't extend to certificates
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithClientBinding(goauth.ClientBindingLogOnly),
+		goauth.WithJWTSigningKey(edKey, goauth.JWTOptions{Issuer: "https://auth.example.com"}))
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, BoundCertHash: "not-a-thumbprint"})
+	assert.Error(t, err)
+	token, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, BoundCertHash: goauth.HashCertificate(owner)})
+	require.NoError(t, err)
+
+	request := func(certs ...*x509.Certificate) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "https://api.example.com/", nil)
+		r.TLS.PeerCertificates = certs
+		r.Header.Set("Authorization", "Bearer "+token)
+		return r
+	}
+	_, err = client.ValidateTokenWithRequest(ctx, "", request(owner))
+	assert.NoError(t, err)
+	_, err = client.ValidateTokenWithRequest(ctx, "", request(other))
+	assert.ErrorIs(t, err, goauth.ErrClientMismatch, "same subject, different certificate")
+	_, err = client.ValidateTokenWithRequest(ctx, "", request())
+	assert.ErrorIs(t, err, goauth.ErrClientMismatch)
+	plain := request(owner)
+	plain.TLS = nil
+	_, err = client.ValidateTokenWithRequest(ctx, "", plain)
+	assert.ErrorIs(t, err, goauth.ErrClientMismatch)
+
+	// Refreshed and JWT forms of the token stay bound
+	pair, err := client.CreateTokenPair(ctx, &goauth.TokenOptions{UserId: 1, BoundCertHash: goauth.HashCertificate(owner)})
+	require.NoError(t, err)
+	pair, err = client.RefreshToken(ctx, pair.RefreshToken)
+	require.NoError(t, err)
+	token = pair.AccessToken
+	_, err = client.ValidateTokenWithRequest(ctx, "", request(other))
+	assert.ErrorIs(t, err, goauth.ErrClientMismatch)
+
+	signed, err := client.IssueJWT(ctx, token)
+	require.NoError(t, err)
+	payload, err := base64.RawURLEncoding.DecodeString(strings.Split(signed, ".")[1])
+	require.NoError(t, err)
+	var claims struct {
+		Cnf map[string]string `json:"cnf"`
+	}
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	assert.Equal(t, goauth.HashCertificate(owner), claims.Cnf["x5t#S256"])
+}
+
+func TestLicenseKeys(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	expires := time.Now().Add(365 * 24 * time.Hour)
+	key, err := license.Issue(priv, license.License{Licensee: 42, Product: 3, ExpiresAt: expires})
+	require.NoError(t, err)
+
+	lic, err := license.Verify(pub, strings.ToLower(strings.ReplaceAll(key, "0", "O")))
+	require.NoError(t, err, "verification tolerates case and O/0 confusion")
+	assert.Equal(t, int64(42), lic.Licensee)
+	assert.Equal(t, uint8(3), lic.Product)
+	assert.False(t, lic.Expired(time.Now()))
+
+	typo := []byte(key)
+	typo[1] = map[bool]byte{true: 'B', false: 'A'}[typo[1] == 'A']
+	_, err = license.Verify(pub, string(typo))
+	assert.ErrorIs(t, err, license.ErrChecksum)
+
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, err = license.Verify(otherPub, key)
+	assert.ErrorIs(t, err, license.ErrSignature)
+
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithLicensePublicKey(pub))
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.VerifyLicense(ctx, key)
+	require.NoError(t, err, "unregistered keys verify offline")
+
+	_, err = client.RegisterLicense(ctx, key)
+	require.NoError(t, err)
+	require.NoError(t, client.RevokeLicense(ctx, key))
+
+	_, err = client.VerifyLicense(ctx, key)
+	assert.Error(t, err)
+}
+
+func TestAbilityCatalogHandler(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(),
+		goauth.WithAbility("posts:read", "Read published posts"),
+		goauth.WithAbility("posts:write", "Create and edit posts"),
+		goauth.WithTokenTemplate(goauth.TokenTemplate{
+			Name:      "ci-deploy",
+			Abilities: []string{"deploy"},
+			ExpiresIn: time.Hour,
+		}))
+	require.NoError(t, err)
+	defer client.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/abilities", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	client.AbilityCatalogHandler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var cat goauth.AbilityCatalog
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &cat))
+	assert.Equal(t, []goauth.AbilityInfo{
+		{Name: "posts:read", Description: "Read published posts"},
+		{Name: "posts:write", Description: "Create and edit posts"},
+	}, cat.Abilities)
+	require.Len(t, cat.Templates, 1)
+	assert.Equal(t, int64(3600), cat.Templates[0].ExpiresInSeconds)
+
+	rec = httptest.NewRecorder()
+	client.AbilityCatalogHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/abilities", nil))
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, rec.Body.String(), "Create and edit posts")
+
+	raw, err := client.CreateTokenFromTemplate(ctx, "ci-deploy", 3)
+	require.NoError(t, err)
+	tok, err := client.ValidateTokenWithAbility(ctx, raw, "deploy")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *tok.ExpiresAt, 5*time.Second)
+}
+
+func TestConsentHandler(t *testing.T) {
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(),
+		goauth.WithAbility("posts:read", "Read published posts"))
+	require.NoError(t, err)
+	defer client.Close()
+
+	var decision string
+	var approved *goauth.ConsentRequest
+	record := func(d string) func(http.ResponseWriter, *http.Request, *goauth.ConsentRequest) {
+		return func(w http.ResponseWriter, r *http.Request, req *goauth.ConsentRequest) {
+			decision, approved = d, req
+			w.WriteHeader(http.StatusFound)
+		}
+	}
+	h, err := client.ConsentHandler(goauth.ConsentHandlers{
+		Load: func(r *http.Request) (*goauth.ConsentRequest, error) {
+			return &goauth.ConsentRequest{
+				AppName: "Acme CLI",
+				Scopes:  []string{"posts:read", "posts:delete"},
+				User:    goauth.ConsentUser{ID: 1, Name: "Ada"},
+			}, nil
+		},
+		Approve: record("approve"),
+		Deny:    record("deny"),
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth/consent", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Acme CLI")
+	assert.Contains(t, rec.Body.String(), "Read published posts")
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Contains(t, rec.Body.String(), cookies[0].Value)
+
+	post := func(csrf, d string, scopes ...string) int {
+		form := url.Values{goauth.ConsentCSRFField: {csrf}, goauth.ConsentDecisionField: {d}, goauth.ConsentScopeField: scopes}
+		req := httptest.NewRequest(http.MethodPost, "/oauth/consent", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookies[0])
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, post("forged", "approve"))
+	assert.Empty(t, decision)
+	assert.Equal(t, http.StatusFound, post(cookies[0].Value, "deny"))
+	assert.Equal(t, "deny", decision)
+	assert.Equal(t, http.StatusFound, post(cookies[0].Value, "approve"))
+	assert.Equal(t, "deny", decision, "approving with every scope unchecked denies")
+
+	// The user unchecks posts:delete; "admin" was never requested
+	assert.Equal(t, http.StatusFound, post(cookies[0].Value, "approve", "posts:read", "admin"))
+	require.Equal(t, "approve", decision)
+	assert.Equal(t, []string{"posts:read"}, approved.Granted)
+
+	raw, err := client.CreateToken(context.Background(), approved.TokenOptions())
+	require.NoError(t, err)
+	grant, err := client.GrantedScopes(context.Background(), raw)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"posts:read", "posts:delete"}, grant.Requested)
+	assert.Equal(t, []string{"posts:read"}, grant.Granted)
+	assert.Equal(t, []string{"posts:delete"}, grant.Denied)
+}
+
+func TestValidationErrors(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	for name, storage := range map[string]goauth.Option{
+		"memory": goauth.WithMemoryStorage(),
+		"gorm":   goauth.WithGormStorage(db),
+	} {
+		t.Run(name, func(t *testing.T) {
+			clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+			client, err := goauth.NewClient(storage, goauth.WithClock(clock))
+			require.NoError(t, err)
+			defer client.Close()
+
+			check := func(raw string, want error) {
+				_, err := client.ValidateToken(ctx, raw)
+				assert.ErrorIs(t, err, want)
+				assert.ErrorIs(t, err, goauth.ErrTokenInvalid)
+			}
+
+			check("", goauth.ErrInvalidFormat)
+			check("no-separator", goauth.ErrInvalidFormat)
+			check("1|a|b", goauth.ErrInvalidFormat)
+			check("1|unknown", goauth.ErrTokenNotFound)
+
+			for _, raw := range []string{"", "no-separator", "1|a|b", "1|"} {
+				_, err := client.GetTokenInfo(ctx, raw)
+				assert.ErrorIs(t, err, goauth.ErrInvalidFormat, raw)
+				assert.ErrorIs(t, err, goauth.ErrTokenInvalid, raw)
+			}
+			assert.ErrorIs(t, client.RevokeToken(ctx, ""), goauth.ErrInvalidFormat)
+
+			raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+			require.NoError(t, err)
+			_, err = client.RotateToken(ctx, raw)
+			require.NoError(t, err)
+			check(raw, goauth.ErrTokenRevoked)
+
+			short, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, ExpiresIn: time.Minute})
+			require.NoError(t, err)
+			clock.Advance(time.Minute + time.Second)
+			check(short, goauth.ErrTokenExpired)
+		})
+	}
+}
+
+func TestSoftRevocation(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	for name, storage := range map[string]goauth.Option{
+		"memory": goauth.WithMemoryStorage(),
+		"gorm":   goauth.WithGormStorage(db),
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, err := goauth.NewClient(storage, goauth.WithSoftRevocation())
+			require.NoError(t, err)
+			defer client.Close()
+
+			raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 7})
+			require.NoError(t, err)
+			require.NoError(t, client.RevokeToken(ctx, raw))
+
+			_, err = client.ValidateToken(ctx, raw)
+			assert.ErrorIs(t, err, goauth.ErrTokenRevoked)
+
+			revoked, total, err := client.ListTokens(ctx, 7, &goauth.ListOptions{Status: goauth.StatusRevoked})
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), total)
+			require.Len(t, revoked, 1)
+			assert.NotNil(t, revoked[0].RevokedAt)
+
+			_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 7})
+			require.NoError(t, err)
+			n, err := client.RevokeUserTokens(ctx, 7)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), n)
+
+			n, err = client.PruneRevoked(ctx, time.Hour)
+			require.NoError(t, err)
+			assert.Zero(t, n)
+
+			n, err = client.PruneRevoked(ctx, 0)
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), n)
+
+			_, total, err = client.ListTokens(ctx, 7, nil)
+			require.NoError(t, err)
+			assert.Zero(t, total)
+		})
+	}
+}
+
+func TestCreateTokens(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	for name, storage := range map[string]goauth.Option{
+		"memory": goauth.WithMemoryStorage(),
+		"gorm":   goauth.WithGormStorage(db),
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, err := goauth.NewClient(storage)
+			require.NoError(t, err)
+			defer client.Close()
+
+			opts := make([]*goauth.TokenOptions, 250)
+			for i := range opts {
+				opts[i] = &goauth.TokenOptions{UserId: 9, Abilities: []string{"device:report"}}
+			}
+			tokens, err := client.CreateTokens(ctx, opts)
+			require.NoError(t, err)
+			require.Len(t, tokens, len(opts))
+
+			for _, raw := range []string{tokens[0], tokens[len(tokens)-1]} {
+				tok, err := client.ValidateToken(ctx, raw)
+				require.NoError(t, err)
+				assert.Equal(t, int64(9), tok.UserId)
+			}
+
+			_, err = client.CreateTokens(ctx, []*goauth.TokenOptions{
+				{UserId: 10},
+				{UserId: 10, ExpiresIn: -time.Hour},
+			})
+			assert.Error(t, err)
+			_, total, err := client.ListTokens(ctx, 10, nil)
+			require.NoError(t, err)
+			assert.Zero(t, total)
+		})
+	}
+}
+
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+	east, west := goauth.NewMemoryStorage(), goauth.NewMemoryStorage()
+
+	eastClient, err := goauth.NewClient(goauth.WithStorage(east),
+		goauth.WithLocator(goauth.HashPrefixLocator(12)),
+		goauth.WithReplication(goauth.ReplicationOptions{SyncPeers: -1}, goauth.Replica{Region: "us-west", Storage: west}))
+	require.NoError(t, err)
+	defer eastClient.Close()
+	westClient, err := goauth.NewClient(goauth.WithStorage(west),
+		goauth.WithLocator(goauth.HashPrefixLocator(12)),
+		goauth.WithReplication(goauth.ReplicationOptions{ReadFallback: true}, goauth.Replica{Region: "us-east", Storage: east}))
+	require.NoError(t, err)
+	defer westClient.Close()
+
+	_, err = goauth.NewClient(goauth.WithStorage(east),
+		goauth.WithReplication(goauth.ReplicationOptions{}, goauth.Replica{Region: "us-west", Storage: west}))
+	assert.Error(t, err, "ID locators cannot be replicated")
+
+	// Synchronous replication: the token validates in the other region at once
+	raw, err := eastClient.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	_, err = westClient.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+
+	// Revoking in one region revokes everywhere, here asynchronously
+	require.NoError(t, westClient.RevokeToken(ctx, raw))
+	assert.Eventually(t, func() bool {
+		_, err := eastClient.ValidateToken(ctx, raw)
+		return errors.Is(err, goauth.ErrTokenRevoked)
+	}, time.Second, 5*time.Millisecond)
+
+	// A token revoked before its creation reached this region stays revoked
+	// when the creation arrives late
+	unreplicated, err := goauth.NewClient(goauth.WithStorage(east), goauth.WithLocator(goauth.HashPrefixLocator(12)))
+	require.NoError(t, err)
+	defer unreplicated.Close()
+	late, err := unreplicated.CreateToken(ctx, &goauth.TokenOptions{UserId: 2})
+	require.NoError(t, err)
+	_, err = westClient.ValidateToken(ctx, late)
+	require.NoError(t, err, "read fallback finds tokens not replicated yet")
+	require.NoError(t, westClient.RevokeToken(ctx, late))
+
+	sum := sha256.Sum256([]byte(strings.SplitN(late, "|", 2)[1]))
+	tok, err := east.FindByHash(hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	resurrected := *tok
+	resurrected.ID, resurrected.RevokedAt = 0, nil
+	assert.ErrorIs(t, west.StoreToken(&resurrected), goauth.ErrDuplicateToken)
+	_, err = westClient.ValidateToken(ctx, late)
+	assert.ErrorIs(t, err, goauth.ErrTokenRevoked)
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&oauth2.Client{}))
+
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+
+	store := oauth2.NewGormStore(db)
+	id, secret, err := oauth2.Register(ctx, store, oauth2.Registration{
+		Name:     "billing-sync",
+		UserID:   42,
+		Scopes:   []string{"invoices:*", "customers:read"},
+		TokenTTL: 10 * time.Minute,
+	})
+	require.NoError(t, err)
+	stored, err := store.FindClient(ctx, id)
+	require.NoError(t, err)
+	assert.NotContains(t, stored.SecretHash, secret)
+
+	handler := oauth2.NewServer(client, store).TokenHandler()
+	request := func(form url.Values, basicID, basicSecret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if basicID != "" {
+			req.SetBasicAuth(url.QueryEscape(basicID), url.QueryEscape(basicSecret))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request(url.Values{"grant_type": {"client_credentials"}, "scope": {"invoices:read"}}, id, secret)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var resp oauth2.TokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "Bearer", resp.TokenType)
+	assert.Equal(t, "invoices:read", resp.Scope)
+	assert.InDelta(t, 600, resp.ExpiresIn, 2)
+
+	tok, err := client.ValidateTokenWithAbility(ctx, resp.AccessToken, "invoices:read")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), tok.UserId)
+	assert.Equal(t, id, tok.Metadata[oauth2.MetaClientID])
+	_, err = client.ValidateTokenWithAbility(ctx, resp.AccessToken, "customers:read")
+	assert.ErrorIs(t, err, goauth.ErrAbilityDenied)
+
+	// Form authentication without a scope grants every allowed scope
+	rec = request(url.Values{"grant_type": {"client_credentials"}, "client_id": {id}, "client_secret": {secret}}, "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "invoices:* customers:read", resp.Scope)
+
+	errorCode := func(rec *httptest.ResponseRecorder) string {
+		var e oauth2.Error
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &e))
+		return e.Code
+	}
+	rec = request(url.Values{"grant_type": {"client_credentials"}}, id, "wrong")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "invalid_client", errorCode(rec))
+	assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+
+	rec = request(url.Values{"grant_type": {"client_credentials"}, "scope": {"users:delete"}}, id, secret)
+	assert.Equal(t, "invalid_scope", errorCode(rec))
+
+	rec = request(url.Values{"grant_type": {"password"}}, id, secret)
+	assert.Equal(t, "unsupported_grant_type", errorCode(rec))
+}
+
+func TestPerTokenExpiration(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithTokenExpiration(time.Hour))
+	require.NoError(t, err)
+	defer client.Close()
+
+	expiry := func(opts *goauth.TokenOptions) *time.Time {
+		opts.UserId = 1
+		raw, err := client.CreateToken(ctx, opts)
+		require.NoError(t, err)
+		tok, err := client.ValidateToken(ctx, raw)
+		require.NoError(t, err)
+		return tok.ExpiresAt
+	}
+
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *expiry(&goauth.TokenOptions{}), 5*time.Second)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), *expiry(&goauth.TokenOptions{ExpiresIn: 5 * time.Minute}), 5*time.Second)
+	at := time.Now().Add(90 * 24 * time.Hour)
+	assert.WithinDuration(t, at, *expiry(&goauth.TokenOptions{ExpiresAt: &at}), 5*time.Second)
+	assert.Nil(t, expiry(&goauth.TokenOptions{ExpiresIn: goauth.NoExpiry}))
+
+	past := time.Now().Add(-time.Minute)
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, ExpiresAt: &past})
+	assert.Error(t, err)
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, ExpiresIn: time.Minute, ExpiresAt: &at})
+	assert.Error(t, err)
+}
+
+func TestShadowValidation(t *testing.T) {
+	ctx := context.Background()
+	// The shadow's new storage is missing the primary's tokens, except
+	// for one copied over
+	store, migrated := goauth.NewMemoryStorage(), goauth.NewMemoryStorage()
+	shadow, err := goauth.NewClient(goauth.WithStorage(migrated))
+	require.NoError(t, err)
+	defer shadow.Close()
+
+	var mu sync.Mutex
+	var diverged []goauth.Divergence
+	client, err := goauth.NewClient(goauth.WithStorage(store),
+		goauth.WithShadowValidation(shadow, goauth.ShadowRecorderFunc(func(d goauth.Divergence) {
+			mu.Lock()
+			defer mu.Unlock()
+			diverged = append(diverged, d)
+		})))
+	require.NoError(t, err)
+
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 3})
+	require.NoError(t, err)
+	tok, err := client.ValidateToken(ctx, raw)
+	require.NoError(t, err, "the primary result is what callers get")
+	assert.Equal(t, int64(3), tok.UserId)
+
+	copied, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 4})
+	require.NoError(t, err)
+	stored, err := client.GetTokenInfo(ctx, copied)
+	require.NoError(t, err)
+	require.NoError(t, migrated.StoreToken(stored))
+	_, err = client.ValidateToken(ctx, copied)
+	require.NoError(t, err)
+	_, err = client.ValidateToken(ctx, "1|unknown")
+	require.Error(t, err)
+	require.NoError(t, client.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, diverged, 1, "agreeing outcomes, failures included, aren't reported")
+	d := diverged[0]
+	assert.Equal(t, "shadow rejected a token the primary accepted", d.Reason)
+	assert.NoError(t, d.PrimaryErr)
+	assert.ErrorIs(t, d.ShadowErr, goauth.ErrTokenNotFound)
+	assert.Equal(t, tok.ID, d.PrimaryToken.ID)
+	assert.Nil(t, d.ShadowToken)
+	assert.NotContains(t, d.TokenHash, raw[strings.Index(raw, "|")+1:])
+	assert.False(t, d.At.IsZero())
+}
+
+func TestSlidingExpiration(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := goauthtest.NewClock(start)
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithClock(clock),
+		goauth.WithSlidingExpiration(time.Hour), goauth.WithMaxTokenLifetime(3*time.Hour))
+	require.NoError(t, err)
+	defer client.Close()
+
+	issue := func(userID int64, opts *goauth.TokenOptions) string {
+		opts.UserId = userID
+		raw, err := client.CreateToken(ctx, opts)
+		require.NoError(t, err)
+		return raw
+	}
+	// Renewals are written in the background
+	stored := func(userID int64, want time.Time) {
+		require.Eventually(t, func() bool {
+			toks, _, err := client.ListTokens(ctx, userID, nil)
+			return err == nil && len(toks) == 1 && toks[0].ExpiresAt.Equal(want)
+		}, time.Second, 5*time.Millisecond)
+	}
+	validate := func(raw string) time.Time {
+		tok, err := client.ValidateToken(ctx, raw)
+		require.NoError(t, err)
+		return *tok.ExpiresAt
+	}
+
+	raw := issue(1, &goauth.TokenOptions{})
+	stored(1, start.Add(time.Hour))
+
+	clock.Advance(45 * time.Minute)
+	assert.Equal(t, start.Add(105*time.Minute), validate(raw), "renewed to now+idle")
+	stored(1, start.Add(105*time.Minute))
+
+	clock.Advance(55 * time.Minute)
+	assert.Equal(t, start.Add(160*time.Minute), validate(raw), "still valid past the first window")
+	stored(1, start.Add(160*time.Minute))
+
+	clock.Advance(55 * time.Minute)
+	assert.Equal(t, start.Add(3*time.Hour), validate(raw), "capped by WithMaxTokenLifetime")
+	stored(1, start.Add(3*time.Hour))
+
+	clock.Advance(30 * time.Minute)
+	_, err = client.ValidateToken(ctx, raw)
+	assert.ErrorIs(t, err, goauth.ErrTokenExpired)
+
+	// Lifetimes the caller chose are kept
+	clock.Set(start)
+	short := issue(2, &goauth.TokenOptions{ExpiresIn: 2 * time.Minute})
+	at := start.Add(90 * time.Minute)
+	fixed := issue(3, &goauth.TokenOptions{ExpiresAt: &at})
+	spoofed := issue(4, &goauth.TokenOptions{ExpiresIn: 2 * time.Minute, Metadata: map[string]string{"goauth.sliding": "1"}})
+	clock.Advance(time.Minute)
+	assert.Equal(t, start.Add(2*time.Minute), validate(short))
+	assert.Equal(t, at, validate(fixed))
+	assert.Equal(t, start.Add(2*time.Minute), validate(spoofed), "the mark can't be set through Metadata")
+
+	// One-time tokens only last their own ttl
+	link, err := client.CreateOneTimeToken(ctx, 5, 2*time.Minute)
+	require.NoError(t, err)
+	tok, err := client.ConsumeOneTimeToken(ctx, link)
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(3*time.Minute), *tok.ExpiresAt)
+
+	// Rotation carries the sliding mark to the successor
+	clock.Set(start)
+	rot, err := client.RotateToken(ctx, issue(6, &goauth.TokenOptions{}))
+	require.NoError(t, err)
+	clock.Advance(30 * time.Minute)
+	assert.Equal(t, start.Add(90*time.Minute), validate(rot.PlainText))
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithSlidingExpiration(0))
+	assert.Error(t, err)
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithMaxTokenLifetime(-time.Hour))
+	assert.Error(t, err)
+}
+
+func TestWorkloadIdentity(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": srv.URL, "jwks_uri": srv.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "OKP", "crv": "Ed25519", "kid": "k1", "x": b64(pub)},
+		}})
+	})
+
+	sign := func(claims map[string]any) string {
+		header, _ := json.Marshal(map[string]string{"alg": "EdDSA", "kid": "k1"})
+		body, _ := json.Marshal(claims)
+		input := b64(header) + "." + b64(body)
+		return input + "." + b64(ed25519.Sign(priv, []byte(input)))
+	}
+	claims := func(sub, aud string) map[string]any {
+		return map[string]any{
+			"iss": srv.URL, "aud": aud, "sub": sub,
+			"exp": time.Now().Add(5 * time.Minute).Unix(),
+			"ref": "refs/heads/main",
+		}
+	}
+
+	mapping := &goauth.WorkloadMapping{
+		Issuer:   srv.URL,
+		Audience: "https://api.example.com",
+		UserID:   42,
+		Rules: []goauth.WorkloadRule{
+			{Claims: map[string]string{"sub": "repo:acme/*:*", "ref": "refs/heads/main"}, Abilities: []string{"deploy"}},
+			{Claims: map[string]string{"sub": "repo:acme/*"}, Abilities: []string{"read"}},
+		},
+	}
+
+	res, err := client.ExchangeWorkloadIdentity(ctx, sign(claims("repo:acme/api:ref:refs/heads/main", mapping.Audience)), mapping)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), *res.ExpiresAt, 5*time.Second)
+	tok, err := client.ValidateTokenWithAbility(ctx, res.PlainText, "deploy")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), tok.UserId)
+	assert.Equal(t, "repo:acme/api:ref:refs/heads/main", tok.Metadata[goauth.MetaWorkloadSubject])
+
+	_, err = client.ExchangeWorkloadIdentity(ctx, sign(claims("repo:other/api:ref:refs/heads/main", mapping.Audience)), mapping)
+	assert.ErrorIs(t, err, goauth.ErrWorkloadDenied)
+	_, err = client.ExchangeWorkloadIdentity(ctx, sign(claims("repo:acme/api:ref:refs/heads/main", "https://elsewhere")), mapping)
+	assert.ErrorIs(t, err, goauth.ErrWorkloadTokenInvalid)
+
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	priv = otherKey
+	_, err = client.ExchangeWorkloadIdentity(ctx, sign(claims("repo:acme/api:ref:refs/heads/main", mapping.Audience)), mapping)
+	assert.ErrorIs(t, err, goauth.ErrWorkloadTokenInvalid)
+}
+
+func TestX509SVIDExchange(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+
+	newCA := func() (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		return cert, key
+	}
+	svid := func(ca *x509.Certificate, caKey *ecdsa.PrivateKey, id string) []*x509.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		u, err := url.Parse(id)
+		require.NoError(t, err)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			URIs:         []*url.URL{u},
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		return []*x509.Certificate{cert}
+	}
+
+	ca, caKey := newCA()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	mapping := &goauth.SPIFFEMapping{
+		TrustDomain: "prod.example.org",
+		X509Roots:   roots,
+		UserID:      7,
+		Rules: []goauth.SPIFFERule{
+			{ID: "spiffe://prod.example.org/ns/payments/*", Abilities: []string{"payments:write"}},
+		},
+	}
+
+	res, err := client.ExchangeX509SVID(ctx, svid(ca, caKey, "spiffe://prod.example.org/ns/payments/sa/api"), mapping)
+	require.NoError(t, err)
+	tok, err := client.ValidateTokenWithAbility(ctx, res.PlainText, "payments:write")
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://prod.example.org/ns/payments/sa/api", tok.Metadata[goauth.MetaWorkloadSubject])
+
+	_, err = client.ExchangeX509SVID(ctx, svid(ca, caKey, "spiffe://prod.example.org/ns/web/sa/frontend"), mapping)
+	assert.ErrorIs(t, err, goauth.ErrWorkloadDenied)
+	_, err = client.ExchangeX509SVID(ctx, svid(ca, caKey, "spiffe://dev.example.org/ns/payments/sa/api"), mapping)
+	assert.ErrorIs(t, err, goauth.ErrWorkloadTokenInvalid)
+
+	rogue, rogueKey := newCA()
+	_, err = client.ExchangeX509SVID(ctx, svid(rogue, rogueKey, "spiffe://prod.example.org/ns/payments/sa/api"), mapping)
+	assert.ErrorIs(t, err, goauth.ErrWorkloadTokenInvalid)
+}
+
+func TestRotateToken(t *testing.T) {
+	ctx := context.Background()
+	name := "ci"
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 4, Name: &name, Abilities: []string{"deploy"}})
+	require.NoError(t, err)
+	old, err := client.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+
+	rot, err := client.RotateToken(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, old.ID, rot.OldID)
+	assert.Equal(t, old.ExpiresAt.Unix(), rot.ExpiresAt.Unix())
+
+	_, err = client.ValidateToken(ctx, raw)
+	assert.Error(t, err)
+	tok, err := client.ValidateTokenWithAbility(ctx, rot.PlainText, "deploy")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), tok.UserId)
+	assert.Equal(t, "ci", *tok.Name)
+
+	// With a grace window the old token keeps working but can't be rotated again
+	graceful, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithRotationGracePeriod(time.Minute))
+	require.NoError(t, err)
+	defer graceful.Close()
+
+	raw, err = graceful.CreateToken(ctx, &goauth.TokenOptions{UserId: 4})
+	require.NoError(t, err)
+	rot, err = graceful.RotateToken(ctx, raw)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), rot.RevokeAt, 5*time.Second)
+
+	_, err = graceful.ValidateToken(ctx, raw)
+	assert.NoError(t, err)
+	_, err = graceful.RotateToken(ctx, raw)
+	assert.ErrorIs(t, err, goauth.ErrTokenRotated)
+
+	// Rotations are audited against the token's owner
+	var events []siem.Event
+	audited, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithAuditEvents(siem.SinkFunc(func(_ context.Context, batch []siem.Event) error {
+		events = append(events, batch...)
+		return nil
+	})))
+	require.NoError(t, err)
+	raw, err = audited.CreateToken(ctx, &goauth.TokenOptions{UserId: 9})
+	require.NoError(t, err)
+	_, err = audited.RotateToken(ctx, raw)
+	require.NoError(t, err)
+	require.NoError(t, audited.Close())
+	require.Len(t, events, 2)
+	assert.Equal(t, siem.TokenRotated, events[1].Type)
+	assert.Equal(t, int64(9), events[1].UserID)
+}
+
+func TestOrgInheritance(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithInheritedAbilities())
+	require.NoError(t, err)
+	defer client.Close()
+
+	org, err := client.CreateOrg(ctx, "acme")
+	require.NoError(t, err)
+	team, err := client.CreateTeam(ctx, org.ID, "platform")
+	require.NoError(t, err)
+	_, err = client.CreateTeam(ctx, 9999, "orphan")
+	assert.ErrorIs(t, err, goauth.ErrOrgUnitNotFound)
+
+	require.NoError(t, client.AddMember(ctx, team.ID, 7))
+	require.NoError(t, client.GrantUnitAbility(ctx, org.ID, "billing:read"))
+	require.NoError(t, client.GrantUnitAbility(ctx, team.ID, "deploy:*"))
+	require.NoError(t, client.GrantUserAbility(ctx, 7, "reports:export"))
+
+	token, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 7, Abilities: []string{"profile:read"}})
+	require.NoError(t, err)
+	tok, err := client.ValidateToken(ctx, token)
+	require.NoError(t, err)
+
+	abilities, err := client.EffectiveAbilities(ctx, tok)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"profile:read", "reports:export", "billing:read", "deploy:*"}, abilities)
+
+	_, err = client.ValidateTokenWithAbility(ctx, token, "deploy:prod")
+	assert.NoError(t, err)
+	_, err = client.ValidateTokenWithAbility(ctx, token, "admin")
+	assert.ErrorIs(t, err, goauth.ErrAbilityDenied)
+
+	require.NoError(t, client.RemoveMember(ctx, team.ID, 7))
+	_, err = client.ValidateTokenWithAbility(ctx, token, "deploy:prod")
+	assert.ErrorIs(t, err, goauth.ErrAbilityDenied)
+}
+
+func TestAuthorizeConditions(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+
+	token, err := client.CreateToken(ctx, &goauth.TokenOptions{
+		UserId: 42,
+		Abilities: []string{
+			"posts:read",
+			"posts:write when resource.owner == token.user_id",
+			"posts:publish when resource.status in ['draft', 'review'] && !resource.locked",
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = client.Authorize(ctx, token, "posts:read", nil)
+	assert.NoError(t, err)
+	_, err = client.Authorize(ctx, token, "posts:write", map[string]any{"owner": int64(42)})
+	assert.NoError(t, err)
+	_, err = client.Authorize(ctx, token, "posts:write", map[string]any{"owner": 7})
+	assert.ErrorIs(t, err, goauth.ErrAbilityDenied)
+	_, err = client.Authorize(ctx, token, "posts:publish", map[string]any{"status": "draft"})
+	assert.NoError(t, err)
+	_, err = client.Authorize(ctx, token, "posts:publish", map[string]any{"status": "draft", "locked": true})
+	assert.ErrorIs(t, err, goauth.ErrAbilityDenied)
+
+	// Conditional grants never match without a resource
+	_, err = client.ValidateTokenWithAbility(ctx, token, "posts:write")
+	assert.ErrorIs(t, err, goauth.ErrAbilityDenied)
+
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{
+		UserId:    42,
+		Abilities: []string{"posts:write when resource.owner =="},
+	})
+	assert.ErrorIs(t, err, goauth.ErrInvalidCondition)
+}
+
+func TestPolicyBundle(t *testing.T) {
+	ctx := context.Background()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var served []byte
+	publish := func(b *policy.Bundle) {
+		data, err := policy.Sign(priv, b)
+		require.NoError(t, err)
+		mu.Lock()
+		served = data
+		mu.Unlock()
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write(served)
+	}))
+	defer srv.Close()
+
+	publish(&policy.Bundle{Version: 1, Roles: map[string][]string{"editor": {"posts:read", "posts:write"}}})
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(),
+		goauth.WithPolicyBundle(policy.NewHTTPSource(srv.URL), pub, 0))
+	require.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, int64(1), client.PolicyVersion())
+
+	token, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Abilities: []string{"role:editor"}})
+	require.NoError(t, err)
+	_, err = client.ValidateTokenWithAbility(ctx, token, "posts:write")
+	assert.NoError(t, err)
+
+	publish(&policy.Bundle{Version: 2, Roles: map[string][]string{"editor": {"posts:read"}}})
+	require.NoError(t, client.ReloadPolicy(ctx))
+	_, err = client.ValidateTokenWithAbility(ctx, token, "posts:write")
+	assert.ErrorIs(t, err, goauth.ErrAbilityDenied)
+
+	publish(&policy.Bundle{Version: 1, Roles: map[string][]string{"editor": {"*"}}})
+	assert.ErrorIs(t, client.ReloadPolicy(ctx), policy.ErrStale)
+
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	forged, err := policy.Sign(otherPriv, &policy.Bundle{Version: 3})
+	require.NoError(t, err)
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(),
+		goauth.WithPolicyBundle(policy.SourceFunc(func(context.Context) ([]byte, error) { return forged, nil }), pub, 0))
+	assert.ErrorIs(t, err, policy.ErrSignature)
+}
+
+func TestDecisionCache(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithDecisionCache(128, time.Minute))
+	require.NoError(t, err)
+	defer client.Close()
+
+	token, err := client.CreateToken(ctx, &goauth.TokenOptions{
+		UserId:    42,
+		Abilities: []string{"posts:write when resource.owner == token.user_id"},
+	})
+	require.NoError(t, err)
+	resource := map[string]any{"owner": 42}
+
+	_, err = client.Authorize(ctx, token, "posts:write", resource)
+	require.NoError(t, err)
+
+	// Edit the stored abilities behind the client's back: the cached
+	// decision stands until the cache is invalidated
+	tok, err := client.GetTokenInfo(ctx, token)
+	require.NoError(t, err)
+	tok.Abilities = "posts:read"
+	require.NoError(t, client.Storage().UpdateToken(tok))
+
+	_, err = client.Authorize(ctx, token, "posts:write", resource)
+	assert.NoError(t, err)
+	_, err = client.Authorize(ctx, token, "posts:write", map[string]any{"owner": 42, "draft": true})
+	assert.ErrorIs(t, err, goauth.ErrAbilityDenied)
+
+	client.InvalidateDecisions()
+	_, err = client.Authorize(ctx, token, "posts:write", resource)
+	assert.ErrorIs(t, err, goauth.ErrAbilityDenied)
+
+	// Revoked tokens are rejected regardless of cached decisions
+	_, err = client.Authorize(ctx, token, "posts:read", nil)
+	require.NoError(t, err)
+	require.NoError(t, client.RevokeToken(ctx, token))
+	_, err = client.Authorize(ctx, token, "posts:read", nil)
+	assert.Error(t, err)
+}
+
+func TestClientSDKTransport(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+
+	hmacKey := []byte("shared-secret")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, err := client.ValidateToken(r.Context(), raw); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		want := clientsdk.HMACSignature(hmacKey, r.Method, r.URL.RequestURI(), r.Header.Get(clientsdk.HeaderTimestamp), body)
+		if r.Header.Get(clientsdk.HeaderSignature) != want {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	pair, err := client.CreateTokenPair(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+
+	refreshes := 0
+	src := clientsdk.NewRefreshingSource(clientsdk.Tokens{
+		AccessToken:  pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+	}, func(ctx context.Context, refresh string) (*clientsdk.Tokens, error) {
+		refreshes++
+		next, err := client.RefreshToken(ctx, refresh)
+		if err != nil {
+			return nil, err
+		}
+		return &clientsdk.Tokens{AccessToken: next.AccessToken, RefreshToken: next.RefreshToken}, nil
+	})
+	httpClient := &http.Client{Transport: &clientsdk.Transport{Source: src, Signer: &clientsdk.HMACSigner{Key: hmacKey}}}
+
+	post := func() *http.Response {
+		resp, err := httpClient.Post(srv.URL+"/items?x=1", "text/plain", strings.NewReader("hello"))
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := post()
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, 0, refreshes)
+
+	// A rejected access token is refreshed and the request replayed
+	require.NoError(t, client.RevokeToken(ctx, pair.AccessToken))
+	resp = post()
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, 1, refreshes)
+	assert.NotEqual(t, pair.AccessToken, src.Tokens().AccessToken)
+}
+
+func TestClientSDKConcurrentRefresh(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, err := client.ValidateToken(r.Context(), raw); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	pair, err := client.CreateTokenPair(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	require.NoError(t, client.RevokeToken(ctx, pair.AccessToken))
+
+	var refreshes atomic.Int32
+	var rotated []clientsdk.Tokens
+	src := clientsdk.NewRefreshingSource(clientsdk.Tokens{
+		AccessToken:  pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+	}, func(ctx context.Context, refresh string) (*clientsdk.Tokens, error) {
+		refreshes.Add(1)
+		next, err := client.RefreshToken(ctx, refresh)
+		if err != nil {
+			return nil, err
+		}
+		return &clientsdk.Tokens{AccessToken: next.AccessToken, RefreshToken: next.RefreshToken}, nil
+	})
+	src.OnTokenRotated = func(tokens clientsdk.Tokens) { rotated = append(rotated, tokens) }
+	httpClient := &http.Client{Transport: &clientsdk.Transport{Source: src}}
+
+	var wg sync.WaitGroup
+	codes := make([]int, 20)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := httpClient.Get(srv.URL)
+			if assert.NoError(t, err) {
+				codes[i] = resp.StatusCode
+				resp.Body.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, code := range codes {
+		assert.Equal(t, http.StatusOK, code)
+	}
+	assert.Equal(t, int32(1), refreshes.Load())
+	require.Len(t, rotated, 1)
+	assert.Equal(t, src.Tokens(), rotated[0])
+}
+
+func TestDeviceFlowLogin(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+
+	var tokenCalls atomic.Int32
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+	mux.HandleFunc("/.well-known/oauth-authorization-server", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"device_authorization_endpoint": srv.URL + "/device",
+			"token_endpoint":                srv.URL + "/token",
+			"revocation_endpoint":           srv.URL + "/revoke",
+		})
+	})
+	mux.HandleFunc("/revoke", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "refresh_token", r.FormValue("token_type_hint"))
+		assert.NotEmpty(t, r.FormValue("token"))
+		_, err := client.RevokeUserTokens(r.Context(), 9)
+		assert.NoError(t, err)
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "mycli", r.FormValue("client_id"))
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"device_code":      "dev-123",
+			"user_code":        "WDJB-MJHT",
+			"verification_uri": srv.URL + "/activate",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenCalls.Add(1)
+		assert.Equal(t, "dev-123", r.FormValue("device_code"))
+		pair, err := client.CreateTokenPair(r.Context(), &goauth.TokenOptions{UserId: 9})
+		require.NoError(t, err)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  pair.AccessToken,
+			"refresh_token": pair.RefreshToken,
+			"expires_in":    3600,
+		})
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+
+	store := clientsdk.NewMemoryStore()
+	var out bytes.Buffer
+	login := func() *clientsdk.Tokens {
+		tokens, err := clientsdk.LoginWithDeviceFlow(ctx, srv.URL,
+			clientsdk.WithClientID("mycli"),
+			clientsdk.WithCredentialStore(store),
+			clientsdk.WithOutput(&out))
+		require.NoError(t, err)
+		return tokens
+	}
+
+	tokens := login()
+	assert.Contains(t, out.String(), "WDJB-MJHT")
+	tok, err := client.ValidateToken(ctx, tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), tok.UserId)
+
+	// The second login is served from the credential cache
+	assert.Equal(t, tokens, login())
+	assert.Equal(t, int32(1), tokenCalls.Load())
+
+	// Logout revokes the session server-side and clears the cache
+	require.NoError(t, clientsdk.Logout(ctx, srv.URL,
+		clientsdk.WithClientID("mycli"),
+		clientsdk.WithCredentialStore(store)))
+	_, err = store.Load(srv.URL + "#mycli")
+	assert.ErrorIs(t, err, clientsdk.ErrCredentialNotFound)
+	_, err = client.ValidateToken(ctx, tokens.AccessToken)
+	assert.Error(t, err)
+}
+
+func TestCredentialFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.enc")
+	key := bytes.Repeat([]byte{7}, 32)
+	store, err := clientsdk.NewFileStore(path, key)
+	require.NoError(t, err)
+
+	want := clientsdk.Tokens{AccessToken: "access|secret", RefreshToken: "refresh|secret"}
+	require.NoError(t, store.Save("issuer#cli", want))
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "secret")
+
+	reopened, err := clientsdk.NewFileStore(path, key)
+	require.NoError(t, err)
+	got, err := reopened.Load("issuer#cli")
+	require.NoError(t, err)
+	assert.Equal(t, want, *got)
+
+	wrongKey, err := clientsdk.NewFileStore(path, bytes.Repeat([]byte{8}, 32))
+	require.NoError(t, err)
+	_, err = wrongKey.Load("issuer#cli")
+	assert.Error(t, err)
+
+	require.NoError(t, store.Delete("issuer#cli"))
+	_, err = store.Load("issuer#cli")
+	assert.ErrorIs(t, err, clientsdk.ErrCredentialNotFound)
+}
+
+func TestDriverStats(t *testing.T) {
+	ctx := context.Background()
+	archive, err := goauth.OpenJSONLArchive(filepath.Join(t.TempDir(), "archive.jsonl"))
+	require.NoError(t, err)
+	defer archive.Close()
+
+	// Tracing, cache and archive decorators around the memory driver
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(),
+		goauth.WithArchive(archive, time.Hour, 0),
+		goauth.WithTokenCache(goauth.NewMemoryTokenCache(100), goauth.CacheOptions{}),
+		goauth.WithTracerProvider(sdktrace.NewTracerProvider()))
+	require.NoError(t, err)
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+		require.NoError(t, err)
+	}
+	_, err = client.ValidateToken(ctx, "1|unknown")
+	assert.Error(t, err)
+
+	stats := client.DriverStats()
+	var names []string
+	for _, s := range stats {
+		names = append(names, s.Driver)
+	}
+	require.Equal(t, []string{"cache", "memory"}, names, "outermost first, decorators without stats skipped")
+	assert.Equal(t, uint64(1), stats[0].CacheMisses)
+	assert.Equal(t, int64(3), stats[1].Tokens, "the inner driver's stats come through")
+}
+
+func TestVersionAndFeatures(t *testing.T) {
+	semver := regexp.MustCompile(`^v\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+	assert.Regexp(t, semver, goauth.Version())
+
+	plain, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer plain.Close()
+	f := plain.Features()
+	assert.Equal(t, goauth.Version(), f.Version)
+	assert.Equal(t, runtime.Version(), f.GoVersion)
+	assert.Equal(t, "HS256", f.SigningMethod)
+	assert.Equal(t, "memory", f.StorageDriver)
+	assert.Equal(t, []string{"memory"}, f.StorageChain)
+	assert.Equal(t, plain.Capabilities(), f.Capabilities)
+	assert.Empty(t, f.Modules)
+
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(),
+		goauth.WithTokenCache(goauth.NewMemoryTokenCache(100), goauth.CacheOptions{}),
+		goauth.WithSlidingExpiration(time.Hour),
+		goauth.WithSanctumCompatibility(),
+		goauth.WithMinAPIVersion("3"))
+	require.NoError(t, err)
+	defer client.Close()
+	client.SetMaintenanceMode(true, nil)
+
+	f = client.Features()
+	assert.Equal(t, "cache", f.StorageDriver)
+	assert.Equal(t, []string{"cache", "memory"}, f.StorageChain)
+	assert.Equal(t, []string{"api_version_fence", "maintenance_mode", "sanctum_compat", "sliding_expiration"}, f.Modules)
+
+	client.SetMaintenanceMode(false, nil)
+	assert.NotContains(t, client.Features().Modules, "maintenance_mode")
+}
+
+func TestTokenCache(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(),
+		goauth.WithTokenCache(goauth.NewMemoryTokenCache(100), goauth.CacheOptions{TTL: time.Minute}))
+	require.NoError(t, err)
+	defer client.Close()
+
+	cacheStats := func() goauth.DriverStats {
+		for _, s := range client.DriverStats() {
+			if s.Driver == "cache" {
+				return s
+			}
+		}
+		t.Fatal("no cache stats")
+		return goauth.DriverStats{}
+	}
+
+	token, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+
+	// Written through on create, so the first validation is a hit
+	for i := 0; i < 3; i++ {
+		_, err = client.ValidateToken(ctx, token)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, uint64(3), cacheStats().CacheHits)
+	assert.Equal(t, uint64(0), cacheStats().CacheMisses)
+
+	// Unknown hashes are cached as misses
+	bogus := "1|" + strings.Repeat("a", 64)
+	for i := 0; i < 2; i++ {
+		_, err = client.ValidateToken(ctx, bogus)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, uint64(1), cacheStats().CacheMisses)
+
+	// Revocation takes effect immediately
+	require.NoError(t, client.RevokeToken(ctx, token))
+	_, err = client.ValidateToken(ctx, token)
+	assert.Error(t, err)
+}
+
+func TestResidency(t *testing.T) {
+	ctx := context.Background()
+	global, eu, us := goauth.NewMemoryStorage(), goauth.NewMemoryStorage(), goauth.NewMemoryStorage()
+	home := goauth.ResidencyFunc(func(userID int64) (string, error) {
+		switch userID {
+		case 1:
+			return "eu", nil
+		case 2:
+			return "us", nil
+		}
+		return "", nil
+	})
+
+	client, err := goauth.NewClient(goauth.WithStorage(global),
+		goauth.WithResidency(home, map[string]goauth.StorageDriver{"eu": eu, "us": us}))
+	require.NoError(t, err)
+	defer client.Close()
+
+	// Pinned tokens live only in their region and name it in the plaintext
+	euRaw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(euRaw, "eu."))
+	tok, err := client.ValidateToken(ctx, euRaw)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), tok.UserId)
+	_, total, err := global.FindByUser(1, goauth.ListOptions{})
+	require.NoError(t, err)
+	assert.Zero(t, total)
+	_, total, err = client.ListTokens(ctx, 1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+
+	pair, err := client.CreateTokenPair(ctx, &goauth.TokenOptions{UserId: 2})
+	require.NoError(t, err)
+	refreshed, err := client.RefreshToken(ctx, pair.RefreshToken)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(refreshed.AccessToken, "us."))
+	require.NoError(t, client.RevokeToken(ctx, refreshed.AccessToken))
+	_, err = client.ValidateToken(ctx, refreshed.AccessToken)
+	assert.ErrorIs(t, err, goauth.ErrTokenNotFound)
+
+	// Unpinned users keep the default storage and token format
+	plain, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 3})
+	require.NoError(t, err)
+	_, err = client.ValidateToken(ctx, plain)
+	require.NoError(t, err)
+
+	// Guests cannot be promoted into another region's user
+	guest, err := client.CreateGuestToken(ctx, &goauth.TokenOptions{})
+	require.NoError(t, err)
+	_, err = client.PromoteGuestToken(ctx, guest, 1)
+	assert.ErrorIs(t, err, goauth.ErrCrossRegion)
+
+	// A deployment without EU storage rejects EU tokens outright
+	usOnly, err := goauth.NewClient(goauth.WithStorage(global),
+		goauth.WithResidency(home, map[string]goauth.StorageDriver{"us": us}))
+	require.NoError(t, err)
+	defer usOnly.Close()
+	_, err = usOnly.ValidateToken(ctx, euRaw)
+	assert.ErrorIs(t, err, goauth.ErrCrossRegion)
+	_, err = usOnly.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	assert.ErrorIs(t, err, goauth.ErrCrossRegion)
+}
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&apikey.Key{}))
+
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithAPIKeys(apikey.NewGormStore(db)))
+	require.NoError(t, err)
+	defer client.Close()
+
+	key, err := client.CreateAPIKey(ctx, &goauth.APIKeyOptions{UserID: 7, Environment: apikey.Test, Abilities: []string{"orders:*"}})
+	require.NoError(t, err)
+	assert.Regexp(t, apikey.Pattern, key)
+	assert.True(t, strings.HasPrefix(key, "goak_test_"))
+
+	k, err := client.ValidateAPIKey(ctx, "Bearer "+key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), k.UserID)
+	assert.Equal(t, apikey.Test, k.Environment)
+	assert.True(t, k.Can("orders:read"))
+	assert.False(t, k.Can("users:read"))
+
+	// Typos fail the checksum without reaching storage
+	typo := []byte(key)
+	typo[12] = map[bool]byte{true: 'B', false: 'A'}[typo[12] == 'A']
+	_, err = apikey.Parse(string(typo))
+	assert.ErrorIs(t, err, apikey.ErrChecksum)
+	_, err = client.ValidateAPIKey(ctx, string(typo))
+	assert.ErrorIs(t, err, goauth.ErrInvalidFormat)
+
+	// API keys and tokens are not interchangeable
+	_, err = client.ValidateToken(ctx, key)
+	assert.ErrorIs(t, err, goauth.ErrTokenInvalid)
+
+	require.NoError(t, client.RevokeAPIKey(ctx, key))
+	_, err = client.ValidateAPIKey(ctx, key)
+	assert.ErrorIs(t, err, goauth.ErrTokenRevoked)
+
+	other, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithAPIKeys(apikey.NewMemoryStore(), "acme"))
+	require.NoError(t, err)
+	defer other.Close()
+	_, err = other.ValidateAPIKey(ctx, key)
+	assert.ErrorIs(t, err, goauth.ErrInvalidFormat, "keys with another prefix are rejected")
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "archive.jsonl")
+	archive, err := goauth.OpenJSONLArchive(path)
+	require.NoError(t, err)
+	hot := goauth.NewMemoryStorage()
+
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	client, err := goauth.NewClient(goauth.WithStorage(hot), goauth.WithClock(clock), goauth.WithArchive(archive, time.Hour, 0))
+	require.NoError(t, err)
+	defer client.Close()
+
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	other, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 2})
+	require.NoError(t, err)
+	slot, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 2, Name: stringPtr("ci"), Replace: true})
+	require.NoError(t, err)
+	clock.Advance(2 * time.Hour)
+
+	n, err := client.ArchiveInactive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n, "named slots stay hot")
+	hash := func(raw string) string {
+		sum := sha256.Sum256([]byte(strings.SplitN(raw, "|", 2)[1]))
+		return hex.EncodeToString(sum[:])
+	}
+	_, err = hot.FindByHash(hash(raw))
+	assert.ErrorIs(t, err, goauth.ErrTokenNotFound)
+	_, err = hot.FindByHash(hash(slot))
+	require.NoError(t, err)
+
+	// The archive survives a restart and rehydrates on first use
+	require.NoError(t, archive.Close())
+	archive, err = goauth.OpenJSONLArchive(path)
+	require.NoError(t, err)
+	defer archive.Close()
+	client2, err := goauth.NewClient(goauth.WithStorage(hot), goauth.WithArchive(archive, time.Hour, 0))
+	require.NoError(t, err)
+	defer client2.Close()
+
+	tok, err := client2.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), tok.UserId)
+	_, err = hot.FindByHash(hash(raw))
+	assert.NoError(t, err, "rehydrated into the hot store")
+
+	// Bulk revocation reaches archived tokens too
+	_, err = client2.RevokeUserTokens(ctx, 2)
+	require.NoError(t, err)
+	_, err = client2.ValidateToken(ctx, other)
+	assert.ErrorIs(t, err, goauth.ErrTokenNotFound)
+}
+
+func TestMaxTokensPerUser(t *testing.T) {
+	ctx := context.Background()
+
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithMaxTokensPerUser(2))
+	require.NoError(t, err)
+	defer client.Close()
+	for i := 0; i < 2; i++ {
+		_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+		require.NoError(t, err)
+	}
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	assert.ErrorIs(t, err, goauth.ErrTokenLimitReached)
+	_, err = client.CreateTokens(ctx, []*goauth.TokenOptions{{UserId: 2}, {UserId: 2}, {UserId: 2}})
+	assert.ErrorIs(t, err, goauth.ErrTokenLimitReached)
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 2})
+	assert.NoError(t, err, "limits are per user")
+
+	// Evicting drops the token unused for longest
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	lru, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithClock(clock), goauth.WithMaxTokensPerUser(2),
+		goauth.WithTokenLimitPolicy(goauth.TokenLimitEvictLRU), goauth.WithLastUsedTracking(goauth.LastUsedSync))
+	require.NoError(t, err)
+	defer lru.Close()
+	first, err := lru.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	clock.Advance(time.Minute)
+	second, err := lru.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	clock.Advance(time.Minute)
+	_, err = lru.ValidateToken(ctx, first)
+	require.NoError(t, err)
+
+	_, err = lru.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	_, err = lru.ValidateToken(ctx, second)
+	assert.ErrorIs(t, err, goauth.ErrTokenNotFound)
+	_, err = lru.ValidateToken(ctx, first)
+	assert.NoError(t, err)
+}
+
+// plainStorage hides every optional interface of the driver it wraps
+type plainStorage struct {
+	goauth.StorageDriver
+}
+
+func TestCapabilities(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "caps.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	full := goauth.Capabilities{
+		List: true, BulkRevoke: true, TTLEnforcement: true, Upsert: true,
+		SoftRevoke: true, BatchInsert: true, Count: true, Changes: true,
+	}
+	transactional := full
+	transactional.Transactions = true
+
+	for name, tc := range map[string]struct {
+		storage goauth.StorageDriver
+		want    goauth.Capabilities
+	}{
+		"memory": {goauth.NewMemoryStorage(), full},
+		"gorm":   {goauth.NewGormStorage(db), transactional},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, err := goauth.NewClient(goauth.WithStorage(tc.storage))
+			require.NoError(t, err)
+			defer client.Close()
+			assert.Equal(t, tc.want, client.Capabilities())
+
+			// Bulk revocation only reaches the given user's tokens
+			var mine []string
+			for i := 0; i < 2; i++ {
+				raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 10})
+				require.NoError(t, err)
+				mine = append(mine, raw)
+			}
+			theirs, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 11})
+			require.NoError(t, err)
+
+			n, err := client.RevokeUserTokens(ctx, 10)
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), n)
+			for _, raw := range mine {
+				_, err = client.ValidateToken(ctx, raw)
+				assert.ErrorIs(t, err, goauth.ErrTokenNotFound)
+			}
+			_, err = client.ValidateToken(ctx, theirs)
+			require.NoError(t, err)
+		})
+	}
+
+	// Drivers without the optional interfaces advertise listing only and
+	// refuse bulk revocation, soft or not
+	plain, err := goauth.NewClient(goauth.WithStorage(plainStorage{goauth.NewMemoryStorage()}))
+	require.NoError(t, err)
+	defer plain.Close()
+	assert.Equal(t, goauth.Capabilities{List: true}, plain.Capabilities())
+	_, err = plain.RevokeUserTokens(ctx, 10)
+	assert.ErrorIs(t, err, goauth.ErrNotSupported)
+
+	soft, err := goauth.NewClient(goauth.WithStorage(plainStorage{goauth.NewMemoryStorage()}), goauth.WithSoftRevocation())
+	require.NoError(t, err)
+	defer soft.Close()
+	_, err = soft.RevokeUserTokens(ctx, 10)
+	assert.ErrorIs(t, err, goauth.ErrNotSupported)
+
+	// A token set is read-only: listing, but no bulk revocation
+	src, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer src.Close()
+	path := filepath.Join(t.TempDir(), "tokens.gats")
+	_, err = src.ExportTokenSetFile(ctx, path)
+	require.NoError(t, err)
+	edge, err := goauth.NewClient(goauth.WithTokenSetFile(path, 0))
+	require.NoError(t, err)
+	defer edge.Close()
+	assert.Equal(t, goauth.Capabilities{List: true, TTLEnforcement: true}, edge.Capabilities())
+	_, err = edge.RevokeUserTokens(ctx, 10)
+	assert.ErrorIs(t, err, goauth.ErrNotSupported)
+}
+
+func TestTransactionalIssuance(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(goauth.SQLiteDSN(filepath.Join(t.TempDir(), "tx.db"), goauth.SQLiteOptions{})), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	client, err := goauth.NewClient(goauth.WithSQLiteStorage(db, goauth.SQLiteOptions{}), goauth.WithMaxTokensPerUser(3),
+		goauth.WithTokenCache(goauth.NewMemoryTokenCache(100), goauth.CacheOptions{}))
+	require.NoError(t, err)
+	defer client.Close()
+	assert.True(t, client.Capabilities().Transactions)
+	ctx := context.Background()
+
+	// The count and the insert can't interleave with another issuance
+	var wg sync.WaitGroup
+	var created, limited atomic.Int32
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+			switch {
+			case err == nil:
+				created.Add(1)
+			case errors.Is(err, goauth.ErrTokenLimitReached):
+				limited.Add(1)
+			default:
+				t.Errorf("create: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), created.Load())
+	assert.Equal(t, int32(9), limited.Load())
+
+	// Concurrent rotations of one token leave exactly one successor
+	token, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 2})
+	require.NoError(t, err)
+	var rotated atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.RotateToken(ctx, token)
+			switch {
+			case err == nil:
+				rotated.Add(1)
+			case errors.Is(err, goauth.ErrTokenRotated), errors.Is(err, goauth.ErrTokenInvalid):
+			default:
+				t.Errorf("rotate: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), rotated.Load())
+	_, total, err := client.ListTokens(ctx, 2, &goauth.ListOptions{Status: goauth.StatusActive})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	_, err = client.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, goauth.ErrTokenRevoked)
+}
+
+func TestRevocationList(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(goauth.SQLiteDSN(filepath.Join(t.TempDir(), "revocations.db"), goauth.SQLiteOptions{})), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+	ctx := context.Background()
+
+	// Two instances, each with its own in-process token cache
+	newInstance := func(opts ...goauth.Option) *goauth.Client {
+		client, err := goauth.NewClient(append([]goauth.Option{
+			goauth.WithSigningKey("revocation-list"),
+			goauth.WithGormStorage(db),
+			goauth.WithSoftRevocation(),
+			goauth.WithTokenCache(goauth.NewMemoryTokenCache(100), goauth.CacheOptions{TTL: time.Hour}),
+		}, opts...)...)
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	plain := newInstance()
+	listed := newInstance(goauth.WithRevocationList(goauth.RevocationListOptions{Interval: time.Hour}))
+	other := newInstance()
+
+	token, err := other.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	for _, c := range []*goauth.Client{plain, listed} {
+		_, err = c.ValidateToken(ctx, token)
+		require.NoError(t, err)
+	}
+	require.NoError(t, other.RevokeToken(ctx, token))
+
+	_, err = plain.ValidateToken(ctx, token)
+	assert.NoError(t, err, "the stale cache entry still validates")
+	require.NoError(t, listed.SyncRevocations(ctx))
+	_, err = listed.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, goauth.ErrTokenRevoked)
+
+	stats, ok := listed.RevocationListStats()
+	require.True(t, ok)
+	assert.Equal(t, 1, stats.Tokens)
+	assert.NotZero(t, stats.Version)
+	assert.NotZero(t, stats.Cursor.Revision)
+	_, ok = plain.RevocationListStats()
+	assert.False(t, ok)
+
+	// Revocations through the client apply at once
+	second, err := listed.CreateToken(ctx, &goauth.TokenOptions{UserId: 2})
+	require.NoError(t, err)
+	_, err = listed.RevokeUserTokens(ctx, 2)
+	require.NoError(t, err)
+	_, err = listed.ValidateToken(ctx, second)
+	assert.ErrorIs(t, err, goauth.ErrTokenRevoked)
+	stats, _ = listed.RevocationListStats()
+	assert.Equal(t, 1, stats.Users)
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithRevocationList(goauth.RevocationListOptions{Interval: -1}))
+	assert.Error(t, err)
+}
+
+type busyLocker struct{}
+
+func (busyLocker) TryLock(context.Context, string, time.Duration) (func(), bool, error) {
+	return nil, false, nil
+}
+
+func TestStorageMaintenance(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "tokens.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+	archive, err := goauth.OpenJSONLArchive(filepath.Join(dir, "archive.jsonl"))
+	require.NoError(t, err)
+	defer archive.Close()
+
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	client, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithClock(clock), goauth.WithArchive(archive, time.Hour, 0))
+	require.NoError(t, err)
+	defer client.Close()
+
+	var tokens []string
+	for i := 0; i < 3; i++ {
+		raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+		require.NoError(t, err)
+		tokens = append(tokens, raw)
+	}
+	clock.Advance(2 * time.Hour)
+	_, err = client.ArchiveInactive(ctx)
+	require.NoError(t, err)
+	require.NoError(t, client.RevokeToken(ctx, tokens[0]))
+
+	before, err := os.Stat(filepath.Join(dir, "archive.jsonl"))
+	require.NoError(t, err)
+	require.NoError(t, client.Maintain(ctx))
+	after, err := os.Stat(filepath.Join(dir, "archive.jsonl"))
+	require.NoError(t, err)
+	assert.Less(t, after.Size(), before.Size(), "tombstones compacted away")
+
+	_, err = client.ValidateToken(ctx, tokens[1])
+	assert.NoError(t, err, "archived tokens survive compaction")
+
+	locked, err := goauth.NewClient(goauth.WithGormStorage(db),
+		goauth.WithStorageMaintenance(time.Hour, goauth.MaintainOptions{Lock: busyLocker{}}))
+	require.NoError(t, err)
+	defer locked.Close()
+	assert.Error(t, locked.Maintain(ctx), "another instance holds the lock")
+}
+
+// slowStorage delays lookups while slow is set
+type slowStorage struct {
+	goauth.StorageDriver
+	slow atomic.Bool
+}
+
+func (s *slowStorage) FindByHash(hash string) (*goauth.PersonalAccessToken, error) {
+	if s.slow.Load() {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return s.StorageDriver.FindByHash(hash)
+}
+
+func TestTracing(t *testing.T) {
+	ctx := context.Background()
+	rec := tracetest.NewSpanRecorder()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(),
+		goauth.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))))
+	require.NoError(t, err)
+	defer client.Close()
+
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 7})
+	require.NoError(t, err)
+	tok, err := client.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	_, err = client.ValidateToken(ctx, "999|unknown")
+	require.Error(t, err)
+
+	attrs := func(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+	var validations, lookups []sdktrace.ReadOnlySpan
+	for _, span := range rec.Ended() {
+		switch span.Name() {
+		case "goauth.ValidateToken":
+			validations = append(validations, span)
+		case "goauth.storage.FindByHash":
+			lookups = append(lookups, span)
+		}
+	}
+	require.Len(t, validations, 2)
+	require.Len(t, lookups, 2)
+
+	ok, failed := validations[0], validations[1]
+	assert.Equal(t, codes.Unset, ok.Status().Code)
+	assert.Equal(t, "ok", attrs(ok)["goauth.outcome"].AsString())
+	assert.Equal(t, int64(7), attrs(ok)["goauth.user_id"].AsInt64())
+	assert.Equal(t, tok.ID, attrs(ok)["goauth.token.id"].AsInt64())
+	assert.NotContains(t, attrs(ok)["goauth.token.hash"].AsString(), raw[strings.Index(raw, "|")+1:])
+	assert.Equal(t, ok.SpanContext().SpanID(), lookups[0].Parent().SpanID(), "storage spans nest under the operation")
+
+	assert.Equal(t, codes.Error, failed.Status().Code)
+	assert.NotEmpty(t, failed.Status().Description)
+	assert.Equal(t, "error", attrs(failed)["goauth.outcome"].AsString())
+	require.NotEmpty(t, failed.Events())
+	assert.Equal(t, "exception", failed.Events()[0].Name)
+	assert.Equal(t, codes.Error, lookups[1].Status().Code)
+	assert.Equal(t, failed.SpanContext().SpanID(), lookups[1].Parent().SpanID())
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithTracerProvider(nil))
+	assert.Error(t, err)
+}
+
+func TestValidationBudget(t *testing.T) {
+	ctx := context.Background()
+	store := &slowStorage{StorageDriver: goauth.NewMemoryStorage()}
+
+	client, err := goauth.NewClient(goauth.WithStorage(store), goauth.WithValidationBudget(20*time.Millisecond))
+	require.NoError(t, err)
+	defer client.Close()
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	_, err = client.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+
+	store.slow.Store(true)
+	start := time.Now()
+	_, err = client.ValidateToken(ctx, raw)
+	assert.ErrorIs(t, err, goauth.ErrValidationTimeout)
+	assert.NotErrorIs(t, err, goauth.ErrTokenInvalid)
+	assert.Less(t, time.Since(start), 80*time.Millisecond)
+
+	// With a fallback, recent results are served until the token is revoked
+	cached, err := goauth.NewClient(goauth.WithStorage(store), goauth.WithValidationBudget(20*time.Millisecond),
+		goauth.WithValidationFallback(100, time.Minute))
+	require.NoError(t, err)
+	defer cached.Close()
+	store.slow.Store(false)
+	_, err = cached.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+
+	store.slow.Store(true)
+	tok, err := cached.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), tok.UserId)
+
+	require.NoError(t, cached.RevokeToken(ctx, raw))
+	_, err = cached.ValidateToken(ctx, raw)
+	assert.ErrorIs(t, err, goauth.ErrValidationTimeout)
+}
+
+// panickingStorage panics when recording a token's last use
+type panickingStorage struct {
+	goauth.StorageDriver
+}
+
+func (panickingStorage) TouchLastUsed(int64) error {
+	panic("touch exploded")
+}
+
+func TestBackgroundErrors(t *testing.T) {
+	ctx := context.Background()
+	next := func(t *testing.T, client *goauth.Client) error {
+		t.Helper()
+		select {
+		case err := <-client.Errors():
+			return err
+		case <-time.After(time.Second):
+			t.Fatal("no background error reported")
+			return nil
+		}
+	}
+
+	store := goauthtest.NewDriver()
+	client, err := goauth.NewClient(goauth.WithStorage(store))
+	require.NoError(t, err)
+	defer client.Close()
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+
+	store.FailOn("TouchLastUsed", errors.New("disk full"))
+	_, err = client.ValidateToken(ctx, raw)
+	require.NoError(t, err, "background failures don't fail validation")
+	err = next(t, client)
+	assert.ErrorContains(t, err, "touch-last-used")
+	assert.ErrorContains(t, err, "disk full")
+
+	panicky, err := goauth.NewClient(goauth.WithStorage(panickingStorage{goauth.NewMemoryStorage()}))
+	require.NoError(t, err)
+	defer panicky.Close()
+	raw, err = panicky.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+
+	for range 2 {
+		_, err = panicky.ValidateToken(ctx, raw)
+		require.NoError(t, err, "the panic is recovered and the client keeps working")
+		var perr *goauth.PanicError
+		require.ErrorAs(t, next(t, panicky), &perr)
+		assert.Equal(t, "touch-last-used", perr.Worker)
+		assert.Equal(t, "touch exploded", perr.Value)
+		assert.Contains(t, string(perr.Stack), "TouchLastUsed")
+	}
+}
+
+func TestLastUsedTracking(t *testing.T) {
+	ctx := context.Background()
+
+	direct, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithLastUsedTracking(goauth.LastUsedSync))
+	require.NoError(t, err)
+	defer direct.Close()
+	raw, err := direct.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	_, err = direct.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	info, err := direct.GetTokenInfo(ctx, raw)
+	require.NoError(t, err)
+	assert.NotNil(t, info.LastUsedAt, "written before ValidateToken returns")
+
+	off, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithLastUsedTracking(goauth.LastUsedOff))
+	require.NoError(t, err)
+	defer off.Close()
+	raw, err = off.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	_, err = off.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	require.NoError(t, off.Close())
+	info, err = off.GetTokenInfo(ctx, raw)
+	require.NoError(t, err)
+	assert.Nil(t, info.LastUsedAt)
+
+	// Batched writes are held until the flush interval or Close
+	batched, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithLastUsedTracking(goauth.LastUsedBatched, time.Hour))
+	require.NoError(t, err)
+	raw, err = batched.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = batched.ValidateToken(ctx, raw)
+		require.NoError(t, err)
+	}
+	info, err = batched.GetTokenInfo(ctx, raw)
+	require.NoError(t, err)
+	assert.Nil(t, info.LastUsedAt)
+	require.NoError(t, batched.Close())
+	info, err = batched.GetTokenInfo(ctx, raw)
+	require.NoError(t, err)
+	assert.NotNil(t, info.LastUsedAt, "flushed on Close")
+}
+
+func BenchmarkValidateToken(b *testing.B) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithLastUsedTracking(goauth.LastUsedOff))
+	require.NoError(b, err)
+	defer client.Close()
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Abilities: []string{"read"}})
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.ValidateToken(ctx, raw); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkValidateTokenConcurrent validates tokens picked at random from
+// 100k stored in memory from every CPU and reports the p99 latency. With
+// LastUsedSync each validation also writes, as under the async default.
+func BenchmarkValidateTokenConcurrent(b *testing.B) {
+	for name, mode := range map[string]goauth.LastUsedMode{"reads": goauth.LastUsedOff, "touching": goauth.LastUsedSync} {
+		b.Run(name, func(b *testing.B) {
+			ctx := context.Background()
+			client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithLastUsedTracking(mode))
+			require.NoError(b, err)
+			defer client.Close()
+
+			const stored = 100_000
+			tokens := make([]string, 0, stored)
+			for len(tokens) < stored {
+				opts := make([]*goauth.TokenOptions, 1000)
+				for i := range opts {
+					opts[i] = &goauth.TokenOptions{UserId: int64(len(tokens)/10 + i + 1)}
+				}
+				batch, err := client.CreateTokens(ctx, opts)
+				require.NoError(b, err)
+				tokens = append(tokens, batch...)
+			}
+
+			var mu sync.Mutex
+			var latencies []time.Duration
+			var seed atomic.Int64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				next := seed.Add(1) * 7919
+				var local []time.Duration
+				for pb.Next() {
+					next = (next*1103515245 + 12345) & 0x7fffffff
+					start := time.Now()
+					if _, err := client.ValidateToken(ctx, tokens[next%stored]); err != nil {
+						b.Error(err)
+						return
+					}
+					local = append(local, time.Since(start))
+				}
+				mu.Lock()
+				latencies = append(latencies, local...)
+				mu.Unlock()
+			})
+			b.StopTimer()
+
+			if len(latencies) > 0 {
+				slices.Sort(latencies)
+				b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+			}
+		})
+	}
+}
+
+func BenchmarkValidateTokenWithAbility(b *testing.B) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithLastUsedTracking(goauth.LastUsedOff),
+		goauth.WithRateLimiter(ratelimit.NewMemory(10, time.Second)))
+	require.NoError(b, err)
+	defer client.Close()
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Abilities: []string{"read:posts", "write:posts", "read:users"}})
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.ValidateTokenWithAbility(ctx, raw, "read:users"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTokenCanCachesAbilities(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Abilities: []string{"read:*", "write:posts"}})
+	require.NoError(t, err)
+	tok, err := client.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+
+	assert.True(t, client.TokenCan(tok, "read:users"))
+	assert.True(t, client.TokenCan(tok, "write:posts"))
+	assert.False(t, client.TokenCan(tok, "write:users"))
+	assert.Zero(t, testing.AllocsPerRun(100, func() { client.TokenCan(tok, "write:posts") }))
+
+	// Changing the abilities invalidates the parsed set
+	tok.Abilities = "write:users"
+	assert.True(t, client.TokenCan(tok, "write:users"))
+	assert.False(t, client.TokenCan(tok, "read:users"))
+}
+
+func BenchmarkTokenCan(b *testing.B) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(b, err)
+	defer client.Close()
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Abilities: []string{"read:posts", "write:posts", "read:users", "admin:*"}})
+	require.NoError(b, err)
+	tok, err := client.ValidateToken(ctx, raw)
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !client.TokenCan(tok, "admin:billing") {
+			b.Fatal("ability denied")
+		}
+	}
+}
+
+func TestTokenEntropy(t *testing.T) {
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, 256, client.TokenEntropy())
+
+	client, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithTokenEntropyBits(200))
+	require.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, 200, client.TokenEntropy())
+	raw, err := client.CreateToken(context.Background(), &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	_, secret, _ := strings.Cut(raw, "|")
+	assert.GreaterOrEqual(t, len(secret), 50, "25 random bytes, hex encoded")
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithTokenEntropyBits(64))
+	assert.Error(t, err)
+}
+
+func TestTokenChecksum(t *testing.T) {
+	ctx := context.Background()
+	random := func(raw string, prefix string, n int) (string, string) {
+		_, secret, _ := strings.Cut(raw, "|")
+		secret = strings.TrimPrefix(secret, prefix)
+		return secret[:len(secret)-n], secret[len(secret)-n:]
+	}
+
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithTokenPrefix("acme_"))
+	require.NoError(t, err)
+	defer client.Close()
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	body, sum := random(raw, "acme_", 8)
+	assert.Len(t, body, 64)
+	assert.Equal(t, fmt.Sprintf("%08x", crc32.Checksum([]byte(body), crc32.MakeTable(crc32.Castagnoli))), sum)
+
+	key := []byte("scanner-key")
+	hmacClient, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithTokenChecksum(goauth.ChecksumHMAC, 12, key))
+	require.NoError(t, err)
+	defer hmacClient.Close()
+	raw, err = hmacClient.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	body, sum = random(raw, "", 12)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(body))
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil))[:12], sum)
+	_, err = hmacClient.ValidateToken(ctx, raw)
+	assert.NoError(t, err)
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithTokenChecksum(goauth.ChecksumHMAC, 0))
+	assert.Error(t, err, "HMAC needs a key")
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithTokenChecksum(goauth.ChecksumCRC32C, 12))
+	assert.Error(t, err, "longer than the checksum")
+}
+
+func TestRoles(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "roles.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}, &goauth.Role{}))
+
+	client, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithRoles(0))
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.CreateRole(ctx, "editor", []string{"posts:read", "posts:write"})
+	require.NoError(t, err)
+	_, err = client.CreateRole(ctx, "admin", []string{"role:editor"})
+	assert.Error(t, err, "roles cannot nest")
+
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Abilities: []string{"profile"}, Roles: []string{"editor"}})
+	require.NoError(t, err)
+	_, err = client.ValidateTokenWithAbility(ctx, raw, "posts:write")
+	assert.NoError(t, err)
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Roles: []string{"ghost"}})
+	assert.ErrorIs(t, err, goauth.ErrRoleNotFound)
+
+	// Role changes apply to existing tokens
+	_, err = client.CreateRole(ctx, "editor", []string{"posts:read"})
+	require.NoError(t, err)
+	_, err = client.ValidateTokenWithAbility(ctx, raw, "posts:write")
+	assert.Error(t, err)
+
+	_, err = client.CreateRole(ctx, "billing", []string{"invoices:*"})
+	require.NoError(t, err)
+	require.NoError(t, client.AssignRole(ctx, raw, "billing"))
+	require.NoError(t, client.AssignRole(ctx, raw, "billing"))
+	info, err := client.GetTokenInfo(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, "profile,role:editor,role:billing", info.Abilities)
+	_, err = client.ValidateTokenWithAbility(ctx, raw, "invoices:pay")
+	assert.NoError(t, err)
+
+	// Other instances see roles at startup
+	other, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithRoles(time.Minute))
+	require.NoError(t, err)
+	defer other.Close()
+	roles, err := other.ListRoles(ctx)
+	require.NoError(t, err)
+	assert.Len(t, roles, 2)
+	_, err = other.ValidateTokenWithAbility(ctx, raw, "invoices:pay")
+	assert.NoError(t, err)
+
+	require.NoError(t, client.DeleteRole(ctx, "billing"))
+	_, err = client.ValidateTokenWithAbility(ctx, raw, "invoices:pay")
+	assert.Error(t, err)
+	assert.ErrorIs(t, client.DeleteRole(ctx, "billing"), goauth.ErrRoleNotFound)
+}
+
+func TestAbilityMatcher(t *testing.T) {
+	ctx := context.Background()
+	tree := &goauth.TreeMatcher{Implies: map[string][]string{
+		"admin":  {"*"},
+		"editor": {"posts:*", "reviewer"},
+		// Cycles are cut
+		"reviewer": {"comments:*:read", "editor"},
+	}}
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithAbilityMatcher(tree))
+	require.NoError(t, err)
+	defer client.Close()
+
+	tok := &goauth.PersonalAccessToken{Abilities: "posts:*"}
+	assert.True(t, client.TokenCan(tok, "posts:read"))
+	assert.True(t, client.TokenCan(tok, "posts:comments:delete"))
+	assert.False(t, client.TokenCan(tok, "posts"))
+	assert.False(t, client.TokenCan(tok, "postsecret"))
+
+	tok = &goauth.PersonalAccessToken{Abilities: "editor"}
+	assert.True(t, client.TokenCan(tok, "posts:publish"))
+	assert.True(t, client.TokenCan(tok, "comments:42:read"))
+	assert.False(t, client.TokenCan(tok, "comments:42:delete"))
+	assert.False(t, client.TokenCan(tok, "users:read"))
+
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Abilities: []string{"admin"}})
+	require.NoError(t, err)
+	_, err = client.ValidateTokenWithAbility(ctx, raw, "billing:refund")
+	assert.NoError(t, err)
+
+	// The default glob matcher is a plain prefix match
+	glob, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer glob.Close()
+	assert.True(t, glob.TokenCan(&goauth.PersonalAccessToken{Abilities: "posts*"}, "postsecret"))
+	assert.False(t, glob.TokenCan(&goauth.PersonalAccessToken{Abilities: "editor"}, "posts:read"))
+}
+
+func TestTokenFormat(t *testing.T) {
+	client, err := goauth.NewClient(
+		goauth.WithMemoryStorage(),
+		goauth.WithTokenPrefix("acme_pat_"),
+		goauth.WithTokenChecksum(goauth.ChecksumXXHash, 12),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	raw, err := client.CreateToken(context.Background(), &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+
+	f := client.TokenFormat()
+	tok, err := f.Decode("Bearer " + raw)
+	require.NoError(t, err)
+	assert.Equal(t, "acme_pat_", tok.Prefix)
+	assert.Len(t, tok.Random, 64)
+	assert.Len(t, tok.Checksum, 12)
+	assert.Equal(t, raw, tokenformat.Encode(tok.Locator, tok.Secret))
+
+	// A typo in the random part fails the checksum, other damage the grammar
+	typo := []byte(raw)
+	if i := strings.Index(raw, "acme_pat_") + len("acme_pat_"); typo[i] == '0' {
+		typo[i] = '1'
+	} else {
+		typo[i] = '0'
+	}
+	_, err = f.Decode(string(typo))
+	assert.ErrorIs(t, err, tokenformat.ErrChecksum)
+	_, err = f.Decode(strings.Replace(raw, "acme_pat_", "other_", 1))
+	assert.ErrorIs(t, err, tokenformat.ErrMalformed)
+	_, err = f.Decode(raw[:len(raw)-1])
+	assert.ErrorIs(t, err, tokenformat.ErrMalformed)
+
+	// Inspect needs no format
+	seen, err := tokenformat.Inspect(raw)
+	require.NoError(t, err)
+	assert.Equal(t, tok.Locator, seen.Locator)
+	assert.Equal(t, "acme_pat_", seen.Prefix)
+	_, err = tokenformat.Inspect("no separator")
+	assert.ErrorIs(t, err, tokenformat.ErrMalformed)
+
+	// The zero Format is the default: 32 random bytes and a full CRC-32C
+	zero := make([]byte, 32)
+	body := strings.Repeat("0", 64)
+	assert.Equal(t, body+fmt.Sprintf("%08x", crc32.Checksum([]byte(body), crc32.MakeTable(crc32.Castagnoli))), tokenformat.Format{}.Secret(zero))
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithTokenPrefix("acme pat"))
+	assert.Error(t, err)
+}
+
+func TestInspect(t *testing.T) {
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithTokenPrefix("acme_pat_"))
+	require.NoError(t, err)
+	defer client.Close()
+
+	raw, err := client.CreateToken(context.Background(), &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	loc, secret, _ := strings.Cut(raw, "|")
+
+	facts, err := client.Inspect("Bearer " + raw)
+	require.NoError(t, err)
+	assert.Equal(t, "acme_pat_", facts.Prefix)
+	assert.Equal(t, tokenformat.Version, facts.Version)
+	assert.Equal(t, loc, facts.Locator)
+	assert.Equal(t, loc, strconv.FormatInt(facts.ID, 10))
+	assert.Equal(t, "crc32c", facts.Checksum)
+	assert.True(t, facts.ChecksumValid)
+	out, err := json.Marshal(facts)
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), secret[len("acme_pat_"):len("acme_pat_")+8])
+
+	// A typo keeps the shape but fails the checksum
+	typo := raw[:len(raw)-1] + "0"
+	if raw[len(raw)-1] == '0' {
+		typo = raw[:len(raw)-1] + "1"
+	}
+	facts, err = client.Inspect(typo)
+	require.NoError(t, err)
+	assert.Equal(t, tokenformat.Version, facts.Version)
+	assert.False(t, facts.ChecksumValid)
+
+	// The package function assumes the default format
+	facts, err = goauth.Inspect(raw)
+	require.NoError(t, err)
+	assert.Zero(t, facts.Version)
+	assert.Empty(t, facts.Prefix)
+	assert.False(t, facts.ChecksumValid)
+
+	// Foreign tokens reveal nothing of their secret
+	facts, err = goauth.Inspect("eu.7|Zq3" + strings.Repeat("a", 40))
+	require.NoError(t, err)
+	assert.Equal(t, "eu", facts.Region)
+	assert.EqualValues(t, 7, facts.ID)
+	assert.Empty(t, facts.Prefix)
+
+	_, err = goauth.Inspect("not a token")
+	assert.ErrorIs(t, err, goauth.ErrInvalidFormat)
+}
+
+func TestTokenHasher(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "hashes.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	legacy, err := goauth.NewClient(goauth.WithGormStorage(db))
+	require.NoError(t, err)
+	defer legacy.Close()
+	raw, err := legacy.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	stored := func() string {
+		tok, err := legacy.GetTokenInfo(ctx, raw)
+		require.NoError(t, err)
+		return tok.Token
+	}
+	assert.Len(t, stored(), 64)
+
+	// Switching to hash strings rehashes rows as they are used
+	sha, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithTokenHasher(phc.SHA256))
+	require.NoError(t, err)
+	defer sha.Close()
+	_, err = sha.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	tok, err := sha.GetTokenInfo(ctx, raw)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(tok.Token, "$sha256$"))
+
+	salted, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithTokenHasher(phc.PBKDF2(1000)))
+	require.NoError(t, err)
+	defer salted.Close()
+	_, err = salted.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	tok, err = salted.GetTokenInfo(ctx, raw)
+	require.NoError(t, err)
+	h, err := phc.Parse(tok.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "pbkdf2-sha256", h.ID)
+	iter, _ := h.Param("i")
+	assert.Equal(t, 1000, iter)
+
+	loc, _, _ := strings.Cut(raw, "|")
+	_, err = salted.ValidateToken(ctx, loc+"|"+strings.Repeat("0", 72))
+	assert.ErrorIs(t, err, goauth.ErrTokenInvalid)
+	require.NoError(t, salted.RevokeToken(ctx, raw))
+	_, err = salted.ValidateToken(ctx, raw)
+	assert.Error(t, err)
+
+	// New salted tokens never produce the same string twice
+	raw2, err := salted.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	_, err = salted.ValidateToken(ctx, raw2)
+	require.NoError(t, err)
+
+	argon := "$argon2id$v=19$m=65536,t=3,p=4$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG"
+	h, err = phc.Parse(argon)
+	require.NoError(t, err)
+	assert.Equal(t, 19, h.Version)
+	m, _ := h.Param("m")
+	assert.Equal(t, 65536, m)
+	assert.Equal(t, argon, h.String())
+	_, err = phc.Verify("secret", argon)
+	assert.ErrorIs(t, err, phc.ErrUnknown)
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithTokenHasher(phc.PBKDF2(0)), goauth.WithLocator(goauth.HashPrefixLocator(12)))
+	assert.Error(t, err)
+}
+
+func TestHashPepper(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "pepper.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	legacy, err := goauth.NewClient(goauth.WithGormStorage(db))
+	require.NoError(t, err)
+	defer legacy.Close()
+	raw, err := legacy.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	_, secret, _ := strings.Cut(raw, "|")
+
+	oldPepper := []byte("0123456789abcdef-old")
+	newPepper := []byte("0123456789abcdef-new")
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithHashPepper([]byte("short")))
+	assert.Error(t, err)
+
+	// Unpeppered rows are rehashed on first use
+	peppered, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithHashPepper(oldPepper))
+	require.NoError(t, err)
+	defer peppered.Close()
+	_, err = peppered.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	tok, err := peppered.GetTokenInfo(ctx, raw)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(tok.Token, "$hmac-sha256$k="), tok.Token)
+	sum := sha256.Sum256([]byte(secret))
+	assert.NotContains(t, tok.Token, hex.EncodeToString(sum[:]))
+	first := tok.Token
+
+	// Without the pepper the row is useless
+	_, err = legacy.ValidateToken(ctx, raw)
+	assert.Error(t, err)
+	other, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithHashPepper([]byte("0123456789abcdef-bad")))
+	require.NoError(t, err)
+	defer other.Close()
+	_, err = other.ValidateToken(ctx, raw)
+	assert.Error(t, err)
+
+	// After a rotation the previous pepper still validates and is replaced
+	rotated, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithHashPepper(newPepper, oldPepper))
+	require.NoError(t, err)
+	defer rotated.Close()
+	_, err = rotated.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	tok, err = rotated.GetTokenInfo(ctx, raw)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(tok.Token, "$hmac-sha256$k="))
+	assert.NotEqual(t, first, tok.Token)
+	_, err = peppered.ValidateToken(ctx, raw)
+	assert.Error(t, err, "the old pepper alone no longer matches")
+
+	fresh, err := rotated.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	_, err = rotated.ValidateToken(ctx, fresh)
+	require.NoError(t, err)
+	loc, _, _ := strings.Cut(fresh, "|")
+	_, err = rotated.ValidateToken(ctx, loc+"|"+strings.Repeat("0", len(secret)))
+	assert.Error(t, err)
+
+	// Once the migration window is closed, unpeppered rows stop validating
+	// and are left as they are; peppered ones, current or previous, don't
+	unmigrated, err := legacy.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	previous, err := peppered.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	strict, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithHashPepper(newPepper, oldPepper),
+		goauth.WithLegacyHashes(false))
+	require.NoError(t, err)
+	defer strict.Close()
+	_, err = strict.ValidateToken(ctx, unmigrated)
+	assert.ErrorIs(t, err, goauth.ErrTokenInvalid)
+	_, err = legacy.ValidateToken(ctx, unmigrated)
+	require.NoError(t, err, "the row wasn't rehashed")
+	_, err = strict.ValidateToken(ctx, fresh)
+	require.NoError(t, err)
+	_, err = strict.ValidateToken(ctx, previous)
+	require.NoError(t, err)
+
+	// The same goes for rows found by ID under a salted hasher
+	salted, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithTokenHasher(phc.PBKDF2(1000)),
+		goauth.WithLegacyHashes(false))
+	require.NoError(t, err)
+	defer salted.Close()
+	_, err = salted.ValidateToken(ctx, unmigrated)
+	assert.ErrorIs(t, err, goauth.ErrTokenInvalid)
+	_, err = legacy.ValidateToken(ctx, unmigrated)
+	require.NoError(t, err)
+}
+
+func TestFieldEncryption(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "fields.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+	rawMeta := func(id int64) string {
+		var meta string
+		require.NoError(t, db.Raw("SELECT metadata FROM personal_access_tokens WHERE id = ?", id).Scan(&meta).Error)
+		return meta
+	}
+
+	plain, err := goauth.NewClient(goauth.WithGormStorage(db))
+	require.NoError(t, err)
+	defer plain.Close()
+	legacy, err := plain.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Metadata: map[string]string{"team": "legacy"}})
+	require.NoError(t, err)
+
+	k1 := goauth.FieldKey{ID: "k1", Key: bytes.Repeat([]byte{1}, 32)}
+	k2 := goauth.FieldKey{ID: "k2", Key: bytes.Repeat([]byte{2}, 32)}
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithFieldEncryption(goauth.FieldKey{ID: "short", Key: []byte("x")}))
+	assert.Error(t, err)
+
+	first, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithFieldEncryption(k1))
+	require.NoError(t, err)
+	defer first.Close()
+	raw, err := first.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Metadata: map[string]string{"team": "payments"}})
+	require.NoError(t, err)
+	tok, err := first.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, "payments", tok.Metadata["team"])
+	assert.Contains(t, rawMeta(tok.ID), "v1:k1:")
+	assert.NotContains(t, rawMeta(tok.ID), "payments")
+
+	// Rows written before encryption are read as they are
+	old, err := first.ValidateToken(ctx, legacy)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", old.Metadata["team"])
+	sealed, err := plain.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	assert.NotContains(t, sealed.Metadata, "team", "without the key metadata stays sealed")
+
+	// A ciphertext is bound to its row
+	require.NoError(t, db.Exec("UPDATE personal_access_tokens SET metadata = ? WHERE id = ?", rawMeta(tok.ID), old.ID).Error)
+	_, err = first.ValidateToken(ctx, legacy)
+	assert.Error(t, err)
+	require.NoError(t, db.Exec("UPDATE personal_access_tokens SET metadata = ? WHERE id = ?", `{"team":"legacy"}`, old.ID).Error)
+
+	// Rotation: the previous key still opens rows until they are re-encrypted
+	second, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithFieldEncryption(k2, k1))
+	require.NoError(t, err)
+	defer second.Close()
+	tok, err = second.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, "payments", tok.Metadata["team"])
+	n, err := second.ReencryptFields(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, n)
+	assert.Contains(t, rawMeta(tok.ID), "v1:k2:")
+	assert.Contains(t, rawMeta(old.ID), "v1:k2:")
+	n, err = second.ReencryptFields(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	_, err = first.ValidateToken(ctx, raw)
+	assert.ErrorIs(t, err, goauth.ErrUnknownFieldKey)
+	only, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithFieldEncryption(k2))
+	require.NoError(t, err)
+	defer only.Close()
+	tok, err = only.ValidateToken(ctx, legacy)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", tok.Metadata["team"])
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	secret := []byte("webhook-secret")
+
+	var mu sync.Mutex
+	var received []webhook.Payload
+	var ids []string
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify(secret, r.Header.Get(webhook.SignatureHeader), body, time.Now(), time.Minute); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, r.Header.Get(webhook.DeliveryHeader))
+		if fail {
+			fail = false
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		var p webhook.Payload
+		_ = json.Unmarshal(body, &p)
+		received = append(received, p)
+	}))
+	defer srv.Close()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "webhooks.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&webhook.Delivery{}))
+	now := time.Now()
+	clock := func() time.Time { return now }
+	hooks, err := webhook.New(webhook.NewGormStore(db), webhook.Options{Backoff: time.Minute, MaxAttempts: 2, Now: clock},
+		webhook.Endpoint{ID: "siem", URL: srv.URL, Secret: secret},
+		webhook.Endpoint{ID: "created-only", URL: srv.URL + "/404", Secret: secret, Events: []string{siem.TokenCreated}})
+	require.NoError(t, err)
+	_, err = webhook.New(webhook.NewMemoryStore(), webhook.Options{}, webhook.Endpoint{ID: "x", URL: srv.URL})
+	assert.Error(t, err, "endpoints need a secret")
+
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithWebhooks(hooks, time.Hour))
+	require.NoError(t, err)
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 7})
+	require.NoError(t, err)
+	_, err = client.ValidateToken(ctx, "1|not-a-real-secret")
+	require.Error(t, err)
+	require.NoError(t, client.RevokeToken(ctx, raw))
+	client.Close() // Flushes the event queue into the delivery log
+
+	pending, err := hooks.Deliveries(ctx, "", webhook.Pending, 0)
+	require.NoError(t, err)
+	assert.Len(t, pending, 3, "created and revoked for siem, created for created-only")
+
+	// The first attempt to siem fails and is retried after the backoff
+	sent, err := hooks.Deliver(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 1, sent)
+	sent, err = hooks.Deliver(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, sent, "nothing is due before the backoff ends")
+	now = now.Add(time.Minute)
+	sent, err = hooks.Deliver(ctx)
+	require.Error(t, err, "created-only keeps failing")
+	assert.Equal(t, 1, sent)
+
+	mu.Lock()
+	require.Len(t, received, 2)
+	types := []string{received[0].Type, received[1].Type}
+	assert.ElementsMatch(t, []string{siem.TokenCreated, siem.TokenRevoked}, types)
+	for _, p := range received {
+		assert.EqualValues(t, 7, p.Event.UserID)
+		assert.NotContains(t, p.Event.Locator, "|")
+	}
+	assert.Len(t, ids, 3)
+	assert.Contains(t, ids[1:], ids[0], "a retry keeps the event ID")
+	mu.Unlock()
+
+	failed, err := hooks.Deliveries(ctx, "created-only", webhook.Failed, 0)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, 2, failed[0].Attempts)
+	assert.Equal(t, http.StatusNotFound, failed[0].LastStatus)
+	delivered, err := hooks.Deliveries(ctx, "siem", webhook.Delivered, 0)
+	require.NoError(t, err)
+	assert.Len(t, delivered, 2)
+
+	body := []byte(`{"id":"x"}`)
+	header := webhook.Sign(secret, now, body)
+	assert.NoError(t, webhook.Verify(secret, header, body, now, time.Minute))
+	assert.ErrorIs(t, webhook.Verify(secret, header, []byte(`{"id":"y"}`), now, time.Minute), webhook.ErrBadSignature)
+	assert.ErrorIs(t, webhook.Verify([]byte("other"), header, body, now, time.Minute), webhook.ErrBadSignature)
+	assert.ErrorIs(t, webhook.Verify(secret, header, body, now.Add(time.Hour), time.Minute), webhook.ErrStale)
+}
+
+func TestValidateTokenDetailed(t *testing.T) {
+	ctx := context.Background()
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithClock(clock), goauth.WithRotationGracePeriod(time.Hour))
+	require.NoError(t, err)
+	defer client.Close()
+
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Abilities: []string{"read:posts", " write:posts"}, ExpiresIn: 10 * time.Hour})
+	require.NoError(t, err)
+	res, err := client.ValidateTokenDetailed(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"read:posts", "write:posts"}, res.Abilities)
+	assert.Equal(t, 10*time.Hour, res.ExpiresIn)
+	assert.False(t, res.NearExpiry)
+	assert.False(t, res.ShouldRotate)
+
+	// The default threshold is a fifth of the lifetime
+	clock.Advance(8 * time.Hour)
+	res, err = client.ValidateTokenDetailed(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, res.ExpiresIn)
+	assert.True(t, res.NearExpiry)
+	assert.True(t, res.ShouldRotate)
+
+	_, err = client.RotateToken(ctx, raw)
+	require.NoError(t, err)
+	res, err = client.ValidateTokenDetailed(ctx, raw)
+	require.NoError(t, err)
+	assert.True(t, res.NearExpiry)
+	assert.False(t, res.ShouldRotate, "a rotated token already has a successor")
+
+	forever, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, ExpiresIn: goauth.NoExpiry})
+	require.NoError(t, err)
+	res, err = client.ValidateTokenDetailed(ctx, forever)
+	require.NoError(t, err)
+	assert.Zero(t, res.ExpiresIn)
+	assert.False(t, res.NearExpiry)
+
+	fixed, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithClock(clock), goauth.WithNearExpiryThreshold(time.Hour))
+	require.NoError(t, err)
+	defer fixed.Close()
+	raw, err = fixed.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, ExpiresIn: 90 * time.Minute})
+	require.NoError(t, err)
+	res, err = fixed.ValidateTokenDetailed(ctx, raw)
+	require.NoError(t, err)
+	assert.False(t, res.NearExpiry)
+	clock.Advance(time.Hour)
+	res, err = fixed.ValidateTokenDetailed(ctx, raw)
+	require.NoError(t, err)
+	assert.True(t, res.ShouldRotate)
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithNearExpiryThreshold(0))
+	assert.Error(t, err)
+}
+
+func TestPasswordLogin(t *testing.T) {
+	ctx := goauth.ContextWithClientIP(context.Background(), "203.0.113.9")
+	var mu sync.Mutex
+	var events []siem.Event
+	sink := siem.SinkFunc(func(_ context.Context, batch []siem.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, batch...)
+		return nil
+	})
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "passwords.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&password.Credentials{}))
+	store := password.NewGormStore(db)
+
+	// Small parameters keep the test fast
+	fast := password.Argon2id(password.Argon2Params{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32})
+	breached := func(_ context.Context, pw string) (bool, error) { return pw == "password123", nil }
+	client, err := goauth.NewClient(
+		goauth.WithMemoryStorage(),
+		goauth.WithAuditEvents(sink),
+		goauth.WithPasswords(store, goauth.PasswordOptions{
+			Hasher: fast,
+			Policy: password.Policy{MinLength: 10, Breached: breached},
+			Token:  goauth.TokenOptions{Abilities: []string{"read"}},
+		}),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	err = client.SetPassword(ctx, 7, "Alice@Example.com", "short")
+	assert.ErrorIs(t, err, password.ErrWeak)
+	var perr *password.PolicyError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, "too_short", perr.Reason)
+	assert.Equal(t, 10, perr.Limit)
+	err = client.SetPassword(ctx, 7, "alice@example.com", "password123")
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, "breached", perr.Reason)
+
+	require.NoError(t, client.SetPassword(ctx, 7, "Alice@Example.com", "correct horse battery staple"))
+	res, err := client.Login(ctx, " alice@example.COM", "correct horse battery staple")
+	require.NoError(t, err)
+	tok, err := client.ValidateToken(ctx, res.PlainText)
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, tok.UserId)
+
+	_, err = client.Login(ctx, "alice@example.com", "wrong")
+	assert.ErrorIs(t, err, goauth.ErrInvalidCredentials)
+	_, err = client.Login(ctx, "nobody@example.com", "correct horse battery staple")
+	assert.ErrorIs(t, err, goauth.ErrInvalidCredentials)
+
+	// Hashes from another hasher still verify and are upgraded on login
+	legacy, err := password.Bcrypt(4).Hash("hunter2hunter2")
+	require.NoError(t, err)
+	require.NoError(t, store.SaveCredentials(ctx, &password.Credentials{UserID: 8, Identifier: "bob", Hash: legacy}))
+	userID, err := client.CheckPassword(ctx, "bob", "hunter2hunter2")
+	require.NoError(t, err)
+	assert.EqualValues(t, 8, userID)
+	creds, err := store.FindCredentials(ctx, "bob")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(creds.Hash, "$argon2id$"))
+	assert.False(t, fast.NeedsRehash(creds.Hash))
+	ok, err := password.Verify("hunter2hunter2", creds.Hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	require.NoError(t, client.Close())
+	mu.Lock()
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	mu.Unlock()
+	assert.Contains(t, types, siem.LoginSucceeded)
+	assert.Contains(t, types, siem.LoginFailed)
+
+	limited, err := goauth.NewClient(
+		goauth.WithMemoryStorage(),
+		goauth.WithRateLimiter(ratelimit.NewMemory(2, time.Hour)),
+		goauth.WithPasswords(store, goauth.PasswordOptions{Hasher: fast}),
+	)
+	require.NoError(t, err)
+	defer limited.Close()
+	for range 2 {
+		_, err = limited.Login(ctx, "bob", "wrong")
+		assert.ErrorIs(t, err, goauth.ErrInvalidCredentials)
+	}
+	_, err = limited.Login(ctx, "bob", "hunter2hunter2")
+	assert.ErrorIs(t, err, goauth.ErrRateLimited)
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithPasswords(nil, goauth.PasswordOptions{}))
+	assert.Error(t, err)
+}
+
+func TestMFA(t *testing.T) {
+	ctx := context.Background()
+
+	// RFC 6238 appendix B, SHA-1, truncated to 6 digits
+	secret := mfa.Encoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		code, err := mfa.Code(secret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, want, code, "t=%d", unix)
+	}
+	_, ok := mfa.Validate(secret, "005924", time.Unix(1234567890, 0).Add(mfa.Period), 1)
+	assert.True(t, ok, "one step of drift is allowed")
+	_, ok = mfa.Validate(secret, "005924", time.Unix(1234567890, 0).Add(3*mfa.Period), 1)
+	assert.False(t, ok)
+
+	key, err := mfa.NewKey("Acme", "alice@example.com", nil)
+	require.NoError(t, err)
+	u, err := url.Parse(key.URI())
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Acme:alice@example.com", u.Path)
+	assert.Equal(t, key.Secret, u.Query().Get("secret"))
+	assert.Equal(t, "Acme", u.Query().Get("issuer"))
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "mfa.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&password.Credentials{}, &mfa.Enrollment{}))
+
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	fast := password.Argon2id(password.Argon2Params{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32})
+	client, err := goauth.NewClient(
+		goauth.WithMemoryStorage(),
+		goauth.WithClock(clock),
+		goauth.WithPasswords(password.NewGormStore(db), goauth.PasswordOptions{Hasher: fast, Token: goauth.TokenOptions{Abilities: []string{"read"}}}),
+		goauth.WithMFA(mfa.NewGormStore(db), goauth.MFAOptions{Issuer: "Acme", MaxAttempts: 2}),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.SetPassword(ctx, 7, "alice@example.com", "correct horse battery staple"))
+
+	// Unconfirmed enrollments don't guard logins
+	key, err = client.EnrollTOTP(ctx, 7, "alice@example.com")
+	require.NoError(t, err)
+	res, err := client.Login(ctx, "alice@example.com", "correct horse battery staple")
+	require.NoError(t, err)
+	assert.False(t, res.MFARequired)
+
+	code := func() string {
+		c, err := mfa.Code(key.Secret, clock.Now())
+		require.NoError(t, err)
+		return c
+	}
+	assert.ErrorIs(t, client.ConfirmTOTP(ctx, 7, "000000"), goauth.ErrInvalidMFACode)
+	require.NoError(t, client.ConfirmTOTP(ctx, 7, code()))
+	enrolled, err := client.MFAEnrolled(ctx, 7)
+	require.NoError(t, err)
+	assert.True(t, enrolled)
+	_, err = client.EnrollTOTP(ctx, 7, "alice@example.com")
+	assert.Error(t, err, "a confirmed enrollment must be disabled first")
+
+	pending, err := client.Login(ctx, "alice@example.com", "correct horse battery staple")
+	require.NoError(t, err)
+	require.True(t, pending.MFARequired)
+	_, err = client.ValidateToken(ctx, pending.PlainText)
+	assert.ErrorIs(t, err, goauth.ErrMFARequired)
+	assert.NotErrorIs(t, err, goauth.ErrTokenInvalid)
+
+	_, err = client.CompleteMFA(ctx, pending.PlainText, code())
+	assert.ErrorIs(t, err, goauth.ErrInvalidMFACode, "the confirming code can't be reused")
+	clock.Advance(mfa.Period)
+	session, err := client.CompleteMFA(ctx, pending.PlainText, code())
+	require.NoError(t, err)
+	tok, err := client.ValidateToken(ctx, session.PlainText)
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, tok.UserId)
+	assert.True(t, tok.Can("read"))
+	_, err = client.CompleteMFA(ctx, pending.PlainText, code())
+	assert.ErrorIs(t, err, goauth.ErrTokenInvalid, "the pending token is used up")
+	_, err = client.CompleteMFA(ctx, session.PlainText, code())
+	assert.ErrorIs(t, err, goauth.ErrTokenInvalid, "only pending tokens can be completed")
+
+	// Wrong codes use up the pending token
+	pending, err = client.Login(ctx, "alice@example.com", "correct horse battery staple")
+	require.NoError(t, err)
+	for range 2 {
+		_, err = client.CompleteMFA(ctx, pending.PlainText, "000000")
+		assert.ErrorIs(t, err, goauth.ErrInvalidMFACode)
+	}
+	clock.Advance(mfa.Period)
+	_, err = client.CompleteMFA(ctx, pending.PlainText, code())
+	assert.ErrorIs(t, err, goauth.ErrTokenInvalid)
+
+	require.NoError(t, client.DisableTOTP(ctx, 7))
+	res, err = client.Login(ctx, "alice@example.com", "correct horse battery staple")
+	require.NoError(t, err)
+	assert.False(t, res.MFARequired)
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithMFA(mfa.NewMemoryStore(), goauth.MFAOptions{}))
+	assert.Error(t, err, "an issuer is required")
+}
+
+func TestOneTimeToken(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(goauth.SQLiteDSN(filepath.Join(t.TempDir(), "tokens.db"), goauth.SQLiteOptions{})), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	drivers := map[string]goauth.Option{
+		"memory": goauth.WithMemoryStorage(),
+		"sqlite": goauth.WithSQLiteStorage(db, goauth.SQLiteOptions{}),
+	}
+
+	for name, opt := range drivers {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+			client, err := goauth.NewClient(opt, goauth.WithClock(clock))
+			require.NoError(t, err)
+			defer client.Close()
+
+			raw, err := client.CreateOneTimeToken(ctx, 7, 15*time.Minute)
+			require.NoError(t, err)
+			_, err = client.ValidateToken(ctx, raw)
+			assert.ErrorIs(t, err, goauth.ErrOneTimeToken, "one-time tokens aren't bearer tokens")
+			assert.ErrorIs(t, err, goauth.ErrTokenInvalid)
+
+			// Concurrent consumers race; exactly one wins
+			var wg sync.WaitGroup
+			var won, revoked atomic.Int32
+			for range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					tok, err := client.ConsumeOneTimeToken(ctx, raw)
+					switch {
+					case err == nil:
+						assert.EqualValues(t, 7, tok.UserId)
+						won.Add(1)
+					case errors.Is(err, goauth.ErrTokenRevoked):
+						revoked.Add(1)
+					default:
+						t.Errorf("unexpected error: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+			assert.EqualValues(t, 1, won.Load())
+			assert.EqualValues(t, 7, revoked.Load())
+
+			expiring, err := client.CreateOneTimeToken(ctx, 7, time.Minute)
+			require.NoError(t, err)
+			clock.Advance(2 * time.Minute)
+			_, err = client.ConsumeOneTimeToken(ctx, expiring)
+			assert.ErrorIs(t, err, goauth.ErrTokenExpired)
+
+			session, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 7})
+			require.NoError(t, err)
+			_, err = client.ConsumeOneTimeToken(ctx, session)
+			assert.ErrorIs(t, err, goauth.ErrTokenInvalid, "only one-time tokens can be consumed")
+			_, err = client.ValidateToken(ctx, session)
+			assert.NoError(t, err, "and failing to consume one leaves it alone")
+
+			_, err = client.CreateOneTimeToken(ctx, 7, 0)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestImportParsers(t *testing.T) {
+	created := time.UnixMilli(1700000000000).UTC()
+	keycloak := func(r io.Reader) ([]importer.User, error) { return importer.ParseKeycloak(r) }
+	firebase := func(r io.Reader) ([]importer.User, error) {
+		return importer.ParseFirebase(r, importer.FirebaseHashConfig{Algorithm: "SCRYPT", Rounds: 8})
+	}
+
+	tests := []struct {
+		name    string
+		parse   func(io.Reader) ([]importer.User, error)
+		input   string
+		want    []importer.User
+		wantErr string
+	}{
+		{
+			name:  "keycloak",
+			parse: keycloak,
+			input: `{"users": [{
+				"id": "kc-1", "username": "ada", "email": "ada@example.com", "emailVerified": true, "enabled": true,
+				"firstName": "Ada", "lastName": "Lovelace", "createdTimestamp": 1700000000000,
+				"attributes": {"dept": ["eng"]},
+				"credentials": [
+					{"type": "otp", "secretData": "{}"},
+					{"type": "password", "secretData": "{\"value\":\"aGFzaA==\",\"salt\":\"c2FsdA==\"}",
+					 "credentialData": "{\"hashIterations\":27500,\"algorithm\":\"pbkdf2-sha256\"}"}
+				]
+			}, {"id": "kc-2", "username": "bob", "enabled": false, "lastName": "Builder", "createdTimestamp": null}]}`,
+			want: []importer.User{{
+				Provider: "keycloak", ExternalID: "kc-1", Username: "ada", Email: "ada@example.com", EmailVerified: true,
+				DisplayName: "Ada Lovelace", PasswordHash: "aGFzaA==", PasswordSalt: "c2FsdA==",
+				HashAlgorithm: "pbkdf2-sha256", HashRounds: 27500, Attributes: map[string][]string{"dept": {"eng"}},
+				CreatedAt: created,
+			}, {
+				Provider: "keycloak", ExternalID: "kc-2", Username: "bob", Disabled: true, DisplayName: "Builder",
+			}},
+		},
+		{
+			name:  "firebase",
+			parse: firebase,
+			input: `{"users": [
+				{"localId": "fb-1", "email": "ada@example.com", "emailVerified": true, "displayName": "Ada",
+				 "passwordHash": "aGFzaA==", "salt": "c2FsdA==", "createdAt": "1700000000000"},
+				{"localId": "fb-2", "email": "sso@example.com", "disabled": true}
+			]}`,
+			want: []importer.User{{
+				Provider: "firebase", ExternalID: "fb-1", Username: "ada@example.com", Email: "ada@example.com",
+				EmailVerified: true, DisplayName: "Ada", PasswordHash: "aGFzaA==", PasswordSalt: "c2FsdA==",
+				HashAlgorithm: "SCRYPT", HashRounds: 8, CreatedAt: created,
+			}, {
+				Provider: "firebase", ExternalID: "fb-2", Username: "sso@example.com", Email: "sso@example.com", Disabled: true,
+			}},
+		},
+		{
+			name:  "empty export",
+			parse: keycloak,
+			input: `{}`,
+			want:  []importer.User{},
+		},
+		{
+			name:    "keycloak not json",
+			parse:   keycloak,
+			input:   `users:`,
+			wantErr: "decode keycloak export",
+		},
+		{
+			name:    "firebase not json",
+			parse:   firebase,
+			input:   `{"users": [`,
+			wantErr: "decode firebase export",
+		},
+		{
+			name:    "keycloak bad hash",
+			parse:   keycloak,
+			input:   `{"users": [{"id": "kc-1", "credentials": [{"type": "password", "secretData": "{\"value\":\"not base64!\"}"}]}]}`,
+			wantErr: "keycloak user kc-1: password hash is not base64",
+		},
+		{
+			name:    "keycloak bad salt",
+			parse:   keycloak,
+			input:   `{"users": [{"id": "kc-1", "credentials": [{"type": "password", "secretData": "{\"value\":\"aGFzaA==\",\"salt\":\"%%\"}"}]}]}`,
+			wantErr: "keycloak user kc-1: salt is not base64",
+		},
+		{
+			name:    "keycloak malformed secret data",
+			parse:   keycloak,
+			input:   `{"users": [{"id": "kc-1", "credentials": [{"type": "password", "secretData": "{"}]}]}`,
+			wantErr: "keycloak user kc-1: decode secretData",
+		},
+		{
+			name:    "keycloak malformed credential data",
+			parse:   keycloak,
+			input:   `{"users": [{"id": "kc-1", "credentials": [{"type": "password", "credentialData": "[]"}]}]}`,
+			wantErr: "keycloak user kc-1: decode credentialData",
+		},
+		{
+			name:    "keycloak bad timestamp",
+			parse:   keycloak,
+			input:   `{"users": [{"id": "kc-1", "createdTimestamp": "yesterday"}]}`,
+			wantErr: `keycloak user kc-1: bad timestamp "\"yesterday\""`,
+		},
+		{
+			name:    "keycloak negative timestamp",
+			parse:   keycloak,
+			input:   `{"users": [{"id": "kc-1", "createdTimestamp": -5}]}`,
+			wantErr: "keycloak user kc-1: bad timestamp",
+		},
+		{
+			name:    "firebase bad hash",
+			parse:   firebase,
+			input:   `{"users": [{"localId": "fb-1", "passwordHash": "***", "salt": "c2FsdA=="}]}`,
+			wantErr: "firebase user fb-1: passwordHash is not base64",
+		},
+		{
+			name:    "firebase bad salt",
+			parse:   firebase,
+			input:   `{"users": [{"localId": "fb-1", "passwordHash": "aGFzaA==", "salt": "c2Fsd"}]}`,
+			wantErr: "firebase user fb-1: salt is not base64",
+		},
+		{
+			name:    "firebase bad timestamp",
+			parse:   firebase,
+			input:   `{"users": [{"localId": "fb-1", "createdAt": "2023-11-14T22:13:20Z"}]}`,
+			wantErr: "firebase user fb-1: bad timestamp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := tt.parse(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.Nil(t, users)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, users)
+		})
+	}
+}
+
+func TestIdentityLinks(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "identities.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}, &goauth.IdentityLink{}))
+
+	for name, opt := range map[string]goauth.Option{
+		"memory": goauth.WithMemoryStorage(),
+		"gorm":   goauth.WithGormStorage(db),
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, err := goauth.NewClient(opt)
+			require.NoError(t, err)
+			defer client.Close()
+
+			link, err := client.LinkIdentity(ctx, 7, " Google ", "alice")
+			require.NoError(t, err)
+			assert.Equal(t, "google", link.Provider)
+			_, err = client.LinkIdentity(ctx, 7, "password", "alice@example.com")
+			require.NoError(t, err)
+
+			// A taken identity can't be linked again, to any user
+			_, err = client.LinkIdentity(ctx, 8, "google", "alice")
+			assert.ErrorIs(t, err, goauth.ErrIdentityAlreadyLinked)
+			_, err = client.LinkIdentity(ctx, 7, "google", "alice")
+			assert.ErrorIs(t, err, goauth.ErrIdentityAlreadyLinked)
+
+			userID, err := client.FindUserByIdentity(ctx, "GOOGLE", "alice")
+			require.NoError(t, err)
+			assert.EqualValues(t, 7, userID)
+			_, err = client.FindUserByIdentity(ctx, "google", "bob")
+			assert.ErrorIs(t, err, goauth.ErrIdentityNotFound)
+
+			links, err := client.ListIdentities(ctx, 7)
+			require.NoError(t, err)
+			require.Len(t, links, 2)
+			assert.Equal(t, "google", links[0].Provider)
+			assert.Equal(t, "password", links[1].Provider)
+
+			require.NoError(t, client.UnlinkIdentity(ctx, "google", "alice"))
+			assert.ErrorIs(t, client.UnlinkIdentity(ctx, "google", "alice"), goauth.ErrIdentityNotFound)
+			_, err = client.FindUserByIdentity(ctx, "google", "alice")
+			assert.ErrorIs(t, err, goauth.ErrIdentityNotFound)
+
+			// Once unlinked, the identity can move to another user
+			_, err = client.LinkIdentity(ctx, 8, "google", "alice")
+			require.NoError(t, err)
+			links, err = client.ListIdentities(ctx, 7)
+			require.NoError(t, err)
+			assert.Len(t, links, 1)
+		})
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer client.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	var discovered, fetched atomic.Int32
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		discovered.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer": srv.URL, "jwks_uri": srv.URL + "/certs",
+			"authorization_endpoint": srv.URL + "/auth", "token_endpoint": srv.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/certs", func(w http.ResponseWriter, r *http.Request) {
+		fetched.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "r1", "alg": "RS256", "use": "sig",
+			"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+
+	sign := func(claims map[string]any) string {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "r1", "typ": "JWT"})
+		body, _ := json.Marshal(claims)
+		input := b64(header) + "." + b64(body)
+		sum := sha256.Sum256([]byte(input))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+		require.NoError(t, err)
+		return input + "." + b64(sig)
+	}
+	claims := func(sub, nonce string) map[string]any {
+		return map[string]any{
+			"iss": srv.URL, "aud": "web-client", "sub": sub, "nonce": nonce,
+			"email": sub + "@example.com", "email_verified": true,
+			"iat": time.Now().Unix(), "exp": time.Now().Add(5 * time.Minute).Unix(),
+		}
+	}
+
+	provider, err := oidc.NewProvider(oidc.Config{Issuer: srv.URL, ClientID: "web-client"})
+	require.NoError(t, err)
+	meta, err := provider.Metadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/token", meta.TokenEndpoint)
+
+	nonce, err := oidc.NewNonce()
+	require.NoError(t, err)
+	login := &goauth.OIDCLogin{Provider: provider, Name: "acme-sso", Token: goauth.TokenOptions{Abilities: []string{"read"}}}
+
+	_, err = client.ExchangeIDToken(ctx, sign(claims("alice", nonce)), nonce, login)
+	assert.ErrorIs(t, err, goauth.ErrIdentityNotFound)
+	_, err = client.LinkIdentity(ctx, 7, "acme-sso", "alice")
+	require.NoError(t, err)
+	res, err := client.ExchangeIDToken(ctx, sign(claims("alice", nonce)), nonce, login)
+	require.NoError(t, err)
+	tok, err := client.ValidateTokenWithAbility(ctx, res.PlainText, "read")
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, tok.UserId)
+	assert.Equal(t, srv.URL, tok.Metadata[goauth.MetaOIDCIssuer])
+	assert.Equal(t, "alice", tok.Metadata[goauth.MetaOIDCSubject])
+
+	_, err = client.ExchangeIDToken(ctx, sign(claims("alice", "other")), nonce, login)
+	assert.ErrorIs(t, err, goauth.ErrInvalidIDToken, "nonce mismatch")
+	wrongAud := claims("alice", nonce)
+	wrongAud["aud"] = "other-client"
+	_, err = client.ExchangeIDToken(ctx, sign(wrongAud), nonce, login)
+	assert.ErrorIs(t, err, goauth.ErrInvalidIDToken)
+	foreign := claims("alice", nonce)
+	foreign["aud"], foreign["azp"] = []string{"web-client", "other-client"}, "other-client"
+	_, err = client.ExchangeIDToken(ctx, sign(foreign), nonce, login)
+	assert.ErrorIs(t, err, goauth.ErrInvalidIDToken, "issued to another client")
+	expired := claims("alice", nonce)
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	_, err = client.ExchangeIDToken(ctx, sign(expired), nonce, login)
+	assert.ErrorIs(t, err, goauth.ErrInvalidIDToken)
+	assert.EqualValues(t, 1, discovered.Load(), "the discovery document is cached")
+	assert.EqualValues(t, 1, fetched.Load(), "the JWKS is cached")
+
+	// A mapping callback can provision users on first sign-in
+	login.UserID = func(_ context.Context, tok *oidc.IDToken) (int64, error) {
+		if !tok.EmailVerified {
+			return 0, errors.New("unverified email")
+		}
+		return 99, nil
+	}
+	res, err = client.ExchangeIDToken(ctx, sign(claims("bob", "")), "", login)
+	require.NoError(t, err)
+	tok, err = client.ValidateToken(ctx, res.PlainText)
+	require.NoError(t, err)
+	assert.EqualValues(t, 99, tok.UserId)
+
+	_, err = oidc.NewProvider(oidc.Config{Issuer: srv.URL})
+	assert.Error(t, err, "a client ID is required")
+	bad, err := oidc.NewProvider(oidc.Config{Issuer: srv.URL + "/elsewhere", ClientID: "web-client"})
+	require.NoError(t, err)
+	_, err = bad.Metadata(ctx)
+	assert.ErrorIs(t, err, oidc.ErrDiscovery)
+}
+
+func TestSAMLLogin(t *testing.T) {
+	ctx := context.Background()
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithClock(clock))
+	require.NoError(t, err)
+	defer client.Close()
+
+	newCert := func() (*rsa.PrivateKey, *x509.Certificate) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: clock.Now().Add(-time.Hour), NotAfter: clock.Now().Add(24 * time.Hour)}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		return key, cert
+	}
+	idpKey, idpCert := newCert()
+	otherKey, otherCert := newCert()
+
+	const (
+		idp = "https://idp.example.com/metadata"
+		sp  = "https://api.example.com/saml"
+	)
+	assertion := func(id, nameID, audience string, notOnOrAfter time.Time, groups ...string) string {
+		ts := func(t time.Time) string { return t.UTC().Format(time.RFC3339) }
+		var attrs strings.Builder
+		for _, g := range groups {
+			attrs.WriteString(`<saml:AttributeValue>` + g + `</saml:AttributeValue>`)
+		}
+		return `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="` + id + `" Version="2.0" IssueInstant="` + ts(clock.Now()) + `">` +
+			`<saml:Issuer>` + idp + `</saml:Issuer>` +
+			`<saml:Subject><saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">` + nameID + `</saml:NameID>` +
+			`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">` +
+			`<saml:SubjectConfirmationData NotOnOrAfter="` + ts(notOnOrAfter) + `" Recipient="` + sp + `/acs"/></saml:SubjectConfirmation></saml:Subject>` +
+			`<saml:Conditions NotBefore="` + ts(clock.Now().Add(-time.Minute)) + `" NotOnOrAfter="` + ts(notOnOrAfter) + `">` +
+			`<saml:AudienceRestriction><saml:Audience>` + audience + `</saml:Audience></saml:AudienceRestriction></saml:Conditions>` +
+			`<saml:AuthnStatement AuthnInstant="` + ts(clock.Now()) + `" SessionIndex="s1"/>` +
+			`<saml:AttributeStatement><saml:Attribute Name="groups">` + attrs.String() + `</saml:Attribute></saml:AttributeStatement>` +
+			`</saml:Assertion>`
+	}
+	sign := func(key *rsa.PrivateKey, cert *x509.Certificate, xml string) []byte {
+		doc := etree.NewDocument()
+		require.NoError(t, doc.ReadFromString(xml))
+		sctx, err := dsig.NewSigningContext(key, [][]byte{cert.Raw})
+		require.NoError(t, err)
+		sctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+		signed, err := sctx.SignEnveloped(doc.Root())
+		require.NoError(t, err)
+		doc.SetRoot(signed)
+		out, err := doc.WriteToBytes()
+		require.NoError(t, err)
+		return out
+	}
+	response := func(inner string) string {
+		return `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="r1" Version="2.0">` +
+			`<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>` + inner + `</samlp:Response>`
+	}
+
+	verifier, err := saml.NewVerifier(saml.Config{Issuer: idp, Audience: sp, Recipient: sp + "/acs", Certificates: []*x509.Certificate{idpCert}})
+	require.NoError(t, err)
+	login := &goauth.SAMLLogin{
+		Verifier: verifier,
+		Name:     "acme-idp",
+		Rules: []goauth.SAMLRule{
+			{Attribute: "groups", Value: "engineering", Abilities: []string{"deploy", "read"}},
+			{Attribute: "groups", Value: "staff-*", Abilities: []string{"read"}},
+		},
+	}
+	_, err = client.LinkIdentity(ctx, 7, "acme-idp", "alice@example.com")
+	require.NoError(t, err)
+	later := clock.Now().Add(5 * time.Minute)
+
+	res, err := client.ExchangeSAMLAssertion(ctx, sign(idpKey, idpCert, assertion("a1", "alice@example.com", sp, later, "engineering", "staff-eu")), login)
+	require.NoError(t, err)
+	tok, err := client.ValidateTokenWithAbility(ctx, res.PlainText, "deploy")
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, tok.UserId)
+	assert.Equal(t, "alice@example.com", tok.Metadata[goauth.MetaSAMLSubject])
+	assert.Equal(t, idp, tok.Metadata[goauth.MetaSAMLIssuer])
+
+	// An assertion signed inside an unsigned response
+	signedInner := sign(idpKey, idpCert, assertion("a2", "alice@example.com", sp, later, "staff-us"))
+	inner := strings.TrimPrefix(string(signedInner), `<?xml version="1.0" encoding="UTF-8"?>`)
+	res, err = client.ExchangeSAMLAssertion(ctx, []byte(response(inner)), login)
+	require.NoError(t, err)
+	tok, err = client.ValidateToken(ctx, res.PlainText)
+	require.NoError(t, err)
+	assert.True(t, tok.Can("read"))
+	assert.False(t, tok.Can("deploy"))
+
+	// A signed response around an unsigned assertion
+	res, err = client.ExchangeSAMLAssertion(ctx, sign(idpKey, idpCert, response(assertion("a10", "alice@example.com", sp, later, "engineering"))), login)
+	require.NoError(t, err)
+	_, err = client.ValidateTokenWithAbility(ctx, res.PlainText, "deploy")
+	assert.NoError(t, err)
+
+	_, err = client.ExchangeSAMLAssertion(ctx, sign(idpKey, idpCert, assertion("a1", "alice@example.com", sp, later, "engineering")), login)
+	assert.ErrorIs(t, err, goauth.ErrInvalidAssertion, "replayed")
+	_, err = client.ExchangeSAMLAssertion(ctx, sign(idpKey, idpCert, assertion("a3", "alice@example.com", "https://other-sp", later, "engineering")), login)
+	assert.ErrorIs(t, err, goauth.ErrInvalidAssertion, "wrong audience")
+	_, err = client.ExchangeSAMLAssertion(ctx, sign(otherKey, otherCert, assertion("a4", "alice@example.com", sp, later, "engineering")), login)
+	assert.ErrorIs(t, err, goauth.ErrInvalidAssertion, "untrusted signer")
+	_, err = client.ExchangeSAMLAssertion(ctx, []byte(assertion("a5", "alice@example.com", sp, later, "engineering")), login)
+	assert.ErrorIs(t, err, goauth.ErrInvalidAssertion, "unsigned")
+	_, err = client.ExchangeSAMLAssertion(ctx, []byte(response(assertion("a6", "alice@example.com", sp, later, "engineering"))), login)
+	assert.ErrorIs(t, err, goauth.ErrInvalidAssertion, "unsigned inside a response")
+	tampered := strings.Replace(string(sign(idpKey, idpCert, assertion("a7", "alice@example.com", sp, later, "staff-eu"))), "staff-eu", "engineering", 1)
+	_, err = client.ExchangeSAMLAssertion(ctx, []byte(tampered), login)
+	assert.ErrorIs(t, err, goauth.ErrInvalidAssertion, "tampered")
+	_, err = client.ExchangeSAMLAssertion(ctx, sign(idpKey, idpCert, assertion("a8", "alice@example.com", sp, later, "contractors")), login)
+	assert.ErrorIs(t, err, goauth.ErrSAMLDenied)
+	expiring := sign(idpKey, idpCert, assertion("a9", "alice@example.com", sp, later, "engineering"))
+	clock.Advance(10 * time.Minute)
+	_, err = client.ExchangeSAMLAssertion(ctx, expiring, login)
+	assert.ErrorIs(t, err, goauth.ErrInvalidAssertion, "expired")
+
+	_, err = saml.NewVerifier(saml.Config{Issuer: idp, Audience: sp})
+	assert.Error(t, err, "certificates are required")
+}
+
+func TestJWKSHandler(t *testing.T) {
+	ctx := context.Background()
+	old, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithRS256Keys(key, goauth.JWTOptions{}))
+	assert.Error(t, err, "an issuer is required")
+	opts := goauth.JWTOptions{Issuer: "https://auth.example.com", Audience: "api", Previous: []crypto.PublicKey{&old.PublicKey}}
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithRS256Keys(key, opts))
+	require.NoError(t, err)
+	defer client.Close()
+
+	srv := httptest.NewServer(client.JWKSHandler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/jwk-set+json", resp.Header.Get("Content-Type"))
+	var jwks goauth.JWKS
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&jwks))
+	require.Len(t, jwks.Keys, 2)
+	for _, k := range jwks.Keys {
+		assert.Equal(t, "RSA", k.Kty)
+		assert.Equal(t, "RS256", k.Alg)
+		assert.Equal(t, "sig", k.Use)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	assert.Equal(t, b64(key.N.Bytes()), jwks.Keys[0].N, "current key first")
+	assert.Equal(t, b64(old.N.Bytes()), jwks.Keys[1].N)
+	assert.NotEqual(t, jwks.Keys[0].Kid, jwks.Keys[1].Kid)
+
+	// Instances with the same rotation config serve the same kids
+	other, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithRS256Keys(key, opts))
+	require.NoError(t, err)
+	defer other.Close()
+	again, ok := other.JWKS()
+	require.True(t, ok)
+	assert.Equal(t, jwks, again)
+
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 42, Abilities: []string{"read", "write"}})
+	require.NoError(t, err)
+	signed, err := client.IssueJWT(ctx, raw)
+	require.NoError(t, err)
+
+	parts := strings.Split(signed, ".")
+	require.Len(t, parts, 3)
+	var header struct{ Alg, Kid, Typ string }
+	var claims struct {
+		Iss, Aud, Sub, Scope string
+		Exp, Iat             int64
+	}
+	for i, v := range []any{&header, &claims} {
+		seg, err := base64.RawURLEncoding.DecodeString(parts[i])
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(seg, v))
+	}
+	assert.Equal(t, "RS256", header.Alg)
+	assert.Equal(t, "at+jwt", header.Typ)
+	assert.Equal(t, jwks.Keys[0].Kid, header.Kid)
+	assert.Equal(t, "https://auth.example.com", claims.Iss)
+	assert.Equal(t, "api", claims.Aud)
+	assert.Equal(t, "42", claims.Sub)
+	assert.Equal(t, "read write", claims.Scope)
+	assert.Equal(t, int64(5*60), claims.Exp-claims.Iat)
+
+	// Verifiable offline with the published key
+	n, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+	require.NoError(t, err)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: key.E}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig))
+
+	require.NoError(t, client.RevokeToken(ctx, raw))
+	_, err = client.IssueJWT(ctx, raw)
+	assert.ErrorIs(t, err, goauth.ErrTokenInvalid)
+
+	plain, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	defer plain.Close()
+	rec := httptest.NewRecorder()
+	plain.JWKSHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestJWTSigningMethods(t *testing.T) {
+	ctx := context.Background()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	t.Run("key loading", func(t *testing.T) {
+		der, err := x509.MarshalPKCS8PrivateKey(edKey)
+		require.NoError(t, err)
+		key, err := goauth.ParseSigningKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		require.NoError(t, err)
+		assert.Equal(t, edKey, key)
+
+		der, err = x509.MarshalECPrivateKey(ecKey)
+		require.NoError(t, err)
+		key, err = goauth.ParseSigningKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+		require.NoError(t, err)
+		assert.True(t, ecKey.Equal(key))
+
+		der, err = x509.MarshalPKIXPublicKey(edKey.Public())
+		require.NoError(t, err)
+		pub, err := goauth.ParseVerificationKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		require.NoError(t, err)
+		assert.Equal(t, edKey.Public(), pub)
+
+		b64 := base64.RawURLEncoding.EncodeToString
+		x := b64(edKey.Public().(ed25519.PublicKey))
+		key, err = goauth.ParseSigningKeyJWK([]byte(`{"kty":"OKP","crv":"Ed25519","alg":"EdDSA","x":"` + x + `","d":"` + b64(edKey.Seed()) + `"}`))
+		require.NoError(t, err)
+		assert.Equal(t, edKey, key)
+		pub, err = goauth.ParseVerificationKeyJWK([]byte(`{"kty":"OKP","crv":"Ed25519","x":"` + x + `"}`))
+		require.NoError(t, err)
+		assert.Equal(t, edKey.Public(), pub)
+
+		ecdhKey, err := ecKey.ECDH()
+		require.NoError(t, err)
+		point := ecdhKey.PublicKey().Bytes()
+		ecJWK := `{"kty":"EC","crv":"P-256","x":"` + b64(point[1:33]) + `","y":"` + b64(point[33:]) + `","d":"` + b64(ecdhKey.Bytes()) + `"}`
+		key, err = goauth.ParseSigningKeyJWK([]byte(ecJWK))
+		require.NoError(t, err)
+		assert.True(t, ecKey.Equal(key))
+
+		_, err = goauth.ParseSigningKeyJWK([]byte(`{"kty":"OKP","crv":"Ed25519","alg":"ES256","x":"` + x + `","d":"` + b64(edKey.Seed()) + `"}`))
+		assert.Error(t, err, "alg must match the key")
+		_, err = goauth.ParseSigningKeyJWK([]byte(`{"kty":"OKP","crv":"Ed25519","x":"` + x + `","d":"` + b64(make([]byte, 32)) + `"}`))
+		assert.Error(t, err, "d must match x")
+		_, err = goauth.ParseSigningKeyJWK([]byte(`{"kty":"OKP","crv":"Ed25519","x":"` + x + `"}`))
+		assert.Error(t, err, "public keys can't sign")
+	})
+
+	t.Run("unsupported keys", func(t *testing.T) {
+		p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		require.NoError(t, err)
+		small, err := rsa.GenerateKey(rand.Reader, 1024)
+		require.NoError(t, err)
+		opts := goauth.JWTOptions{Issuer: "https://auth.example.com"}
+		for _, key := range []crypto.Signer{p384, small} {
+			_, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithJWTSigningKey(key, opts))
+			assert.Error(t, err)
+		}
+		opts.Previous = []crypto.PublicKey{&p384.PublicKey}
+		_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithJWTSigningKey(edKey, opts))
+		assert.Error(t, err)
+	})
+
+	for _, tc := range []struct {
+		alg      string
+		key      crypto.Signer
+		previous crypto.PublicKey
+	}{
+		{"EdDSA", edKey, &ecKey.PublicKey},
+		{"ES256", ecKey, edKey.Public()},
+	} {
+		t.Run(tc.alg, func(t *testing.T) {
+			opts := goauth.JWTOptions{Issuer: "https://auth.example.com", Audience: "api", Previous: []crypto.PublicKey{tc.previous}}
+			client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithJWTSigningKey(tc.key, opts))
+			require.NoError(t, err)
+			defer client.Close()
+			assert.Equal(t, tc.alg, client.Features().SigningMethod)
+			assert.Contains(t, client.Features().Modules, "jwt_access_tokens")
+
+			jwks, ok := client.JWKS()
+			require.True(t, ok)
+			require.Len(t, jwks.Keys, 2)
+			assert.Equal(t, tc.alg, jwks.Keys[0].Alg)
+			assert.NotEqual(t, tc.alg, jwks.Keys[1].Alg, "previous keys keep their own algorithm")
+
+			srv := httptest.NewServer(client.JWKSHandler())
+			defer srv.Close()
+			raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 42, Abilities: []string{"read"}})
+			require.NoError(t, err)
+			signed, err := client.IssueJWT(ctx, raw)
+			require.NoError(t, err)
+
+			// Any JWKS-aware verifier accepts it offline
+			verifier, err := oidc.NewProvider(oidc.Config{Issuer: "https://auth.example.com", ClientID: "api", JWKSURL: srv.URL})
+			require.NoError(t, err)
+			tok, err := verifier.Verify(ctx, signed, "", time.Time{})
+			require.NoError(t, err)
+			assert.Equal(t, "42", tok.Subject)
+			assert.Equal(t, "read", tok.Claims["scope"])
+
+			tampered := signed[:len(signed)-4] + "AAAA"
+			_, err = verifier.Verify(ctx, tampered, "", time.Time{})
+			assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+		})
+	}
+}
+
+func TestDefaultAbilityPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T, opts ...goauth.Option) (client *goauth.Client, legacy, fresh string) {
+		db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "policy.db")), &gorm.Config{Logger: logger.Discard})
+		require.NoError(t, err)
+		require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+		client, err = goauth.NewClient(append(opts, goauth.WithSQLiteStorage(db, goauth.SQLiteOptions{}))...)
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+
+		legacy, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+		require.NoError(t, err)
+		// Rows written before abilities were stored
+		require.NoError(t, db.Exec("UPDATE personal_access_tokens SET abilities = NULL").Error)
+		fresh, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 2})
+		require.NoError(t, err)
+		return client, legacy, fresh
+	}
+	can := func(t *testing.T, client *goauth.Client, raw, ability string) bool {
+		tok, err := client.ValidateToken(ctx, raw)
+		require.NoError(t, err)
+		return client.TokenCan(tok, ability)
+	}
+
+	t.Run("deny all", func(t *testing.T) {
+		client, legacy, fresh := setup(t)
+		assert.False(t, can(t, client, legacy, "posts:read"))
+		assert.False(t, can(t, client, fresh, "posts:read"))
+	})
+
+	t.Run("allow all", func(t *testing.T) {
+		client, legacy, fresh := setup(t, goauth.WithDefaultAbilityPolicy(goauth.AbilityPolicyAllowAll))
+		assert.True(t, can(t, client, legacy, "posts:delete"))
+		assert.False(t, can(t, client, fresh, "posts:delete"), "only legacy rows fall back to the policy")
+
+		tok, err := client.ValidateToken(ctx, fresh)
+		require.NoError(t, err)
+		assert.Empty(t, tok.AbilityList())
+
+		_, err = client.ExchangeToken(ctx, fresh, &goauth.ExchangeOptions{Abilities: []string{"admin"}})
+		assert.ErrorIs(t, err, goauth.ErrExchangeDenied, "a token without abilities can't mint one with some")
+		child, err := client.ExchangeToken(ctx, legacy, &goauth.ExchangeOptions{Abilities: []string{"admin"}})
+		require.NoError(t, err)
+		assert.True(t, can(t, client, child.PlainText, "admin"))
+
+		// The successor of a legacy token keeps what the policy granted it
+		rot, err := client.RotateToken(ctx, legacy)
+		require.NoError(t, err)
+		assert.True(t, can(t, client, rot.PlainText, "posts:delete"))
+	})
+
+	t.Run("default set", func(t *testing.T) {
+		client, legacy, fresh := setup(t, goauth.WithDefaultAbilityPolicy(goauth.AbilityPolicyDefaultSet, "posts:read"))
+		assert.True(t, can(t, client, legacy, "posts:read"))
+		assert.False(t, can(t, client, legacy, "posts:write"))
+		assert.False(t, can(t, client, fresh, "posts:read"))
+
+		rot, err := client.RotateToken(ctx, legacy)
+		require.NoError(t, err)
+		assert.True(t, can(t, client, rot.PlainText, "posts:read"))
+		assert.False(t, can(t, client, rot.PlainText, "posts:write"))
+	})
+
+	_, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithDefaultAbilityPolicy(goauth.AbilityPolicyDefaultSet))
+	assert.Error(t, err)
+}
+
+func TestExchangeToken(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithSoftRevocation())
+	require.NoError(t, err)
+	defer client.Close()
+
+	parent, err := client.CreateToken(ctx, &goauth.TokenOptions{
+		UserId:    1,
+		Abilities: []string{"posts:*", "users:read"},
+		Features:  []string{"beta"},
+		ExpiresIn: time.Hour,
+	})
+	require.NoError(t, err)
+	parentTok, err := client.ValidateToken(ctx, parent)
+	require.NoError(t, err)
+
+	child, err := client.ExchangeToken(ctx, parent, &goauth.ExchangeOptions{Abilities: []string{"posts:read"}, ExpiresIn: 10 * time.Minute})
+	require.NoError(t, err)
+	childTok, err := client.ValidateToken(ctx, child.PlainText)
+	require.NoError(t, err)
+	require.NotNil(t, childTok.ParentID)
+	assert.Equal(t, parentTok.ID, *childTok.ParentID)
+	assert.Equal(t, int64(1), childTok.UserId)
+	assert.True(t, client.TokenCan(childTok, "posts:read"))
+	assert.False(t, client.TokenCan(childTok, "users:read"))
+	// Bindings carry over
+	_, err = client.ValidateTokenForAPI(ctx, child.PlainText, "", "beta")
+	assert.NoError(t, err)
+	_, err = client.ValidateTokenForAPI(ctx, child.PlainText, "", "gamma")
+	assert.Error(t, err)
+
+	_, err = client.ExchangeToken(ctx, parent, &goauth.ExchangeOptions{Abilities: []string{"billing:read"}})
+	assert.ErrorIs(t, err, goauth.ErrExchangeDenied)
+	_, err = client.ExchangeToken(ctx, parent, &goauth.ExchangeOptions{ExpiresIn: 2 * time.Hour})
+	assert.ErrorIs(t, err, goauth.ErrExchangeDenied)
+	_, err = client.ExchangeToken(ctx, child.PlainText, &goauth.ExchangeOptions{Abilities: []string{"posts:write"}})
+	assert.ErrorIs(t, err, goauth.ErrExchangeDenied)
+
+	// Without options the token keeps the abilities and can't outlive the parent
+	inherit, err := client.ExchangeToken(ctx, parent, nil)
+	require.NoError(t, err)
+	inheritTok, err := client.ValidateToken(ctx, inherit.PlainText)
+	require.NoError(t, err)
+	assert.Equal(t, parentTok.Abilities, inheritTok.Abilities)
+	require.NotNil(t, inheritTok.ExpiresAt)
+	assert.False(t, inheritTok.ExpiresAt.After(*parentTok.ExpiresAt))
+
+	grandchild, err := client.ExchangeToken(ctx, child.PlainText, nil)
+	require.NoError(t, err)
+
+	// Revoking the parent revokes the chain below it
+	require.NoError(t, client.RevokeToken(ctx, parent))
+	for _, raw := range []string{child.PlainText, grandchild.PlainText, inherit.PlainText} {
+		_, err = client.ValidateToken(ctx, raw)
+		assert.ErrorIs(t, err, goauth.ErrTokenRevoked)
+	}
+}
+
+// costCenters requires a cost center on every token and stores a project ID
+type costCenters struct{}
+
+func (costCenters) Columns() []goauth.ExtraColumn {
+	return []goauth.ExtraColumn{{Name: "cost_center", Type: "VARCHAR(32)"}, {Name: "project_id", Type: "INTEGER"}}
+}
+
+func (costCenters) Marshal(t *goauth.PersonalAccessToken) (map[string]any, error) {
+	cc, _ := t.Extra["cost_center"].(string)
+	if cc == "" {
+		return nil, errors.New("cost center required")
+	}
+	return map[string]any{"cost_center": cc, "project_id": t.Extra["project_id"]}, nil
+}
+
+func (costCenters) Unmarshal(t *goauth.PersonalAccessToken, values map[string]any) error {
+	t.Extra = map[string]any{"cost_center": values["cost_center"], "project_id": values["project_id"]}
+	return nil
+}
+
+func TestEntityExtender(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "extra.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	client, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithEntityExtender(costCenters{}))
+	require.NoError(t, err)
+	defer client.Close()
+
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Extra: map[string]any{"cost_center": "CC-42", "project_id": 7}})
+	require.NoError(t, err)
+	tok, err := client.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, "CC-42", tok.Extra["cost_center"])
+	assert.EqualValues(t, 7, tok.Extra["project_id"])
+
+	var cc string
+	require.NoError(t, db.Raw("SELECT cost_center FROM personal_access_tokens WHERE id = ?", tok.ID).Scan(&cc).Error)
+	assert.Equal(t, "CC-42", cc)
+
+	list, _, err := client.ListTokens(ctx, 1, nil)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "CC-42", list[0].Extra["cost_center"])
+
+	// Marshal errors fail the write
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	assert.ErrorContains(t, err, "cost center required")
+
+	// Plain columns survive a restart, and may not shadow token fields
+	plain, err := goauth.NewClient(goauth.WithGormStorage(db), goauth.WithEntityExtender(goauth.ExtraColumns(goauth.ExtraColumn{Name: "cost_center", Type: "VARCHAR(32)"})))
+	require.NoError(t, err)
+	defer plain.Close()
+	tok, err = plain.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, "CC-42", tok.Extra["cost_center"])
+
+	_, err = goauth.NewClient(goauth.WithGormStorage(db), goauth.WithEntityExtender(goauth.ExtraColumns(goauth.ExtraColumn{Name: "name", Type: "TEXT"})))
+	assert.Error(t, err)
+}
+
+// apiToken is an application's own token model with its naming
+// conventions and a relation goauth knows nothing about
+type apiToken struct {
+	ID          int64             `gorm:"primaryKey"`
+	OwnerID     int64             `gorm:"uniqueIndex:idx_api_owner_key"`
+	TokenHash   string            `gorm:"uniqueIndex;size:100"`
+	Label       *string           `gorm:"size:100"`
+	Key         *string           `gorm:"size:100;uniqueIndex:idx_api_owner_key"`
+	Scopes      string            `gorm:"type:text"`
+	Kind        string            `gorm:"size:16"`
+	FamilyID    string            `gorm:"size:32"`
+	Metadata    map[string]string `gorm:"serializer:json;type:text"`
+	CreatedAt   time.Time
+	ExpiresAt   *time.Time
+	LastUsedAt  *time.Time
+	RevokedAt   *time.Time
+	WorkspaceID *int64
+	Workspace   *workspace
+}
+
+type workspace struct {
+	ID   int64
+	Name string
+}
+
+func (apiToken) TableName() string { return "api_tokens" }
+
+func (a *apiToken) TokenColumns() map[string]string {
+	return map[string]string{"user_id": "owner_id", "token": "token_hash", "name": "label", "unique_name": "key", "abilities": "scopes"}
+}
+
+func (a *apiToken) ToToken() *goauth.PersonalAccessToken {
+	return &goauth.PersonalAccessToken{
+		ID: a.ID, UserId: a.OwnerID, Token: a.TokenHash, Name: a.Label, UniqueName: a.Key, Abilities: a.Scopes,
+		Kind: a.Kind, FamilyID: a.FamilyID, Metadata: a.Metadata, CreatedAt: a.CreatedAt,
+		ExpiresAt: a.ExpiresAt, LastUsedAt: a.LastUsedAt, RevokedAt: a.RevokedAt,
+	}
+}
+
+func (a *apiToken) FromToken(t *goauth.PersonalAccessToken) {
+	*a = apiToken{
+		ID: t.ID, OwnerID: t.UserId, TokenHash: t.Token, Label: t.Name, Key: t.UniqueName, Scopes: t.Abilities,
+		Kind: t.Kind, FamilyID: t.FamilyID, Metadata: t.Metadata, CreatedAt: t.CreatedAt,
+		ExpiresAt: t.ExpiresAt, LastUsedAt: t.LastUsedAt, RevokedAt: t.RevokedAt,
+	}
+}
+
+func TestGormModelStorage(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "model.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&workspace{}, &apiToken{}))
+
+	client, err := goauth.NewClient(goauth.WithGormModelStorage[apiToken](db), goauth.WithIndexVerification(true))
+	require.NoError(t, err)
+	defer client.Close()
+
+	ci := "ci"
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 7, Name: &ci, Abilities: []string{"repo:read"}})
+	require.NoError(t, err)
+	tok, err := client.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), tok.UserId)
+	assert.True(t, tok.Can("repo:read"))
+
+	var row apiToken
+	require.NoError(t, db.First(&row, tok.ID).Error)
+	assert.Equal(t, int64(7), row.OwnerID)
+	assert.Equal(t, "ci", *row.Label)
+
+	// Replace upserts on the mapped (owner_id, key) index
+	again, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 7, Name: &ci, Replace: true})
+	require.NoError(t, err)
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 7, Name: &ci, Replace: true})
+	require.NoError(t, err)
+	_, err = client.ValidateToken(ctx, again)
+	assert.Error(t, err)
+
+	list, total, err := client.ListTokens(ctx, 7, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+	assert.Len(t, list, 2)
+
+	require.NoError(t, client.RevokeToken(ctx, raw))
+	_, err = client.ValidateToken(ctx, raw)
+	assert.Error(t, err)
+
+	// Models missing a column are rejected up front
+	_, err = goauth.NewClient(goauth.WithGormModelStorage[hashOnly](db))
+	assert.ErrorContains(t, err, "user_id")
+}
+
+type hashOnly struct {
+	ID    int64
+	Token string
+}
+
+func (h *hashOnly) ToToken() *goauth.PersonalAccessToken {
+	return &goauth.PersonalAccessToken{ID: h.ID, Token: h.Token}
+}
+
+func (h *hashOnly) FromToken(t *goauth.PersonalAccessToken) {
+	*h = hashOnly{ID: t.ID, Token: t.Token}
+}
+
+func TestDeviceTokens(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithStorage(goauth.NewMemoryStorage()), goauth.WithDeviceTokenTTL(60*24*time.Hour), goauth.WithSoftRevocation())
+	require.NoError(t, err)
+	defer client.Close()
+
+	laptop, err := client.CreateDeviceToken(ctx, 1, goauth.DeviceOptions{Name: "Firefox on macOS", Fingerprint: "fp-laptop"})
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(60*24*time.Hour), *laptop.ExpiresAt, time.Minute)
+	phone, err := client.CreateDeviceToken(ctx, 1, goauth.DeviceOptions{Name: "iPhone", Fingerprint: "fp-phone"})
+	require.NoError(t, err)
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+
+	tok, err := client.ValidateToken(ctx, laptop.PlainText)
+	require.NoError(t, err)
+	assert.Equal(t, "Firefox on macOS", goauth.DeviceOf(tok).Name)
+
+	devices, err := client.ListDevices(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, devices, 2)
+	assert.Equal(t, "fp-laptop", devices[0].Fingerprint)
+
+	// Signing in again from the laptop replaces its token
+	again, err := client.CreateDeviceToken(ctx, 1, goauth.DeviceOptions{Name: "Firefox on macOS", Fingerprint: "fp-laptop"})
+	require.NoError(t, err)
+	_, err = client.ValidateToken(ctx, laptop.PlainText)
+	assert.ErrorIs(t, err, goauth.ErrTokenRevoked)
+	devices, err = client.ListDevices(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, devices, 2)
+
+	// Devices can only be revoked by their owner
+	phoneTok, err := client.ValidateToken(ctx, phone.PlainText)
+	require.NoError(t, err)
+	assert.ErrorIs(t, client.RevokeDevice(ctx, 2, phoneTok.ID), goauth.ErrTokenNotFound)
+	require.NoError(t, client.RevokeDevice(ctx, 1, phoneTok.ID))
+	_, err = client.ValidateToken(ctx, phone.PlainText)
+	assert.ErrorIs(t, err, goauth.ErrTokenRevoked)
+	_, err = client.ValidateToken(ctx, again.PlainText)
+	assert.NoError(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithStorage(goauth.NewMemoryStorage()))
+	require.NoError(t, err)
+	defer client.Close()
+
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 3, Abilities: []string{"read:posts"}})
+	require.NoError(t, err)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tok, _ := middleware.TokenFromContext(r.Context())
+		fmt.Fprint(w, tok.UserId)
+	})
+	// As chi composes r.Use(Authenticate) with r.With(RequireAbility)
+	read := middleware.Authenticate(client)(middleware.RequireAbility(client, "read:posts")(ok))
+	write := middleware.Authenticate(client)(middleware.RequireAbility(client, "read:posts", "write:posts")(ok))
+
+	serve := func(h http.Handler, auth string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/posts", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(read, "Bearer "+raw)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "3", w.Body.String())
+
+	w = serve(write, "Bearer "+raw)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var body middleware.Error
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, []string{"write:posts"}, body.MissingAbilities)
+
+	w = serve(read, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	w = serve(middleware.RequireAbility(client, "read:posts")(ok), "Bearer 1|nope")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+}
+
+func TestCookieAuth(t *testing.T) {
+	ctx := context.Background()
+	fast := password.Argon2id(password.Argon2Params{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32})
+	client, err := goauth.NewClient(goauth.WithMemoryStorage(),
+		goauth.WithPasswords(password.NewMemoryStore(), goauth.PasswordOptions{Hasher: fast, Token: goauth.TokenOptions{Abilities: []string{"read:posts"}}}))
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.SetPassword(ctx, 5, "carol@example.com", "correct horse battery"))
+
+	opts := middleware.CookieOptions{}
+	mux := http.NewServeMux()
+	mux.Handle("POST /login", middleware.LoginHandler(client, opts, nil))
+	mux.Handle("POST /logout", middleware.LogoutHandler(client, opts))
+	protected := middleware.CookieAuthenticate(client, opts)(middleware.RequireAbility(client, "read:posts")(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tok, _ := middleware.TokenFromContext(r.Context())
+			fmt.Fprint(w, tok.UserId, " ", middleware.CSRFToken(r))
+		})))
+	mux.Handle("/posts", protected)
+
+	serve := func(method, path string, body url.Values, cookies []*http.Cookie, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "https://app.example.com"+path, strings.NewReader(body.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("POST", "/login", url.Values{"identifier": {"carol@example.com"}, "password": {"wrong password!"}}, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, w.Result().Cookies())
+
+	w = serve("POST", "/login", url.Values{"identifier": {"carol@example.com"}, "password": {"correct horse battery"}}, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var login struct {
+		CSRFToken string `json:"csrf_token"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &login))
+	require.NotEmpty(t, login.CSRFToken)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 2)
+	byName := map[string]*http.Cookie{}
+	for _, c := range cookies {
+		byName[c.Name] = c
+		assert.True(t, c.Secure)
+		assert.Equal(t, http.SameSiteLaxMode, c.SameSite)
+	}
+	session, csrf := byName["goauth_session"], byName["goauth_csrf"]
+	require.NotNil(t, session)
+	require.NotNil(t, csrf)
+	assert.True(t, session.HttpOnly)
+	assert.False(t, csrf.HttpOnly, "scripts read the CSRF cookie")
+	assert.Equal(t, login.CSRFToken, csrf.Value)
+	assert.NotEqual(t, session.Value, csrf.Value)
+
+	w = serve("GET", "/posts", nil, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serve("GET", "/posts", nil, []*http.Cookie{session}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "5 "+login.CSRFToken, w.Body.String())
+
+	// State-changing requests must echo the CSRF token
+	w = serve("POST", "/posts", nil, []*http.Cookie{session, csrf}, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve("POST", "/posts", nil, []*http.Cookie{session, csrf}, http.Header{"X-Csrf-Token": {"forged"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve("POST", "/posts", nil, []*http.Cookie{session, csrf}, http.Header{"X-Csrf-Token": {login.CSRFToken}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve("POST", "/posts", url.Values{"csrf_token": {login.CSRFToken}}, []*http.Cookie{session}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Bearer clients share the routes without CSRF
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 9, Abilities: []string{"read:posts"}})
+	require.NoError(t, err)
+	w = serve("POST", "/posts", nil, nil, http.Header{"Authorization": {"Bearer " + raw}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve("POST", "/logout", nil, []*http.Cookie{session}, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "logout needs the CSRF token too")
+	w = serve("POST", "/logout", url.Values{"csrf_token": {login.CSRFToken}}, []*http.Cookie{session}, nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	for _, c := range w.Result().Cookies() {
+		assert.Negative(t, c.MaxAge, "%s is cleared", c.Name)
+	}
+	w = serve("GET", "/posts", nil, []*http.Cookie{session}, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "the token was revoked")
+	require.NotEmpty(t, w.Result().Cookies(), "stale cookies are cleared")
+	_, err = client.ValidateToken(ctx, session.Value)
+	assert.ErrorIs(t, err, goauth.ErrTokenInvalid)
+
+	// Two-factor users get a challenge instead of a session
+	mfaClient, err := goauth.NewClient(goauth.WithMemoryStorage(),
+		goauth.WithPasswords(password.NewMemoryStore(), goauth.PasswordOptions{Hasher: fast}),
+		goauth.WithMFA(mfa.NewMemoryStore(), goauth.MFAOptions{Issuer: "Example"}))
+	require.NoError(t, err)
+	defer mfaClient.Close()
+	require.NoError(t, mfaClient.SetPassword(ctx, 6, "dave@example.com", "correct horse battery"))
+	key, err := mfaClient.EnrollTOTP(ctx, 6, "dave@example.com")
+	require.NoError(t, err)
+	code, err := mfa.Code(key.Secret, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, mfaClient.ConfirmTOTP(ctx, 6, code))
+	code, err = mfa.Code(key.Secret, time.Now().Add(mfa.Period)) // Each step's code works once
+	require.NoError(t, err)
+
+	mux = http.NewServeMux()
+	mux.Handle("POST /login", middleware.LoginHandler(mfaClient, opts, nil))
+	mux.Handle("POST /login/mfa", middleware.LoginHandler(mfaClient, middleware.CookieOptions{AfterLogin: "/home"}, func(r *http.Request) (*goauth.TokenResult, error) {
+		return mfaClient.CompleteMFA(r.Context(), r.PostFormValue("challenge"), r.PostFormValue("code"))
+	}))
+	w = serve("POST", "/login", url.Values{"identifier": {"dave@example.com"}, "password": {"correct horse battery"}}, nil, nil)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, w.Result().Cookies())
+	var challenge struct {
+		Error        string `json:"error"`
+		MFAChallenge string `json:"mfa_challenge"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &challenge))
+	assert.Equal(t, "mfa_required", challenge.Error)
+	require.NotEmpty(t, challenge.MFAChallenge)
+
+	w = serve("POST", "/login/mfa", url.Values{"challenge": {challenge.MFAChallenge}, "code": {code}}, nil, nil)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/home", w.Header().Get("Location"))
+	assert.Len(t, w.Result().Cookies(), 2)
+}
+
+func TestOrgQuotas(t *testing.T) {
+	ctx := context.Background()
+	warnings := make(chan goauth.QuotaUsage, 8)
+	client, err := goauth.NewClient(
+		goauth.WithStorage(goauth.NewMemoryStorage()),
+		goauth.WithOrgQuotas(func(orgID int64) (goauth.OrgQuota, error) {
+			return goauth.OrgQuota{MaxActiveTokens: 3, MaxSessions: 1}, nil
+		}),
+		goauth.WithQuotaWarning(0.6, func(u goauth.QuotaUsage) { warnings <- u }),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	org, err := client.CreateOrg(ctx, "acme")
+	require.NoError(t, err)
+	team, err := client.CreateTeam(ctx, org.ID, "platform")
+	require.NoError(t, err)
+	require.NoError(t, client.AddMember(ctx, org.ID, 1))
+	require.NoError(t, client.AddMember(ctx, team.ID, 2))
+
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	// Team members count against the org
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 2})
+	require.NoError(t, err)
+	select {
+	case u := <-warnings:
+		assert.Equal(t, goauth.QuotaUsage{OrgID: org.ID, Quota: goauth.QuotaActiveTokens, Used: 2, Limit: 3}, u)
+	case <-time.After(time.Second):
+		t.Fatal("no quota warning")
+	}
+
+	_, err = client.CreateTokenPair(ctx, &goauth.TokenOptions{UserId: 2})
+	require.NoError(t, err)
+	_, err = client.CreateTokenPair(ctx, &goauth.TokenOptions{UserId: 1})
+	var qe *goauth.QuotaError
+	require.ErrorAs(t, err, &qe)
+	assert.Equal(t, goauth.QuotaActiveTokens, qe.Quota)
+	assert.ErrorIs(t, err, goauth.ErrQuotaExceeded)
+
+	// Users outside any org are unaffected
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 9})
+	assert.NoError(t, err)
+
+	usage, err := client.OrgQuotaUsage(ctx, org.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []goauth.QuotaUsage{
+		{OrgID: org.ID, Quota: goauth.QuotaActiveTokens, Used: 3, Limit: 3},
+		{OrgID: org.ID, Quota: goauth.QuotaSessions, Used: 1, Limit: 1},
+	}, usage)
+}
+
+func TestUsageMetering(t *testing.T) {
+	ctx := context.Background()
+	var records []metering.Record
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 30, 0, 0, time.UTC))
+	client, err := goauth.NewClient(
+		goauth.WithStorage(goauth.NewMemoryStorage()),
+		goauth.WithClock(clock),
+		goauth.WithUsageMetering(metering.SinkFunc(func(_ context.Context, rs []metering.Record) error {
+			records = append(records, rs...)
+			return nil
+		})),
+	)
+	require.NoError(t, err)
+
+	org, err := client.CreateOrg(ctx, "acme")
+	require.NoError(t, err)
+	team, err := client.CreateTeam(ctx, org.ID, "platform")
+	require.NoError(t, err)
+	require.NoError(t, client.AddMember(ctx, org.ID, 1))
+	require.NoError(t, client.AddMember(ctx, team.ID, 2))
+
+	token, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	pair, err := client.CreateTokenPair(ctx, &goauth.TokenOptions{UserId: 2})
+	require.NoError(t, err)
+	for _, raw := range []string{token, token, pair.AccessToken} {
+		_, err = client.ValidateToken(ctx, raw)
+		require.NoError(t, err)
+	}
+	// Users in no org aren't metered
+	loner, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 9})
+	require.NoError(t, err)
+	_, err = client.ValidateToken(ctx, loner)
+	require.NoError(t, err)
+
+	// Close writes the unfinished hour
+	require.NoError(t, client.Close())
+	require.Len(t, records, 1)
+	r := records[0]
+	assert.Equal(t, org.ID, r.OrgID)
+	assert.Equal(t, time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC), r.Hour)
+	assert.Equal(t, int64(3), r.Validations)
+	assert.Equal(t, int64(2), r.ActiveTokens)
+	assert.Equal(t, int64(1), r.Sessions)
+	assert.True(t, r.Partial)
+
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	sink := metering.NewFileSink(path)
+	require.NoError(t, sink.Write(ctx, records))
+	require.NoError(t, sink.Write(ctx, records))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	var decoded metering.Record
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &decoded))
+	assert.Equal(t, int64(3), decoded.Validations)
+}
+
+func TestVerifierServer(t *testing.T) {
+	ctx := context.Background()
+	srv, err := verifier.New("s3cret", goauth.WithLocator(goauth.HashPrefixLocator(12)))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Seed from a token set exported before the primary started pushing
+	db := goauth.NewMemoryStorage()
+	old, err := goauth.NewClient(goauth.WithStorage(db), goauth.WithLocator(goauth.HashPrefixLocator(12)))
+	require.NoError(t, err)
+	defer old.Close()
+	seeded, err := old.CreateToken(ctx, &goauth.TokenOptions{UserId: 7})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "tokens.gats")
+	_, err = old.ExportTokenSetFile(ctx, path)
+	require.NoError(t, err)
+	n, err := srv.SeedFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	primary, err := goauth.NewClient(goauth.WithStorage(db),
+		goauth.WithLocator(goauth.HashPrefixLocator(12)),
+		goauth.WithReplication(goauth.ReplicationOptions{SyncPeers: 1},
+			goauth.Replica{Region: "verifier", Storage: verifier.NewPeer(ts.URL, "s3cret", ts.Client())}))
+	require.NoError(t, err)
+	defer primary.Close()
+
+	verify := func(raw string, abilities ...string) (int, verifier.Result) {
+		q := url.Values{"ability": abilities}
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/verify?"+q.Encode(), nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+raw)
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var res verifier.Result
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	code, res := verify(seeded)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, int64(7), res.UserID)
+
+	raw, err := primary.CreateToken(ctx, &goauth.TokenOptions{UserId: 1, Abilities: []string{"posts:read"}})
+	require.NoError(t, err)
+	code, res = verify(raw, "posts:read")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, res.Active)
+	assert.Equal(t, []string{"posts:read"}, res.Abilities)
+	code, res = verify(raw, "posts:write")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, []string{"posts:write"}, res.MissingAbilities)
+
+	// Revocations reach the verifier, including of seeded tokens
+	require.NoError(t, primary.RevokeToken(ctx, raw))
+	require.NoError(t, primary.RevokeToken(ctx, seeded))
+	for _, tok := range []string{raw, seeded} {
+		code, res = verify(tok)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.False(t, res.Active)
+	}
+
+	// The replication endpoint requires the shared secret
+	resp, err := ts.Client().Post(ts.URL+"/v1/replicate", "application/json", strings.NewReader("[]"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = ts.Client().Get(ts.URL + "/healthz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var health verifier.Health
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+	assert.Equal(t, int64(2), health.Tokens)
+	assert.NotNil(t, health.LastChange)
+}
+
+func TestChangesSince(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "changes.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	for name, store := range map[string]goauth.StorageDriver{
+		"memory": goauth.NewMemoryStorage(),
+		"gorm":   goauth.NewGormStorage(db),
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, err := goauth.NewClient(goauth.WithStorage(store), goauth.WithSoftRevocation())
+			require.NoError(t, err)
+			defer client.Close()
+
+			_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+			require.NoError(t, err)
+			_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+			require.NoError(t, err)
+			_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 2})
+			require.NoError(t, err)
+
+			page, err := client.ChangesSince(ctx, goauth.ChangeCursor{}, 0)
+			require.NoError(t, err)
+			require.Len(t, page.Tokens, 3)
+			cursor := page.Next
+
+			// Extending and revoking are changes; last-used touches aren't
+			first := page.Tokens[0]
+			require.NoError(t, store.TouchLastUsed(first.ID))
+			require.NoError(t, store.UpdateExpiry(first.ID, time.Now().Add(time.Hour)))
+			page, err = client.ChangesSince(ctx, cursor, 0)
+			require.NoError(t, err)
+			require.Len(t, page.Tokens, 1)
+			assert.Equal(t, first.ID, page.Tokens[0].ID)
+			assert.NotNil(t, page.Tokens[0].ExpiresAt)
+			assert.Greater(t, page.Tokens[0].Revision, cursor.Revision)
+
+			// A bulk revocation pages across rows sharing a revision
+			n, err := client.RevokeUserTokens(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, int64(2), n)
+			var revoked []*goauth.PersonalAccessToken
+			for c := page.Next; ; {
+				p, err := client.ChangesSince(ctx, c, 1)
+				require.NoError(t, err)
+				if len(p.Tokens) == 0 {
+					assert.Equal(t, c, p.Next)
+					break
+				}
+				revoked = append(revoked, p.Tokens...)
+				c = p.Next
+			}
+			require.Len(t, revoked, 2)
+			for _, tok := range revoked {
+				assert.NotNil(t, tok.RevokedAt)
+				assert.False(t, tok.UpdatedAt.IsZero())
+			}
+			assert.NotEqual(t, revoked[0].ID, revoked[1].ID)
+
+			// Held back until settled
+			settled, err := goauth.NewClient(goauth.WithStorage(store), goauth.WithChangeSettle(time.Hour))
+			require.NoError(t, err)
+			defer settled.Close()
+			page, err = settled.ChangesSince(ctx, cursor, 0)
+			require.NoError(t, err)
+			assert.Empty(t, page.Tokens)
+			assert.Equal(t, cursor, page.Next)
+		})
+	}
+
+	// Changes settle by the client's clock. Revisions never go backwards,
+	// so it starts past every revision handed out so far.
+	store := goauth.NewMemoryStorage()
+	plain, err := goauth.NewClient(goauth.WithStorage(store))
+	require.NoError(t, err)
+	defer plain.Close()
+	_, err = plain.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	page, err := plain.ChangesSince(ctx, goauth.ChangeCursor{}, 0)
+	require.NoError(t, err)
+	require.Len(t, page.Tokens, 1)
+
+	clock := goauthtest.NewClock(time.Unix(0, page.Next.Revision).Add(time.Minute))
+	settled, err := goauth.NewClient(goauth.WithStorage(store), goauth.WithClock(clock), goauth.WithChangeSettle(time.Hour))
+	require.NoError(t, err)
+	defer settled.Close()
+	_, err = settled.CreateToken(ctx, &goauth.TokenOptions{UserId: 2})
+	require.NoError(t, err)
+	held, err := settled.ChangesSince(ctx, page.Next, 0)
+	require.NoError(t, err)
+	assert.Empty(t, held.Tokens)
+	clock.Advance(time.Hour + time.Second)
+	held, err = settled.ChangesSince(ctx, page.Next, 0)
+	require.NoError(t, err)
+	require.Len(t, held.Tokens, 1)
+	assert.EqualValues(t, 2, held.Tokens[0].UserId)
+}
+
+func TestNewClientFromFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := filepath.Join(dir, "tokens.db")
+
+	yamlPath := filepath.Join(dir, "goauth.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`
+storage:
+  dsn: sqlite:`+db+`
+token_prefix: app_
+token_ttl: 2h
+locator: ulid
+guest_abilities: [read, list]
+`), 0o600))
+	tomlPath := filepath.Join(dir, "goauth.toml")
+	require.NoError(t, os.WriteFile(tomlPath, []byte(`
+token_prefix = "app_" # scanned by the secret scanner
+token_ttl = "2h"
+locator = 'ulid'
+
+[storage]
+dsn = "memory"
+`), 0o600))
+
+	for _, path := range []string{yamlPath, tomlPath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			client, err := goauth.NewClientFromFile(path)
+			require.NoError(t, err)
+			defer client.Close()
+
+			assert.Equal(t, "app_", client.TokenFormat().Prefix)
+			res, err := client.IssueToken(ctx, &goauth.TokenOptions{UserId: 1})
+			require.NoError(t, err)
+			require.NotNil(t, res.ExpiresAt)
+			assert.WithinDuration(t, time.Now().Add(2*time.Hour), *res.ExpiresAt, time.Minute)
+			loc, _, _ := strings.Cut(res.PlainText, "|")
+			assert.Len(t, loc, 26, "ULID locator")
+
+			_, err = client.ValidateToken(ctx, res.PlainText)
+			require.NoError(t, err)
+		})
+	}
+
+	// The sqlite file was created and holds the token
+	_, err := os.Stat(db)
+	require.NoError(t, err)
+
+	// Environment variables override the file
+	t.Setenv("GOAUTH_TOKEN_PREFIX", "env_")
+	client, err := goauth.NewClientFromFile(yamlPath)
+	require.NoError(t, err)
+	assert.Equal(t, "env_", client.TokenFormat().Prefix)
+	client.Close()
+
+	bad := filepath.Join(dir, "bad.yaml")
+	require.NoError(t, os.WriteFile(bad, []byte("token_tll: 2h\n"), 0o600))
+	_, err = goauth.NewClientFromFile(bad)
+	assert.ErrorContains(t, err, "unknown settings: token_tll")
+
+	require.NoError(t, os.WriteFile(bad, []byte("token_ttl: soon\n"), 0o600))
+	_, err = goauth.NewClientFromFile(bad)
+	assert.ErrorContains(t, err, "setting token_ttl")
+
+	require.NoError(t, os.WriteFile(bad, []byte("storage_dsn: mongo://x\n"), 0o600))
+	_, err = goauth.NewClientFromFile(bad)
+	assert.ErrorContains(t, err, `no storage registered for "mongo`)
+}
+
+func TestNewClientFromEnv(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("GOAUTH_STORAGE_DSN", "memory")
+	t.Setenv("GOAUTH_TOKEN_TTL", "90m")
+	t.Setenv("GOAUTH_LOCATOR", "hash:8")
+	t.Setenv("GOAUTH_SOFT_REVOCATION", "true")
+
+	client, err := goauth.NewClientFromEnv(goauth.WithTokenPrefix("opt_"))
+	require.NoError(t, err)
+	defer client.Close()
+
+	assert.Equal(t, "opt_", client.TokenFormat().Prefix)
+	res, err := client.IssueToken(ctx, &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+	require.NotNil(t, res.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(90*time.Minute), *res.ExpiresAt, time.Minute)
+	loc, _, _ := strings.Cut(res.PlainText, "|")
+	assert.Len(t, loc, 8)
+
+	t.Setenv("GOAUTH_LOCATOR", "sha")
+	_, err = goauth.NewClientFromEnv()
+	assert.ErrorContains(t, err, "setting locator")
+}
+
+func TestGoauthTestDriver(t *testing.T) {
+	ctx := context.Background()
+	client, store := goauthtest.NewTestClient(t,
+		goauth.WithTokenPrefix("app_"),
+		goauth.WithTokenExpiration(time.Hour),
+	)
+
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 7, Name: stringPtr("ci")})
+	require.NoError(t, err)
+	goauthtest.AssertShape(t, client, raw, "<id>|app_<random:64><crc32c:8>")
+
+	tokens := store.Tokens()
+	require.Len(t, tokens, 1)
+	assert.Equal(t, int64(7), tokens[0].UserId)
+	assert.Equal(t, "ci", *tokens[0].Name)
+
+	// Injected failures surface from the client
+	diskFull := errors.New("disk full")
+	store.FailOnce("StoreToken", diskFull)
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 7})
+	assert.ErrorIs(t, err, diskFull)
+	_, err = client.CreateToken(ctx, &goauth.TokenOptions{UserId: 7})
+	require.NoError(t, err)
+	assert.Equal(t, 3, store.Calls("StoreToken"))
+
+	store.FailOn("FindByHash", diskFull)
+	_, err = client.ValidateToken(ctx, raw)
+	assert.ErrorIs(t, err, diskFull)
+	store.FailOn("FindByHash", nil)
+
+	// The driver's clock stamps last use and decides expiry
+	frozen := time.Now().Add(time.Minute).Truncate(time.Second)
+	store.Freeze(frozen)
+	_, err = client.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+	require.NotNil(t, store.Tokens()[0].LastUsedAt)
+	assert.True(t, store.Tokens()[0].LastUsedAt.Equal(frozen))
+
+	store.Advance(2 * time.Hour)
+	_, err = client.ValidateToken(ctx, raw)
+	assert.ErrorIs(t, err, goauth.ErrTokenExpired)
+
+	// Golden files are written on demand and compared otherwise
+	golden := filepath.Join(t.TempDir(), "testdata", "token.golden")
+	shape, err := goauthtest.Shape(client, raw)
+	require.NoError(t, err)
+	t.Setenv(goauthtest.UpdateEnv, "1")
+	goauthtest.AssertGolden(t, golden, []byte(shape))
+	t.Setenv(goauthtest.UpdateEnv, "")
+	goauthtest.AssertGolden(t, golden, []byte(shape))
+
+	_, err = goauthtest.Shape(client, "1|not-a-token")
+	assert.Error(t, err)
+
+	store.Reset()
+	assert.Empty(t, store.Tokens())
+	assert.Zero(t, store.Calls("StoreToken"))
+}
+
+func TestAuditEvents(t *testing.T) {
+	ctx := goauth.ContextWithClientIP(context.Background(), "203.0.113.9")
+	var mu sync.Mutex
+	var events []siem.Event
+	sink := siem.SinkFunc(func(_ context.Context, batch []siem.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, batch...)
+		return nil
+	})
+
+	client, err := goauth.NewClient(
+		goauth.WithMemoryStorage(),
+		goauth.WithAuditEvents(sink),
+		goauth.WithRateLimiter(ratelimit.NewMemory(1, time.Hour)),
+	)
+	require.NoError(t, err)
+
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 5, Abilities: []string{"read"}})
+	require.NoError(t, err)
+	loc, _, _ := strings.Cut(raw, "|")
+	_, err = client.ValidateTokenWithAbility(ctx, raw, "admin")
+	require.ErrorIs(t, err, goauth.ErrAbilityDenied)
+	require.NoError(t, client.RevokeToken(ctx, raw))
+	_, err = client.ValidateToken(ctx, raw)
+	require.ErrorIs(t, err, goauth.ErrTokenNotFound)
+	_, err = client.ValidateToken(ctx, raw)
+	require.ErrorIs(t, err, goauth.ErrRateLimited)
+	require.NoError(t, client.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+		assert.Equal(t, "203.0.113.9", e.SourceIP)
+		assert.Equal(t, loc, e.Locator)
+		assert.Equal(t, goauth.Version(), e.Version)
+		assert.NotContains(t, e.Reason, raw)
+	}
+	assert.Equal(t, []string{
+		siem.TokenCreated, siem.AbilityDenied, siem.TokenRevoked, siem.ValidationFailed, siem.RateLimited,
+	}, types)
+	assert.Equal(t, int64(5), events[0].UserID)
+	assert.Equal(t, siem.Failure, events[1].Outcome)
+	assert.Equal(t, "admin", events[1].Reason)
+	assert.Greater(t, events[4].Severity, events[0].Severity)
+}
+
+func TestSIEMFormats(t *testing.T) {
+	e := siem.Event{
+		Time:     time.UnixMilli(1700000000000),
+		Type:     siem.ValidationFailed,
+		Severity: 5,
+		Outcome:  siem.Failure,
+		Reason:   "token a=b|c",
+		UserID:   42,
+		Locator:  "17",
+		SourceIP: "198.51.100.1",
+		Version:  "v1.2.3",
+	}
+
+	cef, err := siem.CEF().Format(e)
+	require.NoError(t, err)
+	assert.Equal(t, `CEF:0|goauth|goauth|v1.2.3|token.validation_failed|Token validation failed|5|`+
+		`rt=1700000000000 outcome=failure reason=token a\=b|c suser=42 src=198.51.100.1 cs1Label=tokenLocator cs1=17`, string(cef))
+
+	leef, err := siem.LEEF().Format(e)
+	require.NoError(t, err)
+	assert.Equal(t, "LEEF:2.0|goauth|goauth|v1.2.3|token.validation_failed|x09|"+
+		"devTime=1700000000000\tdevTimeFormat=milliseconds\tcat=Token validation failed\tsev=5\toutcome=failure\t"+
+		"reason=token a=b|c\tusrName=42\tsrc=198.51.100.1\ttokenLocator=17", string(leef))
+
+	raw, err := siem.OCSF().Format(e)
+	require.NoError(t, err)
+	var ocsf map[string]any
+	require.NoError(t, json.Unmarshal(raw, &ocsf))
+	assert.EqualValues(t, 3002, ocsf["class_uid"])
+	assert.EqualValues(t, 300201, ocsf["type_uid"])
+	assert.EqualValues(t, 2, ocsf["status_id"])
+	assert.EqualValues(t, 3, ocsf["severity_id"])
+	assert.Equal(t, "42", ocsf["user"].(map[string]any)["uid"])
+	assert.Equal(t, "198.51.100.1", ocsf["src_endpoint"].(map[string]any)["ip"])
+	assert.Equal(t, "17", ocsf["unmapped"].(map[string]any)["token_locator"])
+}
+
+func TestSIEMForwarders(t *testing.T) {
+	ctx := context.Background()
+	events := []siem.Event{
+		{Time: time.Now(), Type: siem.TokenCreated, Severity: 2, Outcome: siem.Success, UserID: 1},
+		{Time: time.Now(), Type: siem.RefreshTokenReused, Severity: 9, Outcome: siem.Failure},
+	}
+
+	t.Run("syslog", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+		received := make(chan string, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			data, _ := io.ReadAll(conn)
+			received <- string(data)
+		}()
+
+		fwd := siem.NewSyslogForwarder("tcp", ln.Addr().String(), siem.CEF())
+		require.NoError(t, fwd.Write(ctx, events))
+		require.NoError(t, fwd.Close())
+
+		data := <-received
+		// Octet-counted RFC 5424 frames, facility authpriv
+		first, rest, ok := strings.Cut(data, " ")
+		require.True(t, ok)
+		n, err := strconv.Atoi(first)
+		require.NoError(t, err)
+		msg := rest[:n]
+		assert.True(t, strings.HasPrefix(msg, "<85>1 "), msg)
+		assert.Contains(t, msg, " goauth ")
+		assert.Contains(t, msg, "CEF:0|goauth|goauth||token.created|")
+		assert.Contains(t, rest[n:], "<82>1 ")
+	})
+
+	t.Run("http", func(t *testing.T) {
+		var body, contentType, auth string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			body, contentType, auth = string(b), r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		}))
+		defer srv.Close()
+
+		fwd := siem.NewHTTPForwarder(srv.URL, siem.OCSF(), http.Header{"Authorization": {"Splunk abc"}}, nil)
+		require.NoError(t, fwd.Write(ctx, events))
+		assert.Equal(t, "application/x-ndjson", contentType)
+		assert.Equal(t, "Splunk abc", auth)
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		require.Len(t, lines, 2)
+		assert.True(t, json.Valid([]byte(lines[1])))
+
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer failing.Close()
+		err := siem.NewHTTPForwarder(failing.URL, siem.LEEF(), nil, nil).Write(ctx, events)
+		assert.ErrorContains(t, err, "403")
+	})
+}
+
+func TestWithClock(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "clock.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&goauth.PersonalAccessToken{}))
+
+	for name, store := range map[string]goauth.StorageDriver{
+		"memory": goauth.NewMemoryStorage(),
+		"gorm":   goauth.NewGormStorage(db),
+	} {
+		t.Run(name, func(t *testing.T) {
+			start := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+			clock := goauthtest.NewClock(start)
+			client, err := goauth.NewClient(
+				goauth.WithStorage(store),
+				goauth.WithClock(clock),
+				goauth.WithTokenExpiration(time.Hour),
+				goauth.WithLastUsedTracking(goauth.LastUsedSync),
+			)
+			require.NoError(t, err)
+			defer client.Close()
+
+			res, err := client.IssueToken(ctx, &goauth.TokenOptions{UserId: 3})
+			require.NoError(t, err)
+			assert.True(t, res.ExpiresAt.Equal(start.Add(time.Hour)))
+
+			clock.Advance(59 * time.Minute)
+			tok, err := client.ValidateToken(ctx, res.PlainText)
+			require.NoError(t, err)
+			assert.True(t, tok.CreatedAt.Equal(start))
+			stored, err := client.GetTokenInfo(ctx, res.PlainText)
+			require.NoError(t, err)
+			require.NotNil(t, stored.LastUsedAt)
+			assert.True(t, stored.LastUsedAt.Equal(start.Add(59*time.Minute)))
+
+			clock.Advance(2 * time.Minute)
+			_, err = client.ValidateToken(ctx, res.PlainText)
+			assert.ErrorIs(t, err, goauth.ErrTokenExpired)
+			expired, _, err := client.ListTokens(ctx, 3, &goauth.ListOptions{Status: goauth.StatusExpired})
+			require.NoError(t, err)
+			assert.Len(t, expired, 1)
+
+			n, err := client.PruneExpired(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), n)
+
+			// Explicit expiries are checked against the clock, not the wall
+			clock.Set(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+			at := clock.Now().Add(time.Hour)
+			res, err = client.IssueToken(ctx, &goauth.TokenOptions{UserId: 3, ExpiresAt: &at})
+			require.NoError(t, err)
+			assert.True(t, res.ExpiresAt.Equal(at))
+			assert.True(t, client.Now().Equal(clock.Now()))
+		})
+	}
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithClock(nil))
+	assert.Error(t, err)
+}
+
+func TestResponsePolicy(t *testing.T) {
+	places := map[string][2]float64{
+		"198.51.100.1": {52.52, 13.40},  // Berlin
+		"198.51.100.2": {52.37, 4.90},   // Amsterdam
+		"203.0.113.1":  {-33.87, 151.2}, // Sydney
+	}
+	locate := func(ip string) (float64, float64, bool) {
+		p, ok := places[ip]
+		return p[0], p[1], ok
+	}
+	from := func(ip string) context.Context {
+		return goauth.ContextWithClientIP(context.Background(), ip)
+	}
+
+	policy, err := goauth.ParseResponsePolicy("impossible_travel: suspend, notify\n# comment")
+	require.NoError(t, err)
+	assert.Equal(t, goauth.ResponsePolicy{"impossible_travel": {"suspend", "notify"}}, policy)
+	_, err = goauth.ParseResponsePolicy("impossible_travel: explode")
+	assert.Error(t, err)
+
+	var mu sync.Mutex
+	var events []siem.Event
+	notified := make(chan goauth.Finding, 1)
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	client, err := goauth.NewClient(
+		goauth.WithMemoryStorage(),
+		goauth.WithClock(clock),
+		goauth.WithResponsePolicy(policy, goauth.ImpossibleTravel(locate, 1000)),
+		goauth.WithNotifier(func(_ context.Context, f goauth.Finding) error {
+			notified <- f
+			return nil
+		}),
+		goauth.WithAuditEvents(siem.SinkFunc(func(_ context.Context, batch []siem.Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, batch...)
+			return nil
+		})),
+	)
+	require.NoError(t, err)
+
+	res, err := client.IssueToken(context.Background(), &goauth.TokenOptions{UserId: 8})
+	require.NoError(t, err)
+
+	_, err = client.ValidateToken(from("198.51.100.1"), res.PlainText)
+	require.NoError(t, err)
+	clock.Advance(2 * time.Hour)
+	_, err = client.ValidateToken(from("198.51.100.2"), res.PlainText)
+	require.NoError(t, err, "Berlin to Amsterdam in two hours is plausible")
+
+	clock.Advance(time.Hour)
+	_, err = client.ValidateToken(from("203.0.113.1"), res.PlainText)
+	require.ErrorIs(t, err, goauth.ErrTokenSuspended)
+	assert.ErrorIs(t, err, goauth.ErrTokenInvalid)
+	f := <-notified
+	assert.Equal(t, "impossible_travel", f.Detector)
+	assert.Equal(t, int64(8), f.Token.UserId)
+
+	_, err = client.ValidateToken(from("203.0.113.1"), res.PlainText)
+	require.ErrorIs(t, err, goauth.ErrTokenSuspended)
+	require.NoError(t, client.ResumeToken(context.Background(), f.Token.ID))
+	_, err = client.ValidateToken(from("203.0.113.1"), res.PlainText)
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []string{
+		siem.TokenCreated, siem.SuspiciousUse, siem.AutomatedResponse, siem.AutomatedResponse,
+		siem.ValidationFailed, siem.TokenResumed,
+	}, types)
+	assert.Equal(t, "suspend on impossible_travel", events[2].Reason)
+	assert.Equal(t, "203.0.113.1", events[1].SourceIP)
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithResponsePolicy(policy))
+	assert.Error(t, err)
+}
+
+func TestCanaryValidation(t *testing.T) {
+	ctx := context.Background()
+	failures := make(chan goauth.CanaryFailure, 4)
+	clock := goauthtest.NewClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	client, err := goauth.NewClient(
+		goauth.WithMemoryStorage(),
+		goauth.WithClock(clock),
+		goauth.WithCanaryValidation(100, goauth.CanaryRules{MaxLifetime: 24 * time.Hour}, func(f goauth.CanaryFailure) {
+			failures <- f
+		}),
+	)
+	require.NoError(t, err)
+
+	short, err := client.IssueToken(ctx, &goauth.TokenOptions{UserId: 1, ExpiresIn: time.Hour})
+	require.NoError(t, err)
+	long, err := client.IssueToken(ctx, &goauth.TokenOptions{UserId: 2, ExpiresIn: 30 * 24 * time.Hour})
+	require.NoError(t, err)
+
+	_, err = client.ValidateToken(ctx, short.PlainText)
+	require.NoError(t, err)
+	_, err = client.ValidateToken(ctx, long.PlainText)
+	require.NoError(t, err, "canary rules never fail validation")
+
+	f := <-failures
+	assert.Equal(t, int64(2), f.UserID)
+	assert.Equal(t, goauth.CanaryMaxLifetime, f.Rule)
+	require.NoError(t, client.Close())
+
+	stats := client.CanaryStats()
+	assert.Equal(t, int64(2), stats.Sampled)
+	assert.Equal(t, int64(1), stats.WouldFail)
+	assert.Equal(t, map[string]int64{goauth.CanaryMaxLifetime: 1}, stats.ByRule)
+	assert.Equal(t, 50.0, stats.WouldFailPercent())
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithCanaryValidation(0, goauth.CanaryRules{}, nil))
+	assert.Error(t, err)
+}
+
+func TestWithRandReader(t *testing.T) {
+	ctx := context.Background()
+	broken, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithRandReader(iotest.ErrReader(errors.New("entropy exhausted"))))
+	require.NoError(t, err)
+	_, err = broken.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	assert.ErrorContains(t, err, "entropy exhausted")
+	_, err = broken.CreateTokenPair(ctx, &goauth.TokenOptions{UserId: 1})
+	assert.ErrorContains(t, err, "entropy exhausted")
+
+	short, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithRandReader(strings.NewReader("too short")))
+	require.NoError(t, err)
+	_, err = short.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	secret := func() string {
+		client, err := goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithRandReader(bytes.NewReader(make([]byte, 64))))
+		require.NoError(t, err)
+		raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 1})
+		require.NoError(t, err)
+		_, s, _ := strings.Cut(raw, "|")
+		return s
+	}
+	assert.Equal(t, secret(), secret())
+	assert.True(t, strings.HasPrefix(secret(), strings.Repeat("0", 64)))
+
+	_, err = goauth.NewClient(goauth.WithMemoryStorage(), goauth.WithRandReader(nil))
+	assert.Error(t, err)
+}
+
+func TestRevokeTokenByID(t *testing.T) {
+	ctx := context.Background()
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+
+	raw, err := client.CreateToken(ctx, &goauth.TokenOptions{UserId: 4})
+	require.NoError(t, err)
+	tok, err := client.ValidateToken(ctx, raw)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, client.RevokeTokenByID(ctx, 5, tok.ID), goauth.ErrTokenNotFound, "only the owner can revoke")
+	require.NoError(t, client.RevokeTokenByID(ctx, 4, tok.ID))
+	_, err = client.ValidateToken(ctx, raw)
+	assert.ErrorIs(t, err, goauth.ErrTokenInvalid)
+	assert.Error(t, client.RevokeTokenByID(ctx, 0, tok.ID))
+}
+
+func TestDefaultConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	assert.Nil(t, cfg.Storage, "no silent in-memory storage")
+	assert.Empty(t, cfg.SigningKey, "no built-in signing key")
+	assert.Error(t, cfg.Validate())
+
+	cfg.ApplyDefaults()
+	assert.Nil(t, cfg.Storage)
+
+	_, err := goauth.NewClient()
+	assert.ErrorContains(t, err, "no storage driver configured")
+	client, err := goauth.NewClient(goauth.WithMemoryStorage())
+	require.NoError(t, err)
+	_, err = client.CreateToken(context.Background(), &goauth.TokenOptions{UserId: 1})
+	require.NoError(t, err)
+}
@@ -0,0 +1,70 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// ErrInvalidCondition is returned for conditional grants whose condition
+// does not compile
+var ErrInvalidCondition = utils.ErrInvalidCondition
+
+// Authorize validates the token and checks that it grants the ability on
+// the given resource. Besides plain abilities, tokens may carry conditional
+// grants such as "posts:write when resource.owner == token.user_id"; the
+// condition sees the token as "token" (id, user_id, name, kind, meta) and
+// the resource map as "resource".
+func (c *Client) Authorize(ctx context.Context, raw, ability string, resource map[string]any) (*PersonalAccessToken, error) {
+	tok, err := c.ValidateToken(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	key, cacheable := decisionKey{}, false
+	if c.decisions != nil {
+		key, cacheable = decisionCacheKey(tok, ability, resource)
+	}
+
+	allowed, cached := false, false
+	if cacheable {
+		allowed, cached = c.decisions.Get(key)
+	}
+	if !cached {
+		allowed, err = c.authorize(ctx, tok, ability, resource)
+		if err != nil {
+			return nil, err
+		}
+		if cacheable {
+			c.decisions.Add(key, allowed)
+		}
+	}
+
+	if !allowed {
+		return nil, fmt.Errorf("%w: %s", utils.ErrAbilityDenied, ability)
+	}
+	return tok, nil
+}
+
+// authorize decides whether tok grants ability on resource. An error means
+// no decision could be made (storage failure or a condition that cannot be
+// evaluated) and is never cached.
+func (c *Client) authorize(ctx context.Context, tok *PersonalAccessToken, ability string, resource map[string]any) (bool, error) {
+	abilities := c.config.EffectiveAbilities(tok.Abilities)
+	if c.inheritAbilities {
+		effective, err := c.EffectiveAbilities(ctx, tok)
+		if err != nil {
+			return false, err
+		}
+		abilities = strings.Join(effective, ",")
+	}
+
+	ok, err := auth.Authorize(tok, c.config.AbilityMatcher, abilities, ability, resource)
+	if !ok && err != nil {
+		return false, fmt.Errorf("%w: %s: %v", utils.ErrAbilityDenied, ability, err)
+	}
+	return ok, nil
+}
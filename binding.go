@@ -0,0 +1,50 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// VersionRange restricts a token to an inclusive range of API versions
+type VersionRange = auth.VersionRange
+
+var (
+	// ErrAPIVersionNotAllowed is returned when a token is used outside its API version range
+	ErrAPIVersionNotAllowed = auth.ErrAPIVersionNotAllowed
+	// ErrFeatureNotAllowed is returned when a token isn't bound to a required feature flag
+	ErrFeatureNotAllowed = auth.ErrFeatureNotAllowed
+)
+
+// WithMinAPIVersion rejects tokens whose bound API range ends below version,
+// fencing off deprecated clients at the auth layer
+func WithMinAPIVersion(version string) Option {
+	return func(c *Client) error {
+		if version == "" {
+			return fmt.Errorf("API version cannot be empty")
+		}
+		if err := auth.ValidateVersion(version); err != nil {
+			return err
+		}
+		c.config.MinAPIVersion = version
+		return nil
+	}
+}
+
+// ValidateTokenForAPI validates the token and checks that it may call the
+// given API version and use every listed feature flag. Tokens created
+// without APIVersions or Features are unrestricted.
+func (c *Client) ValidateTokenForAPI(ctx context.Context, raw string, version string, features ...string) (*entity.PersonalAccessToken, error) {
+	tok, err := c.ValidateToken(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := auth.CheckBinding(c.config, tok, version, features); err != nil {
+		return nil, err
+	}
+
+	return tok, nil
+}
@@ -0,0 +1,90 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/cache"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// WithValidationBudget bounds how long ValidateToken may take. A
+// validation still running after d returns ErrValidationTimeout, or the
+// token's last successful result when WithValidationFallback is set, so
+// latency-sensitive endpoints can cap the tail latency auth adds. The
+// abandoned lookup finishes in the background.
+func WithValidationBudget(d time.Duration) Option {
+	return func(c *Client) error {
+		if d <= 0 {
+			return fmt.Errorf("validation budget must be positive")
+		}
+		c.budget = d
+		return nil
+	}
+}
+
+// WithValidationFallback remembers up to size successful validations and
+// serves them, if no older than maxAge, when a validation exceeds the
+// budget set by WithValidationBudget. Tokens revoked through this client
+// are forgotten at once; revocations elsewhere are seen within maxAge.
+func WithValidationFallback(size int, maxAge time.Duration) Option {
+	return func(c *Client) error {
+		if size <= 0 || maxAge <= 0 {
+			return fmt.Errorf("validation fallback needs a positive size and max age")
+		}
+		c.fallback = cache.New[string, *entity.PersonalAccessToken](size, maxAge)
+		return nil
+	}
+}
+
+type validation struct {
+	tok *entity.PersonalAccessToken
+	err error
+}
+
+// validateWithinBudget runs the validation in the background, giving up
+// after the client's budget
+func (c *Client) validateWithinBudget(ctx context.Context, raw string) (*entity.PersonalAccessToken, error) {
+	key := fallbackKey(raw)
+	done := make(chan validation, 1)
+	go func() {
+		tok, err := c.validate(ctx, raw)
+		if err == nil && c.fallback != nil {
+			c.fallback.Add(key, tok)
+		}
+		done <- validation{tok, err}
+	}()
+
+	timer := time.NewTimer(c.budget)
+	defer timer.Stop()
+	select {
+	case v := <-done:
+		return v.tok, v.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+	}
+
+	if c.fallback != nil {
+		tok, ok := c.fallback.Get(key)
+		if ok && (tok.ExpiresAt == nil || c.config.Now().Before(*tok.ExpiresAt)) && !c.config.Revocations.Revoked(tok) {
+			c.config.Logger.Warn("validation exceeded budget, serving cached result", "budget", c.budget, "token_id", tok.ID)
+			return tok, nil
+		}
+	}
+	return nil, fmt.Errorf("%w (%s)", utils.ErrValidationTimeout, c.budget)
+}
+
+// forget drops a revoked token from the validation fallback
+func (c *Client) forget(raw string) {
+	if c.fallback != nil {
+		c.fallback.Remove(fallbackKey(raw))
+	}
+}
+
+func fallbackKey(raw string) string {
+	return utils.HashToken(strings.TrimPrefix(raw, "Bearer "))
+}
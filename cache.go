@@ -0,0 +1,54 @@
+package goauth
+
+import (
+	"fmt"
+
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// TokenCache stores validated tokens by hash in front of the storage driver
+type TokenCache = storage.TokenCache
+
+// CacheOptions tunes the token cache
+type CacheOptions = storage.CacheOptions
+
+// tokenCacheOptions holds the cache configured by WithTokenCache
+type tokenCacheOptions struct {
+	cache TokenCache
+	opts  CacheOptions
+}
+
+// NewMemoryTokenCache returns an in-process LRU token cache holding at most
+// size tokens
+func NewMemoryTokenCache(size int) TokenCache {
+	return storage.NewMemoryTokenCache(size)
+}
+
+// NewRedisTokenCache returns a token cache shared through Redis, storing
+// entries under prefix (default "goauth:token:")
+func NewRedisTokenCache(rdb redis.UniversalClient, prefix string) TokenCache {
+	return storage.NewRedisTokenCache(rdb, prefix)
+}
+
+// WithTokenCache serves token lookups from cache in front of the storage
+// driver. Unknown hashes are cached for opts.NegativeTTL, new tokens are
+// written through and revocations through this client invalidate the
+// cache immediately. With several processes, use a shared (Redis) cache
+// so revocations are seen everywhere.
+func WithTokenCache(cache TokenCache, opts CacheOptions) Option {
+	return func(c *Client) error {
+		if cache == nil {
+			return fmt.Errorf("token cache cannot be nil")
+		}
+		c.tokenCache = &tokenCacheOptions{cache: cache, opts: opts}
+		return nil
+	}
+}
+
+func (c *Client) wrapCache() {
+	if c.tokenCache == nil {
+		return
+	}
+	c.storage = storage.NewCachingDriver(c.storage, c.tokenCache.cache, c.tokenCache.opts)
+}
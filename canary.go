@@ -0,0 +1,153 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// CanaryRules is a stricter rule set tried on a sample of successful
+// validations. Zero fields are not checked.
+type CanaryRules struct {
+	MaxLifetime      time.Duration // Tokens living longer from creation to expiry, or never expiring, would fail
+	MaxIdle          time.Duration // Tokens unused for longer before this use would fail
+	RequireIPBinding bool          // Tokens not bound to an IP would fail
+	Check            func(use TokenUse) error
+}
+
+// Canary rule names, as found in CanaryFailure.Rule
+const (
+	CanaryMaxLifetime = "max_lifetime"
+	CanaryMaxIdle     = "max_idle"
+	CanaryIPBinding   = "ip_binding"
+	CanaryCheck       = "check"
+)
+
+// CanaryFailure is a sampled validation the canary rules would have
+// rejected
+type CanaryFailure struct {
+	TokenID int64
+	UserID  int64
+	Rule    string
+	Reason  string
+	At      time.Time
+}
+
+// CanaryStats counts the validations sampled so far and how many of them
+// the canary rules would have rejected, per rule
+type CanaryStats struct {
+	Sampled   int64
+	WouldFail int64
+	ByRule    map[string]int64
+}
+
+// WouldFailPercent estimates the share of validations the rules would reject
+func (s CanaryStats) WouldFailPercent() float64 {
+	if s.Sampled == 0 {
+		return 0
+	}
+	return float64(s.WouldFail) * 100 / float64(s.Sampled)
+}
+
+// WithCanaryValidation re-checks percent (0-100] of successful validations
+// against rules and reports the ones they would reject, so the blast radius
+// of a tighter policy is known before it is enforced. Results never change
+// the validation outcome. Checks run in the background; report may be nil
+// when CanaryStats is enough.
+func WithCanaryValidation(percent float64, rules CanaryRules, report func(CanaryFailure)) Option {
+	return func(c *Client) error {
+		if percent <= 0 || percent > 100 {
+			return fmt.Errorf("canary percentage must be in (0, 100]")
+		}
+		if rules.MaxLifetime < 0 || rules.MaxIdle < 0 {
+			return fmt.Errorf("canary durations cannot be negative")
+		}
+		c.canary = &canary{percent: percent, rules: rules, report: report, byRule: map[string]int64{}}
+		return nil
+	}
+}
+
+// CanaryStats returns the canary counters. They are zero without
+// WithCanaryValidation.
+func (c *Client) CanaryStats() CanaryStats {
+	if c.canary == nil {
+		return CanaryStats{ByRule: map[string]int64{}}
+	}
+	c.canary.mu.Lock()
+	defer c.canary.mu.Unlock()
+	return CanaryStats{Sampled: c.canary.sampled, WouldFail: c.canary.wouldFail, ByRule: maps.Clone(c.canary.byRule)}
+}
+
+type canary struct {
+	percent float64
+	rules   CanaryRules
+	report  func(CanaryFailure)
+
+	mu        sync.Mutex
+	sampled   int64
+	wouldFail int64
+	byRule    map[string]int64
+}
+
+// sampleCanary rolls the dice for one successful validation of tok
+func (c *Client) sampleCanary(ctx context.Context, tok *entity.PersonalAccessToken) {
+	if rand.Float64()*100 >= c.canary.percent {
+		return
+	}
+	use := TokenUse{Token: tok, Time: c.config.Now()}
+	use.IP, _ = ctx.Value(clientIPKey{}).(string)
+
+	c.config.Workers.Go("canary-validation", func() error {
+		rule, reason := c.canary.rules.check(use)
+
+		c.canary.mu.Lock()
+		c.canary.sampled++
+		if rule != "" {
+			c.canary.wouldFail++
+			c.canary.byRule[rule]++
+		}
+		c.canary.mu.Unlock()
+
+		if rule != "" && c.canary.report != nil {
+			c.canary.report(CanaryFailure{TokenID: tok.ID, UserID: tok.UserId, Rule: rule, Reason: reason, At: use.Time})
+		}
+		return nil
+	})
+}
+
+// check returns the first rule use fails and why, or "" when it passes
+func (r CanaryRules) check(use TokenUse) (rule, reason string) {
+	tok := use.Token
+	if r.MaxLifetime > 0 {
+		if tok.ExpiresAt == nil {
+			return CanaryMaxLifetime, "token never expires"
+		}
+		if life := tok.ExpiresAt.Sub(tok.CreatedAt); life > r.MaxLifetime {
+			return CanaryMaxLifetime, fmt.Sprintf("lifetime %s exceeds %s", life.Round(time.Second), r.MaxLifetime)
+		}
+	}
+	if r.MaxIdle > 0 {
+		last := tok.CreatedAt
+		if tok.LastUsedAt != nil {
+			last = *tok.LastUsedAt
+		}
+		if idle := use.Time.Sub(last); idle > r.MaxIdle {
+			return CanaryMaxIdle, fmt.Sprintf("idle for %s, over %s", idle.Round(time.Second), r.MaxIdle)
+		}
+	}
+	if r.RequireIPBinding && tok.Metadata[auth.MetaBoundIP] == "" {
+		return CanaryIPBinding, "token is not bound to an IP"
+	}
+	if r.Check != nil {
+		if err := r.Check(use); err != nil {
+			return CanaryCheck, err.Error()
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,213 @@
+package goauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AbilityInfo documents an ability API consumers can request
+type AbilityInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// TokenTemplate is a named preset for issuing tokens, e.g. "ci-deploy"
+type TokenTemplate struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Abilities   []string      `json:"abilities"`
+	ExpiresIn   time.Duration `json:"-"` // Token lifetime; 0 uses the client default
+}
+
+// catalog holds the abilities and templates registered with options
+type catalog struct {
+	abilities []AbilityInfo
+	templates []TokenTemplate
+}
+
+// WithAbility registers an ability and its description in the catalog
+// served by AbilityCatalogHandler
+func WithAbility(name, description string) Option {
+	return func(c *Client) error {
+		if name == "" {
+			return fmt.Errorf("ability name cannot be empty")
+		}
+		if slices.ContainsFunc(c.catalog.abilities, func(a AbilityInfo) bool { return a.Name == name }) {
+			return fmt.Errorf("duplicate ability %q", name)
+		}
+		c.catalog.abilities = append(c.catalog.abilities, AbilityInfo{Name: name, Description: description})
+		return nil
+	}
+}
+
+// WithTokenTemplate registers a token preset usable with
+// CreateTokenFromTemplate and listed by AbilityCatalogHandler
+func WithTokenTemplate(tmpl TokenTemplate) Option {
+	return func(c *Client) error {
+		if tmpl.Name == "" {
+			return fmt.Errorf("template name cannot be empty")
+		}
+		if tmpl.ExpiresIn < 0 && tmpl.ExpiresIn != NoExpiry {
+			return fmt.Errorf("template expiration cannot be negative")
+		}
+		if _, ok := c.template(tmpl.Name); ok {
+			return fmt.Errorf("duplicate token template %q", tmpl.Name)
+		}
+		tmpl.Abilities = slices.Clone(tmpl.Abilities)
+		c.catalog.templates = append(c.catalog.templates, tmpl)
+		return nil
+	}
+}
+
+func (c *Client) template(name string) (TokenTemplate, bool) {
+	for _, t := range c.catalog.templates {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return TokenTemplate{}, false
+}
+
+// CreateTokenFromTemplate issues a token for userID with the abilities and
+// lifetime of a registered template, named after it
+func (c *Client) CreateTokenFromTemplate(ctx context.Context, name string, userID int64) (string, error) {
+	tmpl, ok := c.template(name)
+	if !ok {
+		return "", fmt.Errorf("unknown token template %q", name)
+	}
+	return c.CreateToken(ctx, &TokenOptions{
+		UserId:    userID,
+		Name:      &tmpl.Name,
+		Abilities: tmpl.Abilities,
+		ExpiresIn: tmpl.ExpiresIn,
+	})
+}
+
+// AbilityDescription returns the registered description of an ability
+func (c *Client) AbilityDescription(name string) (string, bool) {
+	for _, a := range c.catalog.abilities {
+		if a.Name == name {
+			return a.Description, true
+		}
+	}
+	return "", false
+}
+
+// AbilityCatalog lists the registered abilities, the roles of the current
+// policy bundle, and the token templates
+type AbilityCatalog struct {
+	Abilities     []AbilityInfo  `json:"abilities"`
+	Roles         []RoleInfo     `json:"roles"`
+	Templates     []TemplateInfo `json:"templates"`
+	PolicyVersion int64          `json:"policy_version,omitempty"`
+}
+
+// RoleInfo lists the abilities a role grants
+type RoleInfo struct {
+	Name      string   `json:"name"`
+	Abilities []string `json:"abilities"`
+}
+
+// TemplateInfo is a TokenTemplate as published in the catalog
+type TemplateInfo struct {
+	TokenTemplate
+	ExpiresInSeconds int64 `json:"expires_in,omitempty"` // 0 means the client default
+	NeverExpires     bool  `json:"never_expires,omitempty"`
+}
+
+// AbilityCatalog returns a snapshot of the catalog
+func (c *Client) AbilityCatalog() AbilityCatalog {
+	cat := AbilityCatalog{
+		Abilities:     slices.Clone(c.catalog.abilities),
+		Roles:         []RoleInfo{},
+		Templates:     make([]TemplateInfo, 0, len(c.catalog.templates)),
+		PolicyVersion: c.config.Roles.Version(),
+	}
+	if cat.Abilities == nil {
+		cat.Abilities = []AbilityInfo{}
+	}
+	for name, abilities := range c.config.Roles.All() {
+		cat.Roles = append(cat.Roles, RoleInfo{Name: name, Abilities: abilities})
+	}
+	slices.SortFunc(cat.Roles, func(a, b RoleInfo) int { return strings.Compare(a.Name, b.Name) })
+	for _, t := range c.catalog.templates {
+		info := TemplateInfo{TokenTemplate: t}
+		switch {
+		case t.ExpiresIn == NoExpiry:
+			info.NeverExpires = true
+		case t.ExpiresIn > 0:
+			info.ExpiresInSeconds = int64(t.ExpiresIn / time.Second)
+		}
+		cat.Templates = append(cat.Templates, info)
+	}
+	return cat
+}
+
+// AbilityCatalogHandler serves the ability catalog so API consumers can
+// discover what to request: JSON for clients asking for application/json
+// (or ?format=json), an HTML page otherwise. Roles reflect the policy
+// bundle loaded at request time.
+func (c *Client) AbilityCatalogHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		cat := c.AbilityCatalog()
+		w.Header().Add("Vary", "Accept")
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(cat)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = catalogPage.Execute(w, cat)
+	})
+}
+
+var catalogPage = template.Must(template.New("catalog").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Ability catalog</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem auto; max-width: 60rem; padding: 0 1rem; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2rem; }
+th, td { border-bottom: 1px solid #ddd; padding: .4rem .6rem; text-align: left; vertical-align: top; }
+code { font-size: .95em; }
+</style>
+</head>
+<body>
+<h1>Ability catalog</h1>
+<p>Machine-readable version: <a href="?format=json">JSON</a></p>
+
+<h2>Abilities</h2>
+{{if .Abilities}}<table>
+<tr><th>Ability</th><th>Description</th></tr>
+{{range .Abilities}}<tr><td><code>{{.Name}}</code></td><td>{{.Description}}</td></tr>
+{{end}}</table>{{else}}<p>No abilities registered.</p>{{end}}
+
+<h2>Roles</h2>
+{{if .Roles}}<table>
+<tr><th>Role</th><th>Grants</th></tr>
+{{range .Roles}}<tr><td><code>role:{{.Name}}</code></td><td><code>{{join .Abilities ", "}}</code></td></tr>
+{{end}}</table>{{if .PolicyVersion}}<p>Policy version {{.PolicyVersion}}</p>{{end}}{{else}}<p>No roles defined.</p>{{end}}
+
+<h2>Token templates</h2>
+{{if .Templates}}<table>
+<tr><th>Template</th><th>Description</th><th>Abilities</th><th>Lifetime</th></tr>
+{{range .Templates}}<tr><td><code>{{.Name}}</code></td><td>{{.Description}}</td><td><code>{{join .Abilities ", "}}</code></td><td>{{if .NeverExpires}}never expires{{else if .ExpiresInSeconds}}{{.ExpiresInSeconds}}s{{else}}default{{end}}</td></tr>
+{{end}}</table>{{else}}<p>No templates defined.</p>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,86 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ChangeCursor is a position in the token change feed. The zero cursor
+// starts before every change.
+type ChangeCursor = storage.ChangeCursor
+
+// Changes is a page of the token change feed
+type Changes struct {
+	Tokens []*PersonalAccessToken // Changed tokens in their current state, oldest change first
+	Next   ChangeCursor           // Where the next call continues; the given cursor when nothing changed
+}
+
+// WithChangeSettle makes ChangesSince hold back changes younger than d.
+// Revisions come from each writer's clock, so with several instances
+// writing, a change can commit with a revision just below one already
+// read; a settle time longer than the clock skew plus the longest write
+// keeps tailers from skipping it.
+func WithChangeSettle(d time.Duration) Option {
+	return func(c *Client) error {
+		if d < 0 {
+			return fmt.Errorf("change settle time cannot be negative")
+		}
+		c.changeSettle = d
+		return nil
+	}
+}
+
+// ChangesSince returns up to limit tokens (0 = all) changed after cursor,
+// for replicas, caches and SIEMs tailing token state. Creations,
+// revocations, expiry changes and updates are changes; last-used touches
+// are not. Hard deletes (revocation without WithSoftRevocation, pruning)
+// leave the feed silently, so tailers should treat unseen tokens as gone
+// once they expire. Store Next and pass it to the following call.
+func (c *Client) ChangesSince(ctx context.Context, cursor ChangeCursor, limit int) (*Changes, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if limit < 0 {
+		return nil, fmt.Errorf("limit cannot be negative")
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	return traced(c, ctx, "ChangesSince", func(cfg *config.Config) (*Changes, error) {
+		feed, ok := cfg.Storage.(storage.ChangeFeed)
+		if !ok {
+			return nil, fmt.Errorf("change feed: %w", utils.ErrNotSupported)
+		}
+		tokens, err := feed.ChangesSince(cursor, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.changeSettle > 0 {
+			settled := c.config.Now().Add(-c.changeSettle).UnixNano()
+			for i, t := range tokens {
+				if t.Revision > settled {
+					tokens = tokens[:i]
+					break
+				}
+			}
+		}
+		page := &Changes{Tokens: tokens, Next: cursor}
+		if len(tokens) > 0 {
+			page.Next = storage.CursorOf(tokens[len(tokens)-1])
+		}
+		return page, nil
+	}, attribute.Int64("goauth.revision", cursor.Revision))
+}
@@ -0,0 +1,216 @@
+// Package clientsdk helps Go programs call APIs protected by goauth. Its
+// Transport wraps an http.RoundTripper to attach Bearer tokens, refresh
+// them when the server answers 401, and optionally sign each request.
+//
+//	src := clientsdk.NewRefreshingSource(tokens, func(ctx context.Context, refresh string) (*clientsdk.Tokens, error) {
+//		// call your API's refresh endpoint
+//	})
+//	httpClient := &http.Client{Transport: &clientsdk.Transport{Source: src}}
+package clientsdk
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrNoRefreshToken is returned when a refresh is needed but no refresh
+// token is available.
+var ErrNoRefreshToken = errors.New("clientsdk: no refresh token")
+
+// TokenSource supplies the access token attached to requests.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// Refresher is implemented by token sources that can obtain a new access
+// token after the server rejected stale, the token the request carried.
+// Implementations return the current token without refreshing when it
+// already differs from stale, so concurrent 401s trigger one refresh.
+type Refresher interface {
+	Refresh(ctx context.Context, stale string) (string, error)
+}
+
+// StaticToken is a TokenSource that always returns the same token.
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error) { return string(t), nil }
+
+// Tokens is an access/refresh token pair as issued by goauth's
+// CreateTokenPair and RefreshToken.
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time // Zero if unknown
+}
+
+// RefreshFunc exchanges a refresh token for a new token pair, typically by
+// calling the API's refresh endpoint.
+type RefreshFunc func(ctx context.Context, refreshToken string) (*Tokens, error)
+
+// RefreshingSource holds a token pair and refreshes it shortly before it
+// expires or when the server rejects the access token. Refreshes are
+// serialized: callers arriving while one is in flight wait for it and use
+// its result instead of spending the refresh token again, which goauth
+// would treat as refresh-token reuse.
+type RefreshingSource struct {
+	refresh RefreshFunc
+
+	// Skew refreshes tokens this long before ExpiresAt. Default 30s.
+	Skew time.Duration
+
+	// OnTokenRotated, if set, receives every newly issued pair so the
+	// application can persist it. It runs while the source is locked, in
+	// rotation order, and must not call back into the source.
+	OnTokenRotated func(Tokens)
+
+	mu     sync.Mutex
+	tokens Tokens
+}
+
+// NewRefreshingSource returns a source starting from tokens.
+func NewRefreshingSource(tokens Tokens, refresh RefreshFunc) *RefreshingSource {
+	return &RefreshingSource{refresh: refresh, tokens: tokens, Skew: 30 * time.Second}
+}
+
+// Token returns the current access token, refreshing it first when it is
+// about to expire.
+func (s *RefreshingSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exp := s.tokens.ExpiresAt
+	if !exp.IsZero() && time.Until(exp) < s.Skew && s.tokens.RefreshToken != "" {
+		if err := s.refreshLocked(ctx); err != nil {
+			return "", err
+		}
+	}
+	return s.tokens.AccessToken, nil
+}
+
+// Refresh exchanges the refresh token for a new pair unless the access
+// token has already moved on from stale. An empty stale always refreshes.
+func (s *RefreshingSource) Refresh(ctx context.Context, stale string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stale != "" && s.tokens.AccessToken != stale {
+		return s.tokens.AccessToken, nil
+	}
+	if err := s.refreshLocked(ctx); err != nil {
+		return "", err
+	}
+	return s.tokens.AccessToken, nil
+}
+
+// Tokens returns a copy of the current token pair.
+func (s *RefreshingSource) Tokens() Tokens {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.tokens
+}
+
+func (s *RefreshingSource) refreshLocked(ctx context.Context) error {
+	if s.tokens.RefreshToken == "" || s.refresh == nil {
+		return ErrNoRefreshToken
+	}
+	next, err := s.refresh(ctx, s.tokens.RefreshToken)
+	if err != nil {
+		return err
+	}
+	if next.RefreshToken == "" {
+		// Servers without rotation keep the old refresh token valid
+		next.RefreshToken = s.tokens.RefreshToken
+	}
+	s.tokens = *next
+	if s.OnTokenRotated != nil {
+		s.OnTokenRotated(s.tokens)
+	}
+	return nil
+}
+
+// Transport is an http.RoundTripper that authenticates requests with a
+// Bearer token from Source. On a 401 response it asks a Refresher source
+// for a new token and retries the request once, provided the request body
+// can be replayed (no body, or http.Request.GetBody set, as it is for
+// bytes, strings and bytes.Buffer bodies).
+type Transport struct {
+	Base   http.RoundTripper // Default http.DefaultTransport
+	Source TokenSource
+	Signer Signer // Optional request signing
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	token, err := t.Source.Token(ctx)
+	if err != nil {
+		closeBody(req)
+		return nil, err
+	}
+
+	resp, err := t.send(req, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	refresher, ok := t.Source.(Refresher)
+	if !ok || !replayable(req) {
+		return resp, nil
+	}
+	token, err = refresher.Refresh(ctx, token)
+	if err != nil {
+		// Hand the original 401 back; the caller can inspect it
+		return resp, nil
+	}
+
+	drain(resp)
+	return t.send(req, token)
+}
+
+// send clones req, authenticates and signs the clone and sends it.
+func (t *Transport) send(req *http.Request, token string) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		out.Body = body
+	}
+	out.Header.Set("Authorization", "Bearer "+token)
+
+	if t.Signer != nil {
+		if err := t.Signer.Sign(out, token); err != nil {
+			closeBody(out)
+			return nil, err
+		}
+	}
+	return t.base().RoundTrip(out)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	resp.Body.Close()
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
@@ -0,0 +1,106 @@
+package clientsdk
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrCredentialNotFound is returned when no credentials are stored
+	// under a key.
+	ErrCredentialNotFound = errors.New("clientsdk: credentials not found")
+	// ErrKeyringUnavailable is returned when the OS keychain cannot be
+	// used on this system.
+	ErrKeyringUnavailable = errors.New("clientsdk: OS keyring unavailable")
+)
+
+// CredentialStore persists token pairs between runs of a program.
+type CredentialStore interface {
+	Load(key string) (*Tokens, error)
+	Save(key string, tokens Tokens) error
+	Delete(key string) error
+}
+
+// Keyring is a secret store addressed by service and user, such as the OS
+// keychain. Get returns ErrCredentialNotFound for missing entries.
+type Keyring interface {
+	Get(service, user string) (string, error)
+	Set(service, user, secret string) error
+	Delete(service, user string) error
+}
+
+// KeyringStore stores token pairs as JSON secrets in a Keyring, one entry
+// per key under Service.
+type KeyringStore struct {
+	Keyring Keyring
+	Service string
+}
+
+// NewKeyringStore returns a store backed by the OS keychain.
+func NewKeyringStore(service string) *KeyringStore {
+	return &KeyringStore{Keyring: SystemKeyring(), Service: service}
+}
+
+func (s *KeyringStore) Load(key string) (*Tokens, error) {
+	secret, err := s.Keyring.Get(s.Service, key)
+	if err != nil {
+		return nil, err
+	}
+	var t Tokens
+	if err := json.Unmarshal([]byte(secret), &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *KeyringStore) Save(key string, tokens Tokens) error {
+	secret, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	return s.Keyring.Set(s.Service, key, string(secret))
+}
+
+func (s *KeyringStore) Delete(key string) error {
+	return s.Keyring.Delete(s.Service, key)
+}
+
+// MemoryStore is a CredentialStore kept in process memory, for tests and
+// short-lived programs.
+type MemoryStore struct {
+	mu     sync.Mutex
+	tokens map[string]Tokens
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tokens: make(map[string]Tokens)}
+}
+
+func (s *MemoryStore) Load(key string) (*Tokens, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[key]
+	if !ok {
+		return nil, ErrCredentialNotFound
+	}
+	return &t, nil
+}
+
+func (s *MemoryStore) Save(key string, tokens Tokens) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[key] = tokens
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, key)
+	return nil
+}
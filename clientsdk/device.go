@@ -0,0 +1,391 @@
+package clientsdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Device flow errors reported by the authorization server.
+var (
+	ErrAccessDenied  = errors.New("clientsdk: device login denied")
+	ErrDeviceExpired = errors.New("clientsdk: device code expired before login completed")
+)
+
+const deviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+// DeviceFlowOption configures LoginWithDeviceFlow.
+type DeviceFlowOption func(*deviceFlow)
+
+type deviceFlow struct {
+	clientID   string
+	scopes     []string
+	httpClient *http.Client
+	out        io.Writer
+	store      CredentialStore
+	service    string
+	deviceURL  string
+	tokenURL   string
+	revokeURL  string
+}
+
+// WithClientID sets the OAuth client ID sent to the authorization server.
+func WithClientID(id string) DeviceFlowOption {
+	return func(f *deviceFlow) { f.clientID = id }
+}
+
+// WithScopes requests the given scopes.
+func WithScopes(scopes ...string) DeviceFlowOption {
+	return func(f *deviceFlow) { f.scopes = scopes }
+}
+
+// WithHTTPClient sets the client used to talk to the authorization server.
+func WithHTTPClient(c *http.Client) DeviceFlowOption {
+	return func(f *deviceFlow) { f.httpClient = c }
+}
+
+// WithOutput sets where the login prompt is printed. Default os.Stderr.
+func WithOutput(w io.Writer) DeviceFlowOption {
+	return func(f *deviceFlow) { f.out = w }
+}
+
+// WithCredentialStore caches tokens in store instead of the OS keychain
+// (or its encrypted file fallback). Pass nil to disable caching.
+func WithCredentialStore(store CredentialStore) DeviceFlowOption {
+	return func(f *deviceFlow) { f.store = store }
+}
+
+// WithEndpoints skips discovery and uses the given device authorization
+// and token endpoints.
+func WithEndpoints(deviceAuthorizationURL, tokenURL string) DeviceFlowOption {
+	return func(f *deviceFlow) { f.deviceURL, f.tokenURL = deviceAuthorizationURL, tokenURL }
+}
+
+// LoginWithDeviceFlow signs a CLI user in with the OAuth 2.0 device
+// authorization grant (RFC 8628): it prints the verification URL and user
+// code, polls until the user approves, and caches the tokens with
+// NewCredentialCache. Cached tokens that have not expired are returned without a new
+// login, and expired ones are refreshed when a refresh token is cached.
+// Endpoints are discovered from the issuer's
+// /.well-known/oauth-authorization-server or openid-configuration
+// document unless set with WithEndpoints.
+func LoginWithDeviceFlow(ctx context.Context, issuerURL string, opts ...DeviceFlowOption) (*Tokens, error) {
+	issuerURL = strings.TrimSuffix(issuerURL, "/")
+	f := newDeviceFlow(opts)
+
+	key := f.cacheKey(issuerURL)
+	var cached *Tokens
+	if f.store != nil {
+		if t, err := f.store.Load(key); err == nil && t.AccessToken != "" {
+			cached = t
+		}
+	}
+	if cached != nil && (cached.ExpiresAt.IsZero() || time.Until(cached.ExpiresAt) > time.Minute) {
+		return cached, nil
+	}
+
+	if f.deviceURL == "" || f.tokenURL == "" {
+		if err := f.discover(ctx, issuerURL); err != nil {
+			return nil, err
+		}
+	}
+
+	if cached != nil && cached.RefreshToken != "" {
+		if tokens, err := f.refresh(ctx, cached.RefreshToken); err == nil {
+			return tokens, f.save(key, tokens)
+		}
+	}
+
+	code, err := f.requestCode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if code.VerificationURIComplete != "" {
+		fmt.Fprintf(f.out, "To sign in, open %s\nand confirm the code %s\n", code.VerificationURIComplete, code.UserCode)
+	} else {
+		fmt.Fprintf(f.out, "To sign in, open %s\nand enter the code %s\n", code.VerificationURI, code.UserCode)
+	}
+
+	tokens, err := f.poll(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, f.save(key, tokens)
+}
+
+// Logout removes the tokens LoginWithDeviceFlow cached for issuerURL and
+// revokes the cached refresh token (RFC 7009) when the issuer advertises a
+// revocation_endpoint. Revocation is best-effort: the local entry is
+// removed even when the server can't be reached. Pass the same
+// WithClientID and WithCredentialStore options used to log in.
+func Logout(ctx context.Context, issuerURL string, opts ...DeviceFlowOption) error {
+	issuerURL = strings.TrimSuffix(issuerURL, "/")
+	f := newDeviceFlow(opts)
+	if f.store == nil {
+		return nil
+	}
+
+	key := f.cacheKey(issuerURL)
+	cached, err := f.store.Load(key)
+	if errors.Is(err, ErrCredentialNotFound) {
+		return nil
+	}
+	if err == nil && cached.RefreshToken != "" {
+		if f.revokeURL == "" {
+			_ = f.discover(ctx, issuerURL)
+		}
+		f.revoke(ctx, cached.RefreshToken)
+	}
+
+	err = f.store.Delete(key)
+	if err != nil && !errors.Is(err, ErrCredentialNotFound) && !errors.Is(err, ErrKeyringUnavailable) {
+		return fmt.Errorf("clientsdk: remove cached tokens: %w", err)
+	}
+	return nil
+}
+
+func newDeviceFlow(opts []DeviceFlowOption) *deviceFlow {
+	f := &deviceFlow{
+		httpClient: http.DefaultClient,
+		out:        os.Stderr,
+		service:    "goauth",
+	}
+	f.store = NewCredentialCache(f.service)
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+func (f *deviceFlow) cacheKey(issuerURL string) string {
+	return issuerURL + "#" + f.clientID
+}
+
+// revoke asks the server to revoke refreshToken, ignoring failures.
+func (f *deviceFlow) revoke(ctx context.Context, refreshToken string) {
+	if f.revokeURL == "" {
+		return
+	}
+	form := url.Values{
+		"token":           {refreshToken},
+		"token_type_hint": {"refresh_token"},
+	}
+	if f.clientID != "" {
+		form.Set("client_id", f.clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.revokeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if resp, err := f.httpClient.Do(req); err == nil {
+		drain(resp)
+	}
+}
+
+// save caches tokens. Systems without a keychain simply skip caching.
+func (f *deviceFlow) save(key string, tokens *Tokens) error {
+	if f.store == nil {
+		return nil
+	}
+	if err := f.store.Save(key, *tokens); err != nil && !errors.Is(err, ErrKeyringUnavailable) {
+		return fmt.Errorf("clientsdk: cache tokens: %w", err)
+	}
+	return nil
+}
+
+// refresh exchanges a cached refresh token at the token endpoint.
+func (f *deviceFlow) refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}
+	if f.clientID != "" {
+		form.Set("client_id", f.clientID)
+	}
+
+	var resp tokenResponse
+	if _, err := f.postForm(ctx, f.tokenURL, form, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" || resp.AccessToken == "" {
+		return nil, fmt.Errorf("clientsdk: refresh failed: %s", resp.Error)
+	}
+	tokens := resp.tokens()
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = refreshToken
+	}
+	return tokens, nil
+}
+
+func (f *deviceFlow) discover(ctx context.Context, issuer string) error {
+	var lastErr error
+	for _, path := range []string{"/.well-known/oauth-authorization-server", "/.well-known/openid-configuration"} {
+		var meta struct {
+			DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+			TokenEndpoint               string `json:"token_endpoint"`
+			RevocationEndpoint          string `json:"revocation_endpoint"`
+		}
+		if err := f.getJSON(ctx, issuer+path, &meta); err != nil {
+			lastErr = err
+			continue
+		}
+		if f.revokeURL == "" {
+			f.revokeURL = meta.RevocationEndpoint
+		}
+		if meta.DeviceAuthorizationEndpoint == "" || meta.TokenEndpoint == "" {
+			lastErr = errors.New("issuer metadata lacks device authorization or token endpoint")
+			continue
+		}
+		if f.deviceURL == "" {
+			f.deviceURL = meta.DeviceAuthorizationEndpoint
+		}
+		if f.tokenURL == "" {
+			f.tokenURL = meta.TokenEndpoint
+		}
+		return nil
+	}
+	return fmt.Errorf("clientsdk: discover %s: %w", issuer, lastErr)
+}
+
+type deviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+func (f *deviceFlow) requestCode(ctx context.Context) (*deviceCode, error) {
+	form := url.Values{}
+	if f.clientID != "" {
+		form.Set("client_id", f.clientID)
+	}
+	if len(f.scopes) > 0 {
+		form.Set("scope", strings.Join(f.scopes, " "))
+	}
+
+	var code deviceCode
+	status, err := f.postForm(ctx, f.deviceURL, form, &code)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || code.DeviceCode == "" {
+		return nil, fmt.Errorf("clientsdk: device authorization failed (HTTP %d)", status)
+	}
+	return &code, nil
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+}
+
+func (r *tokenResponse) tokens() *Tokens {
+	tokens := &Tokens{AccessToken: r.AccessToken, RefreshToken: r.RefreshToken}
+	if r.ExpiresIn > 0 {
+		tokens.ExpiresAt = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+	return tokens
+}
+
+func (f *deviceFlow) poll(ctx context.Context, code *deviceCode) (*Tokens, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	var deadline <-chan time.Time
+	if code.ExpiresIn > 0 {
+		timer := time.NewTimer(time.Duration(code.ExpiresIn) * time.Second)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	form := url.Values{
+		"grant_type":  {deviceCodeGrant},
+		"device_code": {code.DeviceCode},
+	}
+	if f.clientID != "" {
+		form.Set("client_id", f.clientID)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline:
+			return nil, ErrDeviceExpired
+		case <-time.After(interval):
+		}
+
+		var resp tokenResponse
+		if _, err := f.postForm(ctx, f.tokenURL, form, &resp); err != nil {
+			return nil, err
+		}
+
+		switch resp.Error {
+		case "":
+			if resp.AccessToken == "" {
+				return nil, errors.New("clientsdk: token response without access_token")
+			}
+			return resp.tokens(), nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return nil, ErrAccessDenied
+		case "expired_token":
+			return nil, ErrDeviceExpired
+		default:
+			return nil, fmt.Errorf("clientsdk: device login failed: %s", resp.Error)
+		}
+	}
+}
+
+func (f *deviceFlow) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// postForm posts form and decodes the JSON body into v whatever the status,
+// since OAuth errors arrive as JSON with 400 responses.
+func (f *deviceFlow) postForm(ctx context.Context, u string, form url.Values, v any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v); err != nil {
+		return resp.StatusCode, fmt.Errorf("clientsdk: decode %s response (HTTP %d): %w", u, resp.StatusCode, err)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,229 @@
+package clientsdk
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore keeps credentials in one AES-256-GCM encrypted file, for
+// systems without a usable OS keychain.
+type FileStore struct {
+	path string
+	aead cipher.AEAD
+	mu   sync.Mutex
+}
+
+// NewFileStore returns a store encrypting path with a 32-byte key.
+func NewFileStore(path string, key []byte) (*FileStore, error) {
+	if len(key) != 32 {
+		return nil, errors.New("clientsdk: file store key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{path: path, aead: aead}, nil
+}
+
+// DefaultFileStore returns a FileStore under the user's config directory
+// (e.g. ~/.config/<service>/credentials.enc). Its key is generated on first
+// use and kept beside it in a file only the user can read, so the
+// credentials file alone (in a backup or a support bundle) reveals nothing;
+// prefer the OS keychain where one exists.
+func DefaultFileStore(service string) (*FileStore, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, service)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	keyPath := filepath.Join(dir, "credentials.key")
+	key, err := os.ReadFile(keyPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		err = writeFileAtomic(keyPath, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("clientsdk: credentials key: %w", err)
+	}
+	return NewFileStore(filepath.Join(dir, "credentials.enc"), key)
+}
+
+func (s *FileStore) Load(key string) (*Tokens, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := all[key]
+	if !ok {
+		return nil, ErrCredentialNotFound
+	}
+	return &t, nil
+}
+
+func (s *FileStore) Save(key string, tokens Tokens) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	all[key] = tokens
+	return s.write(all)
+}
+
+func (s *FileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := all[key]; !ok {
+		return nil
+	}
+	delete(all, key)
+	if len(all) == 0 {
+		return os.Remove(s.path)
+	}
+	return s.write(all)
+}
+
+func (s *FileStore) read() (map[string]Tokens, error) {
+	all := make(map[string]Tokens)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n := s.aead.NonceSize()
+	if len(data) < n {
+		return nil, errors.New("clientsdk: credentials file corrupt")
+	}
+	plain, err := s.aead.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return nil, errors.New("clientsdk: credentials file cannot be decrypted")
+	}
+	if err := json.Unmarshal(plain, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (s *FileStore) write(all map[string]Tokens) error {
+	plain, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, s.aead.Seal(nonce, nonce, plain, nil))
+}
+
+// writeFileAtomic writes data to a user-only temp file and renames it over
+// path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// fallbackStore uses the OS keychain and falls back to an encrypted file
+// when the keychain is unavailable.
+type fallbackStore struct {
+	primary CredentialStore
+	file    func() (CredentialStore, error)
+}
+
+// NewCredentialCache returns the credential store used by the client SDK:
+// the OS keychain (macOS Keychain, Windows Credential Manager, Secret
+// Service via libsecret), or DefaultFileStore when no keychain is
+// available.
+func NewCredentialCache(service string) CredentialStore {
+	return &fallbackStore{
+		primary: NewKeyringStore(service),
+		file: sync.OnceValues(func() (CredentialStore, error) {
+			return DefaultFileStore(service)
+		}),
+	}
+}
+
+func (s *fallbackStore) Load(key string) (*Tokens, error) {
+	t, err := s.primary.Load(key)
+	if err == nil || !errors.Is(err, ErrKeyringUnavailable) && !errors.Is(err, ErrCredentialNotFound) {
+		return t, err
+	}
+	// Entries may have been saved to the file while the keychain was away
+	file, ferr := s.file()
+	if ferr != nil {
+		return nil, err
+	}
+	return file.Load(key)
+}
+
+func (s *fallbackStore) Save(key string, tokens Tokens) error {
+	err := s.primary.Save(key, tokens)
+	if !errors.Is(err, ErrKeyringUnavailable) {
+		return err
+	}
+	file, ferr := s.file()
+	if ferr != nil {
+		return errors.Join(err, ferr)
+	}
+	return file.Save(key, tokens)
+}
+
+// Delete removes the entry from both the keychain and the file.
+func (s *fallbackStore) Delete(key string) error {
+	err := s.primary.Delete(key)
+	if errors.Is(err, ErrKeyringUnavailable) {
+		err = nil
+	}
+	if file, ferr := s.file(); ferr == nil {
+		err = errors.Join(err, file.Delete(key))
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+//go:build darwin
+
+package clientsdk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// SystemKeyring returns the macOS login keychain, driven through the
+// security tool. Secrets are passed on stdin, never on the command line.
+func SystemKeyring() Keyring { return macKeychain{} }
+
+type macKeychain struct{}
+
+func (macKeychain) Get(service, user string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", user, "-w").Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && exit.ExitCode() == 44 {
+			return "", ErrCredentialNotFound
+		}
+		return "", fmt.Errorf("%w: %v", ErrKeyringUnavailable, err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (macKeychain) Set(service, user, secret string) error {
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		quote(service), quote(user), quote(secret)))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil || stderr.Len() > 0 {
+		return fmt.Errorf("%w: %v %s", ErrKeyringUnavailable, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (macKeychain) Delete(service, user string) error {
+	err := exec.Command("security", "delete-generic-password", "-s", service, "-a", user).Run()
+	var exit *exec.ExitError
+	if err == nil || errors.As(err, &exit) && exit.ExitCode() == 44 {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrKeyringUnavailable, err)
+}
+
+// quote escapes s for the security tool's interactive command parser.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+package clientsdk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// SystemKeyring returns the freedesktop Secret Service (GNOME Keyring,
+// KWallet) driven through secret-tool from libsecret. Secrets are passed on
+// stdin, never on the command line.
+func SystemKeyring() Keyring { return secretService{} }
+
+type secretService struct{}
+
+// run executes secret-tool. Failures that print a diagnostic (no D-Bus
+// session, locked or missing collection) are reported as
+// ErrKeyringUnavailable; a silent non-zero exit means nothing matched.
+func (secretService) run(stdin string, args ...string) (string, bool, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return "", false, fmt.Errorf("%w: secret-tool not installed", ErrKeyringUnavailable)
+	}
+	cmd := exec.Command("secret-tool", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	err := cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return stdout.String(), true, nil
+	case errors.As(err, &exit) && stderr.Len() == 0:
+		return "", false, nil
+	default:
+		return "", false, fmt.Errorf("%w: %v: %s", ErrKeyringUnavailable, err, strings.TrimSpace(stderr.String()))
+	}
+}
+
+func (k secretService) Get(service, user string) (string, error) {
+	out, ok, err := k.run("", "lookup", "service", service, "username", user)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrCredentialNotFound
+	}
+	return out, nil
+}
+
+func (k secretService) Set(service, user, secret string) error {
+	_, ok, err := k.run(secret, "store", "--label", service+" ("+user+")", "service", service, "username", user)
+	if err == nil && !ok {
+		err = fmt.Errorf("%w: secret-tool store failed", ErrKeyringUnavailable)
+	}
+	return err
+}
+
+func (k secretService) Delete(service, user string) error {
+	_, _, err := k.run("", "clear", "service", service, "username", user)
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !linux && !windows
+
+package clientsdk
+
+// SystemKeyring returns a keyring that reports ErrKeyringUnavailable on
+// platforms without a supported OS keychain.
+func SystemKeyring() Keyring { return noKeyring{} }
+
+type noKeyring struct{}
+
+func (noKeyring) Get(string, string) (string, error) { return "", ErrKeyringUnavailable }
+func (noKeyring) Set(string, string, string) error   { return ErrKeyringUnavailable }
+func (noKeyring) Delete(string, string) error        { return ErrKeyringUnavailable }
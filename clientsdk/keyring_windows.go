@@ -0,0 +1,127 @@
+//go:build windows
+
+package clientsdk
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// SystemKeyring returns the Windows Credential Manager. Entries are generic
+// credentials named "service:user" and persisted for the local machine.
+func SystemKeyring() Keyring { return winCredentials{} }
+
+type winCredentials struct{}
+
+var (
+	advapi32      = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead  = advapi32.NewProc("CredReadW")
+	procCredWrite = advapi32.NewProc("CredWriteW")
+	procCredDel   = advapi32.NewProc("CredDeleteW")
+	procCredFree  = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	credMaxBlobSize         = 5 * 512
+	errorNotFound           = syscall.Errno(1168)
+)
+
+// credential mirrors the Win32 CREDENTIALW structure.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func target(service, user string) (*uint16, error) {
+	return syscall.UTF16PtrFromString(service + ":" + user)
+}
+
+func (winCredentials) Get(service, user string) (string, error) {
+	name, err := target(service, user)
+	if err != nil {
+		return "", err
+	}
+	if err := procCredRead.Find(); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrKeyringUnavailable, err)
+	}
+
+	var cred *credential
+	r, _, callErr := procCredRead.Call(uintptr(unsafe.Pointer(name)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if errors.Is(callErr, errorNotFound) {
+			return "", ErrCredentialNotFound
+		}
+		return "", callErr
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func (winCredentials) Set(service, user, secret string) error {
+	if len(secret) > credMaxBlobSize {
+		return fmt.Errorf("%w: secret exceeds %d bytes", ErrKeyringUnavailable, credMaxBlobSize)
+	}
+	name, err := target(service, user)
+	if err != nil {
+		return err
+	}
+	userName, err := syscall.UTF16PtrFromString(user)
+	if err != nil {
+		return err
+	}
+	if err := procCredWrite.Find(); err != nil {
+		return fmt.Errorf("%w: %v", ErrKeyringUnavailable, err)
+	}
+
+	blob := []byte(secret)
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         name,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+		UserName:           userName,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+
+	r, _, callErr := procCredWrite.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if r == 0 {
+		return callErr
+	}
+	return nil
+}
+
+func (winCredentials) Delete(service, user string) error {
+	name, err := target(service, user)
+	if err != nil {
+		return err
+	}
+	if err := procCredDel.Find(); err != nil {
+		return fmt.Errorf("%w: %v", ErrKeyringUnavailable, err)
+	}
+
+	r, _, callErr := procCredDel.Call(uintptr(unsafe.Pointer(name)), credTypeGeneric, 0)
+	if r == 0 && !errors.Is(callErr, errorNotFound) {
+		return callErr
+	}
+	return nil
+}
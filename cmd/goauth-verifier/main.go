@@ -0,0 +1,67 @@
+// Command goauth-verifier runs a standalone verification server (see the
+// verifier package). It reads its replication secret from
+// GOAUTH_VERIFIER_SECRET. -locator must match the primary's locator,
+// "hash:N" for HashPrefixLocator(N) or "ulid":
+//
+//	goauth-verifier -addr :8089 -locator hash:12 -seed /var/lib/goauth/tokens.gats
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mohar9h/goauth"
+	"github.com/mohar9h/goauth/verifier"
+)
+
+func main() {
+	addr := flag.String("addr", ":8089", "listen address")
+	seed := flag.String("seed", "", "token set file exported by the primary, loaded at startup")
+	loc := flag.String("locator", "hash:12", `token locator of the primary: "hash:N" or "ulid"`)
+	prune := flag.Duration("prune", 10*time.Minute, "interval for dropping expired tokens (0 = never)")
+	flag.Parse()
+
+	locator, err := goauth.ParseLocator(*loc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := []goauth.Option{goauth.WithLocator(locator)}
+	if *prune > 0 {
+		opts = append(opts, goauth.WithAutoPrune(*prune))
+	}
+	srv, err := verifier.New(os.Getenv("GOAUTH_VERIFIER_SECRET"), opts...)
+	if err != nil {
+		log.Fatal("Failed to create verifier:", err)
+	}
+	defer srv.Close()
+
+	if *seed != "" {
+		n, err := srv.SeedFile(*seed)
+		if err != nil {
+			log.Fatal("Failed to seed tokens:", err)
+		}
+		log.Printf("Seeded %d tokens from %s", n, *seed)
+	}
+
+	hs := &http.Server{Addr: *addr, Handler: srv.Handler(), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		hs.Shutdown(shutdown)
+	}()
+
+	log.Printf("Verifier listening on %s", *addr)
+	if err := hs.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,192 @@
+package goauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// Maintainer is implemented by storage drivers with housekeeping best run
+// off-peak (see WithStorageMaintenance). Implement it on a custom driver
+// for e.g. partition drops or SCAN-based cleanup.
+type Maintainer = storage.Maintainer
+
+// Locker keeps maintenance from running on more than one instance at once
+type Locker interface {
+	// TryLock acquires name for at most ttl. It returns ok=false without
+	// waiting when another holder has it.
+	TryLock(ctx context.Context, name string, ttl time.Duration) (unlock func(), ok bool, err error)
+}
+
+// MaintainOptions tunes scheduled storage maintenance. Zero values select
+// the defaults.
+type MaintainOptions struct {
+	Jitter  time.Duration // Random delay added to each run so instances spread out. Default interval/10
+	Timeout time.Duration // Cancels a run taking longer. Default 10m
+	Lock    Locker        // Fleet-wide lock, e.g. NewRedisLocker. Default this instance only
+}
+
+// errMaintenanceLocked is returned by runMaintenance when another run holds
+// the lock; scheduled runs skip quietly
+var errMaintenanceLocked = errors.New("storage maintenance already running")
+
+// maintainOptions holds the schedule configured by WithStorageMaintenance
+type maintainOptions struct {
+	interval time.Duration
+	opts     MaintainOptions
+}
+
+// WithStorageMaintenance runs storage housekeeping every interval plus a
+// random jitter: VACUUM on SQLite and Postgres, OPTIMIZE TABLE on MySQL,
+// archive compaction, and Maintain on custom drivers implementing
+// Maintainer. Runs never overlap within the client; set opts.Lock to keep
+// a fleet of instances from running together. Failures are reported on
+// Errors.
+func WithStorageMaintenance(interval time.Duration, opts MaintainOptions) Option {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return fmt.Errorf("maintenance interval must be positive")
+		}
+		if opts.Jitter < 0 || opts.Timeout < 0 {
+			return fmt.Errorf("maintenance jitter and timeout cannot be negative")
+		}
+		if opts.Jitter == 0 {
+			opts.Jitter = interval / 10
+		}
+		if opts.Timeout == 0 {
+			opts.Timeout = 10 * time.Minute
+		}
+		c.maintain = &maintainOptions{interval: interval, opts: opts}
+		return nil
+	}
+}
+
+// Maintain runs storage housekeeping now, e.g. from an external scheduler.
+// It fails without waiting if a run is already in progress.
+func (c *Client) Maintain(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	return c.runMaintenance(ctx)
+}
+
+func (c *Client) runMaintenance(ctx context.Context) error {
+	if !c.maintainMu.TryLock() {
+		return errMaintenanceLocked
+	}
+	defer c.maintainMu.Unlock()
+
+	timeout := 10 * time.Minute
+	var lock Locker
+	if c.maintain != nil {
+		timeout, lock = c.maintain.opts.Timeout, c.maintain.opts.Lock
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if lock != nil {
+		unlock, ok, err := lock.TryLock(ctx, "goauth:maintenance", timeout)
+		if err != nil {
+			return fmt.Errorf("acquire maintenance lock: %w", err)
+		}
+		if !ok {
+			return errMaintenanceLocked
+		}
+		defer unlock()
+	}
+
+	start := time.Now()
+	errs := []error{storage.Maintain(ctx, c.storage)}
+	for _, d := range c.config.RegionStorages() {
+		errs = append(errs, storage.Maintain(ctx, d))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("storage maintenance: %w", err)
+	}
+	c.config.Logger.Info("storage maintenance finished", "duration", time.Since(start))
+	return nil
+}
+
+func (c *Client) startMaintenance() {
+	if c.maintain == nil {
+		return
+	}
+
+	workers := c.config.Workers
+	workers.Go("storage-maintenance", func() error {
+		for {
+			delay := c.maintain.interval
+			if j, err := rand.Int(rand.Reader, big.NewInt(int64(c.maintain.opts.Jitter)+1)); err == nil {
+				delay += time.Duration(j.Int64())
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case <-workers.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+
+			workers.Run("storage-maintenance", func() error {
+				err := c.runMaintenance(context.Background())
+				if errors.Is(err, errMaintenanceLocked) {
+					return nil
+				}
+				return err
+			})
+		}
+	})
+}
+
+// redisLocker implements Locker with SET NX and a compare-and-delete unlock
+type redisLocker struct {
+	rdb redis.UniversalClient
+}
+
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// NewRedisLocker returns a Locker shared by every instance using rdb. A
+// holder that dies keeps the lock until its ttl expires.
+func NewRedisLocker(rdb redis.UniversalClient) Locker {
+	return &redisLocker{rdb: rdb}
+}
+
+func (l *redisLocker) TryLock(ctx context.Context, name string, ttl time.Duration) (func(), bool, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, false, err
+	}
+	owner := hex.EncodeToString(buf)
+
+	ok, err := l.rdb.SetNX(ctx, name, owner, ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			_ = unlockScript.Run(context.Background(), l.rdb, []string{name}, owner).Err()
+		})
+	}, true, nil
+}
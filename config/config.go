@@ -2,75 +2,329 @@
 package config
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
 	"errors"
+	"io"
+	"strings"
 	"time"
 
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/locator"
 	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/internal/worker"
+	"github.com/mohar9h/goauth/phc"
+	"github.com/mohar9h/goauth/tokenformat"
 )
 
-// Config holds the global settings for the auth package.
+// AbilityMatcher decides whether a granted ability covers a requested one.
+type AbilityMatcher = entity.AbilityMatcher
+
+// EntityExtender stores application fields in extra token columns.
+type EntityExtender = storage.EntityExtender
+
+// AbilityPolicy decides what a legacy token with NULL or empty stored
+// abilities may do. Tokens issued without abilities store
+// entity.NoAbilities instead and are never affected.
+type AbilityPolicy int
+
+const (
+	AbilityPolicyDenyAll    AbilityPolicy = iota // Empty abilities grant nothing (default)
+	AbilityPolicyAllowAll                        // Empty abilities grant "*"
+	AbilityPolicyDefaultSet                      // Empty abilities grant DefaultAbilities
+)
+
+// ClientBindingMode decides what happens when a token bound to an IP or
+// user agent is presented by a different client.
+type ClientBindingMode int
+
+const (
+	ClientBindingStrict  ClientBindingMode = iota // Reject the token (default)
+	ClientBindingLogOnly                          // Log the mismatch and accept the token
+)
+
+// TokenLimitPolicy decides what happens when a user holding
+// MaxTokensPerUser live tokens creates another one.
+type TokenLimitPolicy int
+
+const (
+	TokenLimitReject   TokenLimitPolicy = iota // Fail with ErrTokenLimitReached (default)
+	TokenLimitEvictLRU                         // Revoke the least recently used token
+)
+
+// LastUsedMode controls how validation records a token's LastUsedAt.
+type LastUsedMode int
+
+const (
+	LastUsedAsync   LastUsedMode = iota // Write in the background after each validation (default)
+	LastUsedBatched                     // Coalesce writes per token, flushed every LastUsedFlush
+	LastUsedSync                        // Write before validation returns
+	LastUsedOff                         // Don't track usage
+)
+
+// ChecksumAlgorithm selects the checksum appended to generated secrets so
+// scanners can tell real tokens from lookalikes without a lookup.
+type ChecksumAlgorithm = tokenformat.Algorithm
+
+const (
+	ChecksumCRC32C = tokenformat.CRC32C
+	ChecksumXXHash = tokenformat.XXHash
+	ChecksumHMAC   = tokenformat.HMAC
+)
+
+// Clock tells the time. Tests substitute one to control token expiry.
+type Clock interface {
+	Now() time.Time
+}
+
+// IndexCheck controls the startup verification of storage indexes.
+type IndexCheck int
+
+const (
+	IndexCheckOff    IndexCheck = iota // Don't inspect the schema (default)
+	IndexCheckWarn                     // Log a warning when an index is missing
+	IndexCheckStrict                   // Fail NewClient when an index is missing
+)
+
+// Config holds the global settings for the auth package. It is the one
+// configuration type: goauth.NewClient starts from DefaultConfig, then
+// applies its options in order, the last one winning; NewClientFromFile
+// and NewClientFromEnv turn file and environment settings into options
+// that come before the caller's. Fields left zero are filled in by
+// ApplyDefaults. There is no default storage or signing key.
 type Config struct {
-	TokenLength      int             // Length of random tokens (e.g., 32)
-	TokenPrefix      string          // Prefix for random tokens (e.g., "pk_")
-	ExpireAt         time.Duration   // Token TTL (0 = unlimited)
-	SigningKey       string          // For HMAC JWT (HS256)
-	SigningMethod    string          // "HS256", "RS256"
-	PrivateKey       *rsa.PrivateKey // For RSA signing (optional)
-	PublicKey        *rsa.PublicKey  // For RSA verification (optional)
-	Storage          storage.Driver  // Optional: for random tokens
-	AbilityDelimiter string          // e.g., ":" for "read:posts"
+	TokenLength      int               // Random bytes per token, hex encoded (e.g., 32)
+	TokenPrefix      string            // Prefix for random tokens (e.g., "pk_")
+	Checksum         ChecksumAlgorithm // Checksum appended to generated secrets
+	ChecksumLength   int               // Hex characters of the checksum kept (0 = all)
+	ChecksumKey      []byte            // Key for ChecksumHMAC
+	Hasher           phc.Hasher        // Hashes secrets for storage (nil = compact SHA-256)
+	NoLegacyHashes   bool              // Only rows hashed by Hasher validate, ending the rehash migration
+	ExpireAt         time.Duration     // Token TTL (0 = unlimited)
+	RefreshExpireAt  time.Duration     // Refresh token TTL (0 = unlimited)
+	SlidingIdle      time.Duration     // Idle window renewed on each validation (0 = disabled)
+	MaxLifetime      time.Duration     // Absolute cap on sliding renewals, measured from creation
+	RotationGrace    time.Duration     // How long a rotated token stays valid (0 = revoke immediately)
+	SoftRevocation   bool              // Revocation sets RevokedAt instead of deleting the token
+	SigningKey       string            // For HMAC JWT (HS256)
+	SigningMethod    string            // "HS256", "RS256", "ES256", "EdDSA"
+	PrivateKey       *rsa.PrivateKey   // For RSA signing (optional)
+	PublicKey        *rsa.PublicKey    // For RSA verification (optional)
+	Signer           crypto.Signer     // P-256 ECDSA key for ES256, Ed25519 key for EdDSA
+	Storage          storage.Driver    // Optional: for random tokens
+	AbilityDelimiter string            // e.g., ":" for "read:posts"
+	AbilityMatcher   AbilityMatcher    // Decides whether a granted ability covers a requested one (nil = glob)
+	Locator          locator.Locator   // Public segment before "|" (default: numeric ID)
+	AbilityPolicy    AbilityPolicy     // Applied to legacy tokens with NULL/empty abilities
+	DefaultAbilities []string          // Granted under AbilityPolicyDefaultSet
+	AutoPrune        time.Duration     // Interval for deleting expired tokens (0 = disabled)
+	IndexCheck       IndexCheck        // Verify storage indexes at startup
+	Extender         EntityExtender    // Stores PersonalAccessToken.Extra in extra columns
+	ImportMinLength  int               // Minimum length of imported secrets (0 = 16)
+	ImportMinEntropy float64           // Minimum estimated entropy of imported secrets in bits (0 = 64)
+	MinAPIVersion    string            // Reject tokens bound to API versions below this
+	ClientBinding    ClientBindingMode // Enforcement of IP/user-agent bound tokens
+	GuestAbilities   []string          // Default abilities for guest tokens
+	DeviceTokenTTL   time.Duration     // Lifetime of device tokens (0 = 90 days)
+	OrgQuotas        QuotaFunc         // Org quotas enforced at issuance (optional)
+	QuotaHook        QuotaHook         // Called when an issuance nears an org quota
+	QuotaWarnRatio   float64           // Share of a quota that triggers QuotaHook (0 = 0.8)
+	SanctumCompat    bool              // Accept Laravel Sanctum token/record formats during migration
+	Maintenance      *Maintenance      // Runtime maintenance-mode switch
+	Roles            *Roles            // Role definitions referenced as "role:<name>" (optional)
+	Revocations      *Revocations      // Recent revocations checked after cached lookups (optional)
+	MaxTokensPerUser int               // Live access tokens allowed per user (0 = unlimited)
+	TokenLimitPolicy TokenLimitPolicy  // Applied when MaxTokensPerUser is reached
+	LastUsed         LastUsedMode      // How LastUsedAt is recorded
+	LastUsedFlush    time.Duration     // Flush interval under LastUsedBatched
+	NearExpiry       time.Duration     // Remaining lifetime that makes a token near expiry (0 = a fifth of its lifetime)
+	Residency        *Residency        // Pins users' tokens to regional storage (optional)
+	Region           string            // Region this config is routed to; set by Residency
+	Logger           utils.Logger      // Structured logger (default: discard)
+	Clock            Clock             // Source of the current time (nil = time.Now)
+	Rand             io.Reader         // Entropy for secrets and family IDs (nil = crypto/rand)
+	Workers          *worker.Supervisor
+	Touches          *storage.TouchBatcher
 }
 
 // Validate checks if the config is minimally valid.
 func (c *Config) Validate() error {
-	if c.SigningMethod != "HS256" && c.SigningMethod != "RS256" {
+	switch c.SigningMethod {
+	case "HS256":
+		if c.SigningKey == "" {
+			return errors.New("missing HMAC signing key")
+		}
+	case "RS256":
+		if c.PrivateKey == nil || c.PublicKey == nil {
+			return errors.New("missing RSA key pair")
+		}
+	case "ES256":
+		if c.Signer == nil {
+			return errors.New("missing ECDSA signing key")
+		}
+		if k, ok := c.Signer.Public().(*ecdsa.PublicKey); !ok || k.Curve != elliptic.P256() {
+			return errors.New("ES256 needs a P-256 ECDSA signing key")
+		}
+	case "EdDSA":
+		if c.Signer == nil {
+			return errors.New("missing Ed25519 signing key")
+		}
+		if k, ok := c.Signer.Public().(ed25519.PublicKey); !ok || len(k) != ed25519.PublicKeySize {
+			return errors.New("EdDSA needs an Ed25519 signing key")
+		}
+	default:
 		return errors.New("unsupported signing method")
 	}
-	if c.SigningMethod == "HS256" && c.SigningKey == "" {
-		return errors.New("missing HMAC signing key")
-	}
-	if c.SigningMethod == "RS256" && (c.PrivateKey == nil || c.PublicKey == nil) {
-		return errors.New("missing RSA key pair")
-	}
 	if c.TokenLength < 16 {
 		return errors.New("auth length too short")
 	}
+	if c.Checksum == ChecksumHMAC && len(c.ChecksumKey) == 0 {
+		return errors.New("HMAC checksum requires a key")
+	}
+	if c.Hasher != nil && c.Hasher.Salted() {
+		if _, ok := c.Locator.(locator.ID); !ok && c.Locator != nil {
+			return errors.New("salted token hashers need the default ID locator")
+		}
+	}
+	if c.ChecksumLength != 0 && (c.ChecksumLength < 4 || c.ChecksumLength > c.Checksum.Size()) {
+		return errors.New("checksum length must be between 4 and the algorithm's size")
+	}
+	if c.SlidingIdle > 0 && c.MaxLifetime > 0 && c.MaxLifetime < c.SlidingIdle {
+		return errors.New("max token lifetime shorter than sliding idle window")
+	}
+	if c.AbilityPolicy == AbilityPolicyDefaultSet && len(c.DefaultAbilities) == 0 {
+		return errors.New("default ability policy requires at least one ability")
+	}
 	return nil
 }
 
+const (
+	defaultTokenLength      = 32
+	defaultSigningMethod    = "HS256"
+	defaultAbilityDelimiter = ":"
+)
+
 // DefaultConfig returns a default config.
 func DefaultConfig() *Config {
 	return &Config{
-		TokenLength:      32, // Increased for better security
+		TokenLength:      defaultTokenLength, // Increased for better security
 		TokenPrefix:      "",
 		ExpireAt:         24 * time.Hour, // Default 24 hour expiration
-		SigningMethod:    "HS256",
-		SigningKey:       "", // Will be set by client
-		AbilityDelimiter: ":",
-		Storage:          storage.NewMemoryDriver(),
+		RefreshExpireAt:  30 * 24 * time.Hour,
+		MaxLifetime:      30 * 24 * time.Hour,
+		SigningMethod:    defaultSigningMethod,
+		AbilityDelimiter: defaultAbilityDelimiter,
+		Locator:          locator.ID{},
+		Logger:           utils.NopLogger{},
+		GuestAbilities:   []string{"guest"},
+		Maintenance:      &Maintenance{},
 	}
 }
 
-func (c *Config) ApplyDefaults() {
-	def := DefaultConfig()
+// Now returns the current time of the config's clock.
+func (c *Config) Now() time.Time {
+	if c.Clock == nil {
+		return time.Now()
+	}
+	return c.Clock.Now()
+}
 
-	if c.TokenLength == 0 {
-		c.TokenLength = def.TokenLength
+// Random returns the entropy source for generated secrets.
+func (c *Config) Random() io.Reader {
+	if c.Rand == nil {
+		return rand.Reader
 	}
-	if c.TokenPrefix == "" {
-		c.TokenPrefix = def.TokenPrefix
+	return c.Rand
+}
+
+// AccessTTL returns the lifetime given to newly issued access tokens: the
+// idle window when sliding expiration is enabled, ExpireAt otherwise.
+func (c *Config) AccessTTL() time.Duration {
+	if c.SlidingIdle <= 0 {
+		return c.ExpireAt
 	}
-	if c.SigningMethod == "" {
-		c.SigningMethod = def.SigningMethod
+	if c.MaxLifetime > 0 && c.MaxLifetime < c.SlidingIdle {
+		return c.MaxLifetime
+	}
+	return c.SlidingIdle
+}
+
+// TokenEntropyBits returns the randomness in generated tokens. Each random
+// byte becomes two hex characters, so it is 4 bits per secret character.
+func (c *Config) TokenEntropyBits() int {
+	return c.TokenLength * 8
+}
+
+// HashSecret returns the string stored for a token secret: the compact
+// SHA-256 hex digest by default, or the Hasher's hash string.
+func (c *Config) HashSecret(secret string) (string, error) {
+	if c.Hasher == nil {
+		return utils.HashToken(secret), nil
+	}
+	return c.Hasher.Hash(secret)
+}
+
+// TokenFormat returns the wire format of the secrets this config generates.
+func (c *Config) TokenFormat() tokenformat.Format {
+	return tokenformat.Format{
+		Prefix:         c.TokenPrefix,
+		RandomBytes:    c.TokenLength,
+		Checksum:       c.Checksum,
+		ChecksumLength: c.ChecksumLength,
+		Key:            c.ChecksumKey,
+	}
+}
+
+// EffectiveAbilities returns the stored abilities string, or the one implied
+// by AbilityPolicy when the stored value is empty, with role references
+// expanded. entity.NoAbilities isn't empty, so it is returned as is and
+// grants nothing however often it is resolved.
+func (c *Config) EffectiveAbilities(stored string) string {
+	if strings.TrimSpace(stored) != "" {
+		return c.Roles.Expand(stored)
 	}
-	if c.SigningKey == "" {
-		c.SigningKey = def.SigningKey
+	switch c.AbilityPolicy {
+	case AbilityPolicyAllowAll:
+		return "*"
+	case AbilityPolicyDefaultSet:
+		return strings.Join(c.DefaultAbilities, ",")
+	default:
+		return ""
+	}
+}
+
+// ApplyDefaults fills unset fields. It runs on every issuance against the
+// shared client config, so it only writes fields that actually change.
+func (c *Config) ApplyDefaults() {
+	// Called on every validation, so defaults are filled in field by field
+	// rather than by building a DefaultConfig
+	if c.TokenLength == 0 {
+		c.TokenLength = defaultTokenLength
+	}
+	if c.SigningMethod == "" {
+		c.SigningMethod = defaultSigningMethod
 	}
 	if c.AbilityDelimiter == "" {
-		c.AbilityDelimiter = def.AbilityDelimiter
+		c.AbilityDelimiter = defaultAbilityDelimiter
+	}
+	if c.Locator == nil {
+		c.Locator = locator.ID{}
+	}
+	if c.Logger == nil {
+		c.Logger = utils.NopLogger{}
+	}
+	if c.GuestAbilities == nil {
+		c.GuestAbilities = []string{"guest"}
+	}
+	if c.Maintenance == nil {
+		c.Maintenance = &Maintenance{}
 	}
-	if c.Storage == nil {
-		c.Storage = storage.NewMemoryDriver()
+	if c.Workers == nil {
+		c.Workers = worker.New(c.Logger, 64)
 	}
 }
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"sync"
+
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// Maintenance is a runtime switch that makes validation reject every token
+// except those holding one of the allowlisted abilities. Revocation is not
+// gated, so tokens can still be killed while it is on.
+type Maintenance struct {
+	mu    sync.RWMutex
+	on    bool
+	allow []string
+}
+
+// Set turns maintenance mode on or off and replaces the allowlist.
+func (m *Maintenance) Set(on bool, allow []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.on = on
+	m.allow = append([]string(nil), allow...)
+}
+
+// State returns whether maintenance mode is on and a copy of the allowlist.
+func (m *Maintenance) State() (bool, []string) {
+	if m == nil {
+		return false, nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.on, append([]string(nil), m.allow...)
+}
+
+// Admits reports whether tok may pass, matching its abilities against the
+// allowlist with m (nil means entity.GlobMatcher). It always admits when
+// maintenance mode is off.
+func (m *Maintenance) Admits(tok *entity.PersonalAccessToken, matcher AbilityMatcher) bool {
+	if m == nil {
+		return true
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.on {
+		return true
+	}
+	for _, a := range m.allow {
+		if tok.CanWith(matcher, a) {
+			return true
+		}
+	}
+	return false
+}
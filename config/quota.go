@@ -0,0 +1,32 @@
+package config
+
+// Quota names, as reported in QuotaUsage and QuotaError
+const (
+	QuotaActiveTokens = "active_tokens"
+	QuotaSessions     = "sessions"
+)
+
+// DefaultQuotaWarnRatio is the share of a quota at which QuotaHook is
+// called when no ratio is configured.
+const DefaultQuotaWarnRatio = 0.8
+
+// OrgQuota caps what the members of an org, including those of its teams,
+// may hold at once. Zero fields are unlimited.
+type OrgQuota struct {
+	MaxActiveTokens int64 // Live access tokens
+	MaxSessions     int64 // Live refresh tokens, i.e. sessions started with a token pair
+}
+
+// QuotaFunc returns an org's quota, e.g. from its billing plan.
+type QuotaFunc func(orgID int64) (OrgQuota, error)
+
+// QuotaUsage is an org's usage of one quota after an issuance.
+type QuotaUsage struct {
+	OrgID int64
+	Quota string // QuotaActiveTokens or QuotaSessions
+	Used  int64
+	Limit int64
+}
+
+// QuotaHook is told when an issuance brings an org near a quota.
+type QuotaHook func(QuotaUsage)
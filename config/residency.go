@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/mohar9h/goauth/internal/locator"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// Residency pins each user's tokens to the storage of their home region.
+// Tokens issued for a pinned user carry the region in their locator, so
+// validation goes straight to that region's storage.
+type Residency struct {
+	// Resolve returns the user's home region, or "" to keep their tokens
+	// in the default storage
+	Resolve func(userID int64) (string, error)
+	// Regions maps region names to the storage serving them. A region
+	// missing here is hosted elsewhere and its tokens are rejected.
+	Regions map[string]storage.Driver
+}
+
+// ForUser returns the config to issue or look up the user's tokens with:
+// c itself for unpinned users, or a copy routed to their region.
+func (c *Config) ForUser(userID int64) (*Config, error) {
+	if c.Residency == nil || c.Region != "" || userID <= 0 {
+		return c, nil
+	}
+	region, err := c.Residency.Resolve(userID)
+	if err != nil {
+		return nil, fmt.Errorf("resolve region for user %d: %w", userID, err)
+	}
+	if region == "" {
+		return c, nil
+	}
+	return c.forRegion(region)
+}
+
+// ForLocator returns the config to validate a token with the given locator
+// segment, routing region-prefixed tokens to their region's storage.
+func (c *Config) ForLocator(loc string) (*Config, error) {
+	if c.Residency == nil || c.Region != "" {
+		return c, nil
+	}
+	region, _ := locator.SplitRegion(loc)
+	if region == "" {
+		return c, nil
+	}
+	return c.forRegion(region)
+}
+
+func (c *Config) forRegion(region string) (*Config, error) {
+	store, ok := c.Residency.Regions[region]
+	if !ok || store == nil {
+		return nil, fmt.Errorf("%w: %q", utils.ErrCrossRegion, region)
+	}
+	cp := *c
+	cp.Storage = store
+	cp.Locator = locator.Regional{Region: region, Inner: c.Locator}
+	cp.Region = region
+	return &cp, nil
+}
+
+// RegionStorages returns the storage of every region served here, for
+// maintenance that must cover all of them such as pruning.
+func (c *Config) RegionStorages() []storage.Driver {
+	if c.Residency == nil {
+		return nil
+	}
+	out := make([]storage.Driver, 0, len(c.Residency.Regions))
+	for _, d := range c.Residency.Regions {
+		out = append(out, d)
+	}
+	return out
+}
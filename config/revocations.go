@@ -0,0 +1,109 @@
+package config
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// Revocations is an in-memory, versioned set of recent revocations, fed
+// from the change feed and from other instances. Validation consults it
+// after a cached lookup, so a token revoked elsewhere is rejected before
+// its cache entry expires.
+type Revocations struct {
+	mu      sync.RWMutex
+	version uint64
+	tokens  map[int64]time.Time // Token ID -> when it was learned
+	users   map[int64]time.Time // User ID -> tokens created up to here are revoked
+}
+
+// NewRevocations returns an empty set.
+func NewRevocations() *Revocations {
+	return &Revocations{tokens: make(map[int64]time.Time), users: make(map[int64]time.Time)}
+}
+
+// AddToken records that the token id was revoked at.
+func (r *Revocations) AddToken(id int64, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tokens[id]; !ok {
+		r.tokens[id] = at
+		r.version++
+	}
+}
+
+// AddUser records that every token the user held at the time was revoked.
+func (r *Revocations) AddUser(userID int64, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if at.After(r.users[userID]) {
+		r.users[userID] = at
+		r.version++
+	}
+}
+
+// Revoked reports whether tok is known to be revoked.
+func (r *Revocations) Revoked(tok *entity.PersonalAccessToken) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.tokens[tok.ID]; ok {
+		return true
+	}
+	cutoff, ok := r.users[tok.UserId]
+	return ok && !tok.CreatedAt.After(cutoff)
+}
+
+// Prune forgets revocations learned before the cutoff and returns how
+// many it dropped. Storage still rejects those tokens; the set only needs
+// to outlive cached copies of them.
+func (r *Revocations) Prune(before time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for id, at := range r.tokens {
+		if at.Before(before) {
+			delete(r.tokens, id)
+			n++
+		}
+	}
+	for id, at := range r.users {
+		if at.Before(before) {
+			delete(r.users, id)
+			n++
+		}
+	}
+	if n > 0 {
+		r.version++
+	}
+	return n
+}
+
+// Version increases with every change to the set.
+func (r *Revocations) Version() uint64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.version
+}
+
+// Len returns the number of revoked tokens and users held.
+func (r *Revocations) Len() (tokens, users int) {
+	if r == nil {
+		return 0, 0
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.tokens), len(r.users)
+}
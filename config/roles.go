@@ -0,0 +1,115 @@
+package config
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// RolePrefix marks a stored ability as a reference to a role, e.g.
+// "role:editor", which expands to the abilities the role grants.
+const RolePrefix = "role:"
+
+// Roles is a runtime-replaceable table of role definitions, fed from a
+// signed policy bundle and from roles kept in storage. A bundle definition
+// wins over a stored role of the same name.
+type Roles struct {
+	mu      sync.RWMutex
+	version int64
+	roles   map[string][]string
+	stored  map[string][]string
+}
+
+// Set replaces the role table and records the version it came from.
+func (r *Roles) Set(version int64, roles map[string][]string) {
+	cp := make(map[string][]string, len(roles))
+	for name, abilities := range roles {
+		cp[name] = append([]string(nil), abilities...)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.version = version
+	r.roles = cp
+}
+
+// SetStored replaces the roles loaded from storage.
+func (r *Roles) SetStored(roles map[string][]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stored = roles
+}
+
+// Has reports whether a role is defined.
+func (r *Roles) Has(name string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.lookup(name)
+	return ok
+}
+
+func (r *Roles) lookup(name string) ([]string, bool) {
+	if abilities, ok := r.roles[name]; ok {
+		return abilities, true
+	}
+	abilities, ok := r.stored[name]
+	return abilities, ok
+}
+
+// Version returns the version of the current role table (0 if unset).
+func (r *Roles) Version() int64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.version
+}
+
+// Expand replaces every "role:<name>" entry of a stored abilities string
+// with the abilities of that role. Unknown roles expand to nothing.
+func (r *Roles) Expand(abilities string) string {
+	if r == nil || !strings.Contains(abilities, RolePrefix) {
+		return abilities
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var out []string
+	for _, a := range entity.SplitAbilities(abilities) {
+		name, ok := strings.CutPrefix(strings.TrimSpace(a), RolePrefix)
+		if !ok || strings.Contains(name, " ") {
+			out = append(out, a)
+			continue
+		}
+		abilities, _ := r.lookup(name)
+		out = append(out, abilities...)
+	}
+	return strings.Join(out, ",")
+}
+
+// All returns a copy of the role table.
+func (r *Roles) All() map[string][]string {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cp := make(map[string][]string, len(r.roles)+len(r.stored))
+	for name, abilities := range r.stored {
+		cp[name] = append([]string(nil), abilities...)
+	}
+	for name, abilities := range r.roles {
+		cp[name] = append([]string(nil), abilities...)
+	}
+	return cp
+}
@@ -0,0 +1,281 @@
+package goauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// MetaRequestedScopes records the scopes a client asked for on tokens issued
+// after consent, so GrantedScopes can report which ones the user declined
+const MetaRequestedScopes = "goauth.requested_scopes"
+
+// ConsentRequest is an authorization awaiting the user's decision, as
+// resolved by ConsentHandlers.Load from the pending OAuth2 request
+type ConsentRequest struct {
+	AppName string      // Name of the client application asking for access
+	Scopes  []string    // Requested abilities
+	User    ConsentUser // Signed-in user asked to consent
+	State   any         // Application data passed through to Approve and Deny
+
+	// Granted is set before Approve to the scopes the user left checked,
+	// in the order they were requested
+	Granted []string
+}
+
+// TokenOptions returns options issuing a token for the consenting user with
+// the granted scopes, recording the requested ones for GrantedScopes
+func (r *ConsentRequest) TokenOptions() *TokenOptions {
+	name := r.AppName
+	return &TokenOptions{
+		UserId:    r.User.ID,
+		Name:      &name,
+		Abilities: slices.Clone(r.Granted),
+		Metadata:  map[string]string{MetaRequestedScopes: strings.Join(r.Scopes, " ")},
+	}
+}
+
+// ScopeGrant reports how a token's scopes compare to what was requested
+type ScopeGrant struct {
+	Requested []string `json:"requested"`
+	Granted   []string `json:"granted"`
+	Denied    []string `json:"denied"` // Requested but unchecked by the user
+}
+
+// GrantedScopes validates a token and reports the scopes it was granted and,
+// for tokens issued through ConsentRequest.TokenOptions, those the user
+// declined. Client applications should check it before relying on a scope.
+func (c *Client) GrantedScopes(ctx context.Context, raw string) (*ScopeGrant, error) {
+	tok, err := c.ValidateToken(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	grant := &ScopeGrant{Granted: tok.AbilityList(), Denied: []string{}}
+	if grant.Granted == nil {
+		grant.Granted = []string{}
+	}
+	grant.Requested = strings.Fields(tok.Metadata[MetaRequestedScopes])
+	if len(grant.Requested) == 0 {
+		grant.Requested = grant.Granted
+	}
+	for _, s := range grant.Requested {
+		if !slices.Contains(grant.Granted, s) {
+			grant.Denied = append(grant.Denied, s)
+		}
+	}
+	return grant, nil
+}
+
+// ConsentUser describes the signed-in user on the consent page
+type ConsentUser struct {
+	ID    int64
+	Name  string
+	Email string
+}
+
+// ConsentScope is a requested ability with its description from the
+// ability catalog (see WithAbility)
+type ConsentScope struct {
+	Name        string
+	Description string
+}
+
+// ConsentPage is the data a consent template is executed with. Forms must
+// post CSRFField with CSRFToken, a "scope" field for each scope the user
+// keeps, and a "decision" field of "approve" or "deny" back to the consent
+// URL.
+type ConsentPage struct {
+	AppName   string
+	Scopes    []ConsentScope
+	User      ConsentUser
+	CSRFField string
+	CSRFToken string
+}
+
+// Form fields posted by the consent page
+const (
+	ConsentCSRFField     = "csrf_token"
+	ConsentDecisionField = "decision"
+	ConsentScopeField    = "scope"
+)
+
+// ConsentHandlers plugs the consent screen into an authorization endpoint
+type ConsentHandlers struct {
+	// Load resolves the pending authorization for the request, e.g. from
+	// the session. It is called for both rendering and submission.
+	Load func(r *http.Request) (*ConsentRequest, error)
+	// Approve and Deny complete the authorization, typically by
+	// redirecting back to the client application
+	Approve func(w http.ResponseWriter, r *http.Request, req *ConsentRequest)
+	Deny    func(w http.ResponseWriter, r *http.Request, req *ConsentRequest)
+
+	Template   *template.Template // Executed with a ConsentPage. Default a plain built-in page
+	CookieName string             // CSRF cookie name. Default "goauth_consent"
+}
+
+// ConsentPageData builds the template data for req, describing each scope
+// from the ability catalog
+func (c *Client) ConsentPageData(req *ConsentRequest, csrfToken string) ConsentPage {
+	page := ConsentPage{
+		AppName:   req.AppName,
+		Scopes:    make([]ConsentScope, 0, len(req.Scopes)),
+		User:      req.User,
+		CSRFField: ConsentCSRFField,
+		CSRFToken: csrfToken,
+	}
+	for _, s := range req.Scopes {
+		desc, _ := c.AbilityDescription(s)
+		page.Scopes = append(page.Scopes, ConsentScope{Name: s, Description: desc})
+	}
+	return page
+}
+
+// ConsentHandler serves the consent screen: GET renders the page for the
+// request returned by Load, and POST calls Approve or Deny once the
+// submitted CSRF token matches the cookie set when the page was rendered.
+// Users may uncheck individual scopes; Approve receives the rest in
+// ConsentRequest.Granted, and approving with none checked counts as a
+// denial.
+func (c *Client) ConsentHandler(h ConsentHandlers) (http.Handler, error) {
+	if h.Load == nil || h.Approve == nil || h.Deny == nil {
+		return nil, fmt.Errorf("consent handlers require Load, Approve and Deny")
+	}
+	if h.Template == nil {
+		h.Template = consentPage
+	}
+	if h.CookieName == "" {
+		h.CookieName = "goauth_consent"
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			c.renderConsent(w, r, h)
+		case http.MethodPost:
+			c.submitConsent(w, r, h)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	}), nil
+}
+
+func (c *Client) renderConsent(w http.ResponseWriter, r *http.Request, h ConsentHandlers) {
+	req, err := h.Load(r)
+	if err != nil || req == nil {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	csrf := base64.RawURLEncoding.EncodeToString(buf)
+
+	var page bytes.Buffer
+	if err := h.Template.Execute(&page, c.ConsentPageData(req, csrf)); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.CookieName,
+		Value:    csrf,
+		Path:     r.URL.Path,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// Keep the page out of frames so it cannot be clickjacked
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	_, _ = page.WriteTo(w)
+}
+
+func (c *Client) submitConsent(w http.ResponseWriter, r *http.Request, h ConsentHandlers) {
+	cookie, err := r.Cookie(h.CookieName)
+	submitted := r.PostFormValue(ConsentCSRFField)
+	if err != nil || cookie.Value == "" ||
+		subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(submitted)) != 1 {
+		http.Error(w, "invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	// Tokens are single use
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.CookieName,
+		Path:     r.URL.Path,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	req, err := h.Load(r)
+	if err != nil || req == nil {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	switch r.PostFormValue(ConsentDecisionField) {
+	case "approve":
+		// Only scopes that were requested can be granted
+		kept := r.PostForm[ConsentScopeField]
+		req.Granted = nil
+		for _, s := range req.Scopes {
+			if slices.Contains(kept, s) && !slices.Contains(req.Granted, s) {
+				req.Granted = append(req.Granted, s)
+			}
+		}
+		if len(req.Granted) == 0 {
+			h.Deny(w, r, req)
+			return
+		}
+		h.Approve(w, r, req)
+	case "deny":
+		h.Deny(w, r, req)
+	default:
+		http.Error(w, "missing consent decision", http.StatusBadRequest)
+	}
+}
+
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Authorize {{.AppName}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 3rem auto; max-width: 32rem; padding: 0 1rem; }
+ul { padding-left: 1.2rem; }
+li { margin-bottom: .5rem; }
+.desc { color: #555; display: block; }
+button { font-size: 1rem; margin-right: .5rem; padding: .4rem 1.2rem; }
+</style>
+</head>
+<body>
+<h1>Authorize {{.AppName}}</h1>
+<p>{{if .User.Name}}Signed in as <strong>{{.User.Name}}</strong>{{if .User.Email}} ({{.User.Email}}){{end}}.{{else if .User.Email}}Signed in as <strong>{{.User.Email}}</strong>.{{end}}</p>
+<p><strong>{{.AppName}}</strong> is requesting permission to:</p>
+<form method="post">
+<input type="hidden" name="{{.CSRFField}}" value="{{.CSRFToken}}">
+<ul>
+{{range .Scopes}}<li><label><input type="checkbox" name="scope" value="{{.Name}}" checked> <code>{{.Name}}</code></label>{{if .Description}}<span class="desc">{{.Description}}</span>{{end}}</li>
+{{end}}</ul>
+<p>Uncheck anything you don't want to allow.</p>
+<button type="submit" name="decision" value="approve">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
@@ -0,0 +1,52 @@
+package goauth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/cache"
+)
+
+// decisionKey identifies an Authorize decision: the token, a digest of the
+// resource attributes and the requested ability
+type decisionKey struct {
+	token    int64
+	resource [sha256.Size]byte
+	ability  string
+}
+
+// WithDecisionCache caches up to size Authorize decisions for ttl, so hot
+// endpoints skip condition evaluation and inherited-grant lookups. Token
+// validation still runs on every call. The cache is cleared when a policy
+// bundle is reloaded or org grants change through this client; other
+// changes, such as editing a token's abilities, take effect within ttl.
+func WithDecisionCache(size int, ttl time.Duration) Option {
+	return func(c *Client) error {
+		if size <= 0 || ttl <= 0 {
+			return fmt.Errorf("decision cache needs a positive size and TTL")
+		}
+		c.decisions = cache.New[decisionKey, bool](size, ttl)
+		return nil
+	}
+}
+
+// InvalidateDecisions clears the decision cache, e.g. after changing grants
+// through another process
+func (c *Client) InvalidateDecisions() {
+	if c.decisions != nil {
+		c.decisions.Purge()
+	}
+}
+
+// decisionCacheKey builds the cache key for a decision. Resources that
+// cannot be encoded are not cached.
+func decisionCacheKey(tok *PersonalAccessToken, ability string, resource map[string]any) (decisionKey, bool) {
+	// encoding/json sorts map keys, so equal resources encode identically
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return decisionKey{}, false
+	}
+	return decisionKey{token: tok.ID, resource: sha256.Sum256(data), ability: ability}, true
+}
@@ -0,0 +1,230 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Metadata keys identifying a device token's device
+const (
+	MetaDeviceName        = "goauth.device_name"
+	MetaDeviceFingerprint = "goauth.device_fingerprint"
+)
+
+// DefaultDeviceTokenTTL is the lifetime of device tokens unless changed
+// with WithDeviceTokenTTL
+const DefaultDeviceTokenTTL = 90 * 24 * time.Hour
+
+// WithDeviceTokenTTL sets the lifetime of tokens created by
+// CreateDeviceToken (default 90 days)
+func WithDeviceTokenTTL(ttl time.Duration) Option {
+	return func(c *Client) error {
+		if ttl <= 0 {
+			return fmt.Errorf("device token TTL must be positive")
+		}
+		c.config.DeviceTokenTTL = ttl
+		return nil
+	}
+}
+
+// DeviceOptions describe the device a device token is issued to.
+type DeviceOptions struct {
+	Name        string   // Shown to the user, e.g. "Firefox on macOS"
+	Fingerprint string   // Stable device identifier; signing in again replaces the device's token
+	Abilities   []string // Abilities of the token
+	Metadata    map[string]string
+}
+
+// Device is a device signed in with a device token, for "manage your
+// devices" screens.
+type Device struct {
+	ID          int64 // Token ID, for RevokeDevice
+	Name        string
+	Fingerprint string
+	CreatedAt   time.Time
+	LastUsedAt  *time.Time
+	ExpiresAt   *time.Time
+}
+
+// CreateDeviceToken issues a long-lived "remember me" token for one of the
+// user's devices. It is an ordinary access token tagged with the device,
+// valid for WithDeviceTokenTTL. A token for a device with the same
+// fingerprint is revoked once the new one is stored, so each device holds
+// one token.
+func (c *Client) CreateDeviceToken(ctx context.Context, userID int64, opts DeviceOptions) (*TokenResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if userID <= 0 {
+		return nil, fmt.Errorf("user ID must be positive")
+	}
+
+	if opts.Name == "" {
+		return nil, fmt.Errorf("device name cannot be empty")
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	meta := make(map[string]string, len(opts.Metadata)+2)
+	for k, v := range opts.Metadata {
+		meta[k] = v
+	}
+	meta[MetaDeviceName] = opts.Name
+	if opts.Fingerprint != "" {
+		meta[MetaDeviceFingerprint] = opts.Fingerprint
+	}
+
+	return traced(c, ctx, "CreateDeviceToken", func(cfg *config.Config) (*TokenResult, error) {
+		ttl := cfg.DeviceTokenTTL
+		if ttl == 0 {
+			ttl = DefaultDeviceTokenTTL
+		}
+		name := opts.Name
+		result, err := auth.Issue(c.authOptions(&TokenOptions{
+			UserId:    userID,
+			Name:      &name,
+			Abilities: opts.Abilities,
+			Metadata:  meta,
+			ExpiresIn: ttl,
+		}, cfg))
+		if err != nil || opts.Fingerprint == "" {
+			return result, err
+		}
+		if err := c.revokeDevices(cfg, userID, opts.Fingerprint, result.TokenID); err != nil {
+			cfg.Logger.Warn("failed to revoke replaced device token", "user_id", userID, "error", err)
+		}
+		return result, nil
+	}, attribute.Int64("goauth.user_id", userID))
+}
+
+// ListDevices returns the user's devices holding a live device token,
+// oldest first.
+func (c *Client) ListDevices(ctx context.Context, userID int64) ([]*Device, error) {
+	tokens, _, err := c.ListTokens(ctx, userID, &ListOptions{Status: StatusActive})
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []*Device
+	for _, t := range tokens {
+		if d := deviceOf(t); d != nil {
+			devices = append(devices, d)
+		}
+	}
+	return devices, nil
+}
+
+// RevokeDevice signs the user out of a device by revoking its device
+// token, following WithSoftRevocation. It returns ErrTokenNotFound if id
+// isn't one of the user's device tokens.
+func (c *Client) RevokeDevice(ctx context.Context, userID, id int64) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if userID <= 0 || id <= 0 {
+		return fmt.Errorf("user and device IDs must be positive")
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if c.fallback != nil {
+		c.fallback.Purge()
+		defer c.fallback.Purge()
+	}
+
+	err := tracedErr(c, ctx, "RevokeDevice", func(cfg *config.Config) error {
+		cfg, err := cfg.ForUser(userID)
+		if err != nil {
+			return err
+		}
+		tok, err := cfg.Storage.FindByID(id)
+		if err != nil {
+			return err
+		}
+		if tok.UserId != userID || deviceOf(tok) == nil {
+			return utils.ErrTokenNotFound
+		}
+		return revokeStored(cfg, tok)
+	}, attribute.Int64("goauth.user_id", userID))
+	if err == nil {
+		c.announceRevocation(ctx, userID, id)
+	}
+	return err
+}
+
+// DeviceOf returns the device a token was issued to, or nil if it isn't a
+// device token
+func DeviceOf(tok *entity.PersonalAccessToken) *Device {
+	return deviceOf(tok)
+}
+
+func deviceOf(tok *entity.PersonalAccessToken) *Device {
+	name, ok := tok.Metadata[MetaDeviceName]
+	if !ok {
+		return nil
+	}
+	return &Device{
+		ID:          tok.ID,
+		Name:        name,
+		Fingerprint: tok.Metadata[MetaDeviceFingerprint],
+		CreatedAt:   tok.CreatedAt,
+		LastUsedAt:  tok.LastUsedAt,
+		ExpiresAt:   tok.ExpiresAt,
+	}
+}
+
+// revokeDevices revokes the user's live tokens for a fingerprint other
+// than the one hashed keep
+func (c *Client) revokeDevices(cfg *config.Config, userID int64, fingerprint, keep string) error {
+	if !storage.CapabilitiesOf(cfg.Storage).List {
+		return nil
+	}
+	cfg, err := cfg.ForUser(userID)
+	if err != nil {
+		return err
+	}
+	tokens, _, err := cfg.Storage.FindByUser(userID, ListOptions{Status: StatusActive})
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if t.Token == keep || t.Metadata[MetaDeviceFingerprint] != fingerprint || deviceOf(t) == nil {
+			continue
+		}
+		if err := revokeStored(cfg, t); err != nil {
+			return err
+		}
+	}
+	if c.fallback != nil {
+		c.fallback.Purge()
+	}
+	return nil
+}
+
+// revokeStored revokes a token found in storage, following soft revocation
+func revokeStored(cfg *config.Config, tok *entity.PersonalAccessToken) error {
+	if cfg.SoftRevocation {
+		return cfg.Storage.MarkRevoked(tok.Token, cfg.Now())
+	}
+	return cfg.Storage.RevokeToken(tok.Token)
+}
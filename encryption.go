@@ -0,0 +1,103 @@
+package goauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// FieldKey is a data-encryption key for WithFieldEncryption
+type FieldKey = storage.FieldKey
+
+// ErrUnknownFieldKey is returned when a token's metadata was encrypted
+// with a key the client wasn't given
+var ErrUnknownFieldKey = storage.ErrUnknownFieldKey
+
+// WithFieldEncryption encrypts token metadata, which holds client details
+// such as bound IPs, user agents and device names, with AES-GCM before the
+// GORM drivers write it, so a copy of the token table doesn't reveal it.
+// Rows name the key they were sealed with: pass retired keys as previous
+// to keep reading them while ReencryptFields, or ordinary updates, move
+// rows to current. Metadata written before encryption was enabled is read
+// as is. The memory driver holds nothing at rest and keeps metadata as
+// given.
+func WithFieldEncryption(current FieldKey, previous ...FieldKey) Option {
+	return func(c *Client) error {
+		fc, err := storage.NewFieldCipher(current, previous...)
+		if err != nil {
+			return fmt.Errorf("field encryption: %w", err)
+		}
+		c.fieldCipher = fc
+		return nil
+	}
+}
+
+// ReencryptFields rewrites the metadata of every row that is unencrypted
+// or sealed with a previous key using the current key, and returns how
+// many rows it changed. Rows updated while it runs are skipped, since the
+// update already sealed them; run it after rotating keys until it returns
+// 0, then drop the previous keys.
+func (c *Client) ReencryptFields(ctx context.Context) (int64, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	if c.fieldCipher == nil {
+		return 0, fmt.Errorf("no field encryption configured")
+	}
+
+	n, err := traced(c, ctx, "ReencryptFields", func(cfg *config.Config) (int64, error) {
+		var total int64
+		for _, d := range c.encryptedDrivers() {
+			n, err := storage.ReencryptFields(d)
+			if err != nil && !errors.Is(err, utils.ErrNotSupported) {
+				return total, err
+			}
+			total += n
+		}
+		return total, nil
+	})
+	if err != nil {
+		return n, fmt.Errorf("failed to re-encrypt token metadata: %w", err)
+	}
+	return n, nil
+}
+
+// encryptStorage hands the cipher to the storage driver and to those of
+// every region and replication peer
+func (c *Client) encryptStorage() error {
+	if c.fieldCipher == nil {
+		return nil
+	}
+	for _, d := range c.encryptedDrivers() {
+		if err := storage.EncryptFields(d, c.fieldCipher); err != nil {
+			return fmt.Errorf("goauth: field encryption: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) encryptedDrivers() []storage.Driver {
+	drivers := []storage.Driver{c.storage}
+	if c.config.Residency != nil {
+		for _, d := range c.config.Residency.Regions {
+			drivers = append(drivers, d)
+		}
+	}
+	if c.replication != nil {
+		for _, p := range c.replication.peers {
+			drivers = append(drivers, p.Storage)
+		}
+	}
+	return drivers
+}
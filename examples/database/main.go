@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/mohar9h/goauth"
+	"github.com/mohar9h/goauth/goauthtest"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -73,11 +74,13 @@ func main() {
 	// Demonstrate token expiration
 	fmt.Println("\n--- Testing Token Expiration ---")
 
-	// Create a token with very short expiration
+	// Create a token with a one hour expiration on a clock we control
+	clock := goauthtest.NewClock(time.Now())
 	shortExpiryClient, err := goauth.NewClient(
 		goauth.WithSigningKey("test-key"),
 		goauth.WithGormStorage(db),
-		goauth.WithTokenExpiration(1*time.Millisecond),
+		goauth.WithTokenExpiration(time.Hour),
+		goauth.WithClock(clock),
 	)
 	if err != nil {
 		log.Fatal("Failed to create short expiry client:", err)
@@ -98,8 +101,8 @@ func main() {
 	}
 	fmt.Println("Token is valid immediately after creation")
 
-	// Wait for expiration
-	time.Sleep(10 * time.Millisecond)
+	// Move past the expiration without waiting
+	clock.Advance(2 * time.Hour)
 
 	// Token should be expired
 	_, err = shortExpiryClient.ValidateToken(context.Background(), expiringToken)
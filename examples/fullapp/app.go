@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohar9h/goauth"
+	"github.com/mohar9h/goauth/middleware"
+)
+
+// Abilities used by the app. Sessions get the ones a signed in user needs;
+// personal access tokens get a subset the user picks.
+const (
+	abilitySession      = "session"
+	abilityManageTokens = "tokens:manage"
+	abilityAdmin        = "admin"
+	abilityMFAChallenge = "mfa:challenge"
+	abilityRead         = "notes:read"
+	abilityWrite        = "notes:write"
+)
+
+// metaPAT tags personal access tokens so listings leave out sessions and
+// MFA challenges
+const metaPAT = "fullapp.pat"
+
+const mfaChallengeTTL = 5 * time.Minute
+
+var patAbilities = []string{abilityRead, abilityWrite}
+
+// app is the HTTP API: password login with optional TOTP, device
+// sessions, personal access token management and a few admin endpoints
+type app struct {
+	client *goauth.Client
+	users  *userStore
+	now    func() time.Time
+}
+
+func newApp(client *goauth.Client, users *userStore) *app {
+	return &app{client: client, users: users, now: time.Now}
+}
+
+func (a *app) routes() http.Handler {
+	mux := http.NewServeMux()
+	session := middleware.RequireAbility(a.client, abilitySession)
+	manage := middleware.RequireAbility(a.client, abilityManageTokens)
+	admin := middleware.RequireAbility(a.client, abilityAdmin)
+
+	mux.HandleFunc("POST /login", a.login)
+	mux.HandleFunc("POST /login/mfa", a.loginMFA)
+	mux.Handle("POST /logout", session(http.HandlerFunc(a.logout)))
+
+	mux.Handle("GET /sessions", session(http.HandlerFunc(a.listSessions)))
+	mux.Handle("DELETE /sessions/{id}", session(http.HandlerFunc(a.endSession)))
+
+	mux.Handle("GET /tokens", manage(http.HandlerFunc(a.listTokens)))
+	mux.Handle("POST /tokens", manage(http.HandlerFunc(a.createToken)))
+	mux.Handle("DELETE /tokens/{id}", manage(http.HandlerFunc(a.revokeToken)))
+	mux.Handle("POST /tokens/rotate", middleware.Authenticate(a.client)(http.HandlerFunc(a.rotateToken)))
+
+	mux.Handle("GET /notes", middleware.RequireAbility(a.client, abilityRead)(http.HandlerFunc(a.listNotes)))
+
+	mux.Handle("GET /admin/revocations", admin(http.HandlerFunc(a.revocations)))
+	mux.Handle("POST /admin/prune", admin(http.HandlerFunc(a.prune)))
+	return mux
+}
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type mfaRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+type sessionResponse struct {
+	Token     string     `json:"token,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	Challenge   string `json:"challenge,omitempty"`
+}
+
+// login checks the password and either starts a session or, for users with
+// MFA, hands out a short-lived challenge token to present with the code
+func (a *app) login(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	u := a.users.authenticate(req.Email, req.Password)
+	if u == nil {
+		writeError(w, http.StatusUnauthorized, "invalid email or password")
+		return
+	}
+
+	if u.TOTPSecret == "" {
+		a.startSession(w, r, u)
+		return
+	}
+
+	name := "mfa challenge"
+	challenge, err := a.client.CreateToken(r.Context(), &goauth.TokenOptions{
+		UserId:    u.ID,
+		Name:      &name,
+		Abilities: []string{abilityMFAChallenge},
+		ExpiresIn: mfaChallengeTTL,
+	})
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sessionResponse{MFARequired: true, Challenge: challenge})
+}
+
+// loginMFA trades a challenge token and a TOTP code for a session. The
+// challenge is revoked whatever the outcome, so each one allows one guess.
+func (a *app) loginMFA(w http.ResponseWriter, r *http.Request) {
+	var req mfaRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	tok, err := a.client.ValidateTokenWithAbility(r.Context(), req.Challenge, abilityMFAChallenge)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid or expired challenge")
+		return
+	}
+	if err := a.client.RevokeToken(r.Context(), req.Challenge); err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	u := a.users.get(tok.UserId)
+	if u == nil || !verifyTOTP(u.TOTPSecret, req.Code, a.now()) {
+		writeError(w, http.StatusUnauthorized, "invalid code")
+		return
+	}
+	a.startSession(w, r, u)
+}
+
+// startSession issues a device token for the browser or app signing in.
+// Signing in again from the same device replaces its session.
+func (a *app) startSession(w http.ResponseWriter, r *http.Request, u *user) {
+	abilities := []string{abilitySession, abilityManageTokens, abilityRead, abilityWrite}
+	if u.Admin {
+		abilities = append(abilities, abilityAdmin)
+	}
+	device := r.UserAgent()
+	if device == "" {
+		device = "unknown device"
+	}
+
+	res, err := a.client.CreateDeviceToken(r.Context(), u.ID, goauth.DeviceOptions{
+		Name:        device,
+		Fingerprint: r.Header.Get("X-Device-ID"),
+		Abilities:   abilities,
+	})
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sessionResponse{Token: res.PlainText, ExpiresAt: res.ExpiresAt})
+}
+
+func (a *app) logout(w http.ResponseWriter, r *http.Request) {
+	if err := a.client.RevokeToken(r.Context(), r.Header.Get("Authorization")); err != nil {
+		writeServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *app) listSessions(w http.ResponseWriter, r *http.Request) {
+	tok, _ := middleware.TokenFromContext(r.Context())
+	devices, err := a.client.ListDevices(r.Context(), tok.UserId)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func (a *app) endSession(w http.ResponseWriter, r *http.Request) {
+	tok, _ := middleware.TokenFromContext(r.Context())
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	err := a.client.RevokeDevice(r.Context(), tok.UserId, id)
+	// Sessions ended before are as gone as ones that never existed
+	if errors.Is(err, goauth.ErrTokenInvalid) {
+		writeError(w, http.StatusNotFound, "no such session")
+		return
+	}
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type tokenView struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Abilities  []string   `json:"abilities"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// listTokens shows the user's active personal access tokens. Plaintexts
+// are never stored, so only metadata can be listed.
+func (a *app) listTokens(w http.ResponseWriter, r *http.Request) {
+	tok, _ := middleware.TokenFromContext(r.Context())
+	tokens, _, err := a.client.ListTokens(r.Context(), tok.UserId, &goauth.ListOptions{Status: goauth.StatusActive})
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	views := []tokenView{}
+	for _, t := range tokens {
+		if t.Metadata[metaPAT] == "" {
+			continue
+		}
+		v := tokenView{ID: t.ID, Abilities: t.AbilityList(), CreatedAt: t.CreatedAt, LastUsedAt: t.LastUsedAt, ExpiresAt: t.ExpiresAt}
+		if t.Name != nil {
+			v.Name = *t.Name
+		}
+		views = append(views, v)
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+type createTokenRequest struct {
+	Name          string   `json:"name"`
+	Abilities     []string `json:"abilities"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 = the client default
+}
+
+type createTokenResponse struct {
+	Token     string     `json:"token"` // Shown once
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (a *app) createToken(w http.ResponseWriter, r *http.Request) {
+	tok, _ := middleware.TokenFromContext(r.Context())
+	var req createTokenRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Name == "" || len(req.Abilities) == 0 || req.ExpiresInDays < 0 {
+		writeError(w, http.StatusBadRequest, "name and abilities are required")
+		return
+	}
+	for _, ability := range req.Abilities {
+		if !slices.Contains(patAbilities, ability) {
+			writeError(w, http.StatusBadRequest, "unknown ability "+strconv.Quote(ability))
+			return
+		}
+	}
+
+	res, err := a.client.IssueToken(r.Context(), &goauth.TokenOptions{
+		UserId:    tok.UserId,
+		Name:      &req.Name,
+		Abilities: req.Abilities,
+		Metadata:  map[string]string{metaPAT: "1"},
+		ExpiresIn: time.Duration(req.ExpiresInDays) * 24 * time.Hour,
+	})
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, createTokenResponse{Token: res.PlainText, ExpiresAt: res.ExpiresAt})
+}
+
+func (a *app) revokeToken(w http.ResponseWriter, r *http.Request) {
+	tok, _ := middleware.TokenFromContext(r.Context())
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	err := a.client.RevokeTokenByID(r.Context(), tok.UserId, id)
+	if errors.Is(err, goauth.ErrTokenInvalid) {
+		writeError(w, http.StatusNotFound, "no such token")
+		return
+	}
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rotateToken swaps the presented token for a new one, for CI systems that
+// rotate their credentials on a schedule
+func (a *app) rotateToken(w http.ResponseWriter, r *http.Request) {
+	rot, err := a.client.RotateToken(r.Context(), r.Header.Get("Authorization"))
+	if errors.Is(err, goauth.ErrTokenRotated) {
+		writeError(w, http.StatusConflict, "token was already rotated")
+		return
+	}
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, createTokenResponse{Token: rot.PlainText, ExpiresAt: rot.ExpiresAt})
+}
+
+func (a *app) listNotes(w http.ResponseWriter, r *http.Request) {
+	tok, _ := middleware.TokenFromContext(r.Context())
+	writeJSON(w, http.StatusOK, []string{"note for user " + strconv.FormatInt(tok.UserId, 10)})
+}
+
+type revocationsResponse struct {
+	Revoked []int64 `json:"revoked"`
+	Cursor  string  `json:"cursor"` // Pass back as ?cursor= to continue
+}
+
+// revocations tails the change feed for revoked tokens, as a downstream
+// cache or gateway would
+func (a *app) revocations(w http.ResponseWriter, r *http.Request) {
+	cursor, ok := parseCursor(r.URL.Query().Get("cursor"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "malformed cursor")
+		return
+	}
+	changes, err := a.client.ChangesSince(r.Context(), cursor, 500)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	resp := revocationsResponse{Revoked: []int64{}, Cursor: formatCursor(changes.Next)}
+	for _, t := range changes.Tokens {
+		if t.RevokedAt != nil {
+			resp.Revoked = append(resp.Revoked, t.ID)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type pruneResponse struct {
+	Expired int64 `json:"expired"`
+	Revoked int64 `json:"revoked"`
+}
+
+// prune deletes expired tokens and revocations older than a day, which
+// revocation tailers have long seen
+func (a *app) prune(w http.ResponseWriter, r *http.Request) {
+	expired, err := a.client.PruneExpired(r.Context())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	revoked, err := a.client.PruneRevoked(r.Context(), 24*time.Hour)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pruneResponse{Expired: expired, Revoked: revoked})
+}
+
+func formatCursor(c goauth.ChangeCursor) string {
+	return strconv.FormatInt(c.Revision, 10) + "." + strconv.FormatInt(c.ID, 10)
+}
+
+func parseCursor(s string) (goauth.ChangeCursor, bool) {
+	if s == "" {
+		return goauth.ChangeCursor{}, true
+	}
+	rev, id, ok := strings.Cut(s, ".")
+	if !ok {
+		return goauth.ChangeCursor{}, false
+	}
+	var c goauth.ChangeCursor
+	var err1, err2 error
+	c.Revision, err1 = strconv.ParseInt(rev, 10, 64)
+	c.ID, err2 = strconv.ParseInt(id, 10, 64)
+	return c, err1 == nil && err2 == nil
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "malformed ID")
+		return 0, false
+	}
+	return id, true
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed JSON body")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeServerError(w http.ResponseWriter, err error) {
+	logger.Error("request failed", "error", err)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
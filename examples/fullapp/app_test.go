@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mohar9h/goauth"
+	"github.com/mohar9h/goauth/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer starts the app against the services named by
+// FULLAPP_DATABASE_DSN and FULLAPP_REDIS_ADDR, or a temporary SQLite
+// database
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	s := settingsFromEnv()
+	if os.Getenv("FULLAPP_DATABASE_DSN") == "" {
+		s.DatabaseDSN = filepath.Join(t.TempDir(), "fullapp.db")
+	}
+
+	// Tests fail validations on purpose; don't let reruns trip the limiter
+	client, err := openClient(s, goauth.WithRateLimiter(ratelimit.NewMemory(1000, time.Millisecond)))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	users, err := demoUsers()
+	require.NoError(t, err)
+	srv := httptest.NewServer(newApp(client, users).routes())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+type apiClient struct {
+	t     *testing.T
+	srv   *httptest.Server
+	agent string // User agent, which names sessions; default "fullapp-test"
+}
+
+// do sends body as JSON with token as the bearer, decodes the response
+// into out when given and returns the status
+func (c apiClient) do(method, path, token string, body, out any) int {
+	c.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(c.t, json.NewEncoder(&buf).Encode(body))
+	}
+	req, err := http.NewRequest(method, c.srv.URL+path, &buf)
+	require.NoError(c.t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fullapp-test")
+	if c.agent != "" {
+		req.Header.Set("User-Agent", c.agent)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.srv.Client().Do(req)
+	require.NoError(c.t, err)
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		require.NoError(c.t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+func (c apiClient) login(email, password string) sessionResponse {
+	c.t.Helper()
+	var resp sessionResponse
+	require.Equal(c.t, http.StatusOK, c.do("POST", "/login", "", loginRequest{Email: email, Password: password}, &resp))
+	return resp
+}
+
+func TestFullApp(t *testing.T) {
+	api := apiClient{t: t, srv: newTestServer(t)}
+
+	t.Run("login", func(t *testing.T) {
+		api := apiClient{t: t, srv: api.srv}
+		assert.Equal(t, http.StatusUnauthorized, api.do("POST", "/login", "", loginRequest{"alice@example.com", "wrong"}, nil))
+		assert.Equal(t, http.StatusUnauthorized, api.do("POST", "/login", "", loginRequest{"nobody@example.com", "wrong"}, nil))
+
+		session := api.login("alice@example.com", "correct horse battery staple")
+		require.NotEmpty(t, session.Token)
+		assert.False(t, session.MFARequired)
+		assert.Equal(t, http.StatusOK, api.do("GET", "/notes", session.Token, nil, nil))
+		assert.Equal(t, http.StatusUnauthorized, api.do("GET", "/notes", "", nil, nil))
+	})
+
+	t.Run("mfa", func(t *testing.T) {
+		api := apiClient{t: t, srv: api.srv}
+		first := api.login("bob@example.com", "hunter2hunter2")
+		require.True(t, first.MFARequired)
+		require.Empty(t, first.Token)
+
+		code, err := totpCode("JBSWY3DPEHPK3PXP", time.Now())
+		require.NoError(t, err)
+		wrong := fmt.Sprintf("%06d", (mustAtoi(t, code)+1)%1000000)
+		assert.Equal(t, http.StatusUnauthorized, api.do("POST", "/login/mfa", "", mfaRequest{first.Challenge, wrong}, nil))
+		assert.Equal(t, http.StatusUnauthorized, api.do("POST", "/login/mfa", "", mfaRequest{first.Challenge, code}, nil),
+			"a challenge allows one guess")
+
+		second := api.login("bob@example.com", "hunter2hunter2")
+		assert.Equal(t, http.StatusForbidden, api.do("GET", "/notes", second.Challenge, nil, nil))
+		var session sessionResponse
+		require.Equal(t, http.StatusOK, api.do("POST", "/login/mfa", "", mfaRequest{second.Challenge, code}, &session))
+		assert.Equal(t, http.StatusOK, api.do("GET", "/notes", session.Token, nil, nil))
+		assert.Equal(t, http.StatusForbidden, api.do("POST", "/admin/prune", session.Token, nil, nil))
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		api := apiClient{t: t, srv: api.srv}
+		session := api.login("alice@example.com", "correct horse battery staple")
+		other := apiClient{t: t, srv: api.srv, agent: "other-device"}.login("alice@example.com", "correct horse battery staple")
+
+		var devices []goauth.Device
+		require.Equal(t, http.StatusOK, api.do("GET", "/sessions", session.Token, nil, &devices))
+		var otherID int64
+		for _, d := range devices {
+			if d.Name == "other-device" {
+				otherID = max(otherID, d.ID)
+			}
+		}
+		require.NotZero(t, otherID)
+
+		// End the other session from this one
+		assert.Equal(t, http.StatusOK, api.do("GET", "/notes", other.Token, nil, nil))
+		require.Equal(t, http.StatusNoContent, api.do("DELETE", "/sessions/"+strconv.FormatInt(otherID, 10), session.Token, nil, nil))
+		assert.Equal(t, http.StatusUnauthorized, api.do("GET", "/notes", other.Token, nil, nil))
+		assert.Equal(t, http.StatusNotFound, api.do("DELETE", "/sessions/"+strconv.FormatInt(otherID, 10), session.Token, nil, nil))
+
+		assert.Equal(t, http.StatusNoContent, api.do("POST", "/logout", session.Token, nil, nil))
+		assert.Equal(t, http.StatusUnauthorized, api.do("GET", "/notes", session.Token, nil, nil))
+	})
+
+	t.Run("personal access tokens", func(t *testing.T) {
+		api := apiClient{t: t, srv: api.srv}
+		session := api.login("alice@example.com", "correct horse battery staple")
+
+		assert.Equal(t, http.StatusBadRequest, api.do("POST", "/tokens", session.Token, createTokenRequest{Name: "ci", Abilities: []string{"admin"}}, nil))
+		var created createTokenResponse
+		require.Equal(t, http.StatusCreated, api.do("POST", "/tokens", session.Token,
+			createTokenRequest{Name: "ci", Abilities: []string{abilityRead}, ExpiresInDays: 7}, &created))
+		require.NotNil(t, created.ExpiresAt)
+
+		pat := created.Token
+		assert.Equal(t, http.StatusOK, api.do("GET", "/notes", pat, nil, nil))
+		assert.Equal(t, http.StatusForbidden, api.do("GET", "/tokens", pat, nil, nil), "tokens can't manage tokens")
+
+		var rotated createTokenResponse
+		require.Equal(t, http.StatusOK, api.do("POST", "/tokens/rotate", pat, nil, &rotated))
+		assert.Equal(t, http.StatusOK, api.do("GET", "/notes", rotated.Token, nil, nil))
+		assert.Equal(t, http.StatusConflict, api.do("POST", "/tokens/rotate", pat, nil, nil))
+
+		var tokens []tokenView
+		require.Equal(t, http.StatusOK, api.do("GET", "/tokens", session.Token, nil, &tokens))
+		var id int64
+		for _, tok := range tokens {
+			if tok.Name == "ci" && slices.Equal(tok.Abilities, []string{abilityRead}) {
+				id = max(id, tok.ID)
+			}
+		}
+		require.NotZero(t, id)
+
+		var feed revocationsResponse
+		require.Equal(t, http.StatusOK, api.do("GET", "/admin/revocations", session.Token, nil, &feed))
+		for feed.Cursor != "" {
+			var next revocationsResponse
+			require.Equal(t, http.StatusOK, api.do("GET", "/admin/revocations?cursor="+feed.Cursor, session.Token, nil, &next))
+			if next.Cursor == feed.Cursor {
+				break
+			}
+			feed = next
+		}
+
+		require.Equal(t, http.StatusNoContent, api.do("DELETE", "/tokens/"+strconv.FormatInt(id, 10), session.Token, nil, nil))
+		assert.Equal(t, http.StatusUnauthorized, api.do("GET", "/notes", rotated.Token, nil, nil))
+		assert.Equal(t, http.StatusNotFound, api.do("DELETE", "/tokens/999999999", session.Token, nil, nil))
+
+		var tail revocationsResponse
+		require.Equal(t, http.StatusOK, api.do("GET", "/admin/revocations?cursor="+feed.Cursor, session.Token, nil, &tail))
+		assert.Contains(t, tail.Revoked, id, "the revocation feed reports the revoked token")
+
+		var pruned pruneResponse
+		assert.Equal(t, http.StatusOK, api.do("POST", "/admin/prune", session.Token, nil, &pruned))
+	})
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to 6 digits
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		code, err := totpCode(secret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, want, code, "t=%d", unix)
+	}
+
+	now := time.Unix(1234567890, 0)
+	assert.True(t, verifyTOTP(secret, "005924", now))
+	assert.True(t, verifyTOTP(secret, "005924", now.Add(totpStep)), "one step of drift is allowed")
+	assert.False(t, verifyTOTP(secret, "005924", now.Add(3*totpStep)))
+	assert.False(t, verifyTOTP(secret, "5924", now))
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	n, err := strconv.Atoi(s)
+	require.NoError(t, err)
+	return n
+}
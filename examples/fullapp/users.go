@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mohar9h/goauth/phc"
+)
+
+// user is an account of the example app. A real application keeps these in
+// its own database; goauth only stores tokens.
+type user struct {
+	ID           int64
+	Email        string
+	PasswordHash string // PHC string, see the phc package
+	TOTPSecret   string // Base32; empty when MFA is off
+	Admin        bool
+}
+
+// passwordHasher hashes passwords. Unlike token secrets, passwords are
+// guessable, so the iteration count is high.
+var passwordHasher = phc.PBKDF2(600000)
+
+type userStore struct {
+	mu      sync.RWMutex
+	byID    map[int64]*user
+	byEmail map[string]*user
+}
+
+func newUserStore() *userStore {
+	return &userStore{byID: map[int64]*user{}, byEmail: map[string]*user{}}
+}
+
+// add registers a user with a password and, when totpSecret isn't empty,
+// MFA
+func (s *userStore) add(id int64, email, password, totpSecret string, admin bool) error {
+	hash, err := passwordHasher.Hash(password)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	u := &user{ID: id, Email: strings.ToLower(email), PasswordHash: hash, TOTPSecret: totpSecret, Admin: admin}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byID[u.ID] = u
+	s.byEmail[u.Email] = u
+	return nil
+}
+
+func (s *userStore) get(id int64) *user {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.byID[id]
+}
+
+// authenticate returns the user with email and password, or nil
+func (s *userStore) authenticate(email, password string) *user {
+	s.mu.RLock()
+	u := s.byEmail[strings.ToLower(email)]
+	s.mu.RUnlock()
+	if u == nil {
+		// Spend the same time as a wrong password so emails can't be probed
+		_, _ = phc.Verify(password, dummyHash)
+		return nil
+	}
+	if ok, err := phc.Verify(password, u.PasswordHash); err != nil || !ok {
+		return nil
+	}
+	return u
+}
+
+var dummyHash, _ = passwordHasher.Hash("dummy")
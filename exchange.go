@@ -0,0 +1,125 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrExchangeDenied is returned when ExchangeToken is asked for abilities
+// or a lifetime beyond the subject token's
+var ErrExchangeDenied = utils.ErrExchangeDenied
+
+// ExchangeOptions narrow the token minted by ExchangeToken
+type ExchangeOptions struct {
+	Name      *string
+	Abilities []string          // Each must be granted by the subject token; empty keeps its abilities
+	ExpiresIn time.Duration     // Lifetime; default the client's, capped at the subject token's expiry
+	Metadata  map[string]string // Added to the subject token's metadata, which the new token inherits
+}
+
+// ExchangeToken mints a down-scoped token from subjectToken, e.g. for
+// handing a backend job only the access it needs (RFC 8693 style
+// delegation). The new token belongs to the same user, may only hold
+// abilities the subject grants, and never outlives it: asking for more
+// returns ErrExchangeDenied. It records the subject's ID as its parent and
+// stops validating once any token up its chain is revoked or expires.
+func (c *Client) ExchangeToken(ctx context.Context, subjectToken string, opts *ExchangeOptions) (*TokenResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opts == nil {
+		opts = &ExchangeOptions{}
+	}
+
+	subject, err := c.ValidateToken(ctx, subjectToken)
+	if err != nil {
+		return nil, err
+	}
+	if subject.IsGuest() {
+		return nil, fmt.Errorf("%w: guest tokens cannot be exchanged", ErrExchangeDenied)
+	}
+
+	abilities := opts.Abilities
+	if len(abilities) == 0 {
+		abilities = entity.SplitAbilities(subject.Abilities)
+	}
+	for _, a := range abilities {
+		pattern, _ := entity.SplitCondition(a)
+		if !c.TokenCan(subject, pattern) {
+			return nil, fmt.Errorf("%w: ability %q", ErrExchangeDenied, a)
+		}
+	}
+
+	expiresAt, err := c.exchangeExpiry(subject, opts.ExpiresIn)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]string, len(subject.Metadata)+len(opts.Metadata))
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	// The subject's metadata wins so bindings such as BoundIP carry over
+	for k, v := range subject.Metadata {
+		metadata[k] = v
+	}
+
+	tokOpts := &TokenOptions{
+		UserId:    subject.UserId,
+		Name:      opts.Name,
+		Abilities: abilities,
+		Metadata:  metadata,
+		Extra:     subject.Extra,
+		ExpiresAt: expiresAt,
+		ParentID:  subject.ID,
+	}
+	if expiresAt == nil {
+		tokOpts.ExpiresIn = NoExpiry
+	}
+
+	return traced(c, ctx, "ExchangeToken", func(cfg *config.Config) (*TokenResult, error) {
+		if depth, err := auth.CheckChain(cfg, subject); err != nil {
+			return nil, err
+		} else if depth+1 >= auth.MaxChainDepth {
+			return nil, fmt.Errorf("%w: exchange chain too deep", ErrExchangeDenied)
+		}
+		return auth.Issue(c.authOptions(tokOpts, cfg))
+	}, attribute.Int64("goauth.user_id", subject.UserId), attribute.Int64("goauth.parent_id", subject.ID))
+}
+
+// exchangeExpiry returns when an exchanged token expires, nil for never. An
+// explicit lifetime past the subject's expiry is refused; the client's
+// default lifetime is cut to it.
+func (c *Client) exchangeExpiry(subject *entity.PersonalAccessToken, ttl time.Duration) (*time.Time, error) {
+	limit := subject.ExpiresAt
+	switch {
+	case ttl == NoExpiry:
+		if limit != nil {
+			return nil, fmt.Errorf("%w: the subject token expires", ErrExchangeDenied)
+		}
+		return nil, nil
+	case ttl < 0:
+		return nil, fmt.Errorf("expiry duration cannot be negative")
+	case ttl > 0:
+		at := c.config.Now().Add(ttl)
+		if limit != nil && at.After(*limit) {
+			return nil, fmt.Errorf("%w: lifetime exceeds the subject token's", ErrExchangeDenied)
+		}
+		return &at, nil
+	}
+
+	if c.config.ExpireAt > 0 {
+		at := c.config.Now().Add(c.config.ExpireAt)
+		if limit == nil || at.Before(*limit) {
+			return &at, nil
+		}
+	}
+	return limit, nil
+}
@@ -0,0 +1,92 @@
+package goauth
+
+import (
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// MetaExperiment is the metadata key tagging tokens issued under an experiment
+const MetaExperiment = "goauth.experiment"
+
+type experiment struct {
+	name    string
+	percent float64
+	opts    []Option
+	config  *config.Config
+}
+
+// WithExperiment routes percent (0-100] of token issuance to a variant
+// configuration built by applying opts on top of the client's own, e.g.
+// WithTokenLength(64). Tokens issued by the variant are tagged with the
+// experiment name in their metadata (see ExperimentOf) so its impact can be
+// measured. Only issuance settings should differ; validation always uses
+// the primary configuration.
+func WithExperiment(name string, percent float64, opts ...Option) Option {
+	return func(c *Client) error {
+		if name == "" {
+			return fmt.Errorf("experiment name cannot be empty")
+		}
+		if percent <= 0 || percent > 100 {
+			return fmt.Errorf("experiment percentage must be in (0, 100]")
+		}
+
+		total := percent
+		for _, e := range c.experiments {
+			if e.name == name {
+				return fmt.Errorf("duplicate experiment %q", name)
+			}
+			total += e.percent
+		}
+		if total > 100 {
+			return fmt.Errorf("experiment percentages exceed 100")
+		}
+
+		c.experiments = append(c.experiments, &experiment{name: name, percent: percent, opts: opts})
+		return nil
+	}
+}
+
+// ExperimentOf returns the experiment a token was issued under, or "" for
+// the control configuration
+func ExperimentOf(tok *entity.PersonalAccessToken) string {
+	return tok.Metadata[MetaExperiment]
+}
+
+// buildExperiments derives each variant's config from the final client
+// config. Called once by NewClient after all options are applied.
+func (c *Client) buildExperiments() error {
+	for _, e := range c.experiments {
+		cfg := *c.config
+		variant := &Client{config: &cfg, storage: c.storage}
+		for _, opt := range e.opts {
+			if err := opt(variant); err != nil {
+				return fmt.Errorf("experiment %q: %w", e.name, err)
+			}
+		}
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("experiment %q: %w", e.name, err)
+		}
+		cfg.Storage = c.storage
+		e.config = &cfg
+	}
+	return nil
+}
+
+// pickExperiment rolls the dice for one issuance; nil means control
+func (c *Client) pickExperiment() *experiment {
+	if len(c.experiments) == 0 {
+		return nil
+	}
+
+	roll := rand.Float64() * 100
+	for _, e := range c.experiments {
+		if roll < e.percent {
+			return e
+		}
+		roll -= e.percent
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package goauth
+
+import (
+	"fmt"
+
+	"github.com/mohar9h/goauth/internal/storage"
+)
+
+// EntityExtender stores application fields, carried in
+// PersonalAccessToken.Extra, as extra columns of the token table
+type EntityExtender = storage.EntityExtender
+
+// ExtraColumn is an application defined column on the token table
+type ExtraColumn = storage.ExtraColumn
+
+// ExtraColumns returns an EntityExtender that stores Extra[name] as is in
+// each column; implement EntityExtender to convert or validate values.
+func ExtraColumns(cols ...ExtraColumn) EntityExtender {
+	return storage.ExtraColumns(cols...)
+}
+
+// WithEntityExtender adds application columns, such as a cost center or
+// project, to stored tokens without forking the entity. Set the values
+// with TokenOptions.Extra and read them from PersonalAccessToken.Extra.
+// Missing columns are added to the table at startup. The GORM drivers
+// store them in the token's row; the memory driver keeps Extra as given.
+func WithEntityExtender(e EntityExtender) Option {
+	return func(c *Client) error {
+		if e == nil {
+			return fmt.Errorf("entity extender cannot be nil")
+		}
+		c.config.Extender = e
+		return nil
+	}
+}
+
+// extendStorage hands the extender to the storage driver, and to every
+// region's
+func (c *Client) extendStorage() error {
+	if c.config.Extender == nil {
+		return nil
+	}
+	drivers := []storage.Driver{c.storage}
+	if c.config.Residency != nil {
+		for _, d := range c.config.Residency.Regions {
+			drivers = append(drivers, d)
+		}
+	}
+	for _, d := range drivers {
+		if err := storage.Extend(d, c.config.Extender); err != nil {
+			return fmt.Errorf("goauth: entity extender: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/auth"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ImportOptions describes an externally generated credential for
+// ImportExternalToken
+type ImportOptions = auth.ImportOptions
+
+// WithImportPolicy sets the minimum length and estimated entropy (bits) a
+// plaintext secret must have to be accepted by ImportExternalToken.
+// Defaults are 16 characters and 64 bits.
+func WithImportPolicy(minLength int, minEntropyBits float64) Option {
+	return func(c *Client) error {
+		if minLength < 1 || minEntropyBits < 0 {
+			return fmt.Errorf("import policy needs a positive length and non-negative entropy")
+		}
+		c.config.ImportMinLength = minLength
+		c.config.ImportMinEntropy = minEntropyBits
+		return nil
+	}
+}
+
+// ImportExternalToken registers a pre-generated credential (a printed
+// license key, a secret embedded in hardware) as an access token. Plaintext
+// secrets must meet the import policy and the full "locator|secret" token
+// is returned. With opts.Hashed the credential is the hex SHA-256 digest of
+// the secret, which goauth never sees; only the "locator|" prefix is
+// returned.
+func (c *Client) ImportExternalToken(ctx context.Context, plaintextOrHash string, opts *ImportOptions) (string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if opts == nil {
+		return "", fmt.Errorf("import options cannot be nil")
+	}
+
+	if opts.UserId <= 0 {
+		return "", fmt.Errorf("user ID must be positive")
+	}
+
+	if plaintextOrHash == "" {
+		return "", fmt.Errorf("token cannot be empty")
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	default:
+	}
+
+	return traced(c, ctx, "ImportExternalToken", func(cfg *config.Config) (string, error) {
+		authOpts := *opts
+		authOpts.Config = cfg
+		authOpts.DB = nil
+
+		res, err := auth.ImportToken(plaintextOrHash, &authOpts)
+		if err != nil {
+			return "", err
+		}
+		return res.PlainText, nil
+	}, attribute.Int64("goauth.user_id", opts.UserId))
+}
@@ -19,18 +19,30 @@ package goauth
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"os"
+	"io"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mohar9h/goauth/config"
 	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/cache"
 	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/locator"
 	"github.com/mohar9h/goauth/internal/storage"
 	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/internal/worker"
+	"github.com/mohar9h/goauth/phc"
+	"github.com/mohar9h/goauth/ratelimit"
+	"github.com/mohar9h/goauth/siem"
+	"github.com/mohar9h/goauth/tokenformat"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
@@ -38,6 +50,43 @@ import (
 type Client struct {
 	config  *config.Config
 	storage storage.Driver
+	shadow  *shadowValidator
+
+	tracer        trace.Tracer
+	tracedStorage *storage.TracingDriver
+
+	experiments []*experiment
+	tokenSet    tokenSetOptions
+	limiter     ratelimit.Limiter
+	noClientIP  sync.Once // Warns once about attempts without a client IP
+	licenseKey  ed25519.PublicKey
+	apiKeys     *apiKeyOptions
+	passwords   *passwordOptions
+	mfa         *mfaOptions
+
+	inheritAbilities bool
+	policy           *policyOptions
+	roles            *rolesOptions
+	decisions        *cache.LRU[decisionKey, bool]
+	tokenCache       *tokenCacheOptions
+	replication      *replicationOptions
+	archive          *archiveOptions
+	maintain         *maintainOptions
+	maintainMu       sync.Mutex
+	budget           time.Duration
+	fallback         *cache.LRU[string, *entity.PersonalAccessToken]
+	workloadKeySets  workloadKeySets
+	catalog          catalog
+	meter            *meter
+	changeSettle     time.Duration
+	events           *auditLog
+	responses        *responseOptions
+	canary           *canary
+	revocations      *revocationList
+	revocationMu     sync.Mutex           // Serializes revocation list syncs
+	fieldCipher      *storage.FieldCipher // Set by WithFieldEncryption
+	webhooks         *webhookOptions
+	jwt              *jwtSigner // Set by WithJWTSigningKey
 }
 
 // Option is a functional option for configuring the client
@@ -54,17 +103,131 @@ func WithSigningKey(key string) Option {
 	}
 }
 
-// WithTokenLength sets the length of generated tokens
+// WithTokenLength sets how many random bytes generated tokens carry. The
+// secret is hex encoded, so it is twice as many characters long (plus a
+// checksum of up to 8 characters). Prefer WithTokenEntropyBits, which says
+// what it means.
 func WithTokenLength(length int) Option {
 	return func(c *Client) error {
 		if length < 16 {
-			return fmt.Errorf("token length must be at least 16 characters")
+			return fmt.Errorf("token length must be at least 16 bytes")
 		}
 		c.config.TokenLength = length
 		return nil
 	}
 }
 
+// WithTokenEntropyBits sets the entropy of generated tokens, rounded up to
+// a whole byte. At least 128 bits are required.
+func WithTokenEntropyBits(bits int) Option {
+	return func(c *Client) error {
+		if bits < 128 {
+			return fmt.Errorf("token entropy must be at least 128 bits")
+		}
+		c.config.TokenLength = (bits + 7) / 8
+		return nil
+	}
+}
+
+// TokenEntropy returns the bits of randomness in the tokens this client
+// generates, for security reviews that would otherwise have to infer it
+// from the token's length. Tokens issued by experiment variants may differ.
+func (c *Client) TokenEntropy() int {
+	return c.config.TokenEntropyBits()
+}
+
+// Now returns the time on the client's clock (see WithClock), for code
+// building on the client that must agree with it on expiry
+func (c *Client) Now() time.Time {
+	return c.config.Now()
+}
+
+// TokenFormat returns the wire format of the secrets this client
+// generates, for handing to scanners and gateways
+func (c *Client) TokenFormat() tokenformat.Format {
+	return c.config.TokenFormat()
+}
+
+// WithTokenPrefix starts every generated secret with prefix, e.g. "acme_pat_",
+// so secret scanners can recognise leaked tokens (see package tokenformat)
+func WithTokenPrefix(prefix string) Option {
+	return func(c *Client) error {
+		for i := 0; i < len(prefix); i++ {
+			if prefix[i] == '|' || prefix[i] < 0x21 || prefix[i] > 0x7e {
+				return fmt.Errorf("token prefix must be printable ASCII without spaces or '|'")
+			}
+		}
+		c.config.TokenPrefix = prefix
+		return nil
+	}
+}
+
+// WithTokenChecksum selects the checksum appended to generated secrets and
+// how many hex characters of it to keep (0 = all). ChecksumHMAC needs a key,
+// so only holders of it can tell real tokens apart. Existing tokens stay
+// valid after a change, since the checksum is part of the hashed secret.
+func WithTokenChecksum(alg ChecksumAlgorithm, length int, key ...[]byte) Option {
+	return func(c *Client) error {
+		switch alg {
+		case ChecksumCRC32C, ChecksumXXHash, ChecksumHMAC:
+		default:
+			return fmt.Errorf("unknown checksum algorithm %d", alg)
+		}
+		c.config.Checksum = alg
+		c.config.ChecksumLength = length
+		c.config.ChecksumKey = nil
+		if len(key) > 0 {
+			c.config.ChecksumKey = key[0]
+		}
+		return nil
+	}
+}
+
+// WithTokenHasher stores token hashes as hash strings from h, e.g.
+// phc.SHA256 ("$sha256$...") or phc.PBKDF2(n), so each row names its
+// hasher and parameters. Rows written before, or with other parameters,
+// stay valid and are rehashed the next time they are used. Salted hashers
+// need the default ID locator.
+func WithTokenHasher(h phc.Hasher) Option {
+	return func(c *Client) error {
+		if h == nil {
+			return fmt.Errorf("token hasher cannot be nil")
+		}
+		c.config.Hasher = h
+		return nil
+	}
+}
+
+// WithHashPepper stores token hashes as HMAC-SHA256 keyed with pepper, a
+// server-side secret of at least 16 bytes kept out of the database, so a
+// leaked token table alone can't be used to check candidate secrets. Rows
+// hashed without it, or with one of the previous peppers, stay valid and
+// are rehashed with pepper the next time they are used. It replaces
+// WithTokenHasher; the last of the two wins.
+func WithHashPepper(pepper []byte, previous ...[]byte) Option {
+	return func(c *Client) error {
+		h, err := phc.Pepper(pepper, previous...)
+		if err != nil {
+			return fmt.Errorf("hash pepper: %w", err)
+		}
+		c.config.Hasher = h
+		return nil
+	}
+}
+
+// WithLegacyHashes sets whether rows hashed before the configured hasher,
+// such as the compact SHA-256 rows written before WithHashPepper, still
+// validate and are rehashed on use. They do by default so existing tokens
+// migrate lazily. Pass false once the migration window is over: a leaked
+// table's unpeppered hashes then no longer validate. Rows written with a
+// previous pepper are still accepted.
+func WithLegacyHashes(accept bool) Option {
+	return func(c *Client) error {
+		c.config.NoLegacyHashes = !accept
+		return nil
+	}
+}
+
 // WithTokenExpiration sets the token expiration duration
 func WithTokenExpiration(duration time.Duration) Option {
 	return func(c *Client) error {
@@ -73,6 +236,184 @@ func WithTokenExpiration(duration time.Duration) Option {
 	}
 }
 
+// WithLocator sets the strategy for the public segment before "|" in
+// plaintext tokens (default: the numeric storage ID)
+func WithLocator(l Locator) Option {
+	return func(c *Client) error {
+		if l == nil {
+			return fmt.Errorf("locator cannot be nil")
+		}
+		c.config.Locator = l
+		return nil
+	}
+}
+
+// WithClock makes the client and its storage drivers read the time from
+// clock instead of time.Now: token expiry, revocation and last-use times,
+// pruning cutoffs and audit events all follow it. Tests use it to move past
+// a token's expiry without sleeping. Background workers still tick on
+// real time.
+func WithClock(clock Clock) Option {
+	return func(c *Client) error {
+		if clock == nil {
+			return fmt.Errorf("clock cannot be nil")
+		}
+		c.config.Clock = clock
+		return nil
+	}
+}
+
+// WithRandReader sets the entropy source for token secrets and refresh
+// family IDs, instead of crypto/rand. FIPS deployments point it at their
+// validated DRBG; tests use a fixed stream for reproducible tokens. A read
+// error fails the issuance with that error.
+func WithRandReader(r io.Reader) Option {
+	return func(c *Client) error {
+		if r == nil {
+			return fmt.Errorf("rand reader cannot be nil")
+		}
+		c.config.Rand = r
+		return nil
+	}
+}
+
+// WithLogger sets the structured logger (e.g. *slog.Logger) used for
+// background worker failures and diagnostics
+func WithLogger(logger Logger) Option {
+	return func(c *Client) error {
+		if logger == nil {
+			return fmt.Errorf("logger cannot be nil")
+		}
+		c.config.Logger = logger
+		return nil
+	}
+}
+
+// WithDefaultAbilityPolicy sets how tokens with NULL/empty abilities are
+// treated. defaults is required with AbilityPolicyDefaultSet.
+func WithDefaultAbilityPolicy(policy AbilityPolicy, defaults ...string) Option {
+	return func(c *Client) error {
+		if policy == AbilityPolicyDefaultSet && len(defaults) == 0 {
+			return fmt.Errorf("default ability policy requires at least one ability")
+		}
+		c.config.AbilityPolicy = policy
+		c.config.DefaultAbilities = defaults
+		return nil
+	}
+}
+
+// WithAbilityMatcher replaces how granted abilities are matched against
+// requested ones by TokenCan, ValidateTokenWithAbility and Authorize. The
+// default is GlobMatcher. A TreeMatcher without a Delimiter uses the
+// client's ability delimiter.
+func WithAbilityMatcher(m AbilityMatcher) Option {
+	return func(c *Client) error {
+		if m == nil {
+			return fmt.Errorf("ability matcher cannot be nil")
+		}
+		if tree, ok := m.(*TreeMatcher); ok && tree.Delimiter == "" {
+			cp := *tree
+			cp.Delimiter = c.config.AbilityDelimiter
+			m = &cp
+		}
+		c.config.AbilityMatcher = m
+		return nil
+	}
+}
+
+// WithSlidingExpiration renews a token's expiry to now+idle on each
+// successful validation, so unused tokens lapse after the idle window. The
+// total lifetime is capped by WithMaxTokenLifetime (default 30 days).
+func WithSlidingExpiration(idle time.Duration) Option {
+	return func(c *Client) error {
+		if idle <= 0 {
+			return fmt.Errorf("sliding idle window must be positive")
+		}
+		c.config.SlidingIdle = idle
+		return nil
+	}
+}
+
+// WithMaxTokenLifetime sets the absolute lifetime cap for sliding expiration
+func WithMaxTokenLifetime(max time.Duration) Option {
+	return func(c *Client) error {
+		if max <= 0 {
+			return fmt.Errorf("max token lifetime must be positive")
+		}
+		c.config.MaxLifetime = max
+		return nil
+	}
+}
+
+// WithRefreshTokenExpiration sets the refresh token lifetime used by
+// CreateTokenPair and RefreshToken
+func WithRefreshTokenExpiration(duration time.Duration) Option {
+	return func(c *Client) error {
+		if duration < 0 {
+			return fmt.Errorf("refresh token expiration cannot be negative")
+		}
+		c.config.RefreshExpireAt = duration
+		return nil
+	}
+}
+
+// WithRotationGracePeriod keeps tokens replaced by RotateToken valid for
+// grace after rotation, so clients holding the old token can switch over
+func WithRotationGracePeriod(grace time.Duration) Option {
+	return func(c *Client) error {
+		if grace < 0 {
+			return fmt.Errorf("rotation grace period cannot be negative")
+		}
+		c.config.RotationGrace = grace
+		return nil
+	}
+}
+
+// WithSoftRevocation makes RevokeToken and RevokeUserTokens set RevokedAt
+// instead of deleting tokens, keeping them for audits until PruneRevoked
+func WithSoftRevocation() Option {
+	return func(c *Client) error {
+		c.config.SoftRevocation = true
+		return nil
+	}
+}
+
+// WithMaxTokensPerUser limits how many live access tokens a user may hold.
+// Creating one more fails with ErrTokenLimitReached, or evicts the least
+// recently used token under TokenLimitEvictLRU (see WithTokenLimitPolicy).
+// Requires a driver that can count tokens per user.
+func WithMaxTokensPerUser(n int) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return fmt.Errorf("token limit must be positive")
+		}
+		c.config.MaxTokensPerUser = n
+		return nil
+	}
+}
+
+// WithTokenLimitPolicy sets what happens when a user reaches the limit set
+// by WithMaxTokensPerUser
+func WithTokenLimitPolicy(p TokenLimitPolicy) Option {
+	return func(c *Client) error {
+		if p != TokenLimitReject && p != TokenLimitEvictLRU {
+			return fmt.Errorf("unknown token limit policy %d", p)
+		}
+		c.config.TokenLimitPolicy = p
+		return nil
+	}
+}
+
+// WithSanctumCompatibility accepts Laravel Sanctum tokens during a migration:
+// bare tokens without an "id|" segment and JSON-encoded abilities. Records
+// are rewritten to the goauth format on their first successful validation.
+func WithSanctumCompatibility() Option {
+	return func(c *Client) error {
+		c.config.SanctumCompat = true
+		return nil
+	}
+}
+
 // WithStorage sets the storage driver
 func WithStorage(driver storage.Driver) Option {
 	return func(c *Client) error {
@@ -95,6 +436,40 @@ func WithGormStorage(db *gorm.DB) Option {
 	}
 }
 
+// NewGormStorage returns a GORM storage driver, e.g. for a Replica
+func NewGormStorage(db *gorm.DB) storage.Driver {
+	return storage.NewGormDriver(db)
+}
+
+// WithSQLiteStorage sets up a GORM storage tuned for SQLite: WAL mode,
+// a busy timeout and a single-writer queue. Open db with SQLiteDSN so the
+// settings apply to every pooled connection.
+func WithSQLiteStorage(db *gorm.DB, opts SQLiteOptions) Option {
+	return func(c *Client) error {
+		if db == nil {
+			return fmt.Errorf("database connection cannot be nil")
+		}
+		driver, err := storage.NewSQLiteDriver(db, opts)
+		if err != nil {
+			return err
+		}
+		c.storage = driver
+		return nil
+	}
+}
+
+// NewSQLiteStorage returns a GORM storage driver tuned for SQLite, as
+// WithSQLiteStorage sets up
+func NewSQLiteStorage(db *gorm.DB, opts SQLiteOptions) (storage.Driver, error) {
+	return storage.NewSQLiteDriver(db, opts)
+}
+
+// SQLiteDSN builds a SQLite DSN with WAL mode and the busy timeout applied
+// per connection
+func SQLiteDSN(path string, opts SQLiteOptions) string {
+	return storage.SQLiteDSN(path, opts)
+}
+
 // WithMemoryStorage sets up in-memory storage (for testing)
 func WithMemoryStorage() Option {
 	return func(c *Client) error {
@@ -103,21 +478,14 @@ func WithMemoryStorage() Option {
 	}
 }
 
+// NewMemoryStorage returns an in-memory storage driver (for testing)
+func NewMemoryStorage() storage.Driver {
+	return storage.NewMemoryDriver()
+}
+
 // NewClient creates a new authentication client with the given options
 func NewClient(opts ...Option) (*Client, error) {
-	defaultKey := generateSecureKey()
-
-	client := &Client{
-		config: &config.Config{
-			TokenLength:      32,
-			TokenPrefix:      "",
-			ExpireAt:         24 * time.Hour,
-			SigningMethod:    "HS256",
-			SigningKey:       defaultKey,
-			AbilityDelimiter: ":",
-		},
-		storage: nil,
-	}
+	client := &Client{config: config.DefaultConfig()}
 
 	for _, opt := range opts {
 		if err := opt(client); err != nil {
@@ -125,6 +493,10 @@ func NewClient(opts ...Option) (*Client, error) {
 		}
 	}
 
+	if err := client.defaultSigningKey(); err != nil {
+		return nil, err
+	}
+
 	if err := client.config.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
@@ -134,7 +506,56 @@ func NewClient(opts ...Option) (*Client, error) {
 		return nil, fmt.Errorf("goauth: no storage driver configured (call WithGormStorage or WithStorage)")
 	}
 
+	if err := client.verifyIndexes(); err != nil {
+		return nil, err
+	}
+
+	if err := client.extendStorage(); err != nil {
+		return nil, err
+	}
+
+	if err := client.encryptStorage(); err != nil {
+		return nil, err
+	}
+
+	client.wrapArchive()
+	if err := client.wrapReplication(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	client.wrapCache()
+	client.setClock()
+	client.instrumentStorage()
 	client.config.Storage = client.storage
+	client.config.Workers = worker.New(client.config.Logger, 64)
+	client.startReplication()
+
+	if err := client.buildExperiments(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if err := client.startPolicyBundle(); err != nil {
+		return nil, err
+	}
+
+	if err := client.startRoles(); err != nil {
+		return nil, err
+	}
+
+	if err := client.startMetering(); err != nil {
+		return nil, err
+	}
+
+	client.startAudit()
+	client.startWebhooks()
+	client.startAutoPrune()
+	client.startArchiving()
+	client.startMaintenance()
+	client.startLastUsed()
+	client.startTokenSet()
+
+	if err := client.startRevocationList(); err != nil {
+		return nil, err
+	}
 
 	return client, nil
 }
@@ -146,37 +567,162 @@ func (c *Client) Storage() storage.Driver {
 
 // CreateToken generates a new token using the client's configuration
 func (c *Client) CreateToken(ctx context.Context, opts *TokenOptions) (string, error) {
+	result, err := c.IssueToken(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+	return result.PlainText, nil
+}
+
+// IssueToken is CreateToken returning the token's expiry along with its
+// plaintext, e.g. for an OAuth2 expires_in
+func (c *Client) IssueToken(ctx context.Context, opts *TokenOptions) (*TokenResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if opts == nil {
+		return nil, fmt.Errorf("token options cannot be nil")
+	}
+
+	if opts.UserId <= 0 {
+		return nil, fmt.Errorf("user ID must be positive")
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	result, err := traced(c, ctx, "CreateToken", func(cfg *config.Config) (*TokenResult, error) {
+		// Create auth options with client config
+		return auth.Issue(c.authOptions(opts, cfg))
+	}, attribute.Int64("goauth.user_id", opts.UserId))
+	if err == nil {
+		c.auditToken(ctx, siem.TokenCreated, opts.UserId, result.PlainText)
+	}
+	return result, err
+}
+
+// CreateTokens creates a token for each of opts in a single storage round
+// trip where the driver supports it (bulk INSERT for GORM), for provisioning
+// many device tokens at once. Plaintext tokens are returned in the order of
+// opts. Either all tokens are created or none is.
+func (c *Client) CreateTokens(ctx context.Context, opts []*TokenOptions) ([]string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	for i, o := range opts {
+		if o == nil {
+			return nil, fmt.Errorf("token options %d cannot be nil", i)
+		}
+		if o.UserId <= 0 {
+			return nil, fmt.Errorf("token options %d: user ID must be positive", i)
+		}
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	return traced(c, ctx, "CreateTokens", func(cfg *config.Config) ([]string, error) {
+		authOpts := make([]*auth.TokenOptions, len(opts))
+		for i, o := range opts {
+			authOpts[i] = c.authOptions(o, cfg)
+		}
+		results, err := auth.IssueBatch(authOpts)
+		if err != nil {
+			return nil, err
+		}
+		tokens := make([]string, len(results))
+		for i, r := range results {
+			tokens[i] = r.PlainText
+			c.auditToken(ctx, siem.TokenCreated, opts[i].UserId, r.PlainText)
+		}
+		return tokens, nil
+	}, attribute.Int("goauth.batch_size", len(opts)))
+}
+
+// CreateTokenPair issues an access token together with a longer-lived
+// refresh token that can be exchanged via RefreshToken
+func (c *Client) CreateTokenPair(ctx context.Context, opts *TokenOptions) (*TokenPair, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
 
 	if opts == nil {
-		return "", fmt.Errorf("token options cannot be nil")
+		return nil, fmt.Errorf("token options cannot be nil")
 	}
 
 	if opts.UserId <= 0 {
-		return "", fmt.Errorf("user ID must be positive")
+		return nil, fmt.Errorf("user ID must be positive")
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	pair, err := traced(c, ctx, "CreateTokenPair", func(cfg *config.Config) (*TokenPair, error) {
+		return auth.CreateTokenPair(c.authOptions(opts, cfg))
+	}, attribute.Int64("goauth.user_id", opts.UserId))
+	if err == nil {
+		c.auditToken(ctx, siem.TokenCreated, opts.UserId, pair.AccessToken)
 	}
+	return pair, err
+}
 
-	// Create auth options with client config
-	authOpts := &auth.TokenOptions{
-		UserId:    opts.UserId,
-		Name:      opts.Name,
-		Abilities: opts.Abilities,
-		Config:    c.config,
+// RefreshToken exchanges a refresh token for a new token pair and
+// invalidates it. Replaying a rotated refresh token revokes the whole token
+// family and returns ErrRefreshTokenReused.
+func (c *Client) RefreshToken(ctx context.Context, refreshRaw string) (*TokenPair, error) {
+	if ctx == nil {
+		ctx = context.Background()
 	}
 
-	// Use client's storage
-	authOpts.Config.Storage = c.storage
+	if refreshRaw == "" {
+		return nil, fmt.Errorf("refresh token cannot be empty: %w", utils.ErrTokenInvalidFormat)
+	}
 
 	// Check for context cancellation
 	select {
 	case <-ctx.Done():
-		return "", ctx.Err()
+		return nil, ctx.Err()
 	default:
 	}
 
-	return auth.CreateToken(authOpts)
+	pair, err := traced(c, ctx, "RefreshToken", func(cfg *config.Config) (*TokenPair, error) {
+		return auth.RefreshToken(refreshRaw, cfg)
+	})
+	if errors.Is(err, utils.ErrRefreshTokenReused) {
+		c.auditFailure(ctx, refreshRaw, err)
+	}
+	return pair, err
+}
+
+// authOptions copies the caller's options and binds them to cfg
+func (c *Client) authOptions(opts *TokenOptions, cfg *config.Config) *auth.TokenOptions {
+	authOpts := *opts
+	authOpts.Config = cfg
+	authOpts.DB = nil
+
+	if e := c.pickExperiment(); e != nil {
+		authOpts.Config = e.config
+		authOpts.Metadata = make(map[string]string, len(opts.Metadata)+1)
+		for k, v := range opts.Metadata {
+			authOpts.Metadata[k] = v
+		}
+		authOpts.Metadata[MetaExperiment] = e.name
+	}
+	return &authOpts
 }
 
 // ValidateToken checks if the given token is valid and returns token info
@@ -186,7 +732,7 @@ func (c *Client) ValidateToken(ctx context.Context, raw string) (*entity.Persona
 	}
 
 	if raw == "" {
-		return nil, fmt.Errorf("token cannot be empty")
+		return nil, errEmptyToken
 	}
 
 	// Check for context cancellation
@@ -196,7 +742,137 @@ func (c *Client) ValidateToken(ctx context.Context, raw string) (*entity.Persona
 	default:
 	}
 
-	return auth.ValidateToken(raw, c.config)
+	var keys []string
+	var keyBuf [2]string
+	if c.limiter != nil {
+		keys = c.rateLimitKeys(ctx, raw, &keyBuf)
+		if err := c.checkRateLimit(ctx, keys); err != nil {
+			c.auditFailure(ctx, raw, err)
+			return nil, err
+		}
+	}
+
+	var tok *entity.PersonalAccessToken
+	var err error
+	if c.budget > 0 {
+		tok, err = c.validateWithinBudget(ctx, raw)
+	} else {
+		tok, err = c.validate(ctx, raw)
+	}
+	if err != nil && c.limiter != nil {
+		c.recordFailure(ctx, keys, err)
+	}
+	if err != nil {
+		c.auditFailure(ctx, raw, err)
+	}
+	if err == nil && c.responses != nil && len(c.responses.detectors) > 0 {
+		if err = c.inspect(ctx, raw, tok); err != nil {
+			tok = nil
+		}
+	}
+	if err == nil && c.meter != nil {
+		c.meter.count(c.config.Now(), tok.UserId)
+	}
+	if err == nil && c.canary != nil {
+		c.sampleCanary(ctx, tok)
+	}
+	if c.shadow != nil {
+		c.shadow.compare(c, raw, tok, err)
+	}
+	return tok, err
+}
+
+func (c *Client) validate(ctx context.Context, raw string) (*entity.PersonalAccessToken, error) {
+	return traced(c, ctx, "ValidateToken", func(cfg *config.Config) (*entity.PersonalAccessToken, error) {
+		return auth.ValidateToken(raw, cfg)
+	})
+}
+
+// ValidateTokenWithAbility validates the token and checks that it grants the
+// given ability, honoring "*" and prefix wildcards like "read:*" (see
+// WithAbilityMatcher)
+func (c *Client) ValidateTokenWithAbility(ctx context.Context, raw string, ability string) (*entity.PersonalAccessToken, error) {
+	tok, err := c.ValidateToken(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.inheritAbilities {
+		abilities, err := c.EffectiveAbilities(ctx, tok)
+		if err != nil {
+			return nil, err
+		}
+		resolved := *tok
+		resolved.Abilities = strings.Join(abilities, ",")
+		if !resolved.CanWith(c.config.AbilityMatcher, ability) {
+			return nil, c.denyAbility(ctx, raw, tok, ability)
+		}
+		return tok, nil
+	}
+
+	if !c.TokenCan(tok, ability) {
+		return nil, c.denyAbility(ctx, raw, tok, ability)
+	}
+
+	return tok, nil
+}
+
+// denyAbility returns the error for tok lacking ability, auditing it
+func (c *Client) denyAbility(ctx context.Context, raw string, tok *entity.PersonalAccessToken, ability string) error {
+	loc, _, _ := cutToken(raw)
+	c.audit(ctx, siem.Event{
+		Type:    siem.AbilityDenied,
+		Outcome: siem.Failure,
+		Reason:  ability,
+		UserID:  tok.UserId,
+		Locator: loc,
+	})
+	return fmt.Errorf("%w: %s", utils.ErrAbilityDenied, ability)
+}
+
+// TokenCan reports whether the token grants the ability, applying the
+// client's default-ability policy when the token has no stored abilities
+func (c *Client) TokenCan(tok *entity.PersonalAccessToken, ability string) bool {
+	if tok == nil {
+		return false
+	}
+	// Validated tokens already carry their effective abilities; checking
+	// them in place reuses the parsed set cached on tok
+	abilities := c.config.EffectiveAbilities(tok.Abilities)
+	if abilities == tok.Abilities {
+		return tok.CanWith(c.config.AbilityMatcher, ability)
+	}
+	resolved := *tok
+	resolved.Abilities = abilities
+	return resolved.CanWith(c.config.AbilityMatcher, ability)
+}
+
+// MissingAbilities returns the abilities the token doesn't grant, in the
+// order given, counting inherited ones under WithInheritedAbilities. It
+// returns nil when every ability is granted.
+func (c *Client) MissingAbilities(ctx context.Context, tok *entity.PersonalAccessToken, abilities ...string) ([]string, error) {
+	if tok == nil {
+		return abilities, nil
+	}
+
+	check := tok
+	if c.inheritAbilities {
+		effective, err := c.EffectiveAbilities(ctx, tok)
+		if err != nil {
+			return nil, err
+		}
+		resolved := *tok
+		resolved.Abilities = strings.Join(effective, ",")
+		check = &resolved
+	}
+
+	var missing []string
+	for _, a := range abilities {
+		if !c.TokenCan(check, a) {
+			missing = append(missing, a)
+		}
+	}
+	return missing, nil
 }
 
 // RevokeToken removes a token from storage
@@ -206,7 +882,43 @@ func (c *Client) RevokeToken(ctx context.Context, raw string) error {
 	}
 
 	if raw == "" {
-		return fmt.Errorf("token cannot be empty")
+		return errEmptyToken
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	// Forget after revoking too, in case a validation still in flight
+	// cached the token meanwhile
+	c.forget(raw)
+	defer c.forget(raw)
+	var revoked *entity.PersonalAccessToken
+	err := tracedErr(c, ctx, "RevokeToken", func(cfg *config.Config) (err error) {
+		revoked, err = auth.RevokeToken(raw, cfg)
+		return err
+	})
+	if err == nil {
+		c.announceRevocation(ctx, revoked.UserId, revoked.ID)
+		c.auditToken(ctx, siem.TokenRevoked, revoked.UserId, raw)
+	}
+	return err
+}
+
+// RevokeTokenByID revokes one of the user's tokens by its ID, for token
+// management screens that never see plaintexts. It follows
+// WithSoftRevocation and returns ErrTokenNotFound if id isn't one of the
+// user's tokens.
+func (c *Client) RevokeTokenByID(ctx context.Context, userID, id int64) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if userID <= 0 || id <= 0 {
+		return fmt.Errorf("user and token IDs must be positive")
 	}
 
 	// Check for context cancellation
@@ -216,7 +928,62 @@ func (c *Client) RevokeToken(ctx context.Context, raw string) error {
 	default:
 	}
 
-	return auth.RevokeToken(raw, c.config)
+	if c.fallback != nil {
+		c.fallback.Purge()
+		defer c.fallback.Purge()
+	}
+
+	err := tracedErr(c, ctx, "RevokeTokenByID", func(cfg *config.Config) error {
+		cfg, err := cfg.ForUser(userID)
+		if err != nil {
+			return err
+		}
+		tok, err := cfg.Storage.FindByID(id)
+		if err != nil {
+			return err
+		}
+		if tok.UserId != userID {
+			return utils.ErrTokenNotFound
+		}
+		return revokeStored(cfg, tok)
+	}, attribute.Int64("goauth.user_id", userID))
+	if err == nil {
+		c.announceRevocation(ctx, userID, id)
+		c.audit(ctx, siem.Event{Type: siem.TokenRevoked, UserID: userID})
+	}
+	return err
+}
+
+// RotateToken replaces a valid access token with a new one carrying the
+// same user, name, abilities and remaining lifetime, and revokes the old
+// token immediately or after the WithRotationGracePeriod window. The result
+// holds the new plaintext and the old token's ID for audit logs. Rotating a
+// token twice returns ErrTokenRotated.
+func (c *Client) RotateToken(ctx context.Context, raw string) (*TokenRotation, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if raw == "" {
+		return nil, errEmptyToken
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	c.forget(raw)
+	defer c.forget(raw)
+	rotation, err := traced(c, ctx, "RotateToken", func(cfg *config.Config) (*TokenRotation, error) {
+		return auth.RotateToken(raw, cfg.RotationGrace, cfg)
+	})
+	if err == nil {
+		c.auditToken(ctx, siem.TokenRotated, rotation.UserID, raw)
+	}
+	return rotation, err
 }
 
 // GetTokenInfo retrieves token information without validation
@@ -226,7 +993,7 @@ func (c *Client) GetTokenInfo(ctx context.Context, raw string) (*entity.Personal
 	}
 
 	if raw == "" {
-		return nil, fmt.Errorf("token cannot be empty")
+		return nil, errEmptyToken
 	}
 
 	// Check for context cancellation
@@ -237,34 +1004,337 @@ func (c *Client) GetTokenInfo(ctx context.Context, raw string) (*entity.Personal
 	}
 
 	// Extract token hash without validation
-	parts := strings.Split(raw, "|")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid token format")
+	loc, secret, ok := cutToken(raw)
+	if !ok || secret == "" {
+		return nil, utils.ErrTokenInvalidFormat
+	}
+
+	return traced(c, ctx, "GetTokenInfo", func(cfg *config.Config) (*entity.PersonalAccessToken, error) {
+		cfg, err := cfg.ForLocator(loc)
+		if err != nil {
+			return nil, err
+		}
+		return auth.FindBySecret(cfg, loc, secret)
+	})
+}
+
+// ListTokens returns a page of tokens belonging to the user along with the
+// total number of tokens matching the filter. A nil opts lists every token.
+func (c *Client) ListTokens(ctx context.Context, userID int64, opts *ListOptions) ([]*entity.PersonalAccessToken, int64, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if userID <= 0 {
+		return nil, 0, fmt.Errorf("user ID must be positive")
+	}
+
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+
+	if opts.Limit < 0 || opts.Offset < 0 {
+		return nil, 0, fmt.Errorf("limit and offset cannot be negative")
 	}
 
-	hashed := utils.HashToken(parts[1])
-	return c.storage.FindByHash(hashed)
+	if !c.Capabilities().List {
+		return nil, 0, fmt.Errorf("list tokens: %w", utils.ErrNotSupported)
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	default:
+	}
+
+	type page struct {
+		tokens []*entity.PersonalAccessToken
+		total  int64
+	}
+	p, err := traced(c, ctx, "ListTokens", func(cfg *config.Config) (page, error) {
+		cfg, err := cfg.ForUser(userID)
+		if err != nil {
+			return page{}, err
+		}
+		tokens, total, err := cfg.Storage.FindByUser(userID, *opts)
+		return page{tokens, total}, err
+	}, attribute.Int64("goauth.user_id", userID))
+	return p.tokens, p.total, err
 }
 
-// generateSecureKey generates a cryptographically secure signing key
-func generateSecureKey() string {
-	// Try to get from environment first
-	if key := os.Getenv("GOAUTH_SIGNING_KEY"); key != "" {
-		return key
+// IDLocator returns the default locator using the numeric storage ID
+func IDLocator() Locator {
+	return locator.ID{}
+}
+
+// HashPrefixLocator returns a locator using the first n characters of the
+// stored token hash, for drivers without auto-increment IDs
+func HashPrefixLocator(n int) Locator {
+	return locator.HashPrefix{Length: n}
+}
+
+// ULIDLocator returns a locator emitting a random ULID per token
+func ULIDLocator() Locator {
+	return locator.ULID{}
+}
+
+// Errors returns a channel on which background worker failures, including
+// recovered panics, are published. Unread errors are dropped once the
+// buffer fills, so draining it is optional.
+func (c *Client) Errors() <-chan error {
+	return c.config.Workers.Errors()
+}
+
+// SetMaintenanceMode switches maintenance mode on or off. While on, token
+// validation fails with ErrMaintenanceMode unless the token holds one of the
+// allowlisted abilities (e.g. "ops:maintenance"), matched with the client's
+// AbilityMatcher. Revocation keeps working for every token.
+func (c *Client) SetMaintenanceMode(on bool, allow []string) {
+	c.config.Maintenance.Set(on, allow)
+	if on {
+		c.config.Logger.Warn("maintenance mode enabled", "allow", allow)
+	} else {
+		c.config.Logger.Info("maintenance mode disabled")
 	}
+}
+
+// MaintenanceMode reports whether maintenance mode is on and its allowlist
+func (c *Client) MaintenanceMode() (bool, []string) {
+	return c.config.Maintenance.State()
+}
+
+// Capabilities reports which optional features the storage driver supports
+func (c *Client) Capabilities() Capabilities {
+	return storage.CapabilitiesOf(c.storage)
+}
+
+// DriverStats returns internal stats for every driver in the storage chain,
+// outermost decorator first, so operators can spot the bottleneck backend
+func (c *Client) DriverStats() []DriverStats {
+	return storage.CollectStats(c.storage)
+}
 
-	// Generate a secure random key
+// RevokeUserTokens revokes every token belonging to the user and returns the
+// number revoked. Returns ErrNotSupported if the driver lacks bulk revocation.
+func (c *Client) RevokeUserTokens(ctx context.Context, userID int64) (n int64, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if userID <= 0 {
+		return 0, fmt.Errorf("user ID must be positive")
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	if c.fallback != nil {
+		c.fallback.Purge()
+		defer c.fallback.Purge()
+	}
+	defer func() {
+		if err == nil {
+			c.announceRevocation(ctx, userID, 0)
+			c.audit(ctx, siem.Event{Type: siem.UserTokensRevoked, UserID: userID, Count: n})
+		}
+	}()
+
+	if c.config.SoftRevocation {
+		if _, ok := c.storage.(storage.SoftRevoker); !ok || !c.Capabilities().SoftRevoke {
+			return 0, fmt.Errorf("soft bulk revoke: %w", utils.ErrNotSupported)
+		}
+		return traced(c, ctx, "RevokeUserTokens", func(cfg *config.Config) (int64, error) {
+			cfg, err := cfg.ForUser(userID)
+			if err != nil {
+				return 0, err
+			}
+			sr, ok := cfg.Storage.(storage.SoftRevoker)
+			if !ok {
+				return 0, fmt.Errorf("soft bulk revoke: %w", utils.ErrNotSupported)
+			}
+			return sr.MarkRevokedByUser(userID, cfg.Now())
+		}, attribute.Int64("goauth.user_id", userID))
+	}
+
+	if _, ok := c.storage.(storage.BulkRevoker); !ok || !c.Capabilities().BulkRevoke {
+		return 0, fmt.Errorf("bulk revoke: %w", utils.ErrNotSupported)
+	}
+
+	return traced(c, ctx, "RevokeUserTokens", func(cfg *config.Config) (int64, error) {
+		cfg, err := cfg.ForUser(userID)
+		if err != nil {
+			return 0, err
+		}
+		br, ok := cfg.Storage.(storage.BulkRevoker)
+		if !ok {
+			return 0, fmt.Errorf("bulk revoke: %w", utils.ErrNotSupported)
+		}
+		return br.RevokeByUser(userID)
+	}, attribute.Int64("goauth.user_id", userID))
+}
+
+// errEmptyToken is returned for an empty token argument. Like every other
+// malformed token it matches ErrInvalidFormat and ErrTokenInvalid.
+var errEmptyToken = fmt.Errorf("token cannot be empty: %w", utils.ErrTokenInvalidFormat)
+
+// cutToken strips an optional "Bearer " prefix and splits the token into
+// its locator and secret segments
+func cutToken(raw string) (string, string, bool) {
+	raw = strings.TrimPrefix(raw, "Bearer ")
+	loc, secret, ok := strings.Cut(raw, "|")
+	if !ok || strings.Contains(secret, "|") {
+		return "", "", false
+	}
+	return loc, secret, true
+}
+
+// setClock hands the client's clock to the storage drivers, to every
+// region's and to the rate limiter
+func (c *Client) setClock() {
+	if c.config.Clock == nil {
+		return
+	}
+	drivers := []storage.Driver{c.storage}
+	if c.config.Residency != nil {
+		for _, d := range c.config.Residency.Regions {
+			drivers = append(drivers, d)
+		}
+	}
+	for _, d := range drivers {
+		storage.SetClock(d, c.config.Clock.Now)
+	}
+	if l, ok := c.limiter.(ratelimit.Clocked); ok {
+		l.SetClock(c.config.Clock.Now)
+	}
+}
+
+// defaultSigningKey gives an HS256 client without WithSigningKey a random
+// key of its own. Signatures then don't verify across instances or
+// restarts, so deployments should always set one.
+func (c *Client) defaultSigningKey() error {
+	if c.config.SigningMethod != "HS256" || c.config.SigningKey != "" {
+		return nil
+	}
 	key := make([]byte, 32)
 	if _, err := rand.Read(key); err != nil {
-		// Fallback to timestamp-based key if crypto/rand fails
-		key = []byte(fmt.Sprintf("fallback-key-%d", time.Now().UnixNano()))
+		return fmt.Errorf("generate signing key: %w", err)
 	}
-
-	return base64.StdEncoding.EncodeToString(key)
+	c.config.SigningKey = base64.StdEncoding.EncodeToString(key)
+	return nil
 }
 
 // TokenOptions Legacy compatibility types and functions
 type TokenOptions = auth.TokenOptions
 type TokenResult = auth.Result
+type TokenPair = auth.PairResult
+type TokenRotation = auth.Rotation
 type Config = config.Config
 type PersonalAccessToken = entity.PersonalAccessToken
+type AbilityPolicy = config.AbilityPolicy
+type AbilityMatcher = entity.AbilityMatcher
+type GlobMatcher = entity.GlobMatcher
+type TreeMatcher = entity.TreeMatcher
+type TokenLimitPolicy = config.TokenLimitPolicy
+type ChecksumAlgorithm = config.ChecksumAlgorithm
+type Locator = locator.Locator
+type LocatorFunc = locator.Func
+type Capabilities = storage.Capabilities
+type DriverStats = storage.Stats
+type Logger = utils.Logger
+type Clock = config.Clock
+type PanicError = worker.PanicError
+type ListOptions = storage.ListOptions
+type TokenStatus = storage.TokenStatus
+type SQLiteOptions = storage.SQLiteOptions
+type StorageDriver = storage.Driver
+type Transactor = storage.Transactor
+type UserLocker = storage.UserLocker
+
+var (
+	// ErrTokenInvalid matches every token validation failure, including the
+	// more specific errors below
+	ErrTokenInvalid = utils.ErrTokenInvalid
+	// ErrTokenExpired is returned for tokens past their expiry
+	ErrTokenExpired = utils.ErrTokenExpired
+	// ErrTokenNotFound is returned when no stored token matches
+	ErrTokenNotFound = utils.ErrTokenNotFound
+	// ErrTokenRevoked is returned for revoked tokens
+	ErrTokenRevoked = utils.ErrTokenRevoked
+	// ErrInvalidFormat is returned for strings that aren't "locator|secret" tokens
+	ErrInvalidFormat = utils.ErrTokenInvalidFormat
+	// ErrNotSupported is returned when the storage driver lacks a capability
+	ErrNotSupported = utils.ErrNotSupported
+	// ErrAbilityDenied is returned when a token lacks a required ability
+	ErrAbilityDenied = utils.ErrAbilityDenied
+	// ErrRefreshTokenReused is returned when a rotated refresh token is replayed
+	ErrRefreshTokenReused = utils.ErrRefreshTokenReused
+	// ErrTokenRotated is returned when rotating a token that was already rotated
+	ErrTokenRotated = utils.ErrTokenRotated
+	// ErrMaintenanceMode is returned by validation while maintenance mode is on
+	ErrMaintenanceMode = utils.ErrMaintenanceMode
+	// ErrDuplicateToken is returned when a token with the same hash is already stored
+	ErrDuplicateToken = utils.ErrDuplicateToken
+	// ErrMissingIndex is returned by strict index verification
+	ErrMissingIndex = utils.ErrMissingIndex
+	// ErrReadOnlyStorage is returned by writes against a token set driver
+	ErrReadOnlyStorage = utils.ErrReadOnlyStorage
+	// ErrUnindexedHash is returned by ExportTokenSet when a live token's
+	// hash isn't a plain SHA-256 digest, e.g. under WithHashPepper
+	ErrUnindexedHash = utils.ErrUnindexedHash
+	// ErrRateLimited is returned when too many validations failed for a token or IP
+	ErrRateLimited = utils.ErrRateLimited
+	// ErrWeakToken is returned when an imported secret fails the strength policy
+	ErrWeakToken = utils.ErrWeakToken
+	// ErrReplicationIncomplete is returned when fewer peers than
+	// ReplicationOptions.SyncPeers acknowledged a write
+	ErrReplicationIncomplete = utils.ErrReplicationIncomplete
+	// ErrCrossRegion is returned for tokens or users pinned to a region this
+	// client has no storage for (see WithResidency)
+	ErrCrossRegion = utils.ErrCrossRegion
+	// ErrTokenLimitReached is returned when a user already holds the number
+	// of tokens allowed by WithMaxTokensPerUser
+	ErrTokenLimitReached = utils.ErrTokenLimitReached
+	// ErrValidationTimeout is returned when validation exceeds the budget set
+	// by WithValidationBudget. It is not a validation failure.
+	ErrValidationTimeout = utils.ErrValidationTimeout
+	// ErrInvalidCredentials is returned by Login for an unknown identifier
+	// or a wrong password, without telling which
+	ErrInvalidCredentials = utils.ErrInvalidCredentials
+)
+
+// NoExpiry, set as TokenOptions.ExpiresIn, issues a token that never expires
+const NoExpiry = auth.NoExpiry
+
+// Policies for tokens stored without abilities
+const (
+	AbilityPolicyDenyAll    = config.AbilityPolicyDenyAll
+	AbilityPolicyAllowAll   = config.AbilityPolicyAllowAll
+	AbilityPolicyDefaultSet = config.AbilityPolicyDefaultSet
+)
+
+// Policies applied when a user reaches WithMaxTokensPerUser
+const (
+	TokenLimitReject   = config.TokenLimitReject
+	TokenLimitEvictLRU = config.TokenLimitEvictLRU
+)
+
+// Checksums for WithTokenChecksum
+const (
+	ChecksumCRC32C = config.ChecksumCRC32C
+	ChecksumXXHash = config.ChecksumXXHash
+	ChecksumHMAC   = config.ChecksumHMAC
+)
+
+// Token status filters for ListTokens. Revocation deletes tokens unless
+// WithSoftRevocation keeps them, so only then does StatusRevoked match any.
+const (
+	StatusAll     = storage.StatusAll
+	StatusActive  = storage.StatusActive
+	StatusExpired = storage.StatusExpired
+	StatusRevoked = storage.StatusRevoked
+)
@@ -0,0 +1,32 @@
+package goauthtest
+
+import (
+	"testing"
+
+	"github.com/mohar9h/goauth"
+)
+
+// SigningKey is the signing key of clients built by NewTestClient
+const SigningKey = "goauthtest-signing-key"
+
+// NewTestClient returns a client backed by a fresh Driver, closed when the
+// test ends. The client reads the driver's clock, so Advance moves both
+// past a token's expiry. Last use is recorded synchronously so the
+// driver's state is settled when validation returns. opts apply after
+// these defaults.
+func NewTestClient(tb testing.TB, opts ...goauth.Option) (*goauth.Client, *Driver) {
+	tb.Helper()
+
+	d := NewDriver()
+	client, err := goauth.NewClient(append([]goauth.Option{
+		goauth.WithSigningKey(SigningKey),
+		goauth.WithStorage(d),
+		goauth.WithClock(d),
+		goauth.WithLastUsedTracking(goauth.LastUsedSync),
+	}, opts...)...)
+	if err != nil {
+		tb.Fatalf("goauthtest: NewClient: %v", err)
+	}
+	tb.Cleanup(func() { client.Close() })
+	return client, d
+}
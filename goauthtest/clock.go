@@ -0,0 +1,50 @@
+package goauthtest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a goauth.Clock under the test's control, for goauth.WithClock.
+// It runs on real time until Set or Advance freezes it. The zero value is
+// ready to use.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time // Zero = real time
+}
+
+// NewClock returns a clock frozen at t
+func NewClock(t time.Time) *Clock {
+	return &Clock{now: t}
+}
+
+// Now returns the clock's time
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.now.IsZero() {
+		return time.Now()
+	}
+	return c.now
+}
+
+// Set freezes the clock at t, or restarts it on real time when t is zero
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = t
+}
+
+// Advance moves the clock forward by d, freezing it at the current time
+// first if it is running
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.now.IsZero() {
+		c.now = time.Now()
+	}
+	c.now = c.now.Add(d)
+}
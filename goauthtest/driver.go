@@ -0,0 +1,435 @@
+// Package goauthtest helps applications unit-test their goauth flows
+// without a database: a fake storage driver that can be made to fail, a
+// frozen clock and helpers for asserting the shape of issued tokens.
+//
+//	func TestLogin(t *testing.T) {
+//		client, store := goauthtest.NewTestClient(t)
+//		store.FailOn("StoreToken", errors.New("disk full"))
+//		...
+//	}
+package goauthtest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth"
+	"github.com/mohar9h/goauth/internal/storage"
+)
+
+// Driver is an in-memory goauth.StorageDriver under the test's control.
+// Calls can be made to fail with FailOn and FailOnce, are counted by
+// Calls, and see the time set by Freeze. The driver is also a
+// goauth.Clock, so a client built WithClock(driver) shares its time. The
+// zero value is not usable; call NewDriver.
+type Driver struct {
+	mu     sync.Mutex
+	tokens map[int64]*goauth.PersonalAccessToken
+	nextID int64
+	clock  Clock
+	fail   map[string]*failure
+	calls  map[string]int
+}
+
+// failure is an injected error, returned times more times (0 = always)
+type failure struct {
+	err   error
+	times int
+}
+
+var (
+	_ goauth.StorageDriver = (*Driver)(nil)
+	_ storage.BulkRevoker  = (*Driver)(nil)
+	_ storage.SoftRevoker  = (*Driver)(nil)
+)
+
+// NewDriver returns an empty driver running on real time
+func NewDriver() *Driver {
+	return &Driver{
+		tokens: make(map[int64]*goauth.PersonalAccessToken),
+		nextID: 1,
+		fail:   make(map[string]*failure),
+		calls:  make(map[string]int),
+	}
+}
+
+// FailOn makes every call to the named method, e.g. "FindByHash", return
+// err until FailOn(method, nil) clears it
+func (d *Driver) FailOn(method string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err == nil {
+		delete(d.fail, method)
+		return
+	}
+	d.fail[method] = &failure{err: err}
+}
+
+// FailOnce makes the next call to the named method return err
+func (d *Driver) FailOnce(method string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.fail[method] = &failure{err: err, times: 1}
+}
+
+// Calls reports how many times the named method was called, failed calls
+// included
+func (d *Driver) Calls(method string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.calls[method]
+}
+
+// Freeze stops the driver's clock at t. The driver rejects tokens expired
+// at that time and stamps LastUsedAt with it.
+func (d *Driver) Freeze(t time.Time) {
+	d.clock.Set(t)
+}
+
+// Advance moves the driver's clock forward by dur, freezing it at the
+// current time first if it is running
+func (d *Driver) Advance(dur time.Duration) {
+	d.clock.Advance(dur)
+}
+
+// Now returns the driver's current time
+func (d *Driver) Now() time.Time {
+	return d.clock.Now()
+}
+
+// Tokens returns copies of every stored token, ordered by ID
+func (d *Driver) Tokens() []*goauth.PersonalAccessToken {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	all := make([]*goauth.PersonalAccessToken, 0, len(d.tokens))
+	for _, tok := range d.tokens {
+		cp := *tok
+		all = append(all, &cp)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all
+}
+
+// Reset drops every token, injected failure and call count, and restarts
+// the clock
+func (d *Driver) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	clear(d.tokens)
+	clear(d.fail)
+	clear(d.calls)
+	d.clock.Set(time.Time{})
+}
+
+// injected records a call to method and returns the error injected for
+// it, if any. Callers hold d.mu.
+func (d *Driver) injected(method string) error {
+	d.calls[method]++
+
+	f, ok := d.fail[method]
+	if !ok {
+		return nil
+	}
+	if f.times > 0 {
+		if f.times--; f.times == 0 {
+			delete(d.fail, method)
+		}
+	}
+	return f.err
+}
+
+func (d *Driver) byHash(hash string) *goauth.PersonalAccessToken {
+	for _, tok := range d.tokens {
+		if tok.Token == hash {
+			return tok
+		}
+	}
+	return nil
+}
+
+// live returns a copy of tok, or ErrTokenExpired past its expiry
+func (d *Driver) live(tok *goauth.PersonalAccessToken) (*goauth.PersonalAccessToken, error) {
+	if tok.ExpiresAt != nil && d.clock.Now().After(*tok.ExpiresAt) {
+		return nil, goauth.ErrTokenExpired
+	}
+	cp := *tok
+	return &cp, nil
+}
+
+// FindByID looks up a token by ID
+func (d *Driver) FindByID(id int64) (*goauth.PersonalAccessToken, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("FindByID"); err != nil {
+		return nil, err
+	}
+
+	tok, ok := d.tokens[id]
+	if !ok {
+		return nil, goauth.ErrTokenNotFound
+	}
+	return d.live(tok)
+}
+
+// FindByHash looks up a token by its stored hash
+func (d *Driver) FindByHash(hash string) (*goauth.PersonalAccessToken, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("FindByHash"); err != nil {
+		return nil, err
+	}
+
+	tok := d.byHash(hash)
+	if tok == nil {
+		return nil, goauth.ErrTokenNotFound
+	}
+	return d.live(tok)
+}
+
+// FindByUser returns a page of the user's tokens ordered by ID, plus the
+// total match count
+func (d *Driver) FindByUser(userID int64, opts goauth.ListOptions) ([]*goauth.PersonalAccessToken, int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("FindByUser"); err != nil {
+		return nil, 0, err
+	}
+
+	now := d.clock.Now()
+	matched := []*goauth.PersonalAccessToken{}
+	for _, tok := range d.tokens {
+		if tok.UserId == userID && opts.Status.Matches(tok, now) {
+			cp := *tok
+			matched = append(matched, &cp)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID < matched[j].ID })
+
+	total := int64(len(matched))
+	matched = matched[min(opts.Offset, len(matched)):]
+	if opts.Limit > 0 && opts.Limit < len(matched) {
+		matched = matched[:opts.Limit]
+	}
+	return matched, total, nil
+}
+
+// RevokeToken deletes a token by its stored hash
+func (d *Driver) RevokeToken(hash string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("RevokeToken"); err != nil {
+		return err
+	}
+
+	tok := d.byHash(hash)
+	if tok == nil {
+		return goauth.ErrTokenNotFound
+	}
+	delete(d.tokens, tok.ID)
+	return nil
+}
+
+// MarkRevoked sets RevokedAt on a token that isn't already revoked
+func (d *Driver) MarkRevoked(hash string, at time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("MarkRevoked"); err != nil {
+		return err
+	}
+
+	tok := d.byHash(hash)
+	if tok == nil {
+		return goauth.ErrTokenNotFound
+	}
+	if tok.RevokedAt != nil {
+		return goauth.ErrTokenRevoked
+	}
+	tok.RevokedAt = &at
+	tok.UpdatedAt = d.clock.Now()
+	return nil
+}
+
+// RevokeFamily marks every unrevoked token in a refresh family as revoked
+func (d *Driver) RevokeFamily(familyID string, at time.Time) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("RevokeFamily"); err != nil {
+		return 0, err
+	}
+
+	return d.revokeWhere(at, func(tok *goauth.PersonalAccessToken) bool {
+		return familyID != "" && tok.FamilyID == familyID
+	}), nil
+}
+
+// MarkRevokedByUser sets RevokedAt on every unrevoked token of the user
+func (d *Driver) MarkRevokedByUser(userID int64, at time.Time) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("MarkRevokedByUser"); err != nil {
+		return 0, err
+	}
+
+	return d.revokeWhere(at, func(tok *goauth.PersonalAccessToken) bool {
+		return tok.UserId == userID
+	}), nil
+}
+
+// revokeWhere marks the unrevoked tokens matching match as revoked at at.
+// Callers hold d.mu.
+func (d *Driver) revokeWhere(at time.Time, match func(*goauth.PersonalAccessToken) bool) int64 {
+	var n int64
+	for _, tok := range d.tokens {
+		if tok.RevokedAt != nil || !match(tok) {
+			continue
+		}
+		revokedAt := at
+		tok.RevokedAt = &revokedAt
+		tok.UpdatedAt = d.clock.Now()
+		n++
+	}
+	return n
+}
+
+// DeleteExpired deletes every token whose expiry is at or before the cutoff
+func (d *Driver) DeleteExpired(before time.Time) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("DeleteExpired"); err != nil {
+		return 0, err
+	}
+
+	return d.deleteWhere(func(tok *goauth.PersonalAccessToken) bool {
+		return tok.ExpiresAt != nil && !tok.ExpiresAt.After(before)
+	}), nil
+}
+
+// DeleteRevoked deletes every token revoked at or before the cutoff
+func (d *Driver) DeleteRevoked(before time.Time) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("DeleteRevoked"); err != nil {
+		return 0, err
+	}
+
+	return d.deleteWhere(func(tok *goauth.PersonalAccessToken) bool {
+		return tok.RevokedAt != nil && !tok.RevokedAt.After(before)
+	}), nil
+}
+
+// RevokeByUser deletes every token of the user
+func (d *Driver) RevokeByUser(userID int64) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("RevokeByUser"); err != nil {
+		return 0, err
+	}
+
+	return d.deleteWhere(func(tok *goauth.PersonalAccessToken) bool {
+		return tok.UserId == userID
+	}), nil
+}
+
+// deleteWhere deletes the tokens matching match. Callers hold d.mu.
+func (d *Driver) deleteWhere(match func(*goauth.PersonalAccessToken) bool) int64 {
+	var n int64
+	for id, tok := range d.tokens {
+		if match(tok) {
+			delete(d.tokens, id)
+			n++
+		}
+	}
+	return n
+}
+
+// TouchLastUsed sets a token's LastUsedAt to the driver's time
+func (d *Driver) TouchLastUsed(id int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("TouchLastUsed"); err != nil {
+		return err
+	}
+
+	tok, ok := d.tokens[id]
+	if !ok {
+		return goauth.ErrTokenNotFound
+	}
+	now := d.clock.Now()
+	tok.LastUsedAt = &now
+	return nil
+}
+
+// UpdateExpiry moves a token's expiration time
+func (d *Driver) UpdateExpiry(id int64, expiresAt time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("UpdateExpiry"); err != nil {
+		return err
+	}
+
+	tok, ok := d.tokens[id]
+	if !ok {
+		return goauth.ErrTokenNotFound
+	}
+	tok.ExpiresAt = &expiresAt
+	tok.UpdatedAt = d.clock.Now()
+	return nil
+}
+
+// StoreToken stores a new token, assigning its ID when unset
+func (d *Driver) StoreToken(t *goauth.PersonalAccessToken) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("StoreToken"); err != nil {
+		return err
+	}
+
+	if d.byHash(t.Token) != nil {
+		return goauth.ErrDuplicateToken
+	}
+	if t.ID == 0 {
+		t.ID = d.nextID
+		d.nextID++
+	}
+	t.UpdatedAt = d.clock.Now()
+	cp := *t
+	d.tokens[t.ID] = &cp
+	return nil
+}
+
+// UpdateToken replaces the stored token with the same ID
+func (d *Driver) UpdateToken(t *goauth.PersonalAccessToken) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.injected("UpdateToken"); err != nil {
+		return err
+	}
+
+	if _, ok := d.tokens[t.ID]; !ok {
+		return goauth.ErrTokenNotFound
+	}
+	t.UpdatedAt = d.clock.Now()
+	cp := *t
+	d.tokens[t.ID] = &cp
+	return nil
+}
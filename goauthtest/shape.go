@@ -0,0 +1,76 @@
+package goauthtest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/mohar9h/goauth"
+)
+
+// UpdateEnv names the environment variable that makes AssertGolden
+// rewrite golden files instead of comparing against them
+const UpdateEnv = "GOAUTHTEST_UPDATE"
+
+// Shape describes raw with its random parts masked, so tokens issued by
+// the same configuration share a shape, e.g.
+//
+//	<locator:12>|app_<random:64><crc32c:8>
+//
+// Numeric locators show as <id>. raw must decode with the client's token
+// format; Shape returns an error naming the problem otherwise.
+func Shape(client *goauth.Client, raw string) (string, error) {
+	tok, err := client.TokenFormat().Decode(raw)
+	if err != nil {
+		return "", fmt.Errorf("goauthtest: %q is not a token of this client: %w", raw, err)
+	}
+
+	loc := fmt.Sprintf("<locator:%d>", len(tok.Locator))
+	if _, err := strconv.ParseInt(tok.Locator, 10, 64); err == nil {
+		loc = "<id>"
+	}
+	return fmt.Sprintf("%s|%s<random:%d><%s:%d>", loc, tok.Prefix,
+		len(tok.Random), client.TokenFormat().Checksum, len(tok.Checksum)), nil
+}
+
+// AssertShape fails the test unless raw has the shape want (see Shape)
+func AssertShape(tb testing.TB, client *goauth.Client, raw, want string) {
+	tb.Helper()
+
+	got, err := Shape(client, raw)
+	if err != nil {
+		tb.Error(err)
+		return
+	}
+	if got != want {
+		tb.Errorf("goauthtest: token shape\n got: %s\nwant: %s", got, want)
+	}
+}
+
+// AssertGolden fails the test unless got matches the golden file at path,
+// conventionally under testdata. Run the tests with GOAUTHTEST_UPDATE=1 to
+// write got to the file instead.
+func AssertGolden(tb testing.TB, path string, got []byte) {
+	tb.Helper()
+
+	if os.Getenv(UpdateEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			tb.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatalf("goauthtest: %v (run with %s=1 to create it)", err, UpdateEnv)
+	}
+	if !bytes.Equal(got, want) {
+		tb.Errorf("goauthtest: %s differs from golden file\n got: %s\nwant: %s", path, got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/entity"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// WithGuestAbilities sets the abilities given to guest tokens created
+// without explicit abilities (default: "guest")
+func WithGuestAbilities(abilities ...string) Option {
+	return func(c *Client) error {
+		if len(abilities) == 0 {
+			return fmt.Errorf("guest abilities cannot be empty")
+		}
+		c.config.GuestAbilities = abilities
+		return nil
+	}
+}
+
+// CreateGuestToken issues a token that isn't bound to a user, e.g. for an
+// anonymous shopping cart. opts may be nil; UserId is ignored.
+func (c *Client) CreateGuestToken(ctx context.Context, opts *TokenOptions) (string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	default:
+	}
+
+	return traced(c, ctx, "CreateGuestToken", func(cfg *config.Config) (string, error) {
+		authOpts := &auth.TokenOptions{Config: cfg}
+		if opts != nil {
+			authOpts.Name = opts.Name
+			authOpts.Abilities = opts.Abilities
+		}
+		return auth.CreateGuestToken(authOpts)
+	})
+}
+
+// PromoteGuestToken binds a guest token to the user who just registered.
+// The plaintext is unchanged, so the client keeps its session.
+func (c *Client) PromoteGuestToken(ctx context.Context, raw string, userID int64) (*entity.PersonalAccessToken, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if raw == "" {
+		return nil, errEmptyToken
+	}
+
+	if userID <= 0 {
+		return nil, fmt.Errorf("user ID must be positive")
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	return traced(c, ctx, "PromoteGuestToken", func(cfg *config.Config) (*entity.PersonalAccessToken, error) {
+		return auth.PromoteGuestToken(raw, userID, cfg)
+	}, attribute.Int64("goauth.user_id", userID))
+}
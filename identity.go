@@ -0,0 +1,121 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// IdentityLink ties an external identity to a local user
+type IdentityLink = entity.IdentityLink
+
+var (
+	// ErrIdentityNotFound is returned when no user is linked to an identity
+	ErrIdentityNotFound = utils.ErrIdentityNotFound
+	// ErrIdentityAlreadyLinked is returned when linking an identity that is taken
+	ErrIdentityAlreadyLinked = utils.ErrIdentityAlreadyLinked
+)
+
+// LinkIdentity links an external identity (e.g. provider "google" and its
+// "sub" claim) to a local user so every sign-in method shares one token set
+func (c *Client) LinkIdentity(ctx context.Context, userID int64, provider, subject string) (*IdentityLink, error) {
+	store, err := c.identityStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID <= 0 {
+		return nil, fmt.Errorf("user ID must be positive")
+	}
+
+	provider, subject, err = normalizeIdentity(provider, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	link := &entity.IdentityLink{
+		UserId:    userID,
+		Provider:  provider,
+		Subject:   subject,
+		CreatedAt: c.config.Now(),
+	}
+	if err := store.LinkIdentity(link); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// UnlinkIdentity removes the link between an external identity and its user
+func (c *Client) UnlinkIdentity(ctx context.Context, provider, subject string) error {
+	store, err := c.identityStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	provider, subject, err = normalizeIdentity(provider, subject)
+	if err != nil {
+		return err
+	}
+
+	return store.UnlinkIdentity(provider, subject)
+}
+
+// FindUserByIdentity returns the local user ID linked to an external identity
+func (c *Client) FindUserByIdentity(ctx context.Context, provider, subject string) (int64, error) {
+	store, err := c.identityStore(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	provider, subject, err = normalizeIdentity(provider, subject)
+	if err != nil {
+		return 0, err
+	}
+
+	link, err := store.FindIdentity(provider, subject)
+	if err != nil {
+		return 0, err
+	}
+	return link.UserId, nil
+}
+
+// ListIdentities returns every external identity linked to the user
+func (c *Client) ListIdentities(ctx context.Context, userID int64) ([]*IdentityLink, error) {
+	store, err := c.identityStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.ListIdentities(userID)
+}
+
+func (c *Client) identityStore(ctx context.Context) (storage.IdentityStore, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	store, ok := c.storage.(storage.IdentityStore)
+	if !ok {
+		return nil, fmt.Errorf("identity links: %w", utils.ErrNotSupported)
+	}
+	return store, nil
+}
+
+func normalizeIdentity(provider, subject string) (string, string, error) {
+	provider = strings.ToLower(strings.TrimSpace(provider))
+	if provider == "" || subject == "" {
+		return "", "", fmt.Errorf("provider and subject are required")
+	}
+	return provider, subject, nil
+}
@@ -0,0 +1,75 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type firebaseExport struct {
+	Users []firebaseUser `json:"users"`
+}
+
+type firebaseUser struct {
+	LocalID       string `json:"localId"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"emailVerified"`
+	DisplayName   string `json:"displayName"`
+	Disabled      bool   `json:"disabled"`
+	PasswordHash  string `json:"passwordHash"`
+	Salt          string `json:"salt"`
+	CreatedAt     string `json:"createdAt"` // Unix milliseconds
+}
+
+// FirebaseHashConfig carries the project-wide scrypt parameters that
+// `firebase auth:export` omits from the per-user records.
+type FirebaseHashConfig struct {
+	Algorithm string // Usually "SCRYPT"
+	Rounds    int
+}
+
+// ParseFirebase reads the JSON produced by `firebase auth:export --format=json`.
+// Users with a malformed hash, salt or creation time fail the whole parse.
+func ParseFirebase(r io.Reader, hash FirebaseHashConfig) ([]User, error) {
+	var export firebaseExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("importer: decode firebase export: %w", err)
+	}
+
+	users := make([]User, 0, len(export.Users))
+	for _, fu := range export.Users {
+		u := User{
+			Provider:      "firebase",
+			ExternalID:    fu.LocalID,
+			Username:      fu.Email,
+			Email:         fu.Email,
+			EmailVerified: fu.EmailVerified,
+			Disabled:      fu.Disabled,
+			DisplayName:   fu.DisplayName,
+			PasswordHash:  fu.PasswordHash,
+			PasswordSalt:  fu.Salt,
+		}
+		if fu.PasswordHash != "" {
+			u.HashAlgorithm = hash.Algorithm
+			u.HashRounds = hash.Rounds
+		}
+		if err := checkFirebaseUser(&u, fu); err != nil {
+			return nil, fmt.Errorf("importer: firebase user %s: %w", fu.LocalID, err)
+		}
+		users = append(users, u)
+	}
+
+	return users, nil
+}
+
+func checkFirebaseUser(u *User, fu firebaseUser) error {
+	if err := checkBase64("passwordHash", fu.PasswordHash); err != nil {
+		return err
+	}
+	if err := checkBase64("salt", fu.Salt); err != nil {
+		return err
+	}
+	var err error
+	u.CreatedAt, err = parseMillis(fu.CreatedAt)
+	return err
+}
@@ -0,0 +1,131 @@
+// Package importer reads user exports from external identity providers
+// (Keycloak, Firebase Auth) and provisions them into an application backed
+// by goauth, optionally minting a bootstrap token per user.
+//
+// goauth does not own the user table, so provisioning is delegated to a
+// Provisioner callback that creates the local user and returns its ID.
+package importer
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mohar9h/goauth"
+)
+
+// User is a provider-neutral view of an exported account.
+type User struct {
+	Provider      string // "keycloak" or "firebase"
+	ExternalID    string // Provider's user ID
+	Username      string
+	Email         string
+	EmailVerified bool
+	Disabled      bool
+	DisplayName   string
+	PasswordHash  string // Provider-encoded hash, passed through untouched
+	PasswordSalt  string
+	HashAlgorithm string // e.g. "pbkdf2-sha256", "SCRYPT"
+	HashRounds    int
+	Attributes    map[string][]string
+	CreatedAt     time.Time // Zero if the export doesn't say
+}
+
+// Provisioner creates (or finds) the local user for an imported account and
+// returns its local ID.
+type Provisioner func(ctx context.Context, u User) (int64, error)
+
+// Options controls an import run.
+type Options struct {
+	Provision          Provisioner
+	SkipDisabled       bool     // Don't provision disabled accounts
+	BootstrapToken     bool     // Mint a token per provisioned user
+	BootstrapName      string   // Name for bootstrap tokens
+	BootstrapAbilities []string // Abilities for bootstrap tokens
+}
+
+// Result reports the outcome for a single user.
+type Result struct {
+	User    User
+	UserID  int64
+	Token   string // Bootstrap token plaintext, if requested
+	Skipped bool
+	Err     error
+}
+
+// ErrNoProvisioner is returned when Options.Provision is nil.
+var ErrNoProvisioner = errors.New("importer: provisioner is required")
+
+// Import provisions users one at a time. Per-user failures are recorded in
+// the results and don't stop the run; only context cancellation does.
+func Import(ctx context.Context, client *goauth.Client, users []User, opts Options) ([]Result, error) {
+	if opts.Provision == nil {
+		return nil, ErrNoProvisioner
+	}
+	if opts.BootstrapToken && client == nil {
+		return nil, fmt.Errorf("importer: client is required for bootstrap tokens")
+	}
+
+	results := make([]Result, 0, len(users))
+	for _, u := range users {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		res := Result{User: u}
+		if opts.SkipDisabled && u.Disabled {
+			res.Skipped = true
+			results = append(results, res)
+			continue
+		}
+
+		res.UserID, res.Err = opts.Provision(ctx, u)
+		if res.Err == nil && opts.BootstrapToken {
+			res.Token, res.Err = bootstrap(ctx, client, res.UserID, opts)
+		}
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+// checkBase64 rejects a password hash or salt that isn't standard base64,
+// the encoding both providers export them in
+func checkBase64(field, s string) error {
+	if _, err := base64.StdEncoding.DecodeString(s); err != nil {
+		return fmt.Errorf("%s is not base64", field)
+	}
+	return nil
+}
+
+// parseMillis reads a Unix time in milliseconds. Empty means unset.
+func parseMillis(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ms < 0 {
+		return time.Time{}, fmt.Errorf("bad timestamp %q", s)
+	}
+	return time.UnixMilli(ms).UTC(), nil
+}
+
+func bootstrap(ctx context.Context, client *goauth.Client, userID int64, opts Options) (string, error) {
+	var name *string
+	if opts.BootstrapName != "" {
+		name = &opts.BootstrapName
+	}
+
+	token, err := client.CreateToken(ctx, &goauth.TokenOptions{
+		UserId:    userID,
+		Name:      name,
+		Abilities: opts.BootstrapAbilities,
+	})
+	if err != nil {
+		return "", fmt.Errorf("bootstrap token: %w", err)
+	}
+	return token, nil
+}
@@ -0,0 +1,124 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type keycloakExport struct {
+	Users []keycloakUser `json:"users"`
+}
+
+type keycloakUser struct {
+	ID            string               `json:"id"`
+	Username      string               `json:"username"`
+	Email         string               `json:"email"`
+	EmailVerified bool                 `json:"emailVerified"`
+	Enabled       bool                 `json:"enabled"`
+	FirstName     string               `json:"firstName"`
+	LastName      string               `json:"lastName"`
+	Attributes    map[string][]string  `json:"attributes"`
+	Credentials   []keycloakCredential `json:"credentials"`
+	Created       json.RawMessage      `json:"createdTimestamp"` // Unix milliseconds
+}
+
+type keycloakCredential struct {
+	Type           string `json:"type"`
+	SecretData     string `json:"secretData"`     // JSON: {"value": ..., "salt": ...}
+	CredentialData string `json:"credentialData"` // JSON: {"hashIterations": ..., "algorithm": ...}
+}
+
+// ParseKeycloak reads a Keycloak realm or users export (JSON with a
+// top-level "users" array). Users with a malformed password credential or
+// creation time fail the whole parse.
+func ParseKeycloak(r io.Reader) ([]User, error) {
+	var export keycloakExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("importer: decode keycloak export: %w", err)
+	}
+
+	users := make([]User, 0, len(export.Users))
+	for _, ku := range export.Users {
+		u := User{
+			Provider:      "keycloak",
+			ExternalID:    ku.ID,
+			Username:      ku.Username,
+			Email:         ku.Email,
+			EmailVerified: ku.EmailVerified,
+			Disabled:      !ku.Enabled,
+			DisplayName:   joinName(ku.FirstName, ku.LastName),
+			Attributes:    ku.Attributes,
+		}
+
+		created := string(bytes.TrimSpace(ku.Created))
+		if created == "null" {
+			created = ""
+		}
+		var err error
+		if u.CreatedAt, err = parseMillis(created); err != nil {
+			return nil, fmt.Errorf("importer: keycloak user %s: %w", ku.ID, err)
+		}
+
+		for _, c := range ku.Credentials {
+			if c.Type != "password" {
+				continue
+			}
+			if err := applyKeycloakPassword(&u, c); err != nil {
+				return nil, fmt.Errorf("importer: keycloak user %s: %w", ku.ID, err)
+			}
+			break
+		}
+
+		users = append(users, u)
+	}
+
+	return users, nil
+}
+
+func applyKeycloakPassword(u *User, c keycloakCredential) error {
+	var secret struct {
+		Value string `json:"value"`
+		Salt  string `json:"salt"`
+	}
+	var data struct {
+		HashIterations int    `json:"hashIterations"`
+		Algorithm      string `json:"algorithm"`
+	}
+
+	if c.SecretData != "" {
+		if err := json.Unmarshal([]byte(c.SecretData), &secret); err != nil {
+			return fmt.Errorf("decode secretData: %w", err)
+		}
+	}
+	if c.CredentialData != "" {
+		if err := json.Unmarshal([]byte(c.CredentialData), &data); err != nil {
+			return fmt.Errorf("decode credentialData: %w", err)
+		}
+	}
+
+	if err := checkBase64("password hash", secret.Value); err != nil {
+		return err
+	}
+	if err := checkBase64("salt", secret.Salt); err != nil {
+		return err
+	}
+
+	u.PasswordHash = secret.Value
+	u.PasswordSalt = secret.Salt
+	u.HashAlgorithm = data.Algorithm
+	u.HashRounds = data.HashIterations
+	return nil
+}
+
+func joinName(first, last string) string {
+	switch {
+	case first == "":
+		return last
+	case last == "":
+		return first
+	default:
+		return first + " " + last
+	}
+}
@@ -0,0 +1,38 @@
+package goauth
+
+import (
+	"fmt"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/storage"
+)
+
+// WithIndexVerification checks at startup that the storage backend has a
+// unique index on the token hash. A missing index is logged as a warning,
+// or fails NewClient with ErrMissingIndex when strict is set.
+func WithIndexVerification(strict bool) Option {
+	return func(c *Client) error {
+		c.config.IndexCheck = config.IndexCheckWarn
+		if strict {
+			c.config.IndexCheck = config.IndexCheckStrict
+		}
+		return nil
+	}
+}
+
+// verifyIndexes runs the configured startup index check
+func (c *Client) verifyIndexes() error {
+	if c.config.IndexCheck == config.IndexCheckOff {
+		return nil
+	}
+
+	err := storage.VerifyIndexes(c.storage)
+	if err == nil {
+		return nil
+	}
+	if c.config.IndexCheck == config.IndexCheckStrict {
+		return fmt.Errorf("goauth: index verification failed: %w", err)
+	}
+	c.config.Logger.Warn("token hash index missing, lookups will scan the table", "error", err)
+	return nil
+}
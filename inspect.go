@@ -0,0 +1,63 @@
+package goauth
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/mohar9h/goauth/internal/locator"
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/tokenformat"
+)
+
+// TokenFacts are what can be learned from a token string alone. They hold
+// nothing secret and are safe to log or show to support staff.
+type TokenFacts struct {
+	Prefix        string `json:"prefix,omitempty"`   // Secret prefix, e.g. "acme_pat_", when Version is set
+	Version       int    `json:"version"`            // Wire format version, 0 if the token doesn't follow it
+	Locator       string `json:"locator"`            // Public lookup segment before "|"
+	Region        string `json:"region,omitempty"`   // Region pinned by WithRegions, if any
+	ID            int64  `json:"id,omitempty"`       // Token ID when the locator is one
+	Checksum      string `json:"checksum,omitempty"` // Checksum algorithm, when Version is set
+	ChecksumValid bool   `json:"checksum_valid"`
+}
+
+// Inspect parses raw as a token in the default format (no prefix, CRC-32C)
+// without touching storage. It answers "is this even one of ours?": a
+// valid checksum means the token was almost certainly generated by goauth,
+// though it may since have expired or been revoked. Use Client.Inspect for
+// clients with a custom prefix or checksum. Only strings that aren't shaped
+// like "locator|secret" at all return ErrInvalidFormat.
+func Inspect(raw string) (*TokenFacts, error) {
+	return inspect(tokenformat.Format{}, raw)
+}
+
+// Inspect is the package Inspect using this client's token format.
+func (c *Client) Inspect(raw string) (*TokenFacts, error) {
+	return inspect(c.config.TokenFormat(), raw)
+}
+
+func inspect(f tokenformat.Format, raw string) (*TokenFacts, error) {
+	tok, err := tokenformat.Inspect(raw)
+	if err != nil {
+		return nil, utils.ErrTokenInvalidFormat
+	}
+
+	facts := &TokenFacts{Locator: tok.Locator}
+	region, inner := locator.SplitRegion(tok.Locator)
+	facts.Region = region
+	if id, err := strconv.ParseInt(inner, 10, 64); err == nil && id > 0 {
+		facts.ID = id
+	}
+
+	switch _, err := f.Decode(raw); {
+	case err == nil:
+		facts.Version, facts.ChecksumValid = tokenformat.Version, true
+	case errors.Is(err, tokenformat.ErrChecksum):
+		facts.Version = tokenformat.Version
+	default:
+		return facts, nil
+	}
+	facts.Prefix = f.Prefix
+	facts.Checksum = f.Checksum.String()
+	return facts, nil
+}
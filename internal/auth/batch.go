@@ -0,0 +1,113 @@
+// Package auth internal/auth/batch.go
+package auth
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/tokenformat"
+)
+
+// IssueBatch creates an access token for each of opts. Drivers implementing
+// storage.BatchStorer insert them all at once, so either every token is
+// created or none is; other drivers store them one by one. Tokens are stored
+// through the storage of the first options' config, so with residency
+// pinning every token must belong to the same region.
+func IssueBatch(opts []*TokenOptions) ([]*Result, error) {
+	gens := make([]*generator, len(opts))
+	tokens := make([]*entity.PersonalAccessToken, len(opts))
+	plain := make([]string, len(opts))
+
+	for i, o := range opts {
+		cfg, err := prepareConfig(o)
+		if err != nil {
+			return nil, fmt.Errorf("token %d: %w", i, err)
+		}
+		if o.Replace {
+			return nil, fmt.Errorf("token %d: replace is not supported in batches", i)
+		}
+		// One insert cannot span regional stores
+		if i > 0 && cfg.Region != gens[0].cfg.Region {
+			return nil, fmt.Errorf("token %d: %w: batch mixes regions %q and %q", i, utils.ErrCrossRegion, gens[0].cfg.Region, cfg.Region)
+		}
+
+		g := &generator{opts: o, cfg: cfg}
+		ttl, err := g.accessTTL()
+		if err != nil {
+			return nil, fmt.Errorf("token %d: %w", i, err)
+		}
+		if plain[i], err = g.generateTokenString(); err != nil {
+			return nil, fmt.Errorf("token %d: %w", i, err)
+		}
+		hashed, err := cfg.HashSecret(plain[i])
+		if err != nil {
+			return nil, fmt.Errorf("token %d: %w", i, err)
+		}
+		if tokens[i], err = g.build(entity.KindAccess, "", ttl, hashed); err != nil {
+			return nil, fmt.Errorf("token %d: %w", i, err)
+		}
+		gens[i] = g
+	}
+	if len(opts) == 0 {
+		return nil, nil
+	}
+
+	// Make room for each user's new tokens at once
+	adding := make(map[int64]int)
+	first := make(map[int64]*TokenOptions)
+	for _, o := range opts {
+		if adding[o.UserId]++; first[o.UserId] == nil {
+			first[o.UserId] = o
+		}
+	}
+	// Users are locked in ID order so overlapping batches can't deadlock
+	users := slices.Sorted(maps.Keys(adding))
+	err := atomically(gens[0].cfg, func(cfg *config.Config) error {
+		for _, user := range users {
+			if err := enforceQuotas(cfg, first[user], adding[user], 0); err != nil {
+				return fmt.Errorf("user %d: %w", user, err)
+			}
+		}
+		return storeAll(cfg.Storage, tokens)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*Result, len(tokens))
+	for i, t := range tokens {
+		loc, err := gens[i].cfg.Locator.Locate(t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build token locator: %w", err)
+		}
+		results[i] = &Result{
+			PlainText: tokenformat.Encode(loc, plain[i]),
+			TokenID:   t.Token,
+			ExpiresAt: t.ExpiresAt,
+		}
+	}
+	return results, nil
+}
+
+// storeAll inserts tokens in one call when the driver supports it. The
+// fallback revokes the tokens already stored when one insert fails.
+func storeAll(d storage.Driver, tokens []*entity.PersonalAccessToken) error {
+	if b, ok := d.(storage.BatchStorer); ok && storage.CapabilitiesOf(d).BatchInsert {
+		return b.StoreTokens(tokens)
+	}
+
+	for i, t := range tokens {
+		if err := d.StoreToken(t); err != nil {
+			for _, stored := range tokens[:i] {
+				_ = d.RevokeToken(stored.Token)
+			}
+			return err
+		}
+	}
+	return nil
+}
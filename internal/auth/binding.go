@@ -0,0 +1,172 @@
+// Package auth internal/auth/binding.go
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// Metadata keys used to bind a token to API versions and feature flags.
+const (
+	MetaAPIMin   = "goauth.api_min"
+	MetaAPIMax   = "goauth.api_max"
+	MetaFeatures = "goauth.features"
+)
+
+var (
+	ErrAPIVersionNotAllowed = errors.New("token not allowed for this API version")
+	ErrFeatureNotAllowed    = errors.New("token not allowed for this feature")
+)
+
+// buildMetadata merges user metadata with the binding keys derived from opts.
+func buildMetadata(opts *TokenOptions) (map[string]string, error) {
+	if len(opts.Metadata) == 0 && opts.APIVersions == nil && len(opts.Features) == 0 &&
+		opts.BoundIP == "" && opts.BoundUserAgentHash == "" && opts.BoundCertHash == "" {
+		return nil, nil
+	}
+
+	meta := make(map[string]string, len(opts.Metadata)+6)
+	for k, v := range opts.Metadata {
+		meta[k] = v
+	}
+
+	if r := opts.APIVersions; r != nil {
+		for _, v := range []string{r.Min, r.Max} {
+			if _, err := parseVersion(v); err != nil {
+				return nil, err
+			}
+		}
+		if r.Min != "" && r.Max != "" && CompareVersions(r.Min, r.Max) > 0 {
+			return nil, fmt.Errorf("API version range min %q exceeds max %q", r.Min, r.Max)
+		}
+		if r.Min != "" {
+			meta[MetaAPIMin] = r.Min
+		}
+		if r.Max != "" {
+			meta[MetaAPIMax] = r.Max
+		}
+	}
+
+	if len(opts.Features) > 0 {
+		meta[MetaFeatures] = strings.Join(opts.Features, ",")
+	}
+
+	if opts.BoundIP != "" {
+		if err := validateBoundIP(opts.BoundIP); err != nil {
+			return nil, err
+		}
+		meta[MetaBoundIP] = opts.BoundIP
+	}
+	if opts.BoundUserAgentHash != "" {
+		meta[MetaBoundUA] = opts.BoundUserAgentHash
+	}
+	if opts.BoundCertHash != "" {
+		if err := validateCertHash(opts.BoundCertHash); err != nil {
+			return nil, err
+		}
+		meta[MetaBoundCert] = opts.BoundCertHash
+	}
+
+	return meta, nil
+}
+
+// CheckBinding enforces the token's API version and feature bindings, plus
+// the configured minimum supported API version. An empty version skips the
+// per-request version check.
+func CheckBinding(cfg *config.Config, tok *entity.PersonalAccessToken, version string, features []string) error {
+	max := tok.Metadata[MetaAPIMax]
+	if cfg.MinAPIVersion != "" && max != "" && CompareVersions(max, cfg.MinAPIVersion) < 0 {
+		return fmt.Errorf("%w: token limited to %s, minimum supported is %s", ErrAPIVersionNotAllowed, max, cfg.MinAPIVersion)
+	}
+
+	if version != "" {
+		if _, err := parseVersion(version); err != nil {
+			return err
+		}
+		if min := tok.Metadata[MetaAPIMin]; min != "" && CompareVersions(version, min) < 0 {
+			return fmt.Errorf("%w: %s", ErrAPIVersionNotAllowed, version)
+		}
+		if max != "" && CompareVersions(version, max) > 0 {
+			return fmt.Errorf("%w: %s", ErrAPIVersionNotAllowed, version)
+		}
+	}
+
+	allowed, bound := tok.Metadata[MetaFeatures]
+	if !bound {
+		return nil
+	}
+	for _, f := range features {
+		if !listed(allowed, f) {
+			return fmt.Errorf("%w: %s", ErrFeatureNotAllowed, f)
+		}
+	}
+	return nil
+}
+
+// CompareVersions compares dotted numeric versions, treating missing
+// segments as zero. Invalid versions compare as equal.
+func CompareVersions(a, b string) int {
+	va, errA := parseVersion(a)
+	vb, errB := parseVersion(b)
+	if errA != nil || errB != nil {
+		return 0
+	}
+
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// ValidateVersion reports whether v is a dotted numeric version.
+func ValidateVersion(v string) error {
+	_, err := parseVersion(v)
+	return err
+}
+
+func parseVersion(v string) ([]int, error) {
+	if v == "" {
+		return nil, nil
+	}
+	v = strings.TrimPrefix(v, "v")
+
+	parts := strings.Split(v, ".")
+	out := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid API version %q", v)
+		}
+		out[i] = n
+	}
+	return out, nil
+}
+
+// listed reports whether s is an item of the comma separated list
+func listed(list, s string) bool {
+	for list != "" {
+		var item string
+		item, list, _ = strings.Cut(list, ",")
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
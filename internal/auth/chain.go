@@ -0,0 +1,43 @@
+// Package auth internal/auth/chain.go
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// MaxChainDepth bounds how many exchanges separate a token from the
+// original it was derived from
+const MaxChainDepth = 8
+
+// CheckChain walks the parents of an exchanged token and returns how many
+// there are. A token is only as valid as its parents: one revoked, deleted
+// or expired anywhere up the chain revokes it too.
+func CheckChain(cfg *config.Config, tok *entity.PersonalAccessToken) (int, error) {
+	depth := 0
+	now := cfg.Now()
+	for id := tok.ParentID; id != nil; depth++ {
+		if depth == MaxChainDepth {
+			return depth, fmt.Errorf("%w: exchange chain too deep", ErrTokenInvalid)
+		}
+		parent, err := cfg.Storage.FindByID(*id)
+		if errors.Is(err, utils.ErrTokenNotFound) {
+			return depth, utils.ErrTokenRevoked
+		}
+		if err != nil {
+			return depth, err
+		}
+		if parent.RevokedAt != nil {
+			return depth, utils.ErrTokenRevoked
+		}
+		if parent.ExpiresAt != nil && now.After(*parent.ExpiresAt) {
+			return depth, utils.ErrTokenExpired
+		}
+		id = parent.ParentID
+	}
+	return depth, nil
+}
@@ -0,0 +1,101 @@
+// Package auth internal/auth/client_binding.go
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// Metadata keys used to bind a token to the client it was issued to.
+const (
+	MetaBoundIP   = "goauth.bound_ip"
+	MetaBoundUA   = "goauth.bound_ua"
+	MetaBoundCert = "goauth.bound_cert" // RFC 8705 x5t#S256 of the client certificate
+)
+
+var ErrClientMismatch = errors.New("token presented by a different client")
+
+// HashUserAgent returns the digest stored for user-agent bound tokens.
+func HashUserAgent(ua string) string {
+	sum := sha256.Sum256([]byte(ua))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashCertificate returns the RFC 8705 x5t#S256 thumbprint of a DER
+// encoded certificate: its SHA-256 digest, base64url encoded.
+func HashCertificate(der []byte) string {
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// validateCertHash accepts a base64url SHA-256 digest.
+func validateCertHash(v string) error {
+	if b, err := base64.RawURLEncoding.DecodeString(v); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("invalid certificate thumbprint %q", v)
+	}
+	return nil
+}
+
+// validateBoundIP accepts a single address or a CIDR range.
+func validateBoundIP(v string) error {
+	if net.ParseIP(v) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(v); err == nil {
+		return nil
+	}
+	return fmt.Errorf("invalid bound IP %q", v)
+}
+
+// CheckClient enforces the token's IP and user-agent bindings against the
+// presenting client. Unbound tokens always pass.
+func CheckClient(tok *entity.PersonalAccessToken, ip, userAgent string) error {
+	if bound := tok.Metadata[MetaBoundIP]; bound != "" && !ipMatches(bound, ip) {
+		return fmt.Errorf("%w: IP %s", ErrClientMismatch, ip)
+	}
+
+	if bound := tok.Metadata[MetaBoundUA]; bound != "" {
+		got := HashUserAgent(userAgent)
+		if subtle.ConstantTimeCompare([]byte(strings.ToLower(bound)), []byte(got)) != 1 {
+			return fmt.Errorf("%w: user agent", ErrClientMismatch)
+		}
+	}
+	return nil
+}
+
+func ipMatches(bound, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	if _, network, err := net.ParseCIDR(bound); err == nil {
+		return network.Contains(addr)
+	}
+	return addr.Equal(net.ParseIP(bound))
+}
+
+// CheckCertificate enforces the token's certificate binding against the
+// leaf certificate the client presented over mutual TLS, nil when it
+// presented none. Unbound tokens always pass.
+func CheckCertificate(tok *entity.PersonalAccessToken, cert *x509.Certificate) error {
+	bound := tok.Metadata[MetaBoundCert]
+	if bound == "" {
+		return nil
+	}
+	if cert == nil {
+		return fmt.Errorf("%w: no client certificate", ErrClientMismatch)
+	}
+	if subtle.ConstantTimeCompare([]byte(bound), []byte(HashCertificate(cert.Raw))) != 1 {
+		return fmt.Errorf("%w: client certificate", ErrClientMismatch)
+	}
+	return nil
+}
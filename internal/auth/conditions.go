@@ -0,0 +1,101 @@
+// Package auth internal/auth/conditions.go
+package auth
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mohar9h/goauth/internal/condition"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// parsed caches compiled conditions by source; the set of distinct
+// conditions in a deployment is small.
+var parsed sync.Map // string -> *condition.Expr
+
+func compile(src string) (*condition.Expr, error) {
+	if e, ok := parsed.Load(src); ok {
+		return e.(*condition.Expr), nil
+	}
+	e, err := condition.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", utils.ErrInvalidCondition, err)
+	}
+	parsed.Store(src, e)
+	return e, nil
+}
+
+// ValidateAbilities checks that every conditional grant compiles.
+func ValidateAbilities(abilities []string) error {
+	for _, a := range abilities {
+		_, cond := entity.SplitCondition(a)
+		if cond == "" {
+			continue
+		}
+		if _, err := compile(cond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Authorize reports whether the comma separated abilities grant ability on
+// resource. Unconditional grants match as in CanWith; conditional grants match
+// when their condition holds with "token" bound to tok and "resource" to
+// resource. If nothing matches, the last evaluation error is returned.
+func Authorize(tok *entity.PersonalAccessToken, m entity.AbilityMatcher, abilities, ability string, resource map[string]any) (bool, error) {
+	if ability == "" {
+		return false, nil
+	}
+
+	var vars map[string]any
+	var lastErr error
+	for _, granted := range entity.SplitAbilities(abilities) {
+		pattern, cond := entity.SplitCondition(granted)
+		if m == nil {
+			m = entity.GlobMatcher{}
+		}
+		if !m.Match(pattern, ability) {
+			continue
+		}
+		if cond == "" {
+			return true, nil
+		}
+
+		expr, err := compile(cond)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if vars == nil {
+			vars = conditionVars(tok, resource)
+		}
+		ok, err := expr.Eval(vars)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, lastErr
+}
+
+func conditionVars(tok *entity.PersonalAccessToken, resource map[string]any) map[string]any {
+	name := ""
+	if tok.Name != nil {
+		name = *tok.Name
+	}
+	return map[string]any{
+		"token": map[string]any{
+			"id":      tok.ID,
+			"user_id": tok.UserId,
+			"name":    name,
+			"kind":    tok.Kind,
+			"meta":    tok.Metadata,
+		},
+		"resource": resource,
+	}
+}
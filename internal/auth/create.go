@@ -8,8 +8,28 @@ import (
 )
 
 func CreateToken(opts *TokenOptions) (string, error) {
+	result, err := Issue(opts)
+	if err != nil {
+		return "", err
+	}
+
+	return result.PlainText, nil
+}
+
+// Issue creates an access token and returns it with its expiry
+func Issue(opts *TokenOptions) (*Result, error) {
+	cfg, err := prepareConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewGenerator(opts, cfg).Create()
+}
+
+// prepareConfig resolves and validates the configuration for token issuance
+func prepareConfig(opts *TokenOptions) (*config.Config, error) {
 	if opts == nil {
-		return "", fmt.Errorf("options required")
+		return nil, fmt.Errorf("options required")
 	}
 
 	cfg := opts.Config
@@ -20,20 +40,14 @@ func CreateToken(opts *TokenOptions) (string, error) {
 
 	if cfg.Storage == nil {
 		if opts.DB == nil {
-			return "", fmt.Errorf("DB required to create storage")
+			return nil, fmt.Errorf("DB required to create storage")
 		}
 		cfg.Storage = storage.NewGormDriver(opts.DB)
 	}
 
 	if err := cfg.Validate(); err != nil {
-		return "", fmt.Errorf("invalid config: %w", err)
-	}
-
-	gen := NewGenerator(opts, cfg)
-	result, err := gen.Create()
-	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
-	return result.PlainText, nil
+	return cfg.ForUser(opts.UserId)
 }
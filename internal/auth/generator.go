@@ -2,15 +2,13 @@
 package auth
 
 import (
-	"crypto/rand"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/mohar9h/goauth/config"
 	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
 	"github.com/mohar9h/goauth/internal/utils"
-	"hash/crc32"
-	"strings"
+	"github.com/mohar9h/goauth/tokenformat"
 	"time"
 )
 
@@ -19,8 +17,9 @@ type Generator interface {
 }
 
 type generator struct {
-	opts *TokenOptions
-	cfg  *config.Config
+	opts    *TokenOptions
+	cfg     *config.Config
+	sliding bool // Lifetime is the sliding idle window; set by accessTTL
 }
 
 func NewGenerator(opts *TokenOptions, cfg *config.Config) Generator {
@@ -28,16 +27,135 @@ func NewGenerator(opts *TokenOptions, cfg *config.Config) Generator {
 }
 
 func (g *generator) Create() (*Result, error) {
+	ttl, err := g.accessTTL()
+	if err != nil {
+		return nil, err
+	}
+	if !limited(g.cfg, g.opts) {
+		return g.issue(entity.KindAccess, "", ttl)
+	}
+
+	// Count and insert in one transaction so concurrent issuance can't
+	// overshoot the limits
+	var res *Result
+	err = atomically(g.cfg, func(cfg *config.Config) error {
+		tg := &generator{opts: g.opts, cfg: cfg, sliding: g.sliding}
+		if err := enforceQuotas(cfg, g.opts, 1, 0); err != nil {
+			return err
+		}
+		res, err = tg.issue(entity.KindAccess, "", ttl)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// accessTTL returns the lifetime of the access token being issued: the
+// per-token override from the options, or the configured default. Zero
+// means the token never expires. Only tokens given the default lifetime
+// under sliding expiration are marked to slide.
+func (g *generator) accessTTL() (time.Duration, error) {
+	g.sliding = false
+	switch {
+	case g.opts.ExpiresAt != nil && g.opts.ExpiresIn != 0:
+		return 0, errors.New("token options cannot set both ExpiresIn and ExpiresAt")
+	case g.opts.ExpiresAt != nil:
+		ttl := g.opts.ExpiresAt.Sub(g.cfg.Now())
+		if ttl <= 0 {
+			return 0, errors.New("token expiry must be in the future")
+		}
+		return ttl, nil
+	case g.opts.ExpiresIn == NoExpiry:
+		return 0, nil
+	case g.opts.ExpiresIn < 0:
+		return 0, errors.New("token expiration cannot be negative")
+	case g.opts.ExpiresIn > 0:
+		return g.opts.ExpiresIn, nil
+	}
+	g.sliding = g.cfg.SlidingIdle > 0
+	return g.cfg.AccessTTL(), nil
+}
+
+// issue generates and stores a single token of the given kind, optionally
+// tied to a refresh family, and returns its plaintext form
+func (g *generator) issue(kind, family string, ttl time.Duration) (*Result, error) {
 	if g.cfg.Storage == nil {
 		return nil, errors.New("no storage backend configured")
 	}
 
-	plainText := g.generateTokenString()
-	hashed := utils.HashToken(plainText)
+	plainText, err := g.generateTokenString()
+	if err != nil {
+		return nil, err
+	}
+	hashed, err := g.cfg.HashSecret(plainText)
+	if err != nil {
+		return nil, err
+	}
+
+	t, loc, err := g.record(kind, family, ttl, hashed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		PlainText: tokenformat.Encode(loc, plainText),
+		TokenID:   t.Token,
+		ExpiresAt: t.ExpiresAt,
+	}, nil
+}
+
+// record stores a token with the given secret hash and returns it with
+// its public locator
+func (g *generator) record(kind, family string, ttl time.Duration, hashed string) (*entity.PersonalAccessToken, string, error) {
+	t, err := g.build(kind, family, ttl, hashed)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := g.store(t); err != nil {
+		return nil, "", err
+	}
+
+	loc, err := g.cfg.Locator.Locate(t)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to build token locator: %w", err)
+	}
+	return t, loc, nil
+}
+
+// build validates the options and returns the token record to store
+func (g *generator) build(kind, family string, ttl time.Duration, hashed string) (*entity.PersonalAccessToken, error) {
+	meta, err := buildMetadata(g.opts)
+	if err != nil {
+		return nil, err
+	}
+	// The mark can't be asked for through Metadata, or an explicit expiry
+	// could be renewed past
+	delete(meta, MetaSliding)
+	if g.sliding && kind != entity.KindRefresh {
+		if meta == nil {
+			meta = make(map[string]string, 1)
+		}
+		meta[MetaSliding] = "1"
+	}
+	if err := ValidateAbilities(g.opts.Abilities); err != nil {
+		return nil, err
+	}
+	abilities, err := storedAbilities(g.cfg, g.opts)
+	if err != nil {
+		return nil, err
+	}
 
 	var expireAt *time.Time
-	if g.cfg.ExpireAt > 0 {
-		t := time.Now().Add(g.cfg.ExpireAt)
+	switch {
+	case ttl > 0 && g.opts.ExpiresAt != nil:
+		// Keep the exact time asked for rather than drifting by the TTL round trip
+		t := *g.opts.ExpiresAt
+		expireAt = &t
+	case ttl > 0:
+		t := g.cfg.Now().Add(ttl)
 		expireAt = &t
 	}
 
@@ -45,28 +163,40 @@ func (g *generator) Create() (*Result, error) {
 		UserId:    g.opts.UserId,
 		Name:      g.opts.Name,
 		Token:     hashed,
-		Abilities: strings.Join(g.opts.Abilities, ","),
-		CreatedAt: time.Now(),
+		Abilities: abilities,
+		Kind:      kind,
+		FamilyID:  family,
+		Metadata:  meta,
+		Extra:     g.opts.Extra,
+		CreatedAt: g.cfg.Now(),
 		ExpiresAt: expireAt,
 	}
-
-	if err := g.cfg.Storage.StoreToken(t); err != nil {
-		return nil, err
+	if parent := g.opts.ParentID; parent > 0 {
+		t.ParentID = &parent
 	}
-
-	return &Result{
-		PlainText: fmt.Sprintf("%d|%s", t.ID, plainText),
-		TokenID:   hashed,
-	}, nil
+	return t, nil
 }
 
-func (g *generator) generateTokenString() string {
-	buf := make([]byte, g.cfg.TokenLength)
-	if _, err := rand.Read(buf); err != nil {
-		panic("token generation failed: " + err.Error())
+// store inserts t, or upserts it into the user's named slot when
+// TokenOptions.Replace is set
+func (g *generator) store(t *entity.PersonalAccessToken) error {
+	if !g.opts.Replace {
+		return g.cfg.Storage.StoreToken(t)
+	}
+	if t.Name == nil || *t.Name == "" {
+		return errors.New("replace requires a token name")
 	}
-	raw := hex.EncodeToString(buf)
 
-	crc := crc32.Checksum([]byte(raw), crc32.MakeTable(crc32.Castagnoli))
-	return fmt.Sprintf("%s%s%x", g.cfg.TokenPrefix, raw, crc)
+	up, ok := g.cfg.Storage.(storage.Upserter)
+	if !ok {
+		return utils.ErrNotSupported
+	}
+	t.UniqueName = t.Name
+	return up.UpsertToken(t)
+}
+
+// generateTokenString returns a new secret. A failing entropy source fails
+// the issuance rather than the process.
+func (g *generator) generateTokenString() (string, error) {
+	return g.cfg.TokenFormat().GenerateFrom(g.cfg.Random())
 }
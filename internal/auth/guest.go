@@ -0,0 +1,72 @@
+// Package auth internal/auth/guest.go
+package auth
+
+import (
+	"fmt"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// CreateGuestToken issues a token not bound to any user. Abilities default
+// to the configured guest abilities when none are requested.
+func CreateGuestToken(opts *TokenOptions) (string, error) {
+	cfg, err := prepareConfig(opts)
+	if err != nil {
+		return "", err
+	}
+
+	guest := *opts
+	guest.UserId = 0
+	if len(guest.Abilities) == 0 {
+		guest.Abilities = cfg.GuestAbilities
+	}
+
+	g := &generator{opts: &guest, cfg: cfg}
+	ttl, err := g.accessTTL()
+	if err != nil {
+		return "", err
+	}
+	result, err := g.issue(entity.KindGuest, "", ttl)
+	if err != nil {
+		return "", err
+	}
+	return result.PlainText, nil
+}
+
+// PromoteGuestToken rebinds a valid guest token to a registered user. The
+// plaintext stays the same so the client can keep using it.
+func PromoteGuestToken(raw string, userID int64, cfg *config.Config) (*entity.PersonalAccessToken, error) {
+	tok, cfg, err := validate(raw, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !tok.IsGuest() {
+		return nil, fmt.Errorf("token is not a guest token")
+	}
+
+	// The plaintext names the token's storage, so it cannot move regions
+	home, err := cfg.ForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if home.Region != cfg.Region {
+		return nil, fmt.Errorf("%w: user %d is pinned to %q", utils.ErrCrossRegion, userID, home.Region)
+	}
+
+	stored, err := cfg.Storage.FindByID(tok.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	promoted := *stored
+	promoted.UserId = userID
+	promoted.Kind = entity.KindAccess
+	if err := cfg.Storage.UpdateToken(&promoted); err != nil {
+		return nil, fmt.Errorf("failed to promote guest token: %w", err)
+	}
+
+	return &promoted, nil
+}
@@ -0,0 +1,98 @@
+// Package auth internal/auth/hashing.go
+package auth
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/locator"
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/phc"
+)
+
+// FindBySecret looks up the token a secret belongs to and checks the
+// secret against its stored hash. Salted hashes differ per row, so those
+// tokens are found through their ID locator; otherwise the hash is the
+// lookup key, falling back to hashes from a hasher's earlier keys (see
+// phc.Lookups) and then to the compact SHA-256 form rows had before a
+// hasher was configured. Under cfg.NoLegacyHashes only rows written by the
+// hasher are accepted.
+func FindBySecret(cfg *config.Config, loc, secret string) (*entity.PersonalAccessToken, error) {
+	h := cfg.Hasher
+	if h == nil {
+		hashed := utils.HashToken(secret)
+		tok, err := cfg.Storage.FindByHash(hashed)
+		if err != nil {
+			return nil, err
+		}
+		if tok.Token != hashed {
+			return nil, ErrTokenInvalid
+		}
+		return tok, nil
+	}
+
+	var tok *entity.PersonalAccessToken
+	var err error
+	if h.Salted() {
+		_, inner := locator.SplitRegion(loc)
+		id, perr := strconv.ParseInt(inner, 10, 64)
+		if perr != nil || id <= 0 {
+			return nil, utils.ErrTokenInvalidFormat
+		}
+		tok, err = cfg.Storage.FindByID(id)
+	} else {
+		key, herr := h.Hash(secret)
+		if herr != nil {
+			return nil, herr
+		}
+		tok, err = cfg.Storage.FindByHash(key)
+		// Rows written before a hasher change, e.g. under a rotated
+		// pepper or in the compact form
+		var older []string
+		if l, ok := h.(phc.Lookups); ok {
+			older = l.LookupHashes(secret)
+		}
+		if !cfg.NoLegacyHashes {
+			older = append(older, utils.HashToken(secret))
+		}
+		for _, key := range older {
+			if !errors.Is(err, utils.ErrTokenNotFound) {
+				break
+			}
+			tok, err = cfg.Storage.FindByHash(key)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.NoLegacyHashes {
+		if p, err := phc.Parse(tok.Token); err != nil || p.ID != h.ID() {
+			return nil, ErrTokenInvalid
+		}
+	}
+	if ok, err := phc.VerifyWith(h, secret, tok.Token); err != nil || !ok {
+		return nil, ErrTokenInvalid
+	}
+	return rehash(cfg, tok, secret), nil
+}
+
+// rehash rewrites a row hashed by another hasher or with other parameters
+// using the configured one, so a hasher change migrates tokens as they are
+// used. Failures leave the old hash in place for the next attempt.
+func rehash(cfg *config.Config, tok *entity.PersonalAccessToken, secret string) *entity.PersonalAccessToken {
+	if !phc.NeedsRehash(cfg.Hasher, tok.Token) {
+		return tok
+	}
+	hashed, err := cfg.Hasher.Hash(secret)
+	if err == nil {
+		cp := *tok
+		cp.Token = hashed
+		if err = cfg.Storage.UpdateToken(&cp); err == nil {
+			return &cp
+		}
+	}
+	cfg.Logger.Warn("failed to rehash token", "token_id", tok.ID, "error", err)
+	return tok
+}
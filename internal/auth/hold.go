@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// Holds placed on a token by an automated response. The value names the
+// detector that triggered it.
+const (
+	MetaSuspended = "goauth.suspended"
+	MetaStepUp    = "goauth.step_up"
+)
+
+// MetaMFAPending marks a token issued at login before the user's second
+// factor was checked. The value names the factor.
+const MetaMFAPending = "goauth.mfa_pending"
+
+// MetaOneTime marks a single-use token, only accepted by ConsumeOneTime.
+// The value names its purpose.
+const MetaOneTime = "goauth.one_time"
+
+// CheckHold rejects a token that is suspended, awaiting step-up or a
+// second factor, or only good for one use
+func CheckHold(tok *entity.PersonalAccessToken) error {
+	if tok.Metadata[MetaSuspended] != "" {
+		return utils.ErrTokenSuspended
+	}
+	if tok.Metadata[MetaStepUp] != "" {
+		return utils.ErrStepUpRequired
+	}
+	if tok.Metadata[MetaMFAPending] != "" {
+		return utils.ErrMFARequired
+	}
+	if tok.Metadata[MetaOneTime] != "" {
+		return utils.ErrOneTimeToken
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Package auth internal/auth/import.go
+package auth
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/tokenformat"
+)
+
+// Strength policy applied to imported secrets when the config leaves it unset
+const (
+	DefaultImportMinLength  = 16
+	DefaultImportMinEntropy = 64 // bits
+)
+
+// ImportOptions describes an externally generated credential to register.
+type ImportOptions struct {
+	TokenOptions
+	Hashed bool // The credential is already the hex SHA-256 digest of the secret
+}
+
+// ImportToken stores a pre-generated secret (or its hash) as an access
+// token. For plaintext secrets the result holds the full "locator|secret"
+// token; for hashes it holds only the "locator|" prefix to put in front of
+// the secret.
+func ImportToken(credential string, opts *ImportOptions) (*Result, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("options required")
+	}
+
+	cfg, err := prepareConfig(&opts.TokenOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, hashed := credential, ""
+	if opts.Hashed {
+		secret, hashed = "", strings.ToLower(credential)
+		if !isHexDigest(hashed) {
+			return nil, fmt.Errorf("%w: hash must be a hex SHA-256 digest", utils.ErrTokenInvalidFormat)
+		}
+	} else {
+		if err := checkStrength(secret, cfg.ImportMinLength, cfg.ImportMinEntropy); err != nil {
+			return nil, err
+		}
+		if hashed, err = cfg.HashSecret(secret); err != nil {
+			return nil, err
+		}
+	}
+
+	g := &generator{opts: &opts.TokenOptions, cfg: cfg}
+	ttl, err := g.accessTTL()
+	if err != nil {
+		return nil, err
+	}
+	t, loc, err := g.record(entity.KindAccess, "", ttl, hashed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		PlainText: tokenformat.Encode(loc, secret),
+		TokenID:   t.Token,
+		ExpiresAt: t.ExpiresAt,
+	}, nil
+}
+
+// checkStrength rejects secrets that are too short, can't be represented
+// in the "locator|secret" format, or look too predictable
+func checkStrength(secret string, minLength int, minEntropy float64) error {
+	if minLength <= 0 {
+		minLength = DefaultImportMinLength
+	}
+	if minEntropy <= 0 {
+		minEntropy = DefaultImportMinEntropy
+	}
+
+	if strings.ContainsAny(secret, "| \t\r\n") {
+		return fmt.Errorf("%w: secret contains separators or whitespace", utils.ErrTokenInvalidFormat)
+	}
+	if n := len(secret); n < minLength {
+		return fmt.Errorf("%w: %d characters, need %d", utils.ErrWeakToken, n, minLength)
+	}
+	if bits := EstimateEntropy(secret); bits < minEntropy {
+		return fmt.Errorf("%w: about %.0f bits of entropy, need %.0f", utils.ErrWeakToken, bits, minEntropy)
+	}
+	return nil
+}
+
+// EstimateEntropy returns a conservative strength estimate in bits: length
+// times log2 of the alphabet implied by the character classes used, where
+// the alphabet is capped by the number of distinct characters so repeated
+// patterns score low.
+func EstimateEntropy(s string) float64 {
+	var lower, upper, digit, other bool
+	distinct := make(map[rune]struct{})
+	n := 0
+	for _, r := range s {
+		distinct[r] = struct{}{}
+		n++
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	alphabet := 0
+	for _, c := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {other, 33}} {
+		if c.used {
+			alphabet += c.size
+		}
+	}
+	alphabet = min(alphabet, max(len(distinct), 2))
+	return float64(n) * math.Log2(float64(alphabet))
+}
+
+func isHexDigest(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
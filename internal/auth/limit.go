@@ -0,0 +1,87 @@
+// Package auth internal/auth/limit.go
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// enforceLimit makes room for adding more access tokens of the user under
+// cfg.MaxTokensPerUser, rejecting the issuance or evicting the least
+// recently used tokens per cfg.TokenLimitPolicy. Replacing a named slot
+// never adds a token, and rotation and refresh are not limited since they
+// supersede an existing token. Issuance runs the check in the same
+// transaction as the insert, locking the user where the driver can, so
+// concurrent issuance can't exceed the limit; drivers without transactions
+// may briefly exceed it.
+func enforceLimit(cfg *config.Config, opts *TokenOptions, adding int) error {
+	if cfg.MaxTokensPerUser <= 0 || opts.UserId <= 0 || opts.Replace {
+		return nil
+	}
+
+	if locker, ok := cfg.Storage.(storage.UserLocker); ok {
+		if err := locker.LockUser(opts.UserId); err != nil {
+			return fmt.Errorf("token limit: %w", err)
+		}
+	}
+
+	counter, ok := cfg.Storage.(storage.UserCounter)
+	if !ok {
+		return fmt.Errorf("token limit: %w", utils.ErrNotSupported)
+	}
+	n, err := counter.CountByUser(opts.UserId)
+	if err != nil {
+		return fmt.Errorf("token limit: %w", err)
+	}
+
+	excess := n + int64(adding) - int64(cfg.MaxTokensPerUser)
+	if excess <= 0 {
+		return nil
+	}
+	if cfg.TokenLimitPolicy != config.TokenLimitEvictLRU || adding > cfg.MaxTokensPerUser {
+		return utils.ErrTokenLimitReached
+	}
+	return evictLRU(cfg, opts.UserId, excess)
+}
+
+// evictLRU revokes the user's n least recently used live access tokens
+func evictLRU(cfg *config.Config, userID int64, n int64) error {
+	tokens, _, err := cfg.Storage.FindByUser(userID, storage.ListOptions{Status: storage.StatusActive})
+	if err != nil {
+		return fmt.Errorf("token limit: %w", err)
+	}
+
+	live := tokens[:0]
+	for _, t := range tokens {
+		if t.Kind == "" || t.Kind == entity.KindAccess {
+			live = append(live, t)
+		}
+	}
+	lastUsed := func(t *entity.PersonalAccessToken) time.Time {
+		if t.LastUsedAt != nil {
+			return *t.LastUsedAt
+		}
+		return t.CreatedAt
+	}
+	sort.SliceStable(live, func(i, j int) bool { return lastUsed(live[i]).Before(lastUsed(live[j])) })
+
+	now := cfg.Now()
+	for _, t := range live[:min(int(n), len(live))] {
+		if cfg.SoftRevocation {
+			err = cfg.Storage.MarkRevoked(t.Token, now)
+		} else {
+			err = cfg.Storage.RevokeToken(t.Token)
+		}
+		if err != nil {
+			return fmt.Errorf("evict token %d: %w", t.ID, err)
+		}
+		cfg.Logger.Info("evicted least recently used token", "user_id", userID, "token_id", t.ID)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// ConsumeOneTime validates a one-time token and revokes it in a single
+// conditional write, so of concurrent calls with the same token exactly
+// one succeeds; the others get ErrTokenRevoked.
+func ConsumeOneTime(raw string, cfg *config.Config) (*entity.PersonalAccessToken, error) {
+	tok, cfg, err := validateAs(raw, cfg, utils.ErrOneTimeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	now := cfg.Now()
+	if err := cfg.Storage.MarkRevoked(tok.Token, now); err != nil {
+		return nil, err
+	}
+	cp := *tok
+	cp.RevokedAt = &now
+	return &cp, nil
+}
@@ -2,14 +2,39 @@
 package auth
 
 import (
+	"time"
+
 	"github.com/mohar9h/goauth/config"
 	"gorm.io/gorm"
 )
 
+// NoExpiry, set as TokenOptions.ExpiresIn, issues a token that never
+// expires regardless of the client's configured lifetime.
+const NoExpiry time.Duration = -1
+
 type TokenOptions struct {
-	UserId    int64
-	Name      *string
-	Abilities []string
-	Config    *config.Config
-	DB        *gorm.DB // Required for GORM storage
+	UserId             int64
+	Name               *string
+	Replace            bool // Replace the user's existing token with the same Name instead of adding one
+	Abilities          []string
+	Roles              []string          // Roles whose abilities the token gains, stored as "role:<name>"
+	Metadata           map[string]string // Free-form key/value data stored with the token
+	APIVersions        *VersionRange     // Restrict the token to a range of API versions
+	Features           []string          // Restrict the token to these feature flags
+	BoundIP            string            // Only accept the token from this IP or CIDR range
+	BoundUserAgentHash string            // Only accept the token from this user agent (see HashUserAgent)
+	BoundCertHash      string            // Only accept the token over mTLS with this client certificate (see HashCertificate)
+	ExpiresIn          time.Duration     // Overrides the client's token lifetime; NoExpiry for a token that never expires
+	ExpiresAt          *time.Time        // Expires the token at this time instead; exclusive with ExpiresIn
+	ParentID           int64             // Token this one derives from; it stops validating once the parent does
+	Extra              map[string]any    // Application fields stored by the configured EntityExtender
+	Config             *config.Config
+	DB                 *gorm.DB // Required for GORM storage
+}
+
+// VersionRange is an inclusive range of dotted numeric API versions
+// (e.g. "1", "2.3"). An empty bound is open.
+type VersionRange struct {
+	Min string
+	Max string
 }
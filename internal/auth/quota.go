@@ -0,0 +1,124 @@
+// Package auth internal/auth/quota.go
+package auth
+
+import (
+	"fmt"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// enforceQuotas applies the per-user limit and the quotas of the user's
+// orgs before access tokens and refresh tokens (sessions) are added
+func enforceQuotas(cfg *config.Config, opts *TokenOptions, access, sessions int) error {
+	if err := enforceLimit(cfg, opts, access); err != nil {
+		return err
+	}
+	if opts.Replace {
+		access = 0
+	}
+	return enforceOrgQuotas(cfg, opts.UserId, access, sessions)
+}
+
+// enforceOrgQuotas rejects the issuance with a QuotaError when it would
+// take one of the user's orgs past a quota, and calls cfg.QuotaHook for
+// each quota it brings near its limit. It is checked in the transaction
+// of the insert, but only the issuing user is locked, so concurrent
+// issuance by other members may overshoot by a few tokens outside SQLite.
+func enforceOrgQuotas(cfg *config.Config, userID int64, access, sessions int) error {
+	if cfg.OrgQuotas == nil || userID <= 0 || access+sessions == 0 {
+		return nil
+	}
+
+	store, ok := cfg.Storage.(storage.OrgStore)
+	counter, ok2 := cfg.Storage.(storage.OrgCounter)
+	if !ok || !ok2 {
+		return fmt.Errorf("org quota: %w", utils.ErrNotSupported)
+	}
+	orgs, err := UserOrgs(store, userID)
+	if err != nil {
+		return fmt.Errorf("org quota: %w", err)
+	}
+
+	var near []config.QuotaUsage
+	for _, org := range orgs {
+		quota, err := cfg.OrgQuotas(org)
+		if err != nil {
+			return fmt.Errorf("org quota: %w", err)
+		}
+		checks := []struct {
+			name   string
+			kind   string
+			limit  int64
+			adding int
+		}{
+			{config.QuotaActiveTokens, entity.KindAccess, quota.MaxActiveTokens, access},
+			{config.QuotaSessions, entity.KindRefresh, quota.MaxSessions, sessions},
+		}
+		for _, c := range checks {
+			if c.limit <= 0 || c.adding == 0 {
+				continue
+			}
+			used, err := counter.CountByOrg(org, c.kind)
+			if err != nil {
+				return fmt.Errorf("org quota: %w", err)
+			}
+			if used+int64(c.adding) > c.limit {
+				return &utils.QuotaError{OrgID: org, Quota: c.name, Limit: c.limit, Used: used}
+			}
+			if after := used + int64(c.adding); float64(after) >= warnRatio(cfg)*float64(c.limit) {
+				near = append(near, config.QuotaUsage{OrgID: org, Quota: c.name, Used: after, Limit: c.limit})
+			}
+		}
+	}
+
+	if cfg.QuotaHook != nil {
+		for _, u := range near {
+			cfg.Workers.Go("quota-hook", func() error {
+				cfg.QuotaHook(u)
+				return nil
+			})
+		}
+	}
+	return nil
+}
+
+func warnRatio(cfg *config.Config) float64 {
+	if cfg.QuotaWarnRatio > 0 {
+		return cfg.QuotaWarnRatio
+	}
+	return config.DefaultQuotaWarnRatio
+}
+
+// UserOrgs returns the top-level orgs above the units the user belongs to.
+// A parent loop in the stored hierarchy is cut at the first repeated unit.
+func UserOrgs(store storage.OrgStore, userID int64) ([]int64, error) {
+	direct, err := store.UserOrgUnits(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var orgs []int64
+	found := make(map[int64]bool)
+	for _, id := range direct {
+		seen := make(map[int64]bool)
+		for !seen[id] {
+			seen[id] = true
+			unit, err := store.FindOrgUnit(id)
+			if err != nil {
+				return nil, err
+			}
+			if unit.ParentID == nil {
+				if !found[id] {
+					found[id] = true
+					orgs = append(orgs, id)
+				}
+				break
+			}
+			id = *unit.ParentID
+		}
+	}
+	return orgs, nil
+}
@@ -0,0 +1,151 @@
+// Package auth internal/auth/refresh.go
+package auth
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// CreateTokenPair issues an access token plus a longer-lived refresh token
+// that starts a new rotation family.
+func CreateTokenPair(opts *TokenOptions) (*PairResult, error) {
+	cfg, err := prepareConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	family, err := newFamilyID(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Either both tokens are stored, within the limits, or neither is
+	var res *PairResult
+	err = atomically(cfg, func(cfg *config.Config) error {
+		if err := enforceQuotas(cfg, opts, 1, 1); err != nil {
+			return err
+		}
+		res, err = issuePair(&generator{opts: opts, cfg: cfg}, family)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RefreshToken exchanges a refresh token for a new pair and invalidates the
+// presented one. Replaying an already-rotated refresh token revokes every
+// token in its family and returns ErrRefreshTokenReused.
+func RefreshToken(raw string, cfg *config.Config) (*PairResult, error) {
+	if cfg == nil || cfg.Storage == nil {
+		return nil, utils.ErrStorageDriverNil
+	}
+	cfg.ApplyDefaults()
+
+	locator, secret, ok := strings.Cut(raw, "|")
+	if !ok || secret == "" || strings.Contains(secret, "|") {
+		return nil, utils.ErrTokenInvalidFormat
+	}
+
+	cfg, err := cfg.ForLocator(locator)
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := FindBySecret(cfg, locator, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if !tok.IsRefresh() || !cfg.Locator.Verify(locator, tok) {
+		return nil, ErrTokenInvalid
+	}
+
+	if tok.RevokedAt != nil {
+		return nil, revokeFamily(cfg, tok)
+	}
+
+	// The presented token is only used up when its successors are stored
+	var (
+		res    *PairResult
+		marked bool
+	)
+	err = atomically(cfg, func(tcfg *config.Config) error {
+		if err := tcfg.Storage.MarkRevoked(tok.Token, tcfg.Now()); err != nil {
+			return err
+		}
+		marked = true
+
+		opts := &TokenOptions{
+			UserId:    tok.UserId,
+			Name:      tok.Name,
+			Abilities: carriedAbilities(cfg, tok),
+			Metadata:  tok.Metadata,
+			Config:    cfg,
+		}
+		res, err = issuePair(&generator{opts: opts, cfg: tcfg}, tok.FamilyID)
+		return err
+	})
+	switch {
+	case err == nil:
+		return res, nil
+	case marked:
+		return nil, err
+	case errors.Is(err, utils.ErrTokenRevoked):
+		// Lost a race with a concurrent refresh of the same token
+		return nil, revokeFamily(cfg, tok)
+	}
+	return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+}
+
+func issuePair(g *generator, family string) (*PairResult, error) {
+	ttl, err := g.accessTTL()
+	if err != nil {
+		return nil, err
+	}
+	access, err := g.issue(entity.KindAccess, family, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := g.issue(entity.KindRefresh, family, g.cfg.RefreshExpireAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PairResult{
+		AccessToken:      access.PlainText,
+		RefreshToken:     refresh.PlainText,
+		AccessExpiresAt:  access.ExpiresAt,
+		RefreshExpiresAt: refresh.ExpiresAt,
+	}, nil
+}
+
+func revokeFamily(cfg *config.Config, tok *entity.PersonalAccessToken) error {
+	if _, err := cfg.Storage.RevokeFamily(tok.FamilyID, cfg.Now()); err != nil {
+		return fmt.Errorf("%w: failed to revoke token family: %v", utils.ErrRefreshTokenReused, err)
+	}
+	cfg.Logger.Warn("refresh token reuse detected, revoked token family",
+		"user_id", tok.UserId, "family_id", tok.FamilyID)
+	return utils.ErrRefreshTokenReused
+}
+
+func newFamilyID(cfg *config.Config) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(cfg.Random(), buf); err != nil {
+		return "", fmt.Errorf("failed to generate token family: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func splitAbilities(s string) []string {
+	return entity.SplitAbilities(s)
+}
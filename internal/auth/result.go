@@ -1,8 +1,34 @@
 // Package auth internal/auth/result.go
 package auth
 
+import "time"
+
 // Result TokenResult is the result of a successful token creation.
 type Result struct {
 	PlainText string // what the client receives
 	TokenID   string // internal hashed ID for storage
+	ExpiresAt *time.Time
+
+	// MFARequired is set by Login when PlainText is a pending token, to be
+	// passed to CompleteMFA with the user's code
+	MFARequired bool
+}
+
+// PairResult is an access token together with the refresh token that can
+// be exchanged for its successor.
+type PairResult struct {
+	AccessToken      string
+	RefreshToken     string
+	AccessExpiresAt  *time.Time
+	RefreshExpiresAt *time.Time
+}
+
+// Rotation is the result of RotateToken.
+type Rotation struct {
+	PlainText string     // The successor token, for the client
+	ID        int64      // Storage ID of the successor
+	ExpiresAt *time.Time // Expiry of the successor
+	OldID     int64      // Storage ID of the rotated token, for audit logs
+	UserID    int64      // Owner of both tokens, for audit logs
+	RevokeAt  time.Time  // When the rotated token stops being accepted
 }
@@ -3,18 +3,27 @@ package auth
 
 import (
 	"fmt"
+
 	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
 )
 
-func RevokeToken(raw string, cfg *config.Config) error {
-	token, err := ValidateToken(raw, cfg)
+// RevokeToken deletes the token, or marks it revoked under soft revocation
+// so its record is kept for auditing, and returns the revoked record
+func RevokeToken(raw string, cfg *config.Config) (*entity.PersonalAccessToken, error) {
+	token, cfg, err := lookup(raw, cfg, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if err = cfg.Storage.RevokeToken(token.Token); err != nil {
-		return fmt.Errorf("failed to revoke token: %w", err)
+	if cfg.SoftRevocation {
+		err = cfg.Storage.MarkRevoked(token.Token, cfg.Now())
+	} else {
+		err = cfg.Storage.RevokeToken(token.Token)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke token: %w", err)
 	}
 
-	return nil
+	return token, nil
 }
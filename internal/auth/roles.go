@@ -0,0 +1,77 @@
+// Package auth internal/auth/roles.go
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// storedAbilities returns the abilities string to store for opts: its
+// abilities followed by a reference to each of its roles, which must exist
+func storedAbilities(cfg *config.Config, opts *TokenOptions) (string, error) {
+	list := opts.Abilities
+	if len(opts.Roles) > 0 {
+		list = slices.Clone(opts.Abilities)
+		for _, role := range opts.Roles {
+			if !cfg.Roles.Has(role) {
+				return "", fmt.Errorf("%w: %s", utils.ErrRoleNotFound, role)
+			}
+			list = append(list, config.RolePrefix+role)
+		}
+	}
+
+	// An empty string would read as a legacy row and get the ability policy
+	if s := strings.Join(list, ","); s != "" {
+		return s, nil
+	}
+	return entity.NoAbilities, nil
+}
+
+// carriedAbilities returns the abilities a successor of tok is issued with.
+// A legacy token passes on what the ability policy grants it, since the
+// successor is no longer legacy.
+func carriedAbilities(cfg *config.Config, tok *entity.PersonalAccessToken) []string {
+	if strings.TrimSpace(tok.Abilities) == "" {
+		return splitAbilities(cfg.EffectiveAbilities(tok.Abilities))
+	}
+	return splitAbilities(tok.Abilities)
+}
+
+// AssignRole adds a reference to role to the stored abilities of the token
+// raw. Assigning a role the token already holds is a no-op.
+func AssignRole(raw, role string, cfg *config.Config) (*entity.PersonalAccessToken, error) {
+	if !cfg.Roles.Has(role) {
+		return nil, fmt.Errorf("%w: %s", utils.ErrRoleNotFound, role)
+	}
+
+	valid, cfg, err := validate(raw, cfg)
+	if err != nil {
+		return nil, err
+	}
+	// validate returns abilities with roles expanded; update the record
+	tok, err := cfg.Storage.FindByID(valid.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := config.RolePrefix + role
+	for _, a := range entity.SplitAbilities(tok.Abilities) {
+		if strings.TrimSpace(a) == ref {
+			return tok, nil
+		}
+	}
+	if tok.Abilities == "" || tok.Abilities == entity.NoAbilities {
+		tok.Abilities = ref
+	} else {
+		tok.Abilities += "," + ref
+	}
+	if err := cfg.Storage.UpdateToken(tok); err != nil {
+		return nil, fmt.Errorf("failed to assign role: %w", err)
+	}
+	return tok, nil
+}
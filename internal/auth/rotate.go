@@ -0,0 +1,175 @@
+// Package auth internal/auth/rotate.go
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/tokenformat"
+)
+
+// MetaRotatedTo records the ID of the successor on a token that was rotated
+// with a grace window. Such tokens are not rotated again or renewed by
+// sliding expiration.
+const MetaRotatedTo = "goauth.rotated_to"
+
+// RotateToken issues a successor for the access token raw with the same
+// user, name, abilities, metadata and remaining lifetime, then revokes raw.
+// With a positive grace the old token instead stays valid until
+// now+grace (or its own expiry, if sooner), so in-flight clients can switch
+// over. Tokens in a named slot (TokenOptions.Replace) are replaced in
+// place and cannot have a grace window.
+func RotateToken(raw string, grace time.Duration, cfg *config.Config) (*Rotation, error) {
+	if cfg == nil || cfg.Storage == nil {
+		return nil, utils.ErrStorageDriverNil
+	}
+	cfg.ApplyDefaults()
+
+	// A sliding renewal landing after the grace window is set would undo it
+	vcfg := *cfg
+	vcfg.SlidingIdle = 0
+	valid, routed, err := validate(raw, &vcfg)
+	if err != nil {
+		return nil, err
+	}
+	if routed.Region != "" {
+		rcfg := *routed
+		rcfg.SlidingIdle = cfg.SlidingIdle
+		cfg = &rcfg
+	}
+	if valid.Metadata[MetaRotatedTo] != "" {
+		return nil, utils.ErrTokenRotated
+	}
+
+	// Validation resolves policy abilities on a copy; rotate the stored record
+	old, err := cfg.Storage.FindByID(valid.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := cfg.Now()
+	var ttl time.Duration
+	if old.ExpiresAt != nil {
+		if ttl = old.ExpiresAt.Sub(now); ttl <= 0 {
+			return nil, utils.ErrTokenExpired
+		}
+	}
+
+	opts := &TokenOptions{
+		UserId:    old.UserId,
+		Name:      old.Name,
+		Replace:   old.UniqueName != nil,
+		Abilities: carriedAbilities(cfg, old),
+		Metadata:  old.Metadata,
+		Extra:     old.Extra,
+		Config:    cfg,
+	}
+	if old.ParentID != nil {
+		opts.ParentID = *old.ParentID
+	}
+	g := &generator{opts: opts, cfg: cfg, sliding: old.Metadata[MetaSliding] != ""}
+	secret, err := g.generateTokenString()
+	if err != nil {
+		return nil, err
+	}
+	hashed, err := cfg.HashSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the successor and revoke the old token together. Drivers
+	// without transactions get the successor revoked again on failure.
+	var (
+		rot  *Rotation
+		next *entity.PersonalAccessToken
+	)
+	err = atomically(cfg, func(tcfg *config.Config) error {
+		tg := &generator{opts: opts, cfg: tcfg, sliding: g.sliding}
+		var loc string
+		next, loc, err = tg.record(old.Kind, old.FamilyID, ttl, hashed)
+		if err != nil {
+			return err
+		}
+
+		rot = &Rotation{
+			PlainText: tokenformat.Encode(loc, secret),
+			ID:        next.ID,
+			ExpiresAt: next.ExpiresAt,
+			OldID:     old.ID,
+			UserID:    old.UserId,
+			RevokeAt:  now,
+		}
+		switch {
+		case opts.Replace:
+			// The upsert already overwrote the old hash
+			return nil
+		case grace > 0:
+			err = scheduleRevocation(tcfg, old.ID, next.ID, now.Add(grace), rot)
+		default:
+			err = tcfg.Storage.MarkRevoked(old.Token, now)
+		}
+		if err != nil {
+			return &revokeError{err}
+		}
+		return nil
+	})
+
+	var rerr *revokeError
+	if !errors.As(err, &rerr) {
+		if err != nil {
+			return nil, err
+		}
+		return rot, nil
+	}
+	if !storage.Transactional(cfg.Storage) {
+		// Don't leave a second live token behind, e.g. after losing a race
+		// with a concurrent rotation
+		if err := cfg.Storage.RevokeToken(next.Token); err != nil {
+			cfg.Logger.Warn("failed to revoke successor of unrotated token", "token_id", next.ID, "error", err)
+		}
+	}
+	if errors.Is(rerr.err, utils.ErrTokenRevoked) {
+		return nil, utils.ErrTokenRotated
+	}
+	return nil, fmt.Errorf("failed to revoke rotated token: %w", rerr.err)
+}
+
+// revokeError marks a failure to revoke the token being rotated, as
+// opposed to one storing its successor
+type revokeError struct {
+	err error
+}
+
+func (e *revokeError) Error() string { return e.err.Error() }
+func (e *revokeError) Unwrap() error { return e.err }
+
+// scheduleRevocation shortens the old token's expiry to the end of the
+// grace window and marks it as rotated.
+func scheduleRevocation(cfg *config.Config, oldID, nextID int64, end time.Time, rot *Rotation) error {
+	old, err := cfg.Storage.FindByID(oldID)
+	if err != nil {
+		return err
+	}
+	if old.Metadata[MetaRotatedTo] != "" || old.RevokedAt != nil {
+		return utils.ErrTokenRevoked
+	}
+
+	cp := *old
+	if cp.ExpiresAt != nil && cp.ExpiresAt.Before(end) {
+		end = *cp.ExpiresAt
+	}
+	cp.ExpiresAt = &end
+	cp.Metadata = make(map[string]string, len(old.Metadata)+1)
+	maps.Copy(cp.Metadata, old.Metadata)
+	cp.Metadata[MetaRotatedTo] = strconv.FormatInt(nextID, 10)
+
+	rot.RevokeAt = end
+	return cfg.Storage.UpdateToken(&cp)
+}
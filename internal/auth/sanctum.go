@@ -0,0 +1,68 @@
+// Package auth internal/auth/sanctum.go
+package auth
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/locator"
+)
+
+// Laravel Sanctum hashes the plaintext after "|" with SHA-256 just like
+// goauth, but it also issues bare tokens without an "id|" segment and stores
+// abilities as a JSON array. While cfg.SanctumCompat is enabled both shapes
+// are accepted and records are rewritten to the canonical format.
+
+// splitSanctum splits raw into locator and secret, treating a bare token as
+// a Sanctum token without an ID segment.
+func splitSanctum(raw string) (string, string, bool) {
+	if !strings.Contains(raw, "|") {
+		return "", raw, raw != ""
+	}
+	loc, secret, _ := strings.Cut(raw, "|")
+	return loc, secret, !strings.Contains(secret, "|")
+}
+
+// verifySanctumLocator accepts a missing or numeric-ID locator in addition
+// to the configured one.
+func verifySanctumLocator(cfg *config.Config, loc string, tok *entity.PersonalAccessToken) bool {
+	return loc == "" || cfg.Locator.Verify(loc, tok) || (locator.ID{}).Verify(loc, tok)
+}
+
+// migrateSanctum rewrites a Sanctum-shaped record to the canonical goauth
+// format. It returns the normalized copy and whether anything changed.
+func migrateSanctum(tok *entity.PersonalAccessToken) (*entity.PersonalAccessToken, bool) {
+	cp := *tok
+	changed := false
+
+	if abilities, ok := parseSanctumAbilities(tok.Abilities); ok {
+		cp.Abilities = abilities
+		changed = true
+	}
+	if cp.Kind == "" {
+		cp.Kind = entity.KindAccess
+		changed = true
+	}
+
+	return &cp, changed
+}
+
+// parseSanctumAbilities converts a JSON array like ["read","write"] into the
+// comma-joined form. ok is false if the value isn't a JSON array.
+func parseSanctumAbilities(stored string) (string, bool) {
+	s := strings.TrimSpace(stored)
+	if !strings.HasPrefix(s, "[") {
+		return "", false
+	}
+
+	var abilities []string
+	if err := json.Unmarshal([]byte(s), &abilities); err != nil {
+		return "", false
+	}
+	if len(abilities) == 0 {
+		return entity.NoAbilities, true
+	}
+	return strings.Join(abilities, ","), true
+}
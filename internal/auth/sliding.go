@@ -0,0 +1,43 @@
+// Package auth internal/auth/sliding.go
+package auth
+
+import (
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// MetaSliding marks a token issued with the sliding idle window as its
+// lifetime. Tokens given an explicit expiry are never marked.
+const MetaSliding = "goauth.sliding"
+
+// slide renews the token's expiry to now+idle, capped at CreatedAt+MaxLifetime.
+// Renewals smaller than 5% of the idle window are skipped to avoid a storage
+// write on every request. Only tokens marked with MetaSliding are renewed,
+// and never while in a rotation grace window or under a hold. Returns the
+// (possibly updated) token copy.
+func slide(cfg *config.Config, tok *entity.PersonalAccessToken, now time.Time) *entity.PersonalAccessToken {
+	if cfg.SlidingIdle <= 0 || tok.ExpiresAt == nil || tok.Metadata[MetaSliding] == "" ||
+		tok.Metadata[MetaRotatedTo] != "" || CheckHold(tok) != nil {
+		return tok
+	}
+
+	next := now.Add(cfg.SlidingIdle)
+	if cfg.MaxLifetime > 0 {
+		if limit := tok.CreatedAt.Add(cfg.MaxLifetime); next.After(limit) {
+			next = limit
+		}
+	}
+
+	if next.Sub(*tok.ExpiresAt) < cfg.SlidingIdle/20 {
+		return tok
+	}
+
+	cp := *tok
+	cp.ExpiresAt = &next
+	cfg.Workers.Go("sliding-expiry", func() error {
+		return cfg.Storage.UpdateExpiry(cp.ID, next)
+	})
+	return &cp
+}
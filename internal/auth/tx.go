@@ -0,0 +1,27 @@
+// Package auth internal/auth/tx.go
+package auth
+
+import (
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/storage"
+)
+
+// atomically runs fn in a storage transaction, passing a copy of cfg whose
+// Storage is bound to it. Without transaction support fn runs against cfg
+// as is, one statement at a time.
+func atomically(cfg *config.Config, fn func(cfg *config.Config) error) error {
+	return storage.Atomic(cfg.Storage, func(tx storage.Driver) error {
+		if tx == cfg.Storage {
+			return fn(cfg)
+		}
+		tcfg := *cfg
+		tcfg.Storage = tx
+		return fn(&tcfg)
+	})
+}
+
+// limited reports whether issuing tokens for opts checks counts that must
+// not change before the insert
+func limited(cfg *config.Config, opts *TokenOptions) bool {
+	return opts.UserId > 0 && (cfg.MaxTokensPerUser > 0 || cfg.OrgQuotas != nil)
+}
@@ -2,21 +2,67 @@
 package auth
 
 import (
-	"errors"
 	"strings"
-	"time"
 
 	"github.com/mohar9h/goauth/config"
 	"github.com/mohar9h/goauth/internal/entity"
 	"github.com/mohar9h/goauth/internal/utils"
 )
 
-var ErrTokenInvalid = errors.New("token is invalid or expired")
+// ErrTokenInvalid matches every validation failure; see utils for the
+// specific ones
+var ErrTokenInvalid = utils.ErrTokenInvalid
 
 func ValidateToken(raw string, cfg *config.Config) (*entity.PersonalAccessToken, error) {
+	tok, cfg, err := lookup(raw, cfg, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	if cfg == nil {
-		cfg = config.DefaultConfig()
+	// Only validation is gated, so operators can still revoke tokens
+	// mid-incident. The gate sees the abilities use would hand back.
+	abilities := tok.Abilities
+	if cfg.SanctumCompat {
+		migrated, _ := migrateSanctum(tok)
+		abilities = migrated.Abilities
+	}
+	effective := &entity.PersonalAccessToken{Abilities: cfg.EffectiveAbilities(abilities)}
+	if !cfg.Maintenance.Admits(effective, cfg.AbilityMatcher) {
+		return nil, utils.ErrMaintenanceMode
+	}
+
+	return use(cfg, tok), nil
+}
+
+// ValidatePendingMFA is ValidateToken for the tokens it rejects with
+// ErrMFARequired, and only for those
+func ValidatePendingMFA(raw string, cfg *config.Config) (*entity.PersonalAccessToken, error) {
+	tok, _, err := validateAs(raw, cfg, utils.ErrMFARequired)
+	return tok, err
+}
+
+// validate checks raw and also returns the config routed to the region
+// holding the token, for callers that go on to modify it
+func validate(raw string, cfg *config.Config) (*entity.PersonalAccessToken, *config.Config, error) {
+	return validateAs(raw, cfg, nil)
+}
+
+// validateAs is validate for tokens under the given hold (see CheckHold),
+// which are then the only ones it accepts
+func validateAs(raw string, cfg *config.Config, hold error) (*entity.PersonalAccessToken, *config.Config, error) {
+	tok, cfg, err := lookup(raw, cfg, hold)
+	if err != nil {
+		return nil, nil, err
+	}
+	return use(cfg, tok), cfg, nil
+}
+
+// lookup finds and checks the token raw refers to like validateAs, but
+// without recording the use: no sliding expiry, migration or last-used
+// update. Revocation goes through it so the revoked token isn't touched.
+func lookup(raw string, cfg *config.Config, hold error) (*entity.PersonalAccessToken, *config.Config, error) {
+	if cfg == nil || cfg.Storage == nil {
+		return nil, nil, utils.ErrStorageDriverNil
 	}
 	cfg.ApplyDefaults()
 
@@ -24,34 +70,104 @@ func ValidateToken(raw string, cfg *config.Config) (*entity.PersonalAccessToken,
 		raw = after
 	}
 
-	parts := strings.Split(raw, "|")
-	if len(parts) != 2 {
-		return nil, ErrTokenInvalid
+	loc, secret, ok := strings.Cut(raw, "|")
+	if cfg.SanctumCompat {
+		loc, secret, ok = splitSanctum(raw)
+	}
+	if !ok || secret == "" || strings.Contains(secret, "|") {
+		return nil, nil, utils.ErrTokenInvalidFormat
 	}
 
-	hashed := utils.HashToken(parts[1])
+	cfg, err := cfg.ForLocator(loc)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	tok, err := cfg.Storage.FindByHash(hashed)
+	tok, err := FindBySecret(cfg, loc, secret)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	if tok.Token != hashed {
-		return nil, ErrTokenInvalid
+	verified := cfg.Locator.Verify(loc, tok)
+	if cfg.SanctumCompat {
+		verified = verifySanctumLocator(cfg, loc, tok)
+	}
+	if !verified {
+		return nil, nil, ErrTokenInvalid
 	}
 
-	if tok.ExpiresAt != nil && time.Now().After(*tok.ExpiresAt) {
-		return nil, utils.ErrTokenExpired
+	if tok.RevokedAt != nil || cfg.Revocations.Revoked(tok) {
+		return nil, nil, utils.ErrTokenRevoked
 	}
+	if tok.IsRefresh() || tok.IsLicense() {
+		return nil, nil, ErrTokenInvalid
+	}
+
+	if tok.ExpiresAt != nil && cfg.Now().After(*tok.ExpiresAt) {
+		return nil, nil, utils.ErrTokenExpired
+	}
+
+	if tok.ParentID != nil {
+		if _, err := CheckChain(cfg, tok); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := CheckBinding(cfg, tok, "", nil); err != nil {
+		return nil, nil, err
+	}
+
+	if err := CheckHold(tok); err != hold {
+		if hold != nil {
+			return nil, nil, ErrTokenInvalid
+		}
+		return nil, nil, err
+	}
+	return tok, cfg, nil
+}
+
+// use records a validation of tok, extending a sliding expiry and migrating
+// legacy rows, and returns the record to hand to the caller
+func use(cfg *config.Config, tok *entity.PersonalAccessToken) *entity.PersonalAccessToken {
+	tok = slide(cfg, tok, cfg.Now())
 
-	// Update last used time asynchronously
-	go func() {
-		if err := cfg.Storage.TouchLastUsed(tok.ID); err != nil {
-			// Log error but don't fail validation
-			// In a production environment, you might want to use a proper logger
-			_ = err // Suppress unused variable warning
+	if cfg.SanctumCompat {
+		migrated, changed := migrateSanctum(tok)
+		if changed {
+			if err := cfg.Storage.UpdateToken(migrated); err != nil {
+				cfg.Logger.Warn("failed to migrate sanctum token", "token_id", tok.ID, "error", err)
+			}
+			tok = migrated
 		}
-	}()
+	}
+
+	// Legacy rows may lack abilities; resolve them per the configured policy
+	// on a copy so the stored record is left untouched
+	if abilities := cfg.EffectiveAbilities(tok.Abilities); abilities != tok.Abilities {
+		cp := *tok
+		cp.Abilities = abilities
+		tok = &cp
+	}
+
+	touchLastUsed(cfg, tok.ID)
 
-	return tok, nil
+	return tok
+}
+
+// touchLastUsed records a validation per cfg.LastUsed. Failures are
+// surfaced on the client's error channel but don't fail validation.
+func touchLastUsed(cfg *config.Config, id int64) {
+	switch cfg.LastUsed {
+	case config.LastUsedOff:
+	case config.LastUsedSync:
+		cfg.Workers.Run("touch-last-used", func() error {
+			return cfg.Storage.TouchLastUsed(id)
+		})
+	case config.LastUsedBatched:
+		cfg.Touches.Touch(cfg.Storage, id)
+	default:
+		cfg.Workers.Go("touch-last-used", func() error {
+			return cfg.Storage.TouchLastUsed(id)
+		})
+	}
 }
@@ -0,0 +1,503 @@
+// Package condition internal/condition/condition.go
+//
+// A small expression language for conditional ability grants such as
+// "posts:write when resource.owner == token.user_id".
+//
+//	expr    = or
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | compare
+//	compare = operand [ ("==" | "!=" | "<" | "<=" | ">" | ">=" | "in") operand ]
+//	operand = literal | path | "(" expr ")" | "[" [ operand { "," operand } ] "]"
+//
+// Literals are quoted strings, numbers, true, false and null. Paths are
+// dotted names resolved against nested maps; a missing path is null, which
+// is false where a boolean is expected.
+package condition
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrSyntax is returned for expressions that cannot be parsed.
+var ErrSyntax = errors.New("condition: syntax error")
+
+// Expr is a parsed condition.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parse compiles a condition expression.
+func Parse(src string) (*Expr, error) {
+	p := &parser{src: src}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string { return e.src }
+
+// Eval evaluates the expression against vars and reports whether it holds.
+// Non-boolean results and type mismatches in ordered comparisons are errors.
+func (e *Expr) Eval(vars map[string]any) (bool, error) {
+	b, err := asBool(e.root, vars)
+	if err != nil {
+		return false, fmt.Errorf("%w (in %q)", err, e.src)
+	}
+	return b, nil
+}
+
+// ---- lexer ----
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+type parser struct {
+	src  string
+	toks []token
+	pos  int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w in %q: %s", ErrSyntax, p.src, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			var b strings.Builder
+			for ; j < len(s) && rune(s[j]) != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return p.errorf("unterminated string")
+			}
+			p.toks = append(p.toks, token{tokString, b.String(), i})
+			i = j + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1]))):
+			j := i + 1
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			p.toks = append(p.toks, token{tokNumber, s[i:j], i})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_' || s[j] == '.') {
+				j++
+			}
+			p.toks = append(p.toks, token{tokIdent, s[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, cand := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(s[i:], cand) {
+					op = cand
+					break
+				}
+			}
+			if op == "" {
+				return p.errorf("unexpected character %q", c)
+			}
+			p.toks = append(p.toks, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	p.toks = append(p.toks, token{kind: tokEOF, pos: len(s)})
+	return nil
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// ---- parser ----
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicNode{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicNode{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == tokOp && isCompareOp(t.text):
+	case t.kind == tokIdent && t.text == "in":
+	default:
+		return left, nil
+	}
+	p.next()
+	op := t.text
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{op: op, left: left, right: right}, nil
+}
+
+func isCompareOp(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return literal{t.text}, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %q", t.text)
+		}
+		return literal{f}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		case "in":
+			return nil, p.errorf("unexpected %q", t.text)
+		}
+		return pathNode(strings.Split(t.text, ".")), nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, p.errorf("missing )")
+			}
+			return inner, nil
+		case "[":
+			var items listNode
+			if p.accept("]") {
+				return items, nil
+			}
+			for {
+				item, err := p.parseOperand()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+				if p.accept("]") {
+					return items, nil
+				}
+				if !p.accept(",") {
+					return nil, p.errorf("expected , or ]")
+				}
+			}
+		}
+	case tokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return nil, p.errorf("unexpected %q", t.text)
+}
+
+// ---- evaluation ----
+
+type node interface {
+	eval(vars map[string]any) (any, error)
+}
+
+type literal struct{ v any }
+
+func (l literal) eval(map[string]any) (any, error) { return l.v, nil }
+
+type pathNode []string
+
+func (p pathNode) eval(vars map[string]any) (any, error) {
+	var cur any = vars
+	for _, key := range p {
+		switch m := cur.(type) {
+		case map[string]any:
+			cur = m[key]
+		case map[string]string:
+			v, ok := m[key]
+			if !ok {
+				return nil, nil
+			}
+			cur = v
+		default:
+			return nil, nil
+		}
+	}
+	return normalize(cur), nil
+}
+
+type listNode []node
+
+func (l listNode) eval(vars map[string]any) (any, error) {
+	out := make([]any, len(l))
+	for i, item := range l {
+		v, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+type notNode struct{ inner node }
+
+func (n notNode) eval(vars map[string]any) (any, error) {
+	b, err := asBool(n.inner, vars)
+	if err != nil {
+		return nil, err
+	}
+	return !b, nil
+}
+
+type logicNode struct {
+	and         bool
+	left, right node
+}
+
+func (n logicNode) eval(vars map[string]any) (any, error) {
+	l, err := asBool(n.left, vars)
+	if err != nil {
+		return nil, err
+	}
+	if n.and != l {
+		// false && x, true || x
+		return l, nil
+	}
+	return asBool(n.right, vars)
+}
+
+func asBool(n node, vars map[string]any) (bool, error) {
+	v, err := n.eval(vars)
+	if err != nil || v == nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition: expected boolean, got %v", v)
+	}
+	return b, nil
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n compareNode) eval(vars map[string]any) (any, error) {
+	l, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "in":
+		list, ok := r.([]any)
+		if !ok {
+			return nil, fmt.Errorf("condition: right side of in is not a list")
+		}
+		for _, item := range list {
+			if equal(l, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	cmp, err := order(l, r)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+func equal(a, b any) bool {
+	switch a.(type) {
+	case []any:
+		return false
+	}
+	switch b.(type) {
+	case []any:
+		return false
+	}
+	return a == b
+}
+
+func order(a, b any) (int, error) {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	}
+	return 0, fmt.Errorf("condition: cannot order %v and %v", a, b)
+}
+
+// normalize maps Go values from the caller's variables onto the literal
+// types: every number becomes float64 and slices become []any.
+func normalize(v any) any {
+	switch x := v.(type) {
+	case nil, bool, string, float64, []any:
+		return x
+	case int:
+		return float64(x)
+	case int64:
+		return float64(x)
+	case int32:
+		return float64(x)
+	case uint:
+		return float64(x)
+	case uint64:
+		return float64(x)
+	case uint32:
+		return float64(x)
+	case float32:
+		return float64(x)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		out := make([]any, rv.Len())
+		for i := range out {
+			out[i] = normalize(rv.Index(i).Interface())
+		}
+		return out
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	}
+	return v
+}
@@ -1,2 +1,185 @@
 // Package entity internal/entity/ability.go
 package entity
+
+import (
+	"strings"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Wildcard grants every ability when present on a token.
+const Wildcard = "*"
+
+// NoAbilities is stored for tokens issued without abilities, so they can be
+// told apart from legacy rows whose abilities are NULL or empty. It grants
+// nothing.
+const NoAbilities = ","
+
+// ConditionSeparator separates an ability from its condition in a
+// conditional grant like "posts:write when resource.owner == token.user_id".
+const ConditionSeparator = " when "
+
+// Can reports whether the token grants the given ability. A granted "*"
+// matches everything and a trailing "*" (e.g. "read:*") matches by prefix.
+// Conditional grants need a resource to evaluate against and never match
+// here.
+func (t *PersonalAccessToken) Can(ability string) bool {
+	return t.CanWith(nil, ability)
+}
+
+// CanWith is Can with the given matcher deciding whether a granted ability
+// covers the requested one (nil means GlobMatcher).
+func (t *PersonalAccessToken) CanWith(m AbilityMatcher, ability string) bool {
+	if ability == "" {
+		return false
+	}
+	return t.abilitySet().can(m, ability)
+}
+
+// Cant is the inverse of Can.
+func (t *PersonalAccessToken) Cant(ability string) bool {
+	return !t.Can(ability)
+}
+
+// MatchAbility reports whether a granted ability pattern covers the requested one.
+func MatchAbility(granted, requested string) bool {
+	if granted == "" {
+		return false
+	}
+	if granted == Wildcard || granted == requested {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(granted, Wildcard); ok {
+		return strings.HasPrefix(requested, prefix)
+	}
+	return false
+}
+
+// SplitCondition splits a granted ability into the ability pattern and its
+// condition, which is empty for unconditional grants.
+func SplitCondition(granted string) (ability, cond string) {
+	ability, cond, _ = strings.Cut(granted, ConditionSeparator)
+	return strings.TrimSpace(ability), strings.TrimSpace(cond)
+}
+
+// SplitAbilities splits a stored comma separated ability list. Commas inside
+// a condition's quotes, brackets or parentheses do not split.
+func SplitAbilities(s string) []string {
+	if s == "" || s == NoAbilities {
+		return nil
+	}
+
+	var out []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case !strings.Contains(s[start:i], ConditionSeparator):
+			if c == ',' {
+				out = append(out, s[start:i])
+				start = i + 1
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			out = append(out, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(out, s[start:])
+}
+
+// AbilityList returns the stored abilities as a slice, split like
+// SplitAbilities and trimmed, without empty entries. Abilities itself
+// stays the stored comma separated string. The slice is the caller's.
+func (t *PersonalAccessToken) AbilityList() []string {
+	var out []string
+	for _, a := range SplitAbilities(t.Abilities) {
+		if a = strings.TrimSpace(a); a != "" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// SetAbilities stores abilities as the comma separated Abilities string.
+func (t *PersonalAccessToken) SetAbilities(abilities []string) {
+	t.Abilities = strings.Join(abilities, ",")
+}
+
+// abilitySet is an ability list parsed once, so repeated Can checks on a
+// token neither split nor trim it again
+type abilitySet struct {
+	src    string   // Abilities it was parsed from
+	all    bool     // "*" granted
+	grants []string // Unconditional grants, trimmed
+	small  [4]string
+}
+
+// abilitySet returns the parsed Abilities, parsing them on first use or
+// after Abilities was changed
+func (t *PersonalAccessToken) abilitySet() *abilitySet {
+	if set := (*abilitySet)(atomic.LoadPointer(&t.parsed)); set != nil && set.src == t.Abilities {
+		return set
+	}
+	set := parseAbilitySet(t.Abilities)
+	atomic.StorePointer(&t.parsed, unsafe.Pointer(set))
+	return set
+}
+
+// parseAbilitySet makes a single allocation for lists of up to four
+// abilities without conditions, the common case
+func parseAbilitySet(s string) *abilitySet {
+	set := &abilitySet{src: s}
+	set.grants = set.small[:0]
+	add := func(granted string) {
+		switch granted = strings.TrimSpace(granted); granted {
+		case "":
+		case Wildcard:
+			set.all = true
+		default:
+			set.grants = append(set.grants, granted)
+		}
+	}
+
+	if !strings.Contains(s, ConditionSeparator) {
+		for rest := s; rest != ""; {
+			var granted string
+			granted, rest, _ = strings.Cut(rest, ",")
+			add(granted)
+		}
+		return set
+	}
+	for _, granted := range SplitAbilities(s) {
+		if _, cond := SplitCondition(granted); cond == "" {
+			add(granted)
+		}
+	}
+	return set
+}
+
+func (s *abilitySet) can(m AbilityMatcher, ability string) bool {
+	if s.all {
+		return true
+	}
+	for _, granted := range s.grants {
+		if m == nil {
+			if MatchAbility(granted, ability) {
+				return true
+			}
+		} else if m.Match(granted, ability) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+// Package entity internal/entity/identity_link.go
+package entity
+
+import "time"
+
+// IdentityLink ties an external identity (provider + subject) to a local user.
+type IdentityLink struct {
+	ID        int64  `gorm:"primaryKey;autoIncrement"`
+	UserId    int64  `gorm:"index"`
+	Provider  string `gorm:"size:64;uniqueIndex:idx_identity_provider_subject"`  // e.g. "password", "google", "saml:acme"
+	Subject   string `gorm:"size:255;uniqueIndex:idx_identity_provider_subject"` // Provider's stable user identifier
+	Email     string `gorm:"size:255"`
+	CreatedAt time.Time
+}
+
+func (IdentityLink) TableName() string { return "identity_links" }
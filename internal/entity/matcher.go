@@ -0,0 +1,73 @@
+// Package entity internal/entity/matcher.go
+package entity
+
+import "strings"
+
+// AbilityMatcher decides whether a granted ability covers a requested one.
+type AbilityMatcher interface {
+	Match(granted, requested string) bool
+}
+
+// GlobMatcher is the default AbilityMatcher: "*" matches everything and a
+// trailing "*" matches by prefix, so "posts*" also covers "postsecret".
+type GlobMatcher struct{}
+
+func (GlobMatcher) Match(granted, requested string) bool {
+	return MatchAbility(granted, requested)
+}
+
+// TreeMatcher treats abilities as paths split on Delimiter. A "*" segment
+// matches any one segment, and a trailing "*" any number of further
+// segments: "posts:*" covers "posts:read" and "posts:comments:delete" but
+// not "posts" or "postsecret". Implies maps an ability to the ones it
+// grants in turn, e.g. "admin" to {"*"} or "editor" to {"posts:*"}.
+type TreeMatcher struct {
+	Delimiter string // Segment separator (default ":")
+	Implies   map[string][]string
+}
+
+func (m *TreeMatcher) Match(granted, requested string) bool {
+	return m.match(granted, requested, 0)
+}
+
+// maxImplyDepth cuts cycles in Implies
+const maxImplyDepth = 8
+
+func (m *TreeMatcher) match(granted, requested string, depth int) bool {
+	if granted == "" || requested == "" {
+		return false
+	}
+	if granted == Wildcard || granted == requested || m.matchPath(granted, requested) {
+		return true
+	}
+	if depth >= maxImplyDepth {
+		return false
+	}
+	for _, implied := range m.Implies[granted] {
+		if m.match(implied, requested, depth+1) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *TreeMatcher) matchPath(granted, requested string) bool {
+	delim := m.Delimiter
+	if delim == "" {
+		delim = ":"
+	}
+	for {
+		g, gRest, gMore := strings.Cut(granted, delim)
+		r, rRest, rMore := strings.Cut(requested, delim)
+		if g == Wildcard && !gMore {
+			return r != ""
+		}
+		if g != Wildcard && g != r {
+			return false
+		}
+		if !gMore || !rMore {
+			return gMore == rMore
+		}
+		granted, requested = gRest, rRest
+	}
+}
@@ -0,0 +1,50 @@
+// Package entity internal/entity/org.go
+package entity
+
+import "time"
+
+// Org unit kinds.
+const (
+	OrgKindOrg  = "org"
+	OrgKindTeam = "team"
+)
+
+// Grant subject types.
+const (
+	GrantSubjectUnit = "unit"
+	GrantSubjectUser = "user"
+)
+
+// OrgUnit is a node in the organization hierarchy: an org, or a team
+// nested under an org or another team.
+type OrgUnit struct {
+	ID        int64  `gorm:"primaryKey;autoIncrement"`
+	ParentID  *int64 `gorm:"index"` // Nil for top-level orgs
+	Kind      string `gorm:"size:16"`
+	Name      string `gorm:"size:255"`
+	CreatedAt time.Time
+}
+
+func (OrgUnit) TableName() string { return "org_units" }
+
+// OrgMember places a user in an org unit.
+type OrgMember struct {
+	ID        int64 `gorm:"primaryKey;autoIncrement"`
+	UnitID    int64 `gorm:"uniqueIndex:idx_org_member"`
+	UserId    int64 `gorm:"index;uniqueIndex:idx_org_member"`
+	CreatedAt time.Time
+}
+
+func (OrgMember) TableName() string { return "org_members" }
+
+// AbilityGrant gives an ability to an org unit (inherited by its members
+// and every unit below it) or directly to a user.
+type AbilityGrant struct {
+	ID          int64  `gorm:"primaryKey;autoIncrement"`
+	SubjectType string `gorm:"size:8;uniqueIndex:idx_ability_grant"` // GrantSubjectUnit or GrantSubjectUser
+	SubjectID   int64  `gorm:"uniqueIndex:idx_ability_grant"`
+	Ability     string `gorm:"size:255;uniqueIndex:idx_ability_grant"`
+	CreatedAt   time.Time
+}
+
+func (AbilityGrant) TableName() string { return "ability_grants" }
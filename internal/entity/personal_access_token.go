@@ -1,18 +1,67 @@
 // Package entity internal/entity/personal_access_token.go
 package entity
 
-import "time"
+import (
+	"time"
+	"unsafe"
+)
+
+// Token kinds stored in PersonalAccessToken.Kind. Empty means access.
+const (
+	KindAccess  = "access"
+	KindRefresh = "refresh"
+	KindGuest   = "guest"   // Not bound to a user (UserId 0)
+	KindLicense = "license" // Registered license key; never valid as a bearer token
+)
 
 // PersonalAccessToken APIToken defines the persistent structure stored in SQL/Redis.
 type PersonalAccessToken struct {
-	ID         int64      `gorm:"primaryKey;autoIncrement"`
-	UserId     int64      `gorm:"index"`
-	Token      string     `gorm:"index;size:100"`
-	Name       *string    `gorm:"size:100"`
-	Abilities  string     `gorm:"type:text"`
-	CreatedAt  time.Time  `gorm:"autoCreateTime"`
-	ExpiresAt  *time.Time `gorm:"index"`
+	ID         int64             `gorm:"primaryKey;autoIncrement"`
+	UserId     int64             `gorm:"index;uniqueIndex:idx_pat_user_unique_name,priority:1"`
+	Token      string            `gorm:"uniqueIndex:idx_pat_token;size:100"`
+	Name       *string           `gorm:"size:100"`
+	UniqueName *string           `gorm:"size:100;uniqueIndex:idx_pat_user_unique_name,priority:2"` // Set for tokens created with TokenOptions.Replace
+	Abilities  string            `gorm:"type:text"`
+	Kind       string            `gorm:"size:16;default:access"`
+	FamilyID   string            `gorm:"index;size:32"` // Shared by tokens issued from one refresh chain
+	ParentID   *int64            `gorm:"index"`         // Token this one was exchanged from
+	Metadata   map[string]string `gorm:"serializer:json;type:text"`
+	CreatedAt  time.Time         `gorm:"autoCreateTime"`
+	ExpiresAt  *time.Time        `gorm:"index"`
 	LastUsedAt *time.Time
+	RevokedAt  *time.Time `gorm:"index"`
+	UpdatedAt  time.Time  // Last change other than a last-used touch
+	Revision   int64      `gorm:"index"` // Orders changes for ChangesSince; set by the driver on every change
+
+	// Extra holds application fields stored in extra columns by an
+	// EntityExtender
+	Extra map[string]any `gorm:"-"`
+
+	// parsed caches Abilities as an *abilitySet for Can. It is read and
+	// written atomically, so copies of the struct may share it.
+	parsed unsafe.Pointer
+}
+
+// IsRefresh reports whether the record is a refresh token.
+func (t *PersonalAccessToken) IsRefresh() bool {
+	return t.Kind == KindRefresh
+}
+
+// IsLicense reports whether the record is a registered license key.
+func (t *PersonalAccessToken) IsLicense() bool {
+	return t.Kind == KindLicense
+}
+
+// IsGuest reports whether the record is an anonymous guest token.
+func (t *PersonalAccessToken) IsGuest() bool {
+	return t.Kind == KindGuest
 }
 
 func (PersonalAccessToken) TableName() string { return "personal_access_tokens" }
+
+// ToToken returns t itself; with FromToken it makes the entity the default
+// token model for the GORM driver.
+func (t *PersonalAccessToken) ToToken() *PersonalAccessToken { return t }
+
+// FromToken copies src into t.
+func (t *PersonalAccessToken) FromToken(src *PersonalAccessToken) { *t = *src }
@@ -0,0 +1,16 @@
+// Package entity internal/entity/role.go
+package entity
+
+import "time"
+
+// Role is a named set of abilities. Tokens reference it as "role:<name>"
+// and gain its current abilities at validation time.
+type Role struct {
+	ID        int64  `gorm:"primaryKey;autoIncrement"`
+	Name      string `gorm:"size:100;uniqueIndex"`
+	Abilities string `gorm:"type:text"` // Comma separated, like PersonalAccessToken.Abilities
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (Role) TableName() string { return "roles" }
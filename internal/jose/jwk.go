@@ -0,0 +1,221 @@
+// Package jose internal/jose/jwk.go
+package jose
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JWK is a public JSON Web Key (RFC 7517) of type RSA, EC (P-256) or OKP
+// (Ed25519).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// PublicKey decodes the key.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64Int(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64Int(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("jwk: RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("jwk: unsupported curve %q", k.Crv)
+		}
+		x, err := b64Int(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64Int(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if _, err := pub.ECDH(); err != nil {
+			return nil, errors.New("jwk: EC point not on curve")
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("jwk: unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("jwk: invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("jwk: unsupported key type %q", k.Kty)
+}
+
+func b64Int(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("jwk: invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// JWKS is a JSON Web Key Set document.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySet fetches a remote JWKS and caches its keys. Unknown key IDs trigger
+// a refetch, at most once per MinRefresh, so key rotation is picked up
+// without letting bogus kids hammer the endpoint.
+type KeySet struct {
+	URL        string
+	Client     *http.Client  // Default http.DefaultClient
+	TTL        time.Duration // Cache lifetime. Default 1h
+	MinRefresh time.Duration // Minimum delay between fetches. Default 30s
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// Key returns the key with the given ID. An empty kid matches the set's
+// only key.
+func (s *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ttl, minRefresh := s.TTL, s.MinRefresh
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	if minRefresh <= 0 {
+		minRefresh = 30 * time.Second
+	}
+
+	key, ok := s.lookup(kid)
+	age := time.Since(s.fetched)
+	if (ok && age < ttl) || (!ok && !s.fetched.IsZero() && age < minRefresh) {
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+		}
+		return key, nil
+	}
+
+	if err := s.fetch(ctx); err != nil {
+		if ok {
+			// Serve the stale key rather than failing on a flaky endpoint
+			return key, nil
+		}
+		return nil, err
+	}
+	if key, ok = s.lookup(kid); !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+func (s *KeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+func (s *KeySet) fetch(ctx context.Context) error {
+	var set JWKS
+	if err := GetJSON(ctx, s.Client, s.URL, &set); err != nil {
+		return fmt.Errorf("fetch JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		// SPIFFE bundles mark JWT signing keys "jwt-svid"
+		if k.Use != "" && k.Use != "sig" && k.Use != "jwt-svid" {
+			continue
+		}
+		pub, err := k.PublicKey()
+		if err != nil {
+			// Skip key types we don't support instead of rejecting the set
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	s.keys, s.fetched = keys, time.Now()
+	return nil
+}
+
+// GetJSON fetches u and decodes its JSON body (at most 1 MB) into v.
+func GetJSON(ctx context.Context, client *http.Client, u string, v any) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// Discover returns the jwks_uri from an OpenID provider's configuration
+// document.
+func Discover(ctx context.Context, client *http.Client, issuer string) (string, error) {
+	var meta struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	u := trimSlash(issuer) + "/.well-known/openid-configuration"
+	if err := GetJSON(ctx, client, u, &meta); err != nil {
+		return "", fmt.Errorf("discover %s: %w", issuer, err)
+	}
+	if meta.JWKSURI == "" {
+		return "", fmt.Errorf("discover %s: no jwks_uri", issuer)
+	}
+	return meta.JWKSURI, nil
+}
+
+func trimSlash(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '/' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,231 @@
+// Package jose internal/jose/jwt.go
+package jose
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for malformed, badly signed or expired JWTs.
+var ErrInvalidToken = errors.New("invalid JWT")
+
+// Header is the protected JOSE header of a JWT.
+type Header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// KeyFunc returns the verification key for a token's header.
+type KeyFunc func(ctx context.Context, h Header) (crypto.PublicKey, error)
+
+// Verify checks the compact JWS token's signature with the key from keyFn
+// and returns its claims. Only RS256, ES256 and EdDSA are accepted, and the
+// key type must match the algorithm, so "none" and HMAC/RSA confusion are
+// rejected. Claims are not validated; see Claims.Validate.
+func Verify(ctx context.Context, token string, keyFn KeyFunc) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a compact JWS", ErrInvalidToken)
+	}
+
+	var h Header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding", ErrInvalidToken)
+	}
+
+	key, err := keyFn(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(h.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("%w: bad base64url segment", ErrInvalidToken)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: bad JSON segment", ErrInvalidToken)
+	}
+	return nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, input, sig []byte) error {
+	bad := fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+	switch alg {
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			break
+		}
+		sum := sha256.Sum256(input)
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) != nil {
+			return bad
+		}
+		return nil
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || k.Curve != elliptic.P256() {
+			break
+		}
+		if len(sig) != 64 {
+			return bad
+		}
+		sum := sha256.Sum256(input)
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, sum[:], r, s) {
+			return bad
+		}
+		return nil
+	case "EdDSA":
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			break
+		}
+		if !ed25519.Verify(k, input, sig) {
+			return bad
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+	}
+	return fmt.Errorf("%w: key type %T does not match %s", ErrInvalidToken, key, alg)
+}
+
+// Claims is a decoded JWT claims set. Numbers are json.Number.
+type Claims map[string]any
+
+// String returns a string claim, or "" when absent or not a string.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Time returns a NumericDate claim.
+func (c Claims) Time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// Audiences returns the aud claim, which may be a string or an array.
+func (c Claims) Audiences() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []any:
+		out := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// Expected lists the registered claims a token must satisfy.
+type Expected struct {
+	Issuer   string        // Required iss, if set
+	Audience string        // Required member of aud, if set
+	Leeway   time.Duration // Clock skew allowed on exp, nbf and iat
+	Now      time.Time     // Default time.Now()
+}
+
+// Validate checks iss, aud, exp, nbf and iat. exp is required.
+func (c Claims) Validate(want Expected) error {
+	now := want.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	if want.Issuer != "" && c.String("iss") != want.Issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, c.String("iss"))
+	}
+	if want.Audience != "" {
+		found := false
+		for _, a := range c.Audiences() {
+			found = found || a == want.Audience
+		}
+		if !found {
+			return fmt.Errorf("%w: audience mismatch", ErrInvalidToken)
+		}
+	}
+
+	exp, ok := c.Time("exp")
+	if !ok {
+		return fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+	if !now.Before(exp.Add(want.Leeway)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := c.Time("nbf"); ok && now.Add(want.Leeway).Before(nbf) {
+		return fmt.Errorf("%w: not yet valid", ErrInvalidToken)
+	}
+	if iat, ok := c.Time("iat"); ok && now.Add(want.Leeway).Before(iat) {
+		return fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+	return nil
+}
+
+// Lookup resolves a claim path. Keys may themselves contain dots, as in
+// Kubernetes' "kubernetes.io" claim, so "kubernetes.io.namespace" is tried
+// as every split of the path into nested keys.
+func (c Claims) Lookup(path string) (any, bool) {
+	return lookup(map[string]any(c), path)
+}
+
+func lookup(m map[string]any, path string) (any, bool) {
+	if v, ok := m[path]; ok {
+		return v, true
+	}
+	for i := strings.IndexByte(path, '.'); i >= 0; {
+		if sub, ok := m[path[:i]].(map[string]any); ok {
+			if v, ok := lookup(sub, path[i+1:]); ok {
+				return v, true
+			}
+		}
+		next := strings.IndexByte(path[i+1:], '.')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return nil, false
+}
@@ -0,0 +1,190 @@
+// Package jose internal/jose/keys.go
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ParsePrivatePEM decodes the first private key block of data: PKCS #8
+// ("PRIVATE KEY"), PKCS #1 ("RSA PRIVATE KEY") or SEC 1 ("EC PRIVATE
+// KEY"). The key must have an Algorithm.
+func ParsePrivatePEM(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return nil, errors.New("pem: no private key block")
+		}
+
+		var key any
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			continue // e.g. "EC PARAMETERS" before the key
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pem: %w", err)
+		}
+		return checkSigner(key)
+	}
+}
+
+// ParsePublicPEM decodes the first public key or certificate block of
+// data: PKIX ("PUBLIC KEY"), PKCS #1 ("RSA PUBLIC KEY") or a certificate's
+// key. The key must have an Algorithm.
+func ParsePublicPEM(data []byte) (crypto.PublicKey, error) {
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return nil, errors.New("pem: no public key block")
+		}
+
+		var key any
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pem: %w", err)
+		}
+		if _, err := Algorithm(key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+}
+
+// ParsePublicJWK decodes a JSON public key. The key must have an
+// Algorithm, and when the JWK names an alg it must be that one.
+func ParsePublicJWK(data []byte) (crypto.PublicKey, error) {
+	var k JWK
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("jwk: %w", err)
+	}
+	key, err := k.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAlg(key, k.Alg); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ParsePrivateJWK decodes a JSON private key: RSA with its CRT
+// parameters, P-256 EC or Ed25519 OKP. The key must have an Algorithm,
+// and when the JWK names an alg it must be that one.
+func ParsePrivateJWK(data []byte) (crypto.Signer, error) {
+	var k struct {
+		JWK
+		D  string `json:"d"`
+		P  string `json:"p"`
+		Q  string `json:"q"`
+		Dp string `json:"dp"`
+		Dq string `json:"dq"`
+		Qi string `json:"qi"`
+	}
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("jwk: %w", err)
+	}
+	if k.D == "" {
+		return nil, errors.New("jwk: not a private key")
+	}
+	pub, err := k.JWK.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var key crypto.Signer
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		ints := make([]*big.Int, 6)
+		for i, s := range []string{k.D, k.P, k.Q, k.Dp, k.Dq, k.Qi} {
+			if ints[i], err = b64Int(s); err != nil {
+				return nil, errors.New("jwk: RSA private keys need d, p, q, dp, dq and qi")
+			}
+		}
+		rk := &rsa.PrivateKey{PublicKey: *p, D: ints[0], Primes: []*big.Int{ints[1], ints[2]}}
+		rk.Precomputed.Dp, rk.Precomputed.Dq, rk.Precomputed.Qinv = ints[3], ints[4], ints[5]
+		if err := rk.Validate(); err != nil {
+			return nil, fmt.Errorf("jwk: %w", err)
+		}
+		rk.Precompute()
+		key = rk
+	case *ecdsa.PublicKey:
+		d, err := base64.RawURLEncoding.DecodeString(k.D)
+		if err != nil || len(d) != 32 {
+			return nil, errors.New("jwk: invalid P-256 private key")
+		}
+		ek := &ecdsa.PrivateKey{PublicKey: *p, D: new(big.Int).SetBytes(d)}
+		priv, err := ek.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("jwk: %w", err)
+		}
+		if pub, _ := p.ECDH(); !priv.PublicKey().Equal(pub) {
+			return nil, errors.New("jwk: EC private key doesn't match x and y")
+		}
+		key = ek
+	case ed25519.PublicKey:
+		seed, err := base64.RawURLEncoding.DecodeString(k.D)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, errors.New("jwk: invalid Ed25519 private key")
+		}
+		sk := ed25519.NewKeyFromSeed(seed)
+		if !p.Equal(sk.Public()) {
+			return nil, errors.New("jwk: Ed25519 private key doesn't match x")
+		}
+		key = sk
+	}
+	if err := checkAlg(key, k.Alg); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func checkSigner(key any) (crypto.Signer, error) {
+	s, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("jwk: unsupported key type %T", key)
+	}
+	if _, err := Algorithm(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func checkAlg(key any, want string) error {
+	alg, err := Algorithm(key)
+	if err != nil {
+		return err
+	}
+	if want != "" && want != alg {
+		return fmt.Errorf("jwk: %s key labelled %q", alg, want)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+// Package jose internal/jose/sign.go
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// Algorithm returns the JWS algorithm for a key: RS256 for RSA keys of at
+// least 2048 bits, ES256 for P-256 ECDSA keys and EdDSA for Ed25519 keys.
+// Private keys are named by their public half.
+func Algorithm(key any) (string, error) {
+	if s, ok := key.(crypto.Signer); ok {
+		key = s.Public()
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return "", fmt.Errorf("jwk: RSA keys must be at least 2048 bits, got %d", k.N.BitLen())
+		}
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return "", fmt.Errorf("jwk: ECDSA keys must use P-256, got %s", k.Curve.Params().Name)
+		}
+		return "ES256", nil
+	case ed25519.PublicKey:
+		if len(k) != ed25519.PublicKeySize {
+			return "", fmt.Errorf("jwk: invalid Ed25519 key")
+		}
+		return "EdDSA", nil
+	}
+	return "", fmt.Errorf("jwk: unsupported key type %T", key)
+}
+
+// Sign encodes claims as a compact JWS signed with key under h. h.Alg must
+// be the key's Algorithm.
+func Sign(key crypto.Signer, h Header, claims any) (string, error) {
+	header, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := b64(header) + "." + b64(payload)
+
+	if alg, err := Algorithm(key); err != nil {
+		return "", err
+	} else if alg != h.Alg {
+		return "", fmt.Errorf("jws: %T keys sign %s, not %q", key, alg, h.Alg)
+	}
+
+	var sig []byte
+	sum := sha256.Sum256([]byte(input))
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		if r, s, err = ecdsa.Sign(rand.Reader, k, sum[:]); err == nil {
+			// JWS uses the fixed-size R || S encoding, not ASN.1
+			sig = make([]byte, 64)
+			r.FillBytes(sig[:32])
+			s.FillBytes(sig[32:])
+		}
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(input))
+	default:
+		// Keys held elsewhere (KMS, HSM) are crypto.Signers of their own type
+		sig, err = signOpaque(key, h.Alg, []byte(input), sum[:])
+	}
+	if err != nil {
+		return "", err
+	}
+	return input + "." + b64(sig), nil
+}
+
+// PublicJWK encodes a public key as a JWK, with its Algorithm as alg and
+// the RFC 7638 thumbprint as its kid.
+func PublicJWK(pub crypto.PublicKey) (JWK, error) {
+	alg, err := Algorithm(pub)
+	if err != nil {
+		return JWK{}, err
+	}
+	var k JWK
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		k = JWK{Kty: "RSA", N: b64(p.N.Bytes()), E: b64(big.NewInt(int64(p.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		ec, err := p.ECDH()
+		if err != nil {
+			return JWK{}, err
+		}
+		raw := ec.Bytes() // 0x04 || X || Y
+		k = JWK{Kty: "EC", Crv: "P-256", X: b64(raw[1:33]), Y: b64(raw[33:])}
+	case ed25519.PublicKey:
+		k = JWK{Kty: "OKP", Crv: "Ed25519", X: b64(p)}
+	default:
+		return JWK{}, fmt.Errorf("jwk: unsupported key type %T", pub)
+	}
+	k.Kid, k.Alg, k.Use = k.Thumbprint(), alg, "sig"
+	return k, nil
+}
+
+// Thumbprint returns the key's RFC 7638 SHA-256 thumbprint, base64url
+// encoded. Instances holding the same key derive the same value.
+func (k JWK) Thumbprint() string {
+	var canonical string
+	switch k.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, k.Crv, k.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return b64(sum[:])
+}
+
+// signOpaque signs with a crypto.Signer that isn't one of the standard
+// library's key types
+func signOpaque(key crypto.Signer, alg string, input, digest []byte) ([]byte, error) {
+	switch alg {
+	case "RS256":
+		return key.Sign(rand.Reader, digest, crypto.SHA256)
+	case "ES256":
+		der, err := key.Sign(rand.Reader, digest, crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(der, &sig); err != nil {
+			return nil, fmt.Errorf("jws: bad ECDSA signature: %w", err)
+		}
+		out := make([]byte, 64)
+		sig.R.FillBytes(out[:32])
+		sig.S.FillBytes(out[32:])
+		return out, nil
+	case "EdDSA":
+		return key.Sign(rand.Reader, input, crypto.Hash(0))
+	}
+	return nil, fmt.Errorf("jws: unsupported algorithm %q", alg)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
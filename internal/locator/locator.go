@@ -0,0 +1,155 @@
+// Package locator internal/locator/locator.go
+package locator
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// Locator produces the public segment placed before "|" in plaintext tokens
+// and checks that a presented segment belongs to the stored token.
+type Locator interface {
+	Locate(t *entity.PersonalAccessToken) (string, error)
+	Verify(locator string, t *entity.PersonalAccessToken) bool
+}
+
+// ID uses the storage auto-increment ID (the default "id|secret" format).
+type ID struct{}
+
+func (ID) Locate(t *entity.PersonalAccessToken) (string, error) {
+	if t.ID == 0 {
+		return "", fmt.Errorf("storage did not assign a token ID")
+	}
+	return strconv.FormatInt(t.ID, 10), nil
+}
+
+func (ID) Verify(locator string, t *entity.PersonalAccessToken) bool {
+	return locator == strconv.FormatInt(t.ID, 10)
+}
+
+// HashPrefix uses the first N characters of the stored token hash's digest,
+// so it works with drivers that have no numeric IDs.
+type HashPrefix struct {
+	Length int
+}
+
+func (h HashPrefix) Locate(t *entity.PersonalAccessToken) (string, error) {
+	d := digest(t.Token)
+	if h.Length <= 0 || h.Length > len(d) {
+		return "", fmt.Errorf("invalid hash prefix length %d", h.Length)
+	}
+	return d[:h.Length], nil
+}
+
+func (h HashPrefix) Verify(locator string, t *entity.PersonalAccessToken) bool {
+	d := digest(t.Token)
+	return h.Length > 0 && len(d) >= h.Length && locator == d[:h.Length]
+}
+
+// digest strips the header of a "$sha256$..." hash string, so rows keep
+// their locator when rehashed from the compact form
+func digest(hash string) string {
+	return hash[strings.LastIndexByte(hash, '$')+1:]
+}
+
+// ULID emits a fresh lexicographically sortable identifier per token. It is
+// not stored, so verification only checks the shape of the segment.
+type ULID struct{}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func (ULID) Locate(t *entity.PersonalAccessToken) (string, error) {
+	var buf [16]byte
+	ms := uint64(t.CreatedAt.UnixMilli())
+	if t.CreatedAt.IsZero() {
+		ms = uint64(time.Now().UnixMilli())
+	}
+	for i := 5; i >= 0; i-- {
+		buf[i] = byte(ms)
+		ms >>= 8
+	}
+	if _, err := rand.Read(buf[6:]); err != nil {
+		return "", fmt.Errorf("ulid entropy: %w", err)
+	}
+	return encodeULID(buf), nil
+}
+
+func (ULID) Verify(locator string, _ *entity.PersonalAccessToken) bool {
+	if len(locator) != 26 || locator[0] > '7' {
+		return false
+	}
+	for i := 0; i < len(locator); i++ {
+		if !isCrockford(locator[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Func adapts a plain function (e.g. a shard key) into a Locator. The
+// locator is recomputed from the stored token during verification.
+type Func func(t *entity.PersonalAccessToken) (string, error)
+
+func (f Func) Locate(t *entity.PersonalAccessToken) (string, error) {
+	return f(t)
+}
+
+func (f Func) Verify(locator string, t *entity.PersonalAccessToken) bool {
+	want, err := f(t)
+	return err == nil && locator == want
+}
+
+// Regional prefixes the inner locator with the region holding the token
+// ("eu.42|secret"), so validation can route to the right storage before
+// looking the token up.
+type Regional struct {
+	Region string
+	Inner  Locator
+}
+
+func (r Regional) Locate(t *entity.PersonalAccessToken) (string, error) {
+	loc, err := r.Inner.Locate(t)
+	if err != nil {
+		return "", err
+	}
+	return r.Region + "." + loc, nil
+}
+
+func (r Regional) Verify(locator string, t *entity.PersonalAccessToken) bool {
+	region, inner, ok := strings.Cut(locator, ".")
+	return ok && region == r.Region && r.Inner.Verify(inner, t)
+}
+
+// SplitRegion returns the region prefix of a locator issued by Regional, or
+// "" for unpinned tokens
+func SplitRegion(locator string) (region, inner string) {
+	if region, inner, ok := strings.Cut(locator, "."); ok {
+		return region, inner
+	}
+	return "", locator
+}
+
+func encodeULID(b [16]byte) string {
+	out := make([]byte, 26)
+	// 128 bits encoded as 26 base32 characters, most significant first
+	var hi, lo uint64
+	for i := 0; i < 8; i++ {
+		hi = hi<<8 | uint64(b[i])
+		lo = lo<<8 | uint64(b[i+8])
+	}
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
+
+func isCrockford(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'A' && c <= 'Z' && c != 'I' && c != 'L' && c != 'O' && c != 'U')
+}
@@ -0,0 +1,417 @@
+// Package storage internal/storage/archive.go
+package storage
+
+import (
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// Archive is cold storage for tokens moved out of the hot driver by an
+// ArchivingDriver. Get returns utils.ErrTokenNotFound for unknown hashes.
+type Archive interface {
+	Put(tokens []*entity.PersonalAccessToken) error
+	Get(hash string) (*entity.PersonalAccessToken, error)
+	Delete(hash string) error
+	DeleteByUser(userID int64) (int64, error)
+}
+
+// archiveBatch is the number of tokens moved per Archive.Put
+const archiveBatch = 500
+
+// ArchivingDriver moves tokens unused for a while from a hot driver to an
+// Archive, keeping the hot table small, and moves them back the first time
+// FindByHash misses the hot driver. Archived tokens keep their ID, so they
+// validate with any locator once rehydrated.
+//
+// Tokens in named slots (TokenOptions.Replace) or refresh families are never
+// archived, so replacing a slot or revoking a family can't miss an archived
+// member. Bulk revocation by user clears the user's archived tokens.
+// Archived tokens are not listed by FindByUser.
+type ArchivingDriver struct {
+	inner   Driver
+	archive Archive
+	clock
+}
+
+var _ Driver = (*ArchivingDriver)(nil)
+
+func NewArchivingDriver(inner Driver, archive Archive) *ArchivingDriver {
+	return &ArchivingDriver{inner: inner, archive: archive}
+}
+
+// SetClock hands now to the archive as well, when it reads the time
+func (a *ArchivingDriver) SetClock(now func() time.Time) {
+	a.clock.SetClock(now)
+	if c, ok := a.archive.(Clocked); ok {
+		c.SetClock(now)
+	}
+}
+
+func (a *ArchivingDriver) Unwrap() Driver {
+	return a.inner
+}
+
+func (a *ArchivingDriver) Capabilities() Capabilities {
+	return CapabilitiesOf(a.inner)
+}
+
+// ArchiveInactive moves live tokens last used (or, if never used, created)
+// before cutoff to the archive and returns how many were moved. Each batch
+// is written to the archive before it is deleted from the hot driver, so an
+// interrupted run leaves tokens in both, never in neither.
+func (a *ArchivingDriver) ArchiveInactive(cutoff time.Time) (int64, error) {
+	sc, ok := ScannerOf(a.inner)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+
+	var idle []*entity.PersonalAccessToken
+	err := sc.ScanTokens(func(t *entity.PersonalAccessToken) error {
+		if t.RevokedAt != nil || t.UniqueName != nil || t.FamilyID != "" {
+			return nil
+		}
+		last := t.CreatedAt
+		if t.LastUsedAt != nil {
+			last = *t.LastUsedAt
+		}
+		if last.Before(cutoff) {
+			idle = append(idle, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var moved int64
+	for start := 0; start < len(idle); start += archiveBatch {
+		batch := idle[start:min(start+archiveBatch, len(idle))]
+		if err := a.archive.Put(batch); err != nil {
+			return moved, err
+		}
+		for _, t := range batch {
+			if err := a.inner.RevokeToken(t.Token); err != nil {
+				return moved, err
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// FindByHash rehydrates archived tokens into the hot driver. Expired ones
+// are dropped from the archive instead and returned for the caller to
+// reject.
+func (a *ArchivingDriver) FindByHash(hash string) (*entity.PersonalAccessToken, error) {
+	tok, err := a.inner.FindByHash(hash)
+	if !isNotFound(err) {
+		return tok, err
+	}
+	return a.rehydrate(hash)
+}
+
+func (a *ArchivingDriver) rehydrate(hash string) (*entity.PersonalAccessToken, error) {
+	tok, err := a.archive.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.ExpiresAt != nil && a.Now().After(*tok.ExpiresAt) {
+		_ = a.archive.Delete(hash)
+		return tok, nil
+	}
+
+	// A concurrent lookup may have rehydrated it first
+	if err := a.inner.StoreToken(tok); err != nil {
+		if hot, ferr := a.inner.FindByHash(hash); ferr == nil {
+			return hot, nil
+		}
+		return nil, err
+	}
+	if err := a.archive.Delete(hash); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+func (a *ArchivingDriver) FindByID(id int64) (*entity.PersonalAccessToken, error) {
+	return a.inner.FindByID(id)
+}
+
+// CountByUser counts hot tokens only; archived tokens are not counted
+func (a *ArchivingDriver) CountByUser(userID int64) (int64, error) {
+	counter, ok := a.inner.(UserCounter)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	return counter.CountByUser(userID)
+}
+
+func (a *ArchivingDriver) CountByOrg(orgID int64, kind string) (int64, error) {
+	counter, ok := a.inner.(OrgCounter)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	return counter.CountByOrg(orgID, kind)
+}
+
+func (a *ArchivingDriver) ChangesSince(after ChangeCursor, limit int) ([]*entity.PersonalAccessToken, error) {
+	feed, ok := a.inner.(ChangeFeed)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return feed.ChangesSince(after, limit)
+}
+
+func (a *ArchivingDriver) FindByUser(userID int64, opts ListOptions) ([]*entity.PersonalAccessToken, int64, error) {
+	return a.inner.FindByUser(userID, opts)
+}
+
+func (a *ArchivingDriver) StoreToken(t *entity.PersonalAccessToken) error {
+	return a.inner.StoreToken(t)
+}
+
+func (a *ArchivingDriver) StoreTokens(ts []*entity.PersonalAccessToken) error {
+	batch, ok := a.inner.(BatchStorer)
+	if !ok {
+		return utils.ErrNotSupported
+	}
+	return batch.StoreTokens(ts)
+}
+
+func (a *ArchivingDriver) UpsertToken(t *entity.PersonalAccessToken) error {
+	up, ok := a.inner.(Upserter)
+	if !ok {
+		return utils.ErrNotSupported
+	}
+	return up.UpsertToken(t)
+}
+
+func (a *ArchivingDriver) UpdateToken(t *entity.PersonalAccessToken) error {
+	return a.inner.UpdateToken(t)
+}
+
+// RevokeToken deletes the token from both the hot driver and the archive
+func (a *ArchivingDriver) RevokeToken(hash string) error {
+	hotErr := a.inner.RevokeToken(hash)
+	if hotErr != nil && !isNotFound(hotErr) {
+		return hotErr
+	}
+	archErr := a.archive.Delete(hash)
+	if archErr != nil && !isNotFound(archErr) {
+		return archErr
+	}
+	if hotErr != nil && archErr != nil {
+		return hotErr
+	}
+	return nil
+}
+
+// MarkRevoked rehydrates an archived token first, so it is kept as a
+// revoked record like any other
+func (a *ArchivingDriver) MarkRevoked(hash string, at time.Time) error {
+	if _, err := a.inner.FindByHash(hash); isNotFound(err) {
+		if _, err := a.rehydrate(hash); err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+	return a.inner.MarkRevoked(hash, at)
+}
+
+func (a *ArchivingDriver) RevokeFamily(familyID string, at time.Time) (int64, error) {
+	return a.inner.RevokeFamily(familyID, at)
+}
+
+func (a *ArchivingDriver) RevokeByUser(userID int64) (int64, error) {
+	bulk, ok := a.inner.(BulkRevoker)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	n, err := bulk.RevokeByUser(userID)
+	if err != nil {
+		return n, err
+	}
+	archived, err := a.archive.DeleteByUser(userID)
+	return n + archived, err
+}
+
+// MarkRevokedByUser deletes the user's archived tokens outright; only hot
+// tokens are kept as revoked records
+func (a *ArchivingDriver) MarkRevokedByUser(userID int64, at time.Time) (int64, error) {
+	soft, ok := a.inner.(SoftRevoker)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	n, err := soft.MarkRevokedByUser(userID, at)
+	if err != nil {
+		return n, err
+	}
+	archived, err := a.archive.DeleteByUser(userID)
+	return n + archived, err
+}
+
+func (a *ArchivingDriver) DeleteRevoked(before time.Time) (int64, error) {
+	soft, ok := a.inner.(SoftRevoker)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	return soft.DeleteRevoked(before)
+}
+
+func (a *ArchivingDriver) DeleteExpired(before time.Time) (int64, error) {
+	return a.inner.DeleteExpired(before)
+}
+
+func (a *ArchivingDriver) TouchLastUsed(id int64) error {
+	return a.inner.TouchLastUsed(id)
+}
+
+func (a *ArchivingDriver) UpdateExpiry(id int64, expiresAt time.Time) error {
+	return a.inner.UpdateExpiry(id, expiresAt)
+}
+
+func (a *ArchivingDriver) LinkIdentity(link *entity.IdentityLink) error {
+	store, ok := a.inner.(IdentityStore)
+	if !ok {
+		return utils.ErrNotSupported
+	}
+	return store.LinkIdentity(link)
+}
+
+func (a *ArchivingDriver) UnlinkIdentity(provider, subject string) error {
+	store, ok := a.inner.(IdentityStore)
+	if !ok {
+		return utils.ErrNotSupported
+	}
+	return store.UnlinkIdentity(provider, subject)
+}
+
+func (a *ArchivingDriver) FindIdentity(provider, subject string) (*entity.IdentityLink, error) {
+	store, ok := a.inner.(IdentityStore)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return store.FindIdentity(provider, subject)
+}
+
+func (a *ArchivingDriver) ListIdentities(userID int64) ([]*entity.IdentityLink, error) {
+	store, ok := a.inner.(IdentityStore)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return store.ListIdentities(userID)
+}
+
+func (a *ArchivingDriver) orgStore() (OrgStore, error) {
+	store, ok := a.inner.(OrgStore)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return store, nil
+}
+
+func (a *ArchivingDriver) CreateOrgUnit(u *entity.OrgUnit) error {
+	store, err := a.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.CreateOrgUnit(u)
+}
+
+func (a *ArchivingDriver) FindOrgUnit(id int64) (*entity.OrgUnit, error) {
+	store, err := a.orgStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.FindOrgUnit(id)
+}
+
+func (a *ArchivingDriver) AddOrgMember(m *entity.OrgMember) error {
+	store, err := a.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.AddOrgMember(m)
+}
+
+func (a *ArchivingDriver) RemoveOrgMember(unitID, userID int64) error {
+	store, err := a.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.RemoveOrgMember(unitID, userID)
+}
+
+func (a *ArchivingDriver) UserOrgUnits(userID int64) ([]int64, error) {
+	store, err := a.orgStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.UserOrgUnits(userID)
+}
+
+func (a *ArchivingDriver) GrantAbility(g *entity.AbilityGrant) error {
+	store, err := a.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.GrantAbility(g)
+}
+
+func (a *ArchivingDriver) RevokeAbility(subjectType string, subjectID int64, ability string) error {
+	store, err := a.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.RevokeAbility(subjectType, subjectID, ability)
+}
+
+func (a *ArchivingDriver) ListGrants(subjectType string, subjectIDs []int64) ([]*entity.AbilityGrant, error) {
+	store, err := a.orgStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.ListGrants(subjectType, subjectIDs)
+}
+
+func (a *ArchivingDriver) roleStore() (RoleStore, error) {
+	store, ok := a.inner.(RoleStore)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return store, nil
+}
+
+func (a *ArchivingDriver) SaveRole(r *entity.Role) error {
+	store, err := a.roleStore()
+	if err != nil {
+		return err
+	}
+	return store.SaveRole(r)
+}
+
+func (a *ArchivingDriver) FindRole(name string) (*entity.Role, error) {
+	store, err := a.roleStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.FindRole(name)
+}
+
+func (a *ArchivingDriver) DeleteRole(name string) error {
+	store, err := a.roleStore()
+	if err != nil {
+		return err
+	}
+	return store.DeleteRole(name)
+}
+
+func (a *ArchivingDriver) ListRoles() ([]*entity.Role, error) {
+	store, err := a.roleStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.ListRoles()
+}
@@ -0,0 +1,316 @@
+// Package storage internal/storage/archive_stores.go
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ArchivedToken is a row of the token archive table. The token is kept as
+// JSON so the archive needs no indexes beyond the hash and user.
+type ArchivedToken struct {
+	Hash       string    `gorm:"primaryKey;size:100"`
+	UserID     int64     `gorm:"index"`
+	Data       string    `gorm:"type:text;not null"`
+	ArchivedAt time.Time `gorm:"not null"`
+}
+
+func (ArchivedToken) TableName() string { return "personal_access_tokens_archive" }
+
+// TableArchive archives tokens to a separate table, typically in a cheaper
+// database than the hot one (db.AutoMigrate(&ArchivedToken{})).
+type TableArchive struct {
+	db *gorm.DB
+	clock
+}
+
+var _ Archive = (*TableArchive)(nil)
+
+func NewTableArchive(db *gorm.DB) *TableArchive {
+	return &TableArchive{db: db}
+}
+
+func (a *TableArchive) Put(tokens []*entity.PersonalAccessToken) error {
+	rows := make([]ArchivedToken, 0, len(tokens))
+	now := a.Now()
+	for _, t := range tokens {
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, ArchivedToken{Hash: t.Token, UserID: t.UserId, Data: string(data), ArchivedAt: now})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return a.db.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(rows, 200).Error
+}
+
+func (a *TableArchive) Get(hash string) (*entity.PersonalAccessToken, error) {
+	var row ArchivedToken
+	err := a.db.First(&row, "hash = ?", hash).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t entity.PersonalAccessToken
+	if err := json.Unmarshal([]byte(row.Data), &t); err != nil {
+		return nil, fmt.Errorf("decode archived token: %w", err)
+	}
+	return &t, nil
+}
+
+func (a *TableArchive) Delete(hash string) error {
+	res := a.db.Delete(&ArchivedToken{}, "hash = ?", hash)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return utils.ErrTokenNotFound
+	}
+	return nil
+}
+
+func (a *TableArchive) DeleteByUser(userID int64) (int64, error) {
+	res := a.db.Delete(&ArchivedToken{}, "user_id = ?", userID)
+	return res.RowsAffected, res.Error
+}
+
+// JSONLArchive archives tokens to an append-only JSON Lines file, e.g. on a
+// volume synced to object storage. Deletions are appended as tombstones; an
+// index of live entries is rebuilt from the file on open.
+type JSONLArchive struct {
+	mu    sync.Mutex
+	path  string
+	f     *os.File
+	size  int64
+	index map[string]jsonlEntry
+}
+
+type jsonlEntry struct {
+	off    int64
+	n      int
+	userID int64
+}
+
+type jsonlRecord struct {
+	Token   *entity.PersonalAccessToken `json:"token,omitempty"`
+	Deleted string                      `json:"deleted,omitempty"`
+}
+
+var _ Archive = (*JSONLArchive)(nil)
+
+// OpenJSONLArchive opens or creates the archive file at path.
+func OpenJSONLArchive(path string) (*JSONLArchive, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	a := &JSONLArchive{path: path, f: f, index: make(map[string]jsonlEntry)}
+	if err := a.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("load archive %s: %w", path, err)
+	}
+	return a, nil
+}
+
+func (a *JSONLArchive) load() error {
+	r := bufio.NewReader(io.NewSectionReader(a.f, 0, 1<<62))
+	var off int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			a.apply(line, off)
+			off += int64(len(line))
+		}
+		if err == io.EOF {
+			// A torn final line from a crash is ignored and overwritten
+			a.size = off
+			return a.f.Truncate(off)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (a *JSONLArchive) apply(line []byte, off int64) {
+	var rec jsonlRecord
+	if json.Unmarshal(line, &rec) != nil {
+		return
+	}
+	switch {
+	case rec.Deleted != "":
+		delete(a.index, rec.Deleted)
+	case rec.Token != nil:
+		a.index[rec.Token.Token] = jsonlEntry{off: off, n: len(line), userID: rec.Token.UserId}
+	}
+}
+
+func (a *JSONLArchive) append(recs []jsonlRecord) error {
+	var buf bytes.Buffer
+	offs := make([]int64, len(recs))
+	for i, rec := range recs {
+		offs[i] = a.size + int64(buf.Len())
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if _, err := a.f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := a.f.Sync(); err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	for i := range recs {
+		end := len(data)
+		if i+1 < len(recs) {
+			end = int(offs[i+1] - a.size)
+		}
+		a.apply(data[offs[i]-a.size:end], offs[i])
+	}
+	a.size += int64(len(data))
+	return nil
+}
+
+func (a *JSONLArchive) Put(tokens []*entity.PersonalAccessToken) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	recs := make([]jsonlRecord, len(tokens))
+	for i, t := range tokens {
+		recs[i] = jsonlRecord{Token: t}
+	}
+	return a.append(recs)
+}
+
+func (a *JSONLArchive) Get(hash string) (*entity.PersonalAccessToken, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e, ok := a.index[hash]
+	if !ok {
+		return nil, utils.ErrTokenNotFound
+	}
+
+	line := make([]byte, e.n)
+	if _, err := a.f.ReadAt(line, e.off); err != nil {
+		return nil, err
+	}
+	var rec jsonlRecord
+	if err := json.Unmarshal(line, &rec); err != nil || rec.Token == nil {
+		return nil, fmt.Errorf("corrupt archive record at offset %d", e.off)
+	}
+	return rec.Token, nil
+}
+
+func (a *JSONLArchive) Delete(hash string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.index[hash]; !ok {
+		return utils.ErrTokenNotFound
+	}
+	return a.append([]jsonlRecord{{Deleted: hash}})
+}
+
+func (a *JSONLArchive) DeleteByUser(userID int64) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var recs []jsonlRecord
+	for hash, e := range a.index {
+		if e.userID == userID {
+			recs = append(recs, jsonlRecord{Deleted: hash})
+		}
+	}
+	if len(recs) == 0 {
+		return 0, nil
+	}
+	if err := a.append(recs); err != nil {
+		return 0, err
+	}
+	return int64(len(recs)), nil
+}
+
+// Maintain compacts the file, dropping tombstones and the records they
+// delete. The compacted file replaces the old one atomically.
+func (a *JSONLArchive) Maintain(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path)+".compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	index := make(map[string]jsonlEntry, len(a.index))
+	var off int64
+	for hash, e := range a.index {
+		if err := ctx.Err(); err != nil {
+			tmp.Close()
+			return err
+		}
+		line := make([]byte, e.n)
+		if _, err := a.f.ReadAt(line, e.off); err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := w.Write(line); err != nil {
+			tmp.Close()
+			return err
+		}
+		index[hash] = jsonlEntry{off: off, n: e.n, userID: e.userID}
+		off += int64(e.n)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), a.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	a.f.Close()
+	a.f, a.size, a.index = f, off, index
+	return nil
+}
+
+// Close closes the archive file.
+func (a *JSONLArchive) Close() error {
+	return a.f.Close()
+}
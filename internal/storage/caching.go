@@ -0,0 +1,435 @@
+// Package storage internal/storage/caching.go
+package storage
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// TokenCache stores tokens by hash for a CachingDriver. A nil token marks a
+// hash known not to exist. Get reports found=false on a miss.
+type TokenCache interface {
+	Get(hash string) (tok *entity.PersonalAccessToken, found bool, err error)
+	Set(hash string, tok *entity.PersonalAccessToken, ttl time.Duration) error
+	Delete(hashes ...string) error
+	Purge() error
+}
+
+// CacheOptions tunes a CachingDriver. Zero values select the defaults.
+type CacheOptions struct {
+	TTL         time.Duration // Lifetime of cached tokens. Default 1m
+	NegativeTTL time.Duration // Lifetime of cached misses. Default 10s; negative disables
+}
+
+func (o CacheOptions) withDefaults() CacheOptions {
+	if o.TTL <= 0 {
+		o.TTL = time.Minute
+	}
+	if o.NegativeTTL == 0 {
+		o.NegativeTTL = 10 * time.Second
+	}
+	return o
+}
+
+// CachingDriver serves FindByHash from a TokenCache in front of another
+// driver. Misses for unknown hashes are cached too, so floods of bogus
+// tokens don't reach the database. Created tokens are written through and
+// revocations invalidate the cache before and after the backend write.
+// Cache failures fall back to the backend.
+//
+// Changes that only touch last-used timestamps are not invalidated; they
+// don't affect validation.
+type CachingDriver struct {
+	inner  Driver
+	cache  TokenCache
+	opts   CacheOptions
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	tx     *txLog // Set on the driver WithinTransaction passes to fn
+	clock
+}
+
+var _ Driver = (*CachingDriver)(nil)
+
+func NewCachingDriver(inner Driver, cache TokenCache, opts CacheOptions) *CachingDriver {
+	return &CachingDriver{inner: inner, cache: cache, opts: opts.withDefaults()}
+}
+
+func (c *CachingDriver) Unwrap() Driver {
+	return c.inner
+}
+
+func (c *CachingDriver) Capabilities() Capabilities {
+	return CapabilitiesOf(c.inner)
+}
+
+func (c *CachingDriver) Stats() Stats {
+	return Stats{
+		Driver:      "cache",
+		CacheHits:   c.hits.Load(),
+		CacheMisses: c.misses.Load(),
+	}
+}
+
+func (c *CachingDriver) FindByHash(hash string) (*entity.PersonalAccessToken, error) {
+	if tok, found, err := c.cache.Get(hash); err == nil && found {
+		c.hits.Add(1)
+		if tok == nil {
+			return nil, utils.ErrTokenNotFound
+		}
+		if tok.ExpiresAt != nil && c.Now().After(*tok.ExpiresAt) {
+			return nil, utils.ErrTokenExpired
+		}
+		return tok, nil
+	}
+	c.misses.Add(1)
+
+	tok, err := c.inner.FindByHash(hash)
+	switch {
+	case err == nil:
+		c.set(tok)
+	case isNotFound(err) && c.opts.NegativeTTL > 0:
+		_ = c.cache.Set(hash, nil, c.opts.NegativeTTL)
+	}
+	return tok, err
+}
+
+// set caches tok, never beyond its expiry.
+func (c *CachingDriver) set(tok *entity.PersonalAccessToken) {
+	ttl := c.opts.TTL
+	if tok.ExpiresAt != nil {
+		left := tok.ExpiresAt.Sub(c.Now())
+		if left <= 0 {
+			return
+		}
+		ttl = min(ttl, left)
+	}
+	if c.tx != nil {
+		c.tx.touch(tok)
+		return
+	}
+	cp := *tok
+	_ = c.cache.Set(tok.Token, &cp, ttl)
+}
+
+// invalidate runs write and drops hashes from the cache before and after
+// it, so a concurrent miss can't re-cache the old record in between.
+func (c *CachingDriver) invalidate(write func() error, hashes ...string) error {
+	if c.tx != nil {
+		c.tx.hashes = append(c.tx.hashes, hashes...)
+	}
+	_ = c.cache.Delete(hashes...)
+	err := write()
+	_ = c.cache.Delete(hashes...)
+	return err
+}
+
+// purge runs write and empties the cache, for changes whose affected
+// hashes are unknown.
+func (c *CachingDriver) purge(write func() (int64, error)) (int64, error) {
+	n, err := write()
+	if n > 0 || err != nil {
+		_ = c.cache.Purge()
+		if c.tx != nil {
+			c.tx.purge = true
+		}
+	}
+	return n, err
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, utils.ErrTokenNotFound)
+}
+
+func (c *CachingDriver) FindByID(id int64) (*entity.PersonalAccessToken, error) {
+	return c.inner.FindByID(id)
+}
+
+func (c *CachingDriver) CountByUser(userID int64) (int64, error) {
+	counter, ok := c.inner.(UserCounter)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	return counter.CountByUser(userID)
+}
+
+func (c *CachingDriver) CountByOrg(orgID int64, kind string) (int64, error) {
+	counter, ok := c.inner.(OrgCounter)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	return counter.CountByOrg(orgID, kind)
+}
+
+func (c *CachingDriver) ChangesSince(after ChangeCursor, limit int) ([]*entity.PersonalAccessToken, error) {
+	feed, ok := c.inner.(ChangeFeed)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return feed.ChangesSince(after, limit)
+}
+
+func (c *CachingDriver) FindByUser(userID int64, opts ListOptions) ([]*entity.PersonalAccessToken, int64, error) {
+	return c.inner.FindByUser(userID, opts)
+}
+
+func (c *CachingDriver) StoreToken(t *entity.PersonalAccessToken) error {
+	if err := c.inner.StoreToken(t); err != nil {
+		return err
+	}
+	c.set(t)
+	return nil
+}
+
+func (c *CachingDriver) StoreTokens(ts []*entity.PersonalAccessToken) error {
+	batch, ok := c.inner.(BatchStorer)
+	if !ok {
+		return utils.ErrNotSupported
+	}
+	if err := batch.StoreTokens(ts); err != nil {
+		return err
+	}
+	for _, t := range ts {
+		c.set(t)
+	}
+	return nil
+}
+
+func (c *CachingDriver) UpsertToken(t *entity.PersonalAccessToken) error {
+	up, ok := c.inner.(Upserter)
+	if !ok {
+		return utils.ErrNotSupported
+	}
+
+	// The upsert overwrites the hash of the token in the same named slot
+	var replaced []string
+	if t.UniqueName != nil {
+		tokens, _, err := c.inner.FindByUser(t.UserId, ListOptions{})
+		if err != nil {
+			return err
+		}
+		for _, old := range tokens {
+			if old.UniqueName != nil && *old.UniqueName == *t.UniqueName {
+				replaced = append(replaced, old.Token)
+			}
+		}
+	}
+
+	if err := c.invalidate(func() error { return up.UpsertToken(t) }, replaced...); err != nil {
+		return err
+	}
+	c.set(t)
+	return nil
+}
+
+func (c *CachingDriver) UpdateToken(t *entity.PersonalAccessToken) error {
+	hashes := []string{t.Token}
+	if old, err := c.inner.FindByID(t.ID); err == nil && old.Token != t.Token {
+		hashes = append(hashes, old.Token)
+	}
+	return c.invalidate(func() error { return c.inner.UpdateToken(t) }, hashes...)
+}
+
+func (c *CachingDriver) RevokeToken(hash string) error {
+	return c.invalidate(func() error { return c.inner.RevokeToken(hash) }, hash)
+}
+
+func (c *CachingDriver) MarkRevoked(hash string, at time.Time) error {
+	return c.invalidate(func() error { return c.inner.MarkRevoked(hash, at) }, hash)
+}
+
+func (c *CachingDriver) RevokeFamily(familyID string, at time.Time) (int64, error) {
+	return c.purge(func() (int64, error) { return c.inner.RevokeFamily(familyID, at) })
+}
+
+func (c *CachingDriver) RevokeByUser(userID int64) (int64, error) {
+	bulk, ok := c.inner.(BulkRevoker)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	return c.purge(func() (int64, error) { return bulk.RevokeByUser(userID) })
+}
+
+func (c *CachingDriver) MarkRevokedByUser(userID int64, at time.Time) (int64, error) {
+	soft, ok := c.inner.(SoftRevoker)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	return c.purge(func() (int64, error) { return soft.MarkRevokedByUser(userID, at) })
+}
+
+// DeleteRevoked needs no invalidation: revoked tokens were dropped from
+// the cache when they were revoked.
+func (c *CachingDriver) DeleteRevoked(before time.Time) (int64, error) {
+	soft, ok := c.inner.(SoftRevoker)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	return soft.DeleteRevoked(before)
+}
+
+func (c *CachingDriver) UpdateExpiry(id int64, expiresAt time.Time) error {
+	old, err := c.inner.FindByID(id)
+	if err != nil {
+		return c.inner.UpdateExpiry(id, expiresAt)
+	}
+	return c.invalidate(func() error { return c.inner.UpdateExpiry(id, expiresAt) }, old.Token)
+}
+
+// DeleteExpired needs no invalidation: cached entries never outlive the
+// token's expiry.
+func (c *CachingDriver) DeleteExpired(before time.Time) (int64, error) {
+	return c.inner.DeleteExpired(before)
+}
+
+func (c *CachingDriver) TouchLastUsed(id int64) error {
+	return c.inner.TouchLastUsed(id)
+}
+
+func (c *CachingDriver) LinkIdentity(link *entity.IdentityLink) error {
+	store, ok := c.inner.(IdentityStore)
+	if !ok {
+		return utils.ErrNotSupported
+	}
+	return store.LinkIdentity(link)
+}
+
+func (c *CachingDriver) UnlinkIdentity(provider, subject string) error {
+	store, ok := c.inner.(IdentityStore)
+	if !ok {
+		return utils.ErrNotSupported
+	}
+	return store.UnlinkIdentity(provider, subject)
+}
+
+func (c *CachingDriver) FindIdentity(provider, subject string) (*entity.IdentityLink, error) {
+	store, ok := c.inner.(IdentityStore)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return store.FindIdentity(provider, subject)
+}
+
+func (c *CachingDriver) ListIdentities(userID int64) ([]*entity.IdentityLink, error) {
+	store, ok := c.inner.(IdentityStore)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return store.ListIdentities(userID)
+}
+
+func (c *CachingDriver) orgStore() (OrgStore, error) {
+	store, ok := c.inner.(OrgStore)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return store, nil
+}
+
+func (c *CachingDriver) CreateOrgUnit(u *entity.OrgUnit) error {
+	store, err := c.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.CreateOrgUnit(u)
+}
+
+func (c *CachingDriver) FindOrgUnit(id int64) (*entity.OrgUnit, error) {
+	store, err := c.orgStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.FindOrgUnit(id)
+}
+
+func (c *CachingDriver) AddOrgMember(m *entity.OrgMember) error {
+	store, err := c.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.AddOrgMember(m)
+}
+
+func (c *CachingDriver) RemoveOrgMember(unitID, userID int64) error {
+	store, err := c.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.RemoveOrgMember(unitID, userID)
+}
+
+func (c *CachingDriver) UserOrgUnits(userID int64) ([]int64, error) {
+	store, err := c.orgStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.UserOrgUnits(userID)
+}
+
+func (c *CachingDriver) GrantAbility(g *entity.AbilityGrant) error {
+	store, err := c.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.GrantAbility(g)
+}
+
+func (c *CachingDriver) RevokeAbility(subjectType string, subjectID int64, ability string) error {
+	store, err := c.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.RevokeAbility(subjectType, subjectID, ability)
+}
+
+func (c *CachingDriver) ListGrants(subjectType string, subjectIDs []int64) ([]*entity.AbilityGrant, error) {
+	store, err := c.orgStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.ListGrants(subjectType, subjectIDs)
+}
+
+func (c *CachingDriver) roleStore() (RoleStore, error) {
+	store, ok := c.inner.(RoleStore)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return store, nil
+}
+
+func (c *CachingDriver) SaveRole(r *entity.Role) error {
+	store, err := c.roleStore()
+	if err != nil {
+		return err
+	}
+	return store.SaveRole(r)
+}
+
+func (c *CachingDriver) FindRole(name string) (*entity.Role, error) {
+	store, err := c.roleStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.FindRole(name)
+}
+
+func (c *CachingDriver) DeleteRole(name string) error {
+	store, err := c.roleStore()
+	if err != nil {
+		return err
+	}
+	return store.DeleteRole(name)
+}
+
+func (c *CachingDriver) ListRoles() ([]*entity.Role, error) {
+	store, err := c.roleStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.ListRoles()
+}
@@ -0,0 +1,83 @@
+// Package storage internal/storage/capabilities.go
+package storage
+
+import (
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// Capabilities describes optional features a storage driver supports.
+type Capabilities struct {
+	List           bool // FindByUser returns real results
+	BulkRevoke     bool // Implements BulkRevoker
+	TTLEnforcement bool // Expired tokens are rejected by the driver itself
+	Transactions   bool // Mutations can run atomically
+	Upsert         bool // Implements Upserter
+	SoftRevoke     bool // Implements SoftRevoker
+	BatchInsert    bool // Implements BatchStorer
+	Count          bool // Implements UserCounter
+	Changes        bool // Implements ChangeFeed
+}
+
+// Capable is implemented by drivers that advertise their capabilities.
+type Capable interface {
+	Capabilities() Capabilities
+}
+
+// BulkRevoker is implemented by drivers that can revoke many tokens at once.
+type BulkRevoker interface {
+	RevokeByUser(userID int64) (int64, error)
+}
+
+// SoftRevoker is implemented by drivers that can mark all of a user's
+// tokens revoked, keeping the rows, and later delete revoked tokens.
+type SoftRevoker interface {
+	MarkRevokedByUser(userID int64, at time.Time) (int64, error)
+	DeleteRevoked(before time.Time) (int64, error)
+}
+
+// BatchStorer is implemented by drivers that can insert many tokens in one
+// round trip. Either every token is stored or none is.
+type BatchStorer interface {
+	StoreTokens(ts []*entity.PersonalAccessToken) error
+}
+
+// UserCounter is implemented by drivers that can count a user's live
+// access tokens, i.e. those neither revoked nor expired.
+type UserCounter interface {
+	CountByUser(userID int64) (int64, error)
+}
+
+// Upserter is implemented by drivers that can atomically replace a user's
+// token with the same UniqueName, or insert it when there is none.
+type Upserter interface {
+	UpsertToken(t *entity.PersonalAccessToken) error
+}
+
+// CapabilitiesOf reports the capabilities of a driver. Drivers that don't
+// implement Capable are assumed to support listing only, plus whatever
+// optional interfaces they satisfy.
+func CapabilitiesOf(d Driver) Capabilities {
+	if c, ok := d.(Capable); ok {
+		return c.Capabilities()
+	}
+
+	_, bulk := d.(BulkRevoker)
+	_, upsert := d.(Upserter)
+	_, soft := d.(SoftRevoker)
+	_, batch := d.(BatchStorer)
+	_, count := d.(UserCounter)
+	_, changes := d.(ChangeFeed)
+	_, tx := d.(Transactor)
+	return Capabilities{
+		List:         true,
+		BulkRevoke:   bulk,
+		Upsert:       upsert,
+		SoftRevoke:   soft,
+		BatchInsert:  batch,
+		Count:        count,
+		Changes:      changes,
+		Transactions: tx,
+	}
+}
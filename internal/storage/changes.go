@@ -0,0 +1,62 @@
+// Package storage internal/storage/changes.go
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// ChangeCursor is a position in a driver's change feed: the revision and
+// ID of the last change read. The zero cursor starts before every change.
+type ChangeCursor struct {
+	Revision int64
+	ID       int64
+}
+
+// CursorOf returns the cursor just past t's change
+func CursorOf(t *entity.PersonalAccessToken) ChangeCursor {
+	return ChangeCursor{Revision: t.Revision, ID: t.ID}
+}
+
+// Before reports whether t changed after the cursor
+func (c ChangeCursor) Before(t *entity.PersonalAccessToken) bool {
+	return t.Revision > c.Revision || t.Revision == c.Revision && t.ID > c.ID
+}
+
+// ChangeFeed is implemented by drivers that stamp every token change with
+// UpdatedAt and Revision. Creations, revocations, expiry changes and
+// updates are changes; last-used touches are not, and deleted tokens drop
+// out of the feed.
+type ChangeFeed interface {
+	// ChangesSince returns up to limit tokens (0 = all) changed after the
+	// cursor, in their current state and ordered by revision, then ID
+	ChangesSince(after ChangeCursor, limit int) ([]*entity.PersonalAccessToken, error)
+}
+
+// lastRevision is the latest revision handed out by nextRevision
+var lastRevision atomic.Int64
+
+// nextRevision returns the revision of a change made at now: its time in
+// nanoseconds, or one past the previous revision if the clock hasn't moved
+// on. Revisions strictly increase within a process and follow the clock
+// across processes.
+func nextRevision(now time.Time) int64 {
+	rev := now.UnixNano()
+	for {
+		last := lastRevision.Load()
+		if rev <= last {
+			rev = last + 1
+		}
+		if lastRevision.CompareAndSwap(last, rev) {
+			return rev
+		}
+	}
+}
+
+// stamp records a change to t made at now
+func stamp(t *entity.PersonalAccessToken, now time.Time) {
+	t.UpdatedAt = now
+	t.Revision = nextRevision(now)
+}
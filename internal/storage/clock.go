@@ -0,0 +1,42 @@
+// Package storage internal/storage/clock.go
+package storage
+
+import "time"
+
+// Clocked is implemented by drivers that read the time themselves, e.g. to
+// reject expired tokens, so a client's clock can replace time.Now.
+type Clocked interface {
+	SetClock(now func() time.Time)
+}
+
+// SetClock hands now to every Clocked driver in a chain.
+func SetClock(d Driver, now func() time.Time) {
+	for d != nil {
+		if c, ok := d.(Clocked); ok {
+			c.SetClock(now)
+		}
+		w, ok := d.(Wrapper)
+		if !ok {
+			break
+		}
+		d = w.Unwrap()
+	}
+}
+
+// clock is embedded by drivers to implement Clocked. The zero value reads
+// time.Now.
+type clock struct {
+	now func() time.Time
+}
+
+func (c *clock) SetClock(now func() time.Time) {
+	c.now = now
+}
+
+// Now returns the current time of the clock
+func (c *clock) Now() time.Time {
+	if c.now == nil {
+		return time.Now()
+	}
+	return c.now()
+}
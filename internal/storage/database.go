@@ -2,56 +2,588 @@
 package storage
 
 import (
+	"errors"
 	"github.com/mohar9h/goauth/internal/utils"
 	"time"
 
 	"github.com/mohar9h/goauth/internal/entity"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type gormDriver struct {
-	db *gorm.DB
+	db     *gorm.DB
+	rec    records        // Token model
+	ext    EntityExtender // Set by Extend
+	fields *FieldCipher   // Set by EncryptFields
+	clock
 }
 
 func NewGormDriver(db *gorm.DB) Driver {
-	return &gormDriver{db: db}
+	return &gormDriver{db: db, rec: defaultRecords}
 }
 
+// NewGormModelDriver stores tokens in the GORM model M instead of
+// PersonalAccessToken. M must have every column in TokenColumns, renamed
+// through TokenColumnMapper where its names differ.
+func NewGormModelDriver[M any, PM RecordPtr[M]](db *gorm.DB) (Driver, error) {
+	rec, err := newModelRecords[M, PM](db)
+	if err != nil {
+		return nil, err
+	}
+	return &gormDriver{db: db, rec: rec}, nil
+}
+
+// c returns the model's name for goauth's column name
+func (g *gormDriver) c(name string) string {
+	return g.rec.col(name)
+}
+
+// cols maps goauth's column names to the model's
+func (g *gormDriver) cols(names ...string) []clause.Column {
+	cols := make([]clause.Column, len(names))
+	for i, n := range names {
+		cols[i] = clause.Column{Name: g.c(n)}
+	}
+	return cols
+}
+
+func (g *gormDriver) Capabilities() Capabilities {
+	return Capabilities{
+		List:           true,
+		BulkRevoke:     true,
+		TTLEnforcement: true,
+		Transactions:   true,
+		Upsert:         true,
+		SoftRevoke:     true,
+		BatchInsert:    true,
+		Count:          true,
+		Changes:        g.rec.tracked(),
+	}
+}
+
+func (g *gormDriver) Stats() Stats {
+	st := Stats{Driver: "gorm"}
+
+	sqlDB, err := g.db.DB()
+	if err != nil {
+		return st
+	}
+
+	db := sqlDB.Stats()
+	st.OpenConnections = db.OpenConnections
+	st.InUse = db.InUse
+	st.Idle = db.Idle
+	st.WaitCount = db.WaitCount
+	return st
+}
+
+// changed adds the change columns to the values of an update
+func (g *gormDriver) changed(values map[string]any) map[string]any {
+	if g.rec.tracked() {
+		now := g.Now()
+		values[g.c("updated_at")] = now
+		values[g.c("revision")] = nextRevision(now)
+	}
+	return values
+}
+
+// StoreToken inserts t. A duplicate hash is reported as ErrDuplicateToken
+// instead of a dialect specific constraint error.
 func (g *gormDriver) StoreToken(t *entity.PersonalAccessToken) error {
-	return g.db.Create(t).Error
+	stamp(t, g.Now())
+	rows, err := g.sealed(t)
+	if err != nil {
+		return err
+	}
+	return g.withExtra(func(tx *gorm.DB) error {
+		n, err := g.rec.create(tx.Clauses(clause.OnConflict{
+			Columns:   g.cols("token"),
+			DoNothing: true,
+		}), batchSize, rows...)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return utils.ErrDuplicateToken
+		}
+		written([]*entity.PersonalAccessToken{t}, rows)
+		return g.saveExtra(tx, t)
+	})
+}
+
+// batchSize bounds the rows per INSERT so large batches stay below the
+// dialects' bind parameter limits
+const batchSize = 200
+
+// StoreTokens inserts ts in one transaction using multi-row INSERTs. A
+// duplicate hash rolls back the whole batch with ErrDuplicateToken.
+func (g *gormDriver) StoreTokens(ts []*entity.PersonalAccessToken) error {
+	if len(ts) == 0 {
+		return nil
+	}
+	for _, t := range ts {
+		stamp(t, g.Now())
+	}
+	rows, err := g.sealed(ts...)
+	if err != nil {
+		return err
+	}
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		n, err := g.rec.create(tx.Clauses(clause.OnConflict{
+			Columns:   g.cols("token"),
+			DoNothing: true,
+		}), batchSize, rows...)
+		if err != nil {
+			return err
+		}
+		if n != int64(len(ts)) {
+			return utils.ErrDuplicateToken
+		}
+		written(ts, rows)
+		return g.saveExtra(tx, ts...)
+	})
+}
+
+// upsertColumns are overwritten when UpsertToken replaces an existing row
+var upsertColumns = []string{
+	"token", "name", "abilities", "kind", "family_id", "metadata",
+	"created_at", "expires_at", "last_used_at", "revoked_at",
+}
+
+// UpsertToken inserts t or replaces the user's token with the same
+// UniqueName in one statement (ON CONFLICT / ON DUPLICATE KEY UPDATE,
+// depending on the dialect). t.ID is set to the ID of the stored row.
+func (g *gormDriver) UpsertToken(t *entity.PersonalAccessToken) error {
+	stamp(t, g.Now())
+	rows, err := g.sealed(t)
+	if err != nil {
+		return err
+	}
+	return g.withExtra(func(tx *gorm.DB) error {
+		columns := upsertColumns
+		if g.rec.tracked() {
+			columns = append(columns[:len(columns):len(columns)], ChangeColumns...)
+		}
+		update := make([]string, len(columns))
+		for i, c := range columns {
+			update[i] = g.c(c)
+		}
+		_, err := g.rec.create(tx.Clauses(clause.OnConflict{
+			Columns:   g.cols("user_id", "unique_name"),
+			DoUpdates: clause.AssignmentColumns(update),
+		}), batchSize, rows...)
+		if err != nil {
+			return err
+		}
+
+		// MySQL reports no usable insert ID for an updated row
+		err = tx.Model(g.rec.model()).
+			Where(g.c("token")+" = ?", t.Token).
+			Select(g.c("id")).
+			Scan(&t.ID).
+			Error
+		if err != nil {
+			return err
+		}
+		t.CreatedAt = rows[0].CreatedAt
+		return g.saveExtra(tx, t)
+	})
+}
+
+func (g *gormDriver) UpdateToken(t *entity.PersonalAccessToken) error {
+	stamp(t, g.Now())
+	rows, err := g.sealed(t)
+	if err != nil {
+		return err
+	}
+	return g.withExtra(func(tx *gorm.DB) error {
+		if err := g.rec.save(tx, rows[0]); err != nil {
+			return err
+		}
+		written([]*entity.PersonalAccessToken{t}, rows)
+		return g.saveExtra(tx, t)
+	})
 }
 
 func (g *gormDriver) FindByID(id int64) (*entity.PersonalAccessToken, error) {
-	var t entity.PersonalAccessToken
-	if err := g.db.First(&t, "id = ?", id).Error; err != nil {
-		return nil, err
+	t, err := g.rec.first(g.db, g.c("id")+" = ?", id)
+	if err != nil {
+		return nil, notFound(err)
 	}
 
-	if t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt) {
+	if t.ExpiresAt != nil && g.Now().After(*t.ExpiresAt) {
 		return nil, utils.ErrTokenExpired
 	}
-	return &t, nil
+	if err := g.loaded(t); err != nil {
+		return nil, err
+	}
+	return t, nil
 }
 
 func (g *gormDriver) FindByHash(hash string) (*entity.PersonalAccessToken, error) {
-	var t entity.PersonalAccessToken
+	t, err := g.rec.first(g.db, g.c("token")+" = ?", hash)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if t.ExpiresAt != nil && g.Now().After(*t.ExpiresAt) {
+		return nil, utils.ErrTokenExpired
+	}
+	if err := g.loaded(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// notFound maps a missing row to ErrTokenNotFound
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return utils.ErrTokenNotFound
+	}
+	return err
+}
+
+// CountByUser counts the user's live access tokens
+func (g *gormDriver) CountByUser(userID int64) (int64, error) {
+	var n int64
+	err := g.db.Model(g.rec.model()).
+		Where(g.c("user_id")+" = ?", userID).
+		Where(g.live(), g.Now()).
+		Where(g.c("kind")+" = ? OR "+g.c("kind")+" = '' OR "+g.c("kind")+" IS NULL", entity.KindAccess).
+		Count(&n).Error
+	return n, err
+}
+
+func (g *gormDriver) FindByUser(userID int64, opts ListOptions) ([]*entity.PersonalAccessToken, int64, error) {
+	now := g.Now()
+	q := g.db.Model(g.rec.model()).Where(g.c("user_id")+" = ?", userID)
+
+	revokedAt, expiresAt := g.c("revoked_at"), g.c("expires_at")
+	switch opts.Status {
+	case StatusActive:
+		q = q.Where(g.live(), now)
+	case StatusExpired:
+		q = q.Where(revokedAt+" IS NULL").Where(expiresAt+" IS NOT NULL AND "+expiresAt+" <= ?", now)
+	case StatusRevoked:
+		q = q.Where(revokedAt + " IS NOT NULL")
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	q = q.Order(g.c("id")).Offset(opts.Offset)
+	if opts.Limit > 0 {
+		q = q.Limit(opts.Limit)
+	}
 
-	if err := g.db.First(&t, "token = ?", hash).Error; err != nil {
+	tokens, err := g.rec.find(q)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := g.loaded(tokens...); err != nil {
+		return nil, 0, err
+	}
+	return tokens, total, nil
+}
+
+// ScanTokens calls fn for every stored token, reading in batches
+func (g *gormDriver) ScanTokens(fn func(t *entity.PersonalAccessToken) error) error {
+	return g.rec.findInBatches(g.db, 500, func(batch []*entity.PersonalAccessToken) error {
+		if err := g.loaded(batch...); err != nil {
+			return err
+		}
+		for _, t := range batch {
+			if err := fn(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ChangesSince reads the tokens changed after the cursor, using the
+// revision index
+func (g *gormDriver) ChangesSince(after ChangeCursor, limit int) ([]*entity.PersonalAccessToken, error) {
+	if !g.rec.tracked() {
+		return nil, utils.ErrNotSupported
+	}
+	rev, id := g.c("revision"), g.c("id")
+	q := g.db.Model(g.rec.model()).
+		Where(rev+" > ? OR ("+rev+" = ? AND "+id+" > ?)", after.Revision, after.Revision, after.ID).
+		Order(rev).
+		Order(id)
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+
+	tokens, err := g.rec.find(q)
+	if err != nil {
 		return nil, err
 	}
-	if t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt) {
-		return nil, utils.ErrTokenExpired
+	if err := g.loaded(tokens...); err != nil {
+		return nil, err
 	}
-	return &t, nil
+	return tokens, nil
+}
+
+// live is the condition for unrevoked, unexpired tokens given the time
+func (g *gormDriver) live() string {
+	return g.c("revoked_at") + " IS NULL AND (" + g.c("expires_at") + " IS NULL OR " + g.c("expires_at") + " > ?)"
 }
 
 func (g *gormDriver) RevokeToken(hash string) error {
-	return g.db.Delete(&entity.PersonalAccessToken{}, "token = ?", hash).Error
+	return g.db.Delete(g.rec.model(), g.c("token")+" = ?", hash).Error
+}
+
+func (g *gormDriver) MarkRevoked(hash string, at time.Time) error {
+	res := g.db.Model(g.rec.model()).
+		Where(g.c("token")+" = ? AND "+g.c("revoked_at")+" IS NULL", hash).
+		Updates(g.changed(map[string]any{g.c("revoked_at"): at}))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return utils.ErrTokenRevoked
+	}
+	return nil
+}
+
+func (g *gormDriver) RevokeFamily(familyID string, at time.Time) (int64, error) {
+	if familyID == "" {
+		return 0, nil
+	}
+	res := g.db.Model(g.rec.model()).
+		Where(g.c("family_id")+" = ? AND "+g.c("revoked_at")+" IS NULL", familyID).
+		Updates(g.changed(map[string]any{g.c("revoked_at"): at}))
+	return res.RowsAffected, res.Error
+}
+
+func (g *gormDriver) DeleteExpired(before time.Time) (int64, error) {
+	res := g.db.Delete(g.rec.model(), g.c("expires_at")+" IS NOT NULL AND "+g.c("expires_at")+" <= ?", before)
+	return res.RowsAffected, res.Error
+}
+
+func (g *gormDriver) RevokeByUser(userID int64) (int64, error) {
+	res := g.db.Delete(g.rec.model(), g.c("user_id")+" = ?", userID)
+	return res.RowsAffected, res.Error
+}
+
+func (g *gormDriver) MarkRevokedByUser(userID int64, at time.Time) (int64, error) {
+	res := g.db.Model(g.rec.model()).
+		Where(g.c("user_id")+" = ? AND "+g.c("revoked_at")+" IS NULL", userID).
+		Updates(g.changed(map[string]any{g.c("revoked_at"): at}))
+	return res.RowsAffected, res.Error
+}
+
+func (g *gormDriver) DeleteRevoked(before time.Time) (int64, error) {
+	res := g.db.Delete(g.rec.model(), g.c("revoked_at")+" IS NOT NULL AND "+g.c("revoked_at")+" <= ?", before)
+	return res.RowsAffected, res.Error
 }
 
 func (g *gormDriver) TouchLastUsed(id int64) error {
-	return g.db.Model(&entity.PersonalAccessToken{}).
-		Where("id = ?", id).
-		Update("last_used_at", time.Now()).
+	return g.db.Model(g.rec.model()).
+		Where(g.c("id")+" = ?", id).
+		UpdateColumn(g.c("last_used_at"), g.Now()).
 		Error
 }
+
+func (g *gormDriver) UpdateExpiry(id int64, expiresAt time.Time) error {
+	return g.db.Model(g.rec.model()).
+		Where(g.c("id")+" = ?", id).
+		Updates(g.changed(map[string]any{g.c("expires_at"): expiresAt})).
+		Error
+}
+
+// LinkIdentity detects a taken provider/subject through the affected row
+// count, like StoreToken, rather than a dialect specific constraint error
+func (g *gormDriver) LinkIdentity(link *entity.IdentityLink) error {
+	res := g.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "provider"}, {Name: "subject"}},
+		DoNothing: true,
+	}).Create(link)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return utils.ErrIdentityAlreadyLinked
+	}
+	return nil
+}
+
+func (g *gormDriver) UnlinkIdentity(provider, subject string) error {
+	res := g.db.Delete(&entity.IdentityLink{}, "provider = ? AND subject = ?", provider, subject)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return utils.ErrIdentityNotFound
+	}
+	return nil
+}
+
+func (g *gormDriver) FindIdentity(provider, subject string) (*entity.IdentityLink, error) {
+	var link entity.IdentityLink
+	err := g.db.First(&link, "provider = ? AND subject = ?", provider, subject).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (g *gormDriver) ListIdentities(userID int64) ([]*entity.IdentityLink, error) {
+	var links []*entity.IdentityLink
+	if err := g.db.Where("user_id = ?", userID).Order("id").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (g *gormDriver) CreateOrgUnit(u *entity.OrgUnit) error {
+	if u.ParentID != nil {
+		if _, err := g.FindOrgUnit(*u.ParentID); err != nil {
+			return err
+		}
+	}
+	return g.db.Create(u).Error
+}
+
+func (g *gormDriver) FindOrgUnit(id int64) (*entity.OrgUnit, error) {
+	var u entity.OrgUnit
+	err := g.db.First(&u, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.ErrOrgUnitNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (g *gormDriver) AddOrgMember(m *entity.OrgMember) error {
+	if _, err := g.FindOrgUnit(m.UnitID); err != nil {
+		return err
+	}
+	return g.db.Clauses(clause.OnConflict{DoNothing: true}).Create(m).Error
+}
+
+func (g *gormDriver) RemoveOrgMember(unitID, userID int64) error {
+	return g.db.Delete(&entity.OrgMember{}, "unit_id = ? AND user_id = ?", unitID, userID).Error
+}
+
+func (g *gormDriver) UserOrgUnits(userID int64) ([]int64, error) {
+	var ids []int64
+	err := g.db.Model(&entity.OrgMember{}).
+		Where("user_id = ?", userID).
+		Order("unit_id").
+		Pluck("unit_id", &ids).
+		Error
+	return ids, err
+}
+
+// CountByOrg counts the live tokens of kind held by members of the org's
+// subtree. The subtree is read one level per query, then counted at once.
+func (g *gormDriver) CountByOrg(orgID int64, kind string) (int64, error) {
+	units := []int64{orgID}
+	seen := map[int64]bool{orgID: true}
+	for level := units; len(level) > 0; {
+		var children []int64
+		err := g.db.Model(&entity.OrgUnit{}).Where("parent_id IN ?", level).Pluck("id", &children).Error
+		if err != nil {
+			return 0, err
+		}
+		level = level[:0:0]
+		for _, id := range children {
+			if !seen[id] {
+				seen[id] = true
+				level = append(level, id)
+			}
+		}
+		units = append(units, level...)
+	}
+
+	members := g.db.Model(&entity.OrgMember{}).Select("user_id").Where("unit_id IN ?", units)
+	q := g.db.Model(g.rec.model()).
+		Where(g.c("user_id")+" IN (?)", members).
+		Where(g.live(), g.Now())
+	if kind == "" || kind == entity.KindAccess {
+		q = q.Where(g.c("kind")+" = ? OR "+g.c("kind")+" = '' OR "+g.c("kind")+" IS NULL", entity.KindAccess)
+	} else {
+		q = q.Where(g.c("kind")+" = ?", kind)
+	}
+
+	var n int64
+	err := q.Count(&n).Error
+	return n, err
+}
+
+func (g *gormDriver) GrantAbility(grant *entity.AbilityGrant) error {
+	return g.db.Clauses(clause.OnConflict{DoNothing: true}).Create(grant).Error
+}
+
+func (g *gormDriver) RevokeAbility(subjectType string, subjectID int64, ability string) error {
+	return g.db.Delete(&entity.AbilityGrant{},
+		"subject_type = ? AND subject_id = ? AND ability = ?", subjectType, subjectID, ability).Error
+}
+
+func (g *gormDriver) ListGrants(subjectType string, subjectIDs []int64) ([]*entity.AbilityGrant, error) {
+	var grants []*entity.AbilityGrant
+	if len(subjectIDs) == 0 {
+		return grants, nil
+	}
+	err := g.db.Where("subject_type = ? AND subject_id IN ?", subjectType, subjectIDs).
+		Order("id").
+		Find(&grants).
+		Error
+	return grants, err
+}
+
+func (g *gormDriver) SaveRole(r *entity.Role) error {
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		var existing entity.Role
+		err := tx.First(&existing, "name = ?", r.Name).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Create(r).Error
+		}
+		if err != nil {
+			return err
+		}
+		r.ID, r.CreatedAt = existing.ID, existing.CreatedAt
+		return tx.Save(r).Error
+	})
+}
+
+func (g *gormDriver) FindRole(name string) (*entity.Role, error) {
+	var r entity.Role
+	err := g.db.First(&r, "name = ?", name).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (g *gormDriver) DeleteRole(name string) error {
+	res := g.db.Delete(&entity.Role{}, "name = ?", name)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return utils.ErrRoleNotFound
+	}
+	return nil
+}
+
+func (g *gormDriver) ListRoles() ([]*entity.Role, error) {
+	var roles []*entity.Role
+	if err := g.db.Order("name").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
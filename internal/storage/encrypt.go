@@ -0,0 +1,272 @@
+// Package storage internal/storage/encrypt.go
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+	"gorm.io/gorm"
+)
+
+// FieldKey is a data-encryption key. ID is stored next to every ciphertext
+// it produces, so rows name the key that can open them.
+type FieldKey struct {
+	ID  string // Short, stable name, e.g. "2024-06"; may not contain ':'
+	Key []byte // 16, 24 or 32 bytes for AES-128, -192 or -256
+}
+
+// FieldCipher encrypts token metadata with AES-GCM. It seals with the
+// current key and opens rows sealed with any of its keys.
+type FieldCipher struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// ErrUnknownFieldKey is returned for rows sealed with a key the cipher
+// wasn't given.
+var ErrUnknownFieldKey = errors.New("metadata encrypted with an unknown key")
+
+// sealedKey is the only metadata key of a sealed row. Its value is
+// "v1:<key id>:<base64 nonce and ciphertext>".
+const sealedKey = "goauth:enc"
+
+// NewFieldCipher returns a cipher sealing with current. previous keys only
+// open existing rows.
+func NewFieldCipher(current FieldKey, previous ...FieldKey) (*FieldCipher, error) {
+	c := &FieldCipher{current: current.ID, aeads: make(map[string]cipher.AEAD)}
+	for _, k := range append([]FieldKey{current}, previous...) {
+		if k.ID == "" || strings.Contains(k.ID, ":") {
+			return nil, fmt.Errorf("field key ID %q must be non-empty and free of ':'", k.ID)
+		}
+		if _, dup := c.aeads[k.ID]; dup {
+			return nil, fmt.Errorf("duplicate field key ID %q", k.ID)
+		}
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("field key %q: %w", k.ID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads[k.ID] = aead
+	}
+	return c, nil
+}
+
+// Seal encrypts meta with the current key, bound to the token hash so a
+// ciphertext can't be moved to another row. Empty metadata stays empty.
+func (c *FieldCipher) Seal(meta map[string]string, hash string) (map[string]string, error) {
+	if len(meta) == 0 {
+		return meta, nil
+	}
+	plain, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	aead := c.aeads[c.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, plain, []byte(hash))
+	return map[string]string{sealedKey: "v1:" + c.current + ":" + base64.RawStdEncoding.EncodeToString(sealed)}, nil
+}
+
+// Open decrypts metadata sealed by Seal. Metadata stored before encryption
+// was enabled is returned as is.
+func (c *FieldCipher) Open(meta map[string]string, hash string) (map[string]string, error) {
+	kid, data, ok := sealedWith(meta)
+	if !ok {
+		return meta, nil
+	}
+	aead, known := c.aeads[kid]
+	if !known {
+		return nil, fmt.Errorf("%w %q", ErrUnknownFieldKey, kid)
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(raw) < aead.NonceSize() {
+		return nil, fmt.Errorf("malformed encrypted metadata")
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], []byte(hash))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt metadata: %w", err)
+	}
+	var out map[string]string
+	if err := json.Unmarshal(plain, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Current reports whether meta needs no rewrite: it is empty or sealed
+// with the current key.
+func (c *FieldCipher) Current(meta map[string]string) bool {
+	kid, _, ok := sealedWith(meta)
+	return len(meta) == 0 || ok && kid == c.current
+}
+
+// sealedWith splits sealed metadata into its key ID and payload
+func sealedWith(meta map[string]string) (kid, data string, ok bool) {
+	v, found := meta[sealedKey]
+	if !found || len(meta) != 1 {
+		return "", "", false
+	}
+	rest, found := strings.CutPrefix(v, "v1:")
+	if !found {
+		return "", "", false
+	}
+	return strings.Cut(rest, ":")
+}
+
+// Encryptable is implemented by drivers that can encrypt token metadata at
+// rest.
+type Encryptable interface {
+	// EncryptFields seals metadata written from then on with c and opens
+	// it on read
+	EncryptFields(c *FieldCipher) error
+}
+
+// Reencrypter is implemented by Encryptable drivers that can rewrite rows
+// sealed with a previous key, or not at all, with the current one.
+type Reencrypter interface {
+	ReencryptFields() (int64, error)
+}
+
+// EncryptFields walks a driver chain and hands c to the first Encryptable
+// driver.
+func EncryptFields(d Driver, c *FieldCipher) error {
+	if e, ok := findIn[Encryptable](d); ok {
+		return e.EncryptFields(c)
+	}
+	return utils.ErrNotSupported
+}
+
+// ReencryptFields walks a driver chain to the first Reencrypter and runs
+// it.
+func ReencryptFields(d Driver) (int64, error) {
+	if r, ok := findIn[Reencrypter](d); ok {
+		return r.ReencryptFields()
+	}
+	return 0, utils.ErrNotSupported
+}
+
+// findIn returns the first driver in a chain implementing T
+func findIn[T any](d Driver) (T, bool) {
+	for d != nil {
+		if x, ok := d.(T); ok {
+			return x, true
+		}
+		w, ok := d.(Wrapper)
+		if !ok {
+			break
+		}
+		d = w.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// EncryptFields keeps metadata as given; nothing is at rest.
+func (m *memoryDriver) EncryptFields(*FieldCipher) error {
+	return nil
+}
+
+// EncryptFields seals the metadata column from now on.
+func (g *gormDriver) EncryptFields(c *FieldCipher) error {
+	g.fields = c
+	return nil
+}
+
+// sealed returns the rows to write for ts: ts itself, or copies with their
+// metadata sealed under field encryption
+func (g *gormDriver) sealed(ts ...*entity.PersonalAccessToken) ([]*entity.PersonalAccessToken, error) {
+	if g.fields == nil {
+		return ts, nil
+	}
+	rows := make([]*entity.PersonalAccessToken, len(ts))
+	for i, t := range ts {
+		meta, err := g.fields.Seal(t.Metadata, t.Token)
+		if err != nil {
+			return nil, err
+		}
+		cp := *t
+		cp.Metadata = meta
+		rows[i] = &cp
+	}
+	return rows, nil
+}
+
+// written copies the fields an insert sets from the sealed copies back to
+// ts
+func written(ts, rows []*entity.PersonalAccessToken) {
+	for i, r := range rows {
+		if r != ts[i] {
+			ts[i].ID, ts[i].CreatedAt = r.ID, r.CreatedAt
+		}
+	}
+}
+
+// loaded opens the metadata of tokens just read and loads their extra
+// columns
+func (g *gormDriver) loaded(ts ...*entity.PersonalAccessToken) error {
+	if g.fields != nil {
+		for _, t := range ts {
+			meta, err := g.fields.Open(t.Metadata, t.Token)
+			if err != nil {
+				return fmt.Errorf("token %d: %w", t.ID, err)
+			}
+			t.Metadata = meta
+		}
+	}
+	return g.loadExtra(ts...)
+}
+
+// ReencryptFields rewrites the metadata of rows that are unencrypted or
+// sealed with a previous key, and returns how many it changed. Only the
+// metadata column is written, and only while the row's revision is
+// unchanged, so concurrent updates win. The token's content is the same
+// afterwards, so the rewrite doesn't show in the change feed.
+func (g *gormDriver) ReencryptFields() (int64, error) {
+	if g.fields == nil {
+		return 0, fmt.Errorf("field encryption is not enabled")
+	}
+	var n int64
+	err := g.rec.findInBatches(g.db, 500, func(batch []*entity.PersonalAccessToken) error {
+		for _, t := range batch {
+			if g.fields.Current(t.Metadata) {
+				continue
+			}
+			meta, err := g.fields.Open(t.Metadata, t.Token)
+			if err != nil {
+				return fmt.Errorf("token %d: %w", t.ID, err)
+			}
+			if meta, err = g.fields.Seal(meta, t.Token); err != nil {
+				return err
+			}
+			value, err := json.Marshal(meta)
+			if err != nil {
+				return err
+			}
+			q := g.db.Model(g.rec.model()).Where(g.c("id")+" = ?", t.ID)
+			if g.rec.tracked() {
+				q = q.Where(g.c("revision")+" = ?", t.Revision)
+			}
+			res := q.UpdateColumn(g.c("metadata"), gorm.Expr("?", string(value)))
+			if res.Error != nil {
+				return res.Error
+			}
+			n += res.RowsAffected
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,201 @@
+// Package storage internal/storage/extend.go
+package storage
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ExtraColumn is an application defined column on the token table.
+type ExtraColumn struct {
+	Name string // Column name, e.g. "cost_center"
+	Type string // SQL type used when adding the column, e.g. "VARCHAR(64)"
+}
+
+// EntityExtender stores application fields as extra token columns. The
+// fields travel in PersonalAccessToken.Extra; the extender converts them to
+// column values on write and back on read.
+type EntityExtender interface {
+	Columns() []ExtraColumn
+	// Marshal returns the value of each column for t. An error fails the
+	// write, so it doubles as validation.
+	Marshal(t *entity.PersonalAccessToken) (map[string]any, error)
+	// Unmarshal sets t.Extra from the stored values, keyed by column.
+	Unmarshal(t *entity.PersonalAccessToken, values map[string]any) error
+}
+
+// Extendable is implemented by drivers that can store extra columns.
+type Extendable interface {
+	// Extend adds any missing columns and stores e's fields from then on
+	Extend(e EntityExtender) error
+}
+
+// Extend walks a driver chain and hands e to the first Extendable driver.
+func Extend(d Driver, e EntityExtender) error {
+	if x, ok := findIn[Extendable](d); ok {
+		return x.Extend(e)
+	}
+	return utils.ErrNotSupported
+}
+
+// ExtraColumns returns an EntityExtender that stores Extra[name] as is for
+// each column.
+func ExtraColumns(cols ...ExtraColumn) EntityExtender {
+	return plainExtender(cols)
+}
+
+type plainExtender []ExtraColumn
+
+func (p plainExtender) Columns() []ExtraColumn { return p }
+
+func (p plainExtender) Marshal(t *entity.PersonalAccessToken) (map[string]any, error) {
+	values := make(map[string]any, len(p))
+	for _, c := range p {
+		values[c.Name] = t.Extra[c.Name]
+	}
+	return values, nil
+}
+
+func (p plainExtender) Unmarshal(t *entity.PersonalAccessToken, values map[string]any) error {
+	t.Extra = make(map[string]any, len(values))
+	for k, v := range values {
+		// Some dialects return text columns as bytes
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		t.Extra[k] = v
+	}
+	return nil
+}
+
+// Extend keeps Extra as set; tokens are held by pointer.
+func (m *memoryDriver) Extend(EntityExtender) error {
+	return nil
+}
+
+// Extend adds the extender's columns to the token table when missing.
+func (g *gormDriver) Extend(e EntityExtender) error {
+	model := g.rec.model()
+	stmt := &gorm.Statement{DB: g.db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+
+	for _, c := range e.Columns() {
+		switch {
+		case c.Name == "" || c.Type == "":
+			return fmt.Errorf("extra column needs a name and type")
+		case stmt.Schema.LookUpField(c.Name) != nil:
+			return fmt.Errorf("extra column %q clashes with a token field", c.Name)
+		case g.db.Migrator().HasColumn(model, c.Name):
+			continue
+		}
+		err := g.db.Exec("ALTER TABLE ? ADD COLUMN ? "+c.Type,
+			clause.Table{Name: g.rec.table()}, clause.Column{Name: c.Name}).Error
+		if err != nil {
+			return fmt.Errorf("add extra column %q: %w", c.Name, err)
+		}
+	}
+	g.ext = e
+	return nil
+}
+
+// withExtra runs fn, in a transaction when extra columns are written
+// alongside the row
+func (g *gormDriver) withExtra(fn func(tx *gorm.DB) error) error {
+	if g.ext == nil {
+		return fn(g.db)
+	}
+	return g.db.Transaction(fn)
+}
+
+// saveExtra writes the extra columns of stored tokens
+func (g *gormDriver) saveExtra(tx *gorm.DB, ts ...*entity.PersonalAccessToken) error {
+	if g.ext == nil {
+		return nil
+	}
+	declared := make(map[string]bool)
+	for _, c := range g.ext.Columns() {
+		declared[c.Name] = true
+	}
+
+	for _, t := range ts {
+		values, err := g.ext.Marshal(t)
+		if err != nil {
+			return err
+		}
+		for k := range values {
+			if !declared[k] {
+				return fmt.Errorf("extender wrote undeclared column %q", k)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		err = tx.Table(g.rec.table()).Where(g.c("id")+" = ?", t.ID).Updates(values).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadExtra reads the extra columns of ts in one query per batch
+func (g *gormDriver) loadExtra(ts ...*entity.PersonalAccessToken) error {
+	if g.ext == nil || len(ts) == 0 {
+		return nil
+	}
+	cols := []string{g.c("id")}
+	for _, c := range g.ext.Columns() {
+		cols = append(cols, c.Name)
+	}
+
+	for start := 0; start < len(ts); start += batchSize {
+		batch := ts[start:min(start+batchSize, len(ts))]
+		ids := make([]int64, len(batch))
+		for i, t := range batch {
+			ids[i] = t.ID
+		}
+
+		var rows []map[string]any
+		err := g.db.Table(g.rec.table()).Select(cols).Where(g.c("id")+" IN ?", ids).Find(&rows).Error
+		if err != nil {
+			return err
+		}
+		byID := make(map[int64]map[string]any, len(rows))
+		for _, r := range rows {
+			if id, ok := toInt64(r[cols[0]]); ok {
+				delete(r, cols[0])
+				byID[id] = r
+			}
+		}
+		for _, t := range batch {
+			if err := g.ext.Unmarshal(t, byID[t.ID]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func toInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	case []byte:
+		id, err := strconv.ParseInt(string(n), 10, 64)
+		return id, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,56 @@
+// Package storage internal/storage/filter.go
+package storage
+
+import (
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// TokenStatus narrows a token listing to tokens in a given lifecycle state.
+type TokenStatus string
+
+const (
+	StatusAll     TokenStatus = ""
+	StatusActive  TokenStatus = "active"
+	StatusExpired TokenStatus = "expired"
+	StatusRevoked TokenStatus = "revoked" // Only kept rows, i.e. under soft revocation
+)
+
+// ListOptions controls filtering and pagination for FindByUser.
+type ListOptions struct {
+	Status TokenStatus // Filter by status (empty = all)
+	Limit  int         // Page size (0 = no limit)
+	Offset int         // Number of records to skip
+}
+
+// isAccess reports whether t is an access token, as opposed to a refresh,
+// guest or license record
+func isAccess(t *entity.PersonalAccessToken) bool {
+	return t.Kind == "" || t.Kind == entity.KindAccess
+}
+
+// kindMatches reports whether t is of kind, with "" meaning access
+func kindMatches(t *entity.PersonalAccessToken, kind string) bool {
+	if kind == "" || kind == entity.KindAccess {
+		return isAccess(t)
+	}
+	return t.Kind == kind
+}
+
+// Matches reports whether the token is in the requested status at the given time.
+func (s TokenStatus) Matches(t *entity.PersonalAccessToken, now time.Time) bool {
+	revoked := t.RevokedAt != nil
+	expired := t.ExpiresAt != nil && now.After(*t.ExpiresAt)
+
+	switch s {
+	case StatusActive:
+		return !revoked && !expired
+	case StatusExpired:
+		return !revoked && expired
+	case StatusRevoked:
+		return revoked
+	default:
+		return true
+	}
+}
@@ -0,0 +1,16 @@
+// Package storage internal/storage/identity.go
+package storage
+
+import "github.com/mohar9h/goauth/internal/entity"
+
+// IdentityStore is implemented by drivers that persist identity links.
+type IdentityStore interface {
+	LinkIdentity(link *entity.IdentityLink) error
+	UnlinkIdentity(provider, subject string) error
+	FindIdentity(provider, subject string) (*entity.IdentityLink, error)
+	ListIdentities(userID int64) ([]*entity.IdentityLink, error)
+}
+
+func identityKey(provider, subject string) string {
+	return provider + "\x00" + subject
+}
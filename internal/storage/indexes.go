@@ -0,0 +1,46 @@
+// Package storage internal/storage/indexes.go
+package storage
+
+import (
+	"fmt"
+
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// IndexVerifier is implemented by drivers backed by a schema that can be
+// inspected for the indexes goauth relies on.
+type IndexVerifier interface {
+	VerifyIndexes() error
+}
+
+// VerifyIndexes walks a driver chain and runs the first IndexVerifier it
+// finds. Drivers without a schema (e.g. memory) always pass.
+func VerifyIndexes(d Driver) error {
+	for d != nil {
+		if v, ok := d.(IndexVerifier); ok {
+			return v.VerifyIndexes()
+		}
+		w, ok := d.(Wrapper)
+		if !ok {
+			break
+		}
+		d = w.Unwrap()
+	}
+	return nil
+}
+
+// VerifyIndexes checks for a unique index on the token hash. Without it
+// every validation is a full table scan.
+func (g *gormDriver) VerifyIndexes() error {
+	indexes, err := g.db.Migrator().GetIndexes(g.rec.model())
+	if err != nil {
+		return fmt.Errorf("inspect indexes: %w", err)
+	}
+	for _, idx := range indexes {
+		unique, _ := idx.Unique()
+		if cols := idx.Columns(); unique && len(cols) == 1 && cols[0] == g.c("token") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unique index on %s(%s)", utils.ErrMissingIndex, g.rec.table(), g.c("token"))
+}
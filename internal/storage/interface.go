@@ -2,13 +2,21 @@
 package storage
 
 import (
+	"time"
+
 	"github.com/mohar9h/goauth/internal/entity"
 )
 
 type Driver interface {
 	FindByID(id int64) (*entity.PersonalAccessToken, error)
 	FindByHash(hash string) (*entity.PersonalAccessToken, error)
+	FindByUser(userID int64, opts ListOptions) ([]*entity.PersonalAccessToken, int64, error)
 	RevokeToken(hash string) error
+	MarkRevoked(hash string, at time.Time) error
+	RevokeFamily(familyID string, at time.Time) (int64, error)
+	DeleteExpired(before time.Time) (int64, error)
 	TouchLastUsed(id int64) error
+	UpdateExpiry(id int64, expiresAt time.Time) error
 	StoreToken(t *entity.PersonalAccessToken) error
+	UpdateToken(t *entity.PersonalAccessToken) error
 }
@@ -0,0 +1,71 @@
+// Package storage internal/storage/maintain.go
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Maintainer is implemented by drivers with housekeeping best run off-peak,
+// such as reclaiming space after bulk deletes. Custom drivers can implement
+// it for e.g. partition drops or SCAN-based cleanup.
+type Maintainer interface {
+	Maintain(ctx context.Context) error
+}
+
+// Maintain walks a driver chain and runs every Maintainer it finds, so a
+// wrapper such as ArchivingDriver is maintained along with its backend.
+func Maintain(ctx context.Context, d Driver) error {
+	var errs []error
+	for d != nil {
+		if m, ok := d.(Maintainer); ok {
+			errs = append(errs, m.Maintain(ctx))
+		}
+		w, ok := d.(Wrapper)
+		if !ok {
+			break
+		}
+		d = w.Unwrap()
+	}
+	return errors.Join(errs...)
+}
+
+// Maintain reclaims space and refreshes planner statistics for the token
+// table: VACUUM ANALYZE on Postgres, OPTIMIZE TABLE on MySQL and VACUUM
+// plus PRAGMA optimize on SQLite. Other dialects are left alone.
+func (g *gormDriver) Maintain(ctx context.Context) error {
+	table := g.rec.table()
+	db := g.db.WithContext(ctx)
+
+	var stmts []string
+	switch g.db.Dialector.Name() {
+	case "postgres":
+		stmts = []string{"VACUUM (ANALYZE) " + table}
+	case "mysql":
+		stmts = []string{"OPTIMIZE TABLE " + table}
+	case "sqlite":
+		stmts = []string{"VACUUM", "PRAGMA optimize"}
+	}
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
+// Maintain runs through the write queue; VACUUM needs the database to
+// itself
+func (s *sqliteDriver) Maintain(ctx context.Context) error {
+	return s.write(func() error { return s.gormDriver.Maintain(ctx) })
+}
+
+// Maintain compacts the archive when it is a Maintainer; the hot driver is
+// maintained separately by Maintain walking the chain
+func (a *ArchivingDriver) Maintain(ctx context.Context) error {
+	if m, ok := a.archive.(Maintainer); ok {
+		return m.Maintain(ctx)
+	}
+	return nil
+}
@@ -3,103 +3,810 @@ package storage
 
 import (
 	"github.com/mohar9h/goauth/internal/utils"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/mohar9h/goauth/internal/entity"
 )
 
+// memoryShards is the number of token shards. Lookups and updates lock
+// only the shard they touch, so concurrent validations rarely contend.
+const memoryShards = 64
+
+// tokenShard holds the tokens whose hash maps to it, plus the ID index of
+// the IDs that map to it.
+type tokenShard struct {
+	mu     sync.RWMutex
+	byHash map[string]*entity.PersonalAccessToken // key is hashed token string
+	hashes map[int64]string                       // key is token ID, value its hash
+}
+
+// memoryDriver keeps tokens in shards keyed by hash. A token's record
+// lives in its hash's shard and is updated there in place; the ID index
+// in the ID's shard points to the hash. Adding and removing tokens is
+// serialised by writeMu, which keeps the two in step.
 type memoryDriver struct {
-	tokensByHash map[string]*entity.PersonalAccessToken // key is hashed token string
-	tokensByID   map[int64]*entity.PersonalAccessToken  // key is token ID for O(1) lookups
-	mu           sync.RWMutex
-	nextID       int64 // Auto-incrementing ID
+	shards     [memoryShards]tokenShard
+	writeMu    sync.Mutex                      // Serialises adding and removing tokens
+	nextID     int64                           // Auto-incrementing ID; guarded by writeMu
+	identities map[string]*entity.IdentityLink // key is provider + subject
+	units      map[int64]*entity.OrgUnit
+	members    map[memberKey]*entity.OrgMember
+	grants     map[grantKey]*entity.AbilityGrant
+	roles      map[string]*entity.Role
+	mu         sync.RWMutex // Guards the maps above, not tokens
+	nextLinkID int64
+	nextOrgID  int64 // Shared by units, members, grants and roles
+	clock
 }
 
 var _ Driver = (*memoryDriver)(nil)
 
 func NewMemoryDriver() Driver {
-	return &memoryDriver{
-		tokensByHash: make(map[string]*entity.PersonalAccessToken),
-		tokensByID:   make(map[int64]*entity.PersonalAccessToken),
-		nextID:       1,
+	m := &memoryDriver{
+		identities: make(map[string]*entity.IdentityLink),
+		units:      make(map[int64]*entity.OrgUnit),
+		members:    make(map[memberKey]*entity.OrgMember),
+		grants:     make(map[grantKey]*entity.AbilityGrant),
+		roles:      make(map[string]*entity.Role),
+		nextID:     1,
+		nextLinkID: 1,
+		nextOrgID:  1,
+	}
+	for i := range m.shards {
+		m.shards[i].byHash = make(map[string]*entity.PersonalAccessToken)
+		m.shards[i].hashes = make(map[int64]string)
+	}
+	return m
+}
+
+// Capabilities reports the features supported by the memory driver
+func (m *memoryDriver) Capabilities() Capabilities {
+	return Capabilities{
+		List:           true,
+		BulkRevoke:     true,
+		TTLEnforcement: true,
+		Upsert:         true,
+		SoftRevoke:     true,
+		BatchInsert:    true,
+		Count:          true,
+		Changes:        true,
+	}
+}
+
+// Stats reports the number of tokens held in memory
+func (m *memoryDriver) Stats() Stats {
+	var n int64
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.RLock()
+		n += int64(len(sh.byHash))
+		sh.mu.RUnlock()
+	}
+	return Stats{
+		Driver: "memory",
+		Tokens: n,
+	}
+}
+
+// hashShard picks the shard by the FNV-1a hash of the token hash
+func (m *memoryDriver) hashShard(hash string) *tokenShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(hash); i++ {
+		h = (h ^ uint32(hash[i])) * 16777619
+	}
+	return &m.shards[h%memoryShards]
+}
+
+func (m *memoryDriver) idShard(id int64) *tokenShard {
+	return &m.shards[uint64(id)%memoryShards]
+}
+
+// hashOf returns the hash of the token with the given ID
+func (m *memoryDriver) hashOf(id int64) (string, bool) {
+	sh := m.idShard(id)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	hash, ok := sh.hashes[id]
+	return hash, ok
+}
+
+// exists reports whether a token has the hash
+func (m *memoryDriver) exists(hash string) (*entity.PersonalAccessToken, bool) {
+	sh := m.hashShard(hash)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	tok, ok := sh.byHash[hash]
+	if !ok {
+		return nil, false
+	}
+	cp := *tok
+	return &cp, true
+}
+
+// find returns a copy of the token with the hash, or with the ID when hash
+// is empty
+func (m *memoryDriver) find(hash string, id int64) (*entity.PersonalAccessToken, error) {
+	if hash == "" {
+		var ok bool
+		if hash, ok = m.hashOf(id); !ok {
+			return nil, utils.ErrTokenNotFound
+		}
+	}
+	sh := m.hashShard(hash)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	tok, ok := sh.byHash[hash]
+	if !ok || (id != 0 && tok.ID != id) {
+		return nil, utils.ErrTokenNotFound
+	}
+	if tok.ExpiresAt != nil && m.Now().After(*tok.ExpiresAt) {
+		return nil, utils.ErrTokenExpired
+	}
+	cp := *tok
+	return &cp, nil
+}
+
+// modify calls fn with the stored record of the token id, under its
+// shard's write lock
+func (m *memoryDriver) modify(id int64, fn func(t *entity.PersonalAccessToken) error) error {
+	for {
+		hash, ok := m.hashOf(id)
+		if !ok {
+			return utils.ErrTokenNotFound
+		}
+		sh := m.hashShard(hash)
+		sh.mu.Lock()
+		if tok, ok := sh.byHash[hash]; ok && tok.ID == id {
+			err := fn(tok)
+			sh.mu.Unlock()
+			return err
+		}
+		sh.mu.Unlock()
+		// The token was rehashed or removed meanwhile
+		if now, ok := m.hashOf(id); !ok || now == hash {
+			return utils.ErrTokenNotFound
+		}
 	}
 }
 
+// modifyHash calls fn with the stored record of the token with the hash,
+// under its shard's write lock
+func (m *memoryDriver) modifyHash(hash string, fn func(t *entity.PersonalAccessToken) error) error {
+	sh := m.hashShard(hash)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	tok, ok := sh.byHash[hash]
+	if !ok {
+		return utils.ErrTokenNotFound
+	}
+	return fn(tok)
+}
+
+// put stores t, replacing the token with its ID. Callers hold writeMu.
+func (m *memoryDriver) put(t *entity.PersonalAccessToken) {
+	if old, ok := m.hashOf(t.ID); ok && old != t.Token {
+		m.dropHash(old)
+	}
+	hs := m.hashShard(t.Token)
+	hs.mu.Lock()
+	hs.byHash[t.Token] = t
+	hs.mu.Unlock()
+
+	is := m.idShard(t.ID)
+	is.mu.Lock()
+	is.hashes[t.ID] = t.Token
+	is.mu.Unlock()
+}
+
+// drop removes the token. Callers hold writeMu.
+func (m *memoryDriver) drop(id int64, hash string) {
+	is := m.idShard(id)
+	is.mu.Lock()
+	delete(is.hashes, id)
+	is.mu.Unlock()
+	m.dropHash(hash)
+}
+
+func (m *memoryDriver) dropHash(hash string) {
+	hs := m.hashShard(hash)
+	hs.mu.Lock()
+	delete(hs.byHash, hash)
+	hs.mu.Unlock()
+}
+
+// each calls fn with every stored record until it returns false. fn runs
+// under the shard's read lock and must copy records it keeps.
+func (m *memoryDriver) each(fn func(t *entity.PersonalAccessToken) bool) {
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.RLock()
+		for _, t := range sh.byHash {
+			if !fn(t) {
+				sh.mu.RUnlock()
+				return
+			}
+		}
+		sh.mu.RUnlock()
+	}
+}
+
+// modifyEach calls fn with every stored record under the shard's write
+// lock and returns how many records fn changed
+func (m *memoryDriver) modifyEach(fn func(t *entity.PersonalAccessToken) bool) int64 {
+	var n int64
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.Lock()
+		for _, t := range sh.byHash {
+			if fn(t) {
+				n++
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// dropEach removes every token fn selects and returns how many it
+// removed. Callers hold writeMu.
+func (m *memoryDriver) dropEach(fn func(t *entity.PersonalAccessToken) bool) int64 {
+	var gone []*entity.PersonalAccessToken
+	m.each(func(t *entity.PersonalAccessToken) bool {
+		if fn(t) {
+			gone = append(gone, t)
+		}
+		return true
+	})
+	for _, t := range gone {
+		m.drop(t.ID, t.Token)
+	}
+	return int64(len(gone))
+}
+
 // StoreToken stores the token using its hashed value as key
 func (m *memoryDriver) StoreToken(t *entity.PersonalAccessToken) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
 
+	if _, ok := m.exists(t.Token); ok {
+		return utils.ErrDuplicateToken
+	}
+	return m.store(t)
+}
+
+// StoreTokens stores all tokens, or none if any hash is already taken
+func (m *memoryDriver) StoreTokens(ts []*entity.PersonalAccessToken) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	seen := make(map[string]struct{}, len(ts))
+	for _, t := range ts {
+		if _, ok := m.exists(t.Token); ok {
+			return utils.ErrDuplicateToken
+		}
+		if _, ok := seen[t.Token]; ok {
+			return utils.ErrDuplicateToken
+		}
+		seen[t.Token] = struct{}{}
+	}
+	for _, t := range ts {
+		if err := m.store(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpsertToken replaces the user's token with the same UniqueName, keeping
+// its ID, or stores t as a new token
+func (m *memoryDriver) UpsertToken(t *entity.PersonalAccessToken) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	if dup, ok := m.exists(t.Token); ok && !sameSlot(dup, t) {
+		return utils.ErrDuplicateToken
+	}
+	m.each(func(old *entity.PersonalAccessToken) bool {
+		if sameSlot(old, t) {
+			t.ID = old.ID
+			return false
+		}
+		return true
+	})
+	return m.store(t)
+}
+
+func sameSlot(a, b *entity.PersonalAccessToken) bool {
+	return a.UserId == b.UserId && a.UniqueName != nil && b.UniqueName != nil && *a.UniqueName == *b.UniqueName
+}
+
+// store indexes a copy of t, assigning an ID when unset. Callers hold
+// m.writeMu.
+func (m *memoryDriver) store(t *entity.PersonalAccessToken) error {
 	// Assign ID if not set
 	if t.ID == 0 {
 		t.ID = m.nextID
 		m.nextID++
 	}
+	stamp(t, m.Now())
 
-	m.tokensByHash[t.Token] = t
-	m.tokensByID[t.ID] = t
+	cp := *t
+	m.put(&cp)
 	return nil
 }
 
-// FindByID looks up token by its internal ID (numeric) - O(1) lookup
+// UpdateToken replaces the stored record with the same ID
+func (m *memoryDriver) UpdateToken(t *entity.PersonalAccessToken) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	if _, ok := m.hashOf(t.ID); !ok {
+		return utils.ErrTokenNotFound
+	}
+
+	stamp(t, m.Now())
+	cp := *t
+	m.put(&cp)
+	return nil
+}
+
+// FindByID looks up token by its internal ID (numeric) - O(1) lookup.
+// Lookups return copies so callers never race with in-place updates.
 func (m *memoryDriver) FindByID(id int64) (*entity.PersonalAccessToken, error) {
+	return m.find("", id)
+}
+
+// FindByHash looks up token by its hashed token string - O(1) lookup
+func (m *memoryDriver) FindByHash(hash string) (*entity.PersonalAccessToken, error) {
+	return m.find(hash, 0)
+}
+
+// CountByUser counts the user's live access tokens
+func (m *memoryDriver) CountByUser(userID int64) (int64, error) {
+	now := m.Now()
+	var n int64
+	m.each(func(t *entity.PersonalAccessToken) bool {
+		if t.UserId == userID && isAccess(t) && StatusActive.Matches(t, now) {
+			n++
+		}
+		return true
+	})
+	return n, nil
+}
+
+// FindByUser returns a page of the user's tokens ordered by ID, plus the total match count
+func (m *memoryDriver) FindByUser(userID int64, opts ListOptions) ([]*entity.PersonalAccessToken, int64, error) {
+	now := m.Now()
+	var matched []*entity.PersonalAccessToken
+	m.each(func(tok *entity.PersonalAccessToken) bool {
+		if tok.UserId == userID && opts.Status.Matches(tok, now) {
+			cp := *tok
+			matched = append(matched, &cp)
+		}
+		return true
+	})
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID < matched[j].ID })
+
+	total := int64(len(matched))
+	if opts.Offset >= len(matched) {
+		return []*entity.PersonalAccessToken{}, total, nil
+	}
+	matched = matched[opts.Offset:]
+	if opts.Limit > 0 && opts.Limit < len(matched) {
+		matched = matched[:opts.Limit]
+	}
+	return matched, total, nil
+}
+
+// ScanTokens calls fn with a copy of every stored token
+func (m *memoryDriver) ScanTokens(fn func(t *entity.PersonalAccessToken) error) error {
+	var all []entity.PersonalAccessToken
+	m.each(func(tok *entity.PersonalAccessToken) bool {
+		all = append(all, *tok)
+		return true
+	})
+
+	for i := range all {
+		if err := fn(&all[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChangesSince returns copies of the tokens changed after the cursor
+func (m *memoryDriver) ChangesSince(after ChangeCursor, limit int) ([]*entity.PersonalAccessToken, error) {
+	var changed []*entity.PersonalAccessToken
+	m.each(func(tok *entity.PersonalAccessToken) bool {
+		if after.Before(tok) {
+			cp := *tok
+			changed = append(changed, &cp)
+		}
+		return true
+	})
+
+	sort.Slice(changed, func(i, j int) bool {
+		return CursorOf(changed[i]).Before(changed[j])
+	})
+	if limit > 0 && limit < len(changed) {
+		changed = changed[:limit]
+	}
+	return changed, nil
+}
+
+// RevokeToken removes a token by its hashed value
+func (m *memoryDriver) RevokeToken(hash string) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	tok, ok := m.exists(hash)
+	if !ok {
+		return utils.ErrTokenNotFound
+	}
+
+	m.drop(tok.ID, hash)
+	return nil
+}
+
+// MarkRevoked sets RevokedAt on a token that isn't already revoked
+func (m *memoryDriver) MarkRevoked(hash string, at time.Time) error {
+	return m.modifyHash(hash, func(tok *entity.PersonalAccessToken) error {
+		if tok.RevokedAt != nil {
+			return utils.ErrTokenRevoked
+		}
+		tok.RevokedAt = &at
+		stamp(tok, m.Now())
+		return nil
+	})
+}
+
+// RevokeFamily marks every unrevoked token in a refresh family as revoked
+func (m *memoryDriver) RevokeFamily(familyID string, at time.Time) (int64, error) {
+	if familyID == "" {
+		return 0, nil
+	}
+	return m.modifyEach(func(tok *entity.PersonalAccessToken) bool {
+		if tok.FamilyID != familyID || tok.RevokedAt != nil {
+			return false
+		}
+		revokedAt := at
+		tok.RevokedAt = &revokedAt
+		stamp(tok, m.Now())
+		return true
+	}), nil
+}
+
+// DeleteExpired removes every token whose expiry is at or before the cutoff
+func (m *memoryDriver) DeleteExpired(before time.Time) (int64, error) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	return m.dropEach(func(tok *entity.PersonalAccessToken) bool {
+		return tok.ExpiresAt != nil && !tok.ExpiresAt.After(before)
+	}), nil
+}
+
+// MarkRevokedByUser sets RevokedAt on every unrevoked token of the user
+func (m *memoryDriver) MarkRevokedByUser(userID int64, at time.Time) (int64, error) {
+	return m.modifyEach(func(tok *entity.PersonalAccessToken) bool {
+		if tok.UserId != userID || tok.RevokedAt != nil {
+			return false
+		}
+		revokedAt := at
+		tok.RevokedAt = &revokedAt
+		stamp(tok, m.Now())
+		return true
+	}), nil
+}
+
+// DeleteRevoked removes every token revoked at or before the cutoff
+func (m *memoryDriver) DeleteRevoked(before time.Time) (int64, error) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	return m.dropEach(func(tok *entity.PersonalAccessToken) bool {
+		return tok.RevokedAt != nil && !tok.RevokedAt.After(before)
+	}), nil
+}
+
+// RevokeByUser removes every token belonging to the user
+func (m *memoryDriver) RevokeByUser(userID int64) (int64, error) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	return m.dropEach(func(tok *entity.PersonalAccessToken) bool {
+		return tok.UserId == userID
+	}), nil
+}
+
+// TouchLastUsed updates the last used time for analytics or session freshness
+func (m *memoryDriver) TouchLastUsed(id int64) error {
+	now := m.Now()
+	return m.modify(id, func(tok *entity.PersonalAccessToken) error {
+		tok.LastUsedAt = &now
+		return nil
+	})
+}
+
+// UpdateExpiry moves a token's expiration time
+func (m *memoryDriver) UpdateExpiry(id int64, expiresAt time.Time) error {
+	return m.modify(id, func(tok *entity.PersonalAccessToken) error {
+		tok.ExpiresAt = &expiresAt
+		stamp(tok, m.Now())
+		return nil
+	})
+}
+
+// LinkIdentity stores a provider/subject link, rejecting duplicates
+func (m *memoryDriver) LinkIdentity(link *entity.IdentityLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := identityKey(link.Provider, link.Subject)
+	if _, ok := m.identities[key]; ok {
+		return utils.ErrIdentityAlreadyLinked
+	}
+
+	if link.ID == 0 {
+		link.ID = m.nextLinkID
+		m.nextLinkID++
+	}
+	m.identities[key] = link
+	return nil
+}
+
+// UnlinkIdentity removes a provider/subject link
+func (m *memoryDriver) UnlinkIdentity(provider, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := identityKey(provider, subject)
+	if _, ok := m.identities[key]; !ok {
+		return utils.ErrIdentityNotFound
+	}
+	delete(m.identities, key)
+	return nil
+}
+
+// FindIdentity looks up a link by provider and subject
+func (m *memoryDriver) FindIdentity(provider, subject string) (*entity.IdentityLink, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	tok, ok := m.tokensByID[id]
+	link, ok := m.identities[identityKey(provider, subject)]
 	if !ok {
-		return nil, utils.ErrTokenNotFound
+		return nil, utils.ErrIdentityNotFound
 	}
+	return link, nil
+}
 
-	if tok.ExpiresAt != nil && time.Now().After(*tok.ExpiresAt) {
-		return nil, utils.ErrTokenExpired
+// ListIdentities returns every link for a user ordered by ID
+func (m *memoryDriver) ListIdentities(userID int64) ([]*entity.IdentityLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var links []*entity.IdentityLink
+	for _, link := range m.identities {
+		if link.UserId == userID {
+			links = append(links, link)
+		}
 	}
-	return tok, nil
+	sort.Slice(links, func(i, j int) bool { return links[i].ID < links[j].ID })
+	return links, nil
 }
 
-// FindByHash looks up token by its hashed token string - O(1) lookup
-func (m *memoryDriver) FindByHash(hash string) (*entity.PersonalAccessToken, error) {
+// CreateOrgUnit stores a new org or team
+func (m *memoryDriver) CreateOrgUnit(u *entity.OrgUnit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u.ParentID != nil {
+		if _, ok := m.units[*u.ParentID]; !ok {
+			return utils.ErrOrgUnitNotFound
+		}
+	}
+	u.ID = m.nextOrgID
+	m.nextOrgID++
+	m.units[u.ID] = u
+	return nil
+}
+
+// FindOrgUnit looks up an org unit by ID
+func (m *memoryDriver) FindOrgUnit(id int64) (*entity.OrgUnit, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	tok, ok := m.tokensByHash[hash]
+	u, ok := m.units[id]
 	if !ok {
-		return nil, utils.ErrTokenNotFound
+		return nil, utils.ErrOrgUnitNotFound
 	}
+	cp := *u
+	return &cp, nil
+}
 
-	if tok.ExpiresAt != nil && time.Now().After(*tok.ExpiresAt) {
-		return nil, utils.ErrTokenExpired
+// AddOrgMember adds a user to a unit
+func (m *memoryDriver) AddOrgMember(mem *entity.OrgMember) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.units[mem.UnitID]; !ok {
+		return utils.ErrOrgUnitNotFound
 	}
-	return tok, nil
+	key := memberKey{mem.UnitID, mem.UserId}
+	if existing, ok := m.members[key]; ok {
+		mem.ID = existing.ID
+		return nil
+	}
+	mem.ID = m.nextOrgID
+	m.nextOrgID++
+	m.members[key] = mem
+	return nil
 }
 
-// RevokeToken removes a token by its hashed value
-func (m *memoryDriver) RevokeToken(hash string) error {
+// RemoveOrgMember removes a user from a unit
+func (m *memoryDriver) RemoveOrgMember(unitID, userID int64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	tok, ok := m.tokensByHash[hash]
-	if !ok {
-		return utils.ErrTokenNotFound
+	delete(m.members, memberKey{unitID, userID})
+	return nil
+}
+
+// UserOrgUnits returns the IDs of the units a user belongs to directly
+func (m *memoryDriver) UserOrgUnits(userID int64) ([]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []int64
+	for key := range m.members {
+		if key.userID == userID {
+			ids = append(ids, key.unitID)
+		}
 	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
 
-	delete(m.tokensByHash, hash)
-	delete(m.tokensByID, tok.ID)
+// CountByOrg counts the live tokens of kind held by members of the org's
+// subtree
+func (m *memoryDriver) CountByOrg(orgID int64, kind string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// A unit is in the subtree when its parent chain reaches orgID
+	inOrg := func(id int64) bool {
+		for seen := 0; seen <= len(m.units); seen++ {
+			if id == orgID {
+				return true
+			}
+			u, ok := m.units[id]
+			if !ok || u.ParentID == nil {
+				return false
+			}
+			id = *u.ParentID
+		}
+		return false
+	}
+	users := make(map[int64]bool)
+	for key := range m.members {
+		if inOrg(key.unitID) {
+			users[key.userID] = true
+		}
+	}
+
+	now := m.Now()
+	var n int64
+	m.each(func(t *entity.PersonalAccessToken) bool {
+		if users[t.UserId] && kindMatches(t, kind) && StatusActive.Matches(t, now) {
+			n++
+		}
+		return true
+	})
+	return n, nil
+}
+
+// GrantAbility stores a grant
+func (m *memoryDriver) GrantAbility(g *entity.AbilityGrant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := grantKey{g.SubjectType, g.SubjectID, g.Ability}
+	if existing, ok := m.grants[key]; ok {
+		g.ID = existing.ID
+		return nil
+	}
+	g.ID = m.nextOrgID
+	m.nextOrgID++
+	m.grants[key] = g
 	return nil
 }
 
-// TouchLastUsed updates the last used time for analytics or session freshness
-func (m *memoryDriver) TouchLastUsed(id int64) error {
+// RevokeAbility removes a grant
+func (m *memoryDriver) RevokeAbility(subjectType string, subjectID int64, ability string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	tok, ok := m.tokensByID[id]
+	delete(m.grants, grantKey{subjectType, subjectID, ability})
+	return nil
+}
+
+// ListGrants returns the grants of the given subjects ordered by ID
+func (m *memoryDriver) ListGrants(subjectType string, subjectIDs []int64) ([]*entity.AbilityGrant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	want := make(map[int64]bool, len(subjectIDs))
+	for _, id := range subjectIDs {
+		want[id] = true
+	}
+
+	var out []*entity.AbilityGrant
+	for key, g := range m.grants {
+		if key.subjectType == subjectType && want[key.subjectID] {
+			out = append(out, g)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// SaveRole creates a role or replaces the one with the same name
+func (m *memoryDriver) SaveRole(r *entity.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Now()
+	if existing, ok := m.roles[r.Name]; ok {
+		r.ID, r.CreatedAt = existing.ID, existing.CreatedAt
+	} else {
+		r.ID, r.CreatedAt = m.nextOrgID, now
+		m.nextOrgID++
+	}
+	r.UpdatedAt = now
+	cp := *r
+	m.roles[r.Name] = &cp
+	return nil
+}
+
+// FindRole looks up a role by name
+func (m *memoryDriver) FindRole(name string) (*entity.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.roles[name]
 	if !ok {
-		return utils.ErrTokenNotFound
+		return nil, utils.ErrRoleNotFound
 	}
+	cp := *r
+	return &cp, nil
+}
+
+// DeleteRole removes a role
+func (m *memoryDriver) DeleteRole(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	now := time.Now()
-	tok.LastUsedAt = &now
+	if _, ok := m.roles[name]; !ok {
+		return utils.ErrRoleNotFound
+	}
+	delete(m.roles, name)
 	return nil
 }
+
+// ListRoles returns all roles ordered by name
+func (m *memoryDriver) ListRoles() ([]*entity.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make([]*entity.Role, 0, len(m.roles))
+	for _, r := range m.roles {
+		cp := *r
+		out = append(out, &cp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
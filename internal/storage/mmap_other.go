@@ -0,0 +1,15 @@
+//go:build !unix
+
+// Package storage internal/storage/mmap_other.go
+package storage
+
+import "os"
+
+// mapFile reads path into memory on platforms without mmap support
+func mapFile(path string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,181 @@
+// Package storage internal/storage/model.go
+package storage
+
+import (
+	"fmt"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"gorm.io/gorm"
+)
+
+// TokenRecord is a GORM model tokens can be stored in instead of
+// PersonalAccessToken, e.g. to follow a schema's naming conventions or to
+// carry relations of its own. It converts to and from the token the rest
+// of goauth works with.
+type TokenRecord interface {
+	// ToToken returns the token the row holds, with its ID
+	ToToken() *entity.PersonalAccessToken
+	// FromToken fills the row from t, including t.ID when set
+	FromToken(t *entity.PersonalAccessToken)
+}
+
+// TokenColumnMapper is implemented by models whose columns are named
+// differently from goauth's. Keys are goauth's column names (see
+// TokenColumns); columns left out keep them.
+type TokenColumnMapper interface {
+	TokenColumns() map[string]string
+}
+
+// RecordPtr constrains PM to a pointer to the model M.
+type RecordPtr[M any] interface {
+	*M
+	TokenRecord
+}
+
+// TokenColumns are the columns the GORM driver queries by name, so every
+// token model must have them, possibly renamed.
+var TokenColumns = []string{
+	"id", "user_id", "token", "name", "unique_name", "abilities", "kind", "family_id",
+	"metadata", "created_at", "expires_at", "last_used_at", "revoked_at",
+}
+
+// ChangeColumns record token changes for ChangesSince. They are optional:
+// a model without them works, but the driver has no change feed. Models
+// that have them must carry UpdatedAt and Revision through ToToken and
+// FromToken.
+var ChangeColumns = []string{"updated_at", "revision"}
+
+// records does the work of the GORM driver that depends on the token
+// model, so the driver itself need not be generic
+type records interface {
+	model() any // A new, empty row for Model and Delete
+	col(name string) string
+	table() string
+	tracked() bool // Whether the model has ChangeColumns
+	first(db *gorm.DB, conds ...any) (*entity.PersonalAccessToken, error)
+	find(db *gorm.DB) ([]*entity.PersonalAccessToken, error)
+	findInBatches(db *gorm.DB, size int, fn func([]*entity.PersonalAccessToken) error) error
+	// create inserts ts, in batches of size when there are several, and
+	// sets their IDs
+	create(db *gorm.DB, size int, ts ...*entity.PersonalAccessToken) (int64, error)
+	save(db *gorm.DB, t *entity.PersonalAccessToken) error
+}
+
+type modelRecords[M any, PM RecordPtr[M]] struct {
+	cols    map[string]string
+	name    string
+	changes bool
+}
+
+// defaultRecords stores tokens in PersonalAccessToken itself, which
+// converts without copying
+var defaultRecords records = &modelRecords[entity.PersonalAccessToken, *entity.PersonalAccessToken]{
+	name:    entity.PersonalAccessToken{}.TableName(),
+	changes: true,
+}
+
+// newModelRecords checks that M has every column in TokenColumns
+func newModelRecords[M any, PM RecordPtr[M]](db *gorm.DB) (*modelRecords[M, PM], error) {
+	r := &modelRecords[M, PM]{}
+	if m, ok := any(PM(new(M))).(TokenColumnMapper); ok {
+		r.cols = m.TokenColumns()
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(PM(new(M))); err != nil {
+		return nil, err
+	}
+	for _, c := range TokenColumns {
+		if stmt.Schema.LookUpField(r.col(c)) == nil {
+			return nil, fmt.Errorf("token model %s has no column %q for %q", stmt.Schema.Name, r.col(c), c)
+		}
+	}
+	r.changes = true
+	for _, c := range ChangeColumns {
+		if stmt.Schema.LookUpField(r.col(c)) == nil {
+			r.changes = false
+		}
+	}
+	r.name = stmt.Schema.Table
+	return r, nil
+}
+
+func (r *modelRecords[M, PM]) model() any { return PM(new(M)) }
+
+func (r *modelRecords[M, PM]) col(name string) string {
+	if c, ok := r.cols[name]; ok {
+		return c
+	}
+	return name
+}
+
+func (r *modelRecords[M, PM]) table() string { return r.name }
+
+func (r *modelRecords[M, PM]) tracked() bool { return r.changes }
+
+func (r *modelRecords[M, PM]) row(t *entity.PersonalAccessToken) PM {
+	if p, ok := any(t).(PM); ok {
+		return p
+	}
+	p := PM(new(M))
+	p.FromToken(t)
+	return p
+}
+
+func (r *modelRecords[M, PM]) tokens(rows []PM) []*entity.PersonalAccessToken {
+	ts := make([]*entity.PersonalAccessToken, len(rows))
+	for i, p := range rows {
+		ts[i] = p.ToToken()
+	}
+	return ts
+}
+
+func (r *modelRecords[M, PM]) first(db *gorm.DB, conds ...any) (*entity.PersonalAccessToken, error) {
+	p := PM(new(M))
+	if err := db.First(p, conds...).Error; err != nil {
+		return nil, err
+	}
+	return p.ToToken(), nil
+}
+
+func (r *modelRecords[M, PM]) find(db *gorm.DB) ([]*entity.PersonalAccessToken, error) {
+	var rows []PM
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return r.tokens(rows), nil
+}
+
+func (r *modelRecords[M, PM]) findInBatches(db *gorm.DB, size int, fn func([]*entity.PersonalAccessToken) error) error {
+	var batch []PM
+	return db.FindInBatches(&batch, size, func(*gorm.DB, int) error {
+		return fn(r.tokens(batch))
+	}).Error
+}
+
+func (r *modelRecords[M, PM]) create(db *gorm.DB, size int, ts ...*entity.PersonalAccessToken) (int64, error) {
+	rows := make([]PM, len(ts))
+	for i, t := range ts {
+		rows[i] = r.row(t)
+	}
+
+	var res *gorm.DB
+	if len(rows) == 1 {
+		res = db.Create(rows[0])
+	} else {
+		res = db.CreateInBatches(rows, size)
+	}
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	for i, p := range rows {
+		if any(p) != any(ts[i]) {
+			ts[i].ID = p.ToToken().ID
+		}
+	}
+	return res.RowsAffected, nil
+}
+
+func (r *modelRecords[M, PM]) save(db *gorm.DB, t *entity.PersonalAccessToken) error {
+	return db.Save(r.row(t)).Error
+}
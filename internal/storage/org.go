@@ -0,0 +1,36 @@
+// Package storage internal/storage/org.go
+package storage
+
+import "github.com/mohar9h/goauth/internal/entity"
+
+// OrgStore is implemented by drivers that persist the organization
+// hierarchy and its ability grants. Adding an existing member or grant is
+// a no-op.
+type OrgStore interface {
+	CreateOrgUnit(u *entity.OrgUnit) error
+	FindOrgUnit(id int64) (*entity.OrgUnit, error)
+	AddOrgMember(m *entity.OrgMember) error
+	RemoveOrgMember(unitID, userID int64) error
+	UserOrgUnits(userID int64) ([]int64, error)
+	GrantAbility(g *entity.AbilityGrant) error
+	RevokeAbility(subjectType string, subjectID int64, ability string) error
+	ListGrants(subjectType string, subjectIDs []int64) ([]*entity.AbilityGrant, error)
+}
+
+// OrgCounter is implemented by drivers that can count the live tokens held
+// by the members of an org and of every unit below it, for org quotas.
+type OrgCounter interface {
+	// CountByOrg counts live tokens of kind, with "" or KindAccess meaning
+	// access tokens. A user in several of the org's units counts once.
+	CountByOrg(orgID int64, kind string) (int64, error)
+}
+
+type grantKey struct {
+	subjectType string
+	subjectID   int64
+	ability     string
+}
+
+type memberKey struct {
+	unitID, userID int64
+}
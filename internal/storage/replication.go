@@ -0,0 +1,574 @@
+// Package storage internal/storage/replication.go
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// Replica is the storage of a peer region.
+type Replica struct {
+	Region  string
+	Storage Driver
+}
+
+// ReplicationOptions tunes a ReplicatedDriver. Zero values select the
+// defaults. SyncPeers and ReadFallback together set the read-your-writes
+// guarantee across regions: with neither, a token created in one region may
+// be unknown to the others until replication catches up.
+type ReplicationOptions struct {
+	SyncPeers     int           // Peers that must acknowledge a write before it returns; 0 replicates asynchronously, -1 waits for all
+	ReadFallback  bool          // Look up tokens missing locally on the peers
+	QueueSize     int           // Pending replication writes. Default 1024
+	RetryInterval time.Duration // Delay before retrying a failed peer write. Default 1s
+}
+
+func (o ReplicationOptions) withDefaults() ReplicationOptions {
+	if o.QueueSize <= 0 {
+		o.QueueSize = 1024
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = time.Second
+	}
+	return o
+}
+
+type replOp int
+
+const (
+	replCreate replOp = iota
+	replUpsert
+	replRevoke
+	replRevokeFamily
+	replRevokeUser
+)
+
+// replEvent is a write to apply to one peer.
+type replEvent struct {
+	peer   int
+	op     replOp
+	tok    entity.PersonalAccessToken // Token created or revoked
+	family string
+	userID int64
+	at     time.Time
+}
+
+// ReplicatedDriver makes a local driver part of an active-active deployment:
+// token creations and revocations are written to the local region first and
+// then replicated to each peer. Storage IDs differ between regions, so only
+// hash-keyed writes are replicated; last-used timestamps, expiry renewals
+// and token updates stay local.
+//
+// Revocation wins conflicts. Revoking marks the token revoked instead of
+// deleting it, and a peer that doesn't have the token yet stores a revoked
+// tombstone, so a creation replicated late cannot resurrect it. Tombstones
+// are removed by DeleteRevoked or, for expiring tokens, DeleteExpired.
+type ReplicatedDriver struct {
+	local   Driver
+	peers   []Replica
+	opts    ReplicationOptions
+	queue   chan replEvent
+	onError func(region string, err error)
+	clock
+
+	mu    sync.Mutex
+	retry []replEvent
+}
+
+var _ Driver = (*ReplicatedDriver)(nil)
+
+// NewReplicatedDriver wraps local with replication to peers. Call Run to
+// process asynchronous replication.
+func NewReplicatedDriver(local Driver, peers []Replica, opts ReplicationOptions) (*ReplicatedDriver, error) {
+	for i, p := range peers {
+		if p.Storage == nil {
+			return nil, fmt.Errorf("replica %d (%s): %w", i, p.Region, utils.ErrStorageDriverNil)
+		}
+	}
+	opts = opts.withDefaults()
+	if opts.SyncPeers > len(peers) {
+		return nil, fmt.Errorf("cannot wait for %d of %d peers", opts.SyncPeers, len(peers))
+	}
+	return &ReplicatedDriver{
+		local:   local,
+		peers:   peers,
+		opts:    opts,
+		queue:   make(chan replEvent, opts.QueueSize),
+		onError: func(string, error) {},
+	}, nil
+}
+
+// OnError sets the callback receiving asynchronous replication failures.
+func (r *ReplicatedDriver) OnError(fn func(region string, err error)) {
+	r.onError = fn
+}
+
+// Run applies queued writes to the peers, retrying failures every
+// RetryInterval, until done is closed.
+func (r *ReplicatedDriver) Run(done <-chan struct{}) error {
+	ticker := time.NewTicker(r.opts.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case ev := <-r.queue:
+			r.deliver(ev)
+		case <-ticker.C:
+			r.mu.Lock()
+			pending := r.retry
+			r.retry = nil
+			r.mu.Unlock()
+			for _, ev := range pending {
+				r.deliver(ev)
+			}
+		}
+	}
+}
+
+// Pending reports the number of writes not yet applied to every peer.
+func (r *ReplicatedDriver) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.queue) + len(r.retry)
+}
+
+func (r *ReplicatedDriver) deliver(ev replEvent) {
+	if err := r.apply(ev); err != nil {
+		r.onError(r.peers[ev.peer].Region, err)
+		r.mu.Lock()
+		r.retry = append(r.retry, ev)
+		r.mu.Unlock()
+	}
+}
+
+// replicate sends ev to every peer: the first SyncPeers that succeed
+// synchronously, the rest through the queue.
+func (r *ReplicatedDriver) replicate(ev replEvent) error {
+	need := r.opts.SyncPeers
+	if need < 0 {
+		need = len(r.peers)
+	}
+
+	acked := 0
+	var errs []error
+	for i, p := range r.peers {
+		ev.peer = i
+		if acked < need {
+			err := r.apply(ev)
+			if err == nil {
+				acked++
+				continue
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", p.Region, err))
+		}
+		r.enqueue(ev)
+	}
+	if acked < need {
+		return fmt.Errorf("%w: %d of %d peers acknowledged: %w",
+			utils.ErrReplicationIncomplete, acked, need, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (r *ReplicatedDriver) enqueue(ev replEvent) {
+	select {
+	case r.queue <- ev:
+	default:
+		// Keep the write for the retry loop rather than dropping it
+		r.onError(r.peers[ev.peer].Region, fmt.Errorf("replication queue full"))
+		r.mu.Lock()
+		r.retry = append(r.retry, ev)
+		r.mu.Unlock()
+	}
+}
+
+func (r *ReplicatedDriver) apply(ev replEvent) error {
+	peer := r.peers[ev.peer].Storage
+	switch ev.op {
+	case replCreate, replUpsert:
+		tok := ev.tok
+		tok.ID = 0 // Peers assign their own IDs
+		var err error
+		if up, ok := peer.(Upserter); ok && ev.op == replUpsert {
+			err = up.UpsertToken(&tok)
+		} else {
+			err = peer.StoreToken(&tok)
+		}
+		// Already replicated, or revoked there first: revocation wins
+		if errors.Is(err, utils.ErrDuplicateToken) {
+			return nil
+		}
+		return err
+	case replRevoke:
+		return RevokeWithTombstone(peer, &ev.tok, ev.at)
+	case replRevokeFamily:
+		_, err := peer.RevokeFamily(ev.family, ev.at)
+		return err
+	case replRevokeUser:
+		soft, ok := peer.(SoftRevoker)
+		if !ok {
+			return utils.ErrNotSupported
+		}
+		_, err := soft.MarkRevokedByUser(ev.userID, ev.at)
+		return err
+	}
+	return fmt.Errorf("unknown replication op %d", ev.op)
+}
+
+// RevokeWithTombstone marks tok revoked in d, storing it as a revoked
+// tombstone when d doesn't have it yet.
+func RevokeWithTombstone(d Driver, tok *entity.PersonalAccessToken, at time.Time) error {
+	err := d.MarkRevoked(tok.Token, at)
+	if err == nil || !errors.Is(err, utils.ErrTokenNotFound) && !errors.Is(err, utils.ErrTokenRevoked) {
+		return err
+	}
+
+	// Missing or already revoked; a tombstone insert tells the two apart
+	tomb := *tok
+	tomb.ID = 0
+	tomb.RevokedAt = &at
+	err = d.StoreToken(&tomb)
+	if errors.Is(err, utils.ErrDuplicateToken) {
+		// The token exists; it was revoked already or created meanwhile
+		if err = d.MarkRevoked(tok.Token, at); errors.Is(err, utils.ErrTokenRevoked) {
+			return nil
+		}
+	}
+	return err
+}
+
+func (r *ReplicatedDriver) Unwrap() Driver {
+	return r.local
+}
+
+// Capabilities reports the local driver's capabilities. Bulk revocation
+// requires soft revocation support, since it is replicated as tombstones.
+func (r *ReplicatedDriver) Capabilities() Capabilities {
+	caps := CapabilitiesOf(r.local)
+	caps.BulkRevoke = caps.SoftRevoke
+	return caps
+}
+
+func (r *ReplicatedDriver) Stats() Stats {
+	return Stats{Driver: "replicated", QueueDepth: r.Pending()}
+}
+
+func (r *ReplicatedDriver) FindByHash(hash string) (*entity.PersonalAccessToken, error) {
+	tok, err := r.local.FindByHash(hash)
+	if !r.opts.ReadFallback || !isNotFound(err) {
+		return tok, err
+	}
+	for _, p := range r.peers {
+		// Unreachable peers are skipped; expired or revoked tokens count
+		ptok, perr := p.Storage.FindByHash(hash)
+		if perr == nil || errors.Is(perr, utils.ErrTokenInvalid) && !isNotFound(perr) {
+			return ptok, perr
+		}
+	}
+	return tok, err
+}
+
+func (r *ReplicatedDriver) FindByID(id int64) (*entity.PersonalAccessToken, error) {
+	return r.local.FindByID(id)
+}
+
+func (r *ReplicatedDriver) CountByUser(userID int64) (int64, error) {
+	counter, ok := r.local.(UserCounter)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	return counter.CountByUser(userID)
+}
+
+func (r *ReplicatedDriver) CountByOrg(orgID int64, kind string) (int64, error) {
+	counter, ok := r.local.(OrgCounter)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	return counter.CountByOrg(orgID, kind)
+}
+
+func (r *ReplicatedDriver) ChangesSince(after ChangeCursor, limit int) ([]*entity.PersonalAccessToken, error) {
+	feed, ok := r.local.(ChangeFeed)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return feed.ChangesSince(after, limit)
+}
+
+func (r *ReplicatedDriver) FindByUser(userID int64, opts ListOptions) ([]*entity.PersonalAccessToken, int64, error) {
+	return r.local.FindByUser(userID, opts)
+}
+
+func (r *ReplicatedDriver) StoreToken(t *entity.PersonalAccessToken) error {
+	if err := r.local.StoreToken(t); err != nil {
+		return err
+	}
+	return r.replicate(replEvent{op: replCreate, tok: *t})
+}
+
+func (r *ReplicatedDriver) StoreTokens(ts []*entity.PersonalAccessToken) error {
+	batch, ok := r.local.(BatchStorer)
+	if !ok {
+		return utils.ErrNotSupported
+	}
+	if err := batch.StoreTokens(ts); err != nil {
+		return err
+	}
+	var errs []error
+	for _, t := range ts {
+		errs = append(errs, r.replicate(replEvent{op: replCreate, tok: *t}))
+	}
+	return errors.Join(errs...)
+}
+
+func (r *ReplicatedDriver) UpsertToken(t *entity.PersonalAccessToken) error {
+	up, ok := r.local.(Upserter)
+	if !ok {
+		return utils.ErrNotSupported
+	}
+	if err := up.UpsertToken(t); err != nil {
+		return err
+	}
+	return r.replicate(replEvent{op: replUpsert, tok: *t})
+}
+
+// RevokeToken marks the token revoked, here and on the peers, instead of
+// deleting it; see ReplicatedDriver.
+func (r *ReplicatedDriver) RevokeToken(hash string) error {
+	return r.MarkRevoked(hash, r.Now())
+}
+
+func (r *ReplicatedDriver) MarkRevoked(hash string, at time.Time) error {
+	tok, err := r.lookup(hash)
+	if err != nil {
+		return err
+	}
+	if err := RevokeWithTombstone(r.local, tok, at); err != nil {
+		return err
+	}
+	return r.replicate(replEvent{op: replRevoke, tok: *tok, at: at})
+}
+
+// lookup finds a token to revoke locally or, if it hasn't been replicated
+// here yet, on a peer. Expired and revoked tokens can still be revoked.
+func (r *ReplicatedDriver) lookup(hash string) (*entity.PersonalAccessToken, error) {
+	tok, err := r.local.FindByHash(hash)
+	if err == nil {
+		return tok, nil
+	}
+	for _, p := range r.peers {
+		if ptok, perr := p.Storage.FindByHash(hash); perr == nil {
+			return ptok, nil
+		}
+	}
+	if errors.Is(err, utils.ErrTokenExpired) || errors.Is(err, utils.ErrTokenRevoked) {
+		// The driver enforces expiry or revocation itself; the record is
+		// still there, so it can be marked without knowing its fields
+		return &entity.PersonalAccessToken{Token: hash}, nil
+	}
+	return nil, err
+}
+
+func (r *ReplicatedDriver) RevokeFamily(familyID string, at time.Time) (int64, error) {
+	n, err := r.local.RevokeFamily(familyID, at)
+	if err != nil {
+		return n, err
+	}
+	return n, r.replicate(replEvent{op: replRevokeFamily, family: familyID, at: at})
+}
+
+// RevokeByUser marks all of the user's tokens revoked, here and on the
+// peers.
+func (r *ReplicatedDriver) RevokeByUser(userID int64) (int64, error) {
+	return r.MarkRevokedByUser(userID, r.Now())
+}
+
+func (r *ReplicatedDriver) MarkRevokedByUser(userID int64, at time.Time) (int64, error) {
+	soft, ok := r.local.(SoftRevoker)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	n, err := soft.MarkRevokedByUser(userID, at)
+	if err != nil {
+		return n, err
+	}
+	return n, r.replicate(replEvent{op: replRevokeUser, userID: userID, at: at})
+}
+
+// DeleteRevoked and DeleteExpired run per region; each region prunes its
+// own tombstones.
+func (r *ReplicatedDriver) DeleteRevoked(before time.Time) (int64, error) {
+	soft, ok := r.local.(SoftRevoker)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+	return soft.DeleteRevoked(before)
+}
+
+func (r *ReplicatedDriver) DeleteExpired(before time.Time) (int64, error) {
+	return r.local.DeleteExpired(before)
+}
+
+func (r *ReplicatedDriver) TouchLastUsed(id int64) error {
+	return r.local.TouchLastUsed(id)
+}
+
+func (r *ReplicatedDriver) UpdateExpiry(id int64, expiresAt time.Time) error {
+	return r.local.UpdateExpiry(id, expiresAt)
+}
+
+func (r *ReplicatedDriver) UpdateToken(t *entity.PersonalAccessToken) error {
+	return r.local.UpdateToken(t)
+}
+
+func (r *ReplicatedDriver) LinkIdentity(link *entity.IdentityLink) error {
+	store, ok := r.local.(IdentityStore)
+	if !ok {
+		return utils.ErrNotSupported
+	}
+	return store.LinkIdentity(link)
+}
+
+func (r *ReplicatedDriver) UnlinkIdentity(provider, subject string) error {
+	store, ok := r.local.(IdentityStore)
+	if !ok {
+		return utils.ErrNotSupported
+	}
+	return store.UnlinkIdentity(provider, subject)
+}
+
+func (r *ReplicatedDriver) FindIdentity(provider, subject string) (*entity.IdentityLink, error) {
+	store, ok := r.local.(IdentityStore)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return store.FindIdentity(provider, subject)
+}
+
+func (r *ReplicatedDriver) ListIdentities(userID int64) ([]*entity.IdentityLink, error) {
+	store, ok := r.local.(IdentityStore)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return store.ListIdentities(userID)
+}
+
+func (r *ReplicatedDriver) orgStore() (OrgStore, error) {
+	store, ok := r.local.(OrgStore)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return store, nil
+}
+
+func (r *ReplicatedDriver) CreateOrgUnit(u *entity.OrgUnit) error {
+	store, err := r.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.CreateOrgUnit(u)
+}
+
+func (r *ReplicatedDriver) FindOrgUnit(id int64) (*entity.OrgUnit, error) {
+	store, err := r.orgStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.FindOrgUnit(id)
+}
+
+func (r *ReplicatedDriver) AddOrgMember(m *entity.OrgMember) error {
+	store, err := r.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.AddOrgMember(m)
+}
+
+func (r *ReplicatedDriver) RemoveOrgMember(unitID, userID int64) error {
+	store, err := r.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.RemoveOrgMember(unitID, userID)
+}
+
+func (r *ReplicatedDriver) UserOrgUnits(userID int64) ([]int64, error) {
+	store, err := r.orgStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.UserOrgUnits(userID)
+}
+
+func (r *ReplicatedDriver) GrantAbility(g *entity.AbilityGrant) error {
+	store, err := r.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.GrantAbility(g)
+}
+
+func (r *ReplicatedDriver) RevokeAbility(subjectType string, subjectID int64, ability string) error {
+	store, err := r.orgStore()
+	if err != nil {
+		return err
+	}
+	return store.RevokeAbility(subjectType, subjectID, ability)
+}
+
+func (r *ReplicatedDriver) ListGrants(subjectType string, subjectIDs []int64) ([]*entity.AbilityGrant, error) {
+	store, err := r.orgStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.ListGrants(subjectType, subjectIDs)
+}
+
+func (r *ReplicatedDriver) roleStore() (RoleStore, error) {
+	store, ok := r.local.(RoleStore)
+	if !ok {
+		return nil, utils.ErrNotSupported
+	}
+	return store, nil
+}
+
+func (r *ReplicatedDriver) SaveRole(role *entity.Role) error {
+	store, err := r.roleStore()
+	if err != nil {
+		return err
+	}
+	return store.SaveRole(role)
+}
+
+func (r *ReplicatedDriver) FindRole(name string) (*entity.Role, error) {
+	store, err := r.roleStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.FindRole(name)
+}
+
+func (r *ReplicatedDriver) DeleteRole(name string) error {
+	store, err := r.roleStore()
+	if err != nil {
+		return err
+	}
+	return store.DeleteRole(name)
+}
+
+func (r *ReplicatedDriver) ListRoles() ([]*entity.Role, error) {
+	store, err := r.roleStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.ListRoles()
+}
@@ -0,0 +1,13 @@
+// Package storage internal/storage/role.go
+package storage
+
+import "github.com/mohar9h/goauth/internal/entity"
+
+// RoleStore is implemented by drivers that persist role definitions.
+// FindRole returns utils.ErrRoleNotFound for unknown names.
+type RoleStore interface {
+	SaveRole(r *entity.Role) error // Creates the role or replaces the one with the same name
+	FindRole(name string) (*entity.Role, error)
+	DeleteRole(name string) error
+	ListRoles() ([]*entity.Role, error)
+}
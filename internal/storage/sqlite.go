@@ -0,0 +1,195 @@
+// Package storage internal/storage/sqlite.go
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"gorm.io/gorm"
+)
+
+// SQLiteOptions tunes the SQLite driver. Zero values select the defaults.
+type SQLiteOptions struct {
+	// BusyTimeout is how long a connection waits on a locked database
+	// before failing. Default 5s.
+	BusyTimeout time.Duration
+
+	// Synchronous is the PRAGMA synchronous level. Default "NORMAL", which
+	// is durable across application crashes in WAL mode.
+	Synchronous string
+}
+
+func (o SQLiteOptions) withDefaults() SQLiteOptions {
+	if o.BusyTimeout <= 0 {
+		o.BusyTimeout = 5 * time.Second
+	}
+	if o.Synchronous == "" {
+		o.Synchronous = "NORMAL"
+	}
+	return o
+}
+
+// SQLiteDSN builds a go-sqlite3 DSN that applies WAL mode, the busy timeout
+// and the synchronous level to every pooled connection. Pass the result to
+// sqlite.Open.
+func SQLiteDSN(path string, opts SQLiteOptions) string {
+	opts = opts.withDefaults()
+
+	q := url.Values{}
+	q.Set("_journal_mode", "WAL")
+	q.Set("_busy_timeout", fmt.Sprint(opts.BusyTimeout.Milliseconds()))
+	q.Set("_synchronous", opts.Synchronous)
+	q.Set("_txlock", "immediate")
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return "file:" + strings.TrimPrefix(path, "file:") + sep + q.Encode()
+}
+
+// sqliteDriver is the GORM driver with SQLite specific tuning. SQLite
+// allows a single writer at a time, so writes are funnelled through one
+// mutex instead of racing for the database lock; reads run concurrently
+// against the WAL snapshot.
+type sqliteDriver struct {
+	*gormDriver
+	writeMu sync.Mutex
+}
+
+// NewSQLiteDriver switches the database to WAL mode and returns a driver
+// that serialises writes. It fails for databases that cannot use WAL, such
+// as ":memory:".
+func NewSQLiteDriver(db *gorm.DB, opts SQLiteOptions) (Driver, error) {
+	opts = opts.withDefaults()
+
+	var mode string
+	if err := db.Raw("PRAGMA journal_mode = WAL").Scan(&mode).Error; err != nil {
+		return nil, fmt.Errorf("sqlite: enable WAL: %w", err)
+	}
+	if !strings.EqualFold(mode, "wal") {
+		return nil, fmt.Errorf("sqlite: WAL mode unavailable (journal_mode=%s)", mode)
+	}
+
+	pragmas := []string{
+		fmt.Sprintf("PRAGMA busy_timeout = %d", opts.BusyTimeout.Milliseconds()),
+		"PRAGMA synchronous = " + opts.Synchronous,
+	}
+	for _, p := range pragmas {
+		if err := db.Exec(p).Error; err != nil {
+			return nil, fmt.Errorf("sqlite: %s: %w", p, err)
+		}
+	}
+
+	return &sqliteDriver{gormDriver: &gormDriver{db: db, rec: defaultRecords}}, nil
+}
+
+func (s *sqliteDriver) Stats() Stats {
+	st := s.gormDriver.Stats()
+	st.Driver = "sqlite"
+	return st
+}
+
+func (s *sqliteDriver) write(fn func() error) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return fn()
+}
+
+func (s *sqliteDriver) writeN(fn func() (int64, error)) (int64, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return fn()
+}
+
+func (s *sqliteDriver) StoreToken(t *entity.PersonalAccessToken) error {
+	return s.write(func() error { return s.gormDriver.StoreToken(t) })
+}
+
+func (s *sqliteDriver) StoreTokens(ts []*entity.PersonalAccessToken) error {
+	return s.write(func() error { return s.gormDriver.StoreTokens(ts) })
+}
+
+func (s *sqliteDriver) UpsertToken(t *entity.PersonalAccessToken) error {
+	return s.write(func() error { return s.gormDriver.UpsertToken(t) })
+}
+
+func (s *sqliteDriver) UpdateToken(t *entity.PersonalAccessToken) error {
+	return s.write(func() error { return s.gormDriver.UpdateToken(t) })
+}
+
+func (s *sqliteDriver) RevokeToken(hash string) error {
+	return s.write(func() error { return s.gormDriver.RevokeToken(hash) })
+}
+
+func (s *sqliteDriver) MarkRevoked(hash string, at time.Time) error {
+	return s.write(func() error { return s.gormDriver.MarkRevoked(hash, at) })
+}
+
+func (s *sqliteDriver) RevokeFamily(familyID string, at time.Time) (int64, error) {
+	return s.writeN(func() (int64, error) { return s.gormDriver.RevokeFamily(familyID, at) })
+}
+
+func (s *sqliteDriver) DeleteExpired(before time.Time) (int64, error) {
+	return s.writeN(func() (int64, error) { return s.gormDriver.DeleteExpired(before) })
+}
+
+func (s *sqliteDriver) RevokeByUser(userID int64) (int64, error) {
+	return s.writeN(func() (int64, error) { return s.gormDriver.RevokeByUser(userID) })
+}
+
+func (s *sqliteDriver) MarkRevokedByUser(userID int64, at time.Time) (int64, error) {
+	return s.writeN(func() (int64, error) { return s.gormDriver.MarkRevokedByUser(userID, at) })
+}
+
+func (s *sqliteDriver) DeleteRevoked(before time.Time) (int64, error) {
+	return s.writeN(func() (int64, error) { return s.gormDriver.DeleteRevoked(before) })
+}
+
+func (s *sqliteDriver) TouchLastUsed(id int64) error {
+	return s.write(func() error { return s.gormDriver.TouchLastUsed(id) })
+}
+
+func (s *sqliteDriver) UpdateExpiry(id int64, expiresAt time.Time) error {
+	return s.write(func() error { return s.gormDriver.UpdateExpiry(id, expiresAt) })
+}
+
+func (s *sqliteDriver) LinkIdentity(link *entity.IdentityLink) error {
+	return s.write(func() error { return s.gormDriver.LinkIdentity(link) })
+}
+
+func (s *sqliteDriver) UnlinkIdentity(provider, subject string) error {
+	return s.write(func() error { return s.gormDriver.UnlinkIdentity(provider, subject) })
+}
+
+func (s *sqliteDriver) CreateOrgUnit(u *entity.OrgUnit) error {
+	return s.write(func() error { return s.gormDriver.CreateOrgUnit(u) })
+}
+
+func (s *sqliteDriver) AddOrgMember(m *entity.OrgMember) error {
+	return s.write(func() error { return s.gormDriver.AddOrgMember(m) })
+}
+
+func (s *sqliteDriver) RemoveOrgMember(unitID, userID int64) error {
+	return s.write(func() error { return s.gormDriver.RemoveOrgMember(unitID, userID) })
+}
+
+func (s *sqliteDriver) GrantAbility(g *entity.AbilityGrant) error {
+	return s.write(func() error { return s.gormDriver.GrantAbility(g) })
+}
+
+func (s *sqliteDriver) SaveRole(r *entity.Role) error {
+	return s.write(func() error { return s.gormDriver.SaveRole(r) })
+}
+
+func (s *sqliteDriver) DeleteRole(name string) error {
+	return s.write(func() error { return s.gormDriver.DeleteRole(name) })
+}
+
+func (s *sqliteDriver) RevokeAbility(subjectType string, subjectID int64, ability string) error {
+	return s.write(func() error { return s.gormDriver.RevokeAbility(subjectType, subjectID, ability) })
+}
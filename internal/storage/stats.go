@@ -0,0 +1,43 @@
+// Package storage internal/storage/stats.go
+package storage
+
+// Stats is a point-in-time snapshot of a driver's internal health.
+// Fields that don't apply to a driver are left zero.
+type Stats struct {
+	Driver          string // Driver name, e.g. "memory", "gorm"
+	Tokens          int64  // Tokens held (in-process drivers only)
+	OpenConnections int    // Established connections (SQL drivers)
+	InUse           int    // Connections currently in use
+	Idle            int    // Idle connections
+	WaitCount       int64  // Total waits for a free connection
+	QueueDepth      int    // Pending write-behind operations
+	CacheHits       uint64 // Cache lookups served without the backend
+	CacheMisses     uint64 // Cache lookups that fell through
+}
+
+// Statter is implemented by drivers that expose internal stats.
+type Statter interface {
+	Stats() Stats
+}
+
+// Wrapper is implemented by decorator drivers so the full chain can be inspected.
+type Wrapper interface {
+	Unwrap() Driver
+}
+
+// CollectStats walks a driver chain from the outermost decorator inward and
+// returns the stats of every driver that implements Statter.
+func CollectStats(d Driver) []Stats {
+	var out []Stats
+	for d != nil {
+		if s, ok := d.(Statter); ok {
+			out = append(out, s.Stats())
+		}
+		w, ok := d.(Wrapper)
+		if !ok {
+			break
+		}
+		d = w.Unwrap()
+	}
+	return out
+}
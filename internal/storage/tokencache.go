@@ -0,0 +1,146 @@
+// Package storage internal/storage/tokencache.go
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/cache"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/redis/go-redis/v9"
+)
+
+// memoryTokenCache is an in-process LRU TokenCache.
+type memoryTokenCache struct {
+	lru *cache.LRU[string, *entity.PersonalAccessToken]
+}
+
+// NewMemoryTokenCache returns a TokenCache holding at most size entries.
+func NewMemoryTokenCache(size int) TokenCache {
+	return &memoryTokenCache{lru: cache.New[string, *entity.PersonalAccessToken](size, 0)}
+}
+
+func (m *memoryTokenCache) Get(hash string) (*entity.PersonalAccessToken, bool, error) {
+	tok, ok := m.lru.Get(hash)
+	if !ok || tok == nil {
+		return nil, ok, nil
+	}
+	cp := *tok
+	return &cp, true, nil
+}
+
+func (m *memoryTokenCache) Set(hash string, tok *entity.PersonalAccessToken, ttl time.Duration) error {
+	m.lru.AddTTL(hash, tok, ttl)
+	return nil
+}
+
+func (m *memoryTokenCache) Delete(hashes ...string) error {
+	for _, h := range hashes {
+		m.lru.Remove(h)
+	}
+	return nil
+}
+
+func (m *memoryTokenCache) Purge() error {
+	m.lru.Purge()
+	return nil
+}
+
+// redisTokenCache shares cached tokens between processes through Redis.
+// Tokens are stored as JSON under prefix+hash; misses as an empty value.
+type redisTokenCache struct {
+	rdb     redis.UniversalClient
+	prefix  string
+	timeout time.Duration
+}
+
+// NewRedisTokenCache returns a TokenCache storing entries under prefix
+// (default "goauth:token:").
+func NewRedisTokenCache(rdb redis.UniversalClient, prefix string) TokenCache {
+	if prefix == "" {
+		prefix = "goauth:token:"
+	}
+	return &redisTokenCache{rdb: rdb, prefix: prefix, timeout: time.Second}
+}
+
+func (r *redisTokenCache) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), r.timeout)
+}
+
+func (r *redisTokenCache) Get(hash string) (*entity.PersonalAccessToken, bool, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+
+	data, err := r.rdb.Get(ctx, r.prefix+hash).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) == 0 {
+		return nil, true, nil
+	}
+
+	var tok entity.PersonalAccessToken
+	if err := json.Unmarshal(data, &tok); err != nil {
+		return nil, false, err
+	}
+	return &tok, true, nil
+}
+
+func (r *redisTokenCache) Set(hash string, tok *entity.PersonalAccessToken, ttl time.Duration) error {
+	var data []byte
+	if tok != nil {
+		var err error
+		if data, err = json.Marshal(tok); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := r.ctx()
+	defer cancel()
+	return r.rdb.Set(ctx, r.prefix+hash, data, ttl).Err()
+}
+
+func (r *redisTokenCache) Delete(hashes ...string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	keys := make([]string, len(hashes))
+	for i, h := range hashes {
+		keys[i] = r.prefix + h
+	}
+
+	ctx, cancel := r.ctx()
+	defer cancel()
+	return r.rdb.Del(ctx, keys...).Err()
+}
+
+// Purge deletes every key under the prefix. It scans the keyspace, so it
+// is reserved for rare bulk revocations.
+func (r *redisTokenCache) Purge() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	iter := r.rdb.Scan(ctx, 0, r.prefix+"*", 500).Iterator()
+	var batch []string
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == 500 {
+			if err := r.rdb.Unlink(ctx, batch...).Err(); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return r.rdb.Unlink(ctx, batch...).Err()
+	}
+	return nil
+}
@@ -0,0 +1,562 @@
+// Package storage internal/storage/tokenset.go
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// Token set file layout, little endian:
+//
+//	header   16 bytes: magic "GATS", version uint32, count uint32, reserved uint32
+//	records  count x 80 bytes, sorted by hash:
+//	         hash [32]byte, id int64, user_id int64, expires_at int64 (unix, 0 = never),
+//	         abilities offset/length uint32, metadata offset/length uint32,
+//	         kind uint8, 7 bytes padding
+//	blob     abilities strings and JSON metadata referenced by the records;
+//	         records with equal values share one copy
+const (
+	tokenSetMagic   = "GATS"
+	tokenSetVersion = 1
+	tokenSetHeader  = 16
+	tokenSetRecord  = 80
+)
+
+const (
+	kindCodeAccess byte = iota
+	kindCodeGuest
+)
+
+var (
+	errMalformedTokenSet = errors.New("malformed token set")
+	errTokenSetTooLarge  = errors.New("token set too large")
+)
+
+// Scanner is implemented by drivers that can enumerate every stored token.
+type Scanner interface {
+	ScanTokens(fn func(t *entity.PersonalAccessToken) error) error
+}
+
+// WriteTokenSet exports the live access and guest tokens of d in the token
+// set format and returns how many were written. Revoked, expired, refresh
+// and license records are left out; any other token whose hash can't be
+// indexed, such as a peppered or salted one, fails the export with
+// utils.ErrUnindexedHash rather than being left out.
+func WriteTokenSet(w io.Writer, d Driver, now time.Time) (int, error) {
+	sc, ok := ScannerOf(d)
+	if !ok {
+		return 0, utils.ErrNotSupported
+	}
+
+	type row struct {
+		hash [32]byte
+		tok  *entity.PersonalAccessToken
+	}
+	var rows []row
+	err := sc.ScanTokens(func(t *entity.PersonalAccessToken) error {
+		if t.RevokedAt != nil || t.IsRefresh() || t.IsLicense() || (t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)) {
+			return nil
+		}
+		var r row
+		if !decodeHash(&r.hash, t.Token) {
+			return fmt.Errorf("token %d: %w", t.ID, utils.ErrUnindexedHash)
+		}
+		r.tok = t
+		rows = append(rows, r)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(rows, func(i, j int) bool { return bytes.Compare(rows[i].hash[:], rows[j].hash[:]) < 0 })
+
+	var blob bytes.Buffer
+	offsets := make(map[string]uint32)
+	putString := func(ref []byte, v string) error {
+		off, ok := offsets[v]
+		if !ok {
+			if uint64(blob.Len())+uint64(len(v)) > math.MaxUint32 {
+				return errTokenSetTooLarge
+			}
+			off = uint32(blob.Len())
+			offsets[v] = off
+			blob.WriteString(v)
+		}
+		binary.LittleEndian.PutUint32(ref, off)
+		binary.LittleEndian.PutUint32(ref[4:], uint32(len(v)))
+		return nil
+	}
+
+	records := make([]byte, len(rows)*tokenSetRecord)
+	for i, r := range rows {
+		rec := records[i*tokenSetRecord : (i+1)*tokenSetRecord]
+		copy(rec[0:32], r.hash[:])
+		binary.LittleEndian.PutUint64(rec[32:], uint64(r.tok.ID))
+		binary.LittleEndian.PutUint64(rec[40:], uint64(r.tok.UserId))
+		if r.tok.ExpiresAt != nil {
+			binary.LittleEndian.PutUint64(rec[48:], uint64(r.tok.ExpiresAt.Unix()))
+		}
+
+		if err := putString(rec[56:], r.tok.Abilities); err != nil {
+			return 0, err
+		}
+		if len(r.tok.Metadata) > 0 {
+			meta, err := json.Marshal(r.tok.Metadata)
+			if err != nil {
+				return 0, err
+			}
+			if err := putString(rec[64:], string(meta)); err != nil {
+				return 0, err
+			}
+		}
+
+		if r.tok.IsGuest() {
+			rec[72] = kindCodeGuest
+		}
+	}
+
+	var header [tokenSetHeader]byte
+	copy(header[:], tokenSetMagic)
+	binary.LittleEndian.PutUint32(header[4:], tokenSetVersion)
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(rows)))
+
+	bw := bufio.NewWriter(w)
+	for _, part := range [][]byte{header[:], records, blob.Bytes()} {
+		if _, err := bw.Write(part); err != nil {
+			return 0, err
+		}
+	}
+	return len(rows), bw.Flush()
+}
+
+// ScannerOf returns the Scanner of d or of the driver it wraps
+func ScannerOf(d Driver) (Scanner, bool) {
+	for d != nil {
+		if sc, ok := d.(Scanner); ok {
+			return sc, true
+		}
+		w, ok := d.(Wrapper)
+		if !ok {
+			break
+		}
+		d = w.Unwrap()
+	}
+	return nil, false
+}
+
+// TokenSet is a read-only sorted hash index in the token set format.
+// Lookups binary-search the underlying bytes, which may be memory-mapped.
+type TokenSet struct {
+	records []byte
+	blob    []byte
+	count   int
+	release func() error
+
+	// Decoded abilities and metadata by blob reference, so materializing a
+	// record doesn't decode or copy on every lookup
+	abilities map[uint64]string
+	metadata  map[uint64]map[string]string
+}
+
+// TokenSetEntry is one record of a TokenSet. Abilities and Metadata alias
+// the set's memory and are only valid until the set is closed.
+type TokenSetEntry struct {
+	ID        int64
+	UserID    int64
+	ExpiresAt int64 // Unix seconds, 0 = never
+	Kind      string
+	Abilities []byte
+	Metadata  []byte // JSON object, empty when the token has none
+}
+
+// ParseTokenSet validates data and returns a TokenSet reading its records
+// from it without copying. Each distinct abilities and metadata value is
+// decoded once, up front.
+func ParseTokenSet(data []byte) (*TokenSet, error) {
+	if len(data) < tokenSetHeader || string(data[:4]) != tokenSetMagic {
+		return nil, errMalformedTokenSet
+	}
+	if v := binary.LittleEndian.Uint32(data[4:]); v != tokenSetVersion {
+		return nil, fmt.Errorf("unsupported token set version %d", v)
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[8:]))
+	end := tokenSetHeader + count*tokenSetRecord
+	if count < 0 || end > len(data) {
+		return nil, errMalformedTokenSet
+	}
+
+	s := &TokenSet{
+		records:   data[tokenSetHeader:end],
+		blob:      data[end:],
+		count:     count,
+		abilities: make(map[uint64]string),
+		metadata:  make(map[uint64]map[string]string),
+	}
+	for i := 0; i < count; i++ {
+		rec := s.record(i)
+		for _, off := range []int{56, 64} {
+			start := uint64(binary.LittleEndian.Uint32(rec[off:]))
+			n := uint64(binary.LittleEndian.Uint32(rec[off+4:]))
+			if start+n > uint64(len(s.blob)) {
+				return nil, errMalformedTokenSet
+			}
+		}
+
+		ref := binary.LittleEndian.Uint64(rec[56:])
+		if _, ok := s.abilities[ref]; !ok {
+			s.abilities[ref] = string(s.slice(rec[56:]))
+		}
+		ref = binary.LittleEndian.Uint64(rec[64:])
+		if _, ok := s.metadata[ref]; ok {
+			continue
+		}
+		var meta map[string]string
+		if raw := s.slice(rec[64:]); len(raw) > 0 {
+			if err := json.Unmarshal(raw, &meta); err != nil {
+				return nil, fmt.Errorf("%w: %v", errMalformedTokenSet, err)
+			}
+		}
+		s.metadata[ref] = meta
+	}
+	return s, nil
+}
+
+// OpenTokenSet memory-maps the token set file at path
+func OpenTokenSet(path string) (*TokenSet, error) {
+	data, release, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := ParseTokenSet(data)
+	if err != nil {
+		_ = release()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s.release = release
+	return s, nil
+}
+
+// Close unmaps the set. Entries returned by Lookup must not be used after.
+func (s *TokenSet) Close() error {
+	if s.release == nil {
+		return nil
+	}
+	release := s.release
+	s.release = nil
+	return release()
+}
+
+// Len returns the number of tokens in the set
+func (s *TokenSet) Len() int {
+	return s.count
+}
+
+// Lookup finds the token with the given hex-encoded hash. It does not
+// allocate.
+func (s *TokenSet) Lookup(hash string) (TokenSetEntry, bool) {
+	i, ok := s.find(hash)
+	if !ok {
+		return TokenSetEntry{}, false
+	}
+	return s.entry(i), true
+}
+
+// find returns the index of the record with the given hash
+func (s *TokenSet) find(hash string) (int, bool) {
+	var key [32]byte
+	if !decodeHash(&key, hash) {
+		return 0, false
+	}
+
+	lo, hi := 0, s.count
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if bytes.Compare(s.record(mid)[:32], key[:]) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == s.count || !bytes.Equal(s.record(lo)[:32], key[:]) {
+		return 0, false
+	}
+	return lo, true
+}
+
+func (s *TokenSet) record(i int) []byte {
+	return s.records[i*tokenSetRecord : (i+1)*tokenSetRecord]
+}
+
+func (s *TokenSet) entry(i int) TokenSetEntry {
+	rec := s.record(i)
+	e := TokenSetEntry{
+		ID:        int64(binary.LittleEndian.Uint64(rec[32:])),
+		UserID:    int64(binary.LittleEndian.Uint64(rec[40:])),
+		ExpiresAt: int64(binary.LittleEndian.Uint64(rec[48:])),
+		Kind:      entity.KindAccess,
+		Abilities: s.slice(rec[56:]),
+		Metadata:  s.slice(rec[64:]),
+	}
+	if rec[72] == kindCodeGuest {
+		e.Kind = entity.KindGuest
+	}
+	return e
+}
+
+func (s *TokenSet) slice(ref []byte) []byte {
+	off := binary.LittleEndian.Uint32(ref)
+	n := binary.LittleEndian.Uint32(ref[4:])
+	return s.blob[off : off+n]
+}
+
+// decodeHash parses a hex SHA-256 digest, bare or as a "$sha256$" hash
+// string, into dst without allocating. Salted hashes are not indexed.
+func decodeHash(dst *[32]byte, s string) bool {
+	s = strings.TrimPrefix(s, "$sha256$")
+	if len(s) != 64 {
+		return false
+	}
+	for i := 0; i < 32; i++ {
+		hi, ok1 := fromHex(s[2*i])
+		lo, ok2 := fromHex(s[2*i+1])
+		if !ok1 || !ok2 {
+			return false
+		}
+		dst[i] = hi<<4 | lo
+	}
+	return true
+}
+
+func fromHex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// TokenSetDriver is a read-only Driver backed by a TokenSet, for edge
+// validators with a fixed token population. Writes fail with
+// ErrReadOnlyStorage; last-used and sliding-expiry updates are dropped.
+type TokenSetDriver struct {
+	mu  sync.RWMutex
+	set *TokenSet
+	clock
+}
+
+var _ Driver = (*TokenSetDriver)(nil)
+
+// NewTokenSetDriver returns a read-only driver serving lookups from set
+func NewTokenSetDriver(set *TokenSet) *TokenSetDriver {
+	return &TokenSetDriver{set: set}
+}
+
+// Swap replaces the served set, e.g. after a fresh export, and closes the
+// previous one once in-flight lookups have finished.
+func (d *TokenSetDriver) Swap(set *TokenSet) error {
+	d.mu.Lock()
+	old := d.set
+	d.set = set
+	d.mu.Unlock()
+
+	if old == nil {
+		return nil
+	}
+	return old.Close()
+}
+
+// Close releases the served set
+func (d *TokenSetDriver) Close() error {
+	return d.Swap(nil)
+}
+
+func (d *TokenSetDriver) Capabilities() Capabilities {
+	return Capabilities{
+		List:           true,
+		TTLEnforcement: true,
+	}
+}
+
+func (d *TokenSetDriver) Stats() Stats {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	st := Stats{Driver: "tokenset"}
+	if d.set != nil {
+		st.Tokens = int64(d.set.Len())
+	}
+	return st
+}
+
+// FindByHash allocates only the returned record. Its abilities and metadata
+// are shared with other lookups of the same values; Metadata must not be
+// modified.
+func (d *TokenSetDriver) FindByHash(hash string) (*entity.PersonalAccessToken, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.set == nil {
+		return nil, utils.ErrTokenNotFound
+	}
+	i, ok := d.set.find(hash)
+	if !ok {
+		return nil, utils.ErrTokenNotFound
+	}
+	return d.set.materialize(i, hash, d.Now())
+}
+
+func (d *TokenSetDriver) FindByID(id int64) (*entity.PersonalAccessToken, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for i := 0; d.set != nil && i < d.set.count; i++ {
+		if d.set.id(i) == id {
+			return d.set.materialize(i, d.set.hash(i), d.Now())
+		}
+	}
+	return nil, utils.ErrTokenNotFound
+}
+
+func (d *TokenSetDriver) FindByUser(userID int64, opts ListOptions) ([]*entity.PersonalAccessToken, int64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	now := d.Now()
+	var matched []*entity.PersonalAccessToken
+	for i := 0; d.set != nil && i < d.set.count; i++ {
+		if d.set.userID(i) != userID {
+			continue
+		}
+		tok, err := d.set.materialize(i, d.set.hash(i), time.Time{})
+		if err != nil {
+			return nil, 0, err
+		}
+		if opts.Status.Matches(tok, now) {
+			matched = append(matched, tok)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID < matched[j].ID })
+
+	total := int64(len(matched))
+	if opts.Offset >= len(matched) {
+		return []*entity.PersonalAccessToken{}, total, nil
+	}
+	matched = matched[opts.Offset:]
+	if opts.Limit > 0 && opts.Limit < len(matched) {
+		matched = matched[:opts.Limit]
+	}
+	return matched, total, nil
+}
+
+// ScanTokens calls fn with every token of the set, including expired ones
+func (d *TokenSetDriver) ScanTokens(fn func(t *entity.PersonalAccessToken) error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for i := 0; d.set != nil && i < d.set.count; i++ {
+		tok, err := d.set.materialize(i, d.set.hash(i), time.Time{})
+		if err != nil {
+			return err
+		}
+		if err := fn(tok); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// materialize builds the token record of entry i in a single allocation.
+// A zero now skips the expiry check.
+func (s *TokenSet) materialize(i int, hash string, now time.Time) (*entity.PersonalAccessToken, error) {
+	e := s.record(i)
+	rec := &struct {
+		tok entity.PersonalAccessToken
+		exp time.Time
+	}{}
+	rec.tok = entity.PersonalAccessToken{
+		ID:        s.id(i),
+		UserId:    s.userID(i),
+		Token:     hash,
+		Abilities: s.abilities[binary.LittleEndian.Uint64(e[56:])],
+		Metadata:  s.metadata[binary.LittleEndian.Uint64(e[64:])],
+		Kind:      entity.KindAccess,
+	}
+	if e[72] == kindCodeGuest {
+		rec.tok.Kind = entity.KindGuest
+	}
+	if exp := int64(binary.LittleEndian.Uint64(e[48:])); exp != 0 {
+		rec.exp = time.Unix(exp, 0)
+		if !now.IsZero() && now.After(rec.exp) {
+			return nil, utils.ErrTokenExpired
+		}
+		rec.tok.ExpiresAt = &rec.exp
+	}
+	return &rec.tok, nil
+}
+
+func (s *TokenSet) id(i int) int64 {
+	return int64(binary.LittleEndian.Uint64(s.record(i)[32:]))
+}
+
+func (s *TokenSet) userID(i int) int64 {
+	return int64(binary.LittleEndian.Uint64(s.record(i)[40:]))
+}
+
+func (s *TokenSet) hash(i int) string {
+	return hex.EncodeToString(s.record(i)[:32])
+}
+
+func (d *TokenSetDriver) StoreToken(*entity.PersonalAccessToken) error {
+	return utils.ErrReadOnlyStorage
+}
+
+func (d *TokenSetDriver) UpdateToken(*entity.PersonalAccessToken) error {
+	return utils.ErrReadOnlyStorage
+}
+
+func (d *TokenSetDriver) RevokeToken(string) error {
+	return utils.ErrReadOnlyStorage
+}
+
+func (d *TokenSetDriver) MarkRevoked(string, time.Time) error {
+	return utils.ErrReadOnlyStorage
+}
+
+func (d *TokenSetDriver) RevokeFamily(string, time.Time) (int64, error) {
+	return 0, utils.ErrReadOnlyStorage
+}
+
+func (d *TokenSetDriver) DeleteExpired(time.Time) (int64, error) {
+	return 0, utils.ErrReadOnlyStorage
+}
+
+// TouchLastUsed is a no-op; usage isn't tracked on read-only replicas
+func (d *TokenSetDriver) TouchLastUsed(int64) error {
+	return nil
+}
+
+// UpdateExpiry is a no-op; the exported expiry stays in effect until the
+// next regeneration
+func (d *TokenSetDriver) UpdateExpiry(int64, time.Time) error {
+	return nil
+}
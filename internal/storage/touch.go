@@ -0,0 +1,48 @@
+// Package storage internal/storage/touch.go
+package storage
+
+import (
+	"errors"
+	"sync"
+)
+
+// TouchBatcher coalesces TouchLastUsed writes: a token validated many times
+// between flushes is written once. Drivers are used as map keys, so they
+// must be comparable (the built-in ones are pointers).
+type TouchBatcher struct {
+	mu      sync.Mutex
+	pending map[touchKey]struct{}
+}
+
+type touchKey struct {
+	driver Driver
+	id     int64
+}
+
+func NewTouchBatcher() *TouchBatcher {
+	return &TouchBatcher{pending: make(map[touchKey]struct{})}
+}
+
+// Touch queues a last-used update for token id in d
+func (b *TouchBatcher) Touch(d Driver, id int64) {
+	b.mu.Lock()
+	b.pending[touchKey{d, id}] = struct{}{}
+	b.mu.Unlock()
+}
+
+// Flush writes every queued update. Tokens deleted since they were queued
+// are skipped.
+func (b *TouchBatcher) Flush() error {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = make(map[touchKey]struct{})
+	b.mu.Unlock()
+
+	var errs []error
+	for k := range pending {
+		if err := k.driver.TouchLastUsed(k.id); err != nil && !isNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
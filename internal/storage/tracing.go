@@ -0,0 +1,455 @@
+// Package storage internal/storage/tracing.go
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingDriver wraps a Driver and emits an OpenTelemetry span per call.
+// Drivers take no context, so callers bind one with WithContext to parent
+// storage spans under the client operation.
+type TracingDriver struct {
+	inner  Driver
+	tracer trace.Tracer
+	ctx    context.Context
+}
+
+var _ Driver = (*TracingDriver)(nil)
+
+func NewTracingDriver(inner Driver, tracer trace.Tracer) *TracingDriver {
+	return &TracingDriver{inner: inner, tracer: tracer, ctx: context.Background()}
+}
+
+// WithContext returns a copy whose spans are children of the span in ctx.
+func (t *TracingDriver) WithContext(ctx context.Context) *TracingDriver {
+	cp := *t
+	cp.ctx = ctx
+	return &cp
+}
+
+func (t *TracingDriver) Unwrap() Driver {
+	return t.inner
+}
+
+func (t *TracingDriver) Capabilities() Capabilities {
+	return CapabilitiesOf(t.inner)
+}
+
+func (t *TracingDriver) start(op string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := t.tracer.Start(t.ctx, "goauth.storage."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	return span
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attribute.String("goauth.outcome", "error"))
+	} else {
+		span.SetAttributes(attribute.String("goauth.outcome", "ok"))
+	}
+	span.End()
+}
+
+// HashAttr shortens a stored token hash for use as a span attribute.
+func HashAttr(hash string) attribute.KeyValue {
+	if len(hash) > 16 {
+		hash = hash[:16]
+	}
+	return attribute.String("goauth.token.hash", hash)
+}
+
+func tokenAttrs(tok *entity.PersonalAccessToken) []attribute.KeyValue {
+	if tok == nil {
+		return nil
+	}
+	return []attribute.KeyValue{
+		HashAttr(tok.Token),
+		attribute.Int64("goauth.token.id", tok.ID),
+		attribute.Int64("goauth.user_id", tok.UserId),
+	}
+}
+
+func (t *TracingDriver) FindByID(id int64) (*entity.PersonalAccessToken, error) {
+	span := t.start("FindByID", attribute.Int64("goauth.token.id", id))
+	tok, err := t.inner.FindByID(id)
+	span.SetAttributes(tokenAttrs(tok)...)
+	end(span, err)
+	return tok, err
+}
+
+func (t *TracingDriver) FindByHash(hash string) (*entity.PersonalAccessToken, error) {
+	span := t.start("FindByHash", HashAttr(hash))
+	tok, err := t.inner.FindByHash(hash)
+	span.SetAttributes(tokenAttrs(tok)...)
+	end(span, err)
+	return tok, err
+}
+
+func (t *TracingDriver) CountByUser(userID int64) (int64, error) {
+	span := t.start("CountByUser", attribute.Int64("goauth.user_id", userID))
+	counter, ok := t.inner.(UserCounter)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return 0, utils.ErrNotSupported
+	}
+	n, err := counter.CountByUser(userID)
+	end(span, err)
+	return n, err
+}
+
+func (t *TracingDriver) CountByOrg(orgID int64, kind string) (int64, error) {
+	span := t.start("CountByOrg", attribute.Int64("goauth.org_id", orgID))
+	counter, ok := t.inner.(OrgCounter)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return 0, utils.ErrNotSupported
+	}
+	n, err := counter.CountByOrg(orgID, kind)
+	end(span, err)
+	return n, err
+}
+
+func (t *TracingDriver) ChangesSince(after ChangeCursor, limit int) ([]*entity.PersonalAccessToken, error) {
+	span := t.start("ChangesSince", attribute.Int64("goauth.revision", after.Revision))
+	feed, ok := t.inner.(ChangeFeed)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return nil, utils.ErrNotSupported
+	}
+	toks, err := feed.ChangesSince(after, limit)
+	span.SetAttributes(attribute.Int("goauth.changes", len(toks)))
+	end(span, err)
+	return toks, err
+}
+
+func (t *TracingDriver) FindByUser(userID int64, opts ListOptions) ([]*entity.PersonalAccessToken, int64, error) {
+	span := t.start("FindByUser", attribute.Int64("goauth.user_id", userID), attribute.String("goauth.status", string(opts.Status)))
+	toks, total, err := t.inner.FindByUser(userID, opts)
+	span.SetAttributes(attribute.Int64("goauth.total", total))
+	end(span, err)
+	return toks, total, err
+}
+
+func (t *TracingDriver) RevokeToken(hash string) error {
+	span := t.start("RevokeToken", HashAttr(hash))
+	err := t.inner.RevokeToken(hash)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) MarkRevoked(hash string, at time.Time) error {
+	span := t.start("MarkRevoked", HashAttr(hash))
+	err := t.inner.MarkRevoked(hash, at)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) RevokeFamily(familyID string, at time.Time) (int64, error) {
+	span := t.start("RevokeFamily")
+	n, err := t.inner.RevokeFamily(familyID, at)
+	span.SetAttributes(attribute.Int64("goauth.affected", n))
+	end(span, err)
+	return n, err
+}
+
+func (t *TracingDriver) DeleteExpired(before time.Time) (int64, error) {
+	span := t.start("DeleteExpired")
+	n, err := t.inner.DeleteExpired(before)
+	span.SetAttributes(attribute.Int64("goauth.affected", n))
+	end(span, err)
+	return n, err
+}
+
+func (t *TracingDriver) TouchLastUsed(id int64) error {
+	span := t.start("TouchLastUsed", attribute.Int64("goauth.token.id", id))
+	err := t.inner.TouchLastUsed(id)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) UpdateExpiry(id int64, expiresAt time.Time) error {
+	span := t.start("UpdateExpiry", attribute.Int64("goauth.token.id", id))
+	err := t.inner.UpdateExpiry(id, expiresAt)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) StoreToken(tok *entity.PersonalAccessToken) error {
+	span := t.start("StoreToken", attribute.Int64("goauth.user_id", tok.UserId))
+	err := t.inner.StoreToken(tok)
+	span.SetAttributes(tokenAttrs(tok)...)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) StoreTokens(ts []*entity.PersonalAccessToken) error {
+	span := t.start("StoreTokens", attribute.Int("goauth.batch_size", len(ts)))
+	batch, ok := t.inner.(BatchStorer)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return utils.ErrNotSupported
+	}
+	err := batch.StoreTokens(ts)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) UpdateToken(tok *entity.PersonalAccessToken) error {
+	span := t.start("UpdateToken", tokenAttrs(tok)...)
+	err := t.inner.UpdateToken(tok)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) UpsertToken(tok *entity.PersonalAccessToken) error {
+	span := t.start("UpsertToken", attribute.Int64("goauth.user_id", tok.UserId))
+	up, ok := t.inner.(Upserter)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return utils.ErrNotSupported
+	}
+	err := up.UpsertToken(tok)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) RevokeByUser(userID int64) (int64, error) {
+	span := t.start("RevokeByUser", attribute.Int64("goauth.user_id", userID))
+	bulk, ok := t.inner.(BulkRevoker)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return 0, utils.ErrNotSupported
+	}
+	n, err := bulk.RevokeByUser(userID)
+	span.SetAttributes(attribute.Int64("goauth.affected", n))
+	end(span, err)
+	return n, err
+}
+
+func (t *TracingDriver) MarkRevokedByUser(userID int64, at time.Time) (int64, error) {
+	span := t.start("MarkRevokedByUser", attribute.Int64("goauth.user_id", userID))
+	soft, ok := t.inner.(SoftRevoker)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return 0, utils.ErrNotSupported
+	}
+	n, err := soft.MarkRevokedByUser(userID, at)
+	span.SetAttributes(attribute.Int64("goauth.affected", n))
+	end(span, err)
+	return n, err
+}
+
+func (t *TracingDriver) DeleteRevoked(before time.Time) (int64, error) {
+	span := t.start("DeleteRevoked")
+	soft, ok := t.inner.(SoftRevoker)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return 0, utils.ErrNotSupported
+	}
+	n, err := soft.DeleteRevoked(before)
+	span.SetAttributes(attribute.Int64("goauth.affected", n))
+	end(span, err)
+	return n, err
+}
+
+func (t *TracingDriver) LinkIdentity(link *entity.IdentityLink) error {
+	span := t.start("LinkIdentity", attribute.Int64("goauth.user_id", link.UserId))
+	store, ok := t.inner.(IdentityStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return utils.ErrNotSupported
+	}
+	err := store.LinkIdentity(link)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) UnlinkIdentity(provider, subject string) error {
+	span := t.start("UnlinkIdentity", attribute.String("goauth.provider", provider))
+	store, ok := t.inner.(IdentityStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return utils.ErrNotSupported
+	}
+	err := store.UnlinkIdentity(provider, subject)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) FindIdentity(provider, subject string) (*entity.IdentityLink, error) {
+	span := t.start("FindIdentity", attribute.String("goauth.provider", provider))
+	store, ok := t.inner.(IdentityStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return nil, utils.ErrNotSupported
+	}
+	link, err := store.FindIdentity(provider, subject)
+	end(span, err)
+	return link, err
+}
+
+func (t *TracingDriver) ListIdentities(userID int64) ([]*entity.IdentityLink, error) {
+	span := t.start("ListIdentities", attribute.Int64("goauth.user_id", userID))
+	store, ok := t.inner.(IdentityStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return nil, utils.ErrNotSupported
+	}
+	links, err := store.ListIdentities(userID)
+	end(span, err)
+	return links, err
+}
+
+func (t *TracingDriver) CreateOrgUnit(u *entity.OrgUnit) error {
+	span := t.start("CreateOrgUnit", attribute.String("goauth.org_kind", u.Kind))
+	store, ok := t.inner.(OrgStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return utils.ErrNotSupported
+	}
+	err := store.CreateOrgUnit(u)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) FindOrgUnit(id int64) (*entity.OrgUnit, error) {
+	span := t.start("FindOrgUnit", attribute.Int64("goauth.unit_id", id))
+	store, ok := t.inner.(OrgStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return nil, utils.ErrNotSupported
+	}
+	res, err := store.FindOrgUnit(id)
+	end(span, err)
+	return res, err
+}
+
+func (t *TracingDriver) AddOrgMember(m *entity.OrgMember) error {
+	span := t.start("AddOrgMember", attribute.Int64("goauth.unit_id", m.UnitID), attribute.Int64("goauth.user_id", m.UserId))
+	store, ok := t.inner.(OrgStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return utils.ErrNotSupported
+	}
+	err := store.AddOrgMember(m)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) RemoveOrgMember(unitID, userID int64) error {
+	span := t.start("RemoveOrgMember", attribute.Int64("goauth.unit_id", unitID), attribute.Int64("goauth.user_id", userID))
+	store, ok := t.inner.(OrgStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return utils.ErrNotSupported
+	}
+	err := store.RemoveOrgMember(unitID, userID)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) UserOrgUnits(userID int64) ([]int64, error) {
+	span := t.start("UserOrgUnits", attribute.Int64("goauth.user_id", userID))
+	store, ok := t.inner.(OrgStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return nil, utils.ErrNotSupported
+	}
+	res, err := store.UserOrgUnits(userID)
+	end(span, err)
+	return res, err
+}
+
+func (t *TracingDriver) GrantAbility(g *entity.AbilityGrant) error {
+	span := t.start("GrantAbility", attribute.String("goauth.subject_type", g.SubjectType))
+	store, ok := t.inner.(OrgStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return utils.ErrNotSupported
+	}
+	err := store.GrantAbility(g)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) RevokeAbility(subjectType string, subjectID int64, ability string) error {
+	span := t.start("RevokeAbility", attribute.String("goauth.subject_type", subjectType))
+	store, ok := t.inner.(OrgStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return utils.ErrNotSupported
+	}
+	err := store.RevokeAbility(subjectType, subjectID, ability)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) ListGrants(subjectType string, subjectIDs []int64) ([]*entity.AbilityGrant, error) {
+	span := t.start("ListGrants", attribute.String("goauth.subject_type", subjectType))
+	store, ok := t.inner.(OrgStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return nil, utils.ErrNotSupported
+	}
+	res, err := store.ListGrants(subjectType, subjectIDs)
+	end(span, err)
+	return res, err
+}
+
+func (t *TracingDriver) SaveRole(r *entity.Role) error {
+	span := t.start("SaveRole", attribute.String("goauth.role", r.Name))
+	store, ok := t.inner.(RoleStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return utils.ErrNotSupported
+	}
+	err := store.SaveRole(r)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) FindRole(name string) (*entity.Role, error) {
+	span := t.start("FindRole", attribute.String("goauth.role", name))
+	store, ok := t.inner.(RoleStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return nil, utils.ErrNotSupported
+	}
+	res, err := store.FindRole(name)
+	end(span, err)
+	return res, err
+}
+
+func (t *TracingDriver) DeleteRole(name string) error {
+	span := t.start("DeleteRole", attribute.String("goauth.role", name))
+	store, ok := t.inner.(RoleStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return utils.ErrNotSupported
+	}
+	err := store.DeleteRole(name)
+	end(span, err)
+	return err
+}
+
+func (t *TracingDriver) ListRoles() ([]*entity.Role, error) {
+	span := t.start("ListRoles")
+	store, ok := t.inner.(RoleStore)
+	if !ok {
+		end(span, utils.ErrNotSupported)
+		return nil, utils.ErrNotSupported
+	}
+	res, err := store.ListRoles()
+	end(span, err)
+	return res, err
+}
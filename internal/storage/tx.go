@@ -0,0 +1,143 @@
+// Package storage internal/storage/tx.go
+package storage
+
+import (
+	"github.com/mohar9h/goauth/internal/entity"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Transactor is implemented by drivers that can run several operations
+// atomically. fn receives a driver bound to the transaction; every call on
+// it commits with the transaction, or is rolled back when fn returns an
+// error. The driver must not be used after fn returns.
+type Transactor interface {
+	WithinTransaction(fn func(tx Driver) error) error
+}
+
+// UserLocker is implemented by transaction drivers that can serialise
+// concurrent transactions working on the same user's tokens, so a count
+// followed by an insert can't be interleaved with another one. The lock is
+// held until the transaction ends.
+type UserLocker interface {
+	LockUser(userID int64) error
+}
+
+// Transactional reports whether d runs WithinTransaction atomically.
+func Transactional(d Driver) bool {
+	_, ok := d.(Transactor)
+	return ok && CapabilitiesOf(d).Transactions
+}
+
+// Atomic runs fn in a transaction of d, or directly against d when the
+// driver has no transactions.
+func Atomic(d Driver, fn func(tx Driver) error) error {
+	if !Transactional(d) {
+		return fn(d)
+	}
+	return d.(Transactor).WithinTransaction(fn)
+}
+
+// WithinTransaction runs fn against a copy of the driver bound to a GORM
+// transaction. Nested calls use savepoints.
+func (g *gormDriver) WithinTransaction(fn func(tx Driver) error) error {
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		cp := *g
+		cp.db = tx
+		return fn(&cp)
+	})
+}
+
+// LockUser takes a transaction scoped lock on the user. SQLite needs none:
+// its transactions already take the write lock up front.
+func (g *gormDriver) LockUser(userID int64) error {
+	switch g.db.Dialector.Name() {
+	case "sqlite":
+		return nil
+	case "postgres":
+		return g.db.Exec("SELECT pg_advisory_xact_lock(?, ?)", userLockClass, userID).Error
+	}
+	var ids []int64
+	return g.db.Model(g.rec.model()).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where(g.c("user_id")+" = ?", userID).
+		Pluck(g.c("id"), &ids).Error
+}
+
+// userLockClass namespaces goauth's Postgres advisory locks
+const userLockClass = 0x676f61 // "goa"
+
+// WithinTransaction holds the write lock for the whole transaction. The
+// transaction driver is the plain GORM driver, so writes inside it don't
+// take the lock again.
+func (s *sqliteDriver) WithinTransaction(fn func(tx Driver) error) error {
+	return s.write(func() error { return s.gormDriver.WithinTransaction(fn) })
+}
+
+// WithinTransaction runs fn in a transaction of the inner driver, tracing
+// the calls made in it.
+func (t *TracingDriver) WithinTransaction(fn func(tx Driver) error) error {
+	if !Transactional(t.inner) {
+		return fn(t)
+	}
+	span := t.start("Transaction")
+	err := t.inner.(Transactor).WithinTransaction(func(tx Driver) error {
+		cp := *t
+		cp.inner = tx
+		return fn(&cp)
+	})
+	end(span, err)
+	return err
+}
+
+// WithinTransaction runs fn in a transaction of the inner driver. Writes in
+// the transaction don't populate the cache, and every hash they touched is
+// dropped again once it has committed or rolled back, so neither a rolled
+// back token nor a record read before the commit stays cached.
+func (c *CachingDriver) WithinTransaction(fn func(tx Driver) error) error {
+	if !Transactional(c.inner) {
+		return fn(c)
+	}
+	log := &txLog{}
+	err := c.inner.(Transactor).WithinTransaction(func(tx Driver) error {
+		return fn(&CachingDriver{inner: tx, cache: c.cache, opts: c.opts, clock: c.clock, tx: log})
+	})
+	if log.purge {
+		_ = c.cache.Purge()
+	} else if len(log.hashes) > 0 {
+		_ = c.cache.Delete(log.hashes...)
+	}
+	return err
+}
+
+// txLog records the cache entries a transaction of a CachingDriver
+// touched
+type txLog struct {
+	hashes []string
+	purge  bool
+}
+
+func (l *txLog) touch(ts ...*entity.PersonalAccessToken) {
+	for _, t := range ts {
+		l.hashes = append(l.hashes, t.Token)
+	}
+}
+
+func (t *TracingDriver) LockUser(userID int64) error {
+	locker, ok := t.inner.(UserLocker)
+	if !ok {
+		return nil
+	}
+	span := t.start("LockUser", attribute.Int64("goauth.user_id", userID))
+	err := locker.LockUser(userID)
+	end(span, err)
+	return err
+}
+
+func (c *CachingDriver) LockUser(userID int64) error {
+	if locker, ok := c.inner.(UserLocker); ok {
+		return locker.LockUser(userID)
+	}
+	return nil
+}
@@ -8,6 +8,16 @@ import (
 
 // HashToken returns SHA256 hash of token (for storage).
 func HashToken(raw string) string {
-	sum := sha256.Sum256([]byte(raw))
-	return hex.EncodeToString(sum[:])
+	// Hash and hex-encode through stack buffers; this runs on every
+	// validation, and only the returned string should reach the heap
+	var in [128]byte
+	var sum [sha256.Size]byte
+	if len(raw) <= len(in) {
+		sum = sha256.Sum256(append(in[:0], raw...))
+	} else {
+		sum = sha256.Sum256([]byte(raw))
+	}
+	var out [2 * sha256.Size]byte
+	hex.Encode(out[:], sum[:])
+	return string(out[:])
 }
@@ -1,14 +1,78 @@
 // Package utils Package internal/utils/error.go
 package utils
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTokenInvalid is the category of every token validation failure. The
+// specific failures below also match it with errors.Is.
+var ErrTokenInvalid = errors.New("token is invalid or expired")
+
+// tokenError is a specific validation failure within ErrTokenInvalid
+type tokenError struct{ msg string }
+
+func (e *tokenError) Error() string        { return e.msg }
+func (e *tokenError) Is(target error) bool { return target == ErrTokenInvalid }
+
+var (
+	ErrTokenExpired       = &tokenError{"token expired"}
+	ErrTokenNotFound      = &tokenError{"token not found"}
+	ErrTokenRevoked       = &tokenError{"token revoked"}
+	ErrTokenInvalidFormat = &tokenError{"invalid token format"}
+	ErrTokenSuspended     = &tokenError{"token suspended"}
+	ErrOneTimeToken       = &tokenError{"one-time token used as a bearer token"}
+)
 
 var (
-	ErrTokenExpired            = errors.New("token expired")
-	ErrTokenNotFound           = errors.New("token not found")
-	ErrTokenInvalidFormat      = errors.New("invalid token format")
+	ErrDuplicateToken          = errors.New("token already exists")
+	ErrRefreshTokenReused      = errors.New("refresh token reuse detected")
+	ErrTokenRotated            = errors.New("token has already been rotated")
 	ErrSigningKeyCannotBeEmpty = errors.New("signing key cannot be empty")
 	ErrTokenLengthMustBe       = errors.New("token length must be at least 16 characters")
 	ErrStorageDriverNil        = errors.New("storage driver cannot be nil")
 	ErrDatabaseConnectionNil   = errors.New("database connection cannot be nil")
+	ErrIdentityNotFound        = errors.New("identity not linked")
+	ErrIdentityAlreadyLinked   = errors.New("identity already linked to a user")
+	ErrOrgUnitNotFound         = errors.New("org unit not found")
+	ErrRoleNotFound            = errors.New("role not found")
+	ErrInvalidCondition        = errors.New("invalid ability condition")
+	ErrAbilityDenied           = errors.New("token lacks required ability")
+	ErrMaintenanceMode         = errors.New("service is in maintenance mode")
+	ErrNotSupported            = errors.New("operation not supported by storage driver")
+	ErrMissingIndex            = errors.New("required storage index missing")
+	ErrReadOnlyStorage         = errors.New("storage is read-only")
+	ErrUnindexedHash           = errors.New("token hash can't be indexed in a token set")
+	ErrRateLimited             = errors.New("too many failed attempts")
+	ErrWeakToken               = errors.New("token does not meet strength policy")
+	ErrWorkloadTokenInvalid    = errors.New("invalid workload identity token")
+	ErrWorkloadDenied          = errors.New("workload identity not granted any abilities")
+	ErrSAMLDenied              = errors.New("SAML assertion not granted any abilities")
+	ErrReplicationIncomplete   = errors.New("write not acknowledged by enough replicas")
+	ErrTokenLimitReached       = errors.New("user has reached the maximum number of tokens")
+	ErrValidationTimeout       = errors.New("token validation exceeded its latency budget")
+	ErrCrossRegion             = errors.New("token belongs to a region not served here")
+	ErrExchangeDenied          = errors.New("token exchange would widen the subject token")
+	ErrQuotaExceeded           = errors.New("organization quota exceeded")
+	ErrStepUpRequired          = errors.New("token requires step-up authentication")
+	ErrInvalidCredentials      = errors.New("invalid identifier or password")
+	ErrMFARequired             = errors.New("token awaits a second factor")
+	ErrInvalidMFACode          = errors.New("invalid two-factor code")
 )
+
+// QuotaError is an issuance refused by an org quota. It matches
+// ErrQuotaExceeded.
+type QuotaError struct {
+	OrgID int64
+	Quota string // "active_tokens" or "sessions"
+	Limit int64
+	Used  int64 // Held before the refused issuance
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: org %d holds %d of %d %s", ErrQuotaExceeded, e.OrgID, e.Used, e.Limit, strings.ReplaceAll(e.Quota, "_", " "))
+}
+
+func (e *QuotaError) Is(target error) bool { return target == ErrQuotaExceeded }
@@ -0,0 +1,122 @@
+// Package worker internal/worker/worker.go
+package worker
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// PanicError wraps a value recovered from a panicking background worker.
+type PanicError struct {
+	Worker string
+	Value  any
+	Stack  []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("goauth: background worker %q panicked: %v", e.Worker, e.Value)
+}
+
+// Supervisor runs background work, recovering panics and surfacing failures
+// through a buffered error channel instead of crashing the host process.
+type Supervisor struct {
+	logger utils.Logger
+	errs   chan error
+	wg     sync.WaitGroup
+	done   chan struct{}
+	once   sync.Once
+}
+
+// New creates a supervisor whose error channel holds up to buffer errors;
+// further errors are logged and dropped until the channel is drained.
+func New(logger utils.Logger, buffer int) *Supervisor {
+	if logger == nil {
+		logger = utils.NopLogger{}
+	}
+	return &Supervisor{
+		logger: logger,
+		errs:   make(chan error, buffer),
+		done:   make(chan struct{}),
+	}
+}
+
+// Go runs fn in a new goroutine under panic recovery.
+func (s *Supervisor) Go(name string, fn func() error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.Run(name, fn)
+	}()
+}
+
+// Every runs fn every interval until Stop is called. A failing or panicking
+// iteration is recovered and reported; the loop keeps running.
+func (s *Supervisor) Every(name string, interval time.Duration, fn func() error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.Run(name, fn)
+			}
+		}
+	}()
+}
+
+// Run executes fn synchronously under panic recovery, for use inside
+// long-running loops that must survive a single failed iteration.
+func (s *Supervisor) Run(name string, fn func() error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := &PanicError{Worker: name, Value: r, Stack: debug.Stack()}
+			s.logger.Error("background worker panicked", "worker", name, "panic", r, "stack", string(err.Stack))
+			s.Report(err)
+		}
+	}()
+
+	if err := fn(); err != nil {
+		s.logger.Warn("background worker failed", "worker", name, "error", err)
+		s.Report(fmt.Errorf("%s: %w", name, err))
+	}
+}
+
+// Report publishes err on the error channel without blocking.
+func (s *Supervisor) Report(err error) {
+	select {
+	case s.errs <- err:
+	default:
+	}
+}
+
+// Errors returns the channel background failures are published on.
+func (s *Supervisor) Errors() <-chan error {
+	return s.errs
+}
+
+// Done is closed when Stop is called, for workers that select on shutdown.
+func (s *Supervisor) Done() <-chan struct{} {
+	return s.done
+}
+
+// Wait blocks until all goroutines started with Go have returned.
+func (s *Supervisor) Wait() {
+	s.wg.Wait()
+}
+
+// Stop signals periodic workers to exit and waits for all workers to finish.
+// It is safe to call more than once.
+func (s *Supervisor) Stop() {
+	s.once.Do(func() { close(s.done) })
+	s.wg.Wait()
+}
@@ -0,0 +1,198 @@
+package goauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/jose"
+)
+
+// JWK is a public JSON Web Key served by JWKSHandler
+type JWK = jose.JWK
+
+// JWKS is the JSON Web Key Set served by JWKSHandler
+type JWKS = jose.JWKS
+
+// JWTOptions configures the signed access tokens of WithJWTSigningKey.
+// Zero values select the defaults.
+type JWTOptions struct {
+	Issuer   string        // iss claim (required)
+	Audience string        // aud claim, if set
+	TTL      time.Duration // Longest lifetime of a JWT, capped by the opaque token's expiry. Default 5m
+
+	// Previous lists retired verification keys, of any supported type.
+	// They stay in the JWKS so JWTs signed before a rotation keep
+	// verifying until they expire; drop them once TTL has passed.
+	Previous []crypto.PublicKey
+}
+
+// jwtSigner holds the state behind WithJWTSigningKey
+type jwtSigner struct {
+	opts JWTOptions
+	key  crypto.Signer
+	alg  string
+	kid  string
+	jwks JWKS // Current key first
+}
+
+// WithJWTSigningKey switches the client to JWT mode: IssueJWT mints JWT
+// access tokens signed with current, and JWKSHandler publishes the public
+// keys so downstream services can verify them offline. The key type picks
+// the signing method: RS256 for RSA keys of at least 2048 bits, ES256 for
+// P-256 ECDSA keys and EdDSA for Ed25519 keys. Keys held in a KMS or HSM
+// work too, through their crypto.Signer. Key IDs are the RFC 7638
+// thumbprints of the keys, so every instance given the same rotation
+// config serves the same kids.
+func WithJWTSigningKey(current crypto.Signer, opts JWTOptions) Option {
+	return func(c *Client) error {
+		if current == nil {
+			return fmt.Errorf("JWT signing key cannot be nil")
+		}
+		if opts.Issuer == "" {
+			return fmt.Errorf("JWT issuer cannot be empty")
+		}
+		if opts.TTL < 0 {
+			return fmt.Errorf("JWT TTL cannot be negative")
+		}
+		if opts.TTL == 0 {
+			opts.TTL = 5 * time.Minute
+		}
+
+		s := &jwtSigner{opts: opts, key: current}
+		for i, pub := range append([]crypto.PublicKey{current.Public()}, opts.Previous...) {
+			jwk, err := jose.PublicJWK(pub)
+			switch {
+			case err != nil && i == 0:
+				return fmt.Errorf("JWT signing key: %w", err)
+			case err != nil:
+				return fmt.Errorf("previous JWT key %d: %w", i-1, err)
+			}
+			s.jwks.Keys = append(s.jwks.Keys, jwk)
+		}
+		s.alg, s.kid = s.jwks.Keys[0].Alg, s.jwks.Keys[0].Kid
+
+		c.jwt = s
+		c.config.SigningMethod = s.alg
+		if k, ok := current.(*rsa.PrivateKey); ok {
+			c.config.PrivateKey, c.config.PublicKey = k, &k.PublicKey
+		} else {
+			c.config.Signer = current
+		}
+		return nil
+	}
+}
+
+// WithRS256Keys is WithJWTSigningKey with an RSA key.
+func WithRS256Keys(current *rsa.PrivateKey, opts JWTOptions) Option {
+	if current == nil {
+		return func(c *Client) error { return fmt.Errorf("RSA signing key cannot be nil") }
+	}
+	return WithJWTSigningKey(current, opts)
+}
+
+// ParseSigningKeyPEM loads a JWT signing key from PEM: PKCS #8, PKCS #1 RSA
+// or SEC 1 EC. Keys WithJWTSigningKey can't sign with are rejected.
+func ParseSigningKeyPEM(data []byte) (crypto.Signer, error) {
+	return jose.ParsePrivatePEM(data)
+}
+
+// ParseSigningKeyJWK loads a JWT signing key from a private JWK. Keys
+// WithJWTSigningKey can't sign with, or whose alg doesn't match the key,
+// are rejected.
+func ParseSigningKeyJWK(data []byte) (crypto.Signer, error) {
+	return jose.ParsePrivateJWK(data)
+}
+
+// ParseVerificationKeyPEM loads a public key, e.g. a retired key for
+// JWTOptions.Previous, from a PKIX, PKCS #1 or certificate PEM block.
+func ParseVerificationKeyPEM(data []byte) (crypto.PublicKey, error) {
+	return jose.ParsePublicPEM(data)
+}
+
+// ParseVerificationKeyJWK loads a public key from a JWK.
+func ParseVerificationKeyJWK(data []byte) (crypto.PublicKey, error) {
+	return jose.ParsePublicJWK(data)
+}
+
+// IssueJWT validates the opaque token raw and returns a JWT access token
+// (RFC 9068) for it, signed with the current key. The JWT carries the
+// user ID as sub, the token ID as jti, the abilities as scope and any
+// certificate binding as cnf (RFC 8705). It expires after JWTOptions.TTL
+// or with raw, whichever is sooner; services that verify it offline won't
+// see a revocation of raw before then.
+func (c *Client) IssueJWT(ctx context.Context, raw string) (string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if c.jwt == nil {
+		return "", fmt.Errorf("no JWT signing keys configured")
+	}
+
+	tok, err := c.ValidateToken(ctx, raw)
+	if err != nil {
+		return "", err
+	}
+
+	now := c.config.Now()
+	exp := now.Add(c.jwt.opts.TTL)
+	if tok.ExpiresAt != nil && tok.ExpiresAt.Before(exp) {
+		exp = *tok.ExpiresAt
+	}
+	claims := map[string]any{
+		"iss": c.jwt.opts.Issuer,
+		"sub": strconv.FormatInt(tok.UserId, 10),
+		"jti": strconv.FormatInt(tok.ID, 10),
+		"iat": now.Unix(),
+		"exp": exp.Unix(),
+	}
+	if c.jwt.opts.Audience != "" {
+		claims["aud"] = c.jwt.opts.Audience
+	}
+	if abilities := tok.AbilityList(); len(abilities) > 0 {
+		claims["scope"] = strings.Join(abilities, " ")
+	}
+	if cert := tok.Metadata[auth.MetaBoundCert]; cert != "" {
+		claims["cnf"] = map[string]string{"x5t#S256": cert}
+	}
+	return jose.Sign(c.jwt.key, jose.Header{Alg: c.jwt.alg, Kid: c.jwt.kid, Typ: "at+jwt"}, claims)
+}
+
+// JWKS returns the public keys of WithJWTSigningKey, current key first. ok
+// is false when the client isn't in JWT mode.
+func (c *Client) JWKS() (jwks JWKS, ok bool) {
+	if c.jwt == nil {
+		return JWKS{}, false
+	}
+	return JWKS{Keys: append([]JWK(nil), c.jwt.jwks.Keys...)}, true
+}
+
+// JWKSHandler serves the JWKS document, typically at
+// /.well-known/jwks.json. It answers 404 when the client isn't in JWT
+// mode. Responses may be cached for five minutes; verifiers that refetch
+// on an unknown kid pick up a rotated key sooner.
+func (c *Client) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		jwks, ok := c.JWKS()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_ = json.NewEncoder(w).Encode(jwks)
+	})
+}
@@ -0,0 +1,63 @@
+package goauth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/storage"
+)
+
+// LastUsedMode controls how validation records a token's LastUsedAt
+type LastUsedMode = config.LastUsedMode
+
+const (
+	LastUsedAsync   = config.LastUsedAsync
+	LastUsedBatched = config.LastUsedBatched
+	LastUsedSync    = config.LastUsedSync
+	LastUsedOff     = config.LastUsedOff
+)
+
+// WithLastUsedTracking sets how ValidateToken records LastUsedAt. The
+// default, LastUsedAsync, writes in the background after every validation.
+// LastUsedBatched writes each validated token once per flush interval
+// (flushEvery, default 5s), LastUsedSync writes before returning, and
+// LastUsedOff skips the write. Close waits for pending writes.
+func WithLastUsedTracking(mode LastUsedMode, flushEvery ...time.Duration) Option {
+	return func(c *Client) error {
+		switch mode {
+		case LastUsedAsync, LastUsedBatched, LastUsedSync, LastUsedOff:
+		default:
+			return fmt.Errorf("unknown last-used mode %d", mode)
+		}
+		c.config.LastUsed = mode
+		c.config.LastUsedFlush = 5 * time.Second
+		if len(flushEvery) > 0 {
+			if flushEvery[0] <= 0 {
+				return fmt.Errorf("last-used flush interval must be positive")
+			}
+			c.config.LastUsedFlush = flushEvery[0]
+		}
+		c.config.Touches = nil
+		if mode == LastUsedBatched {
+			c.config.Touches = storage.NewTouchBatcher()
+		}
+		return nil
+	}
+}
+
+func (c *Client) startLastUsed() {
+	if c.config.LastUsed != LastUsedBatched {
+		return
+	}
+
+	c.config.Workers.Every("flush-last-used", c.config.LastUsedFlush, c.config.Touches.Flush)
+}
+
+// flushLastUsed writes batched last-used updates still pending at Close
+func (c *Client) flushLastUsed() error {
+	if c.config.Touches == nil {
+		return nil
+	}
+	return c.config.Touches.Flush()
+}
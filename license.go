@@ -0,0 +1,98 @@
+package goauth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/license"
+)
+
+// WithLicensePublicKey sets the Ed25519 public key used to verify license
+// keys issued with license.Issue
+func WithLicensePublicKey(pub ed25519.PublicKey) Option {
+	return func(c *Client) error {
+		if len(pub) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid Ed25519 public key")
+		}
+		c.licenseKey = pub
+		return nil
+	}
+}
+
+// RegisterLicense verifies a license key and records it in storage so it
+// can be revoked later. Registration is optional: unregistered keys still
+// verify offline.
+func (c *Client) RegisterLicense(ctx context.Context, key string) (*license.License, error) {
+	lic, err := c.verifyLicense(key)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("license %016x", lic.Serial)
+	tok := &entity.PersonalAccessToken{
+		UserId:    lic.Licensee,
+		Name:      &name,
+		Token:     utils.HashToken(license.Normalize(key)),
+		Kind:      entity.KindLicense,
+		CreatedAt: c.config.Now(),
+	}
+	if !lic.ExpiresAt.IsZero() {
+		tok.ExpiresAt = &lic.ExpiresAt
+	}
+
+	err = tracedErr(c, ctx, "RegisterLicense", func(cfg *config.Config) error {
+		return cfg.Storage.StoreToken(tok)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lic, nil
+}
+
+// VerifyLicense checks a license key's signature and expiry and, if it
+// was registered, that it hasn't been revoked
+func (c *Client) VerifyLicense(ctx context.Context, key string) (*license.License, error) {
+	lic, err := c.verifyLicense(key)
+	if err != nil {
+		return nil, err
+	}
+	if lic.Expired(c.config.Now()) {
+		return nil, license.ErrExpired
+	}
+
+	_, err = traced(c, ctx, "VerifyLicense", func(cfg *config.Config) (*entity.PersonalAccessToken, error) {
+		tok, err := cfg.Storage.FindByHash(utils.HashToken(license.Normalize(key)))
+		switch {
+		case errors.Is(err, utils.ErrTokenNotFound):
+			return nil, nil
+		case err != nil:
+			return nil, err
+		case tok.RevokedAt != nil:
+			return nil, utils.ErrTokenRevoked
+		}
+		return tok, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lic, nil
+}
+
+// RevokeLicense marks a registered license key as revoked
+func (c *Client) RevokeLicense(ctx context.Context, key string) error {
+	return tracedErr(c, ctx, "RevokeLicense", func(cfg *config.Config) error {
+		return cfg.Storage.MarkRevoked(utils.HashToken(license.Normalize(key)), cfg.Now())
+	})
+}
+
+func (c *Client) verifyLicense(key string) (*license.License, error) {
+	if c.licenseKey == nil {
+		return nil, fmt.Errorf("no license public key configured (call WithLicensePublicKey)")
+	}
+	return license.Verify(c.licenseKey, key)
+}
@@ -0,0 +1,239 @@
+// Package license issues and verifies signed, human-typeable license keys
+// for desktop and embedded software. Keys are verified offline with the
+// issuer's Ed25519 public key; goauth can additionally register them in
+// storage so individual keys can be revoked.
+//
+// A key encodes a 20 byte payload (version, product, expiry, serial,
+// licensee) followed by its 64 byte signature in Crockford base32, split
+// into dash-separated groups of five characters plus one check character.
+// The check character catches typos before any signature work is done and
+// tells the user which group to fix.
+package license
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	version     = 1
+	payloadSize = 20
+	keySize     = payloadSize + ed25519.SignatureSize
+	groupSize   = 5
+	alphabet    = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	secondsDay  = 24 * 60 * 60
+)
+
+var (
+	ErrMalformed = errors.New("malformed license key")
+	ErrChecksum  = errors.New("license key checksum mismatch")
+	ErrSignature = errors.New("license key signature invalid")
+	ErrExpired   = errors.New("license expired")
+)
+
+// License is the information carried by a key.
+type License struct {
+	Serial    uint64    // Unique per key; random when zero at issuance
+	Licensee  int64     // Licensed user or account ID
+	Product   uint8     // Application-defined product or edition code
+	ExpiresAt time.Time // Zero for perpetual licenses; day precision
+}
+
+// Expired reports whether the license has expired at now.
+func (l *License) Expired(now time.Time) bool {
+	return !l.ExpiresAt.IsZero() && !now.Before(l.ExpiresAt)
+}
+
+// Issue signs l with priv and returns the formatted key.
+func Issue(priv ed25519.PrivateKey, l License) (string, error) {
+	if len(priv) != ed25519.PrivateKeySize {
+		return "", errors.New("invalid Ed25519 private key")
+	}
+	if l.Serial == 0 {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return "", fmt.Errorf("generate serial: %w", err)
+		}
+		l.Serial = binary.BigEndian.Uint64(b[:])
+	}
+
+	var days uint16
+	if !l.ExpiresAt.IsZero() {
+		d := (l.ExpiresAt.Unix() + secondsDay - 1) / secondsDay
+		if d <= 0 || d > 0xFFFF {
+			return "", fmt.Errorf("expiry %s out of range", l.ExpiresAt)
+		}
+		days = uint16(d)
+	}
+
+	payload := make([]byte, payloadSize, keySize)
+	payload[0] = version
+	payload[1] = l.Product
+	binary.BigEndian.PutUint16(payload[2:], days)
+	binary.BigEndian.PutUint64(payload[4:], l.Serial)
+	binary.BigEndian.PutUint64(payload[12:], uint64(l.Licensee))
+
+	return format(append(payload, ed25519.Sign(priv, payload)...)), nil
+}
+
+// Verify checks key's group checksums and signature against pub and
+// returns the license it carries. Expiry is not enforced; see Expired.
+func Verify(pub ed25519.PublicKey, key string) (*License, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+
+	raw, err := parse(key)
+	if err != nil {
+		return nil, err
+	}
+	payload, sig := raw[:payloadSize], raw[payloadSize:]
+	if payload[0] != version {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrMalformed, payload[0])
+	}
+	if !ed25519.Verify(pub, payload, sig) {
+		return nil, ErrSignature
+	}
+
+	l := &License{
+		Product:  payload[1],
+		Serial:   binary.BigEndian.Uint64(payload[4:]),
+		Licensee: int64(binary.BigEndian.Uint64(payload[12:])),
+	}
+	if days := binary.BigEndian.Uint16(payload[2:]); days != 0 {
+		l.ExpiresAt = time.Unix(int64(days)*secondsDay, 0).UTC()
+	}
+	return l, nil
+}
+
+// Normalize returns the canonical form of a key as typed by a user:
+// upper case, dashes restored, and the easily confused letters O, I and L
+// read as digits.
+func Normalize(key string) string {
+	raw, err := parse(key)
+	if err != nil {
+		return strings.ToUpper(strings.TrimSpace(key))
+	}
+	return format(raw)
+}
+
+// format encodes raw in base32 groups, each followed by its check character
+func format(raw []byte) string {
+	digits := toBase32(raw)
+
+	var b strings.Builder
+	for i := 0; i < len(digits); i += groupSize {
+		if i > 0 {
+			b.WriteByte('-')
+		}
+		group := digits[i:min(i+groupSize, len(digits))]
+		for _, d := range group {
+			b.WriteByte(alphabet[d])
+		}
+		b.WriteByte(alphabet[checkDigit(group)])
+	}
+	return b.String()
+}
+
+// parse decodes a formatted key, verifying every group's check character
+func parse(key string) ([]byte, error) {
+	var digits []byte
+	groups := strings.FieldsFunc(strings.TrimSpace(key), func(r rune) bool { return r == '-' || r == ' ' })
+	for i, g := range groups {
+		if len(g) < 2 || len(g) > groupSize+1 {
+			return nil, ErrMalformed
+		}
+		vals := make([]byte, len(g))
+		for j := 0; j < len(g); j++ {
+			v, ok := decodeChar(g[j])
+			if !ok {
+				return nil, fmt.Errorf("%w: invalid character %q", ErrMalformed, g[j])
+			}
+			vals[j] = v
+		}
+		data, check := vals[:len(vals)-1], vals[len(vals)-1]
+		if checkDigit(data) != check {
+			return nil, fmt.Errorf("%w in group %d", ErrChecksum, i+1)
+		}
+		digits = append(digits, data...)
+	}
+
+	raw, ok := fromBase32(digits, keySize)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	return raw, nil
+}
+
+// checkDigit is a position-weighted sum mod 31, so almost every single
+// substitution or adjacent transposition within a group is detected
+func checkDigit(group []byte) byte {
+	sum := 0
+	for i, d := range group {
+		sum += (i + 1) * (int(d) + 1)
+	}
+	return byte(sum % 31)
+}
+
+func decodeChar(c byte) (byte, bool) {
+	switch c {
+	case 'O', 'o':
+		c = '0'
+	case 'I', 'i', 'L', 'l':
+		c = '1'
+	}
+	if 'a' <= c && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	if i := strings.IndexByte(alphabet, c); i >= 0 {
+		return byte(i), true
+	}
+	return 0, false
+}
+
+// toBase32 splits raw into 5-bit digits, most significant bit first
+func toBase32(raw []byte) []byte {
+	out := make([]byte, 0, (len(raw)*8+4)/5)
+	var acc uint16
+	bits := 0
+	for _, b := range raw {
+		acc = acc<<8 | uint16(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out = append(out, byte(acc>>bits)&31)
+		}
+	}
+	if bits > 0 {
+		out = append(out, byte(acc<<(5-bits))&31)
+	}
+	return out
+}
+
+// fromBase32 reverses toBase32 for an input of exactly n bytes
+func fromBase32(digits []byte, n int) ([]byte, bool) {
+	if len(digits) != (n*8+4)/5 {
+		return nil, false
+	}
+	out := make([]byte, 0, n)
+	var acc uint16
+	bits := 0
+	for _, d := range digits {
+		acc = acc<<5 | uint16(d)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			out = append(out, byte(acc>>bits))
+		}
+	}
+	// Padding bits must be zero for the encoding to be canonical
+	if acc&(1<<bits-1) != 0 {
+		return nil, false
+	}
+	return out, true
+}
@@ -0,0 +1,202 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/metering"
+)
+
+// meteringCheck is how often finished hours are looked for
+const meteringCheck = time.Minute
+
+// WithUsageMetering reports per-org usage to sink once per hour: the
+// successful validations of the org members' tokens and the org's live
+// access tokens and sessions (see the metering package). Validations are
+// counted in memory per user and attributed to the user's orgs when the
+// hour is written, so validation pays only for an increment. Users in no
+// org aren't metered. Close writes the current hour as a partial record.
+func WithUsageMetering(sink metering.Sink) Option {
+	return func(c *Client) error {
+		if sink == nil {
+			return fmt.Errorf("metering sink cannot be nil")
+		}
+		if c.meter == nil {
+			c.meter = &meter{}
+		}
+		c.meter.sink = sink
+		return nil
+	}
+}
+
+// WithMeteredOrgs adds the orgs listed by f to every hour's records, so
+// orgs holding tokens but making no validations are still reported
+func WithMeteredOrgs(f func() ([]int64, error)) Option {
+	return func(c *Client) error {
+		if f == nil {
+			return fmt.Errorf("metered orgs function cannot be nil")
+		}
+		if c.meter == nil {
+			c.meter = &meter{}
+		}
+		c.meter.orgs = f
+		return nil
+	}
+}
+
+type meter struct {
+	sink metering.Sink
+	orgs func() ([]int64, error)
+
+	mu    sync.Mutex
+	hours map[time.Time]map[int64]int64 // Validations per user per hour
+	next  time.Time                     // First hour not yet taken, with metered orgs
+}
+
+// count records a successful validation by a user's token at now
+func (m *meter) count(now time.Time, userID int64) {
+	if userID <= 0 {
+		return
+	}
+	hour := now.UTC().Truncate(time.Hour)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hours == nil {
+		m.hours = make(map[time.Time]map[int64]int64)
+	}
+	users := m.hours[hour]
+	if users == nil {
+		users = make(map[int64]int64)
+		m.hours[hour] = users
+	}
+	users[userID]++
+}
+
+// take removes the counts of hours finished by now, or of all hours when
+// all is set, and returns them by hour
+func (m *meter) take(now time.Time, all bool) map[time.Time]map[int64]int64 {
+	current := now.UTC().Truncate(time.Hour)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.orgs != nil {
+		// Hours without validations still report the metered orgs
+		end := current
+		if all {
+			end = current.Add(time.Hour)
+		}
+		if m.hours == nil {
+			m.hours = make(map[time.Time]map[int64]int64)
+		}
+		for h := m.next; h.Before(end); h = h.Add(time.Hour) {
+			if m.hours[h] == nil {
+				m.hours[h] = make(map[int64]int64)
+			}
+		}
+		m.next = end
+	}
+
+	taken := make(map[time.Time]map[int64]int64)
+	for hour, users := range m.hours {
+		if all || hour.Before(current) {
+			taken[hour] = users
+			delete(m.hours, hour)
+		}
+	}
+	return taken
+}
+
+func (c *Client) startMetering() error {
+	if c.meter == nil {
+		return nil
+	}
+	if c.meter.sink == nil {
+		return fmt.Errorf("metered orgs need WithUsageMetering")
+	}
+	c.meter.next = c.config.Now().UTC().Truncate(time.Hour)
+	c.config.Workers.Every("usage-metering", meteringCheck, func() error {
+		return c.flushUsage(false)
+	})
+	return nil
+}
+
+// flushUsage writes the records of finished hours, and of the current
+// hour when final is set
+func (c *Client) flushUsage(final bool) error {
+	if c.meter == nil {
+		return nil
+	}
+	now := c.config.Now()
+	hours := c.meter.take(now, final)
+	if len(hours) == 0 {
+		return nil
+	}
+
+	store, ok := c.storage.(storage.OrgStore)
+	if !ok {
+		return fmt.Errorf("usage metering: %w", utils.ErrNotSupported)
+	}
+	var extra []int64
+	if c.meter.orgs != nil {
+		orgs, err := c.meter.orgs()
+		if err != nil {
+			return fmt.Errorf("usage metering: %w", err)
+		}
+		extra = orgs
+	}
+
+	var records []metering.Record
+	current := now.UTC().Truncate(time.Hour)
+	for hour, users := range hours {
+		byOrg := make(map[int64]int64)
+		for user, n := range users {
+			orgs, err := auth.UserOrgs(store, user)
+			if err != nil {
+				return fmt.Errorf("usage metering: %w", err)
+			}
+			for _, org := range orgs {
+				byOrg[org] += n
+			}
+		}
+		for _, org := range extra {
+			if _, ok := byOrg[org]; !ok {
+				byOrg[org] = 0
+			}
+		}
+
+		for org, n := range byOrg {
+			r := metering.Record{OrgID: org, Hour: hour, Validations: n, Partial: !hour.Before(current)}
+			if counter, ok := c.storage.(storage.OrgCounter); ok {
+				var err error
+				if r.ActiveTokens, err = counter.CountByOrg(org, entity.KindAccess); err != nil {
+					return fmt.Errorf("usage metering: %w", err)
+				}
+				if r.Sessions, err = counter.CountByOrg(org, entity.KindRefresh); err != nil {
+					return fmt.Errorf("usage metering: %w", err)
+				}
+			}
+			records = append(records, r)
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].Hour.Equal(records[j].Hour) {
+			return records[i].Hour.Before(records[j].Hour)
+		}
+		return records[i].OrgID < records[j].OrgID
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return c.meter.sink.Write(ctx, records)
+}
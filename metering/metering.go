@@ -0,0 +1,139 @@
+// Package metering exports per-organization usage measured by goauth, for
+// billing API usage at the auth layer. A client set up with
+// goauth.WithUsageMetering aggregates usage per org and hour and hands
+// the records to a Sink:
+//
+//	sink := metering.NewFileSink("/var/log/goauth/usage.jsonl")
+//	client, _ := goauth.NewClient(..., goauth.WithUsageMetering(sink))
+//
+// Each running client writes its own records. Validations from several
+// instances for the same org and hour add up; ActiveTokens and Sessions
+// are read from shared storage, so take the largest value instead.
+package metering
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Record is an org's usage during one hour.
+type Record struct {
+	OrgID        int64     `json:"org_id"`
+	Hour         time.Time `json:"hour"`              // Start of the hour, UTC
+	Validations  int64     `json:"validations"`       // Successful validations by members' tokens
+	ActiveTokens int64     `json:"active_tokens"`     // Live access tokens when the record was written
+	Sessions     int64     `json:"sessions"`          // Live refresh tokens when the record was written
+	Partial      bool      `json:"partial,omitempty"` // Written before the hour ended, e.g. at shutdown
+}
+
+// Sink receives usage records. Write is called from a background worker
+// once per hour with the records of the hour that ended; an error is
+// reported through the client's Errors channel and the records are
+// dropped.
+type Sink interface {
+	Write(ctx context.Context, records []Record) error
+}
+
+// SinkFunc adapts a function into a Sink.
+type SinkFunc func(ctx context.Context, records []Record) error
+
+func (f SinkFunc) Write(ctx context.Context, records []Record) error { return f(ctx, records) }
+
+// NewFileSink appends records to the file at path as JSON lines, creating
+// it if needed.
+func NewFileSink(path string) Sink {
+	return &fileSink{path: path}
+}
+
+type fileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (s *fileSink) Write(_ context.Context, records []Record) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// NewHTTPSink POSTs each batch of records as a JSON array to url. A nil
+// client uses http.DefaultClient. Responses other than 2xx are errors.
+func NewHTTPSink(url string, client *http.Client) Sink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpSink{url: url, client: client}
+}
+
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) Write(ctx context.Context, records []Record) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("metering: %s answered %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// NewPublisherSink sends each record as its own JSON message through
+// publish, keyed by org ID so a partitioned log such as Kafka keeps an
+// org's records in order. It adapts any producer without goauth depending
+// on its client library, e.g. for kafka-go:
+//
+//	metering.NewPublisherSink(func(ctx context.Context, key, value []byte) error {
+//		return writer.WriteMessages(ctx, kafka.Message{Key: key, Value: value})
+//	})
+func NewPublisherSink(publish func(ctx context.Context, key, value []byte) error) Sink {
+	return SinkFunc(func(ctx context.Context, records []Record) error {
+		for _, r := range records {
+			value, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err := publish(ctx, []byte(strconv.FormatInt(r.OrgID, 10)), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,316 @@
+package goauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/mfa"
+	"github.com/mohar9h/goauth/siem"
+)
+
+var (
+	// ErrMFARequired is returned when validating a token Login issued
+	// before the user's second factor was checked; see CompleteMFA
+	ErrMFARequired = utils.ErrMFARequired
+	// ErrInvalidMFACode is returned for a wrong, expired or reused code
+	ErrInvalidMFACode = utils.ErrInvalidMFACode
+)
+
+// MetaMFAPending marks tokens awaiting the second factor. The value names
+// the factor, "totp".
+const MetaMFAPending = auth.MetaMFAPending
+
+// metaMFAAttempts counts the codes tried with a pending token
+const metaMFAAttempts = "goauth.mfa_attempts"
+
+// MFAOptions tunes two-factor login. Zero values select the defaults.
+type MFAOptions struct {
+	Issuer      string        // Service name shown by authenticator apps. Required
+	Skew        int           // Time steps accepted either side of the current one. Default 1
+	PendingTTL  time.Duration // Lifetime of the pending token Login issues. Default 5m
+	MaxAttempts int           // Codes tried with a pending token before it is revoked. Default 3
+}
+
+func (o MFAOptions) withDefaults() MFAOptions {
+	if o.Skew == 0 {
+		o.Skew = 1
+	}
+	if o.PendingTTL <= 0 {
+		o.PendingTTL = 5 * time.Minute
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 3
+	}
+	return o
+}
+
+// mfaOptions holds the store configured by WithMFA
+type mfaOptions struct {
+	store mfa.Store
+	opts  MFAOptions
+}
+
+// WithMFA enables TOTP two-factor authentication with enrollments kept in
+// store. Login then issues users with a confirmed enrollment a pending
+// token, which ValidateToken rejects with ErrMFARequired until
+// CompleteMFA exchanges it for a session.
+func WithMFA(store mfa.Store, opts MFAOptions) Option {
+	return func(c *Client) error {
+		if store == nil {
+			return fmt.Errorf("mfa store cannot be nil")
+		}
+		if opts.Issuer == "" {
+			return fmt.Errorf("mfa issuer cannot be empty")
+		}
+		if opts.Skew < 0 {
+			return fmt.Errorf("mfa skew cannot be negative")
+		}
+		c.mfa = &mfaOptions{store: store, opts: opts.withDefaults()}
+		return nil
+	}
+}
+
+// EnrollTOTP generates a TOTP secret for the user and returns it for
+// display, usually as a QR code of Key.URI. The enrollment guards logins
+// once ConfirmTOTP accepts a code from it. Enrolling again before then
+// replaces the secret; a confirmed enrollment must be removed with
+// DisableTOTP first.
+func (c *Client) EnrollTOTP(ctx context.Context, userID int64, account string) (*mfa.Key, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if c.mfa == nil {
+		return nil, fmt.Errorf("no mfa store configured")
+	}
+	if userID <= 0 {
+		return nil, fmt.Errorf("user ID must be positive")
+	}
+	old, err := c.mfa.store.FindEnrollment(ctx, userID)
+	if err != nil && !errors.Is(err, mfa.ErrNotFound) {
+		return nil, fmt.Errorf("failed to find enrollment: %w", err)
+	}
+	if old != nil && old.Confirmed() {
+		return nil, fmt.Errorf("user already has a confirmed TOTP enrollment")
+	}
+
+	key, err := mfa.NewKey(c.mfa.opts.Issuer, account, c.config.Rand)
+	if err != nil {
+		return nil, err
+	}
+	e := &mfa.Enrollment{UserID: userID, Secret: key.Secret, CreatedAt: c.config.Now(), UpdatedAt: c.config.Now()}
+	if err := c.mfa.store.SaveEnrollment(ctx, e); err != nil {
+		return nil, fmt.Errorf("failed to save enrollment: %w", err)
+	}
+	return key, nil
+}
+
+// ConfirmTOTP activates the user's enrollment with a code from their app.
+func (c *Client) ConfirmTOTP(ctx context.Context, userID int64, code string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	e, err := c.enrollment(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if e.Confirmed() {
+		return nil
+	}
+	if err := c.checkTOTP(ctx, e, code); err != nil {
+		return err
+	}
+	now := c.config.Now()
+	e.ConfirmedAt, e.UpdatedAt = &now, now
+	if err := c.mfa.store.SaveEnrollment(ctx, e); err != nil {
+		return fmt.Errorf("failed to save enrollment: %w", err)
+	}
+	return nil
+}
+
+// VerifyTOTP checks a code from the user's confirmed enrollment. Each code
+// is accepted once. Under WithRateLimiter, failures drain the user's
+// budget.
+func (c *Client) VerifyTOTP(ctx context.Context, userID int64, code string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	e, err := c.enrollment(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !e.Confirmed() {
+		return fmt.Errorf("TOTP enrollment not confirmed")
+	}
+	return c.checkTOTP(ctx, e, code)
+}
+
+// MFAEnrolled reports whether the user has a confirmed enrollment, so
+// their logins need a second factor.
+func (c *Client) MFAEnrolled(ctx context.Context, userID int64) (bool, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if c.mfa == nil {
+		return false, nil
+	}
+	e, err := c.mfa.store.FindEnrollment(ctx, userID)
+	if errors.Is(err, mfa.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to find enrollment: %w", err)
+	}
+	return e.Confirmed(), nil
+}
+
+// DisableTOTP removes the user's enrollment.
+func (c *Client) DisableTOTP(ctx context.Context, userID int64) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if c.mfa == nil {
+		return fmt.Errorf("no mfa store configured")
+	}
+	if err := c.mfa.store.DeleteEnrollment(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete enrollment: %w", err)
+	}
+	return nil
+}
+
+// BeginMFA issues the user a pending token, as Login does for enrolled
+// users, for applications that check the first factor themselves.
+func (c *Client) BeginMFA(ctx context.Context, userID int64) (*TokenResult, error) {
+	if c.mfa == nil {
+		return nil, fmt.Errorf("no mfa store configured")
+	}
+	res, err := c.IssueToken(ctx, &TokenOptions{
+		UserId:    userID,
+		Metadata:  map[string]string{MetaMFAPending: "totp"},
+		ExpiresIn: c.mfa.opts.PendingTTL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.MFARequired = true
+	return res, nil
+}
+
+// CompleteMFA checks the user's code and exchanges the pending token from
+// Login or BeginMFA for a token made from PasswordOptions.Token, revoking
+// the pending one. A pending token is revoked after MFAOptions.MaxAttempts
+// wrong codes.
+func (c *Client) CompleteMFA(ctx context.Context, pending, code string) (*TokenResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if c.mfa == nil {
+		return nil, fmt.Errorf("no mfa store configured")
+	}
+	tok, err := traced(c, ctx, "ValidatePendingMFA", func(cfg *config.Config) (*entity.PersonalAccessToken, error) {
+		return auth.ValidatePendingMFA(pending, cfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.VerifyTOTP(ctx, tok.UserId, code); err != nil {
+		if errors.Is(err, utils.ErrInvalidMFACode) {
+			c.countMFAAttempt(ctx, tok)
+		}
+		return nil, err
+	}
+	if err := c.RevokeTokenByID(ctx, tok.UserId, tok.ID); err != nil {
+		return nil, err
+	}
+
+	var opts TokenOptions
+	if c.passwords != nil {
+		opts = c.passwords.opts.Token
+	}
+	opts.UserId = tok.UserId
+	res, err := c.IssueToken(ctx, &opts)
+	if err != nil {
+		return nil, err
+	}
+	c.audit(ctx, siem.Event{Type: siem.LoginSucceeded, UserID: tok.UserId})
+	return res, nil
+}
+
+// countMFAAttempt records a wrong code against a pending token, revoking
+// it once it has used up its attempts
+func (c *Client) countMFAAttempt(ctx context.Context, tok *entity.PersonalAccessToken) {
+	n, _ := strconv.Atoi(tok.Metadata[metaMFAAttempts])
+	n++
+	var err error
+	if n >= c.mfa.opts.MaxAttempts {
+		err = c.RevokeTokenByID(ctx, tok.UserId, tok.ID)
+	} else {
+		err = c.setHold(ctx, tok.ID, metaMFAAttempts, strconv.Itoa(n))
+	}
+	if err != nil {
+		c.config.Logger.Warn("failed to record mfa attempt", "token_id", tok.ID, "error", err)
+	}
+}
+
+func (c *Client) enrollment(ctx context.Context, userID int64) (*mfa.Enrollment, error) {
+	if c.mfa == nil {
+		return nil, fmt.Errorf("no mfa store configured")
+	}
+	e, err := c.mfa.store.FindEnrollment(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find enrollment: %w", err)
+	}
+	return e, nil
+}
+
+// checkTOTP validates code against e, refusing replays, with rate
+// limiting and auditing
+func (c *Client) checkTOTP(ctx context.Context, e *mfa.Enrollment, code string) error {
+	var keys []string
+	if c.limiter != nil {
+		keys = []string{"mfa:" + strconv.FormatInt(e.UserID, 10)}
+		if err := c.checkRateLimit(ctx, keys); err != nil {
+			c.audit(ctx, siem.Event{Type: siem.MFAFailed, UserID: e.UserID, Outcome: siem.Failure, Reason: err.Error()})
+			return err
+		}
+	}
+
+	step, ok := mfa.Validate(e.Secret, code, c.config.Now(), c.mfa.opts.Skew)
+	if ok && step > e.LastStep {
+		advanced, err := c.mfa.store.AdvanceStep(ctx, e.UserID, step)
+		if err != nil {
+			return fmt.Errorf("failed to record TOTP step: %w", err)
+		}
+		ok = advanced
+	} else {
+		ok = false
+	}
+	if !ok {
+		c.drainRateLimit(ctx, keys)
+		c.audit(ctx, siem.Event{Type: siem.MFAFailed, UserID: e.UserID, Outcome: siem.Failure, Reason: utils.ErrInvalidMFACode.Error()})
+		return utils.ErrInvalidMFACode
+	}
+	e.LastStep = step
+	c.audit(ctx, siem.Event{Type: siem.MFAVerified, UserID: e.UserID})
+	return nil
+}
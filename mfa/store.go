@@ -0,0 +1,160 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotFound is returned by a Store for users without an enrollment.
+var ErrNotFound = errors.New("mfa enrollment not found")
+
+// Enrollment is a user's TOTP secret. It only guards logins once
+// confirmed with a code, which proves the user's app holds the secret.
+type Enrollment struct {
+	ID          int64  `gorm:"primaryKey;autoIncrement"`
+	UserID      int64  `gorm:"uniqueIndex;not null"`
+	Secret      string `gorm:"size:64;not null"`
+	ConfirmedAt *time.Time
+	LastStep    int64 // Time step of the last accepted code, refused from then on
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TableName keeps enrollments apart from goauth's token tables.
+func (Enrollment) TableName() string {
+	return "mfa_enrollments"
+}
+
+// Confirmed reports whether the enrollment guards logins.
+func (e *Enrollment) Confirmed() bool {
+	return e.ConfirmedAt != nil
+}
+
+// Store persists enrollments, one per user.
+type Store interface {
+	FindEnrollment(ctx context.Context, userID int64) (*Enrollment, error)
+	// SaveEnrollment inserts e, or replaces the user's enrollment
+	SaveEnrollment(ctx context.Context, e *Enrollment) error
+	DeleteEnrollment(ctx context.Context, userID int64) error
+	// AdvanceStep records step as the user's last accepted one, and
+	// reports false without changing anything when it isn't later than
+	// the recorded one, so two requests can't both use a code
+	AdvanceStep(ctx context.Context, userID, step int64) (bool, error)
+}
+
+// MemoryStore keeps enrollments in memory (for testing).
+type MemoryStore struct {
+	mu     sync.Mutex
+	byUser map[int64]Enrollment
+	nextID int64
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{byUser: make(map[int64]Enrollment)}
+}
+
+func (s *MemoryStore) FindEnrollment(_ context.Context, userID int64) (*Enrollment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.byUser[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &e, nil
+}
+
+func (s *MemoryStore) SaveEnrollment(_ context.Context, e *Enrollment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.byUser[e.UserID]; ok {
+		e.ID, e.CreatedAt = old.ID, old.CreatedAt
+	} else {
+		s.nextID++
+		e.ID = s.nextID
+	}
+	s.byUser[e.UserID] = *e
+	return nil
+}
+
+func (s *MemoryStore) DeleteEnrollment(_ context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.byUser, userID)
+	return nil
+}
+
+func (s *MemoryStore) AdvanceStep(_ context.Context, userID, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.byUser[userID]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if step <= e.LastStep {
+		return false, nil
+	}
+	e.LastStep = step
+	s.byUser[userID] = e
+	return true, nil
+}
+
+// GormStore keeps enrollments in the mfa_enrollments table
+// (db.AutoMigrate(&mfa.Enrollment{})).
+type GormStore struct {
+	db *gorm.DB
+}
+
+var _ Store = (*GormStore)(nil)
+
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+func (s *GormStore) FindEnrollment(ctx context.Context, userID int64) (*Enrollment, error) {
+	var e Enrollment
+	err := s.db.WithContext(ctx).First(&e, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (s *GormStore) SaveEnrollment(ctx context.Context, e *Enrollment) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var old Enrollment
+		err := tx.First(&old, "user_id = ?", e.UserID).Error
+		switch {
+		case err == nil:
+			e.ID, e.CreatedAt = old.ID, old.CreatedAt
+			return tx.Save(e).Error
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return tx.Create(e).Error
+		default:
+			return err
+		}
+	})
+}
+
+func (s *GormStore) DeleteEnrollment(ctx context.Context, userID int64) error {
+	return s.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&Enrollment{}).Error
+}
+
+func (s *GormStore) AdvanceStep(ctx context.Context, userID, step int64) (bool, error) {
+	res := s.db.WithContext(ctx).Model(&Enrollment{}).
+		Where("user_id = ? AND last_step < ?", userID, step).
+		Update("last_step", step)
+	return res.RowsAffected > 0, res.Error
+}
@@ -0,0 +1,131 @@
+// Package mfa implements time-based one-time passwords (TOTP, RFC 6238)
+// for goauth's two-factor login (see goauth.WithMFA). Codes are the
+// 6-digit, 30-second, HMAC-SHA1 variant every authenticator app supports.
+//
+// A Key is shown to the user once at enrollment, usually as a QR code of
+// its URI:
+//
+//	otpauth://totp/Acme:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Acme&algorithm=SHA1&digits=6&period=30
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits     = 6                // Length of a code
+	Period     = 30 * time.Second // How long a code is valid
+	SecretSize = 20               // Bytes of a generated secret, the length of an SHA-1 digest
+)
+
+// Encoding is the base32 alphabet of secrets, without padding as
+// authenticator apps expect.
+var Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ErrMalformedSecret is returned for secrets that aren't base32.
+var ErrMalformedSecret = errors.New("malformed TOTP secret")
+
+// Key is a TOTP secret with the labels an authenticator app shows for it.
+type Key struct {
+	Issuer  string // Service name, e.g. "Acme"
+	Account string // The user's name at the issuer, e.g. an email address
+	Secret  string // Base32 shared secret
+}
+
+// NewKey generates a secret from rand (crypto/rand when nil).
+func NewKey(issuer, account string, rand io.Reader) (*Key, error) {
+	if issuer == "" || strings.Contains(issuer, ":") {
+		return nil, fmt.Errorf("issuer must be non-empty and without colons")
+	}
+	if rand == nil {
+		rand = cryptoRand
+	}
+	b := make([]byte, SecretSize)
+	if _, err := io.ReadFull(rand, b); err != nil {
+		return nil, fmt.Errorf("generate TOTP secret: %w", err)
+	}
+	return &Key{Issuer: issuer, Account: account, Secret: Encoding.EncodeToString(b)}, nil
+}
+
+var cryptoRand = rand.Reader
+
+// URI returns the key as an otpauth:// URI, the payload of the QR code
+// authenticator apps scan.
+func (k *Key) URI() string {
+	q := url.Values{}
+	q.Set("secret", k.Secret)
+	q.Set("issuer", k.Issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(int(Period/time.Second)))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + k.Issuer + ":" + k.Account,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
+
+// Step returns the time step t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code for secret at time t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, Step(t)), nil
+}
+
+// Validate checks code against the steps within skew of t's, allowing for
+// clock drift, and returns the step it matched. Callers should refuse
+// steps at or before the last one accepted so a code can't be replayed.
+func Validate(secret, code string, t time.Time, skew int) (step int64, ok bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for d := -int64(skew); d <= int64(skew); d++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, now+d)), []byte(code)) == 1 {
+			step, ok = now+d, true
+		}
+	}
+	return step, ok
+}
+
+// hotp is the HOTP value (RFC 4226) of key at counter step
+func hotp(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[off:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, n%1000000)
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	s := strings.ToUpper(strings.TrimRight(strings.ReplaceAll(secret, " ", ""), "="))
+	key, err := Encoding.DecodeString(s)
+	if err != nil || len(key) == 0 {
+		return nil, ErrMalformedSecret
+	}
+	return key, nil
+}
@@ -0,0 +1,374 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/mohar9h/goauth"
+)
+
+// CookieOptions configures cookie authentication for browser apps. The
+// cookie fields mirror gorilla/sessions' Options, so an app's session
+// settings carry over; set Store to keep the token in such a session
+// rather than a cookie of its own. Zero values select the defaults.
+//
+// Token cookies are always HttpOnly and Secure, and the token never
+// reaches scripts. State-changing requests must echo the CSRF token, which
+// scripts read from the CSRFCookie and forms embed with CSRFToken.
+type CookieOptions struct {
+	Name     string        // Token cookie. Default "goauth_session"
+	Path     string        // Default "/"
+	Domain   string        // Default host-only
+	MaxAge   int           // Cookie lifetime in seconds. 0 follows the token's expiry
+	SameSite http.SameSite // Default Lax. None is only sent over HTTPS
+	Insecure bool          // Drop the Secure attribute, for local development over plain HTTP only
+
+	CSRFCookie string // Double-submit cookie, readable by scripts. Default "goauth_csrf"
+	CSRFHeader string // Default "X-CSRF-Token"
+	CSRFField  string // Form field checked when the header is absent. Default "csrf_token"
+
+	Store TokenStore // Where the token is kept between requests. Default the Name cookie
+
+	AfterLogin  string // Where LoginHandler redirects; empty answers with JSON
+	AfterLogout string // Where LogoutHandler redirects; empty answers 204
+}
+
+func (o CookieOptions) withDefaults() CookieOptions {
+	if o.Name == "" {
+		o.Name = "goauth_session"
+	}
+	if o.Path == "" {
+		o.Path = "/"
+	}
+	if o.SameSite == 0 || o.SameSite == http.SameSiteDefaultMode {
+		o.SameSite = http.SameSiteLaxMode
+	}
+	if o.SameSite == http.SameSiteNoneMode {
+		o.Insecure = false
+	}
+	if o.CSRFCookie == "" {
+		o.CSRFCookie = "goauth_csrf"
+	}
+	if o.CSRFHeader == "" {
+		o.CSRFHeader = "X-CSRF-Token"
+	}
+	if o.CSRFField == "" {
+		o.CSRFField = "csrf_token"
+	}
+	if o.Store == nil {
+		o.Store = cookieStore{o}
+	}
+	return o
+}
+
+// cookie returns a cookie named name with the options' attributes,
+// expiring with expiresAt unless MaxAge is set
+func (o CookieOptions) cookie(name, value string, expiresAt *time.Time) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     o.Path,
+		Domain:   o.Domain,
+		MaxAge:   o.MaxAge,
+		Secure:   !o.Insecure,
+		HttpOnly: true,
+		SameSite: o.SameSite,
+	}
+	if o.MaxAge == 0 && expiresAt != nil {
+		c.Expires = *expiresAt
+	}
+	return c
+}
+
+// clearCookie returns a cookie deleting name
+func (o CookieOptions) clearCookie(name string) *http.Cookie {
+	c := o.cookie(name, "", nil)
+	c.MaxAge = -1
+	return c
+}
+
+// TokenStore keeps the session token between requests. The default keeps
+// it in its own cookie. Apps already using gorilla/sessions can keep it in
+// their session instead, e.g. with Load reading
+// session.Values["goauth_token"] and Save and Clear writing it and calling
+// session.Save.
+type TokenStore interface {
+	// Load returns the request's token, or "" when there is none
+	Load(r *http.Request) (string, error)
+	// Save keeps token until expiresAt, which is nil for tokens that
+	// don't expire
+	Save(w http.ResponseWriter, r *http.Request, token string, expiresAt *time.Time) error
+	Clear(w http.ResponseWriter, r *http.Request) error
+}
+
+// cookieStore keeps the token in the Name cookie
+type cookieStore struct {
+	opts CookieOptions
+}
+
+func (s cookieStore) Load(r *http.Request) (string, error) {
+	c, err := r.Cookie(s.opts.Name)
+	if errors.Is(err, http.ErrNoCookie) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return c.Value, nil
+}
+
+func (s cookieStore) Save(w http.ResponseWriter, _ *http.Request, token string, expiresAt *time.Time) error {
+	http.SetCookie(w, s.opts.cookie(s.opts.Name, token, expiresAt))
+	return nil
+}
+
+func (s cookieStore) Clear(w http.ResponseWriter, _ *http.Request) error {
+	http.SetCookie(w, s.opts.clearCookie(s.opts.Name))
+	return nil
+}
+
+// StartSession stores the token of a successful login and sets the CSRF
+// cookie, and returns the CSRF token for the response. LoginHandler calls
+// it; call it directly after a login flow of your own.
+func StartSession(w http.ResponseWriter, r *http.Request, res *goauth.TokenResult, opts CookieOptions) (string, error) {
+	opts = opts.withDefaults()
+	if res == nil || res.PlainText == "" || res.MFARequired {
+		return "", errors.New("middleware: no session token to store")
+	}
+	if err := opts.Store.Save(w, r, res.PlainText, res.ExpiresAt); err != nil {
+		return "", err
+	}
+	csrf := csrfFor(res.PlainText)
+	c := opts.cookie(opts.CSRFCookie, csrf, res.ExpiresAt)
+	c.HttpOnly = false
+	http.SetCookie(w, c)
+	return csrf, nil
+}
+
+// EndSession removes the token and the CSRF cookie. It doesn't revoke the
+// token; LogoutHandler does.
+func EndSession(w http.ResponseWriter, r *http.Request, opts CookieOptions) error {
+	opts = opts.withDefaults()
+	c := opts.clearCookie(opts.CSRFCookie)
+	c.HttpOnly = false
+	http.SetCookie(w, c)
+	return opts.Store.Clear(w, r)
+}
+
+type csrfKey struct{}
+
+// CSRFToken returns the CSRF token of a request authenticated by
+// CookieAuthenticate, for embedding in forms as the CSRFField. It is ""
+// for requests authenticated otherwise.
+func CSRFToken(r *http.Request) string {
+	csrf, _ := r.Context().Value(csrfKey{}).(string)
+	return csrf
+}
+
+// csrfFor derives the CSRF token of a session from its token. Tying the
+// two together means a CSRF cookie planted by a sibling subdomain can't
+// match the victim's session, and the token can't be recovered from it.
+func csrfFor(token string) string {
+	sum := sha256.Sum256([]byte("goauth-csrf\x00" + token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// checkCSRF reports whether r may act for the session with token. Safe
+// methods always may; others must echo the session's CSRF token in the
+// header or form field.
+func checkCSRF(r *http.Request, token string, opts CookieOptions) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	got := r.Header.Get(opts.CSRFHeader)
+	if got == "" {
+		got = r.PostFormValue(opts.CSRFField)
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(csrfFor(token))) == 1
+}
+
+// CookieAuthenticate is Authenticate for browser apps: it validates the
+// session token from the cookie (or Store) and, for state-changing
+// methods, the CSRF token, answering a mismatch with 403 csrf_failed.
+// Requests carrying an Authorization header are authenticated as bearer
+// requests instead, without a CSRF check, so API clients can share the
+// routes. A rejected session's cookies are cleared.
+func CookieAuthenticate(c *goauth.Client, opts CookieOptions) func(http.Handler) http.Handler {
+	opts = opts.withDefaults()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, ok := cookieAuthenticate(c, w, r, opts)
+			if !ok {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func cookieAuthenticate(c *goauth.Client, w http.ResponseWriter, r *http.Request, opts CookieOptions) (*http.Request, bool) {
+	if _, ok := TokenFromContext(r.Context()); ok || r.Header.Get("Authorization") != "" {
+		return authenticate(c, w, r)
+	}
+
+	token, err := opts.Store.Load(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, &Error{Code: "server_error"})
+		return r, false
+	}
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, &Error{Code: "unauthenticated", Description: "not signed in"})
+		return r, false
+	}
+	if !checkCSRF(r, token, opts) {
+		writeError(w, http.StatusForbidden, &Error{Code: "csrf_failed", Description: "missing or wrong CSRF token"})
+		return r, false
+	}
+
+	tok, err := c.ValidateTokenWithRequest(r.Context(), token, r)
+	if err != nil {
+		status, body := errorFor(err)
+		if status == http.StatusUnauthorized {
+			_ = EndSession(w, r, opts)
+		}
+		writeError(w, status, body)
+		return r, false
+	}
+	ctx := context.WithValue(ContextWithToken(r.Context(), tok), csrfKey{}, csrfFor(token))
+	return r.WithContext(ctx), true
+}
+
+// LoginFunc authenticates the request of a login form and returns the
+// session token, e.g. with Client.Login or Client.CompleteMFA. A result
+// with MFARequired is passed back to the browser as a challenge.
+type LoginFunc func(r *http.Request) (*goauth.TokenResult, error)
+
+// PasswordLogin reads identifier and password from the form, or a JSON
+// body with those fields, and signs in with Client.Login.
+func PasswordLogin(c *goauth.Client) LoginFunc {
+	return func(r *http.Request) (*goauth.TokenResult, error) {
+		var creds struct {
+			Identifier string `json:"identifier"`
+			Password   string `json:"password"`
+		}
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&creds); err != nil {
+				return nil, goauth.ErrInvalidCredentials
+			}
+		} else {
+			creds.Identifier, creds.Password = r.PostFormValue("identifier"), r.PostFormValue("password")
+		}
+		return c.Login(r.Context(), creds.Identifier, creds.Password)
+	}
+}
+
+// loginResponse is the JSON body of LoginHandler without AfterLogin
+type loginResponse struct {
+	CSRFToken    string     `json:"csrf_token,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MFAChallenge string     `json:"mfa_challenge,omitempty"` // Pass to CompleteMFA with the user's code
+}
+
+// LoginHandler signs users in with login (PasswordLogin when nil) and
+// starts a cookie session. It answers a redirect to AfterLogin, or JSON
+// with the CSRF token for scripts. When the user still has to pass
+// two-factor authentication it sets no cookie and answers 401
+// mfa_required with the challenge; serve a second LoginHandler whose
+// LoginFunc calls CompleteMFA to finish. Failed logins get 401
+// invalid_credentials.
+func LoginHandler(c *goauth.Client, opts CookieOptions, login LoginFunc) http.Handler {
+	opts = opts.withDefaults()
+	if login == nil {
+		login = PasswordLogin(c)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, &Error{Code: "method_not_allowed"})
+			return
+		}
+
+		res, err := login(r)
+		switch {
+		case errors.Is(err, goauth.ErrInvalidCredentials), errors.Is(err, goauth.ErrInvalidMFACode):
+			writeError(w, http.StatusUnauthorized, &Error{Code: "invalid_credentials", Description: "wrong sign-in details"})
+			return
+		case err != nil:
+			status, body := errorFor(err)
+			writeError(w, status, body)
+			return
+		case res.MFARequired:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(struct {
+				Error
+				loginResponse
+			}{Error{Code: "mfa_required", Description: "complete two-factor authentication to continue"}, loginResponse{MFAChallenge: res.PlainText}})
+			return
+		}
+
+		csrf, err := StartSession(w, r, res, opts)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, &Error{Code: "server_error"})
+			return
+		}
+		if opts.AfterLogin != "" {
+			http.Redirect(w, r, opts.AfterLogin, http.StatusSeeOther)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(loginResponse{CSRFToken: csrf, ExpiresAt: res.ExpiresAt})
+	})
+}
+
+// LogoutHandler revokes the session token and clears the cookies. It only
+// accepts POST with the CSRF token, so other sites can't sign users out.
+// A session whose token is already gone is simply cleared.
+func LogoutHandler(c *goauth.Client, opts CookieOptions) http.Handler {
+	opts = opts.withDefaults()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, &Error{Code: "method_not_allowed"})
+			return
+		}
+
+		token, err := opts.Store.Load(r)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, &Error{Code: "server_error"})
+			return
+		}
+		if token != "" {
+			if !checkCSRF(r, token, opts) {
+				writeError(w, http.StatusForbidden, &Error{Code: "csrf_failed", Description: "missing or wrong CSRF token"})
+				return
+			}
+			err := c.RevokeToken(r.Context(), token)
+			if err != nil && !errors.Is(err, goauth.ErrTokenInvalid) && !errors.Is(err, goauth.ErrInvalidFormat) {
+				writeError(w, http.StatusInternalServerError, &Error{Code: "server_error"})
+				return
+			}
+		}
+		if err := EndSession(w, r, opts); err != nil {
+			writeError(w, http.StatusInternalServerError, &Error{Code: "server_error"})
+			return
+		}
+
+		if opts.AfterLogout != "" {
+			http.Redirect(w, r, opts.AfterLogout, http.StatusSeeOther)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,142 @@
+// Package middleware authenticates HTTP requests with goauth tokens. The
+// middleware has the func(http.Handler) http.Handler shape used by chi
+// and most other routers, so it needs no router dependency:
+//
+//	r := chi.NewRouter()
+//	r.Use(middleware.Authenticate(client))
+//	r.With(middleware.RequireAbility(client, "write:posts")).Post("/posts", createPost)
+//
+// Handlers read the validated token with TokenFromContext. Failures are
+// answered with a JSON Error: 401 for missing or invalid tokens, 403 with
+// the missing abilities when authorization fails.
+//
+// Browser apps can keep the token in an HttpOnly cookie instead: see
+// CookieAuthenticate, LoginHandler and LogoutHandler.
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/mohar9h/goauth"
+)
+
+// Error is the JSON body of a rejected request.
+type Error struct {
+	Code              string   `json:"error"`
+	Description       string   `json:"error_description,omitempty"`
+	MissingAbilities  []string `json:"missing_abilities,omitempty"`
+	RequiredAbilities []string `json:"required_abilities,omitempty"`
+}
+
+type tokenKey struct{}
+
+// ContextWithToken attaches a validated token to ctx.
+func ContextWithToken(ctx context.Context, tok *goauth.PersonalAccessToken) context.Context {
+	return context.WithValue(ctx, tokenKey{}, tok)
+}
+
+// TokenFromContext returns the token validated by Authenticate or
+// RequireAbility.
+func TokenFromContext(ctx context.Context) (*goauth.PersonalAccessToken, bool) {
+	tok, ok := ctx.Value(tokenKey{}).(*goauth.PersonalAccessToken)
+	return tok, ok && tok != nil
+}
+
+// Authenticate validates the bearer token of every request, including its
+// IP and user-agent bindings, and passes the token on in the request
+// context. Requests without a valid token get a 401.
+func Authenticate(c *goauth.Client) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, ok := authenticate(c, w, r)
+			if !ok {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAbility only lets through requests whose token grants every one
+// of abilities, answering others with a 403 listing the missing ones. It
+// uses the token validated by Authenticate, or validates one itself when
+// the route isn't behind Authenticate.
+func RequireAbility(c *goauth.Client, abilities ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, ok := authenticate(c, w, r)
+			if !ok {
+				return
+			}
+			tok, _ := TokenFromContext(r.Context())
+			missing, err := c.MissingAbilities(r.Context(), tok, abilities...)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, &Error{Code: "server_error"})
+				return
+			}
+			if len(missing) > 0 {
+				writeError(w, http.StatusForbidden, &Error{
+					Code:              "insufficient_ability",
+					Description:       "the token lacks required abilities",
+					MissingAbilities:  missing,
+					RequiredAbilities: abilities,
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticate returns r with the validated token in its context, or
+// writes the error response and returns false
+func authenticate(c *goauth.Client, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if _, ok := TokenFromContext(r.Context()); ok {
+		return r, true
+	}
+
+	if r.Header.Get("Authorization") == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		writeError(w, http.StatusUnauthorized, &Error{Code: "unauthenticated", Description: "missing bearer token"})
+		return r, false
+	}
+
+	tok, err := c.ValidateTokenWithRequest(r.Context(), "", r)
+	if err != nil {
+		status, body := errorFor(err)
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Bearer error="`+body.Code+`"`)
+		}
+		writeError(w, status, body)
+		return r, false
+	}
+	return r.WithContext(ContextWithToken(r.Context(), tok)), true
+}
+
+// errorFor maps a validation error to a response
+func errorFor(err error) (int, *Error) {
+	switch {
+	case errors.Is(err, goauth.ErrRateLimited):
+		return http.StatusTooManyRequests, &Error{Code: "rate_limited"}
+	case errors.Is(err, goauth.ErrMaintenanceMode):
+		return http.StatusServiceUnavailable, &Error{Code: "maintenance"}
+	case errors.Is(err, goauth.ErrStepUpRequired):
+		return http.StatusUnauthorized, &Error{Code: "insufficient_user_authentication", Description: "re-authenticate to continue"}
+	case errors.Is(err, goauth.ErrMFARequired):
+		return http.StatusUnauthorized, &Error{Code: "mfa_required", Description: "complete two-factor authentication to continue"}
+	case errors.Is(err, goauth.ErrTokenInvalid), errors.Is(err, goauth.ErrInvalidFormat),
+		errors.Is(err, goauth.ErrClientMismatch):
+		return http.StatusUnauthorized, &Error{Code: "invalid_token", Description: "the token is invalid, expired or revoked"}
+	}
+	return http.StatusInternalServerError, &Error{Code: "server_error"}
+}
+
+func writeError(w http.ResponseWriter, status int, body *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,56 @@
+package goauth
+
+import (
+	"fmt"
+
+	"github.com/mohar9h/goauth/internal/storage"
+	"gorm.io/gorm"
+)
+
+// TokenRecord is a GORM model to store tokens in instead of
+// PersonalAccessToken, converting to and from it
+type TokenRecord = storage.TokenRecord
+
+// TokenColumnMapper renames goauth's columns (see TokenColumns) for a
+// TokenRecord whose schema names them differently
+type TokenColumnMapper = storage.TokenColumnMapper
+
+// TokenColumns are the columns every TokenRecord model must have
+var TokenColumns = storage.TokenColumns
+
+// ChangeColumns are the optional columns that give a TokenRecord model a
+// change feed (see ChangesSince)
+var ChangeColumns = storage.ChangeColumns
+
+// WithGormModelStorage is WithGormStorage with the application's own token
+// model M, e.g. one following its naming conventions or carrying extra
+// relations, while every client method works as usual. *M implements
+// TokenRecord, and TokenColumnMapper when its column names differ; the
+// model is checked for all of TokenColumns when the client is built.
+//
+//	goauth.WithGormModelStorage[APIToken](db)
+func WithGormModelStorage[M any, PM interface {
+	*M
+	TokenRecord
+}](db *gorm.DB) Option {
+	return func(c *Client) error {
+		if db == nil {
+			return fmt.Errorf("database connection cannot be nil")
+		}
+		driver, err := storage.NewGormModelDriver[M, PM](db)
+		if err != nil {
+			return err
+		}
+		c.storage = driver
+		return nil
+	}
+}
+
+// NewGormModelStorage returns a GORM storage driver for the token model M,
+// e.g. for a Replica
+func NewGormModelStorage[M any, PM interface {
+	*M
+	TokenRecord
+}](db *gorm.DB) (storage.Driver, error) {
+	return storage.NewGormModelDriver[M, PM](db)
+}
@@ -0,0 +1,219 @@
+// Package oauth2 implements a minimal OAuth2 token endpoint (RFC 6749) for
+// the client_credentials grant. Registered API clients exchange their ID
+// and secret for a goauth token whose abilities are the granted scopes.
+//
+//	store := oauth2.NewGormStore(db)
+//	id, secret, _ := oauth2.Register(ctx, store, oauth2.Registration{
+//		Name: "billing-sync", UserID: svcUserID, Scopes: []string{"invoices:read"},
+//	})
+//	mux.Handle("/oauth/token", oauth2.NewServer(client, store).TokenHandler())
+//
+// Clients authenticate with HTTP Basic (client_secret_basic) or form
+// parameters (client_secret_post).
+package oauth2
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mohar9h/goauth"
+)
+
+// MetaClientID tags tokens with the OAuth2 client they were issued to.
+const MetaClientID = "goauth.oauth2_client_id"
+
+// Server issues tokens for registered clients.
+type Server struct {
+	auth    *goauth.Client
+	clients ClientStore
+}
+
+func NewServer(auth *goauth.Client, clients ClientStore) *Server {
+	return &Server{auth: auth, clients: clients}
+}
+
+// TokenResponse is the successful token endpoint response.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// Error is an OAuth2 error response (RFC 6749 section 5.2).
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	status      int
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return "oauth2: " + e.Code
+	}
+	return "oauth2: " + e.Code + ": " + e.Description
+}
+
+func oauthError(status int, code, desc string) *Error {
+	return &Error{Code: code, Description: desc, status: status}
+}
+
+// TokenHandler serves the token endpoint.
+func (s *Server) TokenHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, oauthError(http.StatusMethodNotAllowed, "invalid_request", "token requests must use POST"), false)
+			return
+		}
+
+		resp, basic, err := s.token(r)
+		if err != nil {
+			var oe *Error
+			if !errors.As(err, &oe) {
+				oe = oauthError(http.StatusInternalServerError, "server_error", "")
+			}
+			writeError(w, oe, basic)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+}
+
+func (s *Server) token(r *http.Request) (*TokenResponse, bool, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, false, oauthError(http.StatusBadRequest, "invalid_request", "malformed form body")
+	}
+	// Credentials must not travel in the URL
+	if r.URL.Query().Has("client_secret") {
+		return nil, false, oauthError(http.StatusBadRequest, "invalid_request", "client_secret must not be sent in the query string")
+	}
+
+	id, secret, basic, err := clientCredentials(r)
+	if err != nil {
+		return nil, basic, err
+	}
+	client, err := s.authenticate(r, id, secret)
+	if err != nil {
+		return nil, basic, err
+	}
+
+	switch grant := r.PostForm.Get("grant_type"); grant {
+	case "client_credentials":
+	case "":
+		return nil, basic, oauthError(http.StatusBadRequest, "invalid_request", "grant_type is required")
+	default:
+		return nil, basic, oauthError(http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+
+	scopes, err := grantScopes(client, r.PostForm.Get("scope"))
+	if err != nil {
+		return nil, basic, err
+	}
+
+	name := "oauth2:" + client.Name
+	opts := &goauth.TokenOptions{
+		UserId:    client.UserID,
+		Name:      &name,
+		Abilities: scopes,
+		ExpiresIn: client.TokenTTL,
+		Metadata:  map[string]string{MetaClientID: client.ID},
+	}
+	result, err := s.auth.IssueToken(r.Context(), opts)
+	if err != nil {
+		return nil, basic, err
+	}
+
+	resp := &TokenResponse{
+		AccessToken: result.PlainText,
+		TokenType:   "Bearer",
+		Scope:       strings.Join(scopes, " "),
+	}
+	if result.ExpiresAt != nil {
+		resp.ExpiresIn = int64(result.ExpiresAt.Sub(s.auth.Now()).Round(time.Second) / time.Second)
+	}
+	return resp, basic, nil
+}
+
+// clientCredentials reads the client ID and secret from HTTP Basic auth or
+// the form body. Using both is rejected.
+func clientCredentials(r *http.Request) (id, secret string, basic bool, err error) {
+	id, secret, basic = r.BasicAuth()
+	if basic {
+		if r.PostForm.Has("client_secret") {
+			return "", "", true, oauthError(http.StatusBadRequest, "invalid_request", "multiple client authentication methods")
+		}
+		// RFC 6749 section 2.3.1 form-encodes the credentials before Basic encoding
+		if id, err = url.QueryUnescape(id); err == nil {
+			secret, err = url.QueryUnescape(secret)
+		}
+		if err != nil {
+			return "", "", true, oauthError(http.StatusUnauthorized, "invalid_client", "")
+		}
+		return id, secret, true, nil
+	}
+
+	id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	if id == "" || secret == "" {
+		return "", "", false, oauthError(http.StatusUnauthorized, "invalid_client", "client authentication required")
+	}
+	return id, secret, false, nil
+}
+
+func (s *Server) authenticate(r *http.Request, id, secret string) (*Client, error) {
+	client, err := s.clients.FindClient(r.Context(), id)
+	if errors.Is(err, ErrClientNotFound) {
+		// Hash anyway so unknown IDs take as long as wrong secrets
+		HashSecret(secret)
+		return nil, oauthError(http.StatusUnauthorized, "invalid_client", "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !client.VerifySecret(secret) {
+		return nil, oauthError(http.StatusUnauthorized, "invalid_client", "")
+	}
+	return client, nil
+}
+
+// grantScopes returns the requested scopes, or all of the client's when
+// none are requested. Each must be covered by an allowed ability, which may
+// be a wildcard like "invoices:*".
+func grantScopes(client *Client, requested string) ([]string, error) {
+	allowed := client.AllowedScopes()
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return allowed, nil
+	}
+
+	grant := &goauth.PersonalAccessToken{Abilities: strings.Join(allowed, ",")}
+	var out []string
+	for _, s := range scopes {
+		if !grant.Can(s) {
+			return nil, oauthError(http.StatusBadRequest, "invalid_scope", "scope "+s+" is not allowed for this client")
+		}
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+func writeError(w http.ResponseWriter, e *Error, basic bool) {
+	if e.status == http.StatusUnauthorized && basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(e.status)
+	_ = json.NewEncoder(w).Encode(e)
+}
@@ -0,0 +1,242 @@
+// Package oidc verifies ID tokens from OpenID Connect providers such as
+// Google, Azure AD and Keycloak, so goauth can sign in users who
+// authenticated there (see goauth.ExchangeIDToken).
+//
+// A Provider fetches the issuer's discovery document and JWKS on first
+// use and caches both. ID tokens must be signed with RS256, ES256 or
+// EdDSA by one of the issuer's keys, name the client in aud, be unexpired
+// and, when the sign-in request sent one, carry its nonce.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/jose"
+)
+
+// Google is the issuer of Google ID tokens. Tokens naming the legacy
+// issuer "accounts.google.com" are accepted too.
+const Google = "https://accounts.google.com"
+
+// AzureAD returns the issuer of a Microsoft Entra ID (Azure AD) tenant's
+// v2.0 tokens.
+func AzureAD(tenantID string) string {
+	return "https://login.microsoftonline.com/" + tenantID + "/v2.0"
+}
+
+// Keycloak returns the issuer of a Keycloak realm.
+func Keycloak(baseURL, realm string) string {
+	return strings.TrimRight(baseURL, "/") + "/realms/" + realm
+}
+
+var (
+	// ErrInvalidIDToken is returned for ID tokens that are malformed,
+	// badly signed, expired, or meant for another client or sign-in.
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	// ErrDiscovery is returned when the provider's metadata can't be used.
+	ErrDiscovery = errors.New("OIDC discovery failed")
+)
+
+// Config describes a provider and the client registered with it.
+type Config struct {
+	Issuer   string // Expected iss claim, e.g. Google (required)
+	ClientID string // Expected aud claim (required)
+
+	JWKSURL    string        // Signing keys; taken from the discovery document when empty
+	HTTPClient *http.Client  // Default http.DefaultClient
+	Leeway     time.Duration // Clock skew allowed on exp, nbf and iat. Default 1m
+}
+
+// Metadata is the part of a provider's discovery document
+// (/.well-known/openid-configuration) goauth uses.
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint,omitempty"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported,omitempty"`
+}
+
+// Discover fetches the issuer's discovery document. Its issuer must be the
+// one asked for, and its JWKS must be served over HTTPS when the issuer
+// is.
+func Discover(ctx context.Context, client *http.Client, issuer string) (*Metadata, error) {
+	var m Metadata
+	u := strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration"
+	if err := jose.GetJSON(ctx, client, u, &m); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrDiscovery, issuer, err)
+	}
+	switch {
+	case m.Issuer != issuer:
+		return nil, fmt.Errorf("%w: %s: document names issuer %q", ErrDiscovery, issuer, m.Issuer)
+	case m.JWKSURI == "":
+		return nil, fmt.Errorf("%w: %s: no jwks_uri", ErrDiscovery, issuer)
+	case strings.HasPrefix(issuer, "https://") && !strings.HasPrefix(m.JWKSURI, "https://"):
+		return nil, fmt.Errorf("%w: %s: insecure jwks_uri", ErrDiscovery, issuer)
+	}
+	return &m, nil
+}
+
+// Provider verifies ID tokens of one issuer for one client. It is safe for
+// concurrent use.
+type Provider struct {
+	cfg Config
+
+	mu   sync.Mutex
+	meta *Metadata
+	keys *jose.KeySet
+}
+
+// NewProvider returns a provider for cfg. Nothing is fetched until the
+// first token is verified.
+func NewProvider(cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, fmt.Errorf("OIDC provider requires an issuer and client ID")
+	}
+	if cfg.Leeway <= 0 {
+		cfg.Leeway = time.Minute
+	}
+	p := &Provider{cfg: cfg}
+	if cfg.JWKSURL != "" {
+		p.keys = &jose.KeySet{URL: cfg.JWKSURL, Client: cfg.HTTPClient}
+	}
+	return p, nil
+}
+
+// Issuer returns the provider's issuer.
+func (p *Provider) Issuer() string {
+	return p.cfg.Issuer
+}
+
+// Metadata returns the provider's discovery document, fetched once.
+func (p *Provider) Metadata(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	m, err := Discover(ctx, p.cfg.HTTPClient, p.cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	p.meta = m
+	if p.keys == nil {
+		p.keys = &jose.KeySet{URL: m.JWKSURI, Client: p.cfg.HTTPClient}
+	}
+	return m, nil
+}
+
+func (p *Provider) keySet(ctx context.Context) (*jose.KeySet, error) {
+	p.mu.Lock()
+	keys := p.keys
+	p.mu.Unlock()
+	if keys != nil {
+		return keys, nil
+	}
+	if _, err := p.Metadata(ctx); err != nil {
+		return nil, err
+	}
+	return p.keys, nil
+}
+
+// IDToken is a verified ID token.
+type IDToken struct {
+	Issuer        string
+	Subject       string // Stable identifier of the user at the provider
+	Audience      []string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Nonce         string
+	IssuedAt      time.Time
+	Expiry        time.Time
+	Claims        map[string]any // Every claim, with numbers as json.Number
+}
+
+// Claim returns a claim by path, with dots reaching into nested claims.
+func (t *IDToken) Claim(path string) (any, bool) {
+	return jose.Claims(t.Claims).Lookup(path)
+}
+
+// Verify checks an ID token and returns its claims. nonce is the value
+// sent with the sign-in request; when it isn't empty the token must carry
+// it, so a token captured from another sign-in can't be replayed. now
+// defaults to time.Now().
+func (p *Provider) Verify(ctx context.Context, raw, nonce string, now time.Time) (*IDToken, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	claims, err := jose.Verify(ctx, raw, func(ctx context.Context, h jose.Header) (crypto.PublicKey, error) {
+		keys, err := p.keySet(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return keys.Key(ctx, h.Kid)
+	})
+	if err == nil {
+		err = claims.Validate(jose.Expected{Audience: p.cfg.ClientID, Leeway: p.cfg.Leeway, Now: now})
+	}
+	if iss := claims.String("iss"); err == nil && iss != p.cfg.Issuer && !(p.cfg.Issuer == Google && iss == "accounts.google.com") {
+		err = fmt.Errorf("%w: unexpected issuer %q", jose.ErrInvalidToken, iss)
+	}
+	if err != nil {
+		if errors.Is(err, jose.ErrInvalidToken) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+		}
+		return nil, err
+	}
+
+	tok := &IDToken{
+		Issuer:   claims.String("iss"),
+		Subject:  claims.String("sub"),
+		Audience: claims.Audiences(),
+		Email:    claims.String("email"),
+		Name:     claims.String("name"),
+		Nonce:    claims.String("nonce"),
+		Claims:   claims,
+	}
+	tok.IssuedAt, _ = claims.Time("iat")
+	tok.Expiry, _ = claims.Time("exp")
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		tok.EmailVerified = v
+	case string: // Some providers send "true"
+		tok.EmailVerified = v == "true"
+	}
+
+	// An authorized party other than us means the token was issued to
+	// another client that merely lists us as an audience
+	azp := claims.String("azp")
+	switch {
+	case tok.Subject == "":
+		return nil, fmt.Errorf("%w: missing sub", ErrInvalidIDToken)
+	case azp != "" && azp != p.cfg.ClientID, azp == "" && len(tok.Audience) > 1:
+		return nil, fmt.Errorf("%w: issued to another client", ErrInvalidIDToken)
+	case nonce != "" && subtle.ConstantTimeCompare([]byte(tok.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	return tok, nil
+}
+
+// NewNonce returns a random nonce to send with a sign-in request and
+// check with Verify.
+func NewNonce() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
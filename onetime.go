@@ -0,0 +1,86 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/siem"
+)
+
+// MetaOneTime marks tokens from CreateOneTimeToken.
+const MetaOneTime = auth.MetaOneTime
+
+// ErrOneTimeToken is returned by ValidateToken for one-time tokens, which
+// only ConsumeOneTimeToken accepts. It matches ErrTokenInvalid.
+var ErrOneTimeToken = utils.ErrOneTimeToken
+
+// CreateOneTimeToken issues the user a token that ConsumeOneTimeToken
+// accepts once within ttl, for email magic links and password resets. It
+// carries no abilities and ValidateToken rejects it, so a leaked link
+// can't be used as a bearer token.
+func (c *Client) CreateOneTimeToken(ctx context.Context, userID int64, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", fmt.Errorf("one-time token lifetime must be positive")
+	}
+	res, err := c.IssueToken(ctx, &TokenOptions{
+		UserId:    userID,
+		Metadata:  map[string]string{MetaOneTime: "1"},
+		ExpiresIn: ttl,
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.PlainText, nil
+}
+
+// ConsumeOneTimeToken validates a token from CreateOneTimeToken and revokes
+// it in the same conditional write, so it can't be replayed: of concurrent
+// calls with the same token exactly one succeeds, the others get
+// ErrTokenRevoked. The returned token names the user to sign in, e.g. by
+// issuing them a session with IssueToken.
+func (c *Client) ConsumeOneTimeToken(ctx context.Context, raw string) (*entity.PersonalAccessToken, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if raw == "" {
+		return nil, errEmptyToken
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	var keys []string
+	var keyBuf [2]string
+	if c.limiter != nil {
+		keys = c.rateLimitKeys(ctx, raw, &keyBuf)
+		if err := c.checkRateLimit(ctx, keys); err != nil {
+			c.auditFailure(ctx, raw, err)
+			return nil, err
+		}
+	}
+
+	tok, err := traced(c, ctx, "ConsumeOneTimeToken", func(cfg *config.Config) (*entity.PersonalAccessToken, error) {
+		return auth.ConsumeOneTime(raw, cfg)
+	})
+	if err != nil {
+		if c.limiter != nil {
+			c.recordFailure(ctx, keys, err)
+		}
+		c.auditFailure(ctx, raw, err)
+		return nil, err
+	}
+
+	c.announceRevocation(ctx, tok.UserId, tok.ID)
+	c.audit(ctx, siem.Event{Type: siem.TokenRevoked, UserID: tok.UserId, Reason: "one-time token consumed"})
+	return tok, nil
+}
@@ -0,0 +1,257 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// OrgUnit is an org or a team in the organization hierarchy
+type OrgUnit = entity.OrgUnit
+
+// OrgMember places a user in an org or team
+type OrgMember = entity.OrgMember
+
+// AbilityGrant is an ability granted to an org, team or user
+type AbilityGrant = entity.AbilityGrant
+
+// ErrOrgUnitNotFound is returned when an org or team does not exist
+var ErrOrgUnitNotFound = utils.ErrOrgUnitNotFound
+
+// WithInheritedAbilities makes ValidateTokenWithAbility check the token's
+// effective abilities, including grants inherited from the user's orgs and
+// teams, instead of only the abilities stored on the token
+func WithInheritedAbilities() Option {
+	return func(c *Client) error {
+		c.inheritAbilities = true
+		return nil
+	}
+}
+
+// CreateOrg creates a top-level org
+func (c *Client) CreateOrg(ctx context.Context, name string) (*OrgUnit, error) {
+	return c.createOrgUnit(ctx, entity.OrgKindOrg, name, nil)
+}
+
+// CreateTeam creates a team under an org or another team
+func (c *Client) CreateTeam(ctx context.Context, parentID int64, name string) (*OrgUnit, error) {
+	return c.createOrgUnit(ctx, entity.OrgKindTeam, name, &parentID)
+}
+
+func (c *Client) createOrgUnit(ctx context.Context, kind, name string, parentID *int64) (*OrgUnit, error) {
+	store, err := c.orgStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%s name is required", kind)
+	}
+
+	unit := &entity.OrgUnit{
+		ParentID:  parentID,
+		Kind:      kind,
+		Name:      name,
+		CreatedAt: c.config.Now(),
+	}
+	if err := store.CreateOrgUnit(unit); err != nil {
+		return nil, err
+	}
+	return unit, nil
+}
+
+// AddMember adds a user to an org or team
+func (c *Client) AddMember(ctx context.Context, unitID, userID int64) error {
+	store, err := c.orgStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	if userID <= 0 {
+		return fmt.Errorf("user ID must be positive")
+	}
+
+	defer c.InvalidateDecisions()
+	return store.AddOrgMember(&entity.OrgMember{
+		UnitID:    unitID,
+		UserId:    userID,
+		CreatedAt: c.config.Now(),
+	})
+}
+
+// RemoveMember removes a user from an org or team
+func (c *Client) RemoveMember(ctx context.Context, unitID, userID int64) error {
+	store, err := c.orgStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer c.InvalidateDecisions()
+	return store.RemoveOrgMember(unitID, userID)
+}
+
+// GrantUnitAbility grants an ability to every member of an org or team and
+// of all the teams below it
+func (c *Client) GrantUnitAbility(ctx context.Context, unitID int64, ability string) error {
+	return c.grant(ctx, entity.GrantSubjectUnit, unitID, ability)
+}
+
+// GrantUserAbility grants an ability to a user across all of their tokens
+func (c *Client) GrantUserAbility(ctx context.Context, userID int64, ability string) error {
+	return c.grant(ctx, entity.GrantSubjectUser, userID, ability)
+}
+
+// RevokeUnitAbility removes an ability granted to an org or team
+func (c *Client) RevokeUnitAbility(ctx context.Context, unitID int64, ability string) error {
+	store, err := c.orgStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer c.InvalidateDecisions()
+	return store.RevokeAbility(entity.GrantSubjectUnit, unitID, ability)
+}
+
+// RevokeUserAbility removes an ability granted directly to a user
+func (c *Client) RevokeUserAbility(ctx context.Context, userID int64, ability string) error {
+	store, err := c.orgStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer c.InvalidateDecisions()
+	return store.RevokeAbility(entity.GrantSubjectUser, userID, ability)
+}
+
+func (c *Client) grant(ctx context.Context, subjectType string, subjectID int64, ability string) error {
+	store, err := c.orgStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	ability = strings.TrimSpace(ability)
+	if ability == "" {
+		return fmt.Errorf("ability is required")
+	}
+	if err := auth.ValidateAbilities([]string{ability}); err != nil {
+		return err
+	}
+	if subjectType == entity.GrantSubjectUnit {
+		if _, err := store.FindOrgUnit(subjectID); err != nil {
+			return err
+		}
+	}
+
+	defer c.InvalidateDecisions()
+	return store.GrantAbility(&entity.AbilityGrant{
+		SubjectType: subjectType,
+		SubjectID:   subjectID,
+		Ability:     ability,
+		CreatedAt:   c.config.Now(),
+	})
+}
+
+// EffectiveAbilities returns the union of the token's own abilities, the
+// abilities granted to its user, and those granted to every org and team
+// the user belongs to, including the ancestors of those units
+func (c *Client) EffectiveAbilities(ctx context.Context, tok *PersonalAccessToken) ([]string, error) {
+	if tok == nil {
+		return nil, fmt.Errorf("token cannot be nil")
+	}
+
+	store, err := c.orgStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var abilities []string
+	add := func(list ...string) {
+		for _, a := range list {
+			if !seen[a] {
+				seen[a] = true
+				abilities = append(abilities, a)
+			}
+		}
+	}
+	for _, a := range entity.SplitAbilities(c.config.EffectiveAbilities(tok.Abilities)) {
+		if a = strings.TrimSpace(a); a != "" {
+			add(a)
+		}
+	}
+
+	grants, err := store.ListGrants(entity.GrantSubjectUser, []int64{tok.UserId})
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range grants {
+		add(g.Ability)
+	}
+
+	units, err := c.unitAncestry(store, tok.UserId)
+	if err != nil {
+		return nil, err
+	}
+	grants, err = store.ListGrants(entity.GrantSubjectUnit, units)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range grants {
+		add(g.Ability)
+	}
+
+	return abilities, nil
+}
+
+// unitAncestry returns the user's units and all of their ancestors. A
+// parent loop in the stored hierarchy is cut at the first repeated unit.
+func (c *Client) unitAncestry(store storage.OrgStore, userID int64) ([]int64, error) {
+	direct, err := store.UserOrgUnits(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int64]bool)
+	var units []int64
+	for _, id := range direct {
+		for !seen[id] {
+			seen[id] = true
+			units = append(units, id)
+
+			unit, err := store.FindOrgUnit(id)
+			if err != nil {
+				return nil, err
+			}
+			if unit.ParentID == nil {
+				break
+			}
+			id = *unit.ParentID
+		}
+	}
+	return units, nil
+}
+
+func (c *Client) orgStore(ctx context.Context) (storage.OrgStore, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	store, ok := c.storage.(storage.OrgStore)
+	if !ok {
+		return nil, fmt.Errorf("org grants: %w", utils.ErrNotSupported)
+	}
+	return store, nil
+}
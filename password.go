@@ -0,0 +1,195 @@
+package goauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/password"
+	"github.com/mohar9h/goauth/siem"
+)
+
+// PasswordOptions tunes password login
+type PasswordOptions struct {
+	Hasher password.Hasher // Hashes new passwords. Default argon2id with password.DefaultArgon2
+	Policy password.Policy // Checked by SetPassword
+	Token  TokenOptions    // Template for tokens issued by Login; UserId is filled in
+}
+
+// passwordOptions holds the store configured by WithPasswords
+type passwordOptions struct {
+	store password.Store
+	opts  PasswordOptions
+
+	dummyOnce sync.Once
+	dummy     string // Hash checked for unknown identifiers
+}
+
+// WithPasswords enables SetPassword and Login, keeping credentials in
+// store, so goauth can sign users in as well as issue their tokens.
+func WithPasswords(store password.Store, opts PasswordOptions) Option {
+	return func(c *Client) error {
+		if store == nil {
+			return fmt.Errorf("password store cannot be nil")
+		}
+		if opts.Hasher == nil {
+			opts.Hasher = password.Argon2id(password.DefaultArgon2)
+		}
+		c.passwords = &passwordOptions{store: store, opts: opts}
+		return nil
+	}
+}
+
+// normalizeIdentifier makes identifiers such as email addresses match
+// regardless of case and surrounding space
+func normalizeIdentifier(identifier string) string {
+	return strings.ToLower(strings.TrimSpace(identifier))
+}
+
+// SetPassword sets the user's login identifier and password, replacing
+// those they had. The password must pass PasswordOptions.Policy; failures
+// match password.ErrWeak.
+func (c *Client) SetPassword(ctx context.Context, userID int64, identifier, pw string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if c.passwords == nil {
+		return fmt.Errorf("no password store configured")
+	}
+	if userID <= 0 {
+		return fmt.Errorf("user ID must be positive")
+	}
+	id := normalizeIdentifier(identifier)
+	if id == "" {
+		return fmt.Errorf("identifier cannot be empty")
+	}
+	if err := c.passwords.opts.Policy.Check(ctx, pw); err != nil {
+		return err
+	}
+
+	hash, err := c.passwords.opts.Hasher.Hash(pw)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	creds := &password.Credentials{UserID: userID, Identifier: id, Hash: hash, UpdatedAt: c.config.Now()}
+	if err := c.passwords.store.SaveCredentials(ctx, creds); err != nil {
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+	return nil
+}
+
+// CheckPassword returns the user the identifier and password belong to,
+// or ErrInvalidCredentials. Unknown identifiers take as long as wrong
+// passwords, so they can't be told apart by timing. Hashes written with
+// another hasher or other parameters are upgraded on success. Use Login
+// to issue a token as well.
+func (c *Client) CheckPassword(ctx context.Context, identifier, pw string) (int64, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if c.passwords == nil {
+		return 0, fmt.Errorf("no password store configured")
+	}
+	p := c.passwords
+	id := normalizeIdentifier(identifier)
+	creds, err := p.store.FindCredentials(ctx, id)
+	if errors.Is(err, password.ErrNotFound) || id == "" {
+		_, _ = password.Verify(pw, p.dummyHash())
+		return 0, utils.ErrInvalidCredentials
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find credentials: %w", err)
+	}
+
+	ok, err := password.Verify(pw, creds.Hash)
+	if err != nil {
+		c.config.Logger.Warn("unreadable password hash", "user_id", creds.UserID, "error", err)
+	}
+	if !ok {
+		return 0, utils.ErrInvalidCredentials
+	}
+
+	if p.opts.Hasher.NeedsRehash(creds.Hash) {
+		if creds.Hash, err = p.opts.Hasher.Hash(pw); err == nil {
+			creds.UpdatedAt = c.config.Now()
+			err = p.store.SaveCredentials(ctx, creds)
+		}
+		if err != nil {
+			c.config.Logger.Warn("failed to rehash password", "user_id", creds.UserID, "error", err)
+		}
+	}
+	return creds.UserID, nil
+}
+
+func (p *passwordOptions) dummyHash() string {
+	p.dummyOnce.Do(func() {
+		p.dummy, _ = p.opts.Hasher.Hash("goauth-dummy-password")
+	})
+	return p.dummy
+}
+
+// Login checks the identifier and password and issues a token for their
+// user from PasswordOptions.Token. Under WithRateLimiter, failed logins
+// drain the budgets of the identifier and the client IP (see
+// ContextWithClientIP). Logins are audited as user.login and
+// user.login_failed.
+//
+// Under WithMFA, users with a confirmed enrollment get a pending token
+// instead, with MFARequired set on the result; see CompleteMFA.
+func (c *Client) Login(ctx context.Context, identifier, pw string) (*TokenResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	userID, err := c.checkLogin(ctx, identifier, pw)
+	if err != nil {
+		return nil, err
+	}
+	enrolled, err := c.MFAEnrolled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if enrolled {
+		return c.BeginMFA(ctx, userID)
+	}
+
+	opts := c.passwords.opts.Token
+	opts.UserId = userID
+	res, err := c.IssueToken(ctx, &opts)
+	if err != nil {
+		return nil, err
+	}
+	c.audit(ctx, siem.Event{Type: siem.LoginSucceeded, UserID: userID})
+	return res, nil
+}
+
+// checkLogin is CheckPassword with rate limiting and auditing
+func (c *Client) checkLogin(ctx context.Context, identifier, pw string) (int64, error) {
+	var keys []string
+	if c.limiter != nil {
+		keys = append(keys, c.clientIPLimitKey(ctx), "login:"+normalizeIdentifier(identifier))
+		if err := c.checkRateLimit(ctx, keys); err != nil {
+			c.audit(ctx, siem.Event{Type: siem.LoginFailed, Outcome: siem.Failure, Reason: err.Error()})
+			return 0, err
+		}
+	}
+
+	userID, err := c.CheckPassword(ctx, identifier, pw)
+	if errors.Is(err, utils.ErrInvalidCredentials) {
+		c.drainRateLimit(ctx, keys)
+		c.audit(ctx, siem.Event{Type: siem.LoginFailed, Outcome: siem.Failure, Reason: err.Error()})
+	}
+	return userID, err
+}
@@ -0,0 +1,186 @@
+// Package password hashes and checks user passwords for goauth's password
+// login (see goauth.WithPasswords). New hashes use argon2id by default:
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<digest>
+//
+// bcrypt is supported for stores migrated from other systems, and Verify
+// also accepts every hash string the phc package knows, such as
+// $pbkdf2-sha256$. Hashes written with another algorithm or other
+// parameters than the configured Hasher are reported by NeedsRehash, so
+// logins can upgrade them.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mohar9h/goauth/phc"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher hashes passwords for storage.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// NeedsRehash reports whether encoded was written with another
+	// algorithm or other parameters than Hash uses
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2Params are the argon2id cost parameters.
+type Argon2Params struct {
+	Memory  uint32 // KiB
+	Time    uint32 // Passes
+	Threads uint8
+	SaltLen int // Bytes. Default 16
+	KeyLen  int // Bytes. Default 32
+}
+
+// DefaultArgon2 is the second recommended option of RFC 9106 with the
+// memory lowered to 64 MiB.
+var DefaultArgon2 = Argon2Params{Memory: 64 * 1024, Time: 3, Threads: 4}
+
+// Argon2id returns a hasher writing argon2id hash strings. Zero fields of
+// p take their DefaultArgon2 values.
+func Argon2id(p Argon2Params) Hasher {
+	if p.Memory == 0 {
+		p.Memory = DefaultArgon2.Memory
+	}
+	if p.Time == 0 {
+		p.Time = DefaultArgon2.Time
+	}
+	if p.Threads == 0 {
+		p.Threads = DefaultArgon2.Threads
+	}
+	if p.SaltLen <= 0 {
+		p.SaltLen = 16
+	}
+	if p.KeyLen <= 0 {
+		p.KeyLen = 32
+	}
+	return argon2Hasher{p}
+}
+
+// argon2Hasher is also a phc.Hasher. It isn't registered with phc, so
+// token hashing never picks up a password hasher by accident; Verify
+// passes it to phc.VerifyWith instead.
+type argon2Hasher struct {
+	p Argon2Params
+}
+
+var _ phc.Hasher = argon2Hasher{}
+
+func (argon2Hasher) ID() string { return "argon2id" }
+func (a argon2Hasher) Params() string {
+	return fmt.Sprintf("m=%d,t=%d,p=%d", a.p.Memory, a.p.Time, a.p.Threads)
+}
+func (argon2Hasher) Salted() bool { return true }
+
+func (a argon2Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, a.p.Time, a.p.Memory, a.p.Threads, uint32(a.p.KeyLen))
+	h := &phc.Hash{ID: a.ID(), Version: argon2.Version, Params: a.Params(), Salt: phc.B64.EncodeToString(salt), Digest: phc.B64.EncodeToString(key)}
+	return h.String(), nil
+}
+
+func (argon2Hasher) Verify(password string, h *phc.Hash) (bool, error) {
+	m, okM := h.Param("m")
+	t, okT := h.Param("t")
+	p, okP := h.Param("p")
+	if !okM || !okT || !okP || m <= 0 || t <= 0 || p <= 0 || p > 255 || h.Version != argon2.Version {
+		return false, phc.ErrMalformed
+	}
+	salt, err := phc.B64.DecodeString(h.Salt)
+	if err != nil {
+		return false, phc.ErrMalformed
+	}
+	want, err := phc.B64.DecodeString(h.Digest)
+	if err != nil || len(want) == 0 {
+		return false, phc.ErrMalformed
+	}
+	key := argon2.IDKey([]byte(password), salt, uint32(t), uint32(m), uint8(p), uint32(len(want)))
+	return subtle.ConstantTimeCompare(key, want) == 1, nil
+}
+
+func (a argon2Hasher) NeedsRehash(encoded string) bool {
+	return phc.NeedsRehash(a, encoded)
+}
+
+// Bcrypt returns a hasher writing bcrypt strings ($2a$). A cost of 0 means
+// bcrypt.DefaultCost. bcrypt ignores everything after the first 72 bytes
+// of a password; keep Policy.MaxLength at or below that to avoid
+// surprises.
+func Bcrypt(cost int) Hasher {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return bcryptHasher{cost}
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+func (b bcryptHasher) Hash(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	return string(h), err
+}
+
+func (b bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.cost
+}
+
+// isBcrypt reports whether encoded is a bcrypt string
+func isBcrypt(encoded string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(encoded, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks password against a stored hash: argon2id, bcrypt, or any
+// hash string registered with the phc package.
+func Verify(password, encoded string) (bool, error) {
+	if isBcrypt(encoded) {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return phc.VerifyWith(argon2Hasher{}, password, encoded)
+}
+
+// ErrWeak is the category of every policy violation. Check returns a
+// *PolicyError matching it.
+var ErrWeak = errors.New("password does not meet policy")
+
+// PolicyError is a password refused by a Policy.
+type PolicyError struct {
+	Reason string // "too_short", "too_long" or "breached"
+	Limit  int    // The length limit violated, for the length reasons
+}
+
+func (e *PolicyError) Error() string {
+	switch e.Reason {
+	case "too_short":
+		return "password must be at least " + strconv.Itoa(e.Limit) + " characters"
+	case "too_long":
+		return "password must be at most " + strconv.Itoa(e.Limit) + " characters"
+	case "breached":
+		return "password appears in a known data breach"
+	}
+	return ErrWeak.Error()
+}
+
+func (e *PolicyError) Is(target error) bool { return target == ErrWeak }
@@ -0,0 +1,107 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// BreachCheck reports whether a password is known to be compromised.
+type BreachCheck func(ctx context.Context, password string) (bool, error)
+
+// Policy is checked when a password is set. Following NIST SP 800-63B it
+// limits length and rejects breached passwords rather than demanding
+// character classes.
+type Policy struct {
+	MinLength int // In characters. Default 8
+	MaxLength int // In characters. Default 64
+
+	// Breached, when set, rejects compromised passwords. Errors fail the
+	// check unless BreachFailOpen is set.
+	Breached       BreachCheck
+	BreachFailOpen bool
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.MinLength <= 0 {
+		p.MinLength = 8
+	}
+	if p.MaxLength <= 0 {
+		p.MaxLength = 64
+	}
+	return p
+}
+
+// Check returns a *PolicyError when password violates the policy.
+func (p Policy) Check(ctx context.Context, password string) error {
+	p = p.withDefaults()
+	n := utf8.RuneCountInString(password)
+	switch {
+	case n < p.MinLength:
+		return &PolicyError{Reason: "too_short", Limit: p.MinLength}
+	case n > p.MaxLength:
+		return &PolicyError{Reason: "too_long", Limit: p.MaxLength}
+	}
+	if p.Breached == nil {
+		return nil
+	}
+	breached, err := p.Breached(ctx, password)
+	if err != nil {
+		if p.BreachFailOpen {
+			return nil
+		}
+		return fmt.Errorf("breach check: %w", err)
+	}
+	if breached {
+		return &PolicyError{Reason: "breached"}
+	}
+	return nil
+}
+
+// PwnedPasswords checks passwords against the Have I Been Pwned range API
+// with k-anonymity: only the first five hex characters of the password's
+// SHA-1 leave the process. Passwords seen at least minCount times (1 when
+// 0) count as breached. A nil client uses http.DefaultClient.
+func PwnedPasswords(client *http.Client, minCount int) BreachCheck {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	minCount = max(minCount, 1)
+	return func(ctx context.Context, password string) (bool, error) {
+		sum := sha1.Sum([]byte(password))
+		digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, pwnedRangeURL+digest[:5], nil)
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set("Add-Padding", "true")
+		resp, err := client.Do(req)
+		if err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return false, fmt.Errorf("pwned passwords: status %d", resp.StatusCode)
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			suffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+			if !ok || suffix != digest[5:] {
+				continue
+			}
+			n, _ := strconv.Atoi(count)
+			return n >= minCount, nil
+		}
+		return false, scanner.Err()
+	}
+}
+
+// pwnedRangeURL is a variable so tests can point it elsewhere
+var pwnedRangeURL = "https://api.pwnedpasswords.com/range/"
@@ -0,0 +1,134 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotFound is returned by a Store for unknown identifiers.
+var ErrNotFound = errors.New("credentials not found")
+
+// Credentials are a user's login identifier, e.g. an email address, and
+// password hash.
+type Credentials struct {
+	ID         int64  `gorm:"primaryKey;autoIncrement"`
+	UserID     int64  `gorm:"uniqueIndex;not null"`
+	Identifier string `gorm:"size:255;uniqueIndex;not null"` // Normalised by the client before storing
+	Hash       string `gorm:"size:255;not null"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// TableName keeps credentials apart from goauth's token tables.
+func (Credentials) TableName() string {
+	return "password_credentials"
+}
+
+// Store persists credentials.
+type Store interface {
+	FindCredentials(ctx context.Context, identifier string) (*Credentials, error)
+	// SaveCredentials inserts c, or replaces the user's credentials when
+	// they have some
+	SaveCredentials(ctx context.Context, c *Credentials) error
+}
+
+// MemoryStore keeps credentials in memory (for testing).
+type MemoryStore struct {
+	mu     sync.RWMutex
+	byUser map[int64]Credentials
+	nextID int64
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{byUser: make(map[int64]Credentials)}
+}
+
+func (s *MemoryStore) FindCredentials(_ context.Context, identifier string) (*Credentials, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, c := range s.byUser {
+		if c.Identifier == identifier {
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStore) SaveCredentials(_ context.Context, c *Credentials) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.byUser {
+		if other.Identifier == c.Identifier && other.UserID != c.UserID {
+			return ErrIdentifierTaken
+		}
+	}
+	if old, ok := s.byUser[c.UserID]; ok {
+		c.ID, c.CreatedAt = old.ID, old.CreatedAt
+	} else {
+		s.nextID++
+		c.ID = s.nextID
+	}
+	s.byUser[c.UserID] = *c
+	return nil
+}
+
+// ErrIdentifierTaken is returned when saving credentials whose identifier
+// belongs to another user.
+var ErrIdentifierTaken = errors.New("identifier belongs to another user")
+
+// GormStore keeps credentials in the password_credentials table
+// (db.AutoMigrate(&password.Credentials{})).
+type GormStore struct {
+	db *gorm.DB
+}
+
+var _ Store = (*GormStore)(nil)
+
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+func (s *GormStore) FindCredentials(ctx context.Context, identifier string) (*Credentials, error) {
+	var c Credentials
+	err := s.db.WithContext(ctx).First(&c, "identifier = ?", identifier).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *GormStore) SaveCredentials(ctx context.Context, c *Credentials) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var taken int64
+		err := tx.Model(&Credentials{}).Where("identifier = ? AND user_id <> ?", c.Identifier, c.UserID).Count(&taken).Error
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return ErrIdentifierTaken
+		}
+
+		var old Credentials
+		err = tx.First(&old, "user_id = ?", c.UserID).Error
+		switch {
+		case err == nil:
+			c.ID, c.CreatedAt = old.ID, old.CreatedAt
+			return tx.Save(c).Error
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return tx.Create(c).Error
+		default:
+			return err
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package phc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// MinPepperSize is the shortest pepper Pepper accepts.
+const MinPepperSize = 16
+
+// Pepper returns an unsalted HMAC-SHA256 hasher keyed with a server-side
+// pepper. Kept out of the database, e.g. in a secret manager, it makes a
+// leaked token table useless on its own: the hashes can't be checked
+// against candidate secrets without the pepper.
+//
+//	$hmac-sha256$k=<key id>$<hex digest>
+//
+// The key ID is derived from the pepper, so rows name the pepper that wrote
+// them. Pass earlier peppers after a rotation: their rows keep validating
+// and are rehashed with the current pepper on use.
+func Pepper(pepper []byte, previous ...[]byte) (Hasher, error) {
+	p := &pepperHasher{keys: make(map[string][]byte)}
+	for i, key := range append([][]byte{pepper}, previous...) {
+		if len(key) < MinPepperSize {
+			return nil, errors.New("pepper must be at least 16 bytes")
+		}
+		kid := pepperID(key)
+		if _, dup := p.keys[kid]; dup {
+			continue
+		}
+		p.keys[kid] = append([]byte(nil), key...)
+		if i == 0 {
+			p.kid = kid
+		} else {
+			p.previous = append(p.previous, kid)
+		}
+	}
+	return p, nil
+}
+
+// Lookups is implemented by unsalted hashers that also accept hashes
+// written with earlier parameters, such as a rotated pepper. Lookups by
+// hash try each of them after the current one.
+type Lookups interface {
+	LookupHashes(secret string) []string
+}
+
+type pepperHasher struct {
+	kid      string
+	keys     map[string][]byte
+	previous []string // Key IDs of earlier peppers, newest first
+}
+
+func (*pepperHasher) ID() string       { return "hmac-sha256" }
+func (p *pepperHasher) Params() string { return "k=" + p.kid }
+func (*pepperHasher) Salted() bool     { return false }
+
+func (p *pepperHasher) Hash(secret string) (string, error) {
+	return p.hash(p.kid, secret), nil
+}
+
+func (p *pepperHasher) hash(kid, secret string) string {
+	h := &Hash{ID: p.ID(), Params: "k=" + kid, Digest: hex.EncodeToString(p.sum(p.keys[kid], secret))}
+	return h.String()
+}
+
+func (*pepperHasher) sum(key []byte, secret string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(secret))
+	return mac.Sum(nil)
+}
+
+func (p *pepperHasher) LookupHashes(secret string) []string {
+	hashes := make([]string, len(p.previous))
+	for i, kid := range p.previous {
+		hashes[i] = p.hash(kid, secret)
+	}
+	return hashes
+}
+
+func (p *pepperHasher) Verify(secret string, h *Hash) (bool, error) {
+	kid, ok := strings.CutPrefix(h.Params, "k=")
+	if !ok {
+		return false, ErrMalformed
+	}
+	key, ok := p.keys[kid]
+	if !ok {
+		return false, ErrUnknownPepper
+	}
+	want := hex.EncodeToString(p.sum(key, secret))
+	return subtle.ConstantTimeCompare([]byte(want), []byte(h.Digest)) == 1, nil
+}
+
+// ErrUnknownPepper is returned for hashes written with a pepper the hasher
+// wasn't given.
+var ErrUnknownPepper = errors.New("hash written with an unknown pepper")
+
+// pepperID names a pepper without revealing it
+func pepperID(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("goauth pepper id"))
+	return hex.EncodeToString(mac.Sum(nil)[:4])
+}
+
+// VerifyWith checks secret against a stored hash string, using h for
+// strings with its ID and the registered hashers for the rest. Keyed
+// hashers such as Pepper aren't registered, so they must be passed here.
+func VerifyWith(h Hasher, secret, stored string) (bool, error) {
+	if h != nil {
+		if p, err := Parse(stored); err == nil && p.ID == h.ID() {
+			return h.Verify(secret, p)
+		}
+	}
+	return Verify(secret, stored)
+}
@@ -0,0 +1,257 @@
+// Package phc stores token hashes as PHC strings, so each row names the
+// hasher and parameters that produced it:
+//
+//	$<id>[$v=<version>][$<param>=<value>[,...]][$<salt>]$<digest>
+//
+// e.g.
+//
+//	$sha256$9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	$pbkdf2-sha256$i=10000$Jx1mZ7aPcR5nYe2LuH9s4A$3kTQ8bW0qvJx1mZ7aPcR5nYe2LuH9s4Gd0XfB1mZ7aM
+//
+// Salts and PBKDF2 digests use unpadded standard base64 as in the PHC spec.
+// SHA-256 digests stay hex so they match the compact form: a bare 64
+// character hex string is read as $sha256$ with that digest, which is what
+// goauth stored before hash strings and still stores by default.
+//
+// SHA256 and PBKDF2 are built in. Others, such as argon2id from
+// golang.org/x/crypto, can be added with Register.
+package phc
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrMalformed = errors.New("malformed hash string")
+	ErrUnknown   = errors.New("unknown hash algorithm")
+)
+
+// B64 is the encoding of salts and binary digests.
+var B64 = base64.RawStdEncoding
+
+// Hash is a parsed hash string.
+type Hash struct {
+	ID      string
+	Version int    // 0 when absent
+	Params  string // e.g. "m=65536,t=3,p=4"
+	Salt    string // Encoded as it appears in the string
+	Digest  string // Encoded as it appears in the string
+}
+
+// Parse splits a hash string into its fields.
+func Parse(s string) (*Hash, error) {
+	if len(s) == 2*sha256.Size && isHex(s) {
+		return &Hash{ID: "sha256", Digest: s}, nil
+	}
+	if !strings.HasPrefix(s, "$") {
+		return nil, ErrMalformed
+	}
+
+	fields := strings.Split(s[1:], "$")
+	h := &Hash{ID: fields[0]}
+	fields = fields[1:]
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "v=") {
+		v, err := strconv.Atoi(fields[0][2:])
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		h.Version, fields = v, fields[1:]
+	}
+	if len(fields) > 1 && strings.Contains(fields[0], "=") {
+		h.Params, fields = fields[0], fields[1:]
+	}
+	switch len(fields) {
+	case 1:
+		h.Digest = fields[0]
+	case 2:
+		h.Salt, h.Digest = fields[0], fields[1]
+	default:
+		return nil, ErrMalformed
+	}
+	if h.ID == "" || h.Digest == "" {
+		return nil, ErrMalformed
+	}
+	return h, nil
+}
+
+// String encodes h back into a hash string.
+func (h *Hash) String() string {
+	var b strings.Builder
+	b.WriteString("$" + h.ID)
+	if h.Version != 0 {
+		b.WriteString("$v=" + strconv.Itoa(h.Version))
+	}
+	if h.Params != "" {
+		b.WriteString("$" + h.Params)
+	}
+	if h.Salt != "" {
+		b.WriteString("$" + h.Salt)
+	}
+	b.WriteString("$" + h.Digest)
+	return b.String()
+}
+
+// Param returns the integer parameter name from h.Params.
+func (h *Hash) Param(name string) (int, bool) {
+	for p := range strings.SplitSeq(h.Params, ",") {
+		if k, v, ok := strings.Cut(p, "="); ok && k == name {
+			n, err := strconv.Atoi(v)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// Hasher hashes token secrets into hash strings.
+type Hasher interface {
+	// ID is the algorithm identifier that starts its hash strings.
+	ID() string
+	// Params are the parameters new hashes are written with, as they
+	// appear in the string. Rows with others are rehashed on use.
+	Params() string
+	// Salted reports whether hashing the same secret twice gives different
+	// strings, in which case tokens are found by locator, not by hash.
+	Salted() bool
+	Hash(secret string) (string, error)
+	// Verify checks secret against a parsed hash with this hasher's ID.
+	Verify(secret string, h *Hash) (bool, error)
+}
+
+var (
+	mu      sync.RWMutex
+	hashers = map[string]Hasher{}
+)
+
+// Register makes h available to Verify for strings with its ID, replacing
+// any hasher registered under it before.
+func Register(h Hasher) {
+	mu.Lock()
+	defer mu.Unlock()
+	hashers[h.ID()] = h
+}
+
+func init() {
+	Register(SHA256)
+	Register(PBKDF2(0))
+}
+
+// Verify checks secret against a stored hash string using the hasher
+// registered for its ID.
+func Verify(secret, stored string) (bool, error) {
+	h, err := Parse(stored)
+	if err != nil {
+		return false, err
+	}
+	mu.RLock()
+	hasher, ok := hashers[h.ID]
+	mu.RUnlock()
+	if !ok {
+		return false, fmt.Errorf("%w %q", ErrUnknown, h.ID)
+	}
+	return hasher.Verify(secret, h)
+}
+
+// NeedsRehash reports whether stored was written by another hasher than h
+// or with other parameters.
+func NeedsRehash(h Hasher, stored string) bool {
+	p, err := Parse(stored)
+	if err != nil {
+		return true
+	}
+	if p.ID != h.ID() || p.Params != h.Params() {
+		return true
+	}
+	// The compact form is read as $sha256$ but written as it
+	return stored[0] != '$'
+}
+
+// SHA256 is an unsalted SHA-256 of the secret. Token secrets carry enough
+// entropy that a fast hash suffices, and lookups can go by hash.
+var SHA256 Hasher = sha256Hasher{}
+
+type sha256Hasher struct{}
+
+func (sha256Hasher) ID() string     { return "sha256" }
+func (sha256Hasher) Params() string { return "" }
+func (sha256Hasher) Salted() bool   { return false }
+
+func (sha256Hasher) Hash(secret string) (string, error) {
+	sum := sha256.Sum256([]byte(secret))
+	return "$sha256$" + hex.EncodeToString(sum[:]), nil
+}
+
+func (sha256Hasher) Verify(secret string, h *Hash) (bool, error) {
+	sum := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(h.Digest)) == 1, nil
+}
+
+// DefaultPBKDF2Iterations is used by PBKDF2(0).
+const DefaultPBKDF2Iterations = 10000
+
+// PBKDF2 returns a salted PBKDF2-HMAC-SHA256 hasher with the given
+// iteration count, for deployments whose policy requires a salted hash.
+// Every validation pays for the iterations, so keep the count modest:
+// secrets are random, not passwords.
+func PBKDF2(iterations int) Hasher {
+	if iterations <= 0 {
+		iterations = DefaultPBKDF2Iterations
+	}
+	return pbkdf2Hasher{iterations}
+}
+
+type pbkdf2Hasher struct {
+	iter int
+}
+
+func (pbkdf2Hasher) ID() string       { return "pbkdf2-sha256" }
+func (p pbkdf2Hasher) Params() string { return "i=" + strconv.Itoa(p.iter) }
+func (pbkdf2Hasher) Salted() bool     { return true }
+
+func (p pbkdf2Hasher) Hash(secret string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate salt: %w", err)
+	}
+	key, err := pbkdf2.Key(sha256.New, secret, salt, p.iter, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	h := &Hash{ID: p.ID(), Params: p.Params(), Salt: B64.EncodeToString(salt), Digest: B64.EncodeToString(key)}
+	return h.String(), nil
+}
+
+func (pbkdf2Hasher) Verify(secret string, h *Hash) (bool, error) {
+	iter, ok := h.Param("i")
+	salt, err := B64.DecodeString(h.Salt)
+	if !ok || iter <= 0 || err != nil {
+		return false, ErrMalformed
+	}
+	want, err := B64.DecodeString(h.Digest)
+	if err != nil {
+		return false, ErrMalformed
+	}
+	key, err := pbkdf2.Key(sha256.New, secret, salt, iter, len(want))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, want) == 1, nil
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package goauth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/policy"
+)
+
+// policyOptions holds the policy bundle source configured by options
+type policyOptions struct {
+	source  policy.Source
+	key     ed25519.PublicKey
+	refresh time.Duration
+
+	mu     sync.Mutex // Serializes reloads
+	loaded bool
+}
+
+// WithPolicyBundle loads role definitions from a signed policy bundle and
+// refetches it every refresh interval (0 = only at startup). Tokens gain a
+// role's abilities by holding "role:<name>". NewClient fails if the first
+// fetch fails; later failures keep the last good bundle and are reported
+// on Errors().
+func WithPolicyBundle(src policy.Source, pub ed25519.PublicKey, refresh time.Duration) Option {
+	return func(c *Client) error {
+		if src == nil {
+			return fmt.Errorf("policy bundle source cannot be nil")
+		}
+		if len(pub) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid Ed25519 public key")
+		}
+		c.policy = &policyOptions{source: src, key: pub, refresh: refresh}
+		if c.config.Roles == nil {
+			c.config.Roles = &config.Roles{}
+		}
+		return nil
+	}
+}
+
+// ReloadPolicy fetches the policy bundle now instead of waiting for the
+// next refresh. Bundles older than the loaded one are rejected with
+// policy.ErrStale.
+func (c *Client) ReloadPolicy(ctx context.Context) error {
+	if c.policy == nil {
+		return fmt.Errorf("no policy bundle configured")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	p := c.policy
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	data, err := p.source.Fetch(ctx)
+	if errors.Is(err, policy.ErrNotModified) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	bundle, err := policy.Open(p.key, data)
+	if err != nil {
+		return err
+	}
+
+	current := c.config.Roles.Version()
+	if p.loaded && bundle.Version < current {
+		return fmt.Errorf("%w: version %d, loaded %d", policy.ErrStale, bundle.Version, current)
+	}
+
+	c.config.Roles.Set(bundle.Version, bundle.Roles)
+	p.loaded = true
+	c.InvalidateDecisions()
+	c.config.Logger.Info("policy bundle loaded", "version", bundle.Version, "roles", len(bundle.Roles))
+	return nil
+}
+
+// PolicyVersion returns the version of the loaded policy bundle (0 if none)
+func (c *Client) PolicyVersion() int64 {
+	return c.config.Roles.Version()
+}
+
+func (c *Client) startPolicyBundle() error {
+	if c.policy == nil {
+		return nil
+	}
+	if err := c.ReloadPolicy(context.Background()); err != nil {
+		return fmt.Errorf("load policy bundle: %w", err)
+	}
+	if c.policy.refresh > 0 {
+		c.config.Workers.Every("policy-bundle-refresh", c.policy.refresh, func() error {
+			return c.ReloadPolicy(context.Background())
+		})
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package goauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/siem"
+)
+
+// WithAutoPrune starts a background worker that deletes expired tokens
+// every interval. Stop it with Client.Close.
+func WithAutoPrune(interval time.Duration) Option {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return fmt.Errorf("prune interval must be positive")
+		}
+		c.config.AutoPrune = interval
+		return nil
+	}
+}
+
+// PruneExpired deletes tokens past their ExpiresAt and returns the number removed
+func (c *Client) PruneExpired(ctx context.Context) (int64, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	n, err := traced(c, ctx, "PruneExpired", func(cfg *config.Config) (int64, error) {
+		now := cfg.Now()
+		n, err := cfg.Storage.DeleteExpired(now)
+		// Regions pinned by WithResidency are pruned along with the default storage
+		for _, d := range cfg.RegionStorages() {
+			if err != nil {
+				break
+			}
+			var m int64
+			m, err = d.DeleteExpired(now)
+			n += m
+		}
+		return n, err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune expired tokens: %w", err)
+	}
+	if n > 0 {
+		c.audit(ctx, siem.Event{Type: siem.TokensExpired, Count: n})
+	}
+	return n, nil
+}
+
+// PruneRevoked deletes tokens revoked more than olderThan ago, such as those
+// kept by WithSoftRevocation or rotated refresh tokens, and returns the
+// number removed
+func (c *Client) PruneRevoked(ctx context.Context, olderThan time.Duration) (int64, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if olderThan < 0 {
+		return 0, fmt.Errorf("retention cannot be negative")
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	if _, ok := c.storage.(storage.SoftRevoker); !ok || !c.Capabilities().SoftRevoke {
+		return 0, fmt.Errorf("prune revoked: %w", utils.ErrNotSupported)
+	}
+
+	n, err := traced(c, ctx, "PruneRevoked", func(cfg *config.Config) (int64, error) {
+		return cfg.Storage.(storage.SoftRevoker).DeleteRevoked(cfg.Now().Add(-olderThan))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune revoked tokens: %w", err)
+	}
+	return n, nil
+}
+
+// Close stops background workers (auto-prune, last-used updates), waits
+// for in-flight work to finish and flushes batched last-used updates. It is
+// safe to call more than once.
+func (c *Client) Close() error {
+	c.config.Workers.Stop()
+	err := c.flushLastUsed()
+	err = errors.Join(err, c.flushUsage(true))
+	if c.tokenSet.driver != nil {
+		err = errors.Join(err, c.tokenSet.driver.Close())
+	}
+	return err
+}
+
+func (c *Client) startAutoPrune() {
+	if c.config.AutoPrune <= 0 {
+		return
+	}
+
+	c.config.Workers.Every("auto-prune", c.config.AutoPrune, func() error {
+		n, err := c.PruneExpired(context.Background())
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			c.config.Logger.Info("pruned expired tokens", "count", n)
+		}
+		return nil
+	})
+}
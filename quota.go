@@ -0,0 +1,114 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// OrgQuota caps the live access tokens and sessions held by an org's
+// members, including those of its teams. Zero fields are unlimited.
+type OrgQuota = config.OrgQuota
+
+// QuotaUsage is an org's usage of one quota
+type QuotaUsage = config.QuotaUsage
+
+// QuotaError is returned when an issuance would exceed an org quota. It
+// matches ErrQuotaExceeded and tells which org and quota refused it.
+type QuotaError = utils.QuotaError
+
+// ErrQuotaExceeded is returned when an issuance would exceed an org quota
+var ErrQuotaExceeded = utils.ErrQuotaExceeded
+
+// Quotas named in QuotaUsage and QuotaError
+const (
+	QuotaActiveTokens = config.QuotaActiveTokens
+	QuotaSessions     = config.QuotaSessions
+)
+
+// WithOrgQuotas enforces per-org quotas at issuance, e.g. by plan tier. f
+// returns the quota of a top-level org; a user in several orgs must fit
+// each of them. Access tokens count against MaxActiveTokens, and token
+// pairs also against MaxSessions. Rotation and refresh supersede a token
+// and are not counted. Requires a driver with org support.
+func WithOrgQuotas(f func(orgID int64) (OrgQuota, error)) Option {
+	return func(c *Client) error {
+		if f == nil {
+			return fmt.Errorf("org quota function cannot be nil")
+		}
+		c.config.OrgQuotas = f
+		return nil
+	}
+}
+
+// WithQuotaWarning calls hook in the background whenever an issuance
+// brings an org to ratio (0, 1] of a quota, e.g. 0.8 to suggest an
+// upgrade before the limit is hit
+func WithQuotaWarning(ratio float64, hook func(QuotaUsage)) Option {
+	return func(c *Client) error {
+		if ratio <= 0 || ratio > 1 {
+			return fmt.Errorf("quota warning ratio must be in (0, 1]")
+		}
+		if hook == nil {
+			return fmt.Errorf("quota warning hook cannot be nil")
+		}
+		c.config.QuotaWarnRatio = ratio
+		c.config.QuotaHook = hook
+		return nil
+	}
+}
+
+// OrgQuotaUsage returns an org's current usage of each limited quota, for
+// billing pages and dashboards
+func (c *Client) OrgQuotaUsage(ctx context.Context, orgID int64) ([]QuotaUsage, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if c.config.OrgQuotas == nil {
+		return nil, fmt.Errorf("no org quotas configured (call WithOrgQuotas)")
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	return traced(c, ctx, "OrgQuotaUsage", func(cfg *config.Config) ([]QuotaUsage, error) {
+		counter, ok := cfg.Storage.(storage.OrgCounter)
+		if !ok {
+			return nil, fmt.Errorf("org quota: %w", utils.ErrNotSupported)
+		}
+		quota, err := cfg.OrgQuotas(orgID)
+		if err != nil {
+			return nil, err
+		}
+
+		var usage []QuotaUsage
+		for _, q := range []struct {
+			name  string
+			kind  string
+			limit int64
+		}{
+			{QuotaActiveTokens, entity.KindAccess, quota.MaxActiveTokens},
+			{QuotaSessions, entity.KindRefresh, quota.MaxSessions},
+		} {
+			if q.limit <= 0 {
+				continue
+			}
+			used, err := counter.CountByOrg(orgID, q.kind)
+			if err != nil {
+				return nil, err
+			}
+			usage = append(usage, QuotaUsage{OrgID: orgID, Quota: q.name, Used: used, Limit: q.limit})
+		}
+		return usage, nil
+	}, attribute.Int64("goauth.org_id", orgID))
+}
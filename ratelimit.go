@@ -0,0 +1,97 @@
+package goauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/ratelimit"
+)
+
+type clientIPKey struct{}
+
+// WithRateLimiter throttles validation: once a token ID segment or client IP
+// (see ContextWithClientIP) has failed too often, further attempts are
+// rejected with ErrRateLimited before storage is queried. Limiter errors
+// are logged and fail open.
+//
+// The token ID segment is chosen by the caller, so only the client IP
+// bounds a brute force across tokens. Attempts without one share a single
+// budget, and a warning is logged: pass the IP, as ValidateTokenWithRequest
+// and the middleware do, so one client can't throttle the others.
+func WithRateLimiter(l ratelimit.Limiter) Option {
+	return func(c *Client) error {
+		if l == nil {
+			return fmt.Errorf("rate limiter cannot be nil")
+		}
+		c.limiter = l
+		return nil
+	}
+}
+
+// ContextWithClientIP attaches the caller's IP address to ctx so failed
+// validations are also rate limited per IP
+func ContextWithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// unknownClientIP is the limiter key of attempts made without a client IP
+const unknownClientIP = "ip:unknown"
+
+// rateLimitKeys returns the limiter keys for a validation attempt
+func (c *Client) rateLimitKeys(ctx context.Context, raw string, buf *[2]string) []string {
+	keys := append(buf[:0], c.clientIPLimitKey(ctx))
+	if loc, _, ok := cutToken(raw); ok {
+		keys = append(keys, "token:"+loc)
+	}
+	return keys
+}
+
+// clientIPLimitKey returns the limiter key of the caller's IP, or the
+// shared unknownClientIP key when ctx doesn't carry one
+func (c *Client) clientIPLimitKey(ctx context.Context) string {
+	if ip, _ := ctx.Value(clientIPKey{}).(string); ip != "" {
+		return "ip:" + ip
+	}
+	c.noClientIP.Do(func() {
+		c.config.Logger.Warn("rate limiting attempts without a client IP; they share one budget, pass it with ContextWithClientIP")
+	})
+	return unknownClientIP
+}
+
+// checkRateLimit rejects the attempt when any of its keys is exhausted
+func (c *Client) checkRateLimit(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		ok, err := c.limiter.Allow(ctx, key)
+		if err != nil {
+			c.config.Logger.Warn("rate limiter unavailable", "key", key, "error", err)
+			continue
+		}
+		if !ok {
+			return utils.ErrRateLimited
+		}
+	}
+	return nil
+}
+
+// recordFailure drains the budget of every key when err means the secret
+// didn't match a live token. Expired tokens were genuine and don't count.
+func (c *Client) recordFailure(ctx context.Context, keys []string, err error) {
+	if !errors.Is(err, utils.ErrTokenInvalid) || errors.Is(err, utils.ErrTokenExpired) {
+		return
+	}
+	c.drainRateLimit(ctx, keys)
+}
+
+// drainRateLimit records a failed attempt against every key
+func (c *Client) drainRateLimit(ctx context.Context, keys []string) {
+	if c.limiter == nil {
+		return
+	}
+	for _, key := range keys {
+		if err := c.limiter.Fail(ctx, key); err != nil {
+			c.config.Logger.Warn("rate limiter unavailable", "key", key, "error", err)
+		}
+	}
+}
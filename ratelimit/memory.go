@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepThreshold is the bucket count above which Fail drops refilled buckets
+const sweepThreshold = 1 << 16
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// Memory is an in-process token-bucket Limiter. Use Redis to share limits
+// across instances.
+type Memory struct {
+	mu      sync.Mutex
+	burst   float64
+	refill  time.Duration
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+var (
+	_ Limiter = (*Memory)(nil)
+	_ Clocked = (*Memory)(nil)
+)
+
+// NewMemory allows burst failed attempts per key, restoring one attempt
+// every refill
+func NewMemory(burst int, refill time.Duration) *Memory {
+	return &Memory{
+		burst:   float64(burst),
+		refill:  refill,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (m *Memory) SetClock(now func() time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.now = now
+}
+
+func (m *Memory) clock() time.Time {
+	if m.now == nil {
+		return time.Now()
+	}
+	return m.now()
+}
+
+func (m *Memory) Allow(_ context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		return true, nil
+	}
+	m.fill(b, m.clock())
+	if b.tokens >= m.burst {
+		delete(m.buckets, key)
+	}
+	return b.tokens >= 1, nil
+}
+
+func (m *Memory) Fail(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock()
+	b, ok := m.buckets[key]
+	if !ok {
+		if len(m.buckets) >= sweepThreshold {
+			m.sweep(now)
+		}
+		b = &bucket{tokens: m.burst, at: now}
+		m.buckets[key] = b
+	}
+	m.fill(b, now)
+	b.tokens = math.Max(0, b.tokens-1)
+	return nil
+}
+
+// fill adds the tokens earned since the bucket was last touched
+func (m *Memory) fill(b *bucket, now time.Time) {
+	if m.refill > 0 {
+		b.tokens = math.Min(m.burst, b.tokens+float64(now.Sub(b.at))/float64(m.refill))
+	}
+	b.at = now
+}
+
+// sweep drops buckets that have refilled completely
+func (m *Memory) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if m.fill(b, now); b.tokens >= m.burst {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+// Package ratelimit throttles token validation attempts so the "id|secret"
+// format can't be brute-forced. Each key (a token's ID segment, a client IP)
+// has a token bucket that failed validations drain; once it is empty the key
+// is rejected until the bucket refills.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limiter decides whether a key may attempt another validation.
+type Limiter interface {
+	// Allow reports whether key has budget left for another attempt
+	Allow(ctx context.Context, key string) (bool, error)
+
+	// Fail records a failed attempt for key
+	Fail(ctx context.Context, key string) error
+}
+
+// Clocked is implemented by limiters that read the time themselves, so a
+// client's clock can replace time.Now.
+type Clocked interface {
+	SetClock(now func() time.Time)
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// bucketScript refills and optionally drains a bucket stored as a hash.
+// ARGV: burst, refill in ms, cost. Redis time is used so every instance
+// shares one clock.
+var bucketScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local refill = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(b[1]) or burst
+local at = tonumber(b[2]) or now
+if refill > 0 then
+	tokens = math.min(burst, tokens + (now - at) / refill)
+end
+
+if cost > 0 then
+	tokens = math.max(0, tokens - cost)
+	redis.call('HSET', KEYS[1], 'tokens', tokens, 'at', now)
+	if refill > 0 then
+		redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) * refill) + 1)
+	end
+end
+
+if tokens >= 1 then
+	return 1
+end
+return 0
+`)
+
+// Redis is a token-bucket Limiter shared by every instance using the same
+// Redis deployment.
+type Redis struct {
+	client redis.Scripter
+	prefix string
+	burst  int
+	refill time.Duration
+}
+
+var _ Limiter = (*Redis)(nil)
+
+// NewRedis allows burst failed attempts per key, restoring one attempt
+// every refill. Buckets are stored under "goauth:ratelimit:<key>".
+func NewRedis(client redis.Scripter, burst int, refill time.Duration) *Redis {
+	return &Redis{
+		client: client,
+		prefix: "goauth:ratelimit:",
+		burst:  burst,
+		refill: refill,
+	}
+}
+
+func (r *Redis) Allow(ctx context.Context, key string) (bool, error) {
+	ok, err := r.run(ctx, key, 0)
+	return ok, err
+}
+
+func (r *Redis) Fail(ctx context.Context, key string) error {
+	_, err := r.run(ctx, key, 1)
+	return err
+}
+
+func (r *Redis) run(ctx context.Context, key string, cost int) (bool, error) {
+	n, err := bucketScript.Run(ctx, r.client, []string{r.prefix + key},
+		r.burst, r.refill.Milliseconds(), cost).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
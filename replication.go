@@ -0,0 +1,69 @@
+package goauth
+
+import (
+	"fmt"
+
+	"github.com/mohar9h/goauth/internal/locator"
+	"github.com/mohar9h/goauth/internal/storage"
+)
+
+// Replica is the storage of a peer region in an active-active deployment
+type Replica = storage.Replica
+
+// ReplicationOptions tunes replication to peer regions
+type ReplicationOptions = storage.ReplicationOptions
+
+// replicationOptions holds the peers configured by WithReplication
+type replicationOptions struct {
+	peers  []Replica
+	opts   ReplicationOptions
+	driver *storage.ReplicatedDriver
+}
+
+// WithReplication makes the configured storage the local region of an
+// active-active deployment. Token creations and revocations are written
+// locally and replicated to peers in the background; revocation wins
+// conflicts, so revoked tokens are kept as tombstones (see PruneRevoked).
+// opts.SyncPeers and opts.ReadFallback tune how soon other regions see a new
+// token. Storage IDs differ between regions, so use a locator that doesn't
+// depend on them (HashPrefixLocator or ULIDLocator).
+func WithReplication(opts ReplicationOptions, peers ...Replica) Option {
+	return func(c *Client) error {
+		if len(peers) == 0 {
+			return fmt.Errorf("replication requires at least one peer")
+		}
+		c.replication = &replicationOptions{peers: peers, opts: opts}
+		return nil
+	}
+}
+
+func (c *Client) wrapReplication() error {
+	if c.replication == nil {
+		return nil
+	}
+	if _, ok := c.config.Locator.(locator.ID); ok {
+		return fmt.Errorf("replication requires a locator independent of storage IDs (HashPrefixLocator or ULIDLocator)")
+	}
+
+	d, err := storage.NewReplicatedDriver(c.storage, c.replication.peers, c.replication.opts)
+	if err != nil {
+		return err
+	}
+	c.replication.driver = d
+	c.storage = d
+	return nil
+}
+
+func (c *Client) startReplication() {
+	if c.replication == nil {
+		return
+	}
+
+	workers := c.config.Workers
+	c.replication.driver.OnError(func(region string, err error) {
+		workers.Report(fmt.Errorf("replicate to %s: %w", region, err))
+	})
+	workers.Go("replication", func() error {
+		return c.replication.driver.Run(workers.Done())
+	})
+}
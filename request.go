@@ -0,0 +1,105 @@
+package goauth
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/entity"
+)
+
+// ClientBindingMode decides how mismatched IP/user-agent bindings are handled
+type ClientBindingMode = config.ClientBindingMode
+
+// Enforcement modes for IP and user-agent bound tokens
+const (
+	ClientBindingStrict  = config.ClientBindingStrict
+	ClientBindingLogOnly = config.ClientBindingLogOnly
+)
+
+// ErrClientMismatch is returned when a bound token is presented by another client
+var ErrClientMismatch = auth.ErrClientMismatch
+
+// WithClientBinding sets how ValidateTokenWithRequest treats tokens
+// presented from a different IP or user agent than they are bound to.
+// ClientBindingLogOnly is useful to measure impact before enforcing.
+func WithClientBinding(mode ClientBindingMode) Option {
+	return func(c *Client) error {
+		if mode != ClientBindingStrict && mode != ClientBindingLogOnly {
+			return fmt.Errorf("unknown client binding mode %d", mode)
+		}
+		c.config.ClientBinding = mode
+		return nil
+	}
+}
+
+// HashUserAgent returns the value to put in TokenOptions.BoundUserAgentHash
+func HashUserAgent(userAgent string) string {
+	return auth.HashUserAgent(userAgent)
+}
+
+// HashCertificate returns the value to put in TokenOptions.BoundCertHash:
+// the certificate's RFC 8705 x5t#S256 thumbprint
+func HashCertificate(cert *x509.Certificate) string {
+	return auth.HashCertificate(cert.Raw)
+}
+
+// ValidateTokenWithRequest validates the token and checks its IP,
+// user-agent and client certificate bindings against r. When raw is empty
+// the token is read from the Authorization header. The client IP is taken
+// from r.RemoteAddr and the certificate from r.TLS, so deployments behind
+// a proxy must restore them before calling. Certificate-bound tokens
+// (RFC 8705) are rejected from any other client even under
+// ClientBindingLogOnly: unlike IPs, certificates don't change under a
+// legitimate client.
+func (c *Client) ValidateTokenWithRequest(ctx context.Context, raw string, r *http.Request) (*entity.PersonalAccessToken, error) {
+	if r == nil {
+		return nil, fmt.Errorf("request cannot be nil")
+	}
+	if ctx == nil {
+		ctx = r.Context()
+	}
+	if raw == "" {
+		raw = r.Header.Get("Authorization")
+	}
+
+	ip := requestIP(r)
+	tok, err := c.ValidateToken(ContextWithClientIP(ctx, ip), raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := auth.CheckCertificate(tok, peerCertificate(r)); err != nil {
+		return nil, err
+	}
+	if err := auth.CheckClient(tok, ip, r.UserAgent()); err != nil {
+		if c.config.ClientBinding != ClientBindingLogOnly {
+			return nil, err
+		}
+		c.config.Logger.Warn("bound token presented by a different client",
+			"token_id", tok.ID, "ip", ip, "error", err)
+	}
+	return tok, nil
+}
+
+// requestIP returns the host part of r.RemoteAddr
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// peerCertificate returns the leaf certificate the client presented over
+// mutual TLS, or nil
+func peerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
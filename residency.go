@@ -0,0 +1,51 @@
+package goauth
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// ResidencyResolver returns the home region of a user, or "" to keep their
+// tokens in the default storage
+type ResidencyResolver interface {
+	Region(userID int64) (string, error)
+}
+
+// ResidencyFunc adapts a plain function into a ResidencyResolver
+type ResidencyFunc func(userID int64) (string, error)
+
+func (f ResidencyFunc) Region(userID int64) (string, error) {
+	return f(userID)
+}
+
+// WithResidency pins each user's tokens to the storage of their home region,
+// as returned by resolver, for data residency commitments. Pinned tokens
+// carry the region in their plaintext ("eu.42|secret"), so validation,
+// refresh and revocation go straight to that region's storage without
+// consulting the resolver. Tokens of regions missing from regions are
+// rejected with ErrCrossRegion; route them to the deployment serving that
+// region instead. Unpinned users and guest tokens use the configured storage.
+func WithResidency(resolver ResidencyResolver, regions map[string]StorageDriver) Option {
+	return func(c *Client) error {
+		if resolver == nil {
+			return fmt.Errorf("residency requires a resolver")
+		}
+		for name, d := range regions {
+			if name == "" || strings.ContainsAny(name, ".|") {
+				return fmt.Errorf("invalid region name %q", name)
+			}
+			if d == nil {
+				return fmt.Errorf("region %q: %w", name, utils.ErrStorageDriverNil)
+			}
+		}
+		c.config.Residency = &config.Residency{
+			Resolve: resolver.Region,
+			Regions: maps.Clone(regions),
+		}
+		return nil
+	}
+}
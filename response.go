@@ -0,0 +1,382 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/siem"
+)
+
+var (
+	// ErrTokenSuspended is returned for tokens suspended by an automated
+	// response until ResumeToken is called. It matches ErrTokenInvalid.
+	ErrTokenSuspended = utils.ErrTokenSuspended
+	// ErrStepUpRequired is returned for tokens whose holder must
+	// re-authenticate before CompleteStepUp lets the token through again
+	ErrStepUpRequired = utils.ErrStepUpRequired
+)
+
+// Metadata keys holding a token's automated response holds. The value names
+// the detector that placed the hold.
+const (
+	MetaSuspended = auth.MetaSuspended
+	MetaStepUp    = auth.MetaStepUp
+)
+
+// Actions a ResponsePolicy can take on a finding
+const (
+	ActionSuspend    = "suspend"     // Reject the token until ResumeToken
+	ActionStepUp     = "step_up"     // Reject the token until CompleteStepUp
+	ActionRevoke     = "revoke"      // Revoke the token
+	ActionRevokeUser = "revoke_user" // Revoke every token of the user
+	ActionNotify     = "notify"      // Call the Notifier set by WithNotifier
+)
+
+var responseActions = []string{ActionSuspend, ActionStepUp, ActionRevoke, ActionRevokeUser, ActionNotify}
+
+// TokenUse is a successful validation handed to detectors
+type TokenUse struct {
+	Token *entity.PersonalAccessToken
+	IP    string // From ContextWithClientIP; empty when unknown
+	Time  time.Time
+}
+
+// Finding is suspicious use reported by a detector
+type Finding struct {
+	Detector string
+	Token    *entity.PersonalAccessToken
+	IP       string
+	Detail   string
+}
+
+// Detector inspects successful validations for suspicious use. Inspect runs
+// inline with validation and must be fast; it returns nil when the use looks
+// fine.
+type Detector interface {
+	Name() string
+	Inspect(ctx context.Context, use TokenUse) (*Finding, error)
+}
+
+// Notifier tells the token's user about a finding, e.g. by email
+type Notifier func(ctx context.Context, f Finding) error
+
+// ResponsePolicy maps detector names to the actions taken on their
+// findings, in order
+type ResponsePolicy map[string][]string
+
+// ParseResponsePolicy reads a policy written one detector per line or
+// semicolon separated clause:
+//
+//	impossible_travel: suspend, notify
+//	credential_stuffing: revoke_user
+func ParseResponsePolicy(s string) (ResponsePolicy, error) {
+	policy := ResponsePolicy{}
+	for _, clause := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ';' }) {
+		clause = strings.TrimSpace(clause)
+		if clause == "" || strings.HasPrefix(clause, "#") {
+			continue
+		}
+		name, list, ok := strings.Cut(clause, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("response policy: %q is not \"detector: action, ...\"", clause)
+		}
+		for _, action := range strings.Split(list, ",") {
+			if action = strings.TrimSpace(action); action != "" {
+				policy[name] = append(policy[name], action)
+			}
+		}
+	}
+	return policy, policy.validate()
+}
+
+func (p ResponsePolicy) validate() error {
+	for name, actions := range p {
+		if len(actions) == 0 {
+			return fmt.Errorf("response policy: detector %q has no actions", name)
+		}
+		for _, action := range actions {
+			if !slices.Contains(responseActions, action) {
+				return fmt.Errorf("response policy: unknown action %q for detector %q", action, name)
+			}
+		}
+	}
+	return nil
+}
+
+type responseOptions struct {
+	policy    ResponsePolicy
+	detectors []Detector
+	notify    Notifier
+}
+
+// WithResponsePolicy runs detectors on every successful validation and
+// answers their findings per policy. Findings and each action taken are
+// recorded as audit events (see WithAuditEvents). A suspend, step_up or
+// revoke action also fails the validation that triggered it. Findings of
+// detectors missing from the policy are only audited.
+func WithResponsePolicy(policy ResponsePolicy, detectors ...Detector) Option {
+	return func(c *Client) error {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+		if len(detectors) == 0 {
+			return fmt.Errorf("response policy needs at least one detector")
+		}
+		for _, d := range detectors {
+			if d == nil {
+				return fmt.Errorf("detector cannot be nil")
+			}
+		}
+		if c.responses == nil {
+			c.responses = &responseOptions{}
+		}
+		c.responses.policy = maps.Clone(policy)
+		c.responses.detectors = detectors
+		return nil
+	}
+}
+
+// WithNotifier sets the Notifier called by the notify action. It runs in the
+// background; failures are reported on the client's error channel.
+func WithNotifier(notify Notifier) Option {
+	return func(c *Client) error {
+		if notify == nil {
+			return fmt.Errorf("notifier cannot be nil")
+		}
+		if c.responses == nil {
+			c.responses = &responseOptions{}
+		}
+		c.responses.notify = notify
+		return nil
+	}
+}
+
+// inspect runs the detectors on tok and applies the policy to their
+// findings, returning the error that fails the validation, if any
+func (c *Client) inspect(ctx context.Context, raw string, tok *entity.PersonalAccessToken) error {
+	use := TokenUse{Token: tok, Time: c.config.Now()}
+	use.IP, _ = ctx.Value(clientIPKey{}).(string)
+
+	for _, d := range c.responses.detectors {
+		f, err := d.Inspect(ctx, use)
+		if err != nil {
+			c.config.Logger.Warn("detector failed", "detector", d.Name(), "error", err)
+			continue
+		}
+		if f == nil {
+			continue
+		}
+		if f.Detector == "" {
+			f.Detector = d.Name()
+		}
+		if f.Token == nil {
+			f.Token = tok
+		}
+		if err := c.respond(ctx, raw, *f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// respond audits f and takes the actions the policy lists for it
+func (c *Client) respond(ctx context.Context, raw string, f Finding) error {
+	loc, _, _ := cutToken(raw)
+	c.audit(ctx, siem.Event{
+		Type:    siem.SuspiciousUse,
+		Outcome: siem.Failure,
+		Reason:  f.Detector + ": " + f.Detail,
+		UserID:  f.Token.UserId,
+		Locator: loc,
+	})
+
+	var fail error
+	for _, action := range c.responses.policy[f.Detector] {
+		err := c.act(ctx, raw, action, f)
+		e := siem.Event{Type: siem.AutomatedResponse, Reason: action + " on " + f.Detector, UserID: f.Token.UserId, Locator: loc}
+		if err != nil {
+			c.config.Logger.Warn("automated response failed", "action", action, "detector", f.Detector, "token_id", f.Token.ID, "error", err)
+			e.Outcome = siem.Failure
+			e.Reason += ": " + err.Error()
+		}
+		c.audit(ctx, e)
+
+		if fail == nil {
+			switch action {
+			case ActionSuspend:
+				fail = ErrTokenSuspended
+			case ActionStepUp:
+				fail = ErrStepUpRequired
+			case ActionRevoke, ActionRevokeUser:
+				fail = ErrTokenRevoked
+			}
+		}
+	}
+	return fail
+}
+
+func (c *Client) act(ctx context.Context, raw, action string, f Finding) error {
+	switch action {
+	case ActionSuspend:
+		c.forget(raw)
+		return c.setHold(ctx, f.Token.ID, MetaSuspended, f.Detector)
+	case ActionStepUp:
+		c.forget(raw)
+		return c.setHold(ctx, f.Token.ID, MetaStepUp, f.Detector)
+	case ActionRevoke:
+		return c.RevokeToken(ctx, raw)
+	case ActionRevokeUser:
+		_, err := c.RevokeUserTokens(ctx, f.Token.UserId)
+		return err
+	case ActionNotify:
+		notify := c.responses.notify
+		if notify == nil {
+			return fmt.Errorf("no notifier configured")
+		}
+		c.config.Workers.Go("notify", func() error {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+			defer cancel()
+			return notify(ctx, f)
+		})
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", action)
+}
+
+// ResumeToken lifts a suspension placed by an automated response
+func (c *Client) ResumeToken(ctx context.Context, id int64) error {
+	return c.liftHold(ctx, id, MetaSuspended)
+}
+
+// CompleteStepUp lets a token awaiting step-up through again. Call it once
+// the holder has re-authenticated.
+func (c *Client) CompleteStepUp(ctx context.Context, id int64) error {
+	return c.liftHold(ctx, id, MetaStepUp)
+}
+
+func (c *Client) liftHold(ctx context.Context, id int64, key string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if id <= 0 {
+		return fmt.Errorf("token ID must be positive")
+	}
+
+	err := c.setHold(ctx, id, key, "")
+	if err == nil {
+		c.audit(ctx, siem.Event{Type: siem.TokenResumed, Reason: strings.TrimPrefix(key, "goauth.") + " lifted"})
+	}
+	return err
+}
+
+// setHold sets, or with an empty value clears, a hold on token id
+func (c *Client) setHold(ctx context.Context, id int64, key, value string) error {
+	return tracedErr(c, ctx, "SetHold", func(cfg *config.Config) error {
+		tok, err := cfg.Storage.FindByID(id)
+		if err != nil {
+			return err
+		}
+		if tok.Metadata[key] == value {
+			return nil
+		}
+
+		cp := *tok
+		cp.Metadata = make(map[string]string, len(tok.Metadata)+1)
+		maps.Copy(cp.Metadata, tok.Metadata)
+		if value == "" {
+			delete(cp.Metadata, key)
+		} else {
+			cp.Metadata[key] = value
+		}
+		return cfg.Storage.UpdateToken(&cp)
+	})
+}
+
+const (
+	earthRadiusKm = 6371
+	travelSlackKm = 300    // Geolocation error tolerated before speed counts
+	travelTracked = 100000 // Tokens remembered before the oldest are forgotten
+)
+
+// Locate resolves an IP address to coordinates, e.g. from a GeoIP database
+type Locate func(ip string) (lat, lon float64, ok bool)
+
+// ImpossibleTravel flags a token used from two places further apart than
+// could be travelled at maxKmh in the time between the uses. Uses without
+// an IP, or whose IP locate can't resolve, are ignored. Last uses are kept
+// in memory, per client instance.
+func ImpossibleTravel(locate Locate, maxKmh float64) Detector {
+	return &impossibleTravel{locate: locate, maxKmh: maxKmh, last: make(map[int64]travelPoint)}
+}
+
+type travelPoint struct {
+	lat, lon float64
+	ip       string
+	at       time.Time
+}
+
+type impossibleTravel struct {
+	locate Locate
+	maxKmh float64
+
+	mu   sync.Mutex
+	last map[int64]travelPoint
+}
+
+func (d *impossibleTravel) Name() string { return "impossible_travel" }
+
+func (d *impossibleTravel) Inspect(_ context.Context, use TokenUse) (*Finding, error) {
+	if use.IP == "" {
+		return nil, nil
+	}
+	lat, lon, ok := d.locate(use.IP)
+	if !ok {
+		return nil, nil
+	}
+	here := travelPoint{lat: lat, lon: lon, ip: use.IP, at: use.Time}
+
+	d.mu.Lock()
+	prev, seen := d.last[use.Token.ID]
+	if !seen && len(d.last) >= travelTracked {
+		clear(d.last)
+	}
+	d.last[use.Token.ID] = here
+	d.mu.Unlock()
+
+	if !seen {
+		return nil, nil
+	}
+	km := distanceKm(prev.lat, prev.lon, lat, lon) - travelSlackKm
+	if km <= 0 {
+		return nil, nil
+	}
+	hours := here.at.Sub(prev.at).Hours()
+	if hours > 0 && km/hours <= d.maxKmh {
+		return nil, nil
+	}
+	return &Finding{
+		Detail: fmt.Sprintf("used from %s then %s, %.0f km apart in %s",
+			prev.ip, use.IP, km+travelSlackKm, here.at.Sub(prev.at).Round(time.Second)),
+	}, nil
+}
+
+// distanceKm is the great-circle distance between two coordinates
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
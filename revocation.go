@@ -0,0 +1,230 @@
+package goauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// RevocationListOptions tunes the revocation list. Zero values select the
+// defaults.
+type RevocationListOptions struct {
+	// Interval is how often the change feed is polled. Default 1s.
+	Interval time.Duration
+
+	// Retain is how long a revocation is remembered. It must exceed the
+	// token cache TTL and the validation fallback's max age, the longest a
+	// revoked token can be served from a cache. Default 10m.
+	Retain time.Duration
+
+	// Redis, when set, publishes revocations made through this client and
+	// subscribes to those of other instances, so they apply without
+	// waiting for the next poll. Hard deletes and bulk revocations, which
+	// leave no trace in the change feed, reach other instances only this
+	// way.
+	Redis redis.UniversalClient
+
+	// Channel is the Redis channel. Default "goauth:revocations".
+	Channel string
+}
+
+func (o RevocationListOptions) withDefaults() RevocationListOptions {
+	if o.Interval <= 0 {
+		o.Interval = time.Second
+	}
+	if o.Retain <= 0 {
+		o.Retain = 10 * time.Minute
+	}
+	if o.Channel == "" {
+		o.Channel = "goauth:revocations"
+	}
+	return o
+}
+
+// RevocationListStats describes the revocation list of a client
+type RevocationListStats struct {
+	Version  uint64       // Increases with every change to the list
+	Tokens   int          // Revoked tokens held
+	Users    int          // Users whose tokens were all revoked
+	Cursor   ChangeCursor // Position in the change feed
+	SyncedAt time.Time    // End of the last successful poll
+}
+
+// revocationList holds the state behind WithRevocationList
+type revocationList struct {
+	opts     RevocationListOptions
+	cursor   ChangeCursor
+	syncedAt time.Time
+}
+
+// revocationMessage is published on the Redis channel
+type revocationMessage struct {
+	TokenID int64     `json:"token_id,omitempty"`
+	UserID  int64     `json:"user_id,omitempty"` // Without TokenID: all of the user's tokens
+	At      time.Time `json:"at"`
+}
+
+// WithRevocationList keeps an in-memory list of recent revocations, synced
+// from the change feed (and Redis, when configured), that validation checks
+// after a token is found. Tokens served from a token cache or the
+// validation fallback are then rejected once another instance revokes
+// them, without a storage read per request and without waiting for the
+// cache entry to expire. Changed tokens are also dropped from the token
+// cache so their new state is read on next use.
+//
+// The storage driver must have the change feed. Revocations only show up
+// in the feed under WithSoftRevocation; hard deletes need Redis.
+func WithRevocationList(opts RevocationListOptions) Option {
+	return func(c *Client) error {
+		if opts.Interval < 0 || opts.Retain < 0 {
+			return fmt.Errorf("revocation list interval and retention cannot be negative")
+		}
+		c.revocations = &revocationList{opts: opts.withDefaults()}
+		c.config.Revocations = config.NewRevocations()
+		return nil
+	}
+}
+
+// SyncRevocations polls the change feed now instead of waiting for the
+// next interval.
+func (c *Client) SyncRevocations(ctx context.Context) error {
+	if c.revocations == nil {
+		return fmt.Errorf("no revocation list configured")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	c.revocationMu.Lock()
+	defer c.revocationMu.Unlock()
+
+	r := c.revocations
+	for {
+		page, err := c.ChangesSince(ctx, r.cursor, revocationPage)
+		if err != nil {
+			return fmt.Errorf("sync revocations: %w", err)
+		}
+		for _, tok := range page.Tokens {
+			if tok.RevokedAt != nil {
+				c.config.Revocations.AddToken(tok.ID, c.config.Now())
+			}
+			if c.tokenCache != nil {
+				_ = c.tokenCache.cache.Delete(tok.Token)
+			}
+		}
+		r.cursor = page.Next
+		if len(page.Tokens) < revocationPage {
+			break
+		}
+	}
+
+	r.syncedAt = c.config.Now()
+	c.config.Revocations.Prune(r.syncedAt.Add(-r.opts.Retain))
+	return nil
+}
+
+// revocationPage is the number of changes read per query
+const revocationPage = 500
+
+// RevocationListStats reports the state of the revocation list. ok is false
+// without WithRevocationList.
+func (c *Client) RevocationListStats() (stats RevocationListStats, ok bool) {
+	if c.revocations == nil {
+		return RevocationListStats{}, false
+	}
+	c.revocationMu.Lock()
+	defer c.revocationMu.Unlock()
+
+	stats.Version = c.config.Revocations.Version()
+	stats.Tokens, stats.Users = c.config.Revocations.Len()
+	stats.Cursor = c.revocations.cursor
+	stats.SyncedAt = c.revocations.syncedAt
+	return stats, true
+}
+
+// announceRevocation adds a revocation made through this client to the
+// list and publishes it to other instances. userID alone stands for all
+// of the user's tokens.
+func (c *Client) announceRevocation(ctx context.Context, userID, tokenID int64) {
+	if c.revocations == nil {
+		return
+	}
+	msg := revocationMessage{TokenID: tokenID, UserID: userID, At: c.config.Now()}
+	c.applyRevocation(msg)
+
+	rdb := c.revocations.opts.Redis
+	if rdb == nil {
+		return
+	}
+	body, err := json.Marshal(msg)
+	if err == nil {
+		err = rdb.Publish(context.WithoutCancel(ctx), c.revocations.opts.Channel, body).Err()
+	}
+	if err != nil {
+		c.config.Logger.Warn("failed to publish revocation", "token_id", tokenID, "user_id", userID, "error", err)
+	}
+}
+
+func (c *Client) applyRevocation(msg revocationMessage) {
+	switch {
+	case msg.TokenID > 0:
+		c.config.Revocations.AddToken(msg.TokenID, c.config.Now())
+	case msg.UserID > 0:
+		c.config.Revocations.AddUser(msg.UserID, msg.At)
+	}
+}
+
+func (c *Client) startRevocationList() error {
+	if c.revocations == nil {
+		return nil
+	}
+	if !storage.CapabilitiesOf(c.storage).Changes {
+		return fmt.Errorf("revocation list: change feed: %w", utils.ErrNotSupported)
+	}
+
+	// Only revocations from now on can be in another instance's cache
+	c.revocations.cursor = ChangeCursor{Revision: c.config.Now().UnixNano()}
+	workers := c.config.Workers
+	workers.Every("revocation-list", c.revocations.opts.Interval, func() error {
+		return c.SyncRevocations(context.Background())
+	})
+
+	rdb := c.revocations.opts.Redis
+	if rdb == nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := rdb.Subscribe(ctx, c.revocations.opts.Channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		cancel()
+		return fmt.Errorf("revocation list: subscribe: %w", err)
+	}
+	workers.Go("revocation-list-subscribe", func() error {
+		defer cancel()
+		defer sub.Close()
+		messages := sub.Channel()
+		for {
+			select {
+			case <-workers.Done():
+				return nil
+			case m, ok := <-messages:
+				if !ok {
+					return nil
+				}
+				var msg revocationMessage
+				if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+					c.config.Logger.Warn("ignoring malformed revocation message", "error", err)
+					continue
+				}
+				c.applyRevocation(msg)
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,177 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// Role is a named set of abilities kept in storage
+type Role = entity.Role
+
+// ErrRoleNotFound is returned when a role does not exist
+var ErrRoleNotFound = utils.ErrRoleNotFound
+
+// rolesOptions holds the settings of WithRoles
+type rolesOptions struct {
+	refresh time.Duration
+}
+
+// WithRoles enables roles kept in storage (CreateRole, AssignRole and
+// TokenOptions.Roles). Tokens hold "role:<name>" and gain the role's
+// current abilities at validation. Roles are loaded at startup and after
+// changes made through this client; set refresh to also reload them
+// periodically, so changes made by other instances are picked up. The
+// storage driver must support roles; migrate the Role table for GORM.
+func WithRoles(refresh time.Duration) Option {
+	return func(c *Client) error {
+		if refresh < 0 {
+			return fmt.Errorf("role refresh interval cannot be negative")
+		}
+		c.roles = &rolesOptions{refresh: refresh}
+		if c.config.Roles == nil {
+			c.config.Roles = &config.Roles{}
+		}
+		return nil
+	}
+}
+
+// CreateRole creates a role granting abilities, or replaces the abilities
+// of an existing one. Tokens holding the role see the change on their next
+// validation.
+func (c *Client) CreateRole(ctx context.Context, name string, abilities []string) (*Role, error) {
+	store, err := c.roleStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" || strings.ContainsAny(name, " ,|\t\n") {
+		return nil, fmt.Errorf("invalid role name %q", name)
+	}
+	if err := auth.ValidateAbilities(abilities); err != nil {
+		return nil, err
+	}
+	for _, a := range abilities {
+		if strings.HasPrefix(strings.TrimSpace(a), config.RolePrefix) {
+			return nil, fmt.Errorf("roles cannot include other roles: %s", a)
+		}
+	}
+
+	role := &entity.Role{Name: name, Abilities: strings.Join(abilities, ",")}
+	if err := store.SaveRole(role); err != nil {
+		return nil, fmt.Errorf("failed to save role: %w", err)
+	}
+	return role, c.loadRoles()
+}
+
+// DeleteRole deletes a role. Tokens holding it lose its abilities.
+func (c *Client) DeleteRole(ctx context.Context, name string) error {
+	store, err := c.roleStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := store.DeleteRole(name); err != nil {
+		return err
+	}
+	return c.loadRoles()
+}
+
+// ListRoles returns all stored roles ordered by name
+func (c *Client) ListRoles(ctx context.Context) ([]*Role, error) {
+	store, err := c.roleStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return store.ListRoles()
+}
+
+// AssignRole gives the token raw a role. The token must be valid.
+func (c *Client) AssignRole(ctx context.Context, raw, role string) error {
+	if _, err := c.roleStore(ctx); err != nil {
+		return err
+	}
+
+	if raw == "" {
+		return errEmptyToken
+	}
+
+	defer c.InvalidateDecisions()
+	defer c.forget(raw)
+	_, err := traced(c, ctx, "AssignRole", func(cfg *config.Config) (*entity.PersonalAccessToken, error) {
+		return auth.AssignRole(raw, role, cfg)
+	})
+	return err
+}
+
+func (c *Client) roleStore(ctx context.Context) (storage.RoleStore, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if c.roles == nil {
+		return nil, fmt.Errorf("roles are not enabled (call WithRoles)")
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	store, ok := c.storage.(storage.RoleStore)
+	if !ok {
+		return nil, fmt.Errorf("roles: %w", utils.ErrNotSupported)
+	}
+	return store, nil
+}
+
+// loadRoles replaces the stored roles in the role table
+func (c *Client) loadRoles() error {
+	store, ok := c.storage.(storage.RoleStore)
+	if !ok {
+		return fmt.Errorf("roles: %w", utils.ErrNotSupported)
+	}
+	roles, err := store.ListRoles()
+	if err != nil {
+		return fmt.Errorf("failed to load roles: %w", err)
+	}
+
+	table := make(map[string][]string, len(roles))
+	for _, r := range roles {
+		var abilities []string
+		for _, a := range entity.SplitAbilities(r.Abilities) {
+			if a = strings.TrimSpace(a); a != "" {
+				abilities = append(abilities, a)
+			}
+		}
+		table[r.Name] = abilities
+	}
+	c.config.Roles.SetStored(table)
+	c.InvalidateDecisions()
+	return nil
+}
+
+// startRoles loads the roles, failing NewClient if that fails, and starts
+// the periodic reload
+func (c *Client) startRoles() error {
+	if c.roles == nil {
+		return nil
+	}
+
+	if err := c.loadRoles(); err != nil {
+		return err
+	}
+	if c.roles.refresh > 0 {
+		c.config.Workers.Every("reload-roles", c.roles.refresh, c.loadRoles)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/mohar9h/goauth/internal/utils"
+	"github.com/mohar9h/goauth/saml"
+)
+
+// Metadata keys recording the SAML identity a token was issued to
+const (
+	MetaSAMLIssuer  = "goauth.saml_iss"
+	MetaSAMLSubject = "goauth.saml_sub"
+)
+
+var (
+	// ErrInvalidAssertion is returned for SAML assertions that fail
+	// validation
+	ErrInvalidAssertion = saml.ErrInvalidAssertion
+	// ErrSAMLDenied is returned when SAMLLogin has rules and none matches
+	// the assertion
+	ErrSAMLDenied = utils.ErrSAMLDenied
+)
+
+// SAMLLogin maps the assertions of one SAML identity provider to local
+// users and abilities
+type SAMLLogin struct {
+	Verifier *saml.Verifier // Validates the assertions (required)
+	Name     string         // Provider name of identity links, e.g. "okta". Default the issuer
+
+	// UserID maps a validated assertion to the user to sign in. The
+	// default looks up the user linked to Name and the NameID with
+	// LinkIdentity, failing with ErrIdentityNotFound for unknown ones.
+	UserID func(ctx context.Context, a *saml.Assertion) (int64, error)
+
+	// Rules add abilities by attribute, e.g. from group membership. When
+	// there are rules, assertions matching none are rejected with
+	// ErrSAMLDenied.
+	Rules []SAMLRule
+
+	Token TokenOptions // Template for the issued token; UserId is filled in
+}
+
+// SAMLRule grants Abilities to assertions with a value of Attribute
+// matching Value, in which "*" matches any run of characters
+type SAMLRule struct {
+	Attribute string
+	Value     string
+	Abilities []string
+}
+
+// ExchangeSAMLAssertion validates a SAML response or assertion from an
+// enterprise identity provider (see the saml package) and issues a goauth
+// token for the user it maps to, with the abilities of Token plus those of
+// every matching rule. The issued token records the provider's entity ID
+// and the NameID in its metadata.
+func (c *Client) ExchangeSAMLAssertion(ctx context.Context, data []byte, login *SAMLLogin) (*TokenResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("SAML assertion cannot be empty")
+	}
+	if login == nil || login.Verifier == nil {
+		return nil, fmt.Errorf("SAML login requires a verifier")
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	a, err := login.Verifier.Verify(data, c.config.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	abilities := slices.Clone(login.Token.Abilities)
+	if len(login.Rules) > 0 {
+		matched := login.abilities(a)
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrSAMLDenied, a.Subject)
+		}
+		abilities = appendUnique(abilities, matched...)
+	}
+
+	var userID int64
+	if login.UserID != nil {
+		userID, err = login.UserID(ctx, a)
+	} else {
+		name := login.Name
+		if name == "" {
+			name = login.Verifier.Issuer()
+		}
+		userID, err = c.FindUserByIdentity(ctx, name, a.Subject)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if userID <= 0 {
+		return nil, fmt.Errorf("SAML user mapping returned no user for %s", a.Subject)
+	}
+
+	opts := login.Token
+	opts.UserId = userID
+	opts.Abilities = abilities
+	opts.Metadata = make(map[string]string, len(login.Token.Metadata)+2)
+	maps.Copy(opts.Metadata, login.Token.Metadata)
+	opts.Metadata[MetaSAMLIssuer] = a.Issuer
+	opts.Metadata[MetaSAMLSubject] = a.Subject
+	return c.IssueToken(ctx, &opts)
+}
+
+// abilities returns the deduplicated abilities of every rule matching a
+func (l *SAMLLogin) abilities(a *saml.Assertion) []string {
+	var out []string
+	for _, rule := range l.Rules {
+		if slices.ContainsFunc(a.Attributes[rule.Attribute], func(v string) bool { return globMatch(rule.Value, v) }) {
+			out = appendUnique(out, rule.Abilities...)
+		}
+	}
+	return out
+}
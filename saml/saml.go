@@ -0,0 +1,371 @@
+// Package saml validates SAML 2.0 assertions from an enterprise identity
+// provider (Okta, Entra ID, ADFS, ...) so goauth can sign in their users
+// (see goauth.ExchangeSAMLAssertion).
+//
+// A Verifier accepts the XML of a <samlp:Response>, as POSTed to the
+// assertion consumer service, or of a bare <saml:Assertion>. The response
+// or the assertion must carry an enveloped XML signature by one of the
+// provider's certificates; only the signed element is read afterwards, so
+// content smuggled around the signature is ignored. The assertion must
+// come from the configured issuer, name the service provider as an
+// audience, be within its NotBefore/NotOnOrAfter window, and must not
+// have been seen before. Encrypted assertions are not supported.
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// XML namespaces of SAML 2.0 assertions and protocol messages
+const (
+	AssertionNS = "urn:oasis:names:tc:SAML:2.0:assertion"
+	ProtocolNS  = "urn:oasis:names:tc:SAML:2.0:protocol"
+)
+
+const (
+	statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	bearer        = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+// ErrInvalidAssertion is returned for assertions that are malformed,
+// unsigned or badly signed, expired, replayed, or meant for another
+// service provider.
+var ErrInvalidAssertion = errors.New("invalid SAML assertion")
+
+// Config describes the identity provider and the service provider it
+// issues assertions to.
+type Config struct {
+	Issuer       string              // Identity provider entity ID (required)
+	Audience     string              // Service provider entity ID (required)
+	Certificates []*x509.Certificate // Identity provider signing certificates (required)
+
+	// Recipient, when set, must match the bearer confirmation's
+	// Recipient, typically the assertion consumer service URL
+	Recipient string
+	Leeway    time.Duration // Clock skew allowed on time conditions. Default 1m
+}
+
+// Assertion is a validated assertion.
+type Assertion struct {
+	ID           string
+	Issuer       string
+	Subject      string // NameID
+	NameIDFormat string
+	SessionIndex string
+	IssueInstant time.Time
+	NotOnOrAfter time.Time
+	Attributes   map[string][]string // Attribute values by Name
+}
+
+// Attribute returns the first value of the named attribute.
+func (a *Assertion) Attribute(name string) string {
+	if v := a.Attributes[name]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Verifier validates assertions of one identity provider. It remembers the
+// IDs of accepted assertions until they expire, so each is accepted once.
+// It is safe for concurrent use.
+type Verifier struct {
+	cfg Config
+
+	mu   sync.Mutex
+	seen map[string]time.Time // Assertion ID -> NotOnOrAfter
+}
+
+// NewVerifier returns a verifier for cfg.
+func NewVerifier(cfg Config) (*Verifier, error) {
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, fmt.Errorf("SAML verifier requires an issuer and audience")
+	}
+	if len(cfg.Certificates) == 0 {
+		return nil, fmt.Errorf("SAML verifier requires the identity provider's certificates")
+	}
+	if cfg.Leeway <= 0 {
+		cfg.Leeway = time.Minute
+	}
+	return &Verifier{cfg: cfg, seen: make(map[string]time.Time)}, nil
+}
+
+// Issuer returns the identity provider's entity ID.
+func (v *Verifier) Issuer() string {
+	return v.cfg.Issuer
+}
+
+// DecodeResponse decodes the base64 SAMLResponse form value of the
+// HTTP-POST binding.
+func DecodeResponse(form string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(form), ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad base64", ErrInvalidAssertion)
+	}
+	return b, nil
+}
+
+// Verify validates the XML of a response or assertion at time now.
+func (v *Verifier) Verify(data []byte, now time.Time) (*Assertion, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil || doc.Root() == nil {
+		return nil, invalid("malformed XML")
+	}
+	root := doc.Root()
+
+	var el *etree.Element
+	var err error
+	switch {
+	case is(root, ProtocolNS, "Response"):
+		el, err = v.responseAssertion(root, now)
+	case is(root, AssertionNS, "Assertion"):
+		el, err = v.validateSignature(root, now)
+	default:
+		err = invalid("not a SAML response or assertion")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := v.check(el, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.remember(a, now); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// responseAssertion returns the response's assertion, from the signed
+// response or signed itself
+func (v *Verifier) responseAssertion(resp *etree.Element, now time.Time) (*etree.Element, error) {
+	signed := child(resp, dsig.Namespace, "Signature") != nil
+	if signed {
+		var err error
+		if resp, err = v.validateSignature(resp, now); err != nil {
+			return nil, err
+		}
+	}
+
+	status := child(child(resp, ProtocolNS, "Status"), ProtocolNS, "StatusCode")
+	if status == nil || status.SelectAttrValue("Value", "") != statusSuccess {
+		return nil, invalid("response status is not success")
+	}
+	if children(resp, AssertionNS, "EncryptedAssertion") > 0 {
+		return nil, invalid("encrypted assertions are not supported")
+	}
+	if children(resp, AssertionNS, "Assertion") != 1 {
+		return nil, invalid("response must hold exactly one assertion")
+	}
+	assertion := child(resp, AssertionNS, "Assertion")
+	if !signed {
+		return v.validateSignature(assertion, now)
+	}
+	return assertion, nil
+}
+
+// validateSignature checks el's enveloped signature and returns the signed
+// content, the only part that may be trusted. The signing certificate must
+// be valid at now.
+func (v *Verifier) validateSignature(el *etree.Element, now time.Time) (*etree.Element, error) {
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: v.cfg.Certificates})
+	ctx.Clock = dsig.NewFakeClockAt(now)
+	signed, err := ctx.Validate(el)
+	if err != nil {
+		return nil, invalid("signature: " + err.Error())
+	}
+	return signed, nil
+}
+
+// check validates the conditions of a signed assertion
+func (v *Verifier) check(el *etree.Element, now time.Time) (*Assertion, error) {
+	a := &Assertion{ID: el.SelectAttrValue("ID", ""), Attributes: make(map[string][]string)}
+	if a.ID == "" || el.SelectAttrValue("Version", "") != "2.0" {
+		return nil, invalid("missing ID or unsupported version")
+	}
+	a.IssueInstant, _ = parseTime(el.SelectAttrValue("IssueInstant", ""))
+
+	if issuer := child(el, AssertionNS, "Issuer"); issuer == nil || strings.TrimSpace(issuer.Text()) != v.cfg.Issuer {
+		return nil, invalid("unexpected issuer")
+	}
+	a.Issuer = v.cfg.Issuer
+
+	cond := child(el, AssertionNS, "Conditions")
+	if cond == nil {
+		return nil, invalid("missing conditions")
+	}
+	notBefore, err := optionalTime(cond, "NotBefore")
+	if err != nil {
+		return nil, err
+	}
+	notOnOrAfter, err := optionalTime(cond, "NotOnOrAfter")
+	if err != nil {
+		return nil, err
+	}
+	if notOnOrAfter.IsZero() {
+		return nil, invalid("missing NotOnOrAfter")
+	}
+	if !notBefore.IsZero() && now.Add(v.cfg.Leeway).Before(notBefore) {
+		return nil, invalid("not yet valid")
+	}
+	if !now.Before(notOnOrAfter.Add(v.cfg.Leeway)) {
+		return nil, invalid("expired")
+	}
+	a.NotOnOrAfter = notOnOrAfter
+	if !v.audienceMatches(cond) {
+		return nil, invalid("audience mismatch")
+	}
+
+	subject := child(el, AssertionNS, "Subject")
+	nameID := child(subject, AssertionNS, "NameID")
+	if nameID == nil || strings.TrimSpace(nameID.Text()) == "" {
+		return nil, invalid("missing NameID")
+	}
+	a.Subject = strings.TrimSpace(nameID.Text())
+	a.NameIDFormat = nameID.SelectAttrValue("Format", "")
+	if err := v.checkConfirmation(subject, now); err != nil {
+		return nil, err
+	}
+
+	if stmt := child(el, AssertionNS, "AuthnStatement"); stmt != nil {
+		a.SessionIndex = stmt.SelectAttrValue("SessionIndex", "")
+	}
+	for _, stmt := range el.ChildElements() {
+		if !is(stmt, AssertionNS, "AttributeStatement") {
+			continue
+		}
+		for _, attr := range stmt.ChildElements() {
+			if !is(attr, AssertionNS, "Attribute") {
+				continue
+			}
+			name := attr.SelectAttrValue("Name", "")
+			for _, val := range attr.ChildElements() {
+				if is(val, AssertionNS, "AttributeValue") {
+					a.Attributes[name] = append(a.Attributes[name], strings.TrimSpace(val.Text()))
+				}
+			}
+		}
+	}
+	return a, nil
+}
+
+// audienceMatches reports whether every AudienceRestriction names the
+// service provider. At least one is required.
+func (v *Verifier) audienceMatches(cond *etree.Element) bool {
+	restricted := false
+	for _, r := range cond.ChildElements() {
+		if !is(r, AssertionNS, "AudienceRestriction") {
+			continue
+		}
+		restricted = true
+		found := false
+		for _, aud := range r.ChildElements() {
+			found = found || (is(aud, AssertionNS, "Audience") && strings.TrimSpace(aud.Text()) == v.cfg.Audience)
+		}
+		if !found {
+			return false
+		}
+	}
+	return restricted
+}
+
+// checkConfirmation requires a bearer confirmation that is current and,
+// when configured, addressed to the recipient
+func (v *Verifier) checkConfirmation(subject *etree.Element, now time.Time) error {
+	for _, sc := range subject.ChildElements() {
+		if !is(sc, AssertionNS, "SubjectConfirmation") || sc.SelectAttrValue("Method", "") != bearer {
+			continue
+		}
+		data := child(sc, AssertionNS, "SubjectConfirmationData")
+		if data == nil {
+			continue
+		}
+		until, err := optionalTime(data, "NotOnOrAfter")
+		if err != nil || until.IsZero() || !now.Before(until.Add(v.cfg.Leeway)) {
+			continue
+		}
+		if v.cfg.Recipient != "" && data.SelectAttrValue("Recipient", "") != v.cfg.Recipient {
+			continue
+		}
+		return nil
+	}
+	return invalid("no current bearer subject confirmation")
+}
+
+// remember records an accepted assertion, refusing one already seen
+func (v *Verifier) remember(a *Assertion, now time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for id, until := range v.seen {
+		if now.After(until.Add(v.cfg.Leeway)) {
+			delete(v.seen, id)
+		}
+	}
+	if _, ok := v.seen[a.ID]; ok {
+		return invalid("assertion replayed")
+	}
+	v.seen[a.ID] = a.NotOnOrAfter
+	return nil
+}
+
+func invalid(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidAssertion, reason)
+}
+
+func is(el *etree.Element, ns, tag string) bool {
+	return el != nil && el.Tag == tag && el.NamespaceURI() == ns
+}
+
+// child returns el's only child element with the given name, or nil when
+// there is none or more than one
+func child(el *etree.Element, ns, tag string) *etree.Element {
+	if el == nil {
+		return nil
+	}
+	var found *etree.Element
+	for _, c := range el.ChildElements() {
+		if is(c, ns, tag) {
+			if found != nil {
+				return nil
+			}
+			found = c
+		}
+	}
+	return found
+}
+
+func children(el *etree.Element, ns, tag string) int {
+	n := 0
+	for _, c := range el.ChildElements() {
+		if is(c, ns, tag) {
+			n++
+		}
+	}
+	return n
+}
+
+func optionalTime(el *etree.Element, attr string) (time.Time, error) {
+	s := el.SelectAttrValue(attr, "")
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := parseTime(s)
+	if err != nil {
+		return time.Time{}, invalid("bad " + attr)
+	}
+	return t, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
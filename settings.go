@@ -0,0 +1,361 @@
+package goauth
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the environment variables read by NewClientFromEnv
+const EnvPrefix = "GOAUTH_"
+
+// StorageOpener opens the storage a DSN names
+type StorageOpener func(dsn string) (StorageDriver, error)
+
+var (
+	openersMu sync.RWMutex
+	openers   = map[string]StorageOpener{
+		"memory": func(string) (StorageDriver, error) { return NewMemoryStorage(), nil },
+	}
+)
+
+// RegisterStorage makes storage_dsn values starting with "scheme:" open
+// through open. "memory" is built in; importing the sqlitestore package
+// registers "sqlite". Other databases take a few lines, e.g. for Postgres:
+//
+//	goauth.RegisterStorage("postgres", func(dsn string) (goauth.StorageDriver, error) {
+//		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+//		if err != nil {
+//			return nil, err
+//		}
+//		return goauth.NewGormStorage(db), nil
+//	})
+func RegisterStorage(scheme string, open StorageOpener) {
+	if scheme == "" || open == nil {
+		panic("goauth: RegisterStorage needs a scheme and an opener")
+	}
+	openersMu.Lock()
+	defer openersMu.Unlock()
+	openers[scheme] = open
+}
+
+// OpenStorage opens the storage named by dsn with the opener registered
+// for its scheme
+func OpenStorage(dsn string) (StorageDriver, error) {
+	scheme, _, _ := strings.Cut(dsn, ":")
+	openersMu.RLock()
+	open, ok := openers[scheme]
+	openersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no storage registered for %q (see RegisterStorage)", scheme)
+	}
+	return open(dsn)
+}
+
+// ParseLocator parses a locator name: "id", "hash:N" for
+// HashPrefixLocator(N) or "ulid"
+func ParseLocator(s string) (Locator, error) {
+	switch s {
+	case "id":
+		return IDLocator(), nil
+	case "ulid":
+		return ULIDLocator(), nil
+	}
+	if n, ok := strings.CutPrefix(s, "hash:"); ok {
+		if length, err := strconv.Atoi(n); err == nil && length > 0 {
+			return HashPrefixLocator(length), nil
+		}
+	}
+	return nil, fmt.Errorf(`locator must be "id", "hash:N" or "ulid", not %q`, s)
+}
+
+// setting turns one configuration value into an option
+type setting struct {
+	key   string
+	apply func(v string) (Option, error)
+}
+
+func durationSetting(key string, opt func(time.Duration) Option) setting {
+	return setting{key, func(v string) (Option, error) {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		return opt(d), nil
+	}}
+}
+
+func intSetting(key string, opt func(int) Option) setting {
+	return setting{key, func(v string) (Option, error) {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		return opt(n), nil
+	}}
+}
+
+// settings are the keys read by NewClientFromEnv and NewClientFromFile, in
+// the order their options apply
+var settings = []setting{
+	{"storage_dsn", func(v string) (Option, error) {
+		return func(c *Client) error {
+			driver, err := OpenStorage(v)
+			if err != nil {
+				return err
+			}
+			c.storage = driver
+			return nil
+		}, nil
+	}},
+	{"signing_key", func(v string) (Option, error) { return WithSigningKey(v), nil }},
+	{"token_prefix", func(v string) (Option, error) { return WithTokenPrefix(v), nil }},
+	intSetting("token_length", WithTokenLength),
+	durationSetting("token_ttl", WithTokenExpiration),
+	durationSetting("refresh_token_ttl", WithRefreshTokenExpiration),
+	durationSetting("device_token_ttl", WithDeviceTokenTTL),
+	durationSetting("sliding_idle", WithSlidingExpiration),
+	durationSetting("max_token_lifetime", WithMaxTokenLifetime),
+	intSetting("max_tokens_per_user", WithMaxTokensPerUser),
+	{"soft_revocation", func(v string) (Option, error) {
+		on, err := strconv.ParseBool(v)
+		if err != nil || !on {
+			return nil, err
+		}
+		return WithSoftRevocation(), nil
+	}},
+	{"locator", func(v string) (Option, error) {
+		l, err := ParseLocator(v)
+		if err != nil {
+			return nil, err
+		}
+		return WithLocator(l), nil
+	}},
+	{"guest_abilities", func(v string) (Option, error) {
+		return WithGuestAbilities(splitList(v)...), nil
+	}},
+	durationSetting("auto_prune", WithAutoPrune),
+}
+
+// settingOptions converts configuration values by key into options
+func settingOptions(values map[string]string) ([]Option, error) {
+	known := make(map[string]bool, len(settings))
+	var opts []Option
+	for _, s := range settings {
+		known[s.key] = true
+		v, ok := values[s.key]
+		if !ok {
+			continue
+		}
+		opt, err := s.apply(v)
+		if err != nil {
+			return nil, fmt.Errorf("setting %s: %w", s.key, err)
+		}
+		if opt != nil {
+			opts = append(opts, opt)
+		}
+	}
+
+	var unknown []string
+	for k := range values {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown settings: %s", strings.Join(unknown, ", "))
+	}
+	return opts, nil
+}
+
+// envSettings reads the GOAUTH_ variables that name a setting, e.g.
+// GOAUTH_TOKEN_TTL for token_ttl. Other GOAUTH_ variables are left to
+// other tools.
+func envSettings() map[string]string {
+	values := make(map[string]string)
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(EnvPrefix + strings.ToUpper(s.key)); ok {
+			values[s.key] = v
+		}
+	}
+	return values
+}
+
+// NewClientFromEnv builds a client from GOAUTH_ environment variables,
+// one per setting in upper case: GOAUTH_STORAGE_DSN, GOAUTH_SIGNING_KEY,
+// GOAUTH_TOKEN_TTL, GOAUTH_LOCATOR, ... (see the README for the full
+// list). opts apply after them, for what configuration can't express.
+func NewClientFromEnv(opts ...Option) (*Client, error) {
+	envOpts, err := settingOptions(envSettings())
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(append(envOpts, opts...)...)
+}
+
+// NewClientFromFile builds a client from a YAML (.yaml, .yml) or TOML
+// (.toml) file with the settings of NewClientFromEnv in lower case. Nested
+// keys join with "_", so storage: {dsn: ...} sets storage_dsn. GOAUTH_
+// environment variables override the file, e.g. to keep secrets out of
+// it; unknown keys in the file are errors.
+func NewClientFromFile(path string, opts ...Option) (*Client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]string
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		values, err = parseYAMLSettings(data)
+	case ".toml":
+		values, err = parseTOMLSettings(data)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for k, v := range envSettings() {
+		values[k] = v
+	}
+	fileOpts, err := settingOptions(values)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewClient(append(fileOpts, opts...)...)
+}
+
+func parseYAMLSettings(data []byte) (map[string]string, error) {
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	flattenSettings(values, "", doc)
+	return values, nil
+}
+
+func flattenSettings(values map[string]string, prefix string, doc map[string]any) {
+	for k, v := range doc {
+		key := prefix + k
+		switch v := v.(type) {
+		case map[string]any:
+			flattenSettings(values, key+"_", v)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// parseTOMLSettings reads the TOML needed for settings: [tables] and
+// key = value pairs of strings, numbers, booleans and arrays of those
+func parseTOMLSettings(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	prefix := ""
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(stripTOMLComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if table, ok := strings.CutPrefix(line, "["); ok {
+			table, ok = strings.CutSuffix(table, "]")
+			if !ok || table == "" {
+				return nil, fmt.Errorf("line %d: malformed table header", n)
+			}
+			prefix = strings.ReplaceAll(strings.TrimSpace(table), ".", "_") + "_"
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key = strings.ReplaceAll(strings.TrimSpace(key), ".", "_")
+		v, err := tomlValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		values[prefix+key] = v
+	}
+	return values, sc.Err()
+}
+
+// stripTOMLComment drops a # comment outside of quotes
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func tomlValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+	case raw[0] == '"':
+		return strconv.Unquote(raw)
+	case raw[0] == '\'':
+		if len(raw) < 2 || raw[len(raw)-1] != '\'' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return raw[1 : len(raw)-1], nil
+	case raw[0] == '[':
+		inner, ok := strings.CutSuffix(raw[1:], "]")
+		if !ok {
+			return "", fmt.Errorf("unterminated array")
+		}
+		var items []string
+		for _, item := range splitList(inner) {
+			v, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, v)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return raw, nil
+}
+
+// splitList splits a comma separated list, dropping blanks
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
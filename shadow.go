@@ -0,0 +1,111 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// Divergence describes a validation where the shadow configuration
+// disagreed with the primary one
+type Divergence struct {
+	TokenHash    string // Hash of the presented secret, never the plaintext
+	PrimaryErr   error
+	ShadowErr    error
+	PrimaryToken *entity.PersonalAccessToken
+	ShadowToken  *entity.PersonalAccessToken
+	Reason       string
+	At           time.Time
+}
+
+// ShadowRecorder receives divergences found by shadow validation
+type ShadowRecorder interface {
+	RecordDivergence(d Divergence)
+}
+
+// ShadowRecorderFunc adapts a function into a ShadowRecorder
+type ShadowRecorderFunc func(d Divergence)
+
+func (f ShadowRecorderFunc) RecordDivergence(d Divergence) { f(d) }
+
+// WithShadowValidation evaluates every validation against a second client
+// (new hasher, driver, or policy) in the background and reports outcomes
+// that differ from the primary. The primary result is always what callers
+// get. The shadow client runs its own side effects (last-used tracking,
+// sliding expiry), so point it at storage that can absorb them. A nil
+// recorder logs divergences through the primary client's logger.
+func WithShadowValidation(shadow *Client, recorder ShadowRecorder) Option {
+	return func(c *Client) error {
+		if shadow == nil {
+			return fmt.Errorf("shadow client cannot be nil")
+		}
+		if shadow == c || shadow.shadow != nil {
+			return fmt.Errorf("shadow client cannot itself use shadow validation")
+		}
+		c.shadow = &shadowValidator{client: shadow, recorder: recorder}
+		return nil
+	}
+}
+
+type shadowValidator struct {
+	client   *Client
+	recorder ShadowRecorder
+}
+
+// compare runs the shadow validation and records any divergence
+func (s *shadowValidator) compare(primary *Client, raw string, tok *entity.PersonalAccessToken, err error) {
+	primary.config.Workers.Go("shadow-validation", func() error {
+		shadowTok, shadowErr := s.client.ValidateToken(context.Background(), raw)
+
+		reason := divergence(tok, err, shadowTok, shadowErr)
+		if reason == "" {
+			return nil
+		}
+
+		d := Divergence{
+			TokenHash:    secretHash(raw),
+			PrimaryErr:   err,
+			ShadowErr:    shadowErr,
+			PrimaryToken: tok,
+			ShadowToken:  shadowTok,
+			Reason:       reason,
+			At:           primary.config.Now(),
+		}
+		if s.recorder != nil {
+			s.recorder.RecordDivergence(d)
+		} else {
+			primary.config.Logger.Warn("shadow validation diverged",
+				"reason", reason, "primary_error", err, "shadow_error", shadowErr)
+		}
+		return nil
+	})
+}
+
+// divergence explains how two validation outcomes differ, or returns ""
+// when they agree. Two failures are treated as agreement.
+func divergence(a *entity.PersonalAccessToken, errA error, b *entity.PersonalAccessToken, errB error) string {
+	switch {
+	case errA != nil && errB != nil:
+		return ""
+	case errA == nil && errB != nil:
+		return "shadow rejected a token the primary accepted"
+	case errA != nil && errB == nil:
+		return "shadow accepted a token the primary rejected"
+	case a.UserId != b.UserId:
+		return "user ID differs"
+	case a.Abilities != b.Abilities:
+		return "abilities differ"
+	default:
+		return ""
+	}
+}
+
+func secretHash(raw string) string {
+	if _, secret, ok := cutToken(raw); ok {
+		return utils.HashToken(secret)
+	}
+	return utils.HashToken(raw)
+}
@@ -0,0 +1,263 @@
+package siem
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+const vendor, product = "goauth", "goauth"
+
+// Formatter renders an event as one message in a SIEM format.
+type Formatter interface {
+	Format(e Event) ([]byte, error)
+}
+
+// FormatterFunc adapts a function into a Formatter.
+type FormatterFunc func(e Event) ([]byte, error)
+
+func (f FormatterFunc) Format(e Event) ([]byte, error) { return f(e) }
+
+// CEF formats events in ArcSight Common Event Format, e.g.
+//
+//	CEF:0|goauth|goauth|v0.4.0|token.revoked|Token revoked|3|rt=1700000000000 outcome=success suser=42 ...
+func CEF() Formatter {
+	return FormatterFunc(formatCEF)
+}
+
+var (
+	cefHeader    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtension = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+func formatCEF(e Event) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("CEF:0|")
+	for _, field := range []string{vendor, product, e.Version, e.Type, e.Description()} {
+		b.WriteString(cefHeader.Replace(field))
+		b.WriteByte('|')
+	}
+	b.WriteString(strconv.Itoa(clampSeverity(e.Severity)))
+	b.WriteByte('|')
+
+	first := true
+	ext := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if !first {
+			b.WriteByte(' ')
+		}
+		first = false
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(cefExtension.Replace(value))
+	}
+	ext("rt", strconv.FormatInt(e.Time.UnixMilli(), 10))
+	ext("outcome", e.Outcome)
+	ext("reason", e.Reason)
+	ext("suser", userID(e))
+	ext("src", e.SourceIP)
+	if e.Locator != "" {
+		ext("cs1Label", "tokenLocator")
+		ext("cs1", e.Locator)
+	}
+	if e.Count != 0 {
+		ext("cn1Label", "tokenCount")
+		ext("cn1", strconv.FormatInt(e.Count, 10))
+	}
+	return []byte(b.String()), nil
+}
+
+// LEEF formats events in IBM QRadar Log Event Extended Format 2.0 with
+// tab separated attributes, e.g.
+//
+//	LEEF:2.0|goauth|goauth|v0.4.0|token.revoked|x09|devTime=1700000000000	devTimeFormat=...
+func LEEF() Formatter {
+	return FormatterFunc(formatLEEF)
+}
+
+var (
+	leefHeader = strings.NewReplacer("|", " ", "\t", " ", "\r", " ", "\n", " ")
+	leefValue  = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+)
+
+func formatLEEF(e Event) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("LEEF:2.0|")
+	for _, field := range []string{vendor, product, e.Version, e.Type} {
+		b.WriteString(leefHeader.Replace(field))
+		b.WriteByte('|')
+	}
+	b.WriteString("x09|")
+
+	first := true
+	attr := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if !first {
+			b.WriteByte('\t')
+		}
+		first = false
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(leefValue.Replace(value))
+	}
+	attr("devTime", strconv.FormatInt(e.Time.UnixMilli(), 10))
+	attr("devTimeFormat", "milliseconds")
+	attr("cat", e.Description())
+	attr("sev", strconv.Itoa(max(clampSeverity(e.Severity), 1)))
+	attr("outcome", e.Outcome)
+	attr("reason", e.Reason)
+	attr("usrName", userID(e))
+	attr("src", e.SourceIP)
+	attr("tokenLocator", e.Locator)
+	if e.Count != 0 {
+		attr("tokenCount", strconv.FormatInt(e.Count, 10))
+	}
+	return []byte(b.String()), nil
+}
+
+// OCSFVersion is the OCSF schema version of events rendered by OCSF.
+const OCSFVersion = "1.1.0"
+
+// OCSF formats events as Open Cybersecurity Schema Framework
+// Authentication (3002) events in JSON, one object per message.
+func OCSF() Formatter {
+	return ocsfFormatter{}
+}
+
+type ocsfFormatter struct{}
+
+func (ocsfFormatter) Format(e Event) ([]byte, error) { return formatOCSF(e) }
+func (ocsfFormatter) ContentType() string            { return "application/x-ndjson" }
+
+// OCSF Authentication activities
+const (
+	ocsfLogon        = 1
+	ocsfLogoff       = 2
+	ocsfTicket       = 3 // Authentication Ticket
+	ocsfTicketRenew  = 5 // Service Ticket Renew
+	ocsfOther        = 99
+	ocsfClass        = 3002
+	ocsfCategory     = 3 // Identity & Access Management
+	ocsfStatusOK     = 1
+	ocsfStatusFailed = 2
+)
+
+type ocsfEvent struct {
+	ClassUID     int            `json:"class_uid"`
+	CategoryUID  int            `json:"category_uid"`
+	ActivityID   int            `json:"activity_id"`
+	TypeUID      int            `json:"type_uid"`
+	Time         int64          `json:"time"`
+	SeverityID   int            `json:"severity_id"`
+	StatusID     int            `json:"status_id"`
+	Status       string         `json:"status"`
+	StatusDetail string         `json:"status_detail,omitempty"`
+	Message      string         `json:"message"`
+	Metadata     ocsfMetadata   `json:"metadata"`
+	User         *ocsfUser      `json:"user,omitempty"`
+	SrcEndpoint  *ocsfEndpoint  `json:"src_endpoint,omitempty"`
+	Unmapped     map[string]any `json:"unmapped,omitempty"`
+}
+
+type ocsfMetadata struct {
+	Version string      `json:"version"`
+	Product ocsfProduct `json:"product"`
+	EventID string      `json:"event_code"`
+}
+
+type ocsfProduct struct {
+	Name       string `json:"name"`
+	VendorName string `json:"vendor_name"`
+	Version    string `json:"version"`
+}
+
+type ocsfUser struct {
+	UID string `json:"uid"`
+}
+
+type ocsfEndpoint struct {
+	IP string `json:"ip"`
+}
+
+func formatOCSF(e Event) ([]byte, error) {
+	activity := ocsfOther
+	switch e.Type {
+	case TokenCreated:
+		activity = ocsfTicket
+	case TokenRevoked, UserTokensRevoked, TokensExpired:
+		activity = ocsfLogoff
+	case TokenRotated, RefreshTokenReused:
+		activity = ocsfTicketRenew
+	case ValidationFailed, RateLimited, LoginSucceeded, LoginFailed, MFAVerified, MFAFailed:
+		activity = ocsfLogon
+	}
+
+	out := ocsfEvent{
+		ClassUID:     ocsfClass,
+		CategoryUID:  ocsfCategory,
+		ActivityID:   activity,
+		TypeUID:      ocsfClass*100 + activity,
+		Time:         e.Time.UnixMilli(),
+		SeverityID:   ocsfSeverity(e.Severity),
+		StatusID:     ocsfStatusOK,
+		Status:       "Success",
+		StatusDetail: e.Reason,
+		Message:      e.Description(),
+		Metadata: ocsfMetadata{
+			Version: OCSFVersion,
+			Product: ocsfProduct{Name: product, VendorName: vendor, Version: e.Version},
+			EventID: e.Type,
+		},
+	}
+	if e.Outcome == Failure {
+		out.StatusID, out.Status = ocsfStatusFailed, "Failure"
+	}
+	if id := userID(e); id != "" {
+		out.User = &ocsfUser{UID: id}
+	}
+	if e.SourceIP != "" {
+		out.SrcEndpoint = &ocsfEndpoint{IP: e.SourceIP}
+	}
+	if e.Locator != "" || e.Count != 0 {
+		out.Unmapped = make(map[string]any)
+		if e.Locator != "" {
+			out.Unmapped["token_locator"] = e.Locator
+		}
+		if e.Count != 0 {
+			out.Unmapped["token_count"] = e.Count
+		}
+	}
+	return json.Marshal(out)
+}
+
+// ocsfSeverity maps a 0-10 severity onto OCSF's Informational (1) to
+// Critical (5)
+func ocsfSeverity(sev int) int {
+	switch sev = clampSeverity(sev); {
+	case sev >= 9:
+		return 5
+	case sev >= 7:
+		return 4
+	case sev >= 4:
+		return 3
+	case sev >= 2:
+		return 2
+	default:
+		return 1
+	}
+}
+
+func clampSeverity(sev int) int {
+	return min(max(sev, 0), 10)
+}
+
+func userID(e Event) string {
+	if e.UserID == 0 {
+		return ""
+	}
+	return strconv.FormatInt(e.UserID, 10)
+}
@@ -0,0 +1,190 @@
+package siem
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// syslogFacility is LOG_AUTHPRIV, for security and authorization messages
+const syslogFacility = 10
+
+// NewSyslogForwarder sends each event as an RFC 5424 syslog message to
+// addr. network is "udp", "tcp" or "tls" (TCP with TLS and the system
+// roots); stream transports use octet-counting framing (RFC 6587). The
+// connection is dialled on first use and redialled after a failed write.
+func NewSyslogForwarder(network, addr string, f Formatter) *SyslogForwarder {
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "-"
+	}
+	return &SyslogForwarder{network: network, addr: addr, format: f, host: host}
+}
+
+// SyslogForwarder is the Sink returned by NewSyslogForwarder.
+type SyslogForwarder struct {
+	network, addr string
+	format        Formatter
+	host          string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (s *SyslogForwarder) Write(ctx context.Context, events []Event) error {
+	var msgs [][]byte
+	for _, e := range events {
+		msg, err := s.message(e)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, msg := range msgs {
+		if err := s.send(ctx, msg); err != nil {
+			// The server may have dropped an idle connection; retry once
+			s.close()
+			if err := s.send(ctx, msg); err != nil {
+				s.close()
+				return fmt.Errorf("siem: syslog %s: %w", s.addr, err)
+			}
+		}
+	}
+	return nil
+}
+
+// message renders e as an RFC 5424 message
+func (s *SyslogForwarder) message(e Event) ([]byte, error) {
+	body, err := s.format.Format(e)
+	if err != nil {
+		return nil, err
+	}
+	pri := syslogFacility*8 + syslogSeverity(e.Severity)
+	header := fmt.Sprintf("<%d>1 %s %s goauth %d %s - ",
+		pri, e.Time.UTC().Format(time.RFC3339Nano), s.host, os.Getpid(), e.Type)
+	return append([]byte(header), body...), nil
+}
+
+// send writes msg, dialling first if needed. Callers hold s.mu.
+func (s *SyslogForwarder) send(ctx context.Context, msg []byte) error {
+	if s.conn == nil {
+		conn, err := s.dial(ctx)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(deadline)
+	}
+	if s.network != "udp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+func (s *SyslogForwarder) dial(ctx context.Context) (net.Conn, error) {
+	if s.network == "tls" {
+		d := &tls.Dialer{}
+		return d.DialContext(ctx, "tcp", s.addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, s.network, s.addr)
+}
+
+// Close closes the connection, if any. A later Write dials again.
+func (s *SyslogForwarder) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.close()
+	return nil
+}
+
+// close drops the connection. Callers hold s.mu.
+func (s *SyslogForwarder) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// syslogSeverity maps a 0-10 event severity onto syslog's Critical (2) to
+// Informational (6)
+func syslogSeverity(sev int) int {
+	switch sev = clampSeverity(sev); {
+	case sev >= 9:
+		return 2
+	case sev >= 7:
+		return 3
+	case sev >= 4:
+		return 4
+	case sev >= 2:
+		return 5
+	default:
+		return 6
+	}
+}
+
+// NewHTTPForwarder POSTs each batch of events to url, one formatted event
+// per line, with the given extra headers, e.g. the Authorization header of
+// a Splunk HTTP Event Collector. A nil client uses http.DefaultClient.
+// Responses other than 2xx are errors.
+func NewHTTPForwarder(url string, f Formatter, header http.Header, client *http.Client) Sink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpForwarder{url: url, format: f, header: header, client: client}
+}
+
+type httpForwarder struct {
+	url    string
+	format Formatter
+	header http.Header
+	client *http.Client
+}
+
+func (h *httpForwarder) Write(ctx context.Context, events []Event) error {
+	var body bytes.Buffer
+	for _, e := range events {
+		msg, err := h.format.Format(e)
+		if err != nil {
+			return err
+		}
+		body.Write(msg)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, &body)
+	if err != nil {
+		return err
+	}
+	for k, v := range h.header {
+		req.Header[k] = v
+	}
+	contentType := "text/plain"
+	if ct, ok := h.format.(interface{ ContentType() string }); ok {
+		contentType = ct.ContentType()
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("siem: %s answered %s", h.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Package siem ships goauth security events to SIEMs such as Splunk, QRadar
+// or Sentinel. A client set up with goauth.WithAuditEvents hands its
+// events to a Sink; the forwarders here format them as CEF, LEEF or OCSF
+// and send them over syslog or HTTP:
+//
+//	sink := siem.NewSyslogForwarder("tls", "siem.internal:6514", siem.CEF())
+//	client, _ := goauth.NewClient(..., goauth.WithAuditEvents(sink))
+//
+// Events never carry secrets: tokens are identified by their public
+// locator segment only.
+package siem
+
+import (
+	"context"
+	"time"
+)
+
+// Event types. Successful validations aren't events; they are far too
+// frequent for a SIEM and are measured by metering instead.
+const (
+	TokenCreated       = "token.created"
+	TokenRevoked       = "token.revoked"
+	TokenRotated       = "token.rotated"
+	UserTokensRevoked  = "user.tokens_revoked"
+	ValidationFailed   = "token.validation_failed"
+	AbilityDenied      = "token.ability_denied"
+	RateLimited        = "token.rate_limited"
+	RefreshTokenReused = "refresh_token.reused"
+	SuspiciousUse      = "token.suspicious_use"
+	AutomatedResponse  = "token.automated_response"
+	TokenResumed       = "token.resumed"
+	TokensExpired      = "tokens.expired"
+	LoginSucceeded     = "user.login"
+	LoginFailed        = "user.login_failed"
+	MFAVerified        = "user.mfa_verified"
+	MFAFailed          = "user.mfa_failed"
+)
+
+// Outcomes of an event.
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// Event is one security relevant action seen by a goauth client.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`                // One of the event types above
+	Severity int       `json:"severity"`            // 0 (informational) to 10 (critical), as in CEF
+	Outcome  string    `json:"outcome"`             // Success or Failure
+	Reason   string    `json:"reason,omitempty"`    // Why a failure failed, or the ability denied
+	UserID   int64     `json:"user_id,omitempty"`   // User the token belongs to, when known
+	Locator  string    `json:"locator,omitempty"`   // Public segment of the token involved
+	Count    int64     `json:"count,omitempty"`     // Tokens affected by bulk actions
+	SourceIP string    `json:"source_ip,omitempty"` // From goauth.ContextWithClientIP
+	Version  string    `json:"version"`             // goauth version that emitted the event
+}
+
+// Description returns a short human readable name for the event's type.
+func (e Event) Description() string {
+	switch e.Type {
+	case TokenCreated:
+		return "Token created"
+	case TokenRevoked:
+		return "Token revoked"
+	case TokenRotated:
+		return "Token rotated"
+	case UserTokensRevoked:
+		return "All user tokens revoked"
+	case ValidationFailed:
+		return "Token validation failed"
+	case AbilityDenied:
+		return "Token lacks required ability"
+	case RateLimited:
+		return "Token validation rate limited"
+	case RefreshTokenReused:
+		return "Refresh token reuse detected"
+	case SuspiciousUse:
+		return "Suspicious token use detected"
+	case AutomatedResponse:
+		return "Automated response to suspicious use"
+	case TokenResumed:
+		return "Token hold lifted"
+	case TokensExpired:
+		return "Expired tokens pruned"
+	case LoginSucceeded:
+		return "User logged in"
+	case LoginFailed:
+		return "User login failed"
+	case MFAVerified:
+		return "Two-factor code accepted"
+	case MFAFailed:
+		return "Two-factor code rejected"
+	default:
+		return e.Type
+	}
+}
+
+// Sink receives events. Write is called from a background worker with
+// batches of events in the order they happened; an error is reported
+// through the client's Errors channel and the batch is dropped.
+type Sink interface {
+	Write(ctx context.Context, events []Event) error
+}
+
+// SinkFunc adapts a function into a Sink.
+type SinkFunc func(ctx context.Context, events []Event) error
+
+func (f SinkFunc) Write(ctx context.Context, events []Event) error { return f(ctx, events) }
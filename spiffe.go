@@ -0,0 +1,162 @@
+package goauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/jose"
+)
+
+// SPIFFEMapping trusts the SVIDs of one SPIFFE trust domain and maps
+// SPIFFE IDs to abilities
+type SPIFFEMapping struct {
+	TrustDomain string         // e.g. "prod.example.org" (required)
+	X509Roots   *x509.CertPool // Trust bundle CAs, for X.509-SVIDs
+	JWKSURL     string         // Trust bundle JWKS (e.g. a SPIRE bundle endpoint), for JWT-SVIDs
+	Audience    string         // Required aud of JWT-SVIDs
+	UserID      int64          // User the issued tokens act for (required)
+	Rules       []SPIFFERule   // Abilities of every matching rule are granted
+	TTL         time.Duration  // Lifetime of issued tokens. Default 15m
+}
+
+// SPIFFERule grants Abilities to SPIFFE IDs matching ID, in which "*"
+// matches any run of characters, e.g. "spiffe://prod.example.org/ns/payments/*"
+type SPIFFERule struct {
+	ID        string
+	Abilities []string
+}
+
+// ExchangeX509SVID verifies an X.509-SVID chain, leaf first (as in
+// http.Request.TLS.PeerCertificates behind mTLS), against mapping.X509Roots
+// and issues a short-lived token with the abilities mapped from its SPIFFE
+// ID
+func (c *Client) ExchangeX509SVID(ctx context.Context, chain []*x509.Certificate, mapping *SPIFFEMapping) (*TokenResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if err := mapping.validate(); err != nil {
+		return nil, err
+	}
+	if mapping.X509Roots == nil {
+		return nil, fmt.Errorf("SPIFFE mapping has no X.509 trust bundle")
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("%w: no certificate presented", ErrWorkloadTokenInvalid)
+	}
+
+	leaf := chain[0]
+	if leaf.IsCA {
+		return nil, fmt.Errorf("%w: SVID leaf is a CA certificate", ErrWorkloadTokenInvalid)
+	}
+	if len(leaf.URIs) != 1 {
+		return nil, fmt.Errorf("%w: SVID must carry exactly one URI SAN", ErrWorkloadTokenInvalid)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         mapping.X509Roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWorkloadTokenInvalid, err)
+	}
+
+	return c.issueSVIDToken(ctx, "ExchangeX509SVID", leaf.URIs[0].String(), mapping)
+}
+
+// ExchangeJWTSVID verifies a JWT-SVID against the keys at mapping.JWKSURL
+// and mapping.Audience and issues a short-lived token with the abilities
+// mapped from its SPIFFE ID
+func (c *Client) ExchangeJWTSVID(ctx context.Context, svid string, mapping *SPIFFEMapping) (*TokenResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if err := mapping.validate(); err != nil {
+		return nil, err
+	}
+	if mapping.JWKSURL == "" || mapping.Audience == "" {
+		return nil, fmt.Errorf("SPIFFE mapping needs a JWKS URL and audience for JWT-SVIDs")
+	}
+	if svid == "" {
+		return nil, fmt.Errorf("workload token cannot be empty")
+	}
+
+	keys := c.workloadKeySets.get(mapping.JWKSURL)
+	claims, err := jose.Verify(ctx, svid, func(ctx context.Context, h jose.Header) (crypto.PublicKey, error) {
+		return keys.Key(ctx, h.Kid)
+	})
+	if err == nil {
+		err = claims.Validate(jose.Expected{Audience: mapping.Audience, Leeway: time.Minute})
+	}
+	if err != nil {
+		if errors.Is(err, jose.ErrInvalidToken) {
+			return nil, fmt.Errorf("%w: %v", ErrWorkloadTokenInvalid, err)
+		}
+		return nil, err
+	}
+
+	return c.issueSVIDToken(ctx, "ExchangeJWTSVID", claims.String("sub"), mapping)
+}
+
+// issueSVIDToken checks that id belongs to the trust domain and issues the
+// token for its mapped abilities
+func (c *Client) issueSVIDToken(ctx context.Context, op, id string, mapping *SPIFFEMapping) (*TokenResult, error) {
+	id, err := mapping.checkID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var abilities []string
+	for _, rule := range mapping.Rules {
+		if globMatch(rule.ID, id) {
+			abilities = appendUnique(abilities, rule.Abilities...)
+		}
+	}
+	if len(abilities) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrWorkloadDenied, id)
+	}
+
+	return c.issueWorkloadToken(ctx, op, workloadGrant{
+		userID:    mapping.UserID,
+		issuer:    "spiffe://" + mapping.TrustDomain,
+		subject:   id,
+		abilities: abilities,
+		ttl:       mapping.TTL,
+	})
+}
+
+func (m *SPIFFEMapping) validate() error {
+	switch {
+	case m == nil:
+		return fmt.Errorf("SPIFFE mapping cannot be nil")
+	case m.TrustDomain == "":
+		return fmt.Errorf("SPIFFE mapping requires a trust domain")
+	case m.UserID <= 0:
+		return fmt.Errorf("user ID must be positive")
+	}
+	return nil
+}
+
+// checkID validates a SPIFFE ID and that it belongs to the trust domain
+func (m *SPIFFEMapping) checkID(id string) (string, error) {
+	u, err := url.Parse(id)
+	if err != nil || u.Scheme != "spiffe" || u.User != nil || u.Port() != "" ||
+		u.RawQuery != "" || u.Fragment != "" || u.Path == "" || u.Path == "/" {
+		return "", fmt.Errorf("%w: malformed SPIFFE ID %q", ErrWorkloadTokenInvalid, id)
+	}
+	if !strings.EqualFold(u.Host, m.TrustDomain) {
+		return "", fmt.Errorf("%w: SPIFFE ID %q outside trust domain %s", ErrWorkloadTokenInvalid, id, m.TrustDomain)
+	}
+	return "spiffe://" + strings.ToLower(u.Host) + u.Path, nil
+}
@@ -0,0 +1,44 @@
+// Package sqlitestore registers the "sqlite" storage scheme for
+// goauth.NewClientFromEnv and goauth.NewClientFromFile. Import it for its
+// side effect:
+//
+//	import _ "github.com/mohar9h/goauth/sqlitestore"
+//
+// and name the database file in the DSN:
+//
+//	GOAUTH_STORAGE_DSN=sqlite:/var/lib/goauth/tokens.db
+package sqlitestore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mohar9h/goauth"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func init() {
+	goauth.RegisterStorage("sqlite", Open)
+}
+
+// Open opens a "sqlite:path" DSN with the tuning of
+// goauth.WithSQLiteStorage and migrates the token table
+func Open(dsn string) (goauth.StorageDriver, error) {
+	path, ok := strings.CutPrefix(dsn, "sqlite:")
+	if !ok || path == "" {
+		return nil, fmt.Errorf(`sqlite DSN must be "sqlite:path", not %q`, dsn)
+	}
+
+	db, err := gorm.Open(sqlite.Open(goauth.SQLiteDSN(path, goauth.SQLiteOptions{})), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: open %s: %w", path, err)
+	}
+	if err := db.AutoMigrate(&goauth.PersonalAccessToken{}); err != nil {
+		return nil, fmt.Errorf("sqlite: migrate: %w", err)
+	}
+	return goauth.NewSQLiteStorage(db, goauth.SQLiteOptions{})
+}
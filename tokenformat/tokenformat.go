@@ -0,0 +1,242 @@
+// Package tokenformat defines the wire format of goauth personal access
+// tokens, so log scrubbers, gateways and secret scanners can recognise and
+// check tokens without importing the client or touching storage.
+//
+// The grammar, in ABNF:
+//
+//	token    = [ "Bearer " ] locator "|" secret
+//	locator  = 1*( %x21-7B / %x7D-7E )   ; printable, no "|"
+//	secret   = prefix random checksum
+//	prefix   = *( %x21-7B / %x7D-7E )    ; Format.Prefix, "" by default
+//	random   = 2n lhex                   ; n = Format.RandomBytes (32)
+//	checksum = m lhex                    ; m = Format.ChecksumLength
+//	lhex     = DIGIT / %x61-66           ; 0-9 a-f
+//
+// for example
+//
+//	42|pk_9f2c...e1d04b7a3c
+//
+// The locator is public and only says where to look the token up; the
+// secret is what the server hashes and compares. The checksum is computed
+// over random alone (see Algorithm), lowercase hex, zero-padded and cut to
+// m characters. This is format Version 1.
+//
+// Only the holder of the Format can check a token: Decode verifies a token
+// against one, while Inspect splits any token without judging it.
+package tokenformat
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Algorithm selects the checksum appended to secrets so scanners can tell
+// real tokens from lookalikes without a lookup.
+type Algorithm int
+
+const (
+	CRC32C Algorithm = iota // CRC-32 (Castagnoli), 8 hex characters (default)
+	XXHash                  // xxHash64 with seed 0, 16 hex characters
+	HMAC                    // HMAC-SHA256 keyed with Format.Key, 64 hex characters
+)
+
+// Size returns the full checksum length in hex characters.
+func (a Algorithm) Size() int {
+	switch a {
+	case XXHash:
+		return 16
+	case HMAC:
+		return 64
+	default:
+		return 8
+	}
+}
+
+func (a Algorithm) String() string {
+	switch a {
+	case CRC32C:
+		return "crc32c"
+	case XXHash:
+		return "xxhash64"
+	case HMAC:
+		return "hmac-sha256"
+	default:
+		return "Algorithm(" + strconv.Itoa(int(a)) + ")"
+	}
+}
+
+// Version is the version of the grammar above.
+const Version = 1
+
+// DefaultRandomBytes is the random part's length before hex encoding.
+const DefaultRandomBytes = 32
+
+// minTail is the shortest random and checksum goauth accepts: 16 bytes and
+// 4 checksum characters
+const minTail = 2*16 + 4
+
+const bearer = "Bearer "
+
+var (
+	ErrMalformed = errors.New("malformed token")
+	ErrChecksum  = errors.New("token checksum mismatch")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Format describes the secrets one deployment generates. The zero value is
+// goauth's default: no prefix, 32 random bytes and a full CRC-32C.
+type Format struct {
+	Prefix         string
+	RandomBytes    int // 0 = DefaultRandomBytes
+	Checksum       Algorithm
+	ChecksumLength int    // Hex characters of the checksum kept (0 = all)
+	Key            []byte // Key for HMAC
+}
+
+// Token is a token split into its parts.
+type Token struct {
+	Locator  string
+	Prefix   string
+	Random   string // Empty when returned by Inspect
+	Checksum string // Empty when returned by Inspect
+	Secret   string // Prefix, random and checksum together
+}
+
+func (f Format) randomBytes() int {
+	if f.RandomBytes > 0 {
+		return f.RandomBytes
+	}
+	return DefaultRandomBytes
+}
+
+func (f Format) checksumLength() int {
+	if n := f.ChecksumLength; n > 0 && n < f.Checksum.Size() {
+		return n
+	}
+	return f.Checksum.Size()
+}
+
+// Sum returns the checksum of a hex encoded random part.
+func (f Format) Sum(random string) string {
+	var sum string
+	switch f.Checksum {
+	case XXHash:
+		sum = fmt.Sprintf("%016x", xxhash.Sum64String(random))
+	case HMAC:
+		mac := hmac.New(sha256.New, f.Key)
+		mac.Write([]byte(random))
+		sum = hex.EncodeToString(mac.Sum(nil))
+	default:
+		sum = fmt.Sprintf("%08x", crc32.Checksum([]byte(random), castagnoli))
+	}
+	return sum[:f.checksumLength()]
+}
+
+// Secret returns the secret for the given random bytes, which should come
+// from a cryptographic source and be f.RandomBytes long.
+func (f Format) Secret(random []byte) string {
+	raw := hex.EncodeToString(random)
+	return f.Prefix + raw + f.Sum(raw)
+}
+
+// Generate returns a new secret read from crypto/rand.
+func (f Format) Generate() (string, error) {
+	return f.GenerateFrom(rand.Reader)
+}
+
+// GenerateFrom returns a new secret read from r, which must be a
+// cryptographic source outside of tests. A short read is an error.
+func (f Format) GenerateFrom(r io.Reader) (string, error) {
+	buf := make([]byte, f.randomBytes())
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return f.Secret(buf), nil
+}
+
+// Encode joins a locator and a secret into the token handed to clients.
+func Encode(locator, secret string) string {
+	return locator + "|" + secret
+}
+
+// Decode splits raw according to f and verifies its checksum. It returns
+// ErrMalformed when raw does not follow the grammar and ErrChecksum when
+// only the checksum is wrong, e.g. after a typo.
+func (f Format) Decode(raw string) (*Token, error) {
+	t, err := Inspect(raw)
+	if err != nil {
+		return nil, err
+	}
+	body, ok := strings.CutPrefix(t.Secret, f.Prefix)
+	n, m := 2*f.randomBytes(), f.checksumLength()
+	if !ok || len(body) != n+m || !isHex(body) {
+		return nil, ErrMalformed
+	}
+
+	t.Prefix, t.Random, t.Checksum = f.Prefix, body[:n], body[n:]
+	if subtle.ConstantTimeCompare([]byte(f.Sum(t.Random)), []byte(t.Checksum)) != 1 {
+		return nil, ErrChecksum
+	}
+	return t, nil
+}
+
+// Inspect splits raw into locator and secret without knowing its Format,
+// as a log scrubber would. Prefix is everything up to the last character
+// that cannot be hex, so it is exact for prefixes like "pk_" that end in
+// one and empty otherwise, or when what follows is too short to be a
+// goauth secret. Nothing is verified.
+func Inspect(raw string) (*Token, error) {
+	raw = strings.TrimPrefix(raw, bearer)
+	loc, secret, ok := strings.Cut(raw, "|")
+	if !ok || !isPrintable(loc) || !isPrintable(secret) || strings.Contains(secret, "|") {
+		return nil, ErrMalformed
+	}
+
+	t := &Token{Locator: loc, Secret: secret}
+	for i := len(secret) - 1; i >= 0; i-- {
+		if !isHexByte(secret[i]) {
+			if len(secret)-i-1 >= minTail {
+				t.Prefix = secret[:i+1]
+			}
+			break
+		}
+	}
+	return t, nil
+}
+
+func isPrintable(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isHexByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isHexByte(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f')
+}
@@ -0,0 +1,115 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/storage"
+)
+
+// TokenSet is a read-only, memory-mapped index of exported tokens
+type TokenSet = storage.TokenSet
+
+// TokenSetEntry is a single record of a TokenSet
+type TokenSetEntry = storage.TokenSetEntry
+
+// tokenSetOptions holds the export/reload schedule configured by options
+type tokenSetOptions struct {
+	exportPath  string
+	exportEvery time.Duration
+
+	file   string
+	reload time.Duration
+	driver *storage.TokenSetDriver
+}
+
+// OpenTokenSet memory-maps a token set file written by ExportTokenSet
+func OpenTokenSet(path string) (*TokenSet, error) {
+	return storage.OpenTokenSet(path)
+}
+
+// WithTokenSetExport regenerates a token set file at path every interval
+// from the client's storage, for edge validators configured with
+// WithTokenSetFile.
+func WithTokenSetExport(path string, interval time.Duration) Option {
+	return func(c *Client) error {
+		if path == "" || interval <= 0 {
+			return fmt.Errorf("token set export needs a path and a positive interval")
+		}
+		c.tokenSet.exportPath = path
+		c.tokenSet.exportEvery = interval
+		return nil
+	}
+}
+
+// WithTokenSetFile validates against a read-only token set file instead of
+// a database. The file is reopened every reload interval (0 = never) to
+// pick up regenerated exports.
+func WithTokenSetFile(path string, reload time.Duration) Option {
+	return func(c *Client) error {
+		set, err := storage.OpenTokenSet(path)
+		if err != nil {
+			return err
+		}
+		c.tokenSet.file = path
+		c.tokenSet.reload = reload
+		c.tokenSet.driver = storage.NewTokenSetDriver(set)
+		c.storage = c.tokenSet.driver
+		return nil
+	}
+}
+
+// ExportTokenSet writes every live access and guest token to w in the
+// token set format and returns the number of tokens written. Requires a
+// driver that can enumerate its tokens.
+func (c *Client) ExportTokenSet(ctx context.Context, w io.Writer) (int, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return storage.WriteTokenSet(w, c.storage, c.config.Now())
+}
+
+// ExportTokenSetFile writes the token set to path atomically, so readers
+// holding the previous file mapped are unaffected.
+func (c *Client) ExportTokenSetFile(ctx context.Context, path string) (int, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := c.ExportTokenSet(ctx, tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp.Name(), path)
+}
+
+func (c *Client) startTokenSet() {
+	ts := &c.tokenSet
+	if ts.exportEvery > 0 {
+		c.config.Workers.Every("token-set-export", ts.exportEvery, func() error {
+			_, err := c.ExportTokenSetFile(context.Background(), ts.exportPath)
+			return err
+		})
+	}
+	if ts.driver != nil && ts.reload > 0 {
+		c.config.Workers.Every("token-set-reload", ts.reload, func() error {
+			set, err := storage.OpenTokenSet(ts.file)
+			if err != nil {
+				return err
+			}
+			return ts.driver.Swap(set)
+		})
+	}
+}
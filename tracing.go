@@ -0,0 +1,81 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/mohar9h/goauth"
+
+// WithTracerProvider enables OpenTelemetry instrumentation: every client
+// operation and storage call emits a span carrying the (hashed) token ID,
+// user ID, and outcome
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *Client) error {
+		if tp == nil {
+			return fmt.Errorf("tracer provider cannot be nil")
+		}
+		c.tracer = tp.Tracer(tracerName, trace.WithInstrumentationVersion(Version()))
+		return nil
+	}
+}
+
+// instrumentStorage wraps the storage driver with the tracing decorator.
+// Called once by NewClient after all options are applied.
+func (c *Client) instrumentStorage() {
+	if c.tracer == nil {
+		return
+	}
+	c.tracedStorage = storage.NewTracingDriver(c.storage, c.tracer)
+	c.storage = c.tracedStorage
+}
+
+// traced runs fn inside a span named after the client operation. fn gets a
+// config whose storage spans are parented under that span. Without a
+// tracer, fn runs directly against the client config.
+func traced[T any](c *Client, ctx context.Context, op string, fn func(cfg *config.Config) (T, error), attrs ...attribute.KeyValue) (T, error) {
+	if c.tracer == nil {
+		return fn(c.config)
+	}
+
+	ctx, span := c.tracer.Start(ctx, "goauth."+op, trace.WithAttributes(attrs...))
+	defer span.End()
+
+	cfg := *c.config
+	cfg.Storage = c.tracedStorage.WithContext(ctx)
+
+	result, err := fn(&cfg)
+
+	if tok, ok := any(result).(*entity.PersonalAccessToken); ok && tok != nil {
+		span.SetAttributes(
+			storage.HashAttr(tok.Token),
+			attribute.Int64("goauth.token.id", tok.ID),
+			attribute.Int64("goauth.user_id", tok.UserId),
+		)
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attribute.String("goauth.outcome", "error"))
+	} else {
+		span.SetAttributes(attribute.String("goauth.outcome", "ok"))
+	}
+
+	return result, err
+}
+
+// tracedErr adapts traced for operations that only return an error
+func tracedErr(c *Client, ctx context.Context, op string, fn func(cfg *config.Config) error, attrs ...attribute.KeyValue) error {
+	_, err := traced(c, ctx, op, func(cfg *config.Config) (struct{}, error) {
+		return struct{}{}, fn(cfg)
+	}, attrs...)
+	return err
+}
@@ -0,0 +1,76 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/auth"
+)
+
+// ValidationResult is a validated token together with what a client needs
+// to renew it before it expires
+type ValidationResult struct {
+	Token        *PersonalAccessToken
+	Abilities    []string      // Granted abilities, including inherited ones under WithInheritedAbilities
+	ExpiresIn    time.Duration // Remaining lifetime; 0 for tokens that never expire
+	NearExpiry   bool          // ExpiresIn is within the near-expiry threshold (see WithNearExpiryThreshold)
+	ShouldRotate bool          // Near expiry and RotateToken would succeed, so the client should rotate now
+}
+
+// WithNearExpiryThreshold sets the remaining lifetime below which
+// ValidateTokenDetailed reports a token as near expiry. By default it is a
+// fifth of the token's lifetime, so short and long-lived tokens are both
+// flagged in time.
+func WithNearExpiryThreshold(threshold time.Duration) Option {
+	return func(c *Client) error {
+		if threshold <= 0 {
+			return fmt.Errorf("near-expiry threshold must be positive")
+		}
+		c.config.NearExpiry = threshold
+		return nil
+	}
+}
+
+// ValidateTokenDetailed is ValidateToken returning a ValidationResult, so
+// an API can tell clients to rotate their token before it expires, e.g.
+// in a response header, instead of letting requests start failing.
+func (c *Client) ValidateTokenDetailed(ctx context.Context, raw string) (*ValidationResult, error) {
+	tok, err := c.ValidateToken(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	res := &ValidationResult{Token: tok}
+	if c.inheritAbilities {
+		if res.Abilities, err = c.EffectiveAbilities(ctx, tok); err != nil {
+			return nil, err
+		}
+	} else {
+		resolved := *tok
+		resolved.Abilities = c.config.EffectiveAbilities(tok.Abilities)
+		res.Abilities = resolved.AbilityList()
+	}
+
+	if tok.ExpiresAt == nil {
+		return res, nil
+	}
+	res.ExpiresIn = max(tok.ExpiresAt.Sub(c.config.Now()), 0)
+	threshold := c.config.NearExpiry
+	if threshold == 0 {
+		threshold = tok.ExpiresAt.Sub(tok.CreatedAt) / 5
+	}
+	res.NearExpiry = res.ExpiresIn <= threshold
+	res.ShouldRotate = res.NearExpiry && rotatable(tok)
+	return res, nil
+}
+
+// rotatable reports whether a validated token should be rotated: guest
+// tokens belong to no user and are simply reissued, and a rotated token
+// already has a successor
+func rotatable(tok *PersonalAccessToken) bool {
+	return !tok.IsGuest() && tok.Metadata[auth.MetaRotatedTo] == ""
+}
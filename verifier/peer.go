@@ -0,0 +1,133 @@
+package verifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// peerTimeout bounds each push to a verification server
+const peerTimeout = 10 * time.Second
+
+// Peer is the primary's side of a verification server: a write-only
+// storage driver that forwards token creations and revocations to the
+// server's replication endpoint. Use it as the Storage of a goauth.Replica.
+// Lookups report every token as not found, so ReadFallback skips it.
+type Peer struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+var (
+	_ storage.Driver      = (*Peer)(nil)
+	_ storage.Upserter    = (*Peer)(nil)
+	_ storage.SoftRevoker = (*Peer)(nil)
+)
+
+// NewPeer returns a Peer pushing to the server at baseURL with the shared
+// secret. A nil client uses http.DefaultClient.
+func NewPeer(baseURL, secret string, client *http.Client) *Peer {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Peer{url: strings.TrimSuffix(baseURL, "/") + "/v1/replicate", secret: secret, client: client}
+}
+
+func (p *Peer) push(ev Event) error {
+	body, err := json.Marshal([]Event{ev})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), peerTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.secret)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("verifier %s answered %s", p.url, resp.Status)
+	}
+	return nil
+}
+
+func (p *Peer) Stats() storage.Stats {
+	return storage.Stats{Driver: "verifier"}
+}
+
+func (p *Peer) StoreToken(t *entity.PersonalAccessToken) error {
+	return p.push(Event{Op: OpCreate, Token: t, At: time.Now()})
+}
+
+func (p *Peer) UpsertToken(t *entity.PersonalAccessToken) error {
+	return p.push(Event{Op: OpUpsert, Token: t, At: time.Now()})
+}
+
+func (p *Peer) RevokeToken(hash string) error {
+	return p.MarkRevoked(hash, time.Now())
+}
+
+// MarkRevoked sends the revocation; the server stores a tombstone for
+// tokens it hasn't received yet.
+func (p *Peer) MarkRevoked(hash string, at time.Time) error {
+	return p.push(Event{Op: OpRevoke, Token: &entity.PersonalAccessToken{Token: hash}, At: at})
+}
+
+// RevokeFamily and MarkRevokedByUser can't tell how many tokens the server
+// revoked and report zero.
+func (p *Peer) RevokeFamily(familyID string, at time.Time) (int64, error) {
+	return 0, p.push(Event{Op: OpRevokeFamily, Family: familyID, At: at})
+}
+
+func (p *Peer) MarkRevokedByUser(userID int64, at time.Time) (int64, error) {
+	return 0, p.push(Event{Op: OpRevokeUser, UserID: userID, At: at})
+}
+
+func (p *Peer) FindByHash(string) (*entity.PersonalAccessToken, error) {
+	return nil, utils.ErrTokenNotFound
+}
+
+func (p *Peer) FindByID(int64) (*entity.PersonalAccessToken, error) {
+	return nil, utils.ErrTokenNotFound
+}
+
+func (p *Peer) FindByUser(int64, storage.ListOptions) ([]*entity.PersonalAccessToken, int64, error) {
+	return nil, 0, utils.ErrNotSupported
+}
+
+// DeleteRevoked and DeleteExpired are left to the server's own pruning.
+func (p *Peer) DeleteRevoked(time.Time) (int64, error) {
+	return 0, utils.ErrNotSupported
+}
+
+func (p *Peer) DeleteExpired(time.Time) (int64, error) {
+	return 0, utils.ErrNotSupported
+}
+
+func (p *Peer) TouchLastUsed(int64) error {
+	return utils.ErrNotSupported
+}
+
+func (p *Peer) UpdateExpiry(int64, time.Time) error {
+	return utils.ErrNotSupported
+}
+
+func (p *Peer) UpdateToken(*entity.PersonalAccessToken) error {
+	return utils.ErrNotSupported
+}
@@ -0,0 +1,328 @@
+// Package verifier runs a standalone token verification server for read
+// heavy deployments. The server answers validation queries from an
+// in-memory replica, and the primary keeps the replica hot by treating it
+// as a replication peer: token creations and revocations are pushed to it
+// as they happen, so the primary database only serves writes.
+//
+//	// Primary
+//	client, _ := goauth.NewClient(goauth.WithGormStorage(db),
+//		goauth.WithLocator(goauth.HashPrefixLocator(12)),
+//		goauth.WithReplication(goauth.ReplicationOptions{},
+//			goauth.Replica{Region: "verifier-1", Storage: verifier.NewPeer("http://verifier-1:8089", secret, nil)}))
+//
+//	// Verifier
+//	srv, _ := verifier.New(secret, goauth.WithLocator(goauth.HashPrefixLocator(12)))
+//	srv.SeedFile("/var/lib/goauth/tokens.gats")
+//	http.ListenAndServe(":8089", srv.Handler())
+//
+// The server speaks JSON over HTTP:
+//
+//   - GET or POST /v1/verify validates the bearer token, or the "token"
+//     of a JSON body, and checks the abilities listed in "ability" query
+//     parameters or the body's "abilities". It answers 200, 401 or 403
+//     with a Result, so it also works as an nginx auth_request target.
+//   - POST /v1/replicate applies a JSON array of Events sent by a Peer.
+//     It requires the shared secret as bearer token.
+//   - GET /healthz reports the replica's size and last change.
+package verifier
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mohar9h/goauth"
+	"github.com/mohar9h/goauth/internal/entity"
+	"github.com/mohar9h/goauth/internal/storage"
+	"github.com/mohar9h/goauth/internal/utils"
+)
+
+// Replication operations carried by an Event
+const (
+	OpCreate       = "create"
+	OpUpsert       = "upsert"
+	OpRevoke       = "revoke"
+	OpRevokeFamily = "revoke_family"
+	OpRevokeUser   = "revoke_user"
+)
+
+// Event is a token change replicated from the primary.
+type Event struct {
+	Op     string                      `json:"op"`
+	Token  *goauth.PersonalAccessToken `json:"token,omitempty"` // Created token, or the hash to revoke
+	Family string                      `json:"family,omitempty"`
+	UserID int64                       `json:"user_id,omitempty"`
+	At     time.Time                   `json:"at"`
+}
+
+// Result is the JSON body of a /v1/verify answer.
+type Result struct {
+	Active           bool              `json:"active"`
+	UserID           int64             `json:"user_id,omitempty"`
+	Abilities        []string          `json:"abilities,omitempty"`
+	ExpiresAt        *time.Time        `json:"expires_at,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	Error            string            `json:"error,omitempty"`
+	Description      string            `json:"error_description,omitempty"`
+	MissingAbilities []string          `json:"missing_abilities,omitempty"`
+}
+
+// Server validates tokens against an in-memory replica of the primary's
+// tokens.
+type Server struct {
+	client *goauth.Client
+	store  storage.Driver
+	secret string
+
+	lastChange atomic.Int64 // Unix nanoseconds of the last applied event
+}
+
+// New returns a server whose replication endpoint accepts secret. opts
+// configure validation and must match the primary's token settings
+// (locator, token format, ...); the storage is always the server's own
+// replica. Add goauth.WithAutoPrune to drop expired tokens from it.
+func New(secret string, opts ...goauth.Option) (*Server, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("verifier: replication secret cannot be empty")
+	}
+	store := goauth.NewMemoryStorage()
+	client, err := goauth.NewClient(append(opts, goauth.WithStorage(store))...)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{client: client, store: store, secret: secret}, nil
+}
+
+// Client returns the client validating against the replica.
+func (s *Server) Client() *goauth.Client {
+	return s.client
+}
+
+// Close stops the client's background workers.
+func (s *Server) Close() error {
+	return s.client.Close()
+}
+
+// Seed copies the live access and guest tokens of src into the replica and
+// returns how many were added, e.g. from a read-only connection to the
+// primary database at startup. Tokens replicated meanwhile are kept, so
+// seeding while the primary pushes changes cannot undo a revocation.
+func (s *Server) Seed(src goauth.StorageDriver) (int, error) {
+	sc, ok := storage.ScannerOf(src)
+	if !ok {
+		return 0, fmt.Errorf("verifier seed: %w", utils.ErrNotSupported)
+	}
+
+	now := time.Now()
+	added := 0
+	err := sc.ScanTokens(func(t *entity.PersonalAccessToken) error {
+		if t.RevokedAt != nil || t.IsRefresh() || t.IsLicense() || (t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)) {
+			return nil
+		}
+		tok := *t
+		tok.ID = 0
+		err := s.store.StoreToken(&tok)
+		if errors.Is(err, utils.ErrDuplicateToken) {
+			return nil
+		}
+		if err == nil {
+			added++
+		}
+		return err
+	})
+	return added, err
+}
+
+// SeedFile seeds the replica from a token set file written by the primary
+// with WithTokenSetExport.
+func (s *Server) SeedFile(path string) (int, error) {
+	set, err := storage.OpenTokenSet(path)
+	if err != nil {
+		return 0, err
+	}
+	d := storage.NewTokenSetDriver(set)
+	defer d.Close()
+	return s.Seed(d)
+}
+
+// Apply applies replicated events to the replica in order. Creations of
+// tokens already present are ignored, and revoking a token the replica
+// doesn't have yet stores a revoked tombstone, so revocation wins however
+// events are reordered by retries.
+func (s *Server) Apply(events []Event) error {
+	for i, ev := range events {
+		if err := s.apply(ev); err != nil {
+			return fmt.Errorf("event %d (%s): %w", i, ev.Op, err)
+		}
+		s.lastChange.Store(time.Now().UnixNano())
+	}
+	return nil
+}
+
+func (s *Server) apply(ev Event) error {
+	switch ev.Op {
+	case OpCreate, OpUpsert:
+		if ev.Token == nil {
+			return fmt.Errorf("missing token")
+		}
+		tok := *ev.Token
+		tok.ID = 0
+		var err error
+		if ev.Op == OpUpsert {
+			err = s.store.(storage.Upserter).UpsertToken(&tok)
+		} else {
+			err = s.store.StoreToken(&tok)
+		}
+		if errors.Is(err, utils.ErrDuplicateToken) {
+			return nil
+		}
+		return err
+	case OpRevoke:
+		if ev.Token == nil {
+			return fmt.Errorf("missing token")
+		}
+		return storage.RevokeWithTombstone(s.store, ev.Token, ev.At)
+	case OpRevokeFamily:
+		_, err := s.store.RevokeFamily(ev.Family, ev.At)
+		return err
+	case OpRevokeUser:
+		_, err := s.store.(storage.SoftRevoker).MarkRevokedByUser(ev.UserID, ev.At)
+		return err
+	}
+	return fmt.Errorf("unknown operation")
+}
+
+// Handler returns the server's HTTP handler.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/verify", s.handleVerify)
+	mux.HandleFunc("/v1/replicate", s.handleReplicate)
+	mux.HandleFunc("/healthz", s.handleHealth)
+	return mux
+}
+
+type verifyRequest struct {
+	Token     string   `json:"token"`
+	Abilities []string `json:"abilities"`
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	var req verifyRequest
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, &Result{Error: "invalid_request", Description: "malformed JSON body"})
+				return
+			}
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Token == "" {
+		req.Token = bearer(r)
+	}
+	req.Abilities = append(req.Abilities, r.URL.Query()["ability"]...)
+
+	status, res := s.verify(r.Context(), req)
+	writeJSON(w, status, res)
+}
+
+func (s *Server) verify(ctx context.Context, req verifyRequest) (int, *Result) {
+	if req.Token == "" {
+		return http.StatusUnauthorized, &Result{Error: "invalid_token", Description: "missing token"}
+	}
+	tok, err := s.client.ValidateToken(ctx, req.Token)
+	if err != nil {
+		if errors.Is(err, goauth.ErrTokenInvalid) {
+			return http.StatusUnauthorized, &Result{Error: "invalid_token", Description: err.Error()}
+		}
+		return http.StatusInternalServerError, &Result{Error: "server_error"}
+	}
+
+	res := &Result{
+		Active:    true,
+		UserID:    tok.UserId,
+		Abilities: entity.SplitAbilities(tok.Abilities),
+		ExpiresAt: tok.ExpiresAt,
+		Metadata:  tok.Metadata,
+	}
+	if len(req.Abilities) == 0 {
+		return http.StatusOK, res
+	}
+	missing, err := s.client.MissingAbilities(ctx, tok, req.Abilities...)
+	if err != nil {
+		return http.StatusInternalServerError, &Result{Error: "server_error"}
+	}
+	if len(missing) > 0 {
+		res.Error = "insufficient_ability"
+		res.MissingAbilities = missing
+		return http.StatusForbidden, res
+	}
+	return http.StatusOK, res
+}
+
+func (s *Server) handleReplicate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(bearer(r)), []byte(s.secret)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var events []Event
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		http.Error(w, "malformed events", http.StatusBadRequest)
+		return
+	}
+	if err := s.Apply(events); err != nil {
+		// The primary retries the batch; applied events are idempotent
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Health is the JSON body of a /healthz answer.
+type Health struct {
+	Tokens     int64      `json:"tokens"`
+	LastChange *time.Time `json:"last_change,omitempty"` // Last replicated event applied
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	var h Health
+	if st, ok := s.store.(storage.Statter); ok {
+		h.Tokens = st.Stats().Tokens
+	}
+	if ns := s.lastChange.Load(); ns != 0 {
+		at := time.Unix(0, ns).UTC()
+		h.LastChange = &at
+	}
+	writeJSON(w, http.StatusOK, &h)
+}
+
+func bearer(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
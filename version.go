@@ -0,0 +1,99 @@
+package goauth
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+
+	"github.com/mohar9h/goauth/internal/storage"
+)
+
+// version is the release this source tree corresponds to. When goauth is
+// consumed as a module dependency, the version recorded in the build info
+// takes precedence.
+const version = "v0.4.0"
+
+const modulePath = "github.com/mohar9h/goauth"
+
+// Version returns the goauth library version
+func Version() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath && dep.Version != "" && dep.Version != "(devel)" {
+				return dep.Version
+			}
+		}
+	}
+	return version
+}
+
+// Features describes what a client is running with, for support tooling
+// and admin UIs
+type Features struct {
+	Version       string
+	GoVersion     string
+	SigningMethod string
+	StorageDriver string   // Outermost storage driver
+	StorageChain  []string // Every driver in the chain, outermost first
+	Capabilities  Capabilities
+	Modules       []string // Enabled optional modules, sorted
+}
+
+// Features reports the library version, enabled modules, and active storage driver
+func (c *Client) Features() Features {
+	chain := driverChain(c.storage)
+
+	f := Features{
+		Version:       Version(),
+		GoVersion:     runtime.Version(),
+		SigningMethod: c.config.SigningMethod,
+		StorageChain:  chain,
+		Capabilities:  c.Capabilities(),
+	}
+	if len(chain) > 0 {
+		f.StorageDriver = chain[0]
+	}
+
+	cfg := c.config
+	enabled := map[string]bool{
+		"sliding_expiration": cfg.SlidingIdle > 0,
+		"auto_prune":         cfg.AutoPrune > 0,
+		"sanctum_compat":     cfg.SanctumCompat,
+		"api_version_fence":  cfg.MinAPIVersion != "",
+		"shadow_validation":  c.shadow != nil,
+		"experiments":        len(c.experiments) > 0,
+		"jwt_access_tokens":  c.jwt != nil,
+	}
+	if on, _ := c.MaintenanceMode(); on {
+		enabled["maintenance_mode"] = true
+	}
+
+	for name, on := range enabled {
+		if on {
+			f.Modules = append(f.Modules, name)
+		}
+	}
+	sort.Strings(f.Modules)
+
+	return f
+}
+
+// driverChain names each driver from the outermost decorator inward
+func driverChain(d storage.Driver) []string {
+	var names []string
+	for d != nil {
+		name := fmt.Sprintf("%T", d)
+		if s, ok := d.(storage.Statter); ok {
+			name = s.Stats().Driver
+		}
+		names = append(names, name)
+
+		w, ok := d.(storage.Wrapper)
+		if !ok {
+			break
+		}
+		d = w.Unwrap()
+	}
+	return names
+}
@@ -0,0 +1,49 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mohar9h/goauth/webhook"
+)
+
+// WithWebhooks posts token lifecycle events (created, rotated, revoked,
+// expired) to the dispatcher's endpoints. Events go through the same
+// queue as WithAuditEvents, which can be used alongside. The dispatcher
+// records them in its delivery log, and a background worker sends those
+// due every interval (default 5s), retrying failures with backoff.
+// Expiry is reported when PruneExpired or WithAutoPrune deletes tokens.
+func WithWebhooks(d *webhook.Dispatcher, interval ...time.Duration) Option {
+	return func(c *Client) error {
+		if d == nil {
+			return fmt.Errorf("webhook dispatcher cannot be nil")
+		}
+		every := 5 * time.Second
+		if len(interval) > 0 {
+			if interval[0] <= 0 {
+				return fmt.Errorf("webhook interval must be positive")
+			}
+			every = interval[0]
+		}
+		c.addAuditSink("webhook-events", d)
+		c.webhooks = &webhookOptions{dispatcher: d, interval: every}
+		return nil
+	}
+}
+
+// webhookOptions holds the dispatcher configured by WithWebhooks
+type webhookOptions struct {
+	dispatcher *webhook.Dispatcher
+	interval   time.Duration
+}
+
+func (c *Client) startWebhooks() {
+	if c.webhooks == nil {
+		return
+	}
+	c.config.Workers.Every("webhook-deliveries", c.webhooks.interval, func() error {
+		_, err := c.webhooks.dispatcher.Deliver(context.Background())
+		return err
+	})
+}
@@ -0,0 +1,212 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Delivery states.
+const (
+	Pending   = "pending"   // Waiting for its next attempt
+	Delivered = "delivered" // The endpoint answered 2xx
+	Failed    = "failed"    // Gave up after Options.MaxAttempts
+)
+
+// ErrNotFound is returned by a Store for unknown deliveries.
+var ErrNotFound = errors.New("webhook delivery not found")
+
+// Delivery is one event queued for one endpoint, and its log entry. Rows
+// stay after delivery so failures can be inspected and replayed.
+type Delivery struct {
+	ID            int64     `gorm:"primaryKey;autoIncrement"`
+	EventID       string    `gorm:"size:32;index;not null"` // Same for every endpoint receiving the event
+	EventType     string    `gorm:"size:64;not null"`
+	Endpoint      string    `gorm:"size:64;index;not null"` // Endpoint.ID
+	Payload       string    `gorm:"type:text;not null"`     // Signed JSON body
+	Status        string    `gorm:"size:16;index;not null"`
+	Attempts      int       `gorm:"not null"`
+	NextAttemptAt time.Time `gorm:"index"`
+	LastStatus    int       // HTTP status of the last attempt, 0 when it didn't get one
+	LastError     string    `gorm:"type:text"`
+	CreatedAt     time.Time `gorm:"index"`
+	DeliveredAt   *time.Time
+}
+
+// TableName keeps the delivery log apart from goauth's other tables.
+func (Delivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// Store persists the delivery log. It doubles as the dispatcher's queue,
+// so deliveries survive restarts.
+type Store interface {
+	// SaveDeliveries inserts new deliveries and sets their IDs
+	SaveDeliveries(ctx context.Context, ds []*Delivery) error
+	// ClaimDue returns up to limit pending deliveries due at now, pushing
+	// their NextAttemptAt to now+lease so other dispatchers sharing the
+	// store skip them while they are being sent
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Delivery, error)
+	// UpdateDelivery records the outcome of an attempt
+	UpdateDelivery(ctx context.Context, d *Delivery) error
+	// Deliveries lists the log, newest first. Empty filters match all.
+	Deliveries(ctx context.Context, endpoint, status string, limit int) ([]*Delivery, error)
+}
+
+// MemoryStore keeps deliveries in memory (for testing).
+type MemoryStore struct {
+	mu     sync.Mutex
+	rows   []Delivery
+	nextID int64
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) SaveDeliveries(_ context.Context, ds []*Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range ds {
+		s.nextID++
+		d.ID = s.nextID
+		s.rows = append(s.rows, *d)
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClaimDue(_ context.Context, now time.Time, lease time.Duration, limit int) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*Delivery
+	for i := range s.rows {
+		r := &s.rows[i]
+		if r.Status != Pending || r.NextAttemptAt.After(now) {
+			continue
+		}
+		if limit > 0 && len(due) == limit {
+			break
+		}
+		r.NextAttemptAt = now.Add(lease)
+		d := *r
+		due = append(due, &d)
+	}
+	return due, nil
+}
+
+func (s *MemoryStore) UpdateDelivery(_ context.Context, d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.rows {
+		if s.rows[i].ID == d.ID {
+			s.rows[i] = *d
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryStore) Deliveries(_ context.Context, endpoint, status string, limit int) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []*Delivery
+	for _, r := range slices.Backward(s.rows) {
+		if endpoint != "" && r.Endpoint != endpoint || status != "" && r.Status != status {
+			continue
+		}
+		if limit > 0 && len(out) == limit {
+			break
+		}
+		d := r
+		out = append(out, &d)
+	}
+	return out, nil
+}
+
+// GormStore keeps deliveries in the webhook_deliveries table
+// (db.AutoMigrate(&webhook.Delivery{})).
+type GormStore struct {
+	db *gorm.DB
+}
+
+var _ Store = (*GormStore)(nil)
+
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+func (s *GormStore) SaveDeliveries(ctx context.Context, ds []*Delivery) error {
+	if len(ds) == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).Create(ds).Error
+}
+
+// ClaimDue claims each row with a conditional update on its previous
+// NextAttemptAt, so a row is only handed to one of several dispatchers.
+func (s *GormStore) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Delivery, error) {
+	db := s.db.WithContext(ctx)
+	var due []*Delivery
+	q := db.Where("status = ? AND next_attempt_at <= ?", Pending, now).Order("next_attempt_at").Order("id")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if err := q.Find(&due).Error; err != nil {
+		return nil, err
+	}
+
+	claimed := due[:0]
+	until := now.Add(lease)
+	for _, d := range due {
+		res := db.Model(&Delivery{}).
+			Where("id = ? AND status = ? AND next_attempt_at = ?", d.ID, Pending, d.NextAttemptAt).
+			Update("next_attempt_at", until)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 1 {
+			d.NextAttemptAt = until
+			claimed = append(claimed, d)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *GormStore) UpdateDelivery(ctx context.Context, d *Delivery) error {
+	res := s.db.WithContext(ctx).Save(d)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *GormStore) Deliveries(ctx context.Context, endpoint, status string, limit int) ([]*Delivery, error) {
+	q := s.db.WithContext(ctx).Order("id DESC")
+	if endpoint != "" {
+		q = q.Where("endpoint = ?", endpoint)
+	}
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	var out []*Delivery
+	if err := q.Find(&out).Error; err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,326 @@
+// Package webhook notifies external systems of token lifecycle events over
+// HTTP. A Dispatcher set up with goauth.WithWebhooks records every event
+// due to each endpoint in a delivery log, then POSTs it as signed JSON,
+// retrying with exponential backoff until the endpoint answers 2xx:
+//
+//	hooks, _ := webhook.New(webhook.NewGormStore(db), webhook.Options{},
+//		webhook.Endpoint{ID: "siem", URL: "https://siem.internal/hooks/goauth", Secret: secret})
+//	client, _ := goauth.NewClient(..., goauth.WithWebhooks(hooks))
+//
+// Delivery is at least once: receivers should drop repeated event IDs.
+// Payloads never carry secrets; tokens are identified by their public
+// locator segment only.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohar9h/goauth/siem"
+)
+
+// Headers set on every request.
+const (
+	SignatureHeader = "X-Goauth-Signature" // "t=<unix seconds>,v1=<hex HMAC-SHA256>"
+	EventHeader     = "X-Goauth-Event"     // Event type
+	DeliveryHeader  = "X-Goauth-Delivery"  // Event ID, the same on every retry
+)
+
+// DefaultEvents are the lifecycle events an endpoint receives when it
+// names none.
+var DefaultEvents = []string{
+	siem.TokenCreated,
+	siem.TokenRotated,
+	siem.TokenRevoked,
+	siem.UserTokensRevoked,
+	siem.TokensExpired,
+}
+
+// Endpoint receives events.
+type Endpoint struct {
+	ID     string   // Names the endpoint in the delivery log
+	URL    string   // Receives a POST per event
+	Secret []byte   // Signs the payloads
+	Events []string // Event types to send; nil means DefaultEvents
+}
+
+// Options tunes a Dispatcher. Zero values select the defaults.
+type Options struct {
+	Client      *http.Client  // Default: a client with a 10s timeout
+	MaxAttempts int           // Attempts before a delivery fails. Default 8
+	Backoff     time.Duration // Wait after the first failed attempt, doubled for each further one. Default 10s
+	MaxBackoff  time.Duration // Longest wait between attempts. Default 1h
+	Batch       int           // Deliveries sent per Deliver call. Default 100
+	Now         func() time.Time
+}
+
+func (o Options) withDefaults() Options {
+	if o.Client == nil {
+		o.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 8
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 10 * time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = time.Hour
+	}
+	if o.Batch <= 0 {
+		o.Batch = 100
+	}
+	if o.Now == nil {
+		o.Now = time.Now
+	}
+	return o
+}
+
+// Payload is the JSON body POSTed to endpoints.
+type Payload struct {
+	ID    string     `json:"id"`   // Event ID, for deduplication
+	Type  string     `json:"type"` // Event type, as in siem
+	Time  time.Time  `json:"time"`
+	Event siem.Event `json:"event"`
+}
+
+// Dispatcher queues events for endpoints and delivers them. It is a
+// siem.Sink: Write only records deliveries, and Deliver sends those due.
+type Dispatcher struct {
+	store     Store
+	opts      Options
+	endpoints map[string]Endpoint
+}
+
+var _ siem.Sink = (*Dispatcher)(nil)
+
+// New returns a dispatcher sending to endpoints, with its delivery log in
+// store.
+func New(store Store, opts Options, endpoints ...Endpoint) (*Dispatcher, error) {
+	if store == nil {
+		return nil, fmt.Errorf("webhook store cannot be nil")
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("webhooks require at least one endpoint")
+	}
+	d := &Dispatcher{store: store, opts: opts.withDefaults(), endpoints: make(map[string]Endpoint)}
+	for _, e := range endpoints {
+		switch {
+		case e.ID == "" || e.URL == "":
+			return nil, fmt.Errorf("webhook endpoint needs an ID and URL")
+		case len(e.Secret) == 0:
+			return nil, fmt.Errorf("webhook endpoint %q needs a secret", e.ID)
+		}
+		if _, dup := d.endpoints[e.ID]; dup {
+			return nil, fmt.Errorf("duplicate webhook endpoint %q", e.ID)
+		}
+		if e.Events == nil {
+			e.Events = DefaultEvents
+		}
+		d.endpoints[e.ID] = e
+	}
+	return d, nil
+}
+
+// Write records a pending delivery of each event to every endpoint that
+// wants its type.
+func (d *Dispatcher) Write(ctx context.Context, events []siem.Event) error {
+	var ds []*Delivery
+	now := d.opts.Now()
+	for _, e := range events {
+		var id string
+		var body []byte
+		for _, ep := range d.endpoints {
+			if !slices.Contains(ep.Events, e.Type) {
+				continue
+			}
+			if id == "" {
+				var err error
+				if id, err = eventID(); err != nil {
+					return err
+				}
+				if body, err = json.Marshal(Payload{ID: id, Type: e.Type, Time: e.Time, Event: e}); err != nil {
+					return err
+				}
+			}
+			ds = append(ds, &Delivery{
+				EventID:       id,
+				EventType:     e.Type,
+				Endpoint:      ep.ID,
+				Payload:       string(body),
+				Status:        Pending,
+				NextAttemptAt: now,
+				CreatedAt:     now,
+			})
+		}
+	}
+	if len(ds) == 0 {
+		return nil
+	}
+	if err := d.store.SaveDeliveries(ctx, ds); err != nil {
+		return fmt.Errorf("webhook: record deliveries: %w", err)
+	}
+	return nil
+}
+
+func eventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Deliver sends the deliveries that are due and returns how many were
+// accepted. Failed attempts are rescheduled with backoff; the returned
+// error joins their causes.
+func (d *Dispatcher) Deliver(ctx context.Context) (int, error) {
+	now := d.opts.Now()
+	// Claims outlast an attempt, so a dispatcher that dies mid-send only
+	// delays the delivery
+	lease := 2*d.opts.Client.Timeout + time.Minute
+	due, err := d.store.ClaimDue(ctx, now, lease, d.opts.Batch)
+	if err != nil {
+		return 0, fmt.Errorf("webhook: claim deliveries: %w", err)
+	}
+
+	sent := 0
+	var errs []error
+	for _, del := range due {
+		ep, ok := d.endpoints[del.Endpoint]
+		if !ok {
+			// Endpoint removed from the configuration; another dispatcher
+			// may still know it, so leave the delivery pending
+			continue
+		}
+		status, serr := d.send(ctx, ep, del)
+		del.Attempts++
+		del.LastStatus = status
+		del.LastError = ""
+		at := d.opts.Now()
+		switch {
+		case serr == nil:
+			del.Status = Delivered
+			del.DeliveredAt = &at
+			sent++
+		case del.Attempts >= d.opts.MaxAttempts:
+			del.Status = Failed
+			del.LastError = serr.Error()
+			errs = append(errs, fmt.Errorf("webhook %s: giving up on %s: %w", ep.ID, del.EventID, serr))
+		default:
+			del.NextAttemptAt = at.Add(d.backoff(del.Attempts))
+			del.LastError = serr.Error()
+			errs = append(errs, fmt.Errorf("webhook %s: %w", ep.ID, serr))
+		}
+		if err := d.store.UpdateDelivery(ctx, del); err != nil {
+			errs = append(errs, fmt.Errorf("webhook: record delivery %d: %w", del.ID, err))
+		}
+	}
+	return sent, errors.Join(errs...)
+}
+
+// backoff is the wait after the given number of failed attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.opts.Backoff
+	for i := 1; i < attempts && wait < d.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, d.opts.MaxBackoff)
+}
+
+// send POSTs one delivery and returns the response status
+func (d *Dispatcher) send(ctx context.Context, ep Endpoint, del *Delivery) (int, error) {
+	body := []byte(del.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "goauth-webhook")
+	req.Header.Set(EventHeader, del.EventType)
+	req.Header.Set(DeliveryHeader, del.EventID)
+	req.Header.Set(SignatureHeader, Sign(ep.Secret, d.opts.Now(), body))
+
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Deliveries lists the delivery log, newest first. Empty filters match
+// all.
+func (d *Dispatcher) Deliveries(ctx context.Context, endpoint, status string, limit int) ([]*Delivery, error) {
+	return d.store.Deliveries(ctx, endpoint, status, limit)
+}
+
+// Sign returns the signature header value for body sent at t. The HMAC
+// covers the timestamp, so a captured request can't be replayed later.
+func Sign(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(signature(secret, ts, body))
+}
+
+func signature(secret []byte, ts string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// Errors returned by Verify.
+var (
+	ErrBadSignature = errors.New("webhook signature mismatch")
+	ErrStale        = errors.New("webhook signature timestamp outside tolerance")
+)
+
+// Verify checks a signature header for body, for use by receivers.
+// Signatures older or newer than tolerance relative to now are rejected.
+func Verify(secret []byte, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var ts string
+	var sigs [][]byte
+	for part := range strings.SplitSeq(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			if sig, err := hex.DecodeString(v); err == nil {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return ErrBadSignature
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return ErrStale
+	}
+	want := signature(secret, ts, body)
+	for _, sig := range sigs {
+		if hmac.Equal(sig, want) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
@@ -0,0 +1,271 @@
+package goauth
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mohar9h/goauth/config"
+	"github.com/mohar9h/goauth/internal/auth"
+	"github.com/mohar9h/goauth/internal/jose"
+	"github.com/mohar9h/goauth/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// GitHubActionsIssuer is the OIDC issuer of GitHub Actions workflow tokens
+const GitHubActionsIssuer = "https://token.actions.githubusercontent.com"
+
+// Metadata keys recording the workload a token was issued to
+const (
+	MetaWorkloadIssuer  = "goauth.workload_iss"
+	MetaWorkloadSubject = "goauth.workload_sub"
+)
+
+var (
+	// ErrWorkloadTokenInvalid is returned for OIDC tokens that fail
+	// signature, issuer, audience or expiry checks
+	ErrWorkloadTokenInvalid = utils.ErrWorkloadTokenInvalid
+	// ErrWorkloadDenied is returned when no WorkloadRule matches the token
+	ErrWorkloadDenied = utils.ErrWorkloadDenied
+)
+
+// WorkloadMapping trusts one OIDC issuer of workload tokens (GitHub
+// Actions, a Kubernetes cluster's service account issuer, an EKS cluster
+// for IRSA) and maps their claims to abilities
+type WorkloadMapping struct {
+	Issuer   string         // Expected iss claim (required)
+	Audience string         // Expected aud claim (required), e.g. your API's URL
+	JWKSURL  string         // Signing keys; discovered from the issuer when empty
+	UserID   int64          // User the issued tokens act for (required)
+	Rules    []WorkloadRule // Abilities of every matching rule are granted
+	TTL      time.Duration  // Lifetime of issued tokens. Default 15m
+}
+
+// WorkloadRule grants Abilities to workload tokens whose claims match every
+// entry of Claims. Keys are claim names, with dots reaching into nested
+// claims ("kubernetes.io.namespace"). Values are exact strings in which
+// "*" matches any run of characters, so "repo:acme/*:ref:refs/heads/main"
+// matches the main branch of any acme repository.
+type WorkloadRule struct {
+	Claims    map[string]string
+	Abilities []string
+}
+
+// ExchangeWorkloadIdentity verifies an OIDC token presented by a workload
+// and issues a short-lived goauth token for mapping.UserID with the
+// abilities of every matching rule, so CI jobs and pods need no long-lived
+// secrets. The token's signature is checked against the issuer's JWKS
+// (cached per URL), and its iss, aud, exp and nbf claims against mapping.
+// Tokens matching no rule are rejected with ErrWorkloadDenied.
+func (c *Client) ExchangeWorkloadIdentity(ctx context.Context, oidcToken string, mapping *WorkloadMapping) (*TokenResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if oidcToken == "" {
+		return nil, fmt.Errorf("workload token cannot be empty")
+	}
+
+	if err := mapping.validate(); err != nil {
+		return nil, err
+	}
+
+	claims, err := jose.Verify(ctx, oidcToken, func(ctx context.Context, h jose.Header) (crypto.PublicKey, error) {
+		keys, err := c.workloadKeys(ctx, mapping)
+		if err != nil {
+			return nil, err
+		}
+		return keys.Key(ctx, h.Kid)
+	})
+	if err == nil {
+		err = claims.Validate(jose.Expected{Issuer: mapping.Issuer, Audience: mapping.Audience, Leeway: time.Minute})
+	}
+	if err != nil {
+		if errors.Is(err, jose.ErrInvalidToken) {
+			return nil, fmt.Errorf("%w: %v", ErrWorkloadTokenInvalid, err)
+		}
+		return nil, err
+	}
+
+	abilities := mapping.abilities(claims)
+	if len(abilities) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrWorkloadDenied, claims.String("sub"))
+	}
+
+	return c.issueWorkloadToken(ctx, "ExchangeWorkloadIdentity", workloadGrant{
+		userID:    mapping.UserID,
+		issuer:    mapping.Issuer,
+		subject:   claims.String("sub"),
+		abilities: abilities,
+		ttl:       mapping.TTL,
+	})
+}
+
+// workloadGrant describes a token issued to a verified workload
+type workloadGrant struct {
+	userID    int64
+	issuer    string
+	subject   string
+	abilities []string
+	ttl       time.Duration
+}
+
+// issueWorkloadToken issues the short-lived token for a verified workload,
+// named after and tagged with its identity
+func (c *Client) issueWorkloadToken(ctx context.Context, op string, g workloadGrant) (*TokenResult, error) {
+	if g.ttl <= 0 {
+		g.ttl = 15 * time.Minute
+	}
+	name := "workload:" + g.subject
+	opts := &TokenOptions{
+		UserId:    g.userID,
+		Name:      &name,
+		Abilities: g.abilities,
+		ExpiresIn: g.ttl,
+		Metadata: map[string]string{
+			MetaWorkloadIssuer:  g.issuer,
+			MetaWorkloadSubject: g.subject,
+		},
+	}
+
+	return traced(c, ctx, op, func(cfg *config.Config) (*TokenResult, error) {
+		return auth.Issue(c.authOptions(opts, cfg))
+	}, attribute.String("goauth.workload.issuer", g.issuer))
+}
+
+func (m *WorkloadMapping) validate() error {
+	switch {
+	case m == nil:
+		return fmt.Errorf("workload mapping cannot be nil")
+	case m.Issuer == "" || m.Audience == "":
+		return fmt.Errorf("workload mapping requires an issuer and audience")
+	case m.UserID <= 0:
+		return fmt.Errorf("user ID must be positive")
+	}
+	return nil
+}
+
+// abilities returns the deduplicated abilities of every rule matching claims
+func (m *WorkloadMapping) abilities(claims jose.Claims) []string {
+	var out []string
+	for _, rule := range m.Rules {
+		if rule.matches(claims) {
+			out = appendUnique(out, rule.Abilities...)
+		}
+	}
+	return out
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		if !slices.Contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func (r WorkloadRule) matches(claims jose.Claims) bool {
+	if len(r.Claims) == 0 {
+		return false
+	}
+	for name, pattern := range r.Claims {
+		v, ok := claims.Lookup(name)
+		if !ok {
+			return false
+		}
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case map[string]any, []any:
+			return false
+		default:
+			s = fmt.Sprint(v)
+		}
+		if !globMatch(pattern, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// globMatch reports whether s matches pattern, where "*" matches any run of
+// characters, including none
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, mid := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, mid)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(mid):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// workloadKeySets caches one key set per JWKS URL and the discovered JWKS
+// URL of each issuer
+type workloadKeySets struct {
+	mu     sync.Mutex
+	jwks   map[string]string
+	keySet map[string]*jose.KeySet
+}
+
+func (c *Client) workloadKeys(ctx context.Context, m *WorkloadMapping) (*jose.KeySet, error) {
+	ks := &c.workloadKeySets
+	if m.JWKSURL != "" {
+		return ks.get(m.JWKSURL), nil
+	}
+
+	ks.mu.Lock()
+	url := ks.jwks[m.Issuer]
+	ks.mu.Unlock()
+	if url != "" {
+		return ks.get(url), nil
+	}
+
+	url, err := jose.Discover(ctx, nil, m.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(url, "https://") && strings.HasPrefix(m.Issuer, "https://") {
+		return nil, fmt.Errorf("issuer %s advertises insecure jwks_uri", m.Issuer)
+	}
+
+	ks.mu.Lock()
+	if ks.jwks == nil {
+		ks.jwks = make(map[string]string)
+	}
+	ks.jwks[m.Issuer] = url
+	ks.mu.Unlock()
+	return ks.get(url), nil
+}
+
+// get returns the shared key set for url
+func (ks *workloadKeySets) get(url string) *jose.KeySet {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.keySet == nil {
+		ks.keySet = make(map[string]*jose.KeySet)
+	}
+	set, ok := ks.keySet[url]
+	if !ok {
+		set = &jose.KeySet{URL: url}
+		ks.keySet[url] = set
+	}
+	return set
+}